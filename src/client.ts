@@ -0,0 +1,135 @@
+/**
+ * Discovery API client for Workers.
+ *
+ * Calls the DiscoveryService Connect JSON API, either over the network or
+ * through a service binding. Request and response shapes are taken from
+ * the handlers so the client cannot drift from the server.
+ */
+
+import type { handleRegisterColony, handleRegisterAgent } from "./handlers/register";
+import type { handleLookupColony, handleLookupAgent } from "./handlers/lookup";
+import type { handleHealth } from "./handlers/health";
+import type { handleCreateBootstrapToken } from "./handlers/bootstrap";
+
+const SERVICE_PATH = "/coral.discovery.v1.DiscoveryService/";
+
+/**
+ * ProtoJSON wire form of a handler type: int64 values travel as strings
+ * and bytes as base64 strings.
+ */
+export type Wire<T> = T extends bigint
+  ? string
+  : T extends Uint8Array
+    ? string
+    : T extends Array<infer U>
+      ? Wire<U>[]
+      : T extends object
+        ? { [K in keyof T]: Wire<T[K]> }
+        : T;
+
+type Handler = (...args: never[]) => Promise<unknown>;
+type RequestOf<H extends Handler> = Wire<Parameters<H>[1]>;
+type ResponseOf<H extends Handler> = Wire<Awaited<ReturnType<H>>>;
+
+export type RegisterColonyRequest = RequestOf<typeof handleRegisterColony>;
+export type RegisterColonyResponse = ResponseOf<typeof handleRegisterColony>;
+export type LookupColonyRequest = RequestOf<typeof handleLookupColony>;
+export type LookupColonyResponse = ResponseOf<typeof handleLookupColony>;
+export type RegisterAgentRequest = RequestOf<typeof handleRegisterAgent>;
+export type RegisterAgentResponse = ResponseOf<typeof handleRegisterAgent>;
+export type LookupAgentRequest = RequestOf<typeof handleLookupAgent>;
+export type LookupAgentResponse = ResponseOf<typeof handleLookupAgent>;
+export type HealthResponse = ResponseOf<typeof handleHealth>;
+export type CreateBootstrapTokenRequest = RequestOf<typeof handleCreateBootstrapToken>;
+export type CreateBootstrapTokenResponse = ResponseOf<typeof handleCreateBootstrapToken>;
+
+/**
+ * Error returned by the discovery service.
+ */
+export class DiscoveryError extends Error {
+  constructor(
+    message: string,
+    public readonly code: string, // Connect code name, e.g. "not_found".
+    public readonly status: number
+  ) {
+    super(message);
+    this.name = "DiscoveryError";
+  }
+}
+
+/**
+ * Options for DiscoveryClient.
+ */
+export interface DiscoveryClientOptions {
+  // Base URL of the service, e.g. "https://discovery.coral.io".
+  baseUrl: string;
+  // Service binding or other fetcher; defaults to the global fetch.
+  fetcher?: Pick<Fetcher, "fetch">;
+  // Extra headers sent with every request.
+  headers?: Record<string, string>;
+}
+
+/**
+ * Typed client for DiscoveryService.
+ */
+export class DiscoveryClient {
+  private readonly baseUrl: string;
+  private readonly fetcher: Pick<Fetcher, "fetch">;
+  private readonly headers: Record<string, string>;
+
+  constructor(options: DiscoveryClientOptions) {
+    this.baseUrl = options.baseUrl.replace(/\/+$/, "");
+    this.fetcher = options.fetcher ?? { fetch: (input, init) => fetch(input, init) };
+    this.headers = options.headers ?? {};
+  }
+
+  registerColony(request: RegisterColonyRequest): Promise<RegisterColonyResponse> {
+    return this.call("RegisterColony", request);
+  }
+
+  lookupColony(request: LookupColonyRequest): Promise<LookupColonyResponse> {
+    return this.call("LookupColony", request);
+  }
+
+  registerAgent(request: RegisterAgentRequest): Promise<RegisterAgentResponse> {
+    return this.call("RegisterAgent", request);
+  }
+
+  lookupAgent(request: LookupAgentRequest): Promise<LookupAgentResponse> {
+    return this.call("LookupAgent", request);
+  }
+
+  health(): Promise<HealthResponse> {
+    return this.call("Health", {});
+  }
+
+  createBootstrapToken(request: CreateBootstrapTokenRequest): Promise<CreateBootstrapTokenResponse> {
+    return this.call("CreateBootstrapToken", request);
+  }
+
+  /**
+   * Invoke a unary RPC using the Connect JSON encoding.
+   */
+  private async call<T>(rpc: string, request: unknown): Promise<T> {
+    const response = await this.fetcher.fetch(this.baseUrl + SERVICE_PATH + rpc, {
+      method: "POST",
+      headers: { ...this.headers, "Content-Type": "application/json" },
+      body: JSON.stringify(request),
+    });
+
+    if (!response.ok) {
+      let code = "unknown";
+      let message = response.statusText;
+      try {
+        const body = await response.json() as { code?: string; message?: string };
+        code = body.code ?? code;
+        message = body.message ?? message;
+      } catch {
+        // Non-JSON error body; keep the status text.
+      }
+      throw new DiscoveryError(message, code, response.status);
+    }
+
+    return await response.json() as T;
+  }
+}
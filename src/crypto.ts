@@ -17,6 +17,7 @@ import { loadCryptoModule, isCryptoModuleAvailable } from "./wasm-loader";
  */
 export interface SigningKey {
   id: string;
+  privateKeyB64: string; // Base64 private key (unsealed), for Wasm signing.
   privateKey: CryptoKey;
   publicKey: CryptoKey;
   publicKeyBytes: Uint8Array;
@@ -62,6 +63,13 @@ let cachedKeySource: string | null = null;
  *   "privateKey": "base64-encoded-64-byte-ed25519-private-key"
  * }
  *
+ * or, to keep the raw key out of the secret, a key sealed with
+ * `coralctl seal` and opened with the DISCOVERY_KEY_PASSPHRASE secret:
+ * {
+ *   "id": "key-id-ulid",
+ *   "sealedPrivateKey": "..."
+ * }
+ *
  * Optionally, DISCOVERY_PREVIOUS_KEYS can be a JSON array of previous keys
  * for key rotation support.
  */
@@ -106,9 +114,26 @@ export async function ensureKeysLoaded(env: Env): Promise<SigningKeys> {
   try {
     const keyData = JSON.parse(env.DISCOVERY_SIGNING_KEY) as {
       id: string;
-      privateKey: string;
+      privateKey?: string;
+      sealedPrivateKey?: string;
     };
 
+    // Sealed keys are opened by the Wasm module (Argon2id +
+    // XChaCha20-Poly1305).
+    if (keyData.sealedPrivateKey) {
+      if (!env.DISCOVERY_KEY_PASSPHRASE) {
+        console.error("Signing key is sealed but DISCOVERY_KEY_PASSPHRASE is not set");
+        return keys;
+      }
+      const wasm = await loadCryptoModule();
+      const unsealed = await wasm.unsealPrivateKey(keyData.sealedPrivateKey, env.DISCOVERY_KEY_PASSPHRASE);
+      keyData.privateKey = unsealed.privateKey;
+    }
+    if (!keyData.privateKey) {
+      console.error("Signing key has neither privateKey nor sealedPrivateKey");
+      return keys;
+    }
+
     const privateKeyBytes = base64ToBytes(keyData.privateKey);
 
     // Ed25519 private key is 64 bytes (32 byte seed + 32 byte public key).
@@ -166,6 +191,7 @@ export async function ensureKeysLoaded(env: Env): Promise<SigningKeys> {
 
       keys.currentKey = {
         id: keyData.id,
+        privateKeyB64: keyData.privateKey,
         privateKey,
         publicKey,
         publicKeyBytes,
@@ -202,7 +228,7 @@ export async function createJWT(
   if (config.useWasmCrypto) {
     try {
       const wasm = await loadCryptoModule();
-      const result = wasm.createReferralTicket(
+      const result = await wasm.createReferralTicket(
         privateKeyB64,
         key.id,
         reefId,
@@ -212,10 +238,6 @@ export async function createJWT(
         ttlSeconds
       );
 
-      if (result.error) {
-        throw new Error(`Wasm JWT error: ${result.error}`);
-      }
-
       return {
         token: result.jwt!,
         expiresAt: result.expiresAt!,
@@ -267,6 +289,69 @@ export async function createJWT(
   return { token, expiresAt };
 }
 
+/**
+ * Claims of a verified referral ticket.
+ */
+export interface TicketClaims {
+  jti: string;
+  exp: number;
+  reef_id: string;
+  colony_id: string;
+  agent_id: string;
+  intent: string;
+}
+
+/**
+ * Verify a referral ticket against the current and previous signing keys.
+ * Returns null unless the signature, issuer, audience and expiry all check
+ * out.
+ */
+export async function verifyJWT(env: Env, token: string): Promise<TicketClaims | null> {
+  const parts = token.split(".");
+  if (parts.length !== 3) {
+    return null;
+  }
+
+  let header: { alg?: string; kid?: string };
+  let claims: TicketClaims & { iss?: string; aud?: string | string[] };
+  try {
+    header = JSON.parse(new TextDecoder().decode(base64ToBytes(parts[0])));
+    claims = JSON.parse(new TextDecoder().decode(base64ToBytes(parts[1])));
+  } catch {
+    return null;
+  }
+  if (header.alg !== "EdDSA") {
+    return null;
+  }
+
+  const keys = await ensureKeysLoaded(env);
+  const candidates = [keys.currentKey, ...keys.previousKeys].filter(
+    (key): key is SigningKey => key !== null && (!header.kid || key.id === header.kid)
+  );
+  const message = new TextEncoder().encode(`${parts[0]}.${parts[1]}`);
+  const signature = base64ToBytes(parts[2]);
+
+  let valid = false;
+  for (const key of candidates) {
+    if (await crypto.subtle.verify({ name: "Ed25519" }, key.publicKey, signature, message)) {
+      valid = true;
+      break;
+    }
+  }
+  if (!valid) {
+    return null;
+  }
+
+  const audience = Array.isArray(claims.aud) ? claims.aud : [claims.aud];
+  if (claims.iss !== "coral-discovery" || !audience.includes("coral-colony")) {
+    return null;
+  }
+  if (typeof claims.exp !== "number" || claims.exp <= Math.floor(Date.now() / 1000)) {
+    return null;
+  }
+  return claims;
+}
+
 /**
  * Get JWKS for the current and previous signing keys.
  */
@@ -72,9 +72,6 @@ export async function handleCreateBootstrapToken(
   }
 
   // Create JWT with 60 second TTL.
-  // We need to parse the JSON key again to get the base64 private key for Wasm.
-  const keyData = JSON.parse(signingKey) as { id: string; privateKey: string };
-  
   const ttlSeconds = 60;
   const result = await createJWT(
     env,
@@ -84,7 +81,7 @@ export async function handleCreateBootstrapToken(
     request.agentId,
     request.intent,
     ttlSeconds,
-    keyData.privateKey
+    keys.currentKey.privateKeyB64
   );
 
   return {
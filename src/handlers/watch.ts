@@ -0,0 +1,65 @@
+import type { Env } from "../types";
+import { verifyJWT } from "../crypto";
+import type { Logger } from "../logger";
+
+/**
+ * WebSocket subprotocol of /v1/ws. Browsers cannot set headers on
+ * WebSocket requests, so they offer the ticket as a second subprotocol,
+ * "bearer.<ticket>", next to this one.
+ */
+export const WATCH_PROTOCOL = "coral.watch.v1";
+
+const BEARER_PROTOCOL_PREFIX = "bearer.";
+
+/**
+ * Handle GET /v1/ws: upgrade to a WebSocket that streams registry updates
+ * of a mesh. The referral ticket is checked before the upgrade and must
+ * name the mesh as its colony; the Durable Object closes the socket when
+ * the ticket expires.
+ */
+export async function handleWatchSocket(request: Request, env: Env, log?: Logger): Promise<Response> {
+  if (request.headers.get("Upgrade")?.toLowerCase() !== "websocket") {
+    return new Response("Expected WebSocket upgrade", { status: 426 });
+  }
+
+  const token = bearerToken(request);
+  if (!token) {
+    return new Response("Missing referral ticket", { status: 401 });
+  }
+  const claims = await verifyJWT(env, token);
+  if (!claims) {
+    return new Response("Invalid referral ticket", { status: 401 });
+  }
+
+  const meshId = new URL(request.url).searchParams.get("mesh_id") || claims.colony_id;
+  if (meshId !== claims.colony_id) {
+    return new Response("Ticket is not valid for this mesh", { status: 403 });
+  }
+
+  log?.info(`[Handler] Watch: meshId=${meshId}, agentId=${claims.agent_id}`);
+
+  // Hand the upgrade to the Durable Object that owns the mesh so it can
+  // broadcast its own writes.
+  const registry = env.COLONY_REGISTRY.get(env.COLONY_REGISTRY.idFromName(meshId));
+  const headers = new Headers(request.headers);
+  headers.set("X-Ticket-Expires", String(claims.exp));
+  return registry.fetch(new Request("http://internal/watch", { headers }));
+}
+
+/**
+ * Extract the ticket from the Authorization header or a "bearer."
+ * subprotocol.
+ */
+function bearerToken(request: Request): string | null {
+  const auth = request.headers.get("Authorization");
+  if (auth?.startsWith("Bearer ")) {
+    return auth.slice("Bearer ".length).trim();
+  }
+  for (const protocol of (request.headers.get("Sec-WebSocket-Protocol") || "").split(",")) {
+    const p = protocol.trim();
+    if (p.startsWith(BEARER_PROTOCOL_PREFIX)) {
+      return p.slice(BEARER_PROTOCOL_PREFIX.length);
+    }
+  }
+  return null;
+}
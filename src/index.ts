@@ -12,6 +12,7 @@ import { handleRegisterColony, handleRegisterAgent } from "./handlers/register";
 import { handleLookupColony, handleLookupAgent } from "./handlers/lookup";
 import { handleHealth } from "./handlers/health";
 import { handleCreateBootstrapToken } from "./handlers/bootstrap";
+import { handleWatchSocket } from "./handlers/watch";
 import { getJWKS } from "./crypto";
 import { createLogger, parseLogLevel, type Logger } from "./logger";
 import { DiscoveryMetrics } from "./metrics";
@@ -39,11 +40,17 @@ export default {
         return await handleConnectRequest(request, env, ctx, path, clientIP, log);
       }
 
-      // Handle JWKS endpoint for token verification.
-      if (method === "GET" && path === "/.well-known/jwks.json") {
+      // Handle JWKS endpoint for token verification. The coral-jwks.json
+      // path matches the Go jwks package served by corald.
+      if (method === "GET" && (path === "/.well-known/jwks.json" || path === "/.well-known/coral-jwks.json")) {
         return await handleJWKS(env, log);
       }
 
+      // Handle the WebSocket push channel for browser-based agents.
+      if (method === "GET" && path === "/v1/ws") {
+        return await handleWatchSocket(request, env, log);
+      }
+
       // Handle stats endpoint.
       if (method === "GET" && path === "/stats") {
         return await handleStats(env);
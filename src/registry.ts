@@ -1,6 +1,7 @@
 import type { Env, ColonyRecord, AgentRecord, EndpointRecord, Config } from "./types";
 import { parseConfig } from "./types";
 import { createLogger, parseLogLevel, type Logger } from "./logger";
+import { WATCH_PROTOCOL } from "./handlers/watch";
 
 /**
  * SQL schema for the registry.
@@ -128,6 +129,8 @@ export class ColonyRegistry implements DurableObject {
         return this.handleHealth();
       } else if (path === "/count") {
         return this.handleCount();
+      } else if (path === "/watch") {
+        return this.handleWatch(request);
       }
 
       return new Response("Not Found", { status: 404 });
@@ -146,6 +149,14 @@ export class ColonyRegistry implements DurableObject {
   async alarm(): Promise<void> {
     const now = Date.now();
 
+    // Collect expiring agents first so watchers can be told about them.
+    const expiredAgents = this.sql
+      .exec<{ agent_id: string; mesh_id: string }>(
+        `SELECT agent_id, mesh_id FROM agents WHERE expires_at < ?`,
+        now
+      )
+      .toArray();
+
     // Delete expired entries and get counts via changes().
     this.sql.exec(`DELETE FROM colonies WHERE expires_at < ?`, now);
     const coloniesDeleted = this.sql.exec<{ c: number }>(`SELECT changes() as c`).toArray()[0]?.c || 0;
@@ -158,6 +169,10 @@ export class ColonyRegistry implements DurableObject {
       this.colonyCache.clear();
       this.agentCache.clear();
     }
+    for (const row of expiredAgents) {
+      this.broadcast({ type: "expire", kind: "agent", record: { agentId: row.agent_id, meshId: row.mesh_id } });
+    }
+    this.closeExpiredSockets();
 
     // Emit metrics to the global metrics DO.
     try {
@@ -278,6 +293,19 @@ export class ColonyRegistry implements DurableObject {
     // Update cache.
     this.colonyCache.delete(body.meshId);
 
+    this.broadcast({
+      type: "put",
+      kind: "colony",
+      record: {
+        meshId: body.meshId,
+        pubkey: body.pubkey,
+        endpoints: body.endpoints || [],
+        observedEndpoints: observedEndpoint ? [observedEndpoint] : [],
+        metadata: body.metadata,
+        expiresAt: Math.floor(expiresAt / 1000),
+      },
+    });
+
     this.log.info(`[Registry] RegisterColony SUCCESS: meshId=${body.meshId}, expiresAt=${new Date(expiresAt).toISOString()}`);
 
     return Response.json({
@@ -428,6 +456,20 @@ export class ColonyRegistry implements DurableObject {
     // Invalidate cache.
     this.agentCache.delete(body.agentId);
 
+    this.broadcast({
+      type: "put",
+      kind: "agent",
+      record: {
+        agentId: body.agentId,
+        meshId: body.meshId,
+        pubkey: body.pubkey,
+        endpoints: body.endpoints || [],
+        observedEndpoints: observedEndpoint ? [observedEndpoint] : [],
+        metadata: body.metadata,
+        expiresAt: Math.floor(expiresAt / 1000),
+      },
+    });
+
     this.log.info(`[Registry] RegisterAgent SUCCESS: agentId=${body.agentId}, meshId=${body.meshId}, expiresAt=${new Date(expiresAt).toISOString()}`);
 
     return Response.json({
@@ -502,6 +544,77 @@ export class ColonyRegistry implements DurableObject {
     return Response.json(response);
   }
 
+  /**
+   * Accept a watch socket. The worker has already verified the ticket and
+   * passes its expiry in X-Ticket-Expires (Unix seconds). Sockets use the
+   * hibernation API so idle watchers do not keep the object in memory.
+   */
+  private handleWatch(request: Request): Response {
+    const ticketExpires = Number(request.headers.get("X-Ticket-Expires") || 0);
+    const pair = new WebSocketPair();
+    const [client, server] = Object.values(pair);
+
+    this.ctx.acceptWebSocket(server);
+    server.serializeAttachment({ ticketExpires });
+
+    const headers = new Headers();
+    const offered = (request.headers.get("Sec-WebSocket-Protocol") || "").split(",").map((p) => p.trim());
+    if (offered.includes(WATCH_PROTOCOL)) {
+      headers.set("Sec-WebSocket-Protocol", WATCH_PROTOCOL);
+    }
+    return new Response(null, { status: 101, webSocket: client, headers });
+  }
+
+  /**
+   * Send a message to every watch socket, closing those whose ticket has
+   * expired.
+   */
+  private broadcast(message: Record<string, unknown>): void {
+    this.closeExpiredSockets();
+    const data = JSON.stringify(message);
+    for (const ws of this.ctx.getWebSockets()) {
+      try {
+        ws.send(data);
+      } catch {
+        // The socket is already closing.
+      }
+    }
+  }
+
+  /**
+   * Close watch sockets whose ticket has expired.
+   */
+  private closeExpiredSockets(): void {
+    const now = Math.floor(Date.now() / 1000);
+    for (const ws of this.ctx.getWebSockets()) {
+      const attachment = ws.deserializeAttachment() as { ticketExpires?: number } | null;
+      if (attachment?.ticketExpires && attachment.ticketExpires <= now) {
+        try {
+          ws.send(JSON.stringify({ type: "expired" }));
+          ws.close(1008, "ticket expired");
+        } catch {
+          // The socket is already closing.
+        }
+      }
+    }
+  }
+
+  /**
+   * Watchers have nothing to say; incoming messages are ignored.
+   */
+  async webSocketMessage(_ws: WebSocket, _message: string | ArrayBuffer): Promise<void> {}
+
+  /**
+   * Complete the close handshake started by the agent.
+   */
+  async webSocketClose(ws: WebSocket, code: number, reason: string): Promise<void> {
+    try {
+      ws.close(code, reason);
+    } catch {
+      // Reserved codes such as 1005 cannot be echoed back.
+    }
+  }
+
   /**
    * Health check.
    */
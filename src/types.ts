@@ -16,6 +16,7 @@ export interface Env {
 
   // Secrets (set via wrangler secret).
   DISCOVERY_SIGNING_KEY?: string;
+  DISCOVERY_KEY_PASSPHRASE?: string; // Opens a sealed DISCOVERY_SIGNING_KEY.
 }
 
 /**
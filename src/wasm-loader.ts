@@ -5,21 +5,160 @@
  * the crypto functions for JWT operations.
  */
 
+/**
+ * Structured error reported by the Wasm module.
+ */
+export interface WasmError {
+  code: string; // e.g. "ERR_KEY_DECODE", "ERR_TOKEN_EXPIRED", "ERR_TOKEN_REVOKED".
+  message: string;
+  retryable: boolean;
+}
+
+/**
+ * Error thrown when a Wasm export's Promise rejects.
+ */
+export type WasmCryptoError = Error & Pick<WasmError, "code" | "retryable">;
+
 /**
  * Result from createReferralTicket.
  */
 export interface CreateTicketResult {
   jwt?: string;
   expiresAt?: number;
-  error?: string;
 }
 
 /**
- * Result from verifySignature.
+ * Result from createReferralCWT.
+ */
+export interface CreateCWTResult {
+  cwt: string; // Unpadded base64url COSE_Sign1 CWT.
+  expiresAt: number;
+}
+
+/**
+ * Result from verifySignature. Within a verifySignatures batch, error
+ * reports a per-token failure instead of rejecting the whole call.
  */
 export interface VerifySignatureResult {
   valid?: boolean;
-  error?: string;
+  error?: WasmError;
+}
+
+/**
+ * Result from verifyCredential.
+ */
+export interface VerifyCredentialResult {
+  valid: true;
+  issuer: string; // did:key of the issuing key.
+  subject: string; // Member agent DID.
+  reefId: string;
+  colonyId: string;
+  expiresAt: number; // Unix seconds; 0 when the credential does not expire.
+}
+
+/**
+ * Result from loadJWKS.
+ */
+export interface LoadJWKSResult {
+  loaded: number;
+}
+
+/**
+ * Result from fetchJWKS.
+ */
+export interface FetchJWKSResult {
+  loaded: number;
+  hit: boolean; // Served from KV rather than origin.
+  stale: boolean; // Served past freshSeconds; a background refresh was started.
+}
+
+/**
+ * Result from loadRevocations.
+ */
+export interface LoadRevocationsResult {
+  count: number;
+  generatedAt: number; // Unix seconds when the snapshot was built.
+}
+
+/**
+ * Result from signDetached.
+ */
+export interface SignDetachedResult {
+  signature: string; // Base64-encoded Ed25519 signature.
+}
+
+/**
+ * Expectations checked in addition to the signature. Omitted issuer and
+ * audience accept the coral-discovery / coral-colony defaults.
+ */
+export interface VerifyOptions {
+  issuer?: string | string[];
+  audience?: string | string[];
+  // Accepted JWS algs: "EdDSA" (the default), "ES256", "RS256".
+  algorithms?: string | string[];
+  clockSkewSeconds?: number;
+  // Longer skew tolerated on nbf and iat only, for issuers running ahead.
+  notBeforeSkewSeconds?: number;
+  maxTTLSeconds?: number; // Reject tickets whose exp - iat exceeds this.
+}
+
+/**
+ * Input pair for verifySignatures.
+ */
+export interface VerifySignatureInput {
+  token: string;
+  jwksJSON?: string; // Omit to use keys cached by loadJWKS.
+  options?: VerifyOptions;
+}
+
+/**
+ * Result from verifySignatures.
+ */
+export interface VerifySignaturesResult {
+  results?: VerifySignatureResult[];
+}
+
+/**
+ * Unverified claims returned by parseReferralTicket. Use for routing only.
+ */
+export interface ParsedReferralTicket {
+  reefID: string;
+  colonyID: string;
+  agentID: string;
+  intent: string;
+  exp: number;
+  kid: string;
+}
+
+/**
+ * Restriction appended by attenuateTicket. Omitted members are unchanged.
+ */
+export interface Caveat {
+  expiresAt?: number; // Unix seconds; only ever shortens the lifetime.
+  intent?: string; // Must equal or ":"-extend the current intent.
+  agentID?: string;
+}
+
+/**
+ * Result from verifyAttenuatedTicket: the claims after every caveat.
+ */
+export interface VerifyAttenuatedTicketResult {
+  valid: boolean;
+  reefID: string;
+  colonyID: string;
+  agentID: string;
+  intent: string;
+  exp: number;
+}
+
+/**
+ * Result from verifyDelegatedTicket.
+ */
+export interface VerifyDelegatedTicketResult {
+  valid: boolean;
+  depth: number; // Hops below the root ticket; 0 for a root ticket.
+  agentID: string;
+  intent: string;
 }
 
 /**
@@ -30,14 +169,218 @@ export interface GenerateKeyPairResult {
   privateKey?: string;
   publicKey?: string;
   jwk?: string;
-  error?: string;
+}
+
+/**
+ * Agent record stored by the Wasm registry. Field names match the Go
+ * server's JSON encoding.
+ */
+export interface RegistryRecord {
+  agent_id: string;
+  colony_id: string;
+  reef_id: string;
+  endpoints: string[];
+  capabilities?: string[];
+  ttl_seconds: number;
+  created_at: string;
+  updated_at: string;
+  expires_at: string;
+  location?: RegistryLocation;
+  rtt_ms?: Record<string, number>; // Measured RTTs to peers, by agent ID.
+  load?: RegistryLoad;
+  publishes?: string[]; // Pub/sub topics the agent produces.
+  subscribes?: string[]; // Pub/sub topics the agent consumes.
+  metadata?: Record<string, unknown>; // Matches the colony's RegistrySchema, if it has one.
+  seq?: number; // Count of writes to the record; pass to renew to refuse stale renewals.
+  version?: { wall: number; logical?: number; node?: string }; // Hybrid logical clock stamp of the last write.
+}
+
+/**
+ * Options of a service lookup.
+ */
+export interface ServiceQuery {
+  version?: string; // Comparisons that must all hold, e.g. ">=2.3,<3".
+  stable?: boolean; // Exclude pre-releases such as 2.4.0-rc.1.
+  canary?: number; // Percentage of callers steered to the newest matching version.
+  key?: string; // Keeps a caller, e.g. its agent ID, in the same share.
+  colonyID?: string;
+}
+
+/**
+ * Load an agent reports with its heartbeats.
+ */
+export interface RegistryLoad {
+  utilization: number; // Share of capacity in use, from 0 to 1.
+  sessions?: number;
+}
+
+/**
+ * Coarse agent position used to rank peers by proximity.
+ */
+export interface RegistryLocation {
+  country?: string;
+  continent?: string;
+  colo?: string;
+  latitude?: number;
+  longitude?: number;
+}
+
+/**
+ * Build a RegistryLocation from the geolocation Cloudflare attaches to a
+ * request, for register records and nearest origins.
+ */
+export function cfLocation(request: Request): RegistryLocation | undefined {
+  const cf = request.cf;
+  if (!cf) {
+    return undefined;
+  }
+  return {
+    country: cf.country as string | undefined,
+    continent: cf.continent as string | undefined,
+    colo: cf.colo as string | undefined,
+    latitude: cf.latitude ? Number(cf.latitude) : undefined,
+    longitude: cf.longitude ? Number(cf.longitude) : undefined,
+  };
+}
+
+/**
+ * JSON Schema a colony's agents' metadata must match.
+ */
+export interface RegistrySchema {
+  reef_id: string;
+  colony_id: string;
+  schema: Record<string, unknown>;
+  updated_at: string;
+}
+
+/**
+ * Lease on a lock or semaphore of a colony.
+ */
+export interface LockLease {
+  reef_id: string;
+  colony_id: string;
+  name: string;
+  agent_id: string;
+  session?: string;
+  fence: number; // Greater than the fences of every earlier lease of the lock.
+  limit: number;
+  ttl_seconds: number;
+  acquired_at: string;
+  expires_at: string;
+}
+
+/**
+ * Live leases of a lock or semaphore; limit is 0 while none is held.
+ */
+export interface LockSemaphore {
+  reef_id: string;
+  colony_id: string;
+  name: string;
+  limit: number;
+  fence: number;
+  leases: LockLease[];
+}
+
+/**
+ * Registry handle returned by openRegistry. State is persisted to the
+ * Durable Object storage passed in, so it survives isolate eviction.
+ */
+export interface WasmRegistry {
+  register(ticket: string, recordJSON: string): Promise<{ record: RegistryRecord }>;
+  deregister(ticket: string, agentId: string): Promise<{ deleted: boolean }>;
+  // loadJSON carries the agent's current RegistryLoad. With seq, renewals
+  // of a record written since reject with ERR_STALE_RECORD.
+  renew(ticket: string, agentId: string, loadJSON?: string, seq?: number): Promise<{ record: RegistryRecord }>;
+  // Agents of other reefs are not found.
+  lookup(reefId: string, agentId: string): Promise<{ record: RegistryRecord }>;
+  // One page in agent ID order; pass nextCursor back for the next.
+  list(reefId: string, colonyId: string, cursor?: string, limit?: number): Promise<{ agents: RegistryRecord[]; nextCursor?: string }>;
+  // Records written since `since` (a through.wall of an earlier delta, or
+  // none for all) and tombstones of the agents removed since. Do not
+  // JSON.parse deltaJSON; its clock readings exceed
+  // Number.MAX_SAFE_INTEGER. A since older than the tombstone retention
+  // rejects with ERR_RESYNC_REQUIRED.
+  changes(reefId: string, colonyId: string, since?: string): Promise<{ deltaJSON: string }>;
+  // A colony's metadata schema. Once set, registrations whose metadata
+  // does not match it reject with ERR_INVALID_METADATA; schemas outside
+  // the supported JSON Schema subset reject with ERR_INVALID_ARGUMENT.
+  schema(reefId: string, colonyId: string): Promise<{ schema: RegistrySchema }>;
+  setSchema(reefId: string, colonyId: string, schemaJSON: string): Promise<{ schema: RegistrySchema }>;
+  deleteSchema(reefId: string, colonyId: string): Promise<{ deleted: boolean }>;
+  // Rank a colony's agents by estimated RTT from originJSON, a partial
+  // record such as { agent_id, location: cfLocation(request) }.
+  nearest(reefId: string, colonyId: string, originJSON: string, n: number): Promise<{ agents: RegistryRecord[] }>;
+  // A reef's publishers and subscribers of a topic, in agent ID order.
+  topic(reefId: string, topic: string, role?: "publisher" | "subscriber", colonyId?: string): Promise<{ agents: RegistryRecord[] }>;
+  // A reef's agents advertising "service=<service>/vX.Y.Z" at a matching
+  // version, in agent ID order.
+  service(reefId: string, service: string, query?: ServiceQuery): Promise<{ agents: RegistryRecord[] }>;
+  // Call from the Durable Object alarm handler.
+  reap(): Promise<{ evicted: number }>;
+  // Move records stored before reefs were isolated to their reef's keys.
+  // Safe to call on every Durable Object start.
+  migrate(): Promise<{ moved: number }>;
+  // Locks and semaphores of the ticket's colony. acquireLock leases one
+  // of limit (default 1) slots for ttlSeconds (default 30) without
+  // waiting: while all are held it rejects with ERR_LOCK_UNAVAILABLE, to
+  // be retried. Renew within the TTL; renewals and releases of a lease
+  // that ran out reject with ERR_LOCK_NOT_HELD.
+  lock(ticket: string, name: string): Promise<{ semaphore: LockSemaphore }>;
+  acquireLock(ticket: string, name: string, limit?: number, ttlSeconds?: number, session?: string): Promise<{ lease: LockLease }>;
+  renewLock(ticket: string, name: string, fence: number, session?: string): Promise<{ lease: LockLease }>;
+  releaseLock(ticket: string, name: string, fence: number, session?: string): Promise<{ released: boolean }>;
+  // Edge replicas only (openRegistry with a replicaId): POST deltaJSON to
+  // the origin's /v1/crdt/sync with the replicas' secret as bearer token,
+  // then pass the response text to syncComplete. Do not JSON.parse
+  // deltas; their clock readings exceed Number.MAX_SAFE_INTEGER.
+  syncRequest?(): Promise<{ deltaJSON: string }>;
+  syncComplete?(deltaJSON: string): Promise<{ merged: boolean }>;
+}
+
+/**
+ * One-time ticket guard returned by openReplayGuard.
+ */
+export interface WasmReplayGuard {
+  // Record a verified ticket's jti until it expires. A second use rejects
+  // with ERR_TOKEN_REPLAYED.
+  consume(tokenString: string): Promise<{ jti: string; exp: number }>;
+  // Call from the Durable Object alarm handler.
+  prune(): Promise<{ pruned: number }>;
 }
 
 /**
  * Crypto module interface exposed by Wasm.
+ *
+ * Every export returns a Promise. Failures reject with a WasmCryptoError
+ * carrying the structured error code reported by the Wasm module.
  */
 export interface CryptoModule {
   createReferralTicket(
+    privateKeyB64: string,
+    keyId: string,
+    reefId: string,
+    colonyId: string,
+    agentId: string,
+    intent: string,
+    ttlSeconds: number,
+    holderPublicKeyB64?: string // Bind the ticket (cnf) to this holder key.
+  ): Promise<CreateTicketResult>;
+
+  // Omit jwksJSON to verify against keys cached by loadJWKS. With options,
+  // unexpected issuer, audience or lifetime rejects with ERR_TOKEN_CLAIMS.
+  verifySignature(
+    tokenString: string,
+    jwksJSON?: string | null,
+    options?: VerifyOptions
+  ): Promise<VerifySignatureResult>;
+
+  verifySignatures(items: VerifySignatureInput[]): Promise<VerifySignaturesResult>;
+
+  parseReferralTicket(tokenString: string): Promise<ParsedReferralTicket>;
+
+  // Mint a ticket in the compact CWT encoding, for links whose payload
+  // limits JWTs exceed.
+  createReferralCWT(
     privateKeyB64: string,
     keyId: string,
     reefId: string,
@@ -45,38 +388,258 @@ export interface CryptoModule {
     agentId: string,
     intent: string,
     ttlSeconds: number
-  ): CreateTicketResult;
+  ): Promise<CreateCWTResult>;
+
+  // Verify a CWT ticket with the same keys and options as verifySignature.
+  verifyCWT(
+    cwtB64: string,
+    jwksJSON?: string | null,
+    options?: VerifyOptions
+  ): Promise<VerifySignatureResult>;
+
+  // Mint a child of a delegable parent ticket. The child intent must equal
+  // the parent's or extend it with a ":" suffix.
+  delegateTicket(
+    parentToken: string,
+    holderPrivateKeyB64: string,
+    agentId: string,
+    intent: string,
+    ttlSeconds: number,
+    childDelegationKeyB64?: string
+  ): Promise<CreateTicketResult>;
+
+  // Verify a root or delegated ticket. Omit jwksJSON to use loadJWKS keys.
+  verifyDelegatedTicket(
+    tokenString: string,
+    jwksJSON?: string,
+    maxDepth?: number
+  ): Promise<VerifyDelegatedTicketResult>;
+
+  // Mint an attenuable token; agentId may be empty for holders to pin.
+  createAttenuableTicket(
+    privateKeyB64: string,
+    keyId: string,
+    reefId: string,
+    colonyId: string,
+    agentId: string,
+    intent: string,
+    ttlSeconds: number
+  ): Promise<{ token: string }>;
+
+  // Narrow an attenuable token offline; no key is needed.
+  attenuateTicket(token: string, caveat: Caveat): Promise<{ token: string }>;
+
+  // Verify an attenuable token. Omit jwksJSON to use loadJWKS keys.
+  verifyAttenuatedTicket(token: string, jwksJSON?: string): Promise<VerifyAttenuatedTicketResult>;
+
+  // Sign a proof of possession for a bound ticket over a server nonce.
+  createProof(holderPrivateKeyB64: string, tokenString: string, nonce: string): Promise<{ proof: string }>;
 
-  verifySignature(tokenString: string, jwksJSON: string): VerifySignatureResult;
+  // Check the proof presented with an already verified bound ticket.
+  // Failures reject with ERR_PROOF.
+  verifyProof(
+    tokenString: string,
+    proof: string,
+    nonce: string,
+    maxAgeSeconds?: number
+  ): Promise<{ valid: true; thumbprint: string }>;
 
-  generateKeyPair(): GenerateKeyPairResult;
+  // Verify a colony membership Verifiable Credential (Ed25519Signature2020)
+  // issued by a key in jwksJSON. Failures reject with ERR_CREDENTIAL.
+  verifyCredential(credentialJSON: string, jwksJSON: string): Promise<VerifyCredentialResult>;
+
+  // FROST threshold signing (wasm/threshold). Round one: keep nonces
+  // secret for a single thresholdSign call and publish commitment.
+  thresholdCommit(keyShareJSON: string): Promise<{ nonces: string; commitment: string }>;
+
+  // Round two: sign a session message over the complete commitment list.
+  thresholdSign(
+    keyShareJSON: string,
+    noncesJSON: string,
+    message: string,
+    commitmentsJSON: string
+  ): Promise<{ share: string }>;
+
+  // Verify the shares (keyed by identifier) and combine them into an
+  // Ed25519 signature; jwt is message plus signature. Bad shares reject
+  // with ERR_THRESHOLD naming the operator.
+  thresholdAggregate(
+    publicKeysJSON: string,
+    message: string,
+    commitmentsJSON: string,
+    sharesJSON: string
+  ): Promise<{ signature: string; jwt: string }>;
+
+  loadJWKS(jwksJSON: string, ttlSeconds: number): Promise<LoadJWKSResult>;
+
+  // Check a root-signed JWKS bundle from an untrusted source against the
+  // reef's root keys; with ttlSeconds, also load the verified keys.
+  verifyJWKSBundle(
+    bundleJSON: string,
+    rootJWKSJSON: string,
+    reefId: string,
+    ttlSeconds?: number
+  ): Promise<{ jwks: string; loaded: number }>;
+
+  // Read a JWKS document through KV with stale-while-revalidate, then load
+  // it. Pass the incoming request's traceparent header to continue its trace.
+  fetchJWKS(
+    kv: KVNamespace,
+    url: string,
+    freshSeconds: number,
+    staleSeconds: number,
+    traceparent?: string | null
+  ): Promise<FetchJWKSResult>;
+
+  // Record spans of fetchJWKS and of registries opened afterwards, exported
+  // as OTLP/JSON to endpoint (e.g. https://collector/v1/traces). headersJSON
+  // is an object of extra headers; pass a null endpoint to stop tracing.
+  configureTracing(
+    endpoint: string | null,
+    serviceName: string,
+    headersJSON?: string,
+    ratio?: number
+  ): Promise<{ enabled: boolean }>;
+
+  // Export the spans finished so far; hand the Promise to ctx.waitUntil.
+  flushTraces(): Promise<void>;
+
+  // Record createReferralTicket calls and the registrations and rejected
+  // tickets of registries opened afterwards as hash-chained audit records
+  // in an Analytics Engine dataset; pass null to stop.
+  configureAudit(dataset: AnalyticsEngineDataset | null): Promise<{ enabled: boolean }>;
+
+  // Install per-isolate token bucket budgets, in the format of corald's
+  // -rate-limits, for rateLimit and the writes of registries opened
+  // afterwards, which then reject with ERR_RATE_LIMITED; pass null to
+  // remove them.
+  configureRateLimit(configJSON: string | null): Promise<{ enabled: boolean }>;
+
+  // Charge a request to its source IP (CF-Connecting-IP) and, once its
+  // ticket is verified, to the ticket's agent, colony and reef.
+  rateLimit(
+    kind: "register" | "lookup" | "verify",
+    ip: string,
+    agentId?: string,
+    colonyId?: string,
+    reefId?: string
+  ): Promise<{ allowed: boolean; retryAfterMs?: number }>;
+
+  // Count a rejected ticket from ip towards the penalty box.
+  rateLimitFailure(ip: string): Promise<Record<string, never>>;
+
+  // Replace the revocation snapshot (GET /v1/revocations). Verification of
+  // a revoked ticket rejects with ERR_TOKEN_REVOKED.
+  loadRevocations(blob: Uint8Array): Promise<LoadRevocationsResult>;
+
+  // Replace the intent policy applied after signature verification, or
+  // pass null to remove it. Denied tickets reject with ERR_POLICY_DENIED.
+  loadPolicy(policyJSON: string | null): Promise<{ rules: number }>;
+
+  signDetached(privateKeyB64: string, payload: Uint8Array): Promise<SignDetachedResult>;
+
+  verifyDetached(
+    publicKeyB64: string,
+    payload: Uint8Array,
+    signatureB64: string
+  ): Promise<VerifySignatureResult>;
+
+  generateKeyPair(): Promise<GenerateKeyPairResult>;
+
+  // Derive the key at path (e.g. "agent:" + agentId) below a colony master
+  // key. The id is masterKeyId + "/" + path; the JWK carries coral_parent
+  // and coral_path.
+  deriveKey(masterPrivateKeyB64: string, masterKeyId: string, path: string): Promise<GenerateKeyPairResult>;
+
+  // Encrypt a private key under a passphrase (Argon2id +
+  // XChaCha20-Poly1305); reverse with unsealPrivateKey.
+  sealPrivateKey(privateKeyB64: string, passphrase: string): Promise<{ sealed: string }>;
+
+  // Wrong passphrases reject with ERR_KEY_UNSEAL.
+  unsealPrivateKey(sealed: string, passphrase: string): Promise<{ privateKey: string }>;
+
+  // 24-word BIP39 backup phrase of a private key's seed.
+  keyToMnemonic(privateKeyB64: string): Promise<{ mnemonic: string }>;
+
+  // Bad words or checksums reject with ERR_KEY_DECODE.
+  keyFromMnemonic(mnemonic: string): Promise<{ privateKey: string; publicKey: string }>;
+
+  // Derive a shared channel secret (base64) from this agent's Ed25519 key
+  // and a peer's Ed25519 public key via X25519 + HKDF-SHA256.
+  deriveSharedSecret(
+    privateKeyB64: string,
+    peerPublicKeyB64: string,
+    info?: string, // HKDF info; defaults to "coral-channel-v1".
+    length?: number // Secret size in bytes; defaults to 32.
+  ): Promise<{ secret: string }>;
+
+  openRegistry(
+    storage: DurableObjectStorage,
+    jwksJSON: string,
+    defaultTtlSeconds: number,
+    peerCache?: KVNamespace, // Optional read-through cache for lookup.
+    replicaId?: string // Makes the registry an edge replica of the origin's.
+  ): Promise<WasmRegistry>;
+
+  openReplayGuard(storage: DurableObjectStorage): Promise<WasmReplayGuard>;
 }
 
 // Global instance cache.
 let cryptoModule: CryptoModule | null = null;
 
-// Note: We would typically include wasm_exec.js for Go/TinyGo support.
-// In a Cloudflare Workers environment, we can use a bundled version.
+/**
+ * Minimal shape of the Go class defined by TinyGo's wasm_exec.js.
+ */
+interface GoRuntime {
+  importObject: WebAssembly.Imports;
+  run(instance: WebAssembly.Instance): Promise<void>;
+}
+
+type GoConstructor = new () => GoRuntime;
 
 /**
  * Load the crypto Wasm module.
+ *
+ * The TinyGo runtime must be loaded first by importing the wasm_exec.js
+ * copied next to crypto.wasm by `make wasm`:
+ *
+ *   import "./wasm_exec.js";
+ *   import wasmModule from "./crypto.wasm";
+ *   const crypto = await loadCryptoModule(wasmModule);
+ *
+ * Subsequent calls return the cached module and ignore the argument.
  */
-export async function loadCryptoModule(): Promise<CryptoModule> {
+export async function loadCryptoModule(wasmModule?: WebAssembly.Module): Promise<CryptoModule> {
   if (cryptoModule) {
     return cryptoModule;
   }
 
-  // Implementation for demonstration purposes.
-  // In a real production deployment with TinyGo, you would:
-  // 1. Ensure crypto.wasm is in the src directory.
-  // 2. Import it: import wasmModule from "./crypto.wasm";
-  // 3. Initialize the Go runtime: const go = new Go(); 
-  // 4. Instantiate: const instance = await WebAssembly.instantiate(wasmModule, go.importObject);
-  
-  throw new Error(
-    "Wasm module 'crypto.wasm' not found or not initialized. " +
-    "Ensure it is built and bundled correctly."
-  );
+  if (!wasmModule) {
+    throw new Error(
+      "Wasm module 'crypto.wasm' not found or not initialized. " +
+      "Ensure it is built and bundled correctly."
+    );
+  }
+
+  const Go = (globalThis as { Go?: GoConstructor }).Go;
+  if (!Go) {
+    throw new Error("TinyGo runtime not loaded; import wasm_exec.js before loadCryptoModule");
+  }
+
+  const go = new Go();
+  const instance = await WebAssembly.instantiate(wasmModule, go.importObject);
+
+  // main registers coralCrypto and then blocks forever, so run never
+  // settles while the module is alive.
+  void go.run(instance);
+
+  const exports = (globalThis as { coralCrypto?: CryptoModule }).coralCrypto;
+  if (!exports) {
+    throw new Error("Wasm module started but did not register coralCrypto");
+  }
+
+  cryptoModule = exports;
+  return cryptoModule;
 }
 
 /**
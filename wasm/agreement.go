@@ -0,0 +1,56 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/base64"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/x25519"
+)
+
+// defaultSecretLength is the size of derived secrets when length is omitted.
+const defaultSecretLength = 32
+
+// deriveSharedSecret derives a channel secret between an agent's Ed25519
+// key and a peer's Ed25519 public key (e.g. from its registry record) via
+// X25519 and HKDF-SHA256. Both sides derive the same secret.
+// Arguments: privateKeyB64, peerPublicKeyB64, [info], [length]
+// Returns: { secret: string } or { error: { code, message, retryable } }
+func deriveSharedSecret(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected at least 2 arguments: privateKeyB64, peerPublicKeyB64, [info], [length]")
+	}
+
+	privateKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	peer, err := keys.DecodePublicKey(args[1].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode peer public key: "+err.Error())
+	}
+
+	var info string
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		info = args[2].String()
+	}
+	length := defaultSecretLength
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		length = args[3].Int()
+	}
+	if length <= 0 || length > 255*32 {
+		return errorResult(errInvalidArgument, "length must be between 1 and 8160")
+	}
+
+	secret, err := x25519.SharedSecretEd25519(privateKey, peer, info, length)
+	if err != nil {
+		return errorResult(errKeyDecode, err.Error())
+	}
+
+	return map[string]interface{}{
+		"secret": base64.StdEncoding.EncodeToString(secret),
+	}
+}
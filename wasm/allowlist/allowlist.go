@@ -0,0 +1,193 @@
+// Package allowlist implements signed endpoint allowlists. A colony's
+// issuer signs the networks and domains its agents may advertise, and may
+// pin agents to fixed endpoints; the registry refuses registrations whose
+// endpoints fall outside the colony's published list, so a leaked ticket
+// cannot point the colony's peers at a host of the attacker's choosing.
+//
+// Lists are EdDSA JWTs signed with the keys that sign the colony's
+// tickets. Example claims:
+//
+//	{
+//	  "reef_id": "prod", "colony_id": "edge",
+//	  "cidrs": ["10.0.0.0/8", "2001:db8::/32"],
+//	  "domains": ["edge.example.com", "*.edge.example.com"],
+//	  "pins": {"gateway-1": ["203.0.113.7:443"]},
+//	  "aud": "coral-endpoint-allowlist", "iat": 1760486400, "exp": 1792022400
+//	}
+package allowlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Audience is the aud of allowlists, which keeps them from passing for
+// referral tickets and the other way round.
+const Audience = "coral-endpoint-allowlist"
+
+var (
+	// ErrInvalid is returned for allowlists that do not verify or are
+	// malformed.
+	ErrInvalid = errors.New("invalid endpoint allowlist")
+
+	// ErrNotAllowed is returned for endpoints outside a colony's
+	// allowlist, and for any endpoint once the allowlist has expired.
+	ErrNotAllowed = errors.New("endpoint not allowed")
+)
+
+// List names the endpoints the agents of a colony may register.
+type List struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+
+	// CIDRs hold the networks of the IP endpoints allowed.
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// Domains hold the host names allowed: "example.com" allows that
+	// name only, "*.example.com" any name below it. Names are matched as
+	// given; they are not resolved.
+	Domains []string `json:"domains,omitempty"`
+
+	// Pins fix the endpoints of the agents they name, by agent ID.
+	// Pinned agents may register their pinned endpoints only, whatever
+	// CIDRs and Domains allow.
+	Pins map[string][]string `json:"pins,omitempty"`
+}
+
+// Claims are the claims of a signed List.
+type Claims struct {
+	List
+	gojwt.RegisteredClaims
+}
+
+// Published is a verified allowlist as the registry keeps and serves it:
+// the token, for agents to verify themselves, and its claims.
+type Published struct {
+	Token  string  `json:"token"`
+	Claims *Claims `json:"claims"`
+}
+
+// Sign signs list with s, a key signing the colony's tickets. A zero ttl
+// signs a list that does not expire.
+func Sign(ctx context.Context, s signer.Signer, list List, ttl time.Duration) (string, error) {
+	if err := list.Validate(); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &Claims{
+		List: list,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Audience: gojwt.ClaimStrings{Audience},
+			IssuedAt: gojwt.NewNumericDate(now),
+		},
+	}
+	if ttl > 0 {
+		claims.ExpiresAt = gojwt.NewNumericDate(now.Add(ttl))
+	}
+	return signer.SignToken(ctx, s, claims)
+}
+
+// Parse verifies token against keys at time now and returns its claims.
+// Lists must say when they were issued, so a newer list can replace an
+// older one but not the other way round.
+func Parse(keys verify.KeySource, token string, now time.Time) (*Claims, error) {
+	// ParseWithClaims also runs Claims.Validate.
+	claims := &Claims{}
+	_, err := gojwt.ParseWithClaims(token, claims, keys.GetKeyFunc(),
+		gojwt.WithAudience(Audience),
+		gojwt.WithIssuedAt(),
+		gojwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: iat is required", ErrInvalid)
+	}
+	return claims, nil
+}
+
+// Validate checks that l names its colony and holds well-formed CIDRs,
+// domains and pinned endpoints.
+func (l *List) Validate() error {
+	if l.ReefID == "" || l.ColonyID == "" {
+		return fmt.Errorf("%w: reef_id and colony_id are required", ErrInvalid)
+	}
+	for _, c := range l.CIDRs {
+		if _, err := netip.ParsePrefix(c); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+	for _, d := range l.Domains {
+		name := strings.TrimPrefix(d, "*.")
+		if name == "" || strings.ContainsAny(name, "*/: ") {
+			return fmt.Errorf("%w: invalid domain %q", ErrInvalid, d)
+		}
+	}
+	for agent, endpoints := range l.Pins {
+		if len(endpoints) == 0 {
+			return fmt.Errorf("%w: agent %s is pinned to no endpoints", ErrInvalid, agent)
+		}
+		for _, ep := range endpoints {
+			if _, _, err := net.SplitHostPort(ep); err != nil {
+				return fmt.Errorf("%w: agent %s: %v", ErrInvalid, agent, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns ErrNotAllowed unless l allows agentID to register every
+// one of endpoints.
+func (l *List) Check(agentID string, endpoints []string) error {
+	for _, ep := range endpoints {
+		if !l.allows(agentID, ep) {
+			return fmt.Errorf("%w: %s is not in the allowlist of colony %s", ErrNotAllowed, ep, l.ColonyID)
+		}
+	}
+	return nil
+}
+
+// allows reports whether l allows agentID to register endpoint, a
+// host:port pair.
+func (l *List) allows(agentID, endpoint string) bool {
+	if pinned, ok := l.Pins[agentID]; ok {
+		return slices.Contains(pinned, endpoint)
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		for _, c := range l.CIDRs {
+			if prefix, err := netip.ParsePrefix(c); err == nil && prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range l.Domains {
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+// Package audit keeps an append-only, hash-chained log of security
+// relevant events: tickets issued, rejected and revoked, agents joining
+// and leaving the mesh, and administrative actions. Every Record carries
+// the hash of the one before it, so removing, reordering or editing a
+// record breaks the chain that Verify checks.
+//
+// Records are written to one or more Sinks: a JSON-lines File, a signed
+// Webhook, or in the Worker build Workers Analytics Engine. Methods on a
+// nil *Log do nothing, so components can hold a log from an optional
+// Config without checking for one.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+)
+
+// Event types.
+const (
+	TicketIssued      = "ticket.issued"
+	TicketRejected    = "ticket.rejected"
+	TicketRevoked     = "ticket.revoked"
+	AgentRegistered   = "agent.registered"
+	AgentDeregistered = "agent.deregistered"
+	LeaseExpired      = "lease.expired"
+	AdminBackup       = "admin.backup"
+	AdminRestore      = "admin.restore"
+	AdminListAgents   = "admin.list_agents"
+	AdminEvict        = "admin.evict"
+	AdminExpireLease  = "admin.expire_lease"
+	AdminRotateKeys   = "admin.rotate_keys"
+	AdminRevocations  = "admin.list_revocations"
+	EnrollRequest     = "enrollment.requested"
+	AdminEnrollments  = "admin.list_enrollments"
+	AdminApprove      = "admin.approve_enrollment"
+	AdminDeny         = "admin.deny_enrollment"
+	CertIssued        = "certificate.issued"
+	CertRenewed       = "certificate.renewed"
+	AdminDeadLetters  = "admin.list_dead_letters"
+	AdminRedeliver    = "admin.redeliver_webhook"
+	AdminDiscard      = "admin.discard_webhook"
+	AdminHistory      = "admin.read_history"
+	AdminTopology     = "admin.read_topology"
+	AdminQuotas       = "admin.read_quotas"
+	AdminSetQuota     = "admin.set_quota"
+	AdminResetQuota   = "admin.reset_quota"
+	AllowlistPublish  = "allowlist.published"
+	AdminSetSchema    = "admin.set_schema"
+	AdminDeleteSchema = "admin.delete_schema"
+)
+
+// Outcomes of an event.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// GenesisHash is the Prev of the first record of a chain.
+var GenesisHash = strings.Repeat("0", 2*sha256.Size)
+
+// ErrTampered is returned by Verify when the chain is broken.
+var ErrTampered = errors.New("audit chain broken")
+
+// Event describes what happened and who did it.
+type Event struct {
+	Type    string `json:"type"`
+	Outcome string `json:"outcome"`
+
+	// Actor is the agent ID of the ticket holder acting, when known.
+	Actor  string `json:"actor,omitempty"`
+	Reef   string `json:"reef,omitempty"`
+	Colony string `json:"colony,omitempty"`
+	Intent string `json:"intent,omitempty"`
+
+	// Subject is what was acted on: an agent ID, ticket ID or resource.
+	Subject string `json:"subject,omitempty"`
+
+	// Reason explains failures.
+	Reason string `json:"reason,omitempty"`
+
+	// Source is the client address of the request, when known.
+	Source string `json:"source,omitempty"`
+}
+
+// FromClaims returns an Event of type and outcome acted by the holder of
+// claims, which may be nil.
+func FromClaims(typ, outcome string, claims *jwt.ReferralClaims) Event {
+	ev := Event{Type: typ, Outcome: outcome}
+	if claims != nil {
+		ev.Actor, ev.Reef, ev.Colony, ev.Intent = claims.AgentID, claims.ReefID, claims.ColonyID, claims.Intent
+	}
+	return ev
+}
+
+// Record is an Event as written to the log.
+type Record struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Event
+
+	// Prev is the Hash of the record before, or GenesisHash.
+	Prev string `json:"prev"`
+
+	// Hash is the hex SHA-256 of the record's JSON encoding without it.
+	Hash string `json:"hash,omitempty"`
+}
+
+// digest returns the Hash rec should have.
+func (rec *Record) digest() (string, error) {
+	c := *rec
+	c.Hash = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sink stores records. Write is called with records in chain order and
+// must not retain rec beyond the call.
+type Sink interface {
+	Write(ctx context.Context, rec *Record) error
+}
+
+// Tailer is implemented by sinks that can return the last record they
+// hold, so that a restarted Log continues its chain. Tail returns nil
+// when the sink is empty.
+type Tailer interface {
+	Tail() (*Record, error)
+}
+
+// Config holds the configuration for a Log.
+type Config struct {
+	// Sinks receive every record. At least one is required. The chain
+	// resumes from the first that is a Tailer.
+	Sinks []Sink
+}
+
+// Log appends records to its sinks. It is safe for concurrent use.
+type Log struct {
+	mu    sync.Mutex
+	sinks []Sink
+	seq   uint64
+	head  string
+}
+
+// New creates a Log from cfg.
+func New(cfg Config) (*Log, error) {
+	if len(cfg.Sinks) == 0 {
+		return nil, errors.New("audit: at least one sink is required")
+	}
+	l := &Log{sinks: cfg.Sinks, head: GenesisHash}
+	for _, s := range cfg.Sinks {
+		t, ok := s.(Tailer)
+		if !ok {
+			continue
+		}
+		last, err := t.Tail()
+		if err != nil {
+			return nil, fmt.Errorf("audit: failed to resume chain: %w", err)
+		}
+		if last != nil {
+			l.seq, l.head = last.Seq, last.Hash
+		}
+		break
+	}
+	return l, nil
+}
+
+// Record appends ev to the log. The chain advances even when a sink
+// fails, so a record missing from one sink shows as a break there. Callers
+// not failing closed on auditing discard the error.
+func (l *Log) Record(ctx context.Context, ev Event) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec := &Record{Seq: l.seq + 1, Time: time.Now().UTC(), Event: ev, Prev: l.head}
+	hash, err := rec.digest()
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+	l.seq, l.head = rec.Seq, rec.Hash
+
+	var errs []error
+	for _, s := range l.sinks {
+		if err := s.Write(ctx, rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Verify reads JSON-lines records from r, as written by File, and checks
+// that each is intact and follows the one before. A log rotated away
+// from its genesis is checked from its first record on. It returns the
+// last record, nil for an empty log, and the number of records read.
+func Verify(r io.Reader) (*Record, int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	var last *Record
+	n := 0
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return last, n, fmt.Errorf("%w: record %d: %v", ErrTampered, n+1, err)
+		}
+		hash, err := rec.digest()
+		if err != nil {
+			return last, n, err
+		}
+		switch {
+		case hash != rec.Hash:
+			return last, n, fmt.Errorf("%w: record %d was modified", ErrTampered, rec.Seq)
+		case last != nil && rec.Seq != last.Seq+1:
+			return last, n, fmt.Errorf("%w: record %d follows %d", ErrTampered, rec.Seq, last.Seq)
+		case last != nil && rec.Prev != last.Hash:
+			return last, n, fmt.Errorf("%w: record %d does not follow %d", ErrTampered, rec.Seq, last.Seq)
+		case last == nil && rec.Seq == 1 && rec.Prev != GenesisHash:
+			return last, n, fmt.Errorf("%w: record 1 does not start the chain", ErrTampered)
+		}
+		last = &rec
+		n++
+	}
+	return last, n, sc.Err()
+}
+
+// Verifier validates referral tickets; registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Verifier wraps inner so that its rejections are recorded as
+// TicketRejected events. Wrap the outermost verifier to see every
+// rejection.
+func (l *Log) Verifier(inner Verifier) Verifier {
+	if l == nil {
+		return inner
+	}
+	return &verifier{inner: inner, log: l}
+}
+
+type verifier struct {
+	inner Verifier
+	log   *Log
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil {
+		ev := FromClaims(TicketRejected, Failure, claims)
+		ev.Reason = err.Error()
+		_ = v.log.Record(context.Background(), ev)
+	}
+	return claims, err
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxRecordSize bounds a record line; Tail reads back at most this much.
+const maxRecordSize = 64 << 10
+
+// File is a Sink appending records as JSON lines to a file, synced after
+// every record. It is a Tailer.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens, creating it if needed, the log file at path for
+// appending.
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+// Write implements Sink.
+func (s *File) Write(_ context.Context, rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Tail implements Tailer by reading the file's last line.
+func (s *File) Tail() (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := os.Open(s.f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-maxRecordSize, 0)
+	buf := make([]byte, info.Size()-offset)
+	if _, err := r.ReadAt(buf, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	} else if offset > 0 {
+		return nil, errors.New("last record is too large")
+	}
+	var rec Record
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Close closes the file.
+func (s *File) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body under the
+// webhook secret, prefixed with "sha256=".
+const SignatureHeader = "X-Coral-Audit-Signature"
+
+// Webhook defaults.
+const (
+	DefaultWebhookInterval = 5 * time.Second
+	DefaultWebhookMaxQueue = 10000
+)
+
+// ErrQueueFull is returned by Webhook.Write when records are arriving
+// faster than the webhook accepts them.
+var ErrQueueFull = errors.New("audit: webhook queue is full")
+
+// WebhookConfig holds the configuration for a Webhook.
+type WebhookConfig struct {
+	// URL receives POSTs of JSON arrays of records. Required.
+	URL string
+
+	// Secret, when set, signs every body in SignatureHeader.
+	Secret []byte
+
+	// Interval is how often Run delivers. Defaults to
+	// DefaultWebhookInterval.
+	Interval time.Duration
+
+	// MaxQueue bounds the records awaiting delivery. Defaults to
+	// DefaultWebhookMaxQueue.
+	MaxQueue int
+
+	// HTTPClient reaches the webhook. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Webhook is a Sink delivering records in order, in batches, to an HTTP
+// endpoint. Write only queues; Run delivers, retrying failed batches at
+// the next interval so that the receiver sees an unbroken chain.
+type Webhook struct {
+	url      string
+	secret   []byte
+	interval time.Duration
+	maxQueue int
+	http     *http.Client
+
+	flushing sync.Mutex // serializes deliveries, keeping them in order
+	mu       sync.Mutex
+	queue    []Record
+}
+
+// NewWebhook creates a Webhook from cfg.
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("audit: webhook URL is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultWebhookInterval
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = DefaultWebhookMaxQueue
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Webhook{
+		url:      cfg.URL,
+		secret:   cfg.Secret,
+		interval: cfg.Interval,
+		maxQueue: cfg.MaxQueue,
+		http:     cfg.HTTPClient,
+	}, nil
+}
+
+// Write implements Sink.
+func (w *Webhook) Write(_ context.Context, rec *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.queue) >= w.maxQueue {
+		return ErrQueueFull
+	}
+	w.queue = append(w.queue, *rec)
+	return nil
+}
+
+// Flush delivers the queued records. They stay queued when delivery
+// fails.
+func (w *Webhook) Flush(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.mu.Lock()
+	batch := w.queue[:len(w.queue):len(w.queue)]
+	w.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := w.post(ctx, batch); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.queue = w.queue[len(batch):]
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Webhook) post(ctx context.Context, batch []Record) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("audit webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// Run calls Flush every interval until ctx is done, then once more.
+func (w *Webhook) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), w.interval)
+			_ = w.Flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			_ = w.Flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+)
+
+// auditLog records the module's security events; nil until
+// configureAudit.
+var auditLog atomic.Pointer[audit.Log]
+
+// configureAudit starts recording tickets created by createReferralTicket
+// and the registrations, deregistrations, expirations and rejected
+// tickets of registries opened afterwards, as data points in a Workers
+// Analytics Engine dataset. Each isolate keeps its own hash chain. A null
+// dataset stops auditing.
+// Arguments: dataset (AnalyticsEngineDataset)
+// Returns: { enabled: boolean }
+func configureAudit(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: dataset")
+	}
+	if args[0].IsNull() || args[0].IsUndefined() {
+		auditLog.Store(nil)
+		return map[string]interface{}{"enabled": false}
+	}
+	l, err := audit.New(audit.Config{Sinks: []audit.Sink{analyticsSink{dataset: args[0]}}})
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	auditLog.Store(l)
+	return map[string]interface{}{"enabled": true}
+}
+
+// analyticsSink writes records to Workers Analytics Engine, indexed by
+// event type. Blobs are type, outcome, actor, reef, colony, intent,
+// subject, reason, source, prev and hash; doubles are seq and the time in
+// Unix milliseconds.
+type analyticsSink struct {
+	dataset js.Value
+}
+
+func (s analyticsSink) Write(_ context.Context, rec *audit.Record) error {
+	s.dataset.Call("writeDataPoint", map[string]interface{}{
+		"indexes": []interface{}{rec.Type},
+		"blobs": []interface{}{
+			rec.Type, rec.Outcome, rec.Actor, rec.Reef, rec.Colony, rec.Intent,
+			rec.Subject, rec.Reason, rec.Source, rec.Prev, rec.Hash,
+		},
+		"doubles": []interface{}{float64(rec.Seq), float64(rec.Time.UnixMilli())},
+	})
+	return nil
+}
+
+// recordIssued records the ticket createReferralTicket returned in
+// result, unless it failed.
+func recordIssued(result interface{}, reefID, colonyID, agentID, intent string) interface{} {
+	if m, ok := result.(map[string]interface{}); ok && m["error"] == nil {
+		_ = auditLog.Load().Record(context.Background(), audit.Event{
+			Type:    audit.TicketIssued,
+			Outcome: audit.Success,
+			Reef:    reefID,
+			Colony:  colonyID,
+			Intent:  intent,
+			Subject: agentID,
+		})
+	}
+	return result
+}
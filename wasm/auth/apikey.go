@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+)
+
+// APIKeyHeader carries API keys.
+const APIKeyHeader = "Coral-API-Key"
+
+// apiKeyPrefix starts every key generated by NewAPIKey.
+const apiKeyPrefix = "coral_"
+
+// ErrInvalidAPIKey is returned for unknown and expired API keys.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey grants its holder a fixed identity, for callers such as CI jobs
+// and dashboards that cannot mint tickets. Only the key's hash is kept.
+type APIKey struct {
+	// ID names the key in audit events and is the principal's ticket ID.
+	ID string `json:"id"`
+
+	// Hash is the hex SHA-256 of the key (see NewAPIKey).
+	Hash string `json:"hash"`
+
+	ReefID   string   `json:"reef_id"`
+	ColonyID string   `json:"colony_id"`
+	AgentID  string   `json:"agent_id"`
+	Intent   string   `json:"intent"`
+	Roles    []string `json:"roles,omitempty"`
+
+	// ExpiresAt, when set, is when the key stops being accepted.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeys authenticates requests by the API key in their APIKeyHeader.
+// It is safe for concurrent use.
+type APIKeys struct {
+	clock clock.Clock
+
+	mu   sync.RWMutex
+	keys []APIKey
+	hash [][sha256.Size]byte
+}
+
+// apiKeysDocument is the JSON form of APIKeys.
+type apiKeysDocument struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// ParseAPIKeys decodes an API key document of the form
+//
+//	{"keys": [{"id": "ci", "hash": "<hex sha256>", "reef_id": "prod",
+//	  "colony_id": "edge", "agent_id": "ci", "intent": "admin",
+//	  "roles": ["auditor"]}]}
+//
+// Expiry is checked against c, clock.System when nil.
+func ParseAPIKeys(data []byte, c clock.Clock) (*APIKeys, error) {
+	var doc apiKeysDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	k := &APIKeys{clock: clock.Or(c), keys: doc.Keys, hash: make([][sha256.Size]byte, len(doc.Keys))}
+	ids := make(map[string]bool, len(doc.Keys))
+	for i, key := range doc.Keys {
+		if key.ID == "" || ids[key.ID] {
+			return nil, fmt.Errorf("key %d: id is empty or repeated", i)
+		}
+		ids[key.ID] = true
+		sum, err := hex.DecodeString(key.Hash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("key %s: hash must be a hex SHA-256", key.ID)
+		}
+		if key.ReefID == "" || key.ColonyID == "" || key.AgentID == "" || key.Intent == "" {
+			return nil, fmt.Errorf("key %s: reef_id, colony_id, agent_id and intent are required", key.ID)
+		}
+		copy(k.hash[i][:], sum)
+	}
+	return k, nil
+}
+
+// Authenticate implements Authenticator.
+func (k *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := strings.TrimSpace(r.Header.Get(APIKeyHeader))
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(key))
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for i := range k.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash[i][:]) != 1 {
+			continue
+		}
+		entry := &k.keys[i]
+		if entry.ExpiresAt != nil && !k.clock.Now().Before(*entry.ExpiresAt) {
+			return nil, fmt.Errorf("%w: key %s expired", ErrInvalidAPIKey, entry.ID)
+		}
+		claims := &jwt.ReferralClaims{
+			RegisteredClaims: gojwt.RegisteredClaims{ID: entry.ID, Subject: entry.AgentID},
+			ReefID:           entry.ReefID,
+			ColonyID:         entry.ColonyID,
+			AgentID:          entry.AgentID,
+			Intent:           entry.Intent,
+		}
+		if entry.ExpiresAt != nil {
+			claims.ExpiresAt = gojwt.NewNumericDate(*entry.ExpiresAt)
+		}
+		return &Principal{Method: MethodAPIKey, ReferralClaims: claims, Roles: entry.Roles}, nil
+	}
+	return nil, ErrInvalidAPIKey
+}
+
+// Replace accepts the keys of other, such as a reread document, in place
+// of k's. Expiry is still checked against k's clock.
+func (k *APIKeys) Replace(other *APIKeys) {
+	other.mu.RLock()
+	keys, hash := other.keys, other.hash
+	other.mu.RUnlock()
+	k.mu.Lock()
+	k.keys, k.hash = keys, hash
+	k.mu.Unlock()
+}
+
+// NewAPIKey generates a random API key and returns it with the hash to
+// list in the key document. Only the holder keeps the key itself.
+func NewAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(key))
+	return key, hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,106 @@
+package auth_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+)
+
+// document lists keys of the given hashes; expires, when not empty, is
+// the RFC 3339 expiry of the second.
+func document(hash1, hash2, expires string) []byte {
+	expiry := ""
+	if expires != "" {
+		expiry = fmt.Sprintf(`, "expires_at": %q`, expires)
+	}
+	return fmt.Appendf(nil, `{"keys": [
+	  {"id": "ci", "hash": %q, "reef_id": "prod", "colony_id": "edge", "agent_id": "ci", "intent": "admin", "roles": ["auditor"]},
+	  {"id": "old", "hash": %q, "reef_id": "prod", "colony_id": "edge", "agent_id": "dashboard", "intent": "read"%s}
+	]}`, hash1, hash2, expiry)
+}
+
+func newAPIKey(t *testing.T) (key, hash string) {
+	t.Helper()
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, hash
+}
+
+// TestAPIKeys checks that keys authenticate as their entry until they
+// expire on the keys' clock or are replaced.
+func TestAPIKeys(t *testing.T) {
+	ci, ciHash := newAPIKey(t)
+	old, oldHash := newAPIKey(t)
+	now := clock.NewFake(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	expires := now.Now().Add(time.Hour).Format(time.RFC3339)
+	keys, err := auth.ParseAPIKeys(document(ciHash, oldHash, expires), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(key string) (*auth.Principal, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			r.Header.Set(auth.APIKeyHeader, key)
+		}
+		return keys.Authenticate(r)
+	}
+
+	p, err := authenticate(ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Method != auth.MethodAPIKey || p.ID != "ci" || p.ReefID != "prod" || p.AgentID != "ci" || p.Intent != "admin" || !slices.Equal(p.Roles, []string{"auditor"}) {
+		t.Fatalf("principal %+v, want key ci's", p)
+	}
+	if _, err := authenticate(old); err != nil {
+		t.Fatalf("key before its expiry: %v", err)
+	}
+	now.Advance(time.Hour)
+	if _, err := authenticate(old); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Fatalf("expired key: %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := authenticate(ci + "x"); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Fatalf("unknown key: %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := authenticate(""); !errors.Is(err, auth.ErrNoCredentials) {
+		t.Fatalf("no key: %v, want ErrNoCredentials", err)
+	}
+
+	// Replaced keys are still checked against the keys' clock, not the
+	// wall clock the document was reread with.
+	next, err := auth.ParseAPIKeys(document(oldHash, ciHash, expires), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Replace(next)
+	if p, err := authenticate(old); err != nil || p.ID != "ci" {
+		t.Fatalf("replaced key: %v, %v; want key ci's principal", p, err)
+	}
+	if _, err := authenticate(ci); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Fatalf("replaced key past its expiry: %v, want ErrInvalidAPIKey", err)
+	}
+}
+
+// TestParseAPIKeys checks that incomplete entries are refused.
+func TestParseAPIKeys(t *testing.T) {
+	_, hash := newAPIKey(t)
+	for name, doc := range map[string]string{
+		"repeated id": fmt.Sprintf(`{"keys": [{"id": "ci", "hash": %q, "reef_id": "r", "colony_id": "c", "agent_id": "a", "intent": "i"},
+		  {"id": "ci", "hash": %[1]q, "reef_id": "r", "colony_id": "c", "agent_id": "a", "intent": "i"}]}`, hash),
+		"short hash": `{"keys": [{"id": "ci", "hash": "abcd", "reef_id": "r", "colony_id": "c", "agent_id": "a", "intent": "i"}]}`,
+		"no intent":  fmt.Sprintf(`{"keys": [{"id": "ci", "hash": %q, "reef_id": "r", "colony_id": "c", "agent_id": "a"}]}`, hash),
+		"malformed":  `{"keys": {}}`,
+	} {
+		if _, err := auth.ParseAPIKeys([]byte(doc), nil); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
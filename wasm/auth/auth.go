@@ -0,0 +1,131 @@
+// Package auth authenticates requests to the discovery server. Each way
+// a caller can prove who it is — a referral ticket, a JWT-SVID, a mesh
+// certificate, an API key — is an Authenticator yielding a Principal,
+// and a Chain tries them in order. Handlers and policy consume the
+// Principal without caring how it was established.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+)
+
+// Authentication methods, reported in Principal.Method.
+const (
+	MethodTicket      = "ticket"
+	MethodSVID        = "svid"
+	MethodCertificate = "certificate"
+	MethodAPIKey      = "api_key"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request
+// carries no credentials of its kind, passing it to the next one.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Method is how the caller authenticated, one of the Method
+	// constants.
+	Method string
+
+	// ReferralClaims name the caller's reef, colony and agent and the
+	// intent it may act with: the claims of its ticket, or claims
+	// standing in for one.
+	*jwt.ReferralClaims
+
+	// Roles are the caller's admin roles (see package rbac).
+	Roles []string
+
+	// Ticket is the referral ticket the caller authenticated with,
+	// empty for other methods.
+	Ticket string
+}
+
+// Same reports whether p and o name the same agent of the same colony
+// and reef.
+func (p *Principal) Same(o *Principal) bool {
+	return p.ReefID == o.ReefID && p.ColonyID == o.ColonyID && p.AgentID == o.AgentID
+}
+
+// Authenticator authenticates requests of one kind.
+type Authenticator interface {
+	// Authenticate returns the caller of r, ErrNoCredentials when r
+	// carries none of the authenticator's credentials, or why they were
+	// rejected.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+// Authenticate calls f.
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Chain tries its authenticators in order. The first to find
+// credentials decides: its principal is returned, or its error, and the
+// rest are not consulted. A request none recognise fails with
+// ErrNoCredentials.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// Verifier validates referral tickets. It matches registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Ticket authenticates the holder of ticket with v. Roles are read from
+// the verified ticket; tickets in formats without them have none.
+func Ticket(v Verifier, ticket string) (*Principal, error) {
+	claims, err := v.ValidateReferralTicket(ticket)
+	if err != nil {
+		return nil, err
+	}
+	roles, _ := rbac.Roles(ticket)
+	return &Principal{Method: MethodTicket, ReferralClaims: claims, Roles: roles, Ticket: ticket}, nil
+}
+
+// Tickets authenticates the referral tickets token extracts from
+// requests with v.
+func Tickets(v Verifier, token func(*http.Request) string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		ticket := token(r)
+		if ticket == "" {
+			return nil, ErrNoCredentials
+		}
+		return Ticket(v, ticket)
+	})
+}
+
+// principalKey is the context key of the Principal.
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p, so that code below the
+// handler, such as the registry, acts on its behalf without
+// authenticating the request again.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the Principal of ctx, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
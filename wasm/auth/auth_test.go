@@ -0,0 +1,92 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+)
+
+// fixed authenticates every request as p, or fails with err.
+func fixed(p *auth.Principal, err error) auth.Authenticator {
+	return auth.AuthenticatorFunc(func(*http.Request) (*auth.Principal, error) { return p, err })
+}
+
+// TestChain checks that the first authenticator finding credentials
+// decides, whether it accepts or rejects them.
+func TestChain(t *testing.T) {
+	first := &auth.Principal{Method: auth.MethodTicket}
+	second := &auth.Principal{Method: auth.MethodAPIKey}
+	rejected := errors.New("rejected")
+	none := fixed(nil, auth.ErrNoCredentials)
+
+	for name, c := range map[string]struct {
+		chain auth.Chain
+		want  *auth.Principal
+		err   error
+	}{
+		"first accepts":        {auth.Chain{fixed(first, nil), fixed(second, nil)}, first, nil},
+		"first has none":       {auth.Chain{none, fixed(second, nil)}, second, nil},
+		"first rejects":        {auth.Chain{fixed(nil, rejected), fixed(second, nil)}, nil, rejected},
+		"later rejects":        {auth.Chain{fixed(first, nil), fixed(nil, rejected)}, first, nil},
+		"none has credentials": {auth.Chain{none, none}, nil, auth.ErrNoCredentials},
+		"empty chain":          {nil, nil, auth.ErrNoCredentials},
+	} {
+		p, err := c.chain.Authenticate(httptest.NewRequest("GET", "/", nil))
+		if p != c.want || !errors.Is(err, c.err) {
+			t.Errorf("%s: %v, %v; want %v, %v", name, p, err, c.want, c.err)
+		}
+	}
+}
+
+// tickets verifies the tickets it lists.
+type tickets map[string]*jwt.ReferralClaims
+
+func (v tickets) ValidateReferralTicket(token string) (*jwt.ReferralClaims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, errors.New("unknown ticket")
+	}
+	return claims, nil
+}
+
+// TestTickets checks that requests without a ticket pass to the next
+// authenticator and that a bad ticket is rejected rather than skipped.
+func TestTickets(t *testing.T) {
+	claims := &jwt.ReferralClaims{ReefID: "reef", ColonyID: "colony", AgentID: "agent", Intent: "register"}
+	chain := auth.Chain{
+		auth.Tickets(tickets{"good": claims}, func(r *http.Request) string { return r.Header.Get("Ticket") }),
+		fixed(&auth.Principal{Method: auth.MethodAPIKey}, nil),
+	}
+	request := func(ticket string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		if ticket != "" {
+			r.Header.Set("Ticket", ticket)
+		}
+		return r
+	}
+
+	p, err := chain.Authenticate(request("good"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Method != auth.MethodTicket || p.Ticket != "good" || p.ReferralClaims != claims {
+		t.Fatalf("principal %+v, want the ticket's", p)
+	}
+	if p, err := chain.Authenticate(request("forged")); err == nil || errors.Is(err, auth.ErrNoCredentials) {
+		t.Fatalf("forged ticket: %v, %v; want the verifier's error", p, err)
+	}
+	if p, err := chain.Authenticate(request("")); err != nil || p.Method != auth.MethodAPIKey {
+		t.Fatalf("no ticket: %v, %v; want the next authenticator's principal", p, err)
+	}
+
+	ctx := auth.NewContext(context.Background(), p)
+	if auth.FromContext(ctx) != p || auth.FromContext(context.Background()) != nil {
+		t.Fatal("context does not carry the principal")
+	}
+}
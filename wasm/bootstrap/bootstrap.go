@@ -0,0 +1,201 @@
+// Package bootstrap finds the discovery service of a domain in DNS, so
+// agents need only their domain rather than a hardcoded edge URL. It reads
+// the SRV and TXT records of _coral-discovery._tcp.<domain>:
+//
+//	_coral-discovery._tcp.example.com. SRV 10 60 443 edge-a.example.com.
+//	_coral-discovery._tcp.example.com. SRV 20 0  443 edge-b.example.com.
+//	_coral-discovery._tcp.example.com. TXT "url=https://discovery.example.com"
+//	_coral-discovery._tcp.example.com. TXT "jwks=https://discovery.example.com/.well-known/coral-jwks.json"
+//
+// SRV targets become https://<target>:<port> endpoints in RFC 2782 order,
+// followed by any url= entries. DNS answers are only as trustworthy as the
+// resolver: use a DNSSEC-validating resolver, or pin the JWKS, where
+// forged records matter.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Service and Proto name the SRV records looked up.
+const (
+	Service = "coral-discovery"
+	Proto   = "tcp"
+)
+
+// TXT keys.
+const (
+	keyURL  = "url"
+	keyJWKS = "jwks"
+)
+
+// ErrNotFound is returned when the domain publishes no discovery endpoint.
+var ErrNotFound = errors.New("no discovery records")
+
+// Info is what a domain publishes about its discovery service.
+type Info struct {
+	// Endpoints are discovery base URLs, most preferred first.
+	Endpoints []string
+
+	// JWKSURL is the reef's key set, or "" when not published.
+	JWKSURL string
+}
+
+// Config configures a Resolver.
+type Config struct {
+	// Resolver performs the lookups; net.DefaultResolver when nil.
+	Resolver *net.Resolver
+
+	// AllowHTTP accepts http:// URLs in TXT records, for lab setups.
+	AllowHTTP bool
+}
+
+// Resolver looks up discovery records.
+type Resolver struct {
+	dns       *net.Resolver
+	allowHTTP bool
+}
+
+// New creates a Resolver from cfg.
+func New(cfg Config) *Resolver {
+	r := &Resolver{dns: cfg.Resolver, allowHTTP: cfg.AllowHTTP}
+	if r.dns == nil {
+		r.dns = net.DefaultResolver
+	}
+	return r
+}
+
+// Name returns the record name looked up for domain.
+func Name(domain string) string {
+	return "_" + Service + "._" + Proto + "." + strings.TrimSuffix(domain, ".")
+}
+
+// Resolve returns the discovery service published for domain. Missing
+// SRV or TXT records are not an error as long as one of them yields an
+// endpoint; neither are TXT URLs that are malformed or not https.
+func (r *Resolver) Resolve(ctx context.Context, domain string) (*Info, error) {
+	if domain == "" {
+		return nil, errors.New("domain is required")
+	}
+	info := &Info{}
+
+	_, srvs, err := r.dns.LookupSRV(ctx, Service, Proto, domain)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to look up SRV records for %s: %w", domain, err)
+	}
+	for _, srv := range order(srvs) {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			// "." means the service is explicitly unavailable.
+			continue
+		}
+		info.Endpoints = appendUnique(info.Endpoints, "https://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+
+	txts, err := r.dns.LookupTXT(ctx, Name(domain))
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to look up TXT records for %s: %w", domain, err)
+	}
+	// Bad records are skipped, so one stray lab entry does not take down
+	// bootstrap, and only reported when nothing usable is left.
+	var rejected []error
+	for _, txt := range txts {
+		key, value, ok := strings.Cut(txt, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case keyURL:
+			if err := r.checkURL(value); err != nil {
+				rejected = append(rejected, err)
+				continue
+			}
+			info.Endpoints = appendUnique(info.Endpoints, strings.TrimSuffix(value, "/"))
+		case keyJWKS:
+			if err := r.checkURL(value); err != nil {
+				rejected = append(rejected, err)
+				continue
+			}
+			if info.JWKSURL == "" {
+				info.JWKSURL = value
+			}
+		}
+	}
+
+	if len(info.Endpoints) == 0 {
+		if len(rejected) > 0 {
+			return nil, fmt.Errorf("%w: %s: %w", ErrNotFound, Name(domain), errors.Join(rejected...))
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, Name(domain))
+	}
+	return info, nil
+}
+
+func (r *Resolver) checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || (u.Scheme != "https" && !(r.allowHTTP && u.Scheme == "http")) {
+		return fmt.Errorf("%q is not an https URL", raw)
+	}
+	return nil
+}
+
+// order sorts SRV records by priority and, within a priority, by the
+// weighted random selection of RFC 2782.
+func order(srvs []*net.SRV) []*net.SRV {
+	sorted := append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	var out []*net.SRV
+	for len(sorted) > 0 {
+		end := 1
+		for end < len(sorted) && sorted[end].Priority == sorted[0].Priority {
+			end++
+		}
+		group := sorted[:end]
+		for len(group) > 0 {
+			total := 0
+			for _, s := range group {
+				total += int(s.Weight)
+			}
+			pick := 0
+			if total > 0 {
+				n := rand.IntN(total + 1)
+				for i, s := range group {
+					if n -= int(s.Weight); n <= 0 {
+						pick = i
+						break
+					}
+				}
+			}
+			out = append(out, group[pick])
+			group = append(group[:pick:pick], group[pick+1:]...)
+		}
+		sorted = sorted[end:]
+	}
+	return out
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
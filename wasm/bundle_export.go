@@ -0,0 +1,46 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// verifyJWKSBundle checks a root-signed JWKS bundle (see jwks.Bundle),
+// e.g. one fetched from a mirror or DNS, against the reef's root keys and
+// returns the verified key set. With ttlSeconds the keys are also loaded
+// into the module-level cache, as by loadJWKS.
+// Arguments: bundleJSON, rootJWKSJSON, reefID, [ttlSeconds]
+// Returns: { jwks: string, loaded: number } or { error: { code, message, retryable } }
+func verifyJWKSBundle(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: bundleJSON, rootJWKSJSON, reefID")
+	}
+
+	roots, err := verify.ParseKeys([]byte(args[1].String()))
+	if err != nil {
+		return errorResult(errJWKSMalformed, err.Error())
+	}
+	doc, err := jwks.VerifyJWKSBundle([]byte(args[0].String()), roots, args[2].String(), nil)
+	if err != nil {
+		return errorResult(errJWKSMalformed, err.Error())
+	}
+
+	loaded := 0
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		if args[3].Int() <= 0 {
+			return errorResult(errInvalidArgument, "ttlSeconds must be positive")
+		}
+		if loaded, err = keyCache.load(string(doc), time.Duration(args[3].Int())*time.Second); err != nil {
+			return errorResult(errJWKSMalformed, err.Error())
+		}
+	}
+	return map[string]interface{}{
+		"jwks":   string(doc),
+		"loaded": loaded,
+	}
+}
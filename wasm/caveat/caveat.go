@@ -0,0 +1,414 @@
+// Package caveat implements attenuable referral tokens in the style of
+// Biscuit. A token starts with an authority block signed by a colony root
+// key; any holder can append caveat blocks offline that shorten its
+// expiry, narrow its intent or pin it to one agent, without contacting
+// the issuer. Caveats only ever restrict: verification applies every
+// block in turn and rejects a block that would widen its predecessor.
+//
+// Every block names a fresh Ed25519 next key that signs the following
+// block, and the token carries the private half of the last one as its
+// proof. Whoever holds a token can therefore attenuate it further, but
+// cannot remove blocks, as the proof for an earlier block was discarded
+// when the next one was appended.
+package caveat
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// version is the token format version.
+const version = 1
+
+// DefaultMaxBlocks is the number of blocks, the authority block included,
+// a token may carry when Config.MaxBlocks is zero.
+const DefaultMaxBlocks = 16
+
+var (
+	// ErrMalformed is returned for tokens that cannot be decoded.
+	ErrMalformed = errors.New("malformed attenuable token")
+
+	// ErrWidens is returned when a caveat block widens its predecessor.
+	ErrWidens = errors.New("caveat widens the token")
+
+	// ErrTooManyBlocks is returned when a token exceeds the block limit.
+	ErrTooManyBlocks = errors.New("too many caveat blocks")
+
+	// ErrRevoked is returned when the token's jti is revoked.
+	ErrRevoked = errors.New("attenuable token revoked")
+)
+
+// Authority describes the token minted by the colony root key.
+type Authority struct {
+	ReefID   string
+	ColonyID string
+	AgentID  string
+	Intent   string
+	TTL      time.Duration
+}
+
+// Caveat restricts a token. Zero fields leave the token unchanged.
+type Caveat struct {
+	// ExpiresAt shortens the token's lifetime. A later time than the
+	// current expiry has no effect.
+	ExpiresAt time.Time
+
+	// Intent narrows the token's intent: it must equal the current
+	// intent or extend it with a ":"-separated suffix.
+	Intent string
+
+	// AgentID pins the token to one agent. It must match the current
+	// agent, if the token already names one.
+	AgentID string
+}
+
+// token is the wire form: the base64url JSON of this struct.
+type token struct {
+	Version int     `json:"v"`
+	KID     string  `json:"kid"`
+	Blocks  []block `json:"blocks"`
+
+	// Proof is the seed of the last block's next key.
+	Proof []byte `json:"proof"`
+}
+
+// block is a signed payload.
+type block struct {
+	Payload []byte `json:"p"`
+	Sig     []byte `json:"s"`
+}
+
+// payload is the content of a block. The authority block sets the
+// referral members, jti and iat; caveat blocks set any of exp, intent and
+// agent_id.
+type payload struct {
+	ReefID    string `json:"reef_id,omitempty"`
+	ColonyID  string `json:"colony_id,omitempty"`
+	AgentID   string `json:"agent_id,omitempty"`
+	Intent    string `json:"intent,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+
+	// Next is the public key signing the following block.
+	Next []byte `json:"next"`
+}
+
+// Mint creates a token for a with the colony root key s.
+func Mint(ctx context.Context, s signer.Signer, a Authority) (string, error) {
+	if a.TTL <= 0 {
+		return "", errors.New("TTL must be positive")
+	}
+	next, proof, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	p, err := json.Marshal(payload{
+		ReefID:    a.ReefID,
+		ColonyID:  a.ColonyID,
+		AgentID:   a.AgentID,
+		Intent:    a.Intent,
+		ID:        uuid.New().String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.TTL).Unix(),
+		Next:      next,
+	})
+	if err != nil {
+		return "", err
+	}
+	sig, err := s.Sign(ctx, p)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authority block: %w", err)
+	}
+	if !ed25519.Verify(s.Public(), p, sig) {
+		return "", signer.ErrBadSignature
+	}
+	return encode(&token{
+		Version: version,
+		KID:     s.KeyID(),
+		Blocks:  []block{{Payload: p, Sig: sig}},
+		Proof:   proof.Seed(),
+	})
+}
+
+// Attenuate appends c to tokenString. It needs no key beyond the proof
+// the token carries. The token is not verified; Attenuate only checks
+// that the result would pass the narrowing rules.
+func Attenuate(tokenString string, c Caveat) (string, error) {
+	t, err := decode(tokenString)
+	if err != nil {
+		return "", err
+	}
+	claims, err := t.claims()
+	if err != nil {
+		return "", err
+	}
+	add := payload{Intent: c.Intent, AgentID: c.AgentID}
+	if !c.ExpiresAt.IsZero() {
+		add.ExpiresAt = c.ExpiresAt.Unix()
+	}
+	if err := restrict(claims, &add); err != nil {
+		return "", err
+	}
+
+	next, proof, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	add.Next = next
+	p, err := json.Marshal(add)
+	if err != nil {
+		return "", err
+	}
+	key := ed25519.NewKeyFromSeed(t.Proof)
+	prev := t.Blocks[len(t.Blocks)-1]
+	t.Blocks = append(t.Blocks, block{Payload: p, Sig: ed25519.Sign(key, signed(p, prev.Sig))})
+	t.Proof = proof.Seed()
+	return encode(t)
+}
+
+// Inspect returns the effective claims of tokenString without verifying
+// it. The result must only be used for routing, never for authorization.
+func Inspect(tokenString string) (*jwt.ReferralClaims, error) {
+	t, err := decode(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return t.claims()
+}
+
+// IsToken reports whether tokenString is in the attenuable format rather
+// than a JWT, which always contains dots.
+func IsToken(tokenString string) bool {
+	return tokenString != "" && !strings.Contains(tokenString, ".")
+}
+
+// Config holds the configuration for a Verifier.
+type Config struct {
+	// Root resolves the colony root keys signing authority blocks.
+	// Required.
+	Root verify.KeySource
+
+	// Clock is the time expiry is checked against. Defaults to
+	// clock.System.
+	Clock clock.Clock
+
+	// Leeway tolerates clock skew when checking expiry.
+	Leeway time.Duration
+
+	// MaxBlocks bounds the blocks of a token. Defaults to
+	// DefaultMaxBlocks.
+	MaxBlocks int
+
+	// IsRevoked, when set, is consulted for the token's reef and jti.
+	IsRevoked func(ctx context.Context, reefID, jti string) (bool, error)
+}
+
+// Verifier validates attenuable tokens. It satisfies registry.Verifier,
+// returning the effective claims after every caveat.
+type Verifier struct {
+	cfg Config
+}
+
+// New creates a Verifier from cfg.
+func New(cfg Config) (*Verifier, error) {
+	if cfg.Root == nil {
+		return nil, errors.New("root key source is required")
+	}
+	if cfg.MaxBlocks == 0 {
+		cfg.MaxBlocks = DefaultMaxBlocks
+	}
+	cfg.Clock = clock.Or(cfg.Clock)
+	return &Verifier{cfg: cfg}, nil
+}
+
+// ValidateReferralTicket implements registry.Verifier.
+func (v *Verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	return v.Verify(context.Background(), tokenString)
+}
+
+// TicketVerifier validates referral tickets in other formats.
+// registry.Verifier satisfies it.
+type TicketVerifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Wrap returns a TicketVerifier validating attenuable tokens with v and
+// passing any other token to next.
+func (v *Verifier) Wrap(next TicketVerifier) TicketVerifier {
+	return &wrapped{v: v, next: next}
+}
+
+type wrapped struct {
+	v    *Verifier
+	next TicketVerifier
+}
+
+func (w *wrapped) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	if IsToken(tokenString) {
+		return w.v.ValidateReferralTicket(tokenString)
+	}
+	return w.next.ValidateReferralTicket(tokenString)
+}
+
+// Verify checks the signature chain of tokenString, applies its caveats
+// and returns the effective claims. Expired tokens yield an error
+// wrapping gojwt.ErrTokenExpired and bad signatures one wrapping
+// gojwt.ErrTokenSignatureInvalid.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*jwt.ReferralClaims, error) {
+	t, err := decode(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Blocks) > v.cfg.MaxBlocks {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooManyBlocks, len(t.Blocks), v.cfg.MaxBlocks)
+	}
+
+	root, err := verify.Ed25519Key(v.cfg.Root, t.KID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", gojwt.ErrTokenUnverifiable, err)
+	}
+	key := root
+	var prev []byte
+	for i, b := range t.Blocks {
+		msg := b.Payload
+		if i > 0 {
+			msg = signed(b.Payload, prev)
+		}
+		if !ed25519.Verify(key, msg, b.Sig) {
+			return nil, fmt.Errorf("%w: block %d", gojwt.ErrTokenSignatureInvalid, i)
+		}
+		var p payload
+		if err := json.Unmarshal(b.Payload, &p); err != nil || len(p.Next) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: block %d", ErrMalformed, i)
+		}
+		key, prev = p.Next, b.Sig
+	}
+	if !ed25519.NewKeyFromSeed(t.Proof).Public().(ed25519.PublicKey).Equal(key) {
+		return nil, fmt.Errorf("%w: proof does not match the last block", gojwt.ErrTokenSignatureInvalid)
+	}
+
+	claims, err := t.claims()
+	if err != nil {
+		return nil, err
+	}
+	if !v.cfg.Clock.Now().Before(claims.ExpiresAt.Add(v.cfg.Leeway)) {
+		return nil, fmt.Errorf("%w: %w", gojwt.ErrTokenInvalidClaims, gojwt.ErrTokenExpired)
+	}
+	if v.cfg.IsRevoked != nil {
+		revoked, err := v.cfg.IsRevoked(ctx, claims.ReefID, claims.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check revocation: %w", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("%w: %s", ErrRevoked, claims.ID)
+		}
+	}
+	return claims, nil
+}
+
+// claims folds the blocks of t into effective claims. It does not check
+// signatures.
+func (t *token) claims() (*jwt.ReferralClaims, error) {
+	var claims *jwt.ReferralClaims
+	for i, b := range t.Blocks {
+		var p payload
+		if err := json.Unmarshal(b.Payload, &p); err != nil {
+			return nil, fmt.Errorf("%w: block %d", ErrMalformed, i)
+		}
+		if i == 0 {
+			if p.ExpiresAt == 0 || p.ID == "" {
+				return nil, fmt.Errorf("%w: authority block lacks exp or jti", ErrMalformed)
+			}
+			claims = &jwt.ReferralClaims{
+				ReefID:   p.ReefID,
+				ColonyID: p.ColonyID,
+				AgentID:  p.AgentID,
+				Intent:   p.Intent,
+				RegisteredClaims: gojwt.RegisteredClaims{
+					ID:        p.ID,
+					Issuer:    jwt.DefaultIssuer,
+					Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+					IssuedAt:  gojwt.NewNumericDate(time.Unix(p.IssuedAt, 0)),
+					ExpiresAt: gojwt.NewNumericDate(time.Unix(p.ExpiresAt, 0)),
+				},
+			}
+			continue
+		}
+		if p.ReefID != "" || p.ColonyID != "" || p.ID != "" {
+			return nil, fmt.Errorf("%w: block %d sets authority members", ErrWidens, i)
+		}
+		if err := restrict(claims, &p); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return claims, nil
+}
+
+// restrict applies the caveats of p to claims.
+func restrict(claims *jwt.ReferralClaims, p *payload) error {
+	if p.Intent != "" {
+		if p.Intent != claims.Intent && !strings.HasPrefix(p.Intent, claims.Intent+":") {
+			return fmt.Errorf("%w: intent %q is not within %q", ErrWidens, p.Intent, claims.Intent)
+		}
+		claims.Intent = p.Intent
+	}
+	if p.AgentID != "" {
+		if claims.AgentID != "" && p.AgentID != claims.AgentID {
+			return fmt.Errorf("%w: agent %q differs from %q", ErrWidens, p.AgentID, claims.AgentID)
+		}
+		claims.AgentID = p.AgentID
+	}
+	if p.ExpiresAt != 0 && p.ExpiresAt < claims.ExpiresAt.Unix() {
+		claims.ExpiresAt = gojwt.NewNumericDate(time.Unix(p.ExpiresAt, 0))
+	}
+	return nil
+}
+
+// signed is the message signed for a caveat block: its payload followed
+// by the previous block's signature, tying it to that block.
+func signed(payload, prevSig []byte) []byte {
+	return append(append([]byte{}, payload...), prevSig...)
+}
+
+func encode(t *token) (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decode(tokenString string) (*token, error) {
+	data, err := base64.RawURLEncoding.DecodeString(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	var t token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if t.Version != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformed, t.Version)
+	}
+	if len(t.Blocks) == 0 || len(t.Proof) != ed25519.SeedSize {
+		return nil, ErrMalformed
+	}
+	return &t, nil
+}
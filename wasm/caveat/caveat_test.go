@@ -0,0 +1,204 @@
+package caveat_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/caveat"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// wire mirrors the token encoding, so tests can forge blocks.
+type wire struct {
+	Version int    `json:"v"`
+	KID     string `json:"kid"`
+	Blocks  []struct {
+		Payload []byte `json:"p"`
+		Sig     []byte `json:"s"`
+	} `json:"blocks"`
+	Proof []byte `json:"proof"`
+}
+
+func unwrap(t *testing.T, token string) *wire {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w wire
+	if err := json.Unmarshal(data, &w); err != nil {
+		t.Fatal(err)
+	}
+	return &w
+}
+
+func wrap(t *testing.T, w *wire) string {
+	t.Helper()
+	data, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// mint returns a verifier on now and a token of "onboard" tickets for
+// any agent of the colony, living an hour.
+func mint(t *testing.T, now clock.Clock, cfg caveat.Config) (*caveat.Verifier, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := verify.ParseKeys(fmt.Appendf(nil, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"root","use":"sig","x":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(pub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Root, cfg.Clock = keys, now
+	v, err := caveat.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := caveat.Mint(context.Background(), signer.Local("root", priv), caveat.Authority{
+		ReefID: "reef", ColonyID: "colony", Intent: "onboard", TTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, token
+}
+
+func attenuate(t *testing.T, token string, c caveat.Caveat) string {
+	t.Helper()
+	out, err := caveat.Attenuate(token, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCaveats(t *testing.T) {
+	ctx := context.Background()
+	v, token := mint(t, nil, caveat.Config{})
+	soon := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	narrowed := attenuate(t, token, caveat.Caveat{Intent: "onboard:join"})
+	narrowed = attenuate(t, narrowed, caveat.Caveat{AgentID: "agent", ExpiresAt: soon})
+	// A later expiry than the current one has no effect.
+	narrowed = attenuate(t, narrowed, caveat.Caveat{ExpiresAt: soon.Add(time.Hour)})
+
+	claims, err := v.Verify(ctx, narrowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Intent != "onboard:join" || claims.AgentID != "agent" || !claims.ExpiresAt.Equal(soon) {
+		t.Fatalf("intent %q, agent %q, exp %v; want onboard:join, agent, %v", claims.Intent, claims.AgentID, claims.ExpiresAt, soon)
+	}
+	if claims.ReefID != "reef" || claims.ColonyID != "colony" {
+		t.Fatalf("reef %q, colony %q", claims.ReefID, claims.ColonyID)
+	}
+
+	for name, c := range map[string]caveat.Caveat{
+		"wider intent":  {Intent: "onboard"},
+		"other intent":  {Intent: "register"},
+		"prefix intent": {Intent: "onboard:joiner"},
+		"other agent":   {AgentID: "other"},
+	} {
+		if _, err := caveat.Attenuate(narrowed, c); !errors.Is(err, caveat.ErrWidens) {
+			t.Errorf("%s: %v, want ErrWidens", name, err)
+		}
+	}
+}
+
+// TestForgedBlocks appends properly signed blocks that widen the token,
+// as Attenuate refuses to, and removes blocks, and checks that Verify
+// rejects each.
+func TestForgedBlocks(t *testing.T) {
+	ctx := context.Background()
+	v, token := mint(t, nil, caveat.Config{})
+	pinned := attenuate(t, token, caveat.Caveat{Intent: "onboard:join", AgentID: "agent"})
+
+	for name, widen := range map[string]map[string]any{
+		"intent": {"intent": "onboard"},
+		"agent":  {"agent_id": "other"},
+		"reef":   {"reef_id": "other"},
+		"jti":    {"jti": "other"},
+	} {
+		w := unwrap(t, pinned)
+		next, proof, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		widen["next"] = []byte(next)
+		p, err := json.Marshal(widen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev := w.Blocks[len(w.Blocks)-1].Sig
+		sig := ed25519.Sign(ed25519.NewKeyFromSeed(w.Proof), append(append([]byte{}, p...), prev...))
+		w.Blocks = append(w.Blocks, struct {
+			Payload []byte `json:"p"`
+			Sig     []byte `json:"s"`
+		}{p, sig})
+		w.Proof = proof.Seed()
+		if _, err := v.Verify(ctx, wrap(t, w)); !errors.Is(err, caveat.ErrWidens) {
+			t.Errorf("block widening %s: %v, want ErrWidens", name, err)
+		}
+	}
+
+	// Dropping the caveat block leaves a proof for a key no block names.
+	w := unwrap(t, pinned)
+	w.Blocks = w.Blocks[:1]
+	if _, err := v.Verify(ctx, wrap(t, w)); !errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+		t.Errorf("caveat removed: %v, want ErrTokenSignatureInvalid", err)
+	}
+	w = unwrap(t, pinned)
+	w.Blocks[1].Payload = []byte(`{"intent":"onboard:join","next":"` + base64.StdEncoding.EncodeToString(make([]byte, 32)) + `"}`)
+	if _, err := v.Verify(ctx, wrap(t, w)); !errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+		t.Errorf("caveat altered: %v, want ErrTokenSignatureInvalid", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	now := clock.NewFake(time.Now())
+	revoked := false
+	v, token := mint(t, now, caveat.Config{
+		MaxBlocks: 3,
+		IsRevoked: func(_ context.Context, reefID, jti string) (bool, error) {
+			return revoked && reefID == "reef", nil
+		},
+	})
+
+	short := attenuate(t, token, caveat.Caveat{ExpiresAt: now.Now().Add(time.Minute)})
+	if _, err := v.Verify(ctx, short); err != nil {
+		t.Fatal(err)
+	}
+	now.Advance(2 * time.Minute)
+	if _, err := v.Verify(ctx, short); !errors.Is(err, gojwt.ErrTokenExpired) {
+		t.Fatalf("past its caveat's expiry: %v, want ErrTokenExpired", err)
+	}
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("unattenuated token: %v", err)
+	}
+
+	long := attenuate(t, attenuate(t, attenuate(t, token, caveat.Caveat{}), caveat.Caveat{}), caveat.Caveat{})
+	if _, err := v.Verify(ctx, long); !errors.Is(err, caveat.ErrTooManyBlocks) {
+		t.Fatalf("4 blocks with limit 3: %v, want ErrTooManyBlocks", err)
+	}
+
+	revoked = true
+	if _, err := v.Verify(ctx, token); !errors.Is(err, caveat.ErrRevoked) {
+		t.Fatalf("revoked token: %v, want ErrRevoked", err)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/caveat"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// createAttenuableTicket mints an attenuable token (see package caveat)
+// signed with the colony root key. agentID may be empty for holders to
+// pin with attenuateTicket.
+// Arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds
+// Returns: { token: string } or { error: { code, message, retryable } }
+func createAttenuableTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 7 {
+		return errorResult(errInvalidArgument, "expected 7 arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds")
+	}
+
+	privateKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	a := caveat.Authority{
+		ReefID:   args[2].String(),
+		ColonyID: args[3].String(),
+		AgentID:  args[4].String(),
+		Intent:   args[5].String(),
+		TTL:      time.Duration(args[6].Int()) * time.Second,
+	}
+	token, err := caveat.Mint(context.Background(), signer.Local(args[1].String(), privateKey), a)
+	if err != nil {
+		return errorResult(errTokenSign, "failed to create token: "+err.Error())
+	}
+	return recordIssued(map[string]interface{}{
+		"token": token,
+	}, a.ReefID, a.ColonyID, a.AgentID, a.Intent)
+}
+
+// attenuateTicket appends a caveat to an attenuable token, offline.
+// Arguments: token, { expiresAt?: number (Unix seconds), intent?: string, agentID?: string }
+// Returns: { token: string } or { error: { code, message, retryable } }
+func attenuateTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 || args[1].Type() != js.TypeObject {
+		return errorResult(errInvalidArgument, "expected 2 arguments: token, caveat")
+	}
+
+	var c caveat.Caveat
+	if exp := args[1].Get("expiresAt"); exp.Type() == js.TypeNumber {
+		c.ExpiresAt = time.Unix(int64(exp.Float()), 0)
+	}
+	if intent := args[1].Get("intent"); intent.Type() == js.TypeString {
+		c.Intent = intent.String()
+	}
+	if agent := args[1].Get("agentID"); agent.Type() == js.TypeString {
+		c.AgentID = agent.String()
+	}
+
+	token, err := caveat.Attenuate(args[0].String(), c)
+	if err != nil {
+		return caveatError(err).result()
+	}
+	return map[string]interface{}{
+		"token": token,
+	}
+}
+
+// verifyAttenuatedTicket verifies an attenuable token against the root
+// keys in jwksJSON or the loadJWKS cache, and the loadRevocations
+// snapshot and loadPolicy policy, and returns its effective claims.
+// Arguments: token, [jwksJSON]
+// Returns: { valid: true, reefID, colonyID, agentID, intent, exp } or { error: { code, message, retryable } }
+func verifyAttenuatedTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected at least 1 argument: token, [jwksJSON]")
+	}
+
+	cfg := caveat.Config{
+		Root: keyCache,
+		IsRevoked: func(_ context.Context, reefID, jti string) (bool, error) {
+			return isRevoked(reefID, jti), nil
+		},
+	}
+	if len(args) > 1 && !args[1].IsUndefined() && !args[1].IsNull() {
+		keys, err := verify.ParseKeys([]byte(args[1].String()))
+		if err != nil {
+			return errorResult(errJWKSMalformed, err.Error())
+		}
+		cfg.Root = keys
+	}
+	verifier, err := caveat.New(cfg)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	claims, err := verifier.Verify(context.Background(), args[0].String())
+	if err != nil {
+		return caveatError(err).result()
+	}
+	if perr := checkPolicy(claims); perr != nil {
+		return perr.result()
+	}
+	return map[string]interface{}{
+		"valid":    true,
+		"reefID":   claims.ReefID,
+		"colonyID": claims.ColonyID,
+		"agentID":  claims.AgentID,
+		"intent":   claims.Intent,
+		"exp":      claims.ExpiresAt.Unix(),
+	}
+}
+
+// caveatError maps an attenuable token failure to an exportError.
+func caveatError(err error) *exportError {
+	switch {
+	case errors.Is(err, caveat.ErrRevoked):
+		return newError(errTokenRevoked, err.Error())
+	case errors.Is(err, caveat.ErrWidens), errors.Is(err, caveat.ErrTooManyBlocks):
+		return newError(errDelegation, err.Error())
+	default:
+		return classifyTokenError(err)
+	}
+}
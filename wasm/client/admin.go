@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+)
+
+// The admin API acts on the reef of the ticket, which the ticket source
+// is asked for with the rbac.IntentAdmin intent. Its roles must grant the
+// operation's permission, or the call fails with rbac.ErrForbidden.
+
+// AdminAgents returns every agent of the reef, or of colonyID when not
+// empty, including those whose lease expired within the grace period.
+func (c *Client) AdminAgents(ctx context.Context, colonyID string) ([]*registry.Record, error) {
+	path := "/v1/admin/agents"
+	if colonyID != "" {
+		path += "?" + url.Values{"colony": {colonyID}}.Encode()
+	}
+	var out registry.Page
+	if err := c.do(ctx, http.MethodGet, path, rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Records, nil
+}
+
+// Evict removes agentID from the registry.
+func (c *Client) Evict(ctx context.Context, agentID string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/admin/agents/"+url.PathEscape(agentID), rbac.IntentAdmin, nil, nil)
+}
+
+// ExpireLease ends agentID's lease now and returns the updated record.
+// The agent must renew within the grace period or be evicted.
+func (c *Client) ExpireLease(ctx context.Context, agentID string) (*registry.Record, error) {
+	var out registry.Record
+	path := "/v1/admin/agents/" + url.PathEscape(agentID) + "/expire"
+	if err := c.do(ctx, http.MethodPost, path, rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ColonyQuotas returns the default colony quota and the quotas set for
+// colonies of the reef.
+func (c *Client) ColonyQuotas(ctx context.Context) (registry.ColonyQuota, []*registry.ColonyQuota, error) {
+	var out struct {
+		Default registry.ColonyQuota    `json:"default"`
+		Quotas  []*registry.ColonyQuota `json:"quotas"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/quotas", rbac.IntentAdmin, nil, &out); err != nil {
+		return registry.ColonyQuota{}, nil, err
+	}
+	return out.Default, out.Quotas, nil
+}
+
+// ColonyQuota returns colonyID's quota and how much of it the colony uses
+// on the server.
+func (c *Client) ColonyQuota(ctx context.Context, colonyID string) (*registry.QuotaStatus, error) {
+	var out registry.QuotaStatus
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/quotas/"+url.PathEscape(colonyID), rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetColonyQuota sets the quota of q.ColonyID in place of the default.
+// q.ReefID is taken from the ticket.
+func (c *Client) SetColonyQuota(ctx context.Context, q *registry.ColonyQuota) (*registry.ColonyQuota, error) {
+	var out registry.ColonyQuota
+	if err := c.do(ctx, http.MethodPut, "/v1/admin/quotas/"+url.PathEscape(q.ColonyID), rbac.IntentAdmin, q, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ResetColonyQuota returns colonyID to the default quota.
+func (c *Client) ResetColonyQuota(ctx context.Context, colonyID string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/admin/quotas/"+url.PathEscape(colonyID), rbac.IntentAdmin, nil, nil)
+}
+
+// RotateKeys makes the server sign with a new key at once and returns its
+// key ID. The previous key keeps verifying for the server's overlap
+// window.
+func (c *Client) RotateKeys(ctx context.Context) (string, error) {
+	var out struct {
+		KeyID string `json:"kid"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/admin/keys/rotate", rbac.IntentAdmin, nil, &out); err != nil {
+		return "", err
+	}
+	return out.KeyID, nil
+}
+
+// AdminRevocations returns the revoked ticket IDs and when each
+// revocation lapses.
+func (c *Client) AdminRevocations(ctx context.Context) ([]revocation.Revocation, error) {
+	var out struct {
+		Revocations []revocation.Revocation `json:"revocations"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/revocations", rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Revocations, nil
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Allowlist returns the endpoint allowlist published for colonyID of the
+// ticket's reef. Callers that do not trust the server verify its Token
+// with allowlist.Parse.
+func (c *Client) Allowlist(ctx context.Context, colonyID string) (*allowlist.Published, error) {
+	var out allowlist.Published
+	if err := c.do(ctx, http.MethodGet, allowlistPath(colonyID), registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PublishAllowlist makes token, an allowlist signed with allowlist.Sign,
+// the endpoint allowlist of colonyID.
+func (c *Client) PublishAllowlist(ctx context.Context, colonyID, token string) (*allowlist.Published, error) {
+	var out allowlist.Published
+	body := map[string]string{"token": token}
+	if err := c.do(ctx, http.MethodPut, allowlistPath(colonyID), registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func allowlistPath(colonyID string) string {
+	return "/v1/colonies/" + url.PathEscape(colonyID) + "/allowlist"
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrCacheMiss is returned by Cache.Load for keys never stored.
+var ErrCacheMiss = errors.New("not cached")
+
+// Cache persists what a Client learned from the server: agent lookups,
+// colony, topic and service listings, and the server's key set. Use one
+// Cache per server. DirCache keeps it on disk and, in the Wasm build,
+// IndexedDBCache in the browser.
+type Cache interface {
+	// Load returns the value stored under key, or ErrCacheMiss.
+	Load(ctx context.Context, key string) ([]byte, error)
+
+	// Store replaces the value stored under key.
+	Store(ctx context.Context, key string, value []byte) error
+}
+
+// StaleError accompanies results served from the Cache because the
+// server could not be reached. The results are those the server last
+// returned; records in them may since have changed or expired.
+type StaleError struct {
+	// CachedAt is when the server returned the results.
+	CachedAt time.Time
+
+	// Err is why the server could not be reached.
+	Err error
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("serving results cached %s ago: %v", e.Age().Round(time.Second), e.Err)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// Age is how old the cached results are.
+func (e *StaleError) Age() time.Duration {
+	return time.Since(e.CachedAt)
+}
+
+// Stale returns the StaleError of err, when err only reports that the
+// results were served from the cache.
+func Stale(err error) (*StaleError, bool) {
+	var stale *StaleError
+	ok := errors.As(err, &stale)
+	return stale, ok
+}
+
+// cacheEntry is the stored form of a cached result.
+type cacheEntry struct {
+	CachedAt time.Time       `json:"cached_at"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// cached calls fetch, which fills out from the server, and stores out in
+// the cache under key. When the server cannot be reached, out is filled
+// from the cache instead and a *StaleError is returned.
+func (c *Client) cached(ctx context.Context, key string, out interface{}, fetch func() error) error {
+	err := fetch()
+	if c.cache == nil {
+		return err
+	}
+	if err == nil {
+		if value, err := json.Marshal(out); err == nil {
+			c.cacheStore(ctx, key, value)
+		}
+		return nil
+	}
+	cachedAt, value, ok := c.cacheLoad(ctx, key, err)
+	if !ok || json.Unmarshal(value, out) != nil {
+		return err
+	}
+	return &StaleError{CachedAt: cachedAt, Err: err}
+}
+
+// cacheStore stores value under key, stamped with the current time.
+// Failures to store are ignored: the cache is best effort.
+func (c *Client) cacheStore(ctx context.Context, key string, value []byte) {
+	data, err := json.Marshal(cacheEntry{CachedAt: time.Now().UTC(), Value: value})
+	if err == nil {
+		_ = c.cache.Store(ctx, key, data)
+	}
+}
+
+// cacheLoad returns the value cached under key, provided cause, the
+// error of the request that missed, means the server was unreachable.
+func (c *Client) cacheLoad(ctx context.Context, key string, cause error) (time.Time, []byte, bool) {
+	if c.cache == nil || !unreachable(cause) {
+		return time.Time{}, nil, false
+	}
+	data, err := c.cache.Load(context.WithoutCancel(ctx), key)
+	if err != nil {
+		return time.Time{}, nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return time.Time{}, nil, false
+	}
+	return entry.CachedAt, entry.Value, true
+}
+
+// unreachable reports whether err means the server could not answer,
+// rather than that it answered with an error.
+func unreachable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
+
+// DirCache returns a Cache keeping one file per key in dir, which is
+// created on first use.
+func DirCache(dir string) Cache {
+	return dirCache(dir)
+}
+
+type dirCache string
+
+func (d dirCache) path(key string) string {
+	return filepath.Join(string(d), url.PathEscape(key)+".json")
+}
+
+// Load implements Cache.
+func (d dirCache) Load(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+// Store implements Cache. The file is replaced atomically, so readers
+// never see a partial write.
+func (d dirCache) Store(_ context.Context, key string, value []byte) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(string(d), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
@@ -0,0 +1,105 @@
+//go:build js
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall/js"
+)
+
+// idbStore is the object store cached values are kept in.
+const idbStore = "entries"
+
+// IndexedDBCache returns a Cache keeping values in the browser's
+// IndexedDB database name, which is created on first use.
+func IndexedDBCache(name string) Cache {
+	return &indexedDBCache{name: name}
+}
+
+type indexedDBCache struct {
+	name string
+
+	mu  sync.Mutex
+	db  js.Value
+	err error
+}
+
+// open returns the database, opening it on first use.
+func (c *indexedDBCache) open() (js.Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.db.IsUndefined() || c.err != nil {
+		return c.db, c.err
+	}
+	idb := js.Global().Get("indexedDB")
+	if idb.IsUndefined() {
+		c.err = errors.New("client: IndexedDB is not available")
+		return js.Undefined(), c.err
+	}
+	req := idb.Call("open", c.name, 1)
+	upgrade := js.FuncOf(func(js.Value, []js.Value) interface{} {
+		req.Get("result").Call("createObjectStore", idbStore)
+		return nil
+	})
+	defer upgrade.Release()
+	req.Set("onupgradeneeded", upgrade)
+	c.db, c.err = idbWait(req)
+	return c.db, c.err
+}
+
+// Load implements Cache.
+func (c *indexedDBCache) Load(_ context.Context, key string) ([]byte, error) {
+	db, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	store := db.Call("transaction", idbStore, "readonly").Call("objectStore", idbStore)
+	v, err := idbWait(store.Call("get", key))
+	if err != nil {
+		return nil, err
+	}
+	if v.Type() != js.TypeString {
+		return nil, ErrCacheMiss
+	}
+	return []byte(v.String()), nil
+}
+
+// Store implements Cache.
+func (c *indexedDBCache) Store(_ context.Context, key string, value []byte) error {
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	store := db.Call("transaction", idbStore, "readwrite").Call("objectStore", idbStore)
+	_, err = idbWait(store.Call("put", string(value), key))
+	return err
+}
+
+// idbWait blocks until the IDBRequest req succeeds or fails, returning
+// its result. Like jsutil.Await, it must not be called from a js.FuncOf
+// callback.
+func idbWait(req js.Value) (js.Value, error) {
+	done := make(chan error, 1)
+	onSuccess := js.FuncOf(func(js.Value, []js.Value) interface{} {
+		done <- nil
+		return nil
+	})
+	defer onSuccess.Release()
+	onError := js.FuncOf(func(js.Value, []js.Value) interface{} {
+		msg := "IndexedDB request failed"
+		if e := req.Get("error"); e.Truthy() {
+			msg += ": " + e.Get("message").String()
+		}
+		done <- errors.New(msg)
+		return nil
+	})
+	defer onError.Release()
+	req.Set("onsuccess", onSuccess)
+	req.Set("onerror", onError)
+	if err := <-done; err != nil {
+		return js.Undefined(), err
+	}
+	return req.Get("result"), nil
+}
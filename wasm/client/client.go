@@ -0,0 +1,618 @@
+// Package client is a Go SDK for the discovery HTTP/JSON API served by
+// corald and the Worker. It attaches referral tickets, retries transient
+// failures with exponential backoff and can keep a registration alive with
+// a background heartbeat.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/bootstrap"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// TicketSource supplies the referral ticket attached to a request. intent
+// is registry.IntentRegister for registration and deregistration,
+// registry.IntentRenew for lease renewal, revocation.IntentRevoke for
+// revocation, registry.IntentBackup and registry.IntentRestore for
+// snapshots, threshold.IntentOperate for threshold signing sessions and
+// rbac.IntentAdmin for the admin API; read requests ask for
+// registry.IntentRegister.
+type TicketSource interface {
+	Ticket(ctx context.Context, intent string) (string, error)
+}
+
+// TicketFunc adapts a function to a TicketSource.
+type TicketFunc func(ctx context.Context, intent string) (string, error)
+
+// Ticket implements TicketSource.
+func (f TicketFunc) Ticket(ctx context.Context, intent string) (string, error) {
+	return f(ctx, intent)
+}
+
+// StaticTicket returns a TicketSource that always yields ticket.
+func StaticTicket(ticket string) TicketSource {
+	return TicketFunc(func(context.Context, string) (string, error) {
+		return ticket, nil
+	})
+}
+
+// Config holds the configuration for a Client.
+type Config struct {
+	// BaseURL is the discovery server root, e.g. "https://discovery.coral.io".
+	BaseURL string
+
+	// Endpoints are further servers of the same discovery service, in
+	// order of preference after BaseURL. A request that cannot reach an
+	// endpoint, or is answered with a server error, marks it down and is
+	// retried against the next; down endpoints are passed over for 30s or
+	// until RunHealthChecks finds them healthy. Connect fills them in with
+	// the other endpoints published in DNS.
+	Endpoints []string
+
+	// HedgeDelay, when set along with Endpoints, hedges lookups and
+	// listings: when the preferred endpoint has not answered within
+	// HedgeDelay, the request is also sent to the next healthy endpoint
+	// and the first answer is used.
+	HedgeDelay time.Duration
+
+	// Domain is looked up in DNS by Connect when BaseURL is empty.
+	Domain string
+
+	// Bootstrap resolves Domain; bootstrap.New's defaults when nil.
+	Bootstrap *bootstrap.Resolver
+
+	// Tickets supplies referral tickets. Required unless ClientCert or
+	// APIKey is set; requests then carry no ticket.
+	Tickets TicketSource
+
+	// APIKey, when set, is sent in the Coral-API-Key header of every
+	// authenticated request in place of a ticket. The server grants the
+	// key a fixed identity, so the key must be registered with the
+	// intents of the requests made with it.
+	APIKey string
+
+	// HTTPClient is used for requests. Defaults to a client with a 30s
+	// timeout; Watch uses a copy without a timeout.
+	HTTPClient *http.Client
+
+	// QUIC, when set, sends requests over HTTP/3 instead, replacing
+	// HTTPClient's transport. BaseURL must be an https URL of a server
+	// listening for QUIC, such as corald with -quic-addr.
+	QUIC *QUICConfig
+
+	// Backoff controls retries of transient failures. Defaults to
+	// DefaultBackoff.
+	Backoff *Backoff
+
+	// ProofKey is the holder key of tickets bound with a cnf claim. When
+	// set, every request carries a proof of possession signed with it
+	// over the latest server nonce.
+	ProofKey ed25519.PrivateKey
+
+	// RecordKey, when set, signs the records Register sends (see
+	// registry.Record.Sign), so peers can check them against the agent's
+	// key. Defaults to ProofKey, which registries require to sign the
+	// records of bound tickets.
+	RecordKey ed25519.PrivateKey
+
+	// SVID supplies the JWT-SVID sent in the Coral-SVID header with
+	// tickets bound to a SPIFFE ID. It is called for every request, so
+	// it should return the workload's current SVID.
+	SVID func(ctx context.Context) (string, error)
+
+	// ClientCert supplies the mesh certificate presented over mTLS (see
+	// package meshca), such as CertRenewal.GetClientCertificate. It is
+	// called for every handshake, so it should return the agent's
+	// current certificate. The server then authenticates the agent
+	// without a ticket where a register ticket would do, and requires
+	// tickets sent along to name the same agent. HTTPClient's transport,
+	// if set, must be an *http.Transport.
+	ClientCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// Metrics counts and times the client's requests when set.
+	Metrics *metrics.Set
+
+	// Cache, when set, keeps the results of Lookup, LookupReef, Query,
+	// Topic and Service and the key set of ServerKeys. While the server
+	// cannot be reached, they are answered from it along with a
+	// *StaleError (see Stale).
+	Cache Cache
+}
+
+// Client is a typed discovery API client. It is safe for concurrent use.
+type Client struct {
+	baseURL   *url.URL
+	endpoints *endpointSet
+	hedge     time.Duration
+	tickets   TicketSource
+	apiKey    string
+	http      *http.Client
+	stream    *http.Client
+	backoff   Backoff
+	prover    *prover // nil without Config.ProofKey
+	recordKey ed25519.PrivateKey
+	svid      func(ctx context.Context) (string, error)
+	jwksURL   string // published in DNS; see JWKSURL
+	metrics   instruments
+	cache     Cache
+
+	// served is the schema version of the server's last response, 0
+	// before the first (see bodyVersion).
+	served atomic.Int32
+}
+
+// New creates a Client from cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("base URL is required; use Connect to bootstrap from DNS")
+	}
+	if cfg.Tickets == nil && cfg.ClientCert == nil && cfg.APIKey == "" {
+		return nil, errors.New("ticket source, client certificate or API key is required")
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	urls := []*url.URL{base}
+	for _, endpoint := range cfg.Endpoints {
+		u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+		}
+		urls = append(urls, u)
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.QUIC != nil {
+		quicConf := *cfg.QUIC
+		if cfg.ClientCert != nil {
+			quicConf.TLS = withClientCert(quicConf.TLS, cfg.ClientCert)
+		}
+		h3 := *httpClient
+		h3.Transport = newQUICTransport(quicConf)
+		httpClient = &h3
+	} else if cfg.ClientCert != nil {
+		if httpClient, err = withClientTransport(httpClient, cfg.ClientCert); err != nil {
+			return nil, err
+		}
+	}
+	stream := *httpClient
+	stream.Timeout = 0
+
+	backoff := DefaultBackoff
+	if cfg.Backoff != nil {
+		backoff = *cfg.Backoff
+	}
+
+	c := &Client{
+		baseURL:   base,
+		endpoints: newEndpointSet(urls),
+		hedge:     cfg.HedgeDelay,
+		tickets:   cfg.Tickets,
+		apiKey:    cfg.APIKey,
+		http:      httpClient,
+		stream:    &stream,
+		backoff:   backoff,
+		svid:      cfg.SVID,
+		metrics:   newInstruments(cfg.Metrics),
+		cache:     cfg.Cache,
+	}
+	if cfg.ProofKey != nil {
+		c.prover = &prover{key: cfg.ProofKey}
+	}
+	c.recordKey = cfg.RecordKey
+	if c.recordKey == nil {
+		c.recordKey = cfg.ProofKey
+	}
+	return c, nil
+}
+
+// Register creates or updates rec and returns the stored record. With
+// Config.RecordKey or Config.ProofKey set, it sends rec signed with that
+// key, sequenced by the current time; rec itself is left unchanged. A
+// record naming a Seq, such as one read with Lookup and modified, is
+// registered only if the stored record is still at it; otherwise
+// Register fails with registry.ErrStaleRecord and the caller should read
+// the record again.
+func (c *Client) Register(ctx context.Context, rec *registry.Record) (*registry.Record, error) {
+	if c.recordKey != nil {
+		rec = rec.Clone()
+		if err := rec.Sign(c.recordKey, uint64(time.Now().UnixNano())); err != nil {
+			return nil, err
+		}
+	}
+	var out registry.Record
+	if err := c.do(ctx, http.MethodPost, "/v1/register", registry.IntentRegister, rec, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Deregister removes agentID from the registry.
+func (c *Client) Deregister(ctx context.Context, agentID string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/agents/"+url.PathEscape(agentID), registry.IntentRegister, nil, nil)
+}
+
+// Renew extends agentID's lease and returns the updated record. Over QUIC
+// renewals are sent as 0-RTT early data on resumed sessions.
+func (c *Client) Renew(ctx context.Context, agentID string) (*registry.Record, error) {
+	return c.RenewLoad(ctx, agentID, nil)
+}
+
+// RenewLoad renews agentID's lease like Renew, reporting load as the
+// agent's current load when non-nil.
+func (c *Client) RenewLoad(ctx context.Context, agentID string, load *registry.Load) (*registry.Record, error) {
+	return c.RenewSeq(ctx, agentID, 0, load)
+}
+
+// RenewSeq is RenewLoad, failing with registry.ErrStaleRecord unless the
+// record is still at seq when seq is nonzero (see registry.Record.Seq).
+func (c *Client) RenewSeq(ctx context.Context, agentID string, seq uint64, load *registry.Load) (*registry.Record, error) {
+	var body interface{}
+	if load != nil || seq != 0 {
+		body = struct {
+			Load *registry.Load `json:"load,omitempty"`
+			Seq  uint64         `json:"seq,omitempty"`
+		}{load, seq}
+	}
+	var out registry.Record
+	path := "/v1/agents/" + url.PathEscape(agentID) + "/renew"
+	if err := c.do(withEarlyData(ctx), http.MethodPost, path, registry.IntentRenew, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Lookup returns the live record for agentID. With Config.Cache set, it
+// returns the cached record and a *StaleError while the server cannot be
+// reached.
+func (c *Client) Lookup(ctx context.Context, agentID string) (*registry.Record, error) {
+	var out registry.Record
+	path := "/v1/agents/" + url.PathEscape(agentID)
+	err := c.cached(ctx, "agents/"+agentID, &out, func() error {
+		return c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out)
+	})
+	return staleResult(&out, err)
+}
+
+// LookupReef returns the record of agentID in reefID, a reef federated
+// with the server's. The server forwards the lookup to the federated
+// reef's server.
+func (c *Client) LookupReef(ctx context.Context, reefID, agentID string) (*registry.Record, error) {
+	var out registry.Record
+	path := "/v1/agents/" + url.PathEscape(agentID) + "?" + url.Values{"reef": {reefID}}.Encode()
+	err := c.cached(ctx, "reefs/"+reefID+"/agents/"+agentID, &out, func() error {
+		return c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out)
+	})
+	return staleResult(&out, err)
+}
+
+// List returns the live records in colonyID.
+func (c *Client) List(ctx context.Context, colonyID string) ([]*registry.Record, error) {
+	return c.Query(ctx, colonyID, nil)
+}
+
+// QueryOption tunes a Query.
+type QueryOption func(url.Values)
+
+// Nearest returns only the n agents nearest the ticket's agent, ranked
+// by the server from measured RTTs and locations (see registry.Nearest).
+func Nearest(n int) QueryOption {
+	return func(q url.Values) { q.Set("nearest", strconv.Itoa(n)) }
+}
+
+// LeastLoaded returns only the n least loaded agents, by the load they
+// report with their heartbeats (see registry.LeastLoaded).
+func LeastLoaded(n int) QueryOption {
+	return func(q url.Values) { q.Set("least_loaded", strconv.Itoa(n)) }
+}
+
+// Weighted returns n agents drawn at random by the server, weighted by
+// their health scores (see registry.Weighted). Only one of Nearest,
+// LeastLoaded and Weighted may be given.
+func Weighted(n int) QueryOption {
+	return func(q url.Values) { q.Set("weighted", strconv.Itoa(n)) }
+}
+
+// Query returns the live records in colonyID whose labels match sel,
+// filtered by the server. Plain listings are fetched page by page, so
+// large colonies take several requests. With Config.Cache set, it
+// returns the cached listing and a *StaleError while the server cannot
+// be reached.
+func (c *Client) Query(ctx context.Context, colonyID string, sel *registry.Selector, opts ...QueryOption) ([]*registry.Record, error) {
+	q := url.Values{}
+	if s := sel.String(); s != "" {
+		q.Set("selector", s)
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	var out []*registry.Record
+	err := c.cached(ctx, "colonies/"+colonyID+"?"+q.Encode(), &out, func() error {
+		out = nil
+		cursor := ""
+		for {
+			page, err := c.QueryPage(ctx, colonyID, sel, cursor, 0, opts...)
+			if err != nil {
+				return err
+			}
+			out = append(out, page.Records...)
+			if page.NextCursor == "" {
+				return nil
+			}
+			cursor = page.NextCursor
+		}
+	})
+	return staleResult(out, err)
+}
+
+// QueryPage returns one page of Query: up to limit records (the server's
+// default when zero) in agent ID order, starting after cursor. Pass the
+// page's NextCursor to fetch the next one; it is empty on the last page.
+func (c *Client) QueryPage(ctx context.Context, colonyID string, sel *registry.Selector, cursor string, limit int, opts ...QueryOption) (*registry.Page, error) {
+	q := url.Values{}
+	if s := sel.String(); s != "" {
+		q.Set("selector", s)
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	path := "/v1/colonies/" + url.PathEscape(colonyID) + "/agents"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out registry.Page
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Digest returns the digest of colonyID's membership (see
+// registry.Digest).
+func (c *Client) Digest(ctx context.Context, colonyID string) (*registry.Digest, error) {
+	var out registry.Digest
+	if err := c.do(ctx, http.MethodGet, "/v1/colonies/"+url.PathEscape(colonyID)+"/digest", registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Resync returns the live records of colonyID given have, those an
+// earlier List returned. It asks for the colony's digest and only lists
+// the colony again when have is out of date, or the digest could not be
+// had, reporting whether it did.
+func (c *Client) Resync(ctx context.Context, colonyID string, have []*registry.Record) ([]*registry.Record, bool, error) {
+	if d, err := c.Digest(ctx, colonyID); err == nil && d.Matches(have) {
+		return have, false, nil
+	}
+	recs, err := c.List(ctx, colonyID)
+	return recs, true, err
+}
+
+// Topic returns the live records of the ticket's reef that take part in
+// topic as role, in agent ID order, optionally only those of colonyID.
+func (c *Client) Topic(ctx context.Context, topic string, role registry.TopicRole, colonyID string) ([]*registry.Record, error) {
+	q := url.Values{}
+	if role != registry.AnyRole {
+		q.Set("role", string(role))
+	}
+	if colonyID != "" {
+		q.Set("colony", colonyID)
+	}
+	path := "/v1/topics/" + url.PathEscape(topic) + "/agents"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out registry.Page
+	err := c.cached(ctx, strings.TrimPrefix(path, "/v1/"), &out, func() error {
+		return c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out)
+	})
+	return staleResult(out.Records, err)
+}
+
+// Service returns the live records of the ticket's reef running
+// q.Service at a version satisfying q, in agent ID order. q.Key is
+// ignored: the server keeps the ticket's agent in the same canary share.
+func (c *Client) Service(ctx context.Context, q registry.ServiceQuery) ([]*registry.Record, error) {
+	v := url.Values{}
+	if q.Version != "" {
+		v.Set("version", q.Version)
+	}
+	if q.Stable {
+		v.Set("stable", "true")
+	}
+	if q.Canary != 0 {
+		v.Set("canary", strconv.Itoa(q.Canary))
+	}
+	if q.ColonyID != "" {
+		v.Set("colony", q.ColonyID)
+	}
+	path := "/v1/services/" + url.PathEscape(q.Service) + "/agents"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	var out registry.Page
+	err := c.cached(ctx, strings.TrimPrefix(path, "/v1/"), &out, func() error {
+		return c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out)
+	})
+	return staleResult(out.Records, err)
+}
+
+// staleResult returns out along with err when err is a *StaleError, and
+// only err otherwise.
+func staleResult[T any](out T, err error) (T, error) {
+	if _, ok := Stale(err); err != nil && !ok {
+		var zero T
+		return zero, err
+	}
+	return out, err
+}
+
+// do sends a JSON request with retries and decodes the response into out.
+func (c *Client) do(ctx context.Context, method, path, intent string, body, out interface{}) error {
+	var payload []byte
+	version := c.bodyVersion()
+	if body != nil {
+		var err error
+		if payload, err = wire.Marshal(body, version); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	fetch := func(ctx context.Context) (reply, error) {
+		resp, err := c.send(c.http, func() (*http.Request, error) {
+			req, err := c.newRequest(ctx, method, path, intent, payload)
+			if err == nil && payload != nil {
+				req.Header.Set(wire.ContentHeader, strconv.Itoa(version))
+			}
+			return req, err
+		})
+		if err != nil {
+			return reply{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return reply{}, decodeError(resp)
+		}
+		served, err := c.servedVersion(resp)
+		if err != nil {
+			return reply{}, permanent(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return reply{}, fmt.Errorf("failed to read response: %w", err)
+		}
+		return reply{data, served}, nil
+	}
+	return c.backoff.retry(ctx, func() error {
+		var r reply
+		var err error
+		if method == http.MethodGet {
+			r, err = c.hedged(ctx, fetch)
+		} else {
+			r, err = fetch(ctx)
+		}
+		if err != nil || out == nil {
+			return err
+		}
+		if err := wire.Unmarshal(r.data, r.version, out); err != nil {
+			return permanent(fmt.Errorf("failed to decode response: %w", err))
+		}
+		return nil
+	})
+}
+
+// reply is a response body and the schema version it is encoded in.
+type reply struct {
+	data    []byte
+	version int
+}
+
+// servedVersion returns the schema version of resp and remembers it for
+// bodyVersion. Servers that predate versioning answer in version 1.
+func (c *Client) servedVersion(resp *http.Response) (int, error) {
+	version, err := wire.Parse(resp.Header.Get(wire.ContentHeader))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	c.served.Store(int32(version))
+	return version, nil
+}
+
+// bodyVersion returns the schema version to encode request bodies in:
+// that of the server's last response, which it is known to decode, and
+// the oldest supported one before the first.
+func (c *Client) bodyVersion() int {
+	if v := int(c.served.Load()); v != 0 {
+		return v
+	}
+	return wire.Oldest
+}
+
+// newRequest builds a request to the current endpoint (see
+// Config.Endpoints) with the referral ticket for intent attached. Requests to public routes pass no intent and carry no ticket,
+// as do all requests of clients authenticating by certificate alone.
+func (c *Client) newRequest(ctx context.Context, method, path, intent string, payload []byte) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(ctx).String()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(wire.AcceptHeader, wire.Accept)
+	if intent == "" {
+		return req, nil
+	}
+	if c.apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, c.apiKey)
+		return req, nil
+	}
+	if c.tickets == nil {
+		return req, nil
+	}
+
+	ticket, err := c.tickets.Ticket(ctx, intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain referral ticket: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ticket)
+	if c.prover != nil {
+		if err := c.prover.attach(req, ticket); err != nil {
+			return nil, fmt.Errorf("failed to sign proof of possession: %w", err)
+		}
+	}
+	if c.svid != nil {
+		svid, err := c.svid(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain JWT-SVID: %w", err)
+		}
+		req.Header.Set(spiffe.Header, svid)
+	}
+	return req, nil
+}
+
+// Revoke revokes the ticket identified by jti until expiresAt, normally the
+// revoked ticket's exp claim. The ticket source is asked for a ticket with
+// the "revoke" intent.
+func (c *Client) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	body := map[string]interface{}{
+		"jti":        jti,
+		"expires_at": expiresAt.UTC(),
+	}
+	return c.do(ctx, http.MethodPost, "/v1/revocations", revocation.IntentRevoke, body, nil)
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/bootstrap"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+)
+
+// probeTimeout bounds each endpoint probe in Discover.
+const probeTimeout = 5 * time.Second
+
+// Connect creates a Client like New. When cfg.BaseURL is empty the
+// discovery service is looked up in DNS for cfg.Domain (see package
+// bootstrap) and the first published endpoint that answers is used,
+// with the others as Endpoints to fail over to unless cfg sets them.
+func Connect(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.BaseURL != "" {
+		return New(cfg)
+	}
+	if cfg.Domain == "" {
+		return nil, errors.New("base URL or bootstrap domain is required")
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	info, base, err := discover(ctx, cfg.Bootstrap, httpClient, cfg.Domain)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BaseURL = base
+	if cfg.Endpoints == nil {
+		for _, endpoint := range info.Endpoints {
+			if endpoint != base {
+				cfg.Endpoints = append(cfg.Endpoints, endpoint)
+			}
+		}
+	}
+	c, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if info.JWKSURL != "" {
+		c.jwksURL = info.JWKSURL
+	}
+	return c, nil
+}
+
+// Discover resolves domain's discovery records with r (bootstrap.New's
+// defaults when nil) and returns the first endpoint serving the key set,
+// along with the published JWKS URL if any.
+func Discover(ctx context.Context, r *bootstrap.Resolver, httpClient *http.Client, domain string) (baseURL, jwksURL string, err error) {
+	info, baseURL, err := discover(ctx, r, httpClient, domain)
+	if err != nil {
+		return "", "", err
+	}
+	return baseURL, info.JWKSURL, nil
+}
+
+// discover is Discover, returning all of domain's discovery records.
+func discover(ctx context.Context, r *bootstrap.Resolver, httpClient *http.Client, domain string) (*bootstrap.Info, string, error) {
+	if r == nil {
+		r = bootstrap.New(bootstrap.Config{})
+	}
+	info, err := r.Resolve(ctx, domain)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var errs []error
+	for _, endpoint := range info.Endpoints {
+		if err := probe(ctx, httpClient, endpoint); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return info, endpoint, nil
+	}
+	return nil, "", fmt.Errorf("no discovery endpoint of %s answered: %w", domain, errors.Join(errs...))
+}
+
+// probe checks that endpoint serves the discovery key set.
+func probe(ctx context.Context, httpClient *http.Client, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+jwks.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// JWKSURL returns the key set verifying this discovery service's tickets:
+// the URL published in DNS when the client was bootstrapped, otherwise
+// the server's well-known path.
+func (c *Client) JWKSURL() string {
+	if c.jwksURL != "" {
+		return c.jwksURL
+	}
+	return c.baseURL.String() + jwks.Path
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// campaignWait is how long each campaign request waits for the leader's
+// term to end.
+const campaignWait = 25 * time.Second
+
+// Leadership is a term of leadership won with Client.Campaign. It renews
+// its lease in a background goroutine until the term ends.
+type Leadership struct {
+	c       *Client
+	name    string
+	session string
+	leader  *election.Leader
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Campaign blocks until the ticket's agent leads the election name of
+// its colony, such as "colony/x/leader", or ctx is done. The term lasts
+// while its lease of election.DefaultTTL is renewed, which the returned
+// Leadership does until ctx is cancelled or Resign is called. Pass its
+// Fence to the systems the leader writes to, so that they can refuse the
+// writes of earlier terms.
+func (c *Client) Campaign(ctx context.Context, name string) (*Leadership, error) {
+	return c.CampaignTTL(ctx, name, election.DefaultTTL)
+}
+
+// CampaignTTL is Campaign with a lease of ttl, whole seconds between
+// election.MinTTL and election.MaxTTL. A shorter lease hands leadership
+// over sooner after a leader fails, at the cost of more renewals.
+func (c *Client) CampaignTTL(ctx context.Context, name string, ttl time.Duration) (*Leadership, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	session := hex.EncodeToString(b)
+	body := map[string]any{"session": session, "ttl_seconds": int(ttl / time.Second)}
+	path := electionPath(name) + "/campaign?wait=" + url.QueryEscape(campaignWait.String())
+	for {
+		sent := time.Now()
+		var l election.Leader
+		if err := c.do(ctx, http.MethodPost, path, registry.IntentRegister, body, &l); err != nil {
+			return nil, err
+		}
+		if l.Session != session {
+			continue
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		lead := &Leadership{
+			c:       c,
+			name:    name,
+			session: session,
+			leader:  &l,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+			stopped: make(chan struct{}),
+		}
+		go lead.run(ctx, sent.Add(ttl), ttl)
+		return lead, nil
+	}
+}
+
+// Leader returns the current term of the election name of the ticket's
+// colony, or an error wrapping election.ErrNoLeader when nobody leads.
+func (c *Client) Leader(ctx context.Context, name string) (*election.Leader, error) {
+	var l election.Leader
+	err := c.do(ctx, http.MethodGet, electionPath(name), registry.IntentRegister, nil, &l)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %w", election.ErrNoLeader, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Fence returns the fencing token of the term.
+func (l *Leadership) Fence() uint64 {
+	return l.leader.Fence
+}
+
+// Term returns the term as won.
+func (l *Leadership) Term() *election.Leader {
+	return l.leader
+}
+
+// Done is closed when the term ends: when a renewal is refused, when
+// renewals failed until the lease ran out, or when the campaign's
+// context is done or Resign called. The leader must stop acting as such
+// then.
+func (l *Leadership) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns why the term ended once Done is closed: an error wrapping
+// election.ErrNotLeader when it was lost, or the error of the campaign's
+// context.
+func (l *Leadership) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Resign stops renewing and ends the term, so that another candidate
+// leads right away instead of once the lease runs out. It returns an
+// error wrapping election.ErrNotLeader when the term had ended already.
+func (l *Leadership) Resign(ctx context.Context) error {
+	l.cancel()
+	<-l.stopped
+	body := map[string]any{"session": l.session, "fence": l.leader.Fence}
+	return l.c.do(ctx, http.MethodPost, electionPath(l.name)+"/resign", registry.IntentRegister, body, nil)
+}
+
+// run renews the term every third of ttl until it ends. deadline is when
+// the lease runs out without another renewal.
+func (l *Leadership) run(ctx context.Context, deadline time.Time, ttl time.Duration) {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	body := map[string]any{"session": l.session, "fence": l.leader.Fence}
+	for {
+		select {
+		case <-ctx.Done():
+			l.end(ctx.Err())
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		renewCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := l.c.do(renewCtx, http.MethodPost, electionPath(l.name)+"/renew", registry.IntentRegister, body, nil)
+		cancel()
+		switch {
+		case err == nil:
+			deadline = sent.Add(ttl)
+		case errors.Is(err, election.ErrNotLeader):
+			l.end(err)
+			return
+		case ctx.Err() != nil:
+			l.end(ctx.Err())
+			return
+		case !time.Now().Before(deadline):
+			l.end(fmt.Errorf("%w: lease of election %s ran out: %w", election.ErrNotLeader, l.name, err))
+			return
+		}
+	}
+}
+
+// end records why the term ended and closes Done.
+func (l *Leadership) end(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	close(l.done)
+}
+
+func electionPath(name string) string {
+	return "/v1/elections/" + url.PathEscape(name)
+}
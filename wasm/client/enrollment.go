@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/enrollment"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Enroll submits csr for the agent named by the ticket the source yields
+// for the enrollment.IntentEnroll intent. The enrollment is returned
+// pending, or approved with its identity when the server auto-approves
+// the colony; poll Enrollment until an admin decides.
+func (c *Client) Enroll(ctx context.Context, csr *enrollment.CSR) (*enrollment.Enrollment, error) {
+	var out enrollment.Enrollment
+	if err := c.do(ctx, http.MethodPost, "/v1/enrollments", enrollment.IntentEnroll, csr, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Enrollment returns the state of enrollment id and, once approved, the
+// agent's identity: a registration ticket bound to the enrolled key.
+// Present it with a proof of possession by the key (see
+// Config.ProofKey).
+func (c *Client) Enrollment(ctx context.Context, id string) (*enrollment.Enrollment, error) {
+	var out enrollment.Enrollment
+	if err := c.do(ctx, http.MethodGet, enrollmentPath(id), enrollment.IntentEnroll, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReissueIdentity exchanges the current identity ticket, which the
+// source yields for registry.IntentRegister, for a fresh one before it
+// expires.
+func (c *Client) ReissueIdentity(ctx context.Context, id string) (*enrollment.Enrollment, error) {
+	var out enrollment.Enrollment
+	if err := c.do(ctx, http.MethodPost, enrollmentPath(id)+"/identity", registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AdminEnrollments returns the enrollments of the reef, oldest first,
+// optionally only those with status.
+func (c *Client) AdminEnrollments(ctx context.Context, status string) ([]*enrollment.Enrollment, error) {
+	path := "/v1/admin/enrollments"
+	if status != "" {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+	var out struct {
+		Enrollments []*enrollment.Enrollment `json:"enrollments"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Enrollments, nil
+}
+
+// ApproveEnrollment approves a pending enrollment, enrolling the agent's
+// key and issuing its identity.
+func (c *Client) ApproveEnrollment(ctx context.Context, id string) (*enrollment.Enrollment, error) {
+	var out enrollment.Enrollment
+	if err := c.do(ctx, http.MethodPost, "/v1/admin"+enrollmentPath(id)+"/approve", rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DenyEnrollment denies a pending enrollment, recording reason.
+func (c *Client) DenyEnrollment(ctx context.Context, id, reason string) (*enrollment.Enrollment, error) {
+	body := map[string]string{"reason": reason}
+	var out enrollment.Enrollment
+	if err := c.do(ctx, http.MethodPost, "/v1/admin"+enrollmentPath(id)+"/deny", rbac.IntentAdmin, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func enrollmentPath(id string) string {
+	return "/v1/enrollments/" + url.PathEscape(id)
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+)
+
+// APIError is a { code, message } error returned by the server.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+
+	// RetryAfter is the server's Retry-After for rate limited requests;
+	// retries wait at least this long.
+	RetryAfter time.Duration
+
+	// Quota and Limit name the quota a quota_exceeded request was over
+	// (see registry.QuotaError).
+	Quota string
+	Limit int
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Unwrap maps the error code onto the registry sentinel errors so callers
+// can use errors.Is(err, registry.ErrNotFound) and friends,
+// rbac.ErrForbidden for permission_denied, allowlist.ErrNotAllowed for
+// endpoint_not_allowed, registry.ErrStaleRecord for stale_record,
+// ratelimit.ErrLimited for resource_exhausted, a
+// *registry.QuotaError wrapping registry.ErrQuotaExceeded for
+// quota_exceeded, history.ErrCompacted for out_of_range,
+// registry.ErrCursorExpired for resync_required, schema.ErrMismatch, as
+// well as registry.ErrInvalidRecord, for invalid_metadata,
+// election.ErrNotLeader for not_leader, lock.ErrUnavailable for
+// lock_unavailable and lock.ErrNotHeld for not_held.
+func (e *APIError) Unwrap() error {
+	switch e.Code {
+	case "not_found":
+		return registry.ErrNotFound
+	case "failed_precondition":
+		return registry.ErrLeaseExpired
+	case "unauthenticated":
+		return registry.ErrUnauthorized
+	case "permission_denied":
+		return rbac.ErrForbidden
+	case "endpoint_not_allowed":
+		return allowlist.ErrNotAllowed
+	case "stale_record":
+		return registry.ErrStaleRecord
+	case "resource_exhausted":
+		return ratelimit.ErrLimited
+	case "quota_exceeded":
+		return &registry.QuotaError{Quota: e.Quota, Limit: e.Limit, RetryAfter: e.RetryAfter}
+	case "out_of_range":
+		return history.ErrCompacted
+	case "resync_required":
+		return registry.ErrCursorExpired
+	case "invalid_metadata":
+		return fmt.Errorf("%w: %w", registry.ErrInvalidRecord, schema.ErrMismatch)
+	case "not_leader":
+		return election.ErrNotLeader
+	case "lock_unavailable":
+		return lock.ErrUnavailable
+	case "not_held":
+		return lock.ErrNotHeld
+	case "invalid_argument":
+		return registry.ErrInvalidRecord
+	default:
+		return nil
+	}
+}
+
+// Temporary reports whether the request may succeed if retried. Quotas
+// other than the registrations per minute stay full until agents or
+// streams leave, so exceeding them is not.
+func (e *APIError) Temporary() bool {
+	if e.Code == "quota_exceeded" {
+		return e.Quota == registry.QuotaRegistrations
+	}
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// decodeError reads an APIError from a non-2xx response. Transient
+// statuses are returned as retryable; everything else is permanent.
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = "unknown"
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	if apiErr.Temporary() {
+		return apiErr
+	}
+	return permanent(apiErr)
+}
+
+// permanentError marks an error that must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// unwrapPermanent strips the retry marker before returning to callers.
+func unwrapPermanent(err error) error {
+	var p *permanentError
+	if errors.As(err, &p) {
+		return p.err
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/vc"
+)
+
+// exchangeMargin is how long before expiry a cached exchanged ticket is
+// replaced.
+const exchangeMargin = 30 * time.Second
+
+// ExchangeConfig configures ExchangeTickets.
+type ExchangeConfig struct {
+	// BaseURL is the discovery server root. Required.
+	BaseURL string
+
+	// IDToken returns the operator's current OIDC ID token. Required.
+	IDToken func(ctx context.Context) (string, error)
+
+	// ReefID and ColonyID select the colony tickets are requested for.
+	ReefID   string
+	ColonyID string
+
+	// HTTPClient is used for exchanges. Defaults to a client with a 30s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// ExchangeTickets returns a TicketSource that obtains tickets by
+// exchanging an ID token at POST /v1/token, caching the ticket for each
+// intent until shortly before it expires.
+func ExchangeTickets(cfg ExchangeConfig) TicketSource {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &exchangeSource{cfg: cfg, cache: make(map[string]exchanged)}
+}
+
+type exchanged struct {
+	ticket    string
+	expiresAt time.Time
+}
+
+type exchangeSource struct {
+	cfg ExchangeConfig
+
+	mu    sync.Mutex
+	cache map[string]exchanged
+}
+
+func (s *exchangeSource) Ticket(ctx context.Context, intent string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.cache[intent]; ok && time.Until(t.expiresAt) > exchangeMargin {
+		return t.ticket, nil
+	}
+	idToken, err := s.cfg.IDToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain ID token: %w", err)
+	}
+	tok, err := Exchange(ctx, s.cfg.HTTPClient, s.cfg.BaseURL, idToken, s.cfg.ReefID, s.cfg.ColonyID, intent)
+	if err != nil {
+		return "", err
+	}
+	s.cache[intent] = exchanged{ticket: tok.AccessToken, expiresAt: time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)}
+	return tok.AccessToken, nil
+}
+
+// Exchange swaps idToken for a ticket with intent on the colony. Refused
+// exchanges return an *oidc.Error.
+func Exchange(ctx context.Context, httpClient *http.Client, baseURL, idToken, reefID, colonyID, intent string) (*oidc.Token, error) {
+	form := url.Values{
+		"grant_type":           {oidc.GrantTypeTokenExchange},
+		"subject_token":        {idToken},
+		"subject_token_type":   {oidc.TokenTypeIDToken},
+		"requested_token_type": {oidc.TokenTypeJWT},
+		"resource":             {vc.ColonyIRI(reefID, colonyID)},
+		"scope":                {intent},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/v1/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var oerr oidc.Error
+		if err := json.NewDecoder(resp.Body).Decode(&oerr); err == nil && oerr.Code != "" {
+			return nil, &oerr
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token exchange failed: %s", resp.Status)
+	}
+	var tok oidc.Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("failed to decode exchange response: %w", err)
+	}
+	return &tok, nil
+}
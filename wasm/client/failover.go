@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// failoverCooldown is how long an endpoint that failed a request is
+// passed over, unless a health check finds it healthy sooner.
+const failoverCooldown = 30 * time.Second
+
+// EndpointStatus is the health of one of the client's endpoints.
+type EndpointStatus struct {
+	URL     string
+	Healthy bool
+
+	// LastError is the most recent failure, empty once healthy again.
+	LastError string
+
+	// CheckedAt is when the endpoint last answered or failed.
+	CheckedAt time.Time
+}
+
+// endpointSet tracks the health of the client's endpoints. Requests go
+// to the first healthy endpoint in order of preference.
+type endpointSet struct {
+	urls []*url.URL
+
+	mu       sync.Mutex
+	status   []EndpointStatus
+	downTill []time.Time
+}
+
+func newEndpointSet(urls []*url.URL) *endpointSet {
+	s := &endpointSet{urls: urls, status: make([]EndpointStatus, len(urls)), downTill: make([]time.Time, len(urls))}
+	for i, u := range urls {
+		s.status[i] = EndpointStatus{URL: u.String(), Healthy: true}
+	}
+	return s
+}
+
+// healthy returns the healthy endpoints in order of preference. When
+// none is healthy, all are returned, so requests keep trying.
+func (s *endpointSet) healthy() []*url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []*url.URL
+	for i, u := range s.urls {
+		if s.status[i].Healthy || now.After(s.downTill[i]) {
+			out = append(out, u)
+		}
+	}
+	if len(out) == 0 {
+		return s.urls
+	}
+	return out
+}
+
+// pick returns the endpoint requests should go to.
+func (s *endpointSet) pick() *url.URL {
+	return s.healthy()[0]
+}
+
+// mark records the outcome of a request to, or probe of, u: err is nil
+// when it answered.
+func (s *endpointSet) mark(u *url.URL, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.urls {
+		if e.Scheme != u.Scheme || e.Host != u.Host {
+			continue
+		}
+		st := &s.status[i]
+		st.CheckedAt = time.Now()
+		if err == nil {
+			st.Healthy, st.LastError = true, ""
+			continue
+		}
+		st.Healthy, st.LastError = false, err.Error()
+		s.downTill[i] = st.CheckedAt.Add(failoverCooldown)
+	}
+}
+
+// observe marks the endpoint of req after a round trip. Endpoints that
+// cannot be reached or answer with a server error are marked down;
+// requests the caller cancelled say nothing about the endpoint.
+func (s *endpointSet) observe(req *http.Request, resp *http.Response, err error) {
+	if len(s.urls) < 2 || req.Context().Err() != nil {
+		return
+	}
+	if err == nil && resp.StatusCode >= 500 {
+		err = errors.New(resp.Status)
+	}
+	s.mark(req.URL, err)
+}
+
+type endpointKey struct{}
+
+// withEndpoint pins the requests made with ctx to endpoint u.
+func withEndpoint(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, endpointKey{}, u)
+}
+
+// endpoint returns the endpoint a request made with ctx goes to.
+func (c *Client) endpoint(ctx context.Context) *url.URL {
+	if u, ok := ctx.Value(endpointKey{}).(*url.URL); ok {
+		return u
+	}
+	return c.endpoints.pick()
+}
+
+// Endpoints returns the health of the client's endpoints, in order of
+// preference.
+func (c *Client) Endpoints() []EndpointStatus {
+	c.endpoints.mu.Lock()
+	defer c.endpoints.mu.Unlock()
+	return append([]EndpointStatus(nil), c.endpoints.status...)
+}
+
+// CheckEndpoints probes every endpoint for the discovery key set and
+// records which are healthy.
+func (c *Client) CheckEndpoints(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range c.endpoints.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := probe(ctx, c.http, u.String())
+			if ctx.Err() == nil {
+				c.endpoints.mark(u, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// RunHealthChecks calls CheckEndpoints every interval until ctx is done,
+// so that requests fail back to preferred endpoints once they recover
+// and skip failed ones before a request has to find out.
+func (c *Client) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CheckEndpoints(ctx)
+		}
+	}
+}
+
+// hedged calls fetch on the preferred endpoint and, when it has not
+// answered within the hedge delay or could not be reached, on the next
+// healthy endpoint too. The first answer wins and the other request is
+// cancelled.
+func (c *Client) hedged(ctx context.Context, fetch func(ctx context.Context) (reply, error)) (reply, error) {
+	urls := c.endpoints.healthy()
+	if c.hedge <= 0 || len(urls) < 2 {
+		return fetch(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply reply
+		err   error
+	}
+	results := make(chan result, 2)
+	launch := func(u *url.URL) {
+		go func() {
+			r, err := fetch(withEndpoint(ctx, u))
+			results <- result{r, err}
+		}()
+	}
+	launch(urls[0])
+	timer := time.NewTimer(c.hedge)
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var first error
+	for {
+		select {
+		case <-timer.C:
+			launch(urls[1])
+			pending, hedged = pending+1, true
+		case r := <-results:
+			pending--
+			if r.err == nil || !unreachable(r.err) {
+				return r.reply, r.err
+			}
+			if first == nil {
+				first = r.err
+			}
+			if !hedged {
+				timer.Stop()
+				launch(urls[1])
+				pending, hedged = pending+1, true
+			}
+			if pending == 0 {
+				return reply{}, first
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Heartbeat keeps a registration alive by renewing its lease in a
+// background goroutine. Create one with Client.StartHeartbeat.
+type Heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	errs   chan error
+
+	mu   sync.Mutex
+	load *registry.Load
+}
+
+// StartHeartbeat registers rec and renews its lease every interval until
+// ctx is cancelled or Stop is called. An interval of 0 renews at a third
+// of the record's TTL. If the server reports the lease as expired or
+// missing, the record is registered again.
+//
+// Renewals only renew the record the heartbeat last wrote (see
+// registry.Record.Seq). When another instance of the agent registers
+// other endpoints meanwhile, the heartbeat reports a
+// registry.ErrStaleRecord and ends, leaving the record to the newer
+// instance instead of flapping between the two.
+func (c *Client) StartHeartbeat(ctx context.Context, rec *registry.Record, interval time.Duration) (*Heartbeat, error) {
+	stored, err := c.Register(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = time.Duration(stored.TTLSeconds) * time.Second / 3
+	}
+	if interval <= 0 {
+		interval = registry.DefaultTTL / 3
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Heartbeat{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		errs:   make(chan error, 1),
+	}
+	rec = rec.Clone()
+	rec.Seq = 0
+	go h.run(ctx, c, rec, stored.Seq, interval)
+	return h, nil
+}
+
+// Errors delivers renewal failures. Only the most recent unread error is
+// kept; the heartbeat keeps running after an error.
+func (h *Heartbeat) Errors() <-chan error {
+	return h.errs
+}
+
+// ReportLoad sets the load sent with the following renewals, and with
+// registrations after a lapsed lease.
+func (h *Heartbeat) ReportLoad(load *registry.Load) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.load = load
+}
+
+// Stop ends the heartbeat and waits for the goroutine to exit. The
+// registration is left to expire; call Client.Deregister to remove it.
+func (h *Heartbeat) Stop() {
+	h.cancel()
+	<-h.done
+}
+
+func (h *Heartbeat) run(ctx context.Context, c *Client, rec *registry.Record, seq uint64, interval time.Duration) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.Lock()
+		load := h.load
+		h.mu.Unlock()
+		stored, err := c.RenewSeq(ctx, rec.AgentID, seq, load)
+		if errors.Is(err, registry.ErrStaleRecord) {
+			stored, err = h.reconcile(ctx, c, rec)
+			if errors.Is(err, registry.ErrStaleRecord) {
+				h.report(err)
+				return
+			}
+		}
+		if errors.Is(err, registry.ErrLeaseExpired) || errors.Is(err, registry.ErrNotFound) {
+			if load != nil {
+				rec.Load = load
+			}
+			stored, err = c.Register(ctx, rec)
+		}
+		if err == nil {
+			seq = stored.Seq
+		}
+		if err != nil && ctx.Err() == nil {
+			h.report(err)
+		}
+	}
+}
+
+// reconcile reads the record again after a renewal found it written
+// since the heartbeat's last write. That was the heartbeat's own renewal
+// when its answer was lost, or any write leaving the endpoints of rec in
+// place, and the heartbeat carries on from the record read. Otherwise
+// another instance took the record over, which reconcile reports as a
+// registry.ErrStaleRecord.
+func (h *Heartbeat) reconcile(ctx context.Context, c *Client, rec *registry.Record) (*registry.Record, error) {
+	current, err := c.Lookup(ctx, rec.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(current.Endpoints, rec.Endpoints) {
+		return nil, fmt.Errorf("%w: agent %s was registered again at %s", registry.ErrStaleRecord, rec.AgentID, strings.Join(current.Endpoints, ", "))
+	}
+	return current, nil
+}
+
+// report delivers err without blocking, replacing any unread error.
+func (h *Heartbeat) report(err error) {
+	select {
+	case <-h.errs:
+	default:
+	}
+	select {
+	case h.errs <- err:
+	default:
+	}
+}
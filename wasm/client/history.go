@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// History returns a page of the archived registry changes of the
+// ticket's reef, oldest first. q.ReefID is ignored.
+func (c *Client) History(ctx context.Context, q history.Query) (*history.Page, error) {
+	v := url.Values{}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.ColonyID != "" {
+		v.Set("colony", q.ColonyID)
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/v1/history"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	var out history.Page
+	if err := c.do(ctx, http.MethodGet, path, rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Topology returns the agents of the ticket's reef, or only of colonyID
+// when set, that were registered at at. It fails with
+// history.ErrCompacted when the server no longer keeps the changes
+// before at.
+func (c *Client) Topology(ctx context.Context, colonyID string, at time.Time) ([]*registry.Record, error) {
+	v := url.Values{"at": {at.Format(time.RFC3339)}}
+	if colonyID != "" {
+		v.Set("colony", colonyID)
+	}
+	var out registry.Page
+	if err := c.do(ctx, http.MethodGet, "/v1/history/topology?"+v.Encode(), rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Records, nil
+}
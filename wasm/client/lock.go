@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// acquireWait is how long each acquire request waits for a lease to free
+// up.
+const acquireWait = 25 * time.Second
+
+// Hold is a lease on a lock or semaphore acquired with Client.Lock or
+// Client.Acquire. It renews the lease in a background goroutine until it
+// is lost or released.
+type Hold struct {
+	c       *Client
+	name    string
+	session string
+	lease   *lock.Lease
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Lock blocks until the ticket's agent holds the lock name of its colony,
+// such as "colony/x/deploy", or ctx is done. The lease lasts while it is
+// renewed, which the returned Hold does until ctx is cancelled or Release
+// is called. Pass its Fence to the systems the holder writes to, so that
+// they can refuse the writes of earlier holders.
+func (c *Client) Lock(ctx context.Context, name string) (*Hold, error) {
+	return c.Acquire(ctx, name, 1, lock.DefaultTTL)
+}
+
+// Acquire is Lock for the semaphore name, of which at most limit leases,
+// up to lock.MaxLimit, are held at once, with leases of ttl, whole
+// seconds between lock.MinTTL and lock.MaxTTL. Every holder of a
+// semaphore must pass the same limit.
+func (c *Client) Acquire(ctx context.Context, name string, limit int, ttl time.Duration) (*Hold, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	session := hex.EncodeToString(b)
+	body := map[string]any{"session": session, "limit": limit, "ttl_seconds": int(ttl / time.Second)}
+	path := lockPath(name) + "/acquire?wait=" + url.QueryEscape(acquireWait.String())
+	for {
+		sent := time.Now()
+		var lease lock.Lease
+		err := c.do(ctx, http.MethodPost, path, registry.IntentRegister, body, &lease)
+		if errors.Is(err, lock.ErrUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		h := &Hold{
+			c:       c,
+			name:    name,
+			session: session,
+			lease:   &lease,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+			stopped: make(chan struct{}),
+		}
+		go h.run(ctx, sent.Add(ttl), ttl)
+		return h, nil
+	}
+}
+
+// Semaphore returns the live leases of the lock or semaphore name of the
+// ticket's colony.
+func (c *Client) Semaphore(ctx context.Context, name string) (*lock.Semaphore, error) {
+	var sem lock.Semaphore
+	if err := c.do(ctx, http.MethodGet, lockPath(name), registry.IntentRegister, nil, &sem); err != nil {
+		return nil, err
+	}
+	return &sem, nil
+}
+
+// Fence returns the fencing token of the lease.
+func (h *Hold) Fence() uint64 {
+	return h.lease.Fence
+}
+
+// Lease returns the lease as acquired.
+func (h *Hold) Lease() *lock.Lease {
+	return h.lease
+}
+
+// Done is closed when the lease is lost: when a renewal is refused, when
+// renewals failed until the lease ran out, or when the acquisition's
+// context is done or Release called. The holder must stop acting on the
+// lock then.
+func (h *Hold) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns why the lease was lost once Done is closed: an error
+// wrapping lock.ErrNotHeld when it expired, or the error of the
+// acquisition's context.
+func (h *Hold) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Release stops renewing and gives up the lease, so that another holder
+// acquires it right away instead of once it runs out. It returns an error
+// wrapping lock.ErrNotHeld when the lease was lost already.
+func (h *Hold) Release(ctx context.Context) error {
+	h.cancel()
+	<-h.stopped
+	body := map[string]any{"session": h.session, "fence": h.lease.Fence}
+	return h.c.do(ctx, http.MethodPost, lockPath(h.name)+"/release", registry.IntentRegister, body, nil)
+}
+
+// run renews the lease every third of ttl until it is lost. deadline is
+// when the lease runs out without another renewal.
+func (h *Hold) run(ctx context.Context, deadline time.Time, ttl time.Duration) {
+	defer close(h.stopped)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	body := map[string]any{"session": h.session, "fence": h.lease.Fence}
+	for {
+		select {
+		case <-ctx.Done():
+			h.end(ctx.Err())
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		renewCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := h.c.do(renewCtx, http.MethodPost, lockPath(h.name)+"/renew", registry.IntentRegister, body, nil)
+		cancel()
+		switch {
+		case err == nil:
+			deadline = sent.Add(ttl)
+		case errors.Is(err, lock.ErrNotHeld):
+			h.end(err)
+			return
+		case ctx.Err() != nil:
+			h.end(ctx.Err())
+			return
+		case !time.Now().Before(deadline):
+			h.end(fmt.Errorf("%w: lease of lock %s ran out: %w", lock.ErrNotHeld, h.name, err))
+			return
+		}
+	}
+}
+
+// end records why the lease was lost and closes Done.
+func (h *Hold) end(err error) {
+	h.mu.Lock()
+	h.err = err
+	h.mu.Unlock()
+	close(h.done)
+}
+
+func lockPath(name string) string {
+	return "/v1/locks/" + url.PathEscape(name)
+}
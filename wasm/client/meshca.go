@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// IssueCertificate requests a mesh certificate for csr, a DER PKCS#10
+// request for the agent's Ed25519 key (see meshca.NewCSR). The agent
+// must be registered; when its ticket is bound to a key, csr must be for
+// that key.
+func (c *Client) IssueCertificate(ctx context.Context, csr []byte) (*meshca.Certificate, error) {
+	body := map[string][]byte{"csr": csr}
+	var out meshca.Certificate
+	if err := c.do(ctx, http.MethodPost, "/v1/mesh/certificates", registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// MeshRoots returns the PEM bundle of the mesh CA roots, which peers
+// trust to verify each other's certificates (see meshca.ParseRoots).
+func (c *Client) MeshRoots(ctx context.Context) (string, error) {
+	var out struct {
+		Roots string `json:"roots"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/mesh/ca", "", nil, &out); err != nil {
+		return "", err
+	}
+	return out.Roots, nil
+}
+
+// RenewCertificate renews cert, a current mesh certificate for key,
+// without a ticket: it opens an order with cert, answers the challenge
+// with key and finalizes the order with a request for key.
+func (c *Client) RenewCertificate(ctx context.Context, key ed25519.PrivateKey, cert *meshca.Certificate) (*meshca.Certificate, error) {
+	var o meshca.Order
+	if err := c.do(ctx, http.MethodPost, "/v1/mesh/orders", "", map[string]string{"certificate": cert.Certificate}, &o); err != nil {
+		return nil, err
+	}
+	sig := map[string][]byte{"signature": ed25519.Sign(key, o.KeyAuthorization())}
+	if err := c.do(ctx, http.MethodPost, orderPath(o.ID)+"/challenge", "", sig, &o); err != nil {
+		return nil, err
+	}
+	csr, err := meshca.NewCSR(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(ctx, http.MethodPost, orderPath(o.ID)+"/finalize", "", map[string][]byte{"csr": csr}, &o); err != nil {
+		return nil, err
+	}
+	if o.Certificate == nil {
+		return nil, fmt.Errorf("order %s is %s without a certificate", o.ID, o.Status)
+	}
+	return o.Certificate, nil
+}
+
+// CertRenewal keeps a mesh certificate current by renewing it in a
+// background goroutine. Create one with Client.StartCertRenewal.
+type CertRenewal struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	errs   chan error
+	key    ed25519.PrivateKey
+
+	mu   sync.Mutex
+	cert *meshca.Certificate
+}
+
+// StartCertRenewal renews cert, a mesh certificate for key, once two
+// thirds of its remaining lifetime have passed, and every renewed one
+// likewise, until ctx is cancelled or Stop is called. onRenew, when not
+// nil, is called with each renewed certificate, e.g. to reload a TLS
+// config. Failed renewals are retried every minute; an expired
+// certificate can no longer be renewed and is replaced with
+// IssueCertificate.
+func (c *Client) StartCertRenewal(ctx context.Context, key ed25519.PrivateKey, cert *meshca.Certificate, onRenew func(*meshca.Certificate)) *CertRenewal {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &CertRenewal{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		errs:   make(chan error, 1),
+		key:    key,
+		cert:   cert,
+	}
+	go r.run(ctx, c, onRenew)
+	return r
+}
+
+// Certificate returns the current certificate.
+func (r *CertRenewal) Certificate() *meshca.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert
+}
+
+// Errors delivers renewal failures. Only the most recent unread error is
+// kept; renewal keeps running after an error.
+func (r *CertRenewal) Errors() <-chan error {
+	return r.errs
+}
+
+// Stop ends renewal and waits for the goroutine to exit.
+func (r *CertRenewal) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// GetClientCertificate returns the current certificate for a TLS
+// handshake; set it as Config.ClientCert to authenticate over mTLS.
+func (r *CertRenewal) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return meshca.TLSCertificate(r.Certificate(), r.key)
+}
+
+func (r *CertRenewal) run(ctx context.Context, c *Client, onRenew func(*meshca.Certificate)) {
+	defer close(r.done)
+
+	wait := time.Until(r.Certificate().ExpiresAt) * 2 / 3
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		cert, err := c.RenewCertificate(ctx, r.key, r.Certificate())
+		if err != nil {
+			if ctx.Err() == nil {
+				r.report(err)
+			}
+			wait = time.Minute
+			continue
+		}
+		r.mu.Lock()
+		r.cert = cert
+		r.mu.Unlock()
+		if onRenew != nil {
+			onRenew(cert)
+		}
+		wait = time.Until(cert.ExpiresAt) * 2 / 3
+	}
+}
+
+// report delivers err without blocking, replacing any unread error.
+func (r *CertRenewal) report(err error) {
+	select {
+	case <-r.errs:
+	default:
+	}
+	select {
+	case r.errs <- err:
+	default:
+	}
+}
+
+func orderPath(id string) string {
+	return "/v1/mesh/orders/" + url.PathEscape(id)
+}
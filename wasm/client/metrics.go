@@ -0,0 +1,37 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+)
+
+// instruments are the client's metrics; nil without Config.Metrics.
+type instruments struct {
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+}
+
+func newInstruments(set *metrics.Set) instruments {
+	return instruments{
+		requests: set.Counter("coral_client_requests_total",
+			"Discovery API requests sent, by method and status code (\"error\" when none arrived).", "method", "code"),
+		latency: set.Histogram("coral_client_request_duration_seconds",
+			"Time to the response headers of discovery API requests, by method.", nil, "method"),
+	}
+}
+
+// do sends req with hc, observing it.
+func (m instruments) do(hc *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := hc.Do(req)
+	m.latency.Since(start, req.Method)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	m.requests.Inc(req.Method, code)
+	return resp, err
+}
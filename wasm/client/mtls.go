@@ -0,0 +1,35 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// withClientTransport returns a copy of hc whose transport presents the
+// certificate get returns.
+func withClientTransport(hc *http.Client, get func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) (*http.Client, error) {
+	var t *http.Transport
+	switch base := hc.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = base.Clone()
+	default:
+		return nil, errors.New("ClientCert needs HTTPClient's transport to be an *http.Transport")
+	}
+	t.TLSClientConfig = withClientCert(t.TLSClientConfig, get)
+	c := *hc
+	c.Transport = t
+	return &c, nil
+}
+
+// withClientCert returns a copy of conf, which may be nil, presenting the
+// certificate get returns.
+func withClientCert(conf *tls.Config, get func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) *tls.Config {
+	if conf = conf.Clone(); conf == nil {
+		conf = &tls.Config{}
+	}
+	conf.GetClientCertificate = get
+	return conf
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+)
+
+// prover attaches proofs of possession for tickets bound to key. It
+// remembers the last nonce issued by the server.
+type prover struct {
+	key ed25519.PrivateKey
+
+	mu    sync.Mutex
+	nonce string
+}
+
+// attach adds a proof for ticket to req.
+func (p *prover) attach(req *http.Request, ticket string) error {
+	p.mu.Lock()
+	nonce := p.nonce
+	p.mu.Unlock()
+
+	proof, err := pop.NewProof(p.key, ticket, nonce)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(pop.ProofHeader, proof)
+	return nil
+}
+
+// update records a nonce from resp and reports whether it is new.
+func (p *prover) update(resp *http.Response) bool {
+	nonce := resp.Header.Get(pop.NonceHeader)
+	if nonce == "" {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nonce == p.nonce {
+		return false
+	}
+	p.nonce = nonce
+	return true
+}
+
+// send builds and sends a request. When the server rejects the proof and
+// supplies a new nonce, the request is rebuilt and sent once more.
+func (c *Client) send(hc *http.Client, build func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, permanent(err)
+		}
+		resp, err := c.metrics.do(hc, req)
+		c.endpoints.observe(req, resp, err)
+		if err != nil {
+			return nil, err
+		}
+		if c.prover == nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 || !c.prover.update(resp) {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
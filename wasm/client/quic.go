@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QUICConfig makes a Client speak HTTP/3 over QUIC instead of TCP, which
+// saves mobile agents a TCP and TLS handshake on every network change.
+type QUICConfig struct {
+	// TLS configures the QUIC handshake; nil verifies the server against
+	// the system roots. It is cloned, and given a session cache when it
+	// has none so that new connections resume earlier sessions.
+	TLS *tls.Config
+
+	// Disable0RTT waits for the handshake before renewing a lease. By
+	// default renewals on resumed sessions go out as 0-RTT early data.
+	Disable0RTT bool
+}
+
+// earlyDataKey marks request contexts whose request may be sent as 0-RTT
+// early data.
+type earlyDataKey struct{}
+
+// withEarlyData allows the request made with ctx to be sent as early
+// data. Early data can be replayed, so this is only for requests servers
+// accept more than once, such as lease renewals.
+func withEarlyData(ctx context.Context) context.Context {
+	return context.WithValue(ctx, earlyDataKey{}, true)
+}
+
+// quicTransport is an HTTP/3 round tripper. Requests go through an
+// http3.Transport, except early-data requests, which use a connection of
+// their own dialed with 0-RTT. http3 only sends GET and HEAD requests
+// before the handshake completes, so those connections are handed to it
+// without their handshake signal.
+type quicTransport struct {
+	h3          *http3.Transport
+	tls         *tls.Config
+	disable0RTT bool
+
+	mu    sync.Mutex
+	early map[string]*earlyConn // by host:port
+}
+
+type earlyConn struct {
+	conn quic.EarlyConnection
+	cc   *http3.ClientConn
+}
+
+// handshakeHidden hides quic.EarlyConnection's HandshakeComplete from
+// http3.ClientConn, which then sends every request as soon as it can.
+type handshakeHidden struct {
+	quic.Connection
+}
+
+func newQUICTransport(cfg QUICConfig) *quicTransport {
+	tlsConf := cfg.TLS.Clone()
+	if tlsConf == nil {
+		tlsConf = &tls.Config{}
+	}
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return &quicTransport{
+		h3:          &http3.Transport{TLSClientConfig: tlsConf},
+		tls:         tlsConf,
+		disable0RTT: cfg.Disable0RTT,
+		early:       map[string]*earlyConn{},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *quicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.disable0RTT || req.Context().Value(earlyDataKey{}) == nil {
+		return t.h3.RoundTrip(req)
+	}
+
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+	ec, err := t.earlyConn(req.Context(), addr, req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ec.cc.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+	t.drop(addr, ec)
+	if !errors.Is(err, quic.Err0RTTRejected) || (req.GetBody == nil && req.Body != nil) {
+		return nil, err
+	}
+
+	// The server did not accept the resumed session's early data, e.g.
+	// after a restart; send the request again after a full handshake.
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.h3.RoundTrip(retry)
+}
+
+// earlyConn returns the live early-data connection to addr, dialing one
+// when there is none.
+func (t *quicTransport) earlyConn(ctx context.Context, addr, serverName string) (*earlyConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ec, ok := t.early[addr]; ok && ec.conn.Context().Err() == nil {
+		return ec, nil
+	}
+
+	tlsConf := t.tls.Clone()
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = serverName
+	}
+	tlsConf.NextProtos = []string{http3.NextProtoH3}
+	conn, err := quic.DialAddrEarly(ctx, addr, tlsConf, nil)
+	if err != nil {
+		return nil, err
+	}
+	ec := &earlyConn{conn: conn, cc: t.h3.NewClientConn(handshakeHidden{conn})}
+	t.early[addr] = ec
+	return ec, nil
+}
+
+func (t *quicTransport) drop(addr string, ec *earlyConn) {
+	t.mu.Lock()
+	if t.early[addr] == ec {
+		delete(t.early, addr)
+	}
+	t.mu.Unlock()
+	ec.conn.CloseWithError(0, "")
+}
+
+// CloseIdleConnections closes the QUIC connections; called through
+// http.Client.CloseIdleConnections.
+func (t *quicTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	early := t.early
+	t.early = map[string]*earlyConn{}
+	t.mu.Unlock()
+	for _, ec := range early {
+		ec.conn.CloseWithError(0, "")
+	}
+	t.h3.CloseIdleConnections()
+}
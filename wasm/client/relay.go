@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/relay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// ReserveRelay reserves a relay for the ticket's agent and agent peer,
+// for use when hole punching fails. quota asks for fewer bytes than the
+// server's default; zero takes the default. The reservation carries the
+// agent's relay ticket for relay.Bind.
+func (c *Client) ReserveRelay(ctx context.Context, peer string, quota int64) (*relay.Reservation, error) {
+	body := struct {
+		Peer       string `json:"peer"`
+		QuotaBytes int64  `json:"quota_bytes,omitempty"`
+	}{peer, quota}
+	var out relay.Reservation
+	if err := c.do(ctx, http.MethodPost, "/v1/relay/reservations", registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RelayReservations returns the reservations peers made with the ticket's
+// agent, each with the agent's own relay ticket. When there are none the
+// server holds the request for up to wait (at most 25s).
+func (c *Client) RelayReservations(ctx context.Context, wait time.Duration) ([]*relay.Reservation, error) {
+	var out struct {
+		Reservations []*relay.Reservation `json:"reservations"`
+	}
+	path := "/v1/relay/reservations"
+	if wait > 0 {
+		path += "?wait=" + url.QueryEscape(wait.String())
+	}
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Reservations, nil
+}
+
+// keysRefetch limits how often ServerKeys refetches the key set for
+// tokens signed with an unknown key.
+const keysRefetch = time.Minute
+
+// ServerKeys returns the key set verifying the server's tickets, fetched
+// from JWKSURL, for relays checking relay tickets. Tokens signed with a
+// key the set lacks trigger a refetch, at most once a minute, so key
+// rotation is followed. With Config.Cache set, it returns the cached key
+// set and a *StaleError while the server cannot be reached.
+func (c *Client) ServerKeys(ctx context.Context) (verify.KeySource, error) {
+	k := &serverKeys{url: c.JWKSURL(), http: c.http, client: c}
+	err := k.fetch(ctx)
+	if err == nil {
+		return k, nil
+	}
+	cachedAt, data, ok := c.cacheLoad(ctx, jwksCacheKey, err)
+	if !ok || k.set(data) != nil {
+		return nil, err
+	}
+	return k, &StaleError{CachedAt: cachedAt, Err: err}
+}
+
+// jwksCacheKey is the Cache key of the server's key set.
+const jwksCacheKey = "jwks"
+
+type serverKeys struct {
+	url    string
+	http   *http.Client
+	client *Client // caches fetched key sets
+
+	mu        sync.Mutex
+	validator *jwt.Validator
+	fetched   time.Time
+}
+
+func (k *serverKeys) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", k.url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := k.set(data); err != nil {
+		return err
+	}
+	if k.client.cache != nil {
+		k.client.cacheStore(ctx, jwksCacheKey, data)
+	}
+	return nil
+}
+
+// set replaces the key set with the JWKS in data.
+func (k *serverKeys) set(data []byte) error {
+	validator, err := jwt.NewValidatorFromJSON(string(data))
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.validator, k.fetched = validator, time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// GetKeyFunc implements verify.KeySource.
+func (k *serverKeys) GetKeyFunc() gojwt.Keyfunc {
+	return func(token *gojwt.Token) (interface{}, error) {
+		k.mu.Lock()
+		validator := k.validator
+		key, err := validator.GetKeyFunc()(token)
+		refetch := err != nil && time.Since(k.fetched) >= keysRefetch
+		if refetch {
+			// Failed fetches count too, so bad tokens cannot hammer the
+			// server.
+			k.fetched = time.Now()
+		}
+		k.mu.Unlock()
+		if !refetch {
+			return key, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := k.fetch(ctx); err != nil {
+			return nil, err
+		}
+		k.mu.Lock()
+		validator = k.validator
+		k.mu.Unlock()
+		return validator.GetKeyFunc()(token)
+	}
+}
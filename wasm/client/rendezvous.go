@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rendezvous"
+)
+
+// SendSignal sends a WebRTC signal of type typ (rendezvous.TypeOffer and
+// so on) to agent to, which must be live in the ticket's colony. data is
+// the session description or ICE candidate as JSON.
+func (c *Client) SendSignal(ctx context.Context, to, typ string, data json.RawMessage) (*rendezvous.Signal, error) {
+	body := struct {
+		To   string          `json:"to"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data,omitempty"`
+	}{to, typ, data}
+	var out rendezvous.Signal
+	if err := c.do(ctx, http.MethodPost, "/v1/rendezvous/signals", registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Signals takes the signals waiting for the ticket's agent, oldest first.
+// When there are none the server holds the request for up to wait (at
+// most 25s) before returning an empty slice.
+func (c *Client) Signals(ctx context.Context, wait time.Duration) ([]*rendezvous.Signal, error) {
+	var out struct {
+		Signals []*rendezvous.Signal `json:"signals"`
+	}
+	path := "/v1/rendezvous/signals"
+	if wait > 0 {
+		path += "?wait=" + url.QueryEscape(wait.String())
+	}
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Signals, nil
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Backoff configures exponential backoff with full jitter.
+type Backoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+	// Max caps the delay between attempts.
+	Max time.Duration
+	// Multiplier grows the delay after each attempt.
+	Multiplier float64
+	// MaxAttempts bounds the total number of attempts; 0 means unbounded.
+	MaxAttempts int
+}
+
+// DefaultBackoff retries up to 5 times starting at 100ms.
+var DefaultBackoff = Backoff{
+	Initial:     100 * time.Millisecond,
+	Max:         5 * time.Second,
+	Multiplier:  2,
+	MaxAttempts: 5,
+}
+
+// delay returns the jittered wait before retry number attempt (0-based).
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 0; i < attempt; i++ {
+		d *= b.Multiplier
+		if b.Max > 0 && d > float64(b.Max) {
+			d = float64(b.Max)
+			break
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// retry calls fn until it succeeds, returns a permanent error, the
+// attempts are exhausted or ctx is done. Rate limited attempts wait for
+// the server's Retry-After when it is longer than the backoff.
+func (b Backoff) retry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var p *permanentError
+		if errors.As(err, &p) || ctx.Err() != nil {
+			return unwrapPermanent(err)
+		}
+		if b.MaxAttempts > 0 && attempt+1 >= b.MaxAttempts {
+			return err
+		}
+
+		wait := b.delay(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// ColonySchema returns the metadata schema of colonyID of the ticket's
+// reef, or registry.ErrNotFound when the colony has none. Generate types
+// from it with schema.Compile and Schema.Generate, or coralctl
+// schema-gen, and read records' metadata with Metadata.
+func (c *Client) ColonySchema(ctx context.Context, colonyID string) (*registry.ColonySchema, error) {
+	var out registry.ColonySchema
+	if err := c.do(ctx, http.MethodGet, "/v1/colonies/"+url.PathEscape(colonyID)+"/schema", registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetColonySchema makes s, a JSON Schema, the metadata schema of
+// colonyID. Registrations whose metadata does not match it fail with
+// schema.ErrMismatch from then on.
+func (c *Client) SetColonySchema(ctx context.Context, colonyID string, s json.RawMessage) (*registry.ColonySchema, error) {
+	var out registry.ColonySchema
+	if err := c.do(ctx, http.MethodPut, schemaPath(colonyID), rbac.IntentAdmin, s, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteColonySchema removes the metadata schema of colonyID.
+func (c *Client) DeleteColonySchema(ctx context.Context, colonyID string) error {
+	return c.do(ctx, http.MethodDelete, schemaPath(colonyID), rbac.IntentAdmin, nil, nil)
+}
+
+func schemaPath(colonyID string) string {
+	return "/v1/admin/schemas/" + url.PathEscape(colonyID)
+}
+
+// Metadata decodes the metadata of rec into a T, typically the type
+// generated from its colony's schema:
+//
+//	meta, err := client.Metadata[edge.Metadata](rec)
+//
+// Records without metadata decode to a zero T.
+func Metadata[T any](rec *registry.Record) (*T, error) {
+	var out T
+	if len(rec.Metadata) == 0 {
+		return &out, nil
+	}
+	if err := json.Unmarshal(rec.Metadata, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode the metadata of agent %s: %w", rec.AgentID, err)
+	}
+	return &out, nil
+}
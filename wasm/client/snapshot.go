@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Snapshot writes a backup of the server's registry to w, in the binary
+// format of registry.Snapshot. The ticket source is asked for a ticket
+// with the registry.IntentBackup intent. Nothing is written to w unless
+// the server answers with a snapshot, and failed copies are not retried.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) error {
+	return c.backoff.retry(ctx, func() error {
+		resp, err := c.send(c.http, func() (*http.Request, error) {
+			return c.newRequest(ctx, http.MethodGet, "/v1/snapshot", registry.IntentBackup, nil)
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return decodeError(resp)
+		}
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return permanent(fmt.Errorf("failed to copy snapshot: %w", err))
+		}
+		return nil
+	})
+}
+
+// Restore replaces the server's registry state with the snapshot read
+// from r and returns the number of records restored. The ticket source is
+// asked for a ticket with the registry.IntentRestore intent.
+func (c *Client) Restore(ctx context.Context, r io.Reader) (int, error) {
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var out struct {
+		Restored int `json:"restored"`
+	}
+	err = c.backoff.retry(ctx, func() error {
+		resp, err := c.send(c.http, func() (*http.Request, error) {
+			req, err := c.newRequest(ctx, http.MethodPut, "/v1/snapshot", registry.IntentRestore, payload)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			return req, nil
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return decodeError(resp)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return permanent(fmt.Errorf("failed to decode response: %w", err))
+		}
+		return nil
+	})
+	return out.Restored, err
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/stun"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+)
+
+// ReflexiveEndpoints returns the endpoints to register for the UDP
+// socket conn: its private, non-loopback addresses followed by the server-reflexive
+// address the STUN server at stunAddr sees, such as corald with
+// -stun-addr. Peers on the same network use the former, peers elsewhere
+// the latter. Do not read from conn until it returns.
+func ReflexiveEndpoints(ctx context.Context, conn net.PacketConn, stunAddr string) ([]string, error) {
+	local, err := traversal.LocalCandidates(conn)
+	if err != nil {
+		return nil, err
+	}
+	// Loopback addresses are of no use to other hosts.
+	var endpoints []string
+	for _, ep := range local {
+		if ap, err := netip.ParseAddrPort(ep); err == nil && !ap.Addr().IsLoopback() {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	mapped, err := stun.Discover(ctx, conn, stunAddr)
+	if err != nil {
+		return nil, err
+	}
+	if public := mapped.String(); !slices.Contains(endpoints, public) {
+		endpoints = append(endpoints, public)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// StartThreshold opens a threshold signing session for the root ticket
+// described by req. Threshold requests ask the ticket source for the
+// threshold.IntentOperate intent.
+func (c *Client) StartThreshold(ctx context.Context, req *threshold.Request) (*threshold.Session, error) {
+	var out threshold.Session
+	if err := c.do(ctx, http.MethodPost, "/v1/threshold/sessions", threshold.IntentOperate, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ThresholdSession returns the current state of a signing session.
+func (c *Client) ThresholdSession(ctx context.Context, id string) (*threshold.Session, error) {
+	var out threshold.Session
+	if err := c.do(ctx, http.MethodGet, thresholdPath(id), threshold.IntentOperate, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CommitThreshold publishes an operator's round-one commitment.
+func (c *Client) CommitThreshold(ctx context.Context, id string, com *threshold.Commitment) (*threshold.Session, error) {
+	var out threshold.Session
+	if err := c.do(ctx, http.MethodPost, thresholdPath(id)+"/commitments", threshold.IntentOperate, com, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SubmitThresholdShare publishes an operator's signature share. The
+// session returned after the last share carries the signed ticket.
+func (c *Client) SubmitThresholdShare(ctx context.Context, id string, identifier uint16, share string) (*threshold.Session, error) {
+	body := map[string]interface{}{
+		"identifier": identifier,
+		"share":      share,
+	}
+	var out threshold.Session
+	if err := c.do(ctx, http.MethodPost, thresholdPath(id)+"/shares", threshold.IntentOperate, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func thresholdPath(id string) string {
+	return "/v1/threshold/sessions/" + url.PathEscape(id)
+}
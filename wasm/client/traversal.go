@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+)
+
+// InitiateTraversal opens a hole punching attempt to agent peer, which
+// must be live in the ticket's colony. candidates are the addresses of
+// the UDP socket to punch from, e.g. from traversal.LocalCandidates; the
+// server adds the public address it sees.
+func (c *Client) InitiateTraversal(ctx context.Context, peer string, candidates []string) (*traversal.Attempt, error) {
+	body := struct {
+		Peer       string   `json:"peer"`
+		Candidates []string `json:"candidates"`
+	}{peer, candidates}
+	var out traversal.Attempt
+	if err := c.do(ctx, http.MethodPost, "/v1/traversal/attempts", registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PendingTraversals returns the attempts waiting for the ticket's agent
+// to accept. When there are none the server holds the request for up to
+// wait (at most 25s) before returning an empty slice.
+func (c *Client) PendingTraversals(ctx context.Context, wait time.Duration) ([]*traversal.Attempt, error) {
+	var out struct {
+		Attempts []*traversal.Attempt `json:"attempts"`
+	}
+	path := "/v1/traversal/attempts"
+	if wait > 0 {
+		path += "?wait=" + url.QueryEscape(wait.String())
+	}
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Attempts, nil
+}
+
+// AcceptTraversal accepts attempt id with the candidates of the socket to
+// punch from. The returned attempt is ready; pass it to traversal.Punch
+// right away.
+func (c *Client) AcceptTraversal(ctx context.Context, id string, candidates []string) (*traversal.Attempt, error) {
+	body := struct {
+		Candidates []string `json:"candidates"`
+	}{candidates}
+	var out traversal.Attempt
+	if err := c.do(ctx, http.MethodPost, "/v1/traversal/attempts/"+url.PathEscape(id)+"/accept", registry.IntentRegister, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AwaitTraversal returns attempt id once its target accepted it, or as it
+// stands after up to wait (at most 25s); check Status before punching.
+func (c *Client) AwaitTraversal(ctx context.Context, id string, wait time.Duration) (*traversal.Attempt, error) {
+	path := "/v1/traversal/attempts/" + url.PathEscape(id)
+	if wait > 0 {
+		path += "?wait=" + url.QueryEscape(wait.String())
+	}
+	var out traversal.Attempt
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
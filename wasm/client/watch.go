@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// Watch streams registry changes matching filter from /v1/watch. The
+// stream reconnects with backoff when the connection drops or the server
+// asks for a resync, resuming after the last change received so that
+// changes made while disconnected are replayed, from the server's
+// stored records and tombstones once it no longer journals them (see
+// registry.Registry.WatchFrom). When the tombstones are gone too, an
+// event of type registry.EventResync is delivered in their place and the
+// stream continues from the current state; callers that need a complete
+// view should List then. A server shutting down
+// drains its streams; the stream then moves to another endpoint. The
+// channel is closed when ctx is done or reconnection is abandoned.
+func (c *Client) Watch(ctx context.Context, filter registry.Filter) (<-chan registry.Event, error) {
+	return c.WatchFrom(ctx, filter, "")
+}
+
+// WatchFrom is Watch, resuming after the change with cursor, the Cursor
+// of an event from an earlier watch, so that a watcher can pick up where
+// it left off across restarts.
+func (c *Client) WatchFrom(ctx context.Context, filter registry.Filter, cursor string) (<-chan registry.Event, error) {
+	q := filterQuery(filter)
+	path := "/v1/watch"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	// Establish the first connection synchronously so configuration and
+	// authentication errors surface to the caller.
+	var pending []registry.Event
+	open := func() (*http.Response, error) {
+		var resp *http.Response
+		err := c.backoff.retry(ctx, func() error {
+			var err error
+			resp, err = c.openStream(ctx, path, cursor)
+			if errors.Is(err, registry.ErrCursorExpired) {
+				pending = append(pending, registry.Event{Type: registry.EventResync})
+				cursor = ""
+				resp, err = c.openStream(ctx, path, cursor)
+			}
+			return err
+		})
+		return resp, err
+	}
+	resp, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan registry.Event, 64)
+	go func() {
+		defer close(out)
+		for {
+			for _, ev := range pending {
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			pending = nil
+			last, drained := readEvents(ctx, resp, out)
+			if last != "" {
+				cursor = last
+			}
+			if drained {
+				c.endpoints.mark(resp.Request.URL, errDraining)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if resp, err = open(); err != nil {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Delta returns the changes to the records matching filter since the
+// Through of an earlier delta, or every record for a zero since (see
+// registry.Delta). Holders of a view of the registry apply the records
+// and drop the agents of the tombstones, and of the records that have
+// expired. Delta fails with registry.ErrCursorExpired when since is
+// older than the tombstones the server keeps; list again then.
+func (c *Client) Delta(ctx context.Context, filter registry.Filter, since registry.Timestamp) (*registry.Delta, error) {
+	q := filterQuery(filter)
+	if !since.IsZero() {
+		q.Set("since", since.String())
+	}
+	path := "/v1/changes"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out registry.Delta
+	if err := c.do(ctx, http.MethodGet, path, registry.IntentRegister, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// filterQuery encodes filter as the query parameters of /v1/watch and
+// /v1/changes.
+func filterQuery(filter registry.Filter) url.Values {
+	q := url.Values{}
+	if filter.ColonyID != "" {
+		q.Set("colony_id", filter.ColonyID)
+	}
+	if filter.ReefID != "" {
+		q.Set("reef_id", filter.ReefID)
+	}
+	if filter.Capability != "" {
+		q.Set("capability", filter.Capability)
+	}
+	if s := filter.Selector.String(); s != "" {
+		q.Set("selector", s)
+	}
+	if filter.Topic != "" {
+		q.Set("topic", filter.Topic)
+	}
+	if filter.TopicRole != registry.AnyRole {
+		q.Set("topic_role", string(filter.TopicRole))
+	}
+	return q
+}
+
+// openStream opens an event stream connection, resuming after cursor
+// when set.
+func (c *Client) openStream(ctx context.Context, path, cursor string) (*http.Response, error) {
+	resp, err := c.send(c.stream, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, path, registry.IntentRegister, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if cursor != "" {
+			req.Header.Set("Last-Event-ID", cursor)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	if _, err := c.servedVersion(resp); err != nil {
+		resp.Body.Close()
+		return nil, permanent(err)
+	}
+	return resp, nil
+}
+
+// errDraining marks the endpoint of a drained stream down.
+var errDraining = errors.New("server is draining")
+
+// readEvents parses Server-Sent Events from resp until the stream ends, a
+// resync is requested, the server drains or ctx is done. It returns the
+// cursor of the last event delivered and whether the server drained.
+func readEvents(ctx context.Context, resp *http.Response, out chan<- registry.Event) (last string, drained bool) {
+	defer resp.Body.Close()
+	version, _ := wire.Parse(resp.Header.Get(wire.ContentHeader))
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	var id, name, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if name == "resync" || name == "drain" {
+				return last, name == "drain"
+			}
+			if ev, err := parseEvent(name, data, version); err == nil {
+				ev.Cursor = id
+				select {
+				case out <- ev:
+					last = id
+				case <-ctx.Done():
+					return last, false
+				}
+			}
+			id, name, data = "", "", ""
+		case strings.HasPrefix(line, ":"):
+			// Keep-alive comment.
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	return last, false
+}
+
+// parseEvent decodes a single SSE event, its record encoded in the
+// schema version, into a registry.Event.
+func parseEvent(name, data string, version int) (registry.Event, error) {
+	var typ registry.EventType
+	switch name {
+	case "put":
+		typ = registry.EventPut
+	case "delete":
+		typ = registry.EventDelete
+	case "expire":
+		typ = registry.EventExpire
+	case "suspect":
+		typ = registry.EventSuspect
+	case "alive":
+		typ = registry.EventAlive
+	case "fail":
+		typ = registry.EventFail
+	default:
+		return registry.Event{}, errors.New("unknown event " + name)
+	}
+
+	var rec registry.Record
+	if err := wire.Unmarshal([]byte(data), version, &rec); err != nil {
+		return registry.Event{}, err
+	}
+	return registry.Event{Type: typ, Record: &rec}, nil
+}
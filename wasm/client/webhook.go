@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/webhook"
+)
+
+// AdminDeadLetters returns the webhook events the server could not
+// deliver, oldest first.
+func (c *Client) AdminDeadLetters(ctx context.Context) ([]*webhook.DeadLetter, error) {
+	var out struct {
+		DeadLetters []*webhook.DeadLetter `json:"dead_letters"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/webhooks/dead-letters", rbac.IntentAdmin, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.DeadLetters, nil
+}
+
+// RedeliverDeadLetter makes the server deliver a dead letter once more,
+// removing it from the queue on success.
+func (c *Client) RedeliverDeadLetter(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, deadLetterPath(id)+"/redeliver", rbac.IntentAdmin, nil, nil)
+}
+
+// DiscardDeadLetter removes a dead letter without delivering it.
+func (c *Client) DiscardDeadLetter(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, deadLetterPath(id), rbac.IntentAdmin, nil, nil)
+}
+
+func deadLetterPath(id string) string {
+	return "/v1/admin/webhooks/dead-letters/" + url.PathEscape(id)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+func runAdminAgents(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-agents", flag.ExitOnError)
+	conn.register(fs)
+	colony := fs.String("filter-colony", "", "list only this colony's agents")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	recs, err := c.AdminAgents(ctx, *colony)
+	if err != nil {
+		return err
+	}
+	return printJSON(recs)
+}
+
+func runAdminEvict(ctx context.Context, args []string) error {
+	c, id, err := agentCommand("admin-evict", args)
+	if err != nil {
+		return err
+	}
+	return c.Evict(ctx, id)
+}
+
+func runAdminExpire(ctx context.Context, args []string) error {
+	c, id, err := agentCommand("admin-expire", args)
+	if err != nil {
+		return err
+	}
+	rec, err := c.ExpireLease(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+func runAdminRotateKeys(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-rotate-keys", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	kid, err := c.RotateKeys(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(kid)
+	return nil
+}
+
+func runAdminRevocations(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-revocations", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	revs, err := c.AdminRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(revs)
+}
+
+func runAdminQuotas(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-quotas", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 1 {
+		status, err := c.ColonyQuota(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return printJSON(status)
+	}
+	def, quotas, err := c.ColonyQuotas(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"default": def, "quotas": quotas})
+}
+
+func runAdminSetQuota(ctx context.Context, args []string) error {
+	var conn connFlags
+	var q registry.ColonyQuota
+	fs := flag.NewFlagSet("admin-set-quota", flag.ExitOnError)
+	conn.register(fs)
+	fs.IntVar(&q.MaxAgents, "max-agents", 0, "most agents the colony may register (0 for no limit)")
+	fs.IntVar(&q.RegistrationsPerMinute, "registrations-per-minute", 0, "most registrations per minute (0 for no limit)")
+	fs.IntVar(&q.MaxWatches, "max-watches", 0, "most watch streams open at once (0 for no limit)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: coralctl admin-set-quota [flags] <colony-id>")
+	}
+	q.ColonyID = fs.Arg(0)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	stored, err := c.SetColonyQuota(ctx, &q)
+	if err != nil {
+		return err
+	}
+	return printJSON(stored)
+}
+
+func runAdminResetQuota(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-reset-quota", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: coralctl admin-reset-quota [flags] <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	return c.ResetColonyQuota(ctx, fs.Arg(0))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+)
+
+// runAllowlistSign signs a colony's endpoint allowlist with a key signing
+// its tickets and prints it, for allowlist-publish.
+func runAllowlistSign(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("allowlist-sign", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "key document signing the colony's tickets, or gcpkms://<key version>")
+	var list allowlist.List
+	var cidrs, domains stringList
+	fs.StringVar(&list.ReefID, "reef", "", "reef ID")
+	fs.StringVar(&list.ColonyID, "colony", "", "colony ID")
+	fs.Var(&cidrs, "cidr", "network of allowed IP endpoints (repeatable)")
+	fs.Var(&domains, "domain", "allowed host name, or *.name for any name below it (repeatable)")
+	fs.Func("pin", "fixed endpoint of an agent, as `agent=host:port` (repeatable)", func(v string) error {
+		agent, endpoint, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("pin %q is not agent=host:port", v)
+		}
+		if list.Pins == nil {
+			list.Pins = map[string][]string{}
+		}
+		list.Pins[agent] = append(list.Pins[agent], endpoint)
+		return nil
+	})
+	ttl := fs.Duration("ttl", 0, "allowlist lifetime (0 for no expiry)")
+	fs.Parse(args)
+
+	list.CIDRs, list.Domains = cidrs, domains
+	if list.ReefID == "" || list.ColonyID == "" {
+		return errors.New("-reef and -colony are required")
+	}
+	s, err := openSigner(ctx, *keyFile)
+	if err != nil {
+		return err
+	}
+	token, err := allowlist.Sign(ctx, s, list, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// runAllowlistPublish publishes a signed allowlist, read from -in or
+// stdin, as the endpoint allowlist of a colony.
+func runAllowlistPublish(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("allowlist-publish", flag.ExitOnError)
+	conn.register(fs)
+	in := fs.String("in", "", "file holding the allowlist printed by allowlist-sign (default stdin)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl allowlist-publish [-in allowlist.jwt] <colony-id>")
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	token, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	published, err := c.PublishAllowlist(ctx, fs.Arg(0), strings.TrimSpace(string(token)))
+	if err != nil {
+		return err
+	}
+	return printJSON(published.Claims)
+}
+
+// runAllowlist prints the endpoint allowlist published for a colony.
+func runAllowlist(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("allowlist", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl allowlist <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	published, err := c.Allowlist(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(published)
+}
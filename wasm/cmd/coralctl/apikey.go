@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// runAPIKey generates an API key and prints it with the entry to add to
+// corald's -api-keys document, which holds only the key's hash.
+func runAPIKey(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("api-key", flag.ExitOnError)
+	id := fs.String("id", "", "key ID, shown in audit events")
+	reef := fs.String("reef", "", "reef ID")
+	colony := fs.String("colony", "", "colony ID")
+	agent := fs.String("agent", "", "agent ID the key acts as")
+	intent := fs.String("intent", registry.IntentRegister, "intent the key acts with")
+	ttl := fs.Duration("ttl", 0, "key lifetime (0 never expires)")
+	var roles stringList
+	fs.Var(&roles, "role", "role granted to the key for the admin API (repeatable)")
+	fs.Parse(args)
+
+	if *id == "" || *reef == "" || *colony == "" || *agent == "" {
+		return errors.New("-id, -reef, -colony and -agent are required")
+	}
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return err
+	}
+	entry := auth.APIKey{ID: *id, Hash: hash, ReefID: *reef, ColonyID: *colony, AgentID: *agent, Intent: *intent, Roles: roles}
+	if *ttl > 0 {
+		expires := time.Now().Add(*ttl).UTC().Truncate(time.Second)
+		entry.ExpiresAt = &expires
+	}
+	return printJSON(map[string]interface{}{"key": key, "entry": entry})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+)
+
+// runAuditVerify checks the hash chain of an audit log written by corald
+// -audit-file and prints its length and head.
+func runAuditVerify(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	in := fs.String("in", "", "audit log to verify (default stdin)")
+	fs.Parse(args)
+
+	log := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log = f
+	}
+
+	last, n, err := audit.Verify(log)
+	if err != nil {
+		return fmt.Errorf("%w (%d records intact)", err, n)
+	}
+	out := map[string]interface{}{"records": n}
+	if last != nil {
+		out["seq"], out["head"] = last.Seq, last.Hash
+	}
+	return printJSON(out)
+}
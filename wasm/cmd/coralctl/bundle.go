@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+)
+
+// runJWKSSign signs a discovery server's JWKS document with the reef root
+// key and prints the bundle, for corald -jwks-bundle, mirrors or DNS.
+func runJWKSSign(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("jwks-sign", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "the reef's root key document, or gcpkms://<key version>")
+	reef := fs.String("reef", "", "reef ID")
+	server := fs.String("server", "", "base URL of the discovery server whose keys to sign")
+	file := fs.String("jwks", "", "JWKS file to sign instead of fetching it from -server")
+	ttl := fs.Duration("ttl", 7*24*time.Hour, "bundle lifetime; re-sign before it ends")
+	fs.Parse(args)
+
+	if *reef == "" || (*server == "") == (*file == "") {
+		return errors.New("-reef and one of -server or -jwks are required")
+	}
+	var doc []byte
+	var err error
+	if *file != "" {
+		doc, err = os.ReadFile(*file)
+	} else {
+		doc, err = fetchJWKS(ctx, strings.TrimSuffix(*server, "/")+jwks.Path)
+	}
+	if err != nil {
+		return err
+	}
+	s, err := openSigner(ctx, *keyFile)
+	if err != nil {
+		return err
+	}
+	bundle, err := jwks.SignBundle(ctx, s, *reef, doc, *ttl)
+	if err != nil {
+		return err
+	}
+	return printJSON(bundle)
+}
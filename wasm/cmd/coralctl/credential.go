@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/did"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/vc"
+)
+
+// runCredential issues a colony membership Verifiable Credential and
+// prints it. The subject is -subject or the did:web of -agent.
+func runCredential(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("credential", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "issuing key document, or gcpkms://<key version>")
+	reef := fs.String("reef", "", "reef ID")
+	colony := fs.String("colony", "", "colony ID")
+	agent := fs.String("agent", "", "member agent ID, named by its did:web under -did-domain")
+	domain := fs.String("did-domain", "", "host serving agent DID documents (corald -did-domain)")
+	subject := fs.String("subject", "", "member DID, instead of -agent")
+	ttl := fs.Duration("ttl", 30*24*time.Hour, "credential lifetime (0 for no expiry)")
+	fs.Parse(args)
+
+	if *subject == "" {
+		if *agent == "" || *domain == "" {
+			return errors.New("-subject, or -agent with -did-domain, is required")
+		}
+		*subject = did.New(did.Config{Domain: *domain}).AgentDID(*agent)
+	}
+	s, err := openSigner(ctx, *keyFile)
+	if err != nil {
+		return err
+	}
+	c, err := vc.Issue(ctx, s, vc.Membership{
+		SubjectDID: *subject,
+		ReefID:     *reef,
+		ColonyID:   *colony,
+		TTL:        *ttl,
+	})
+	if err != nil {
+		return err
+	}
+	return printJSON(c)
+}
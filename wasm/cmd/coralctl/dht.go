@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/dht"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// runDHTNode runs a DHT node until interrupted, optionally publishing an
+// agent record signed with -key-file.
+func runDHTNode(ctx context.Context, args []string) error {
+	var bootstrap, endpoints, capabilities stringList
+	rec := &registry.Record{}
+	fs := flag.NewFlagSet("dht-node", flag.ExitOnError)
+	listen := fs.String("listen", ":7946", "UDP address to listen on")
+	keyFile := fs.String("key-file", "", "node key file (a key is generated when empty)")
+	fs.Var(&bootstrap, "bootstrap", "host:port of a known node (repeatable)")
+	fs.StringVar(&rec.ReefID, "reef", "", "reef ID of the record to publish")
+	fs.StringVar(&rec.ColonyID, "colony", "", "colony ID of the record to publish")
+	fs.StringVar(&rec.AgentID, "agent", "", "agent ID of the record to publish (publishes nothing when empty)")
+	fs.Var(&endpoints, "endpoint", "agent endpoint (repeatable)")
+	fs.Var(&capabilities, "capability", "agent capability (repeatable)")
+	ttl := fs.Duration("ttl", dht.DefaultRecordTTL, "record lifetime")
+	fs.Parse(args)
+	rec.Endpoints, rec.Capabilities = endpoints, capabilities
+
+	cfg := dht.Config{Addr: *listen, Bootstrap: bootstrap, RecordTTL: *ttl}
+	if *keyFile != "" {
+		kp, err := readKeyPair(*keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Key = kp
+	}
+	node, err := dht.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	if err := node.Join(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "dht node %s listening on %s with %d peers\n", node.ID(), node.Addr(), node.Peers())
+
+	if rec.AgentID != "" {
+		if err := node.Publish(ctx, rec, nil); err != nil {
+			return err
+		}
+	}
+	node.Run(ctx)
+	return nil
+}
+
+// runDHTLookup looks up an agent, or lists a colony with -colony, through
+// the DHT.
+func runDHTLookup(ctx context.Context, args []string) error {
+	var bootstrap stringList
+	fs := flag.NewFlagSet("dht-lookup", flag.ExitOnError)
+	fs.Var(&bootstrap, "bootstrap", "host:port of a known node (repeatable)")
+	colony := fs.String("colony", "", "list this colony instead of looking up an agent")
+	fs.Parse(args)
+	if len(bootstrap) == 0 {
+		return errors.New("-bootstrap is required")
+	}
+	if (*colony == "") == (fs.NArg() == 0) {
+		return errors.New("pass either an agent ID or -colony")
+	}
+
+	node, err := dht.New(dht.Config{Bootstrap: bootstrap})
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	if err := node.Join(ctx); err != nil {
+		return err
+	}
+	if *colony != "" {
+		recs, err := node.List(ctx, *colony)
+		if err != nil {
+			return err
+		}
+		return printJSON(recs)
+	}
+	rec, err := node.Lookup(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+// readKeyPair reads a key file as a keys.KeyPair.
+func readKeyPair(path string) (*keys.KeyPair, error) {
+	key, err := readSigningKey(path)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := keys.DecodePrivateKey(strings.TrimSpace(key.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &keys.KeyPair{ID: key.ID, PrivateKey: privateKey, PublicKey: privateKey.Public().(ed25519.PublicKey)}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+)
+
+// runCampaign campaigns for an election of the agent's colony, prints
+// the term once elected and holds it until interrupted, then resigns.
+func runCampaign(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("campaign", flag.ExitOnError)
+	conn.register(fs)
+	ttl := fs.Duration("ttl", election.DefaultTTL, "lease of the term, renewed at a third of it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl campaign [-ttl 15s] <election>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	lead, err := c.CampaignTTL(ctx, fs.Arg(0), *ttl)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(lead.Term()); err != nil {
+		return err
+	}
+	select {
+	case <-lead.Done():
+		return lead.Err()
+	case <-ctx.Done():
+	}
+	resignCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return lead.Resign(resignCtx)
+}
+
+// runLeader prints the current term of an election of the agent's
+// colony.
+func runLeader(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("leader", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl leader <election>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	l, err := c.Leader(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(l)
+}
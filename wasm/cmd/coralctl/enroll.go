@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/client"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/enrollment"
+)
+
+// runEnroll submits a CSR for the agent's key under an enrollment ticket
+// and, with -wait, polls until the enrollment is decided.
+func runEnroll(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	conn.register(fs)
+	agentKey := fs.String("agent-key-file", "", "the agent's key, enrolled as its identity")
+	var labels stringList
+	fs.Var(&labels, "label", "key=value label shown to approvers (repeatable)")
+	wait := fs.Duration("wait", 0, "poll this long for a decision (0 returns the pending enrollment)")
+	fs.Parse(args)
+
+	key, err := readSigningKey(*agentKey)
+	if err != nil {
+		return err
+	}
+	priv, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode agent key: %w", err)
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	reef, colony, agent, err := enrollIdentity(&conn)
+	if err != nil {
+		return err
+	}
+	lbls := make(map[string]string, len(labels))
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return fmt.Errorf("-label: expected key=value, got %q", l)
+		}
+		lbls[k] = v
+	}
+	csr, err := enrollment.NewCSR(priv, reef, colony, agent, lbls)
+	if err != nil {
+		return err
+	}
+
+	en, err := c.Enroll(ctx, csr)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(*wait)
+	for en.Status == enrollment.StatusPending && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+		if en, err = c.Enrollment(ctx, en.ID); err != nil {
+			return err
+		}
+	}
+	return printJSON(en)
+}
+
+// enrollIdentity returns the reef, colony and agent the enrollment
+// ticket names: the -reef, -colony and -agent flags when minting, else
+// the ticket's claims.
+func enrollIdentity(conn *connFlags) (reef, colony, agent string, err error) {
+	if conn.keyFile != "" {
+		return conn.reef, conn.colony, conn.agent, nil
+	}
+	if conn.ticket == "" {
+		return "", "", "", errors.New("-ticket, $CORAL_TICKET or -key-file is required")
+	}
+	var claims jwt.ReferralClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(conn.ticket, &claims); err != nil {
+		return "", "", "", fmt.Errorf("failed to parse ticket: %w", err)
+	}
+	return claims.ReefID, claims.ColonyID, claims.AgentID, nil
+}
+
+func runEnrollment(ctx context.Context, args []string) error {
+	c, id, err := enrollmentCommand("enrollment", args, nil)
+	if err != nil {
+		return err
+	}
+	en, err := c.Enrollment(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(en)
+}
+
+func runAdminEnrollments(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-enrollments", flag.ExitOnError)
+	conn.register(fs)
+	status := fs.String("status", "", "list only enrollments in this status: pending, approved or denied")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	ens, err := c.AdminEnrollments(ctx, *status)
+	if err != nil {
+		return err
+	}
+	return printJSON(ens)
+}
+
+func runAdminApprove(ctx context.Context, args []string) error {
+	c, id, err := enrollmentCommand("admin-approve", args, nil)
+	if err != nil {
+		return err
+	}
+	en, err := c.ApproveEnrollment(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(en)
+}
+
+func runAdminDeny(ctx context.Context, args []string) error {
+	var reason string
+	c, id, err := enrollmentCommand("admin-deny", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&reason, "reason", "", "why the enrollment is denied, shown to the agent")
+	})
+	if err != nil {
+		return err
+	}
+	en, err := c.DenyEnrollment(ctx, id, reason)
+	if err != nil {
+		return err
+	}
+	return printJSON(en)
+}
+
+// enrollmentCommand parses the flags of a command taking an enrollment
+// ID, with extra registering its own flags, and builds the client.
+func enrollmentCommand(name string, args []string, extra func(*flag.FlagSet)) (*client.Client, string, error) {
+	var conn connFlags
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conn.register(fs)
+	if extra != nil {
+		extra(fs)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return nil, "", fmt.Errorf("usage: coralctl %s [flags] <enrollment-id>", name)
+	}
+	c, err := conn.client()
+	return c, fs.Arg(0), err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+)
+
+// runFederate signs a trust anchor for a peer reef with the local reef's
+// root key and prints it, for corald -federation-anchors. The peer's key
+// set is -peer-jwks or fetched from its server.
+func runFederate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("federate", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "the local reef's root key document, or gcpkms://<key version>")
+	reef := fs.String("reef", "", "local reef ID")
+	peerReef := fs.String("peer-reef", "", "federated reef ID")
+	peerURL := fs.String("peer-url", "", "base URL of the federated reef's discovery server")
+	peerJWKS := fs.String("peer-jwks", "", "JWKS file of the peer server's keys (default fetched from -peer-url)")
+	ttl := fs.Duration("ttl", 365*24*time.Hour, "anchor lifetime")
+	fs.Parse(args)
+
+	if *reef == "" || *peerReef == "" || *peerURL == "" {
+		return errors.New("-reef, -peer-reef and -peer-url are required")
+	}
+	var doc []byte
+	var err error
+	if *peerJWKS != "" {
+		doc, err = os.ReadFile(*peerJWKS)
+	} else {
+		doc, err = fetchJWKS(ctx, strings.TrimSuffix(*peerURL, "/")+jwks.Path)
+	}
+	if err != nil {
+		return err
+	}
+	s, err := openSigner(ctx, *keyFile)
+	if err != nil {
+		return err
+	}
+	token, err := federation.SignAnchor(ctx, s, *reef, federation.Peer{
+		Reef: *peerReef,
+		URL:  *peerURL,
+		JWKS: doc,
+	}, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// fetchJWKS downloads the JWKS document at url.
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/gossip"
+)
+
+// runGossip joins a colony's gossip as -name and prints other members'
+// state changes until interrupted, then leaves.
+func runGossip(ctx context.Context, args []string) error {
+	var seeds stringList
+	cfg := gossip.Config{Reporter: printReporter{}}
+	fs := flag.NewFlagSet("gossip", flag.ExitOnError)
+	fs.StringVar(&cfg.Name, "name", "", "member name, normally the agent ID")
+	fs.StringVar(&cfg.Colony, "colony", "", "colony ID")
+	fs.StringVar(&cfg.Reef, "reef", "", "reef ID of the colony")
+	fs.StringVar(&cfg.Addr, "listen", gossip.DefaultAddr, "UDP address to listen on")
+	fs.StringVar(&cfg.Advertise, "advertise", "", "address peers reach this member at (default the listen address)")
+	fs.Var(&seeds, "seed", "host:port of a colony member (repeatable)")
+	secretFile := fs.String("secret-file", "", "file holding the colony's gossip authentication secret")
+	fs.Parse(args)
+	cfg.Seeds = seeds
+
+	if *secretFile != "" {
+		secret, err := os.ReadFile(*secretFile)
+		if err != nil {
+			return err
+		}
+		cfg.Secret = bytes.TrimSpace(secret)
+	}
+	node, err := gossip.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	if err := node.Join(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "gossiping with colony %s on %s; %d members known\n", cfg.Colony, node.Addr(), len(node.Members()))
+
+	node.Run(ctx)
+	leaveCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return node.Leave(leaveCtx)
+}
+
+// printReporter prints gossip state changes.
+type printReporter struct{}
+
+func (printReporter) MarkSuspect(_ context.Context, _, colonyID, agentID string) error {
+	return printChange("suspect", colonyID, agentID)
+}
+
+func (printReporter) MarkAlive(_ context.Context, _, colonyID, agentID string) error {
+	return printChange("alive", colonyID, agentID)
+}
+
+func (printReporter) MarkFailed(_ context.Context, _, colonyID, agentID string) error {
+	return printChange("fail", colonyID, agentID)
+}
+
+func printChange(state, colonyID, agentID string) error {
+	_, err := fmt.Printf("%s\t%s\t%s\n", state, colonyID, agentID)
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+)
+
+// runHistory lists the archived registry changes of the ticket's reef.
+func runHistory(ctx context.Context, args []string) error {
+	var conn connFlags
+	var q history.Query
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	conn.register(fs)
+	fs.Func("since", "only list changes after this RFC 3339 `time`", timeFlag(&q.Since))
+	fs.Func("until", "only list changes up to this RFC 3339 `time`", timeFlag(&q.Until))
+	fs.StringVar(&q.ColonyID, "filter-colony", "", "only list changes to agents of this colony")
+	fs.IntVar(&q.Limit, "limit", 0, "page size (default 500)")
+	fs.StringVar(&q.Cursor, "cursor", "", "next_cursor of the previous page")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	page, err := c.History(ctx, q)
+	if err != nil {
+		return err
+	}
+	return printJSON(page)
+}
+
+// runTopology lists the agents of the ticket's reef that were registered
+// at a past time.
+func runTopology(ctx context.Context, args []string) error {
+	var conn connFlags
+	var at time.Time
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	conn.register(fs)
+	fs.Func("at", "RFC 3339 `time` to reconstruct the topology at", timeFlag(&at))
+	colony := fs.String("filter-colony", "", "only list agents of this colony")
+	fs.Parse(args)
+	if at.IsZero() {
+		return errors.New("usage: coralctl topology -at <time> [flags]")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	recs, err := c.Topology(ctx, *colony, at)
+	if err != nil {
+		return err
+	}
+	return printJSON(recs)
+}
+
+// timeFlag parses an RFC 3339 time flag into t.
+func timeFlag(t *time.Time) func(string) error {
+	return func(v string) (err error) {
+		*t, err = time.Parse(time.RFC3339, v)
+		return err
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/caveat"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/hdkey"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/keyseal"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/mnemonic"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+)
+
+// passphraseEnv names the environment variable holding the passphrase of
+// sealed key files.
+const passphraseEnv = "CORAL_KEY_PASSPHRASE"
+
+// signingKey is the {id, privateKey} document used by the
+// DISCOVERY_SIGNING_KEY secret. Sealed documents carry sealedPrivateKey
+// instead of privateKey.
+type signingKey struct {
+	ID               string `json:"id"`
+	PrivateKey       string `json:"privateKey,omitempty"`
+	SealedPrivateKey string `json:"sealedPrivateKey,omitempty"`
+}
+
+// runKeygen prints a new signing key document together with its JWKS.
+func runKeygen(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	seal := fs.Bool("seal", false, "seal the private key with $"+passphraseEnv)
+	fs.Parse(args)
+
+	kp, err := keys.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	key := signingKey{ID: kp.ID, PrivateKey: keys.EncodePrivateKey(kp.PrivateKey)}
+	if *seal {
+		if key, err = sealKey(key.ID, kp.PrivateKey); err != nil {
+			return err
+		}
+	}
+	return printJSON(map[string]interface{}{
+		"signing_key": key,
+		"public_key":  keys.EncodePublicKey(kp.PublicKey),
+		"jwks":        keys.KeyPairsToJWKS([]*keys.KeyPair{kp}),
+	})
+}
+
+// runSeal prints the sealed form of a plaintext key file.
+func runSeal(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("seal", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "plaintext signing key document")
+	fs.Parse(args)
+
+	key, err := readSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	privateKey, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode private key: %w", err)
+	}
+	sealed, err := sealKey(key.ID, privateKey)
+	if err != nil {
+		return err
+	}
+	return printJSON(sealed)
+}
+
+// runDerive prints the key derived from a master key file at a path.
+func runDerive(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("derive", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "colony master key document")
+	agent := fs.String("agent", "", "derive the signing key of this agent")
+	path := fs.String("path", "", "explicit derivation path, e.g. agent:A/session:1")
+	fs.Parse(args)
+
+	if *agent != "" {
+		p, err := hdkey.AgentPath(*agent)
+		if err != nil {
+			return err
+		}
+		*path = p
+	}
+	if *path == "" {
+		return errors.New("-agent or -path is required")
+	}
+	key, err := readSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	privateKey, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	kp, err := hdkey.DeriveKeyPair(&keys.KeyPair{ID: key.ID, Algorithm: "EdDSA", PrivateKey: privateKey}, *path)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"signing_key": signingKey{ID: kp.ID, PrivateKey: keys.EncodePrivateKey(kp.PrivateKey)},
+		"public_key":  keys.EncodePublicKey(kp.PublicKey),
+		"jwks":        map[string]interface{}{"keys": []hdkey.JWK{hdkey.ToJWK(kp, key.ID, *path)}},
+	})
+}
+
+// runBackup prints the mnemonic backup of a key file.
+func runBackup(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "signing key document")
+	fs.Parse(args)
+
+	key, err := readSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	privateKey, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode private key: %w", err)
+	}
+	phrase, err := mnemonic.FromKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]string{"id": key.ID, "mnemonic": phrase})
+}
+
+// runRestore rebuilds a key file from a mnemonic read from stdin. The key
+// ID is not part of the mnemonic and must be given.
+func runRestore(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	id := fs.String("id", "", "key ID recorded with the backup")
+	seal := fs.Bool("seal", false, "seal the restored key with $"+passphraseEnv)
+	fs.Parse(args)
+
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+	phrase, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	privateKey, err := mnemonic.ToKey(string(phrase))
+	if err != nil {
+		return err
+	}
+
+	key := signingKey{ID: *id, PrivateKey: keys.EncodePrivateKey(privateKey)}
+	if *seal {
+		if key, err = sealKey(key.ID, privateKey); err != nil {
+			return err
+		}
+	}
+	kp := &keys.KeyPair{ID: *id, PrivateKey: privateKey, PublicKey: privateKey.Public().(ed25519.PublicKey)}
+	return printJSON(map[string]interface{}{
+		"signing_key": key,
+		"public_key":  keys.EncodePublicKey(kp.PublicKey),
+		"jwks":        keys.KeyPairsToJWKS([]*keys.KeyPair{kp}),
+	})
+}
+
+// sealKey seals privateKey with the passphrase from the environment.
+func sealKey(id string, privateKey ed25519.PrivateKey) (signingKey, error) {
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return signingKey{}, errors.New("$" + passphraseEnv + " is required to seal keys")
+	}
+	sealed, err := keyseal.Seal(privateKey, []byte(passphrase))
+	if err != nil {
+		return signingKey{}, err
+	}
+	return signingKey{ID: id, SealedPrivateKey: sealed}, nil
+}
+
+// runTicket mints a referral ticket and prints it.
+func runTicket(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("ticket", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "signing key document from keygen, or gcpkms://<key version>")
+	reef := fs.String("reef", "", "reef ID")
+	colony := fs.String("colony", "", "colony ID")
+	agent := fs.String("agent", "", "agent ID")
+	intent := fs.String("intent", registry.IntentRegister, "ticket intent")
+	ttl := fs.Duration("ttl", 5*time.Minute, "ticket lifetime")
+	delegateTo := fs.String("delegation-key", "", "base64 public key allowed to delegate this ticket")
+	bindTo := fs.String("bind", "", "base64 holder public key the ticket is bound to (cnf)")
+	spiffeID := fs.String("spiffe-id", "", "SPIFFE ID whose JWT-SVID must accompany the ticket")
+	attenuable := fs.Bool("attenuable", false, "mint an attenuable token that holders narrow offline with coralctl attenuate (-agent optional)")
+	var roles stringList
+	fs.Var(&roles, "role", "role granted to the ticket for the admin API (repeatable)")
+	fs.Parse(args)
+
+	if *attenuable {
+		if countSet(*delegateTo, *bindTo, *spiffeID) > 0 || len(roles) > 0 {
+			return errors.New("-attenuable cannot be combined with -delegation-key, -bind, -spiffe-id or -role")
+		}
+		return mintAttenuable(*keyFile, caveat.Authority{
+			ReefID:   *reef,
+			ColonyID: *colony,
+			AgentID:  *agent,
+			Intent:   *intent,
+			TTL:      *ttl,
+		})
+	}
+
+	if n := countSet(*delegateTo, *bindTo, *spiffeID); n > 1 {
+		return errors.New("-delegation-key, -bind and -spiffe-id cannot be combined")
+	} else if n > 0 && len(roles) > 0 {
+		return errors.New("-role cannot be combined with -delegation-key, -bind or -spiffe-id")
+	}
+	minter, err := newMinter(*keyFile, *reef, *colony, *agent)
+	if err != nil {
+		return err
+	}
+	minter.roles = roles
+	var token string
+	switch {
+	case *delegateTo != "":
+		token, err = minter.mintDelegable(*intent, *ttl, *delegateTo)
+	case *bindTo != "":
+		token, err = minter.mintBound(*intent, *ttl, *bindTo)
+	case *spiffeID != "":
+		token, err = minter.mintSPIFFE(*intent, *ttl, *spiffeID)
+	default:
+		token, err = minter.mint(*intent, *ttl)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// runDelegate mints a child ticket from a delegable parent.
+func runDelegate(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("delegate", flag.ExitOnError)
+	parent := fs.String("parent", "", "parent ticket")
+	keyFile := fs.String("key-file", "", "holder key document matching the parent's delegation key")
+	agent := fs.String("agent", "", "agent ID the child ticket is for")
+	intent := fs.String("intent", "", "child intent; must equal or extend the parent's")
+	ttl := fs.Duration("ttl", 5*time.Minute, "ticket lifetime, capped at the parent's expiry")
+	delegateTo := fs.String("delegation-key", "", "base64 public key allowed to delegate the child further")
+	fs.Parse(args)
+
+	if *parent == "" || *agent == "" || *intent == "" {
+		return errors.New("-parent, -agent and -intent are required")
+	}
+	key, err := readSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	holder, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	child := delegation.Child{AgentID: *agent, Intent: *intent, TTL: *ttl}
+	if *delegateTo != "" {
+		if child.DelegationKey, err = keys.DecodePublicKey(*delegateTo); err != nil {
+			return fmt.Errorf("failed to decode delegation key: %w", err)
+		}
+	}
+	token, err := delegation.Delegate(*parent, holder, child)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// mintAttenuable prints an attenuable token for a signed with the
+// colony root key in keyFile.
+func mintAttenuable(keyFile string, a caveat.Authority) error {
+	if a.ReefID == "" || a.ColonyID == "" {
+		return errors.New("-reef and -colony are required")
+	}
+	s, err := openSigner(context.Background(), keyFile)
+	if err != nil {
+		return err
+	}
+	token, err := caveat.Mint(context.Background(), s, a)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// runAttenuate appends a caveat to an attenuable token. It needs no key:
+// the token carries the proof that lets its holder extend it.
+func runAttenuate(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("attenuate", flag.ExitOnError)
+	token := fs.String("token", "", "attenuable token from coralctl ticket -attenuable")
+	ttl := fs.Duration("ttl", 0, "shorten the token to expire this long from now")
+	intent := fs.String("intent", "", "narrow the intent; must equal or extend the current one")
+	agent := fs.String("agent", "", "pin the token to this agent ID")
+	fs.Parse(args)
+
+	if *token == "" {
+		return errors.New("-token is required")
+	}
+	c := caveat.Caveat{Intent: *intent, AgentID: *agent}
+	if *ttl > 0 {
+		c.ExpiresAt = time.Now().Add(*ttl)
+	}
+	out, err := caveat.Attenuate(*token, c)
+	if err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
+
+// gcpKMSScheme prefixes -key-file values naming a Cloud KMS key version.
+// The access token is read from $CORAL_GCP_ACCESS_TOKEN, e.g. the output
+// of `gcloud auth print-access-token`.
+const gcpKMSScheme = "gcpkms://"
+
+// minter signs referral tickets for a fixed reef, colony and agent.
+type minter struct {
+	signer                signer.Signer
+	reef, colony, agentID string
+
+	// roles are granted to tickets from mint, for the admin API.
+	roles []string
+}
+
+func newMinter(keyFile, reef, colony, agentID string) (*minter, error) {
+	if reef == "" || colony == "" || agentID == "" {
+		return nil, errors.New("-reef, -colony and -agent are required")
+	}
+
+	s, err := openSigner(context.Background(), keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &minter{signer: s, reef: reef, colony: colony, agentID: agentID}, nil
+}
+
+// openSigner returns a Signer for a key file or a gcpkms:// key version.
+func openSigner(ctx context.Context, keyFile string) (signer.Signer, error) {
+	if version, ok := strings.CutPrefix(keyFile, gcpKMSScheme); ok {
+		return signer.NewGCPKMS(ctx, signer.GCPKMSConfig{
+			KeyVersion: version,
+			Token: func(context.Context) (string, error) {
+				if token := os.Getenv("CORAL_GCP_ACCESS_TOKEN"); token != "" {
+					return token, nil
+				}
+				return "", errors.New("$CORAL_GCP_ACCESS_TOKEN is required for " + gcpKMSScheme + " keys")
+			},
+		})
+	}
+
+	key, err := readSigningKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+	return signer.Local(key.ID, privateKey), nil
+}
+
+// readSigningKey reads a bare {id, privateKey} document or full keygen
+// output from path, unsealing sealed keys with $CORAL_KEY_PASSPHRASE.
+func readSigningKey(path string) (signingKey, error) {
+	if path == "" {
+		return signingKey{}, errors.New("-key-file is required")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	var key signingKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return signingKey{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if key.PrivateKey == "" && key.SealedPrivateKey == "" {
+		var doc struct {
+			SigningKey signingKey `json:"signing_key"`
+		}
+		if err := json.Unmarshal(data, &doc); err == nil {
+			key = doc.SigningKey
+		}
+	}
+	if key.SealedPrivateKey != "" {
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return signingKey{}, errors.New("key file is sealed; set $" + passphraseEnv)
+		}
+		privateKey, err := keyseal.Unseal(key.SealedPrivateKey, []byte(passphrase))
+		if err != nil {
+			return signingKey{}, err
+		}
+		key.PrivateKey = keys.EncodePrivateKey(privateKey)
+	}
+	if key.ID == "" || key.PrivateKey == "" {
+		return signingKey{}, errors.New("key file must contain id and privateKey")
+	}
+	return key, nil
+}
+
+func (m *minter) mint(intent string, ttl time.Duration) (string, error) {
+	claims := m.claims(intent, ttl)
+	if len(m.roles) > 0 {
+		return signer.SignToken(context.Background(), m.signer, &rbac.Claims{ReferralClaims: claims, Roles: m.roles})
+	}
+	return signer.SignToken(context.Background(), m.signer, &claims)
+}
+
+// mintDelegable signs a root ticket whose holder may delegate it with the
+// private key matching delegationKey.
+func (m *minter) mintDelegable(intent string, ttl time.Duration, delegationKey string) (string, error) {
+	if _, err := keys.DecodePublicKey(delegationKey); err != nil {
+		return "", fmt.Errorf("failed to decode delegation key: %w", err)
+	}
+	return signer.SignToken(context.Background(), m.signer, &delegation.Claims{
+		ReferralClaims: m.claims(intent, ttl),
+		DelegationKey:  delegationKey,
+	})
+}
+
+// mintBound signs a ticket bound to holderKey through its cnf claim.
+// Presenting it requires a proof of possession signed by holderKey.
+func (m *minter) mintBound(intent string, ttl time.Duration, holderKey string) (string, error) {
+	holder, err := keys.DecodePublicKey(holderKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode holder key: %w", err)
+	}
+	claims := &pop.Claims{ReferralClaims: m.claims(intent, ttl)}
+	pop.Bind(claims, holder)
+	return signer.SignToken(context.Background(), m.signer, claims)
+}
+
+// mintSPIFFE signs a ticket bound to a SPIFFE ID. Presenting it requires
+// a JWT-SVID for that ID.
+func (m *minter) mintSPIFFE(intent string, ttl time.Duration, spiffeID string) (string, error) {
+	id, err := spiffe.ParseID(spiffeID)
+	if err != nil {
+		return "", err
+	}
+	claims := &spiffe.Claims{ReferralClaims: m.claims(intent, ttl)}
+	spiffe.Bind(claims, id)
+	return signer.SignToken(context.Background(), m.signer, claims)
+}
+
+// countSet returns how many of values are non-empty.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// claims builds the referral claims of a new ticket.
+func (m *minter) claims(intent string, ttl time.Duration) jwt.ReferralClaims {
+	now := time.Now()
+	return jwt.ReferralClaims{
+		ReefID:   m.reef,
+		ColonyID: m.colony,
+		AgentID:  m.agentID,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			NotBefore: gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+)
+
+// runLock acquires a lock or semaphore of the agent's colony, prints the
+// lease once held and holds it until interrupted, then releases it.
+func runLock(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("lock", flag.ExitOnError)
+	conn.register(fs)
+	limit := fs.Int("limit", 1, "leases of the semaphore held at once")
+	ttl := fs.Duration("ttl", lock.DefaultTTL, "lease of the lock, renewed at a third of it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl lock [-limit 1] [-ttl 30s] <lock>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	h, err := c.Acquire(ctx, fs.Arg(0), *limit, *ttl)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(h.Lease()); err != nil {
+		return err
+	}
+	select {
+	case <-h.Done():
+		return h.Err()
+	case <-ctx.Done():
+	}
+	releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return h.Release(releaseCtx)
+}
+
+// runLocks prints the live leases of a lock or semaphore of the agent's
+// colony.
+func runLocks(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("locks", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl locks <lock>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	sem, err := c.Semaphore(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(sem)
+}
@@ -0,0 +1,228 @@
+// Command coralctl is an operator tool for discovery: it generates keys,
+// mints referral tickets and drives the registry API through the client
+// package.
+//
+// Usage:
+//
+//	coralctl keygen [-seal]
+//	coralctl seal -key-file key.json
+//	coralctl derive -key-file master.json (-agent A | -path P)
+//	coralctl backup -key-file key.json
+//	coralctl restore -id ID [-seal] < phrase.txt
+//	coralctl ticket -key-file key.json -reef R -colony C -agent A [-intent register] [-ttl 5m] [-role R] [-delegation-key PUB | -bind PUB | -spiffe-id ID]
+//	coralctl api-key -id ID -reef R -colony C -agent A [-intent register] [-role R] [-ttl 0]
+//	coralctl credential -key-file key.json -reef R -colony C (-agent A -did-domain H | -subject DID) [-ttl 720h]
+//	coralctl allowlist-sign -key-file key.json -reef R -colony C [-cidr 10.0.0.0/8] [-domain *.example.com] [-pin A=host:port] [-ttl 0]
+//	coralctl federate -key-file root.json -reef R -peer-reef P -peer-url URL [-peer-jwks jwks.json] [-ttl 8760h]
+//	coralctl delegate -parent T -key-file holder.json -agent B -intent onboard:join
+//	coralctl enroll -agent-key-file agent.json [-label k=v] [-wait 10m]
+//	coralctl enrollment <enrollment-id>
+//	coralctl mesh-cert -agent-key-file agent.json [-out dir]
+//	coralctl mesh-renew -agent-key-file agent.json [-dir dir]
+//	coralctl register -endpoint host:port [-capability cap] [-ttl 5m] [-country AU] [-coords -33.9,151.2] [-rtt B=12ms] [-load 0.4] [-publish T] [-subscribe T]
+//	coralctl deregister <agent-id>
+//	coralctl renew <agent-id>
+//	coralctl lookup [-foreign-reef R] <agent-id>
+//	coralctl list [-selector "gpu=true,region in (eu-west)"] [-limit 500] [-cursor C] [-nearest 3 | -least-loaded 3 | -weighted 3] <colony-id>
+//	coralctl digest [-check listing.json] <colony-id>
+//	coralctl allowlist <colony-id>
+//	coralctl allowlist-publish [-in allowlist.jwt] <colony-id>
+//	coralctl schema <colony-id>
+//	coralctl schema-gen [-in schema.json] [-package metadata] [-type Metadata] [-out metadata.go] [colony-id]
+//	coralctl topic [-topic-role publisher|subscriber] [-filter-colony C] <topic>
+//	coralctl service [-version ">=2.3,<3"] [-stable] [-canary 10] [-filter-colony C] <service>
+//	coralctl watch [-filter-colony C] [-filter-reef R] [-capability cap] [-selector S] [-topic T [-topic-role R]] [-cursor C]
+//	coralctl changes [-filter-colony C] [-capability cap] [-selector S] [-since T]
+//	coralctl revoke [-expires 24h] <ticket|jti>
+//	coralctl registry-backup [-out snapshot.bin]
+//	coralctl registry-restore [-in snapshot.bin]
+//	coralctl audit-verify [-in audit.log]
+//	coralctl admin-agents [-filter-colony C]
+//	coralctl admin-evict <agent-id>
+//	coralctl admin-expire <agent-id>
+//	coralctl admin-quotas [colony-id]
+//	coralctl admin-set-quota [-max-agents N] [-registrations-per-minute N] [-max-watches N] <colony-id>
+//	coralctl admin-reset-quota <colony-id>
+//	coralctl admin-set-schema [-in schema.json] <colony-id>
+//	coralctl admin-delete-schema <colony-id>
+//	coralctl admin-rotate-keys
+//	coralctl admin-revocations
+//	coralctl admin-enrollments [-status pending]
+//	coralctl admin-approve <enrollment-id>
+//	coralctl admin-deny [-reason R] <enrollment-id>
+//	coralctl admin-dead-letters
+//	coralctl admin-redeliver <dead-letter-id>
+//	coralctl admin-discard <dead-letter-id>
+//	coralctl history [-since T] [-until T] [-filter-colony C] [-limit 500] [-cursor C]
+//	coralctl topology -at 2026-10-14T03:00:00Z [-filter-colony C]
+//	coralctl signal -to B [-type offer] -data JSON
+//	coralctl signals [-wait 25s]
+//	coralctl campaign [-ttl 15s] <election>
+//	coralctl leader <election>
+//	coralctl lock [-limit 1] [-ttl 30s] <lock>
+//	coralctl locks <lock>
+//	coralctl punch [-to B] [-listen :0]
+//	coralctl stun [-listen :0] host:3478
+//	coralctl relay [-listen :3479] [-endpoint host:port] [-capacity 100]
+//	coralctl reserve [-to B] [-quota bytes]
+//	coralctl announce -reef R -colony C -agent A -endpoint host:port [-interface eth0]
+//	coralctl browse [-wait 3s] [-filter-colony C] [-interface eth0]
+//	coralctl dht-node [-listen :7946] [-key-file key.json] [-bootstrap host:port] [-reef R -colony C -agent A -endpoint host:port]
+//	coralctl dht-lookup -bootstrap host:port (<agent-id> | -colony C)
+//	coralctl gossip -name A -colony C [-reef R] [-listen :7947] [-seed host:port] [-secret-file f]
+//	coralctl threshold-deal [-key-file root.json | -id ID] -k 2 -operator A -operator B -operator C [-out dir]
+//	coralctl threshold-start -for-reef R -for-colony C -for-agent A [-intent register] [-ttl 24h]
+//	coralctl threshold-commit -share share-1.json <session-id>
+//	coralctl threshold-sign -share share-1.json <session-id>
+//	coralctl threshold-status <session-id>
+//
+// Registry commands take -server (or -domain, to find it in DNS), with
+// -failover servers tried when it is unreachable and -hedge to race
+// slow lookups against the first of them, and
+// either -ticket (or $CORAL_TICKET) or -key-file with -reef, -colony and
+// -agent to mint tickets on demand, plus -pop-key-file for tickets bound
+// to a holder key, and -quic to use HTTP/3 (with -ca-file to trust a
+// private CA). Operators may instead pass -id-token-file to exchange
+// an OIDC ID token for tickets, and workloads -svid-file to present a
+// JWT-SVID; callers holding an API key pass it as -api-key (or
+// $CORAL_API_KEY). Sealed key files are opened with
+// $CORAL_KEY_PASSPHRASE. Admin commands need tickets with the "admin"
+// intent and, when minted from -key-file, a -role granting the
+// operation; so do revoke, registry-backup and registry-restore, with
+// the "revoke", "backup" and "restore" intents. Agents enroll with
+// tickets carrying the "enroll" intent, then register with the issued
+// identity as -ticket and their agent key as -pop-key-file. With -cache-dir (or $CORAL_CACHE_DIR), lookup, list,
+// topic and service fall back to the results they last printed while
+// the server is unreachable.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// command is a single coralctl subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"keygen", "generate an Ed25519 signing key pair", runKeygen},
+	{"seal", "encrypt a key file under $CORAL_KEY_PASSPHRASE", runSeal},
+	{"derive", "derive an agent key from a colony master key", runDerive},
+	{"backup", "print a key's mnemonic backup phrase", runBackup},
+	{"restore", "rebuild a key file from a mnemonic on stdin", runRestore},
+	{"ticket", "mint a referral ticket", runTicket},
+	{"api-key", "generate an API key and its -api-keys entry", runAPIKey},
+	{"credential", "issue a colony membership verifiable credential", runCredential},
+	{"allowlist-sign", "sign a colony's endpoint allowlist", runAllowlistSign},
+	{"federate", "sign a trust anchor for a federated reef", runFederate},
+	{"jwks-sign", "sign a server's key set with the reef root key", runJWKSSign},
+	{"delegate", "mint a child ticket from a delegable parent", runDelegate},
+	{"attenuate", "narrow an attenuable token offline", runAttenuate},
+	{"enroll", "submit an agent's key for enrollment", runEnroll},
+	{"enrollment", "show an enrollment and the identity it issued", runEnrollment},
+	{"mesh-cert", "obtain a mesh mTLS certificate for a registered agent", runMeshCert},
+	{"mesh-renew", "renew a mesh certificate with the agent's key", runMeshRenew},
+	{"register", "register an agent", runRegister},
+	{"deregister", "remove an agent", runDeregister},
+	{"renew", "renew an agent's lease", runRenew},
+	{"lookup", "look up an agent", runLookup},
+	{"list", "list a colony's agents", runList},
+	{"digest", "check whether a colony listing is still current", runDigest},
+	{"allowlist", "show a colony's endpoint allowlist", runAllowlist},
+	{"allowlist-publish", "publish a colony's signed endpoint allowlist", runAllowlistPublish},
+	{"schema", "show a colony's metadata schema", runSchema},
+	{"schema-gen", "generate Go types for a colony's metadata", runSchemaGen},
+	{"topic", "list a topic's publishers and subscribers", runTopic},
+	{"service", "list the agents running a version of a service", runService},
+	{"watch", "tail registry changes", runWatch},
+	{"changes", "print the records and tombstones changed since a delta", runChanges},
+	{"revoke", "revoke a referral ticket", runRevoke},
+	{"registry-backup", "download a snapshot of the reef's registry", runRegistryBackup},
+	{"registry-restore", "replace the reef's registry with a snapshot", runRegistryRestore},
+	{"audit-verify", "check the hash chain of an audit log", runAuditVerify},
+	{"admin-agents", "list a reef's agents, including expired leases", runAdminAgents},
+	{"admin-evict", "remove any agent of the reef", runAdminEvict},
+	{"admin-expire", "end an agent's lease now", runAdminExpire},
+	{"admin-quotas", "show the colony quotas of the reef, or one colony's quota and use", runAdminQuotas},
+	{"admin-set-quota", "set a colony's quota", runAdminSetQuota},
+	{"admin-reset-quota", "return a colony to the default quota", runAdminResetQuota},
+	{"admin-set-schema", "set the JSON Schema of a colony's metadata", runAdminSetSchema},
+	{"admin-delete-schema", "remove a colony's metadata schema", runAdminDeleteSchema},
+	{"admin-rotate-keys", "make the server sign with a new key", runAdminRotateKeys},
+	{"admin-revocations", "list revoked tickets", runAdminRevocations},
+	{"admin-enrollments", "list a reef's enrollments", runAdminEnrollments},
+	{"admin-approve", "approve an enrollment, issuing the agent's identity", runAdminApprove},
+	{"admin-deny", "deny an enrollment", runAdminDeny},
+	{"admin-dead-letters", "list webhook events that could not be delivered", runAdminDeadLetters},
+	{"admin-redeliver", "deliver a dead-lettered webhook event again", runAdminRedeliver},
+	{"admin-discard", "drop a dead-lettered webhook event", runAdminDiscard},
+	{"history", "list archived registry changes", runHistory},
+	{"topology", "list the agents registered at a past time", runTopology},
+	{"signal", "send a WebRTC signal to a peer agent", runSignal},
+	{"signals", "receive WebRTC signals sent to the agent", runSignals},
+	{"campaign", "lead an election of the agent's colony until interrupted", runCampaign},
+	{"leader", "print the leader of an election of the agent's colony", runLeader},
+	{"lock", "hold a lock or semaphore of the agent's colony until interrupted", runLock},
+	{"locks", "print the leases of a lock or semaphore of the agent's colony", runLocks},
+	{"punch", "punch a UDP hole to a peer agent through NATs", runPunch},
+	{"stun", "print the private and server-reflexive endpoints of a UDP socket", runSTUN},
+	{"relay", "serve as a relay agent for peers that cannot punch through", runRelay},
+	{"reserve", "reserve a relay to a peer agent", runReserve},
+	{"announce", "announce an agent on the LAN over mDNS", runAnnounce},
+	{"browse", "find agents on the LAN over mDNS", runBrowse},
+	{"dht-node", "run a peer-to-peer discovery node", runDHTNode},
+	{"dht-lookup", "find agents through the peer-to-peer network", runDHTLookup},
+	{"gossip", "join a colony's failure detection gossip", runGossip},
+	{"threshold-deal", "split a root key into k-of-n operator shares", runThresholdDeal},
+	{"threshold-start", "open a threshold signing session for a root ticket", runThresholdStart},
+	{"threshold-commit", "commit an operator to a signing session", runThresholdCommit},
+	{"threshold-sign", "send an operator's signature share", runThresholdSign},
+	{"threshold-status", "show a signing session", runThresholdStatus},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(ctx, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "coralctl %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "coralctl: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: coralctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-17s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
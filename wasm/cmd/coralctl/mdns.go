@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/mdns"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// mdnsFlags select the interface and record TTL for LAN discovery.
+type mdnsFlags struct {
+	iface string
+	ttl   time.Duration
+}
+
+func (m *mdnsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&m.iface, "interface", "", "network interface (system default when empty)")
+	fs.DurationVar(&m.ttl, "ttl", mdns.DefaultTTL, "mDNS record TTL")
+}
+
+func (m *mdnsFlags) config() (mdns.Config, error) {
+	cfg := mdns.Config{TTL: m.ttl}
+	if m.iface != "" {
+		iface, err := net.InterfaceByName(m.iface)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Interface = iface
+	}
+	return cfg, nil
+}
+
+// runAnnounce announces an agent on the LAN until interrupted.
+func runAnnounce(ctx context.Context, args []string) error {
+	var m mdnsFlags
+	var endpoints, capabilities stringList
+	rec := &registry.Record{}
+	fs := flag.NewFlagSet("announce", flag.ExitOnError)
+	m.register(fs)
+	fs.StringVar(&rec.ReefID, "reef", "", "reef ID")
+	fs.StringVar(&rec.ColonyID, "colony", "", "colony ID")
+	fs.StringVar(&rec.AgentID, "agent", "", "agent ID")
+	fs.Var(&endpoints, "endpoint", "agent endpoint (repeatable; the first is the SRV target)")
+	fs.Var(&capabilities, "capability", "agent capability (repeatable)")
+	fs.Parse(args)
+	rec.Endpoints, rec.Capabilities = endpoints, capabilities
+
+	cfg, err := m.config()
+	if err != nil {
+		return err
+	}
+	r, err := mdns.NewResponder(cfg)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := r.Announce(rec); err != nil {
+		return err
+	}
+	return r.Serve(ctx)
+}
+
+// runBrowse prints agents found on the LAN. With -wait it prints the
+// agents found within that time as JSON; otherwise it tails changes like
+// watch.
+func runBrowse(ctx context.Context, args []string) error {
+	var m mdnsFlags
+	var filter registry.Filter
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	m.register(fs)
+	fs.StringVar(&filter.ColonyID, "filter-colony", "", "only show this colony")
+	fs.StringVar(&filter.ReefID, "filter-reef", "", "only show this reef")
+	fs.StringVar(&filter.Capability, "capability", "", "only show agents with this capability")
+	fs.Func("selector", "only show agents whose labels match this selector", selectorFlag(&filter.Selector))
+	wait := fs.Duration("wait", 0, "collect agents for this long, print them and exit")
+	fs.Parse(args)
+
+	cfg, err := m.config()
+	if err != nil {
+		return err
+	}
+	if *wait > 0 {
+		recs, err := mdns.Lookup(ctx, cfg, *wait)
+		if err != nil {
+			return err
+		}
+		matched := []*registry.Record{}
+		for _, rec := range recs {
+			if filter.Matches(rec) {
+				matched = append(matched, rec)
+			}
+		}
+		return printJSON(matched)
+	}
+
+	events, err := mdns.Browse(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if filter.Matches(ev.Record) {
+			fmt.Printf("%s\t%s\t%s\t%s\n", ev.Type, ev.Record.ColonyID, ev.Record.AgentID, strings.Join(ev.Record.Endpoints, ","))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/client"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+)
+
+// runMeshCert requests a mesh certificate for the agent's key and writes
+// the certificate, its chain and the CA roots as PEM files into -out.
+func runMeshCert(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("mesh-cert", flag.ExitOnError)
+	conn.register(fs)
+	agentKey := fs.String("agent-key-file", "", "the agent's key, certified for mTLS")
+	out := fs.String("out", ".", "directory to write cert.pem, chain.pem and roots.pem into")
+	fs.Parse(args)
+
+	key, err := readSigningKey(*agentKey)
+	if err != nil {
+		return err
+	}
+	priv, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode agent key: %w", err)
+	}
+	csr, err := meshca.NewCSR(priv)
+	if err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	cert, err := c.IssueCertificate(ctx, csr)
+	if err != nil {
+		return err
+	}
+	return writeMeshCert(ctx, c, *out, cert)
+}
+
+// runMeshRenew renews the mesh certificate in -dir without a ticket,
+// proving possession of the agent's key, and replaces the PEM files.
+func runMeshRenew(ctx context.Context, args []string) error {
+	conn := connFlags{anonymous: true}
+	fs := flag.NewFlagSet("mesh-renew", flag.ExitOnError)
+	conn.register(fs)
+	agentKey := fs.String("agent-key-file", "", "the agent's key, as certified")
+	dir := fs.String("dir", ".", "directory holding cert.pem, where the renewed files are written")
+	fs.Parse(args)
+
+	key, err := readSigningKey(*agentKey)
+	if err != nil {
+		return err
+	}
+	priv, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode agent key: %w", err)
+	}
+	current, err := os.ReadFile(filepath.Join(*dir, "cert.pem"))
+	if err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	cert, err := c.RenewCertificate(ctx, priv, &meshca.Certificate{Certificate: string(current)})
+	if err != nil {
+		return err
+	}
+	return writeMeshCert(ctx, c, *dir, cert)
+}
+
+// writeMeshCert writes cert, its chain and the CA roots as PEM files
+// into dir.
+func writeMeshCert(ctx context.Context, c *client.Client, dir string, cert *meshca.Certificate) error {
+	roots, err := c.MeshRoots(ctx)
+	if err != nil {
+		return err
+	}
+	for name, data := range map[string]string{"cert.pem": cert.Certificate, "chain.pem": cert.Chain, "roots.pem": roots} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			return err
+		}
+	}
+	return printJSON(map[string]interface{}{"spiffe_id": cert.SPIFFEID, "expires_at": cert.ExpiresAt})
+}
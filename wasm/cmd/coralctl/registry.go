@@ -0,0 +1,916 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/client"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/relay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rendezvous"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+)
+
+// connFlags are the connection and identity flags shared by registry
+// commands.
+type connFlags struct {
+	server   string
+	failover stringList
+	hedge    time.Duration
+	domain   string
+	quic     bool
+	caFile   string
+	ticket   string
+	apiKey   string
+	keyFile  string
+	pop      string
+	svid     string
+	idToken  string
+	reef     string
+	colony   string
+	agent    string
+	roles    stringList
+	meshDir  string
+	meshKey  string
+	cache    string
+
+	// anonymous lets commands calling only public routes run without
+	// a ticket.
+	anonymous bool
+}
+
+func (c *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.server, "server", os.Getenv("CORAL_DISCOVERY_URL"), "discovery server URL (default "+defaultServer+" without -domain)")
+	fs.Var(&c.failover, "failover", "further server URL to fail over to when -server is unreachable (repeatable)")
+	fs.DurationVar(&c.hedge, "hedge", 0, "also send lookups to the first -failover server when -server has not answered within this delay")
+	fs.StringVar(&c.domain, "domain", os.Getenv("CORAL_DOMAIN"), "find the server in this domain's _coral-discovery._tcp DNS records when -server is unset")
+	fs.BoolVar(&c.quic, "quic", false, "talk to the server over HTTP/3 (QUIC); -server must be its https URL")
+	fs.StringVar(&c.caFile, "ca-file", "", "PEM CA certificates trusted for the server instead of the system roots")
+	fs.StringVar(&c.ticket, "ticket", os.Getenv("CORAL_TICKET"), "referral ticket")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("CORAL_API_KEY"), "API key from coralctl api-key, sent instead of tickets")
+	fs.StringVar(&c.keyFile, "key-file", "", "signing key (or gcpkms://<key version>) used to mint tickets instead of -ticket")
+	fs.StringVar(&c.pop, "pop-key-file", "", "holder key signing proofs of possession for bound tickets")
+	fs.StringVar(&c.svid, "svid-file", "", "JWT-SVID file, re-read for every request; the ticket when no other is given, else sent with SPIFFE-bound tickets")
+	fs.StringVar(&c.idToken, "id-token-file", "", "OIDC ID token file exchanged for tickets at the server's POST /v1/token")
+	fs.StringVar(&c.reef, "reef", "", "reef ID")
+	fs.StringVar(&c.colony, "colony", "", "colony ID")
+	fs.StringVar(&c.agent, "agent", "", "agent ID")
+	fs.Var(&c.roles, "role", "role granted to tickets minted with -key-file, for admin commands (repeatable)")
+	fs.StringVar(&c.meshDir, "mesh-cert-dir", "", "directory holding the agent's mesh cert.pem, presented over mTLS; authenticates without a ticket")
+	fs.StringVar(&c.meshKey, "mesh-key-file", "", "the agent key certified in -mesh-cert-dir")
+	fs.StringVar(&c.cache, "cache-dir", os.Getenv("CORAL_CACHE_DIR"), "directory caching lookups and listings, printed from it with a warning while the server is unreachable")
+}
+
+// defaultServer is used without -server and -domain.
+const defaultServer = "http://localhost:8080"
+
+// client builds a discovery client from the flags.
+func (c *connFlags) client() (*client.Client, error) {
+	switch {
+	case c.server != "":
+	case c.domain != "":
+		base, _, err := client.Discover(context.Background(), nil, http.DefaultClient, c.domain)
+		if err != nil {
+			return nil, err
+		}
+		c.server = base
+	default:
+		c.server = defaultServer
+	}
+
+	var tickets client.TicketSource
+	switch {
+	case c.keyFile != "":
+		m, err := newMinter(c.keyFile, c.reef, c.colony, c.agent)
+		if err != nil {
+			return nil, err
+		}
+		m.roles = c.roles
+		tickets = client.TicketFunc(func(_ context.Context, intent string) (string, error) {
+			return m.mint(intent, 5*time.Minute)
+		})
+	case c.ticket != "":
+		tickets = client.StaticTicket(c.ticket)
+	case c.idToken != "":
+		tickets = client.ExchangeTickets(client.ExchangeConfig{
+			BaseURL:  c.server,
+			IDToken:  func(context.Context) (string, error) { return readTrimmed(c.idToken) },
+			ReefID:   c.reef,
+			ColonyID: c.colony,
+		})
+	case c.svid != "":
+		tickets = client.TicketFunc(func(ctx context.Context, _ string) (string, error) {
+			return c.readSVID(ctx)
+		})
+	case c.meshDir != "", c.apiKey != "":
+		// The mesh certificate or API key authenticates the caller.
+	case c.anonymous:
+		tickets = client.TicketFunc(func(context.Context, string) (string, error) {
+			return "", errors.New("no ticket configured")
+		})
+	default:
+		return nil, errors.New("-ticket, $CORAL_TICKET, -key-file, -id-token-file, -svid-file, -mesh-cert-dir or -api-key is required")
+	}
+	cfg := client.Config{BaseURL: c.server, Endpoints: c.failover, HedgeDelay: c.hedge, Tickets: tickets, APIKey: c.apiKey}
+	if c.cache != "" {
+		cfg.Cache = client.DirCache(c.cache)
+	}
+	if c.svid != "" && (c.keyFile != "" || c.ticket != "" || c.idToken != "") {
+		cfg.SVID = c.readSVID
+	}
+	if c.caFile != "" || c.quic {
+		tlsConf, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		if c.quic {
+			cfg.QUIC = &client.QUICConfig{TLS: tlsConf}
+		} else {
+			cfg.HTTPClient = &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: tlsConf},
+			}
+		}
+	}
+	if c.meshDir != "" {
+		pair, err := c.meshCertificate()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCert = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return pair, nil }
+	}
+	if c.pop != "" {
+		key, err := readSigningKey(c.pop)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ProofKey, err = keys.DecodePrivateKey(key.PrivateKey); err != nil {
+			return nil, fmt.Errorf("failed to decode holder key: %w", err)
+		}
+	}
+	return client.New(cfg)
+}
+
+// tlsConfig trusts -ca-file when set and the system roots otherwise.
+func (c *connFlags) tlsConfig() (*tls.Config, error) {
+	if c.caFile == "" {
+		return &tls.Config{}, nil
+	}
+	pem, err := os.ReadFile(c.caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", c.caFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// meshCertificate loads the mesh certificate of -mesh-cert-dir with the
+// key of -mesh-key-file.
+func (c *connFlags) meshCertificate() (*tls.Certificate, error) {
+	key, err := readSigningKey(c.meshKey)
+	if err != nil {
+		return nil, fmt.Errorf("-mesh-key-file: %w", err)
+	}
+	priv, err := keys.DecodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode agent key: %w", err)
+	}
+	cert, err := os.ReadFile(filepath.Join(c.meshDir, "cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return meshca.TLSCertificate(&meshca.Certificate{Certificate: string(cert)}, priv)
+}
+
+// readSVID reads the JWT-SVID file, which the SPIFFE agent rotates in
+// place.
+func (c *connFlags) readSVID(context.Context) (string, error) {
+	return readTrimmed(c.svid)
+}
+
+// readTrimmed reads a token file.
+func readTrimmed(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func runRegister(ctx context.Context, args []string) error {
+	var conn connFlags
+	var endpoints, capabilities, publishes, subscribes stringList
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	conn.register(fs)
+	fs.Var(&endpoints, "endpoint", "agent endpoint (repeatable)")
+	fs.Var(&capabilities, "capability", "agent capability (repeatable)")
+	fs.Var(&publishes, "publish", "pub/sub topic the agent produces (repeatable)")
+	fs.Var(&subscribes, "subscribe", "pub/sub topic the agent consumes (repeatable)")
+	ttl := fs.Duration("ttl", 0, "lease TTL (server default when zero)")
+	utilization := fs.Float64("load", -1, "share of the agent's capacity in use, from 0 to 1")
+	seq := fs.Uint64("seq", 0, "register only over the record at this seq, as printed by lookup")
+	metadata := fs.String("metadata", "", "JSON object describing the agent, matching its colony's schema")
+	var loc registry.Location
+	fs.StringVar(&loc.Country, "country", "", "ISO country code of the agent")
+	fs.Func("coords", "coarse `lat,lon` of the agent", func(v string) error {
+		lat, lon, ok := strings.Cut(v, ",")
+		var err1, err2 error
+		loc.Latitude, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		loc.Longitude, err2 = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if !ok || err1 != nil || err2 != nil {
+			return errors.New("want lat,lon")
+		}
+		return nil
+	})
+	rtt := map[string]int{}
+	fs.Func("rtt", "measured round trip time to a peer, as `agent=duration` (repeatable)", func(v string) error {
+		id, d, ok := strings.Cut(v, "=")
+		ms, err := time.ParseDuration(d)
+		if !ok || id == "" || err != nil {
+			return errors.New("want agent=duration")
+		}
+		rtt[id] = int(ms.Milliseconds())
+		return nil
+	})
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	rec := &registry.Record{
+		AgentID:      conn.agent,
+		ColonyID:     conn.colony,
+		ReefID:       conn.reef,
+		Endpoints:    endpoints,
+		Capabilities: capabilities,
+		TTLSeconds:   int(ttl.Seconds()),
+		Publishes:    publishes,
+		Subscribes:   subscribes,
+		Seq:          *seq,
+	}
+	if *metadata != "" {
+		rec.Metadata = json.RawMessage(*metadata)
+	}
+	if loc != (registry.Location{}) {
+		rec.Location = &loc
+	}
+	if len(rtt) > 0 {
+		rec.RTT = rtt
+	}
+	if *utilization >= 0 {
+		rec.Load = &registry.Load{Utilization: *utilization}
+	}
+	rec, err = c.Register(ctx, rec)
+	if err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+func runDeregister(ctx context.Context, args []string) error {
+	c, id, err := agentCommand("deregister", args)
+	if err != nil {
+		return err
+	}
+	return c.Deregister(ctx, id)
+}
+
+func runRenew(ctx context.Context, args []string) error {
+	c, id, err := agentCommand("renew", args)
+	if err != nil {
+		return err
+	}
+	rec, err := c.Renew(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+func runLookup(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	conn.register(fs)
+	foreignReef := fs.String("foreign-reef", "", "look the agent up in this federated reef")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl lookup [flags] <agent-id>")
+	}
+
+	id := fs.Arg(0)
+	if conn.agent == "" && *foreignReef == "" {
+		conn.agent = id
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	var rec *registry.Record
+	if *foreignReef != "" {
+		rec, err = c.LookupReef(ctx, *foreignReef, id)
+	} else {
+		rec, err = c.Lookup(ctx, id)
+	}
+	if err := warnStale(err); err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+func runList(ctx context.Context, args []string) error {
+	var conn connFlags
+	var sel *registry.Selector
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	conn.register(fs)
+	fs.Func("selector", "only list agents whose labels match this selector, e.g. gpu=true,region in (eu-west)", selectorFlag(&sel))
+	nearest := fs.Int("nearest", 0, "only list this many agents nearest the ticket's agent")
+	leastLoaded := fs.Int("least-loaded", 0, "only list this many of the least loaded agents")
+	weighted := fs.Int("weighted", 0, "list this many agents drawn at random, weighted by health")
+	limit := fs.Int("limit", 0, "print a single page of this many agents with its next_cursor")
+	cursor := fs.String("cursor", "", "continue a paged listing from this next_cursor")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl list [flags] <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	var opts []client.QueryOption
+	if *nearest > 0 {
+		opts = append(opts, client.Nearest(*nearest))
+	}
+	if *leastLoaded > 0 {
+		opts = append(opts, client.LeastLoaded(*leastLoaded))
+	}
+	if *weighted > 0 {
+		opts = append(opts, client.Weighted(*weighted))
+	}
+	if *limit > 0 || *cursor != "" {
+		page, err := c.QueryPage(ctx, fs.Arg(0), sel, *cursor, *limit, opts...)
+		if err != nil {
+			return err
+		}
+		return printJSON(page)
+	}
+	recs, err := c.Query(ctx, fs.Arg(0), sel, opts...)
+	if err := warnStale(err); err != nil {
+		return err
+	}
+	return printJSON(recs)
+}
+
+func runDigest(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	conn.register(fs)
+	check := fs.String("check", "", "listing printed by coralctl list to check against the digest instead of printing it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl digest [-check listing.json] <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	d, err := c.Digest(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *check == "" {
+		return printJSON(d)
+	}
+	data, err := os.ReadFile(*check)
+	if err != nil {
+		return err
+	}
+	var have []*registry.Record
+	if err := json.Unmarshal(data, &have); err != nil {
+		return fmt.Errorf("%s: %w", *check, err)
+	}
+	changed := d.Changed(have)
+	if changed == nil {
+		changed = []string{}
+	}
+	return printJSON(map[string]interface{}{"current": d.Matches(have), "changed": changed})
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	var conn connFlags
+	var filter registry.Filter
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&filter.ColonyID, "filter-colony", "", "only show this colony")
+	fs.StringVar(&filter.ReefID, "filter-reef", "", "only show this reef")
+	fs.StringVar(&filter.Capability, "capability", "", "only show agents with this capability")
+	fs.Func("selector", "only show agents whose labels match this selector", selectorFlag(&filter.Selector))
+	fs.StringVar(&filter.Topic, "topic", "", "only show agents publishing or subscribing to this topic")
+	fs.Func("topic-role", "with -topic, only show agents in this `role`: publisher or subscriber", topicRoleFlag(&filter.TopicRole))
+	cursor := fs.String("cursor", "", "resume after the change with this cursor, the last column of an earlier watch")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	events, err := c.WatchFrom(ctx, filter, *cursor)
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if ev.Record == nil {
+			fmt.Println(ev.Type)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", ev.Type, ev.Record.ColonyID, ev.Record.AgentID, strings.Join(ev.Record.Endpoints, ","), ev.Cursor)
+	}
+	return nil
+}
+
+// runChanges prints the delta of the records since -since, the
+// through.wall of an earlier delta, or every record without it.
+func runChanges(ctx context.Context, args []string) error {
+	var conn connFlags
+	var filter registry.Filter
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&filter.ColonyID, "filter-colony", "", "only show this colony")
+	fs.StringVar(&filter.Capability, "capability", "", "only show agents with this capability")
+	fs.Func("selector", "only show agents whose labels match this selector", selectorFlag(&filter.Selector))
+	var since registry.Timestamp
+	fs.Func("since", "show the changes since this `timestamp`, the through.wall of an earlier delta", func(v string) error {
+		var err error
+		since, err = registry.ParseTimestamp(v)
+		return err
+	})
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	d, err := c.Delta(ctx, filter, since)
+	if err != nil {
+		return err
+	}
+	return printJSON(d)
+}
+
+// runTopic lists the agents of the ticket's reef that publish or
+// subscribe to a topic.
+func runTopic(ctx context.Context, args []string) error {
+	var conn connFlags
+	var role registry.TopicRole
+	fs := flag.NewFlagSet("topic", flag.ExitOnError)
+	conn.register(fs)
+	fs.Func("topic-role", "only list agents in this `role`: publisher or subscriber", topicRoleFlag(&role))
+	colony := fs.String("filter-colony", "", "only list agents of this colony")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl topic [flags] <topic>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	recs, err := c.Topic(ctx, fs.Arg(0), role, *colony)
+	if err := warnStale(err); err != nil {
+		return err
+	}
+	return printJSON(recs)
+}
+
+// runService lists the agents of the ticket's reef running a service at
+// a compatible version.
+func runService(ctx context.Context, args []string) error {
+	var conn connFlags
+	var q registry.ServiceQuery
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&q.Version, "version", "", "version `constraint`, e.g. \">=2.3,<3\"")
+	fs.BoolVar(&q.Stable, "stable", false, "exclude pre-release versions")
+	fs.IntVar(&q.Canary, "canary", 0, "`percent` of callers steered to the newest matching version")
+	fs.StringVar(&q.ColonyID, "filter-colony", "", "only list agents of this colony")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl service [flags] <service>")
+	}
+	q.Service = fs.Arg(0)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	recs, err := c.Service(ctx, q)
+	if err := warnStale(err); err != nil {
+		return err
+	}
+	return printJSON(recs)
+}
+
+// warnStale prints a warning for results served from the -cache-dir
+// cache, returning other errors.
+func warnStale(err error) error {
+	if stale, ok := client.Stale(err); ok {
+		fmt.Fprintf(os.Stderr, "coralctl: warning: server unreachable, showing results cached at %s: %v\n", stale.CachedAt.Format(time.RFC3339), stale.Err)
+		return nil
+	}
+	return err
+}
+
+// topicRoleFlag parses a topic role flag into role.
+func topicRoleFlag(role *registry.TopicRole) func(string) error {
+	return func(v string) (err error) {
+		*role, err = registry.ParseTopicRole(v)
+		return err
+	}
+}
+
+// selectorFlag parses a -selector flag into sel.
+func selectorFlag(sel **registry.Selector) func(string) error {
+	return func(v string) (err error) {
+		*sel, err = registry.ParseSelector(v)
+		return err
+	}
+}
+
+// agentCommand parses connection flags and a single agent ID argument.
+// The agent ID doubles as -agent when minting tickets.
+func agentCommand(name string, args []string) (*client.Client, string, error) {
+	var conn connFlags
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return nil, "", fmt.Errorf("usage: coralctl %s [flags] <agent-id>", name)
+	}
+
+	id := fs.Arg(0)
+	if conn.agent == "" {
+		conn.agent = id
+	}
+	c, err := conn.client()
+	return c, id, err
+}
+
+// runSignal sends a WebRTC signal to a peer of the -agent.
+func runSignal(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("signal", flag.ExitOnError)
+	conn.register(fs)
+	to := fs.String("to", "", "recipient agent ID")
+	typ := fs.String("type", rendezvous.TypeOffer, "signal type: offer, answer, candidate or bye")
+	data := fs.String("data", "", "session description or ICE candidate JSON (- reads stdin)")
+	fs.Parse(args)
+
+	payload := []byte(*data)
+	if *data == "-" {
+		var err error
+		if payload, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	sig, err := c.SendSignal(ctx, *to, *typ, payload)
+	if err != nil {
+		return err
+	}
+	fmt.Println(sig.ID)
+	return nil
+}
+
+// runSignals prints the signals waiting for the -agent, one JSON object
+// per line.
+func runSignals(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("signals", flag.ExitOnError)
+	conn.register(fs)
+	wait := fs.Duration("wait", 0, "long poll an empty mailbox this long (at most 25s)")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	sigs, err := c.Signals(ctx, *wait)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, sig := range sigs {
+		if err := enc.Encode(sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPunch punches a UDP hole to a peer agent, as the initiator with -to
+// or else by accepting the first attempt addressed to the -agent, and
+// prints the peer address that answered.
+func runPunch(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("punch", flag.ExitOnError)
+	conn.register(fs)
+	to := fs.String("to", "", "peer agent ID to punch to (waits for an attempt when empty)")
+	listen := fs.String("listen", ":0", "UDP address to punch from")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	candidates, err := traversal.LocalCandidates(pc)
+	if err != nil {
+		return err
+	}
+
+	var attempt *traversal.Attempt
+	if *to != "" {
+		if attempt, err = c.InitiateTraversal(ctx, *to, candidates); err != nil {
+			return err
+		}
+		for attempt.Status != traversal.StatusReady {
+			if attempt, err = c.AwaitTraversal(ctx, attempt.ID, 25*time.Second); err != nil {
+				return err
+			}
+		}
+	} else {
+		var pending []*traversal.Attempt
+		for len(pending) == 0 {
+			if pending, err = c.PendingTraversals(ctx, 25*time.Second); err != nil {
+				return err
+			}
+		}
+		if attempt, err = c.AcceptTraversal(ctx, pending[0].ID, candidates); err != nil {
+			return err
+		}
+	}
+
+	self := attempt.Target.AgentID
+	if *to != "" {
+		self = attempt.Initiator.AgentID
+	}
+	peer, err := traversal.Punch(ctx, pc, attempt, self)
+	if err != nil {
+		return err
+	}
+	fmt.Println(peer)
+	return nil
+}
+
+// runSTUN prints the endpoints of a UDP socket on -listen, private and
+// server-reflexive, one per line, for use with register -endpoint.
+func runSTUN(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stun", flag.ExitOnError)
+	listen := fs.String("listen", ":0", "UDP address to learn the endpoints of")
+	timeout := fs.Duration("timeout", 10*time.Second, "give up after this long")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl stun [flags] <host:port>")
+	}
+
+	pc, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	endpoints, err := client.ReflexiveEndpoints(ctx, pc, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, ep := range endpoints {
+		fmt.Println(ep)
+	}
+	return nil
+}
+
+// runRelay serves as a relay agent: it registers the -agent with the
+// relay capability, keeps the lease alive and forwards datagrams for the
+// reservations it is presented tickets of.
+func runRelay(ctx context.Context, args []string) error {
+	var conn connFlags
+	var endpoints stringList
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	conn.register(fs)
+	listen := fs.String("listen", ":3479", "UDP address to relay on")
+	fs.Var(&endpoints, "endpoint", "advertised relay address (repeatable; default the listen socket's addresses)")
+	rate := fs.Int64("rate", 0, "bytes per second forwarded per reservation (0 only applies ticket rates)")
+	capacity := fs.Int("capacity", 0, "reservations the relay is sized for, to report utilization against")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	if len(endpoints) == 0 {
+		if endpoints, err = traversal.LocalCandidates(pc); err != nil {
+			return err
+		}
+	}
+	keys, err := c.ServerKeys(ctx)
+	if err != nil {
+		return err
+	}
+	srv, err := relay.NewServer(relay.ServerConfig{AgentID: conn.agent, Keys: keys, Rate: *rate, Capacity: *capacity})
+	if err != nil {
+		return err
+	}
+	hb, err := c.StartHeartbeat(ctx, &registry.Record{
+		AgentID:      conn.agent,
+		ColonyID:     conn.colony,
+		ReefID:       conn.reef,
+		Endpoints:    endpoints,
+		Capabilities: []string{relay.Capability},
+	}, 0)
+	if err != nil {
+		return err
+	}
+	defer hb.Stop()
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hb.ReportLoad(srv.Load())
+			}
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "relaying on %s\n", pc.LocalAddr())
+	return srv.Serve(ctx, pc)
+}
+
+// runReserve reserves a relay to -to and prints the reservation, or
+// without -to waits for reservations peers made with the -agent.
+func runReserve(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("reserve", flag.ExitOnError)
+	conn.register(fs)
+	to := fs.String("to", "", "peer agent ID (waits for reservations when empty)")
+	quota := fs.Int64("quota", 0, "bytes to reserve (server default when zero)")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	if *to != "" {
+		res, err := c.ReserveRelay(ctx, *to, *quota)
+		if err != nil {
+			return err
+		}
+		return printJSON(res)
+	}
+	for {
+		found, err := c.RelayReservations(ctx, 25*time.Second)
+		if err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			return printJSON(found)
+		}
+	}
+}
+
+// runRevoke revokes a ticket given either the ticket itself or its jti.
+func runRevoke(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	conn.register(fs)
+	expires := fs.Duration("expires", 24*time.Hour, "how long to keep a bare jti revoked")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl revoke [flags] <ticket|jti>")
+	}
+
+	jti, expiresAt := fs.Arg(0), time.Now().Add(*expires)
+	if claims, err := unverifiedClaims(fs.Arg(0)); err == nil {
+		jti = claims.ID
+		if claims.ExpiresAt != nil {
+			expiresAt = claims.ExpiresAt.Time
+		}
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	return c.Revoke(ctx, jti, expiresAt)
+}
+
+func runRegistryBackup(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("registry-backup", flag.ExitOnError)
+	conn.register(fs)
+	out := fs.String("out", "", "file to write the snapshot to (default stdout)")
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return c.Snapshot(ctx, os.Stdout)
+	}
+	// Write the snapshot beside the target and rename it into place, so
+	// a failed backup never replaces a good one.
+	f, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := c.Snapshot(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), *out)
+}
+
+func runRegistryRestore(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("registry-restore", flag.ExitOnError)
+	conn.register(fs)
+	in := fs.String("in", "", "snapshot file to restore (default stdin)")
+	fs.Parse(args)
+
+	snapshot := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		snapshot = f
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	n, err := c.Restore(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]int{"restored": n})
+}
+
+// unverifiedClaims decodes a ticket's registered claims without checking
+// its signature.
+func unverifiedClaims(token string) (*gojwt.RegisteredClaims, error) {
+	var claims gojwt.RegisteredClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+)
+
+// runSchema prints the metadata schema of a colony.
+func runSchema(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl schema <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	cs, err := c.ColonySchema(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(cs)
+}
+
+// runSchemaGen writes Go types for the metadata of a colony's agents,
+// generated from the schema in -in or else the one the colony has
+// registered, for use with client.Metadata.
+func runSchemaGen(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("schema-gen", flag.ExitOnError)
+	conn.register(fs)
+	in := fs.String("in", "", "file holding the schema, instead of fetching the colony's")
+	pkg := fs.String("package", "metadata", "package of the generated code")
+	typ := fs.String("type", "Metadata", "name of the generated metadata type")
+	out := fs.String("out", "", "file to write the generated code to (default stdout)")
+	fs.Parse(args)
+
+	var data []byte
+	switch {
+	case *in != "" && fs.NArg() == 0:
+		var err error
+		if data, err = os.ReadFile(*in); err != nil {
+			return err
+		}
+	case *in == "" && fs.NArg() == 1:
+		c, err := conn.client()
+		if err != nil {
+			return err
+		}
+		cs, err := c.ColonySchema(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		data = cs.Schema
+	default:
+		return errors.New("usage: coralctl schema-gen [-in schema.json] [-package P] [-type T] [-out file.go] [colony-id]")
+	}
+	s, err := schema.Compile(data)
+	if err != nil {
+		return err
+	}
+	src, err := s.Generate(*pkg, *typ)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*out, src, 0o644)
+}
+
+// runAdminSetSchema makes the JSON Schema read from -in or stdin the
+// metadata schema of a colony.
+func runAdminSetSchema(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-set-schema", flag.ExitOnError)
+	conn.register(fs)
+	in := fs.String("in", "", "file holding the schema (default stdin)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl admin-set-schema [-in schema.json] <colony-id>")
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(io.LimitReader(r, schema.MaxSize+1))
+	if err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	cs, err := c.SetColonySchema(ctx, fs.Arg(0), data)
+	if err != nil {
+		return err
+	}
+	return printJSON(cs)
+}
+
+// runAdminDeleteSchema removes the metadata schema of a colony.
+func runAdminDeleteSchema(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-delete-schema", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl admin-delete-schema <colony-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	return c.DeleteColonySchema(ctx, fs.Arg(0))
+}
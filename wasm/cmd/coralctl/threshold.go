@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/client"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// runThresholdDeal splits a root key, or a fresh random one, into share
+// files, one per -operator, and prints the public keys corald needs.
+func runThresholdDeal(_ context.Context, args []string) error {
+	var operators stringList
+	fs := flag.NewFlagSet("threshold-deal", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "existing root key to split (a random key is dealt when empty)")
+	id := fs.String("id", "", "key ID of a random key")
+	k := fs.Int("k", 2, "operators required to sign")
+	fs.Var(&operators, "operator", "agent ID of an operator's threshold tickets (repeatable; the i-th receives share-<i>.json)")
+	out := fs.String("out", ".", "directory receiving share-<i>.json files")
+	fs.Parse(args)
+
+	var privateKey ed25519.PrivateKey
+	kid := *id
+	if *keyFile != "" {
+		key, err := readSigningKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		if privateKey, err = keys.DecodePrivateKey(key.PrivateKey); err != nil {
+			return fmt.Errorf("failed to decode private key: %w", err)
+		}
+		kid = key.ID
+	}
+	if kid == "" {
+		return errors.New("-key-file or -id is required")
+	}
+
+	shares, pub, err := threshold.Deal(kid, privateKey, *k, len(operators))
+	if err != nil {
+		return err
+	}
+	pub.Operators = make(map[uint16]string, len(shares))
+	for i, share := range shares {
+		pub.Operators[share.Identifier] = operators[i]
+		data, err := json.MarshalIndent(share, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(*out, "share-"+strconv.Itoa(int(share.Identifier))+".json")
+		if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+			return err
+		}
+	}
+
+	groupKey, err := pub.PublicKey()
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"public_keys": pub,
+		"jwks":        keys.KeyPairsToJWKS([]*keys.KeyPair{{ID: kid, Algorithm: "EdDSA", PublicKey: groupKey}}),
+	})
+}
+
+// runThresholdStart opens a signing session for a root ticket.
+func runThresholdStart(ctx context.Context, args []string) error {
+	var conn connFlags
+	var req threshold.Request
+	fs := flag.NewFlagSet("threshold-start", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&req.ReefID, "for-reef", "", "reef ID of the root ticket")
+	fs.StringVar(&req.ColonyID, "for-colony", "", "colony ID of the root ticket")
+	fs.StringVar(&req.AgentID, "for-agent", "", "agent ID of the root ticket")
+	fs.StringVar(&req.Intent, "intent", "register", "intent of the root ticket")
+	fs.StringVar(&req.DelegationKey, "delegation-key", "", "base64 public key allowed to delegate the root ticket")
+	ttl := fs.Duration("ttl", 24*time.Hour, "root ticket lifetime")
+	fs.Parse(args)
+	req.TTLSeconds = int(ttl.Seconds())
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	session, err := c.StartThreshold(ctx, &req)
+	if err != nil {
+		return err
+	}
+	return printJSON(session)
+}
+
+// runThresholdStatus prints a signing session.
+func runThresholdStatus(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("threshold-status", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: coralctl threshold-status [flags] <session-id>")
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	session, err := c.ThresholdSession(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(session)
+}
+
+// runThresholdCommit publishes a commitment for a session, keeping the
+// secret nonces next to the share file until threshold-sign uses them.
+func runThresholdCommit(ctx context.Context, args []string) error {
+	c, share, shareFile, id, err := thresholdCommand("threshold-commit", args)
+	if err != nil {
+		return err
+	}
+
+	nonces, com, err := threshold.Commit(share)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(nonces)
+	if err != nil {
+		return err
+	}
+	// O_EXCL refuses to overwrite nonces that may already be committed.
+	path := noncePath(shareFile, id)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	session, err := c.CommitThreshold(ctx, id, com)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return printJSON(session)
+}
+
+// runThresholdSign signs a session whose commitments are complete. The
+// nonces are deleted before the share is sent so they can never be used
+// twice.
+func runThresholdSign(ctx context.Context, args []string) error {
+	c, share, shareFile, id, err := thresholdCommand("threshold-sign", args)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.ThresholdSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.Status != threshold.StatusSigning {
+		return fmt.Errorf("session %s is %s, not %s", id, session.Status, threshold.StatusSigning)
+	}
+	path := noncePath(shareFile, id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("no commitment from this share: %w", err)
+	}
+	var nonces threshold.Nonces
+	if err := json.Unmarshal(data, &nonces); err != nil {
+		return fmt.Errorf("failed to parse nonces: %w", err)
+	}
+
+	sigShare, err := threshold.Sign(share, &nonces, []byte(session.Message), session.Commitments)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	session, err = c.SubmitThresholdShare(ctx, id, share.Identifier, sigShare)
+	if err != nil {
+		return err
+	}
+	return printJSON(session)
+}
+
+// thresholdCommand parses connection flags, -share and a session ID.
+func thresholdCommand(name string, args []string) (*client.Client, *threshold.KeyShare, string, string, error) {
+	var conn connFlags
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conn.register(fs)
+	shareFile := fs.String("share", "", "operator share file from threshold-deal")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *shareFile == "" {
+		return nil, nil, "", "", fmt.Errorf("usage: coralctl %s -share share.json [flags] <session-id>", name)
+	}
+
+	data, err := os.ReadFile(*shareFile)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	var share threshold.KeyShare
+	if err := json.Unmarshal(data, &share); err != nil {
+		return nil, nil, "", "", fmt.Errorf("failed to parse share file: %w", err)
+	}
+	c, err := conn.client()
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	return c, &share, *shareFile, fs.Arg(0), nil
+}
+
+// noncePath is where a share's nonces for a session are kept.
+func noncePath(shareFile, sessionID string) string {
+	return shareFile + "." + sessionID + ".nonces"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/client"
+)
+
+func runAdminDeadLetters(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := flag.NewFlagSet("admin-dead-letters", flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	letters, err := c.AdminDeadLetters(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(letters)
+}
+
+func runAdminRedeliver(ctx context.Context, args []string) error {
+	c, id, err := deadLetterCommand("admin-redeliver", args)
+	if err != nil {
+		return err
+	}
+	return c.RedeliverDeadLetter(ctx, id)
+}
+
+func runAdminDiscard(ctx context.Context, args []string) error {
+	c, id, err := deadLetterCommand("admin-discard", args)
+	if err != nil {
+		return err
+	}
+	return c.DiscardDeadLetter(ctx, id)
+}
+
+// deadLetterCommand parses the flags of a command taking a dead letter
+// ID and builds the client.
+func deadLetterCommand(name string, args []string) (*client.Client, string, error) {
+	var conn connFlags
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conn.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return nil, "", fmt.Errorf("usage: coralctl %s [flags] <dead-letter-id>", name)
+	}
+	c, err := conn.client()
+	return c, fs.Arg(0), err
+}
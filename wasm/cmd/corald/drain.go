@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replication"
+)
+
+// handoffTimeout bounds pushing leases to peers and writing the handoff
+// file at shutdown.
+const handoffTimeout = 30 * time.Second
+
+// drainOptions configure how corald shuts down.
+type drainOptions struct {
+	period time.Duration
+	file   string
+}
+
+// resume restores the leases a previous run handed off to -handoff-file,
+// then removes the file so that they are not restored twice.
+func (o drainOptions) resume(ctx context.Context, reg *registry.Registry) error {
+	if o.file == "" {
+		return nil
+	}
+	f, err := os.Open(o.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := reg.Resume(ctx, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("corald: resumed %d leases from %s", n, o.file)
+	return os.Remove(o.file)
+}
+
+// drain refuses new registrations and ends watch streams, then keeps
+// serving renewals and lookups for -drain-period, so that agents and
+// load balancers move to other instances before the listeners close.
+func (o drainOptions) drain(reg *registry.Registry) {
+	reg.Drain()
+	if o.period > 0 {
+		log.Printf("corald: draining for %s", o.period)
+		time.Sleep(o.period)
+	}
+}
+
+// handoff passes the leases held here on once the listeners closed: to
+// the peers of replicator, which is nil without -replica-id, and to
+// -handoff-file for the next run.
+func (o drainOptions) handoff(reg *registry.Registry, replicator *replication.Replicator) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	defer cancel()
+	var errs []error
+	if replicator != nil {
+		if err := replicator.Handoff(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.file != "" {
+		if err := writeHandoff(ctx, reg, o.file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeHandoff writes a snapshot of reg to path, replacing it only once
+// complete.
+func writeHandoff(ctx context.Context, reg *registry.Registry, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := reg.Snapshot(ctx, "", f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
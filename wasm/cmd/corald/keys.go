@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// keyTick is how often the rotating key set checks its schedule.
+const keyTick = time.Minute
+
+// keyOptions configures the rotating signing key set.
+type keyOptions struct {
+	path        string
+	rotateEvery time.Duration
+	overlap     time.Duration
+	reef        string
+}
+
+// openKeys returns the verification key source, the handler for
+// jwks.Path and, with -signing-keys, the set signing new tickets.
+// With -signing-keys corald owns a rotating key set and publishes it;
+// otherwise it verifies against, and republishes, the static -jwks file.
+func openKeys(ctx context.Context, opts options) (verify.KeySource, http.Handler, *jwks.Set, error) {
+	if opts.keys.path != "" {
+		set, err := jwks.New(jwks.Config{
+			Path:           opts.keys.path,
+			RotationPeriod: opts.keys.rotateEvery,
+			Overlap:        opts.keys.overlap,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		go set.Run(ctx, keyTick)
+		return set, set, set, nil
+	}
+
+	if opts.jwksPath == "" {
+		return nil, nil, nil, errors.New("-jwks or -signing-keys is required")
+	}
+	keys := &staticKeys{Keys: &verify.Keys{}}
+	if err := keys.load(opts.jwksPath); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys, keys, nil, nil
+}
+
+// staticKeys is the -jwks key set, republished as read. Reloads replace
+// it in place.
+type staticKeys struct {
+	*verify.Keys
+	doc atomic.Pointer[[]byte]
+}
+
+// load reads the JWKS document at path into k.
+func (k *staticKeys) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keys, err := verify.ParseKeys(data)
+	if err != nil {
+		return err
+	}
+	k.Keys.Replace(keys)
+	k.doc.Store(&data)
+	return nil
+}
+
+func (k *staticKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(*k.doc.Load())
+}
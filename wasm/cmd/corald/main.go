@@ -0,0 +1,1393 @@
+// Command corald runs the discovery registry as a standalone HTTP and gRPC
+// server.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/caveat"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/config"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/crdt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/did"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/enrollment"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/eventbus"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	registryv1 "github.com/coral-mesh/coral-discovery-workers/wasm/gen/coral/registry/v1"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/gossip"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/hdkey"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/readcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/relay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rendezvous"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replication"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/shard"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/stun"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tenant"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/webhook"
+)
+
+// options holds the command-line configuration.
+type options struct {
+	config    string
+	addr      string
+	grpcAddr  string
+	tls       tlsOptions
+	jwksPath  string
+	tenants   string
+	quota     registry.ColonyQuota
+	allowlist bool
+	signed    bool
+	bundle    string
+	ttl       time.Duration
+	grace     time.Duration
+	reapEvery time.Duration
+	watchKeep int
+	tombKeep  time.Duration
+	offset    time.Duration
+	store     storeOptions
+	keys      keyOptions
+	maxDepth  int
+	policy    string
+	roles     string
+	apiKeys   string
+	limits    string
+	oneTime   string
+	pop       popOptions
+	threshold string
+	enroll    enrollOptions
+	meshCA    meshCAOptions
+	webhooks  webhookOptions
+	eventbus  eventbusOptions
+	history   historyOptions
+	reads     readCacheOptions
+	signaling bool
+	electing  bool
+	locking   bool
+	punching  bool
+	proxied   bool
+	metrics   bool
+	trace     traceOptions
+	audit     auditOptions
+	stunAddr  string
+	relay     relayOptions
+	federate  federationOptions
+	replica   replicaOptions
+	shard     shardOptions
+	didDomain string
+	didReef   string
+	spiffe    spiffeOptions
+	oidc      oidcOptions
+	gossip    gossipOptions
+	verify    verifyOptions
+	drain     drainOptions
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.config, "config", "", "YAML or JSON settings file keyed by flag name, overridden by CORALD_* environment variables and flags; reloaded on SIGHUP")
+	flag.StringVar(&opts.addr, "addr", ":8080", "HTTP listen address")
+	flag.StringVar(&opts.grpcAddr, "grpc-addr", "", "gRPC listen address (disabled when empty)")
+	flag.StringVar(&opts.tls.certFile, "tls-cert", "", "TLS certificate file; serves HTTPS on -addr when set with -tls-key")
+	flag.StringVar(&opts.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&opts.tls.mtls, "mtls", false, "verify mesh certificates clients present over TLS, authenticating agents by them (needs -tls-cert and -mesh-ca-keys or -mtls-roots)")
+	flag.StringVar(&opts.tls.peerRoots, "mtls-roots", "", "PEM mesh CA roots client certificates are verified against (default this server's mesh CA)")
+	flag.StringVar(&opts.tls.quicAddr, "quic-addr", "", "UDP address serving HTTP/3 over QUIC with 0-RTT renewals (needs -tls-cert; disabled when empty)")
+	flag.StringVar(&opts.jwksPath, "jwks", "", "path to the JWKS document used to verify referral tickets")
+	flag.StringVar(&opts.tenants, "tenants", "", "JSON document listing the reefs served, with their own JWKS and agent quotas; tickets of other reefs are rejected")
+	flag.IntVar(&opts.quota.MaxAgents, "colony-max-agents", 0, "most agents a colony may register unless its quota says otherwise (0 for no limit)")
+	flag.IntVar(&opts.quota.RegistrationsPerMinute, "colony-registrations-per-minute", 0, "most registrations per minute of a colony on this instance unless its quota says otherwise (0 for no limit)")
+	flag.IntVar(&opts.quota.MaxWatches, "colony-max-watches", 0, "most watch streams a colony may hold open on this instance unless its quota says otherwise (0 for no limit)")
+	flag.BoolVar(&opts.allowlist, "require-allowlist", false, "refuse registrations in colonies without a published endpoint allowlist")
+	flag.BoolVar(&opts.signed, "require-signed-records", false, "refuse records their agents have not signed")
+	flag.StringVar(&opts.bundle, "jwks-bundle", "", "root-signed key set bundle from coralctl jwks-sign, served at "+jwks.BundlePath)
+	flag.DurationVar(&opts.ttl, "ttl", registry.DefaultTTL, "default registration TTL")
+	flag.DurationVar(&opts.grace, "grace", 30*time.Second, "grace period before expired leases are evicted")
+	flag.DurationVar(&opts.reapEvery, "reap-interval", time.Minute, "how often expired leases are evicted")
+	flag.IntVar(&opts.watchKeep, "watch-retention", registry.DefaultWatchRetention, "recent changes kept for reconnecting watchers to resume from")
+	flag.DurationVar(&opts.tombKeep, "tombstone-retention", registry.DefaultTombstoneTTL, "how long tombstones of removed agents are kept for replicas, watchers and delta syncs to catch up with")
+	flag.StringVar(&opts.store.backend, "store", "memory", "storage backend: memory, bolt, redis or raft")
+	flag.StringVar(&opts.store.boltPath, "bolt-path", "corald.db", "BoltDB file for -store=bolt")
+	flag.StringVar(&opts.store.redisAddr, "redis-addr", "localhost:6379", "Redis address for -store=redis")
+	flag.StringVar(&opts.store.redisPass, "redis-password", "", "Redis password for -store=redis")
+	flag.IntVar(&opts.store.redisDB, "redis-db", 0, "Redis database for -store=redis")
+	flag.StringVar(&opts.store.raft.id, "raft-id", "", "name of this member of the Raft group for -store=raft")
+	flag.StringVar(&opts.store.raft.addr, "raft-addr", ":7000", "listen address for the RPCs of the other Raft members")
+	flag.StringVar(&opts.store.raft.peers, "raft-peers", "", "comma-separated ID=URL of the other Raft members, each URL reaching its -raft-addr")
+	flag.StringVar(&opts.store.raft.dir, "raft-dir", "corald-raft", "directory of the Raft log and snapshot")
+	flag.StringVar(&opts.store.raft.secretFile, "raft-secret-file", "", "file holding the secret shared by the Raft members")
+	flag.IntVar(&opts.maxDepth, "max-delegation-depth", delegation.DefaultMaxDepth, "delegation hops allowed below a root ticket (-1 disables)")
+	flag.StringVar(&opts.verify.issuers, "issuer", "", "comma-separated accepted ticket issuers (default coral-discovery)")
+	flag.StringVar(&opts.verify.audiences, "audience", "", "comma-separated accepted ticket audiences (default coral-colony)")
+	flag.StringVar(&opts.verify.algs, "ticket-algs", "", "comma-separated accepted ticket signature algorithms: EdDSA, ES256, RS256 (default EdDSA)")
+	flag.DurationVar(&opts.verify.leeway, "clock-skew", 0, "tolerated clock skew when checking ticket times")
+	flag.DurationVar(&opts.verify.nbfLeeway, "nbf-skew", 0, "tolerated skew on ticket nbf and iat alone, for issuers whose clocks run ahead (longer than -clock-skew to take effect)")
+	flag.DurationVar(&opts.offset, "clock-offset", 0, "correction added to the host clock when issuing and checking tickets and expiring leases")
+	flag.DurationVar(&opts.verify.maxTTL, "max-ticket-ttl", 0, "reject tickets living longer than this (0 disables)")
+	flag.StringVar(&opts.oneTime, "one-time-intents", "", "comma-separated intents whose tickets are accepted only once (* for all)")
+	flag.BoolVar(&opts.pop.required, "require-pop", false, "reject tickets not bound to a holder key with a cnf claim")
+	flag.StringVar(&opts.pop.secretFile, "pop-secret-file", "", "file holding the proof-of-possession nonce secret shared by instances (random when empty)")
+	flag.BoolVar(&opts.enroll.enabled, "enrollment", false, "queue new agents for approval at /v1/enrollments; registration then needs an enrolled key (needs -signing-keys)")
+	flag.StringVar(&opts.enroll.auto, "enrollment-auto-approve", "", "comma-separated colonies whose enrollments are approved on submission (* for all)")
+	flag.DurationVar(&opts.enroll.ttl, "enrollment-ttl", enrollment.DefaultIdentityTTL, "lifetime of identity tickets issued to enrolled agents (shorter than -key-overlap)")
+	flag.StringVar(&opts.meshCA.keys, "mesh-ca-keys", "", "mesh CA key file; enables POST /v1/mesh/certificates issuing mTLS certificates to registered agents")
+	flag.StringVar(&opts.meshCA.trustDomain, "mesh-trust-domain", "coral.local", "SPIFFE trust domain of mesh certificates")
+	flag.DurationVar(&opts.meshCA.ttl, "mesh-cert-ttl", meshca.DefaultTTL, "lifetime of mesh certificates")
+	flag.DurationVar(&opts.meshCA.rotateEvery, "mesh-ca-rotate-every", keys.DefaultRotationPeriod, "mesh CA key rotation period, checked at startup")
+	flag.StringVar(&opts.threshold, "threshold-keys", "", "threshold key public shares from coralctl threshold-deal; enables /v1/threshold signing sessions")
+	flag.BoolVar(&opts.signaling, "rendezvous", false, "relay WebRTC signaling between agents of a colony at /v1/rendezvous/signals")
+	flag.BoolVar(&opts.electing, "elections", false, "elect leaders among the agents of a colony at /v1/elections")
+	flag.BoolVar(&opts.locking, "locks", false, "lease locks and semaphores to the agents of a colony at /v1/locks")
+	flag.BoolVar(&opts.punching, "traversal", false, "coordinate NAT hole punching between agents of a colony at /v1/traversal/attempts")
+	flag.StringVar(&opts.stunAddr, "stun-addr", "", "UDP address answering STUN Binding requests, e.g. :3478 (disabled when empty)")
+	flag.BoolVar(&opts.relay.enabled, "relays", false, "broker reservations of relay agents at /v1/relay/reservations (needs -signing-keys)")
+	flag.Int64Var(&opts.relay.quota, "relay-quota", relay.DefaultQuota, "bytes a relay reservation may forward")
+	flag.Int64Var(&opts.relay.rate, "relay-rate", 0, "bytes per second a relay reservation may forward (0 leaves it to the relay)")
+	flag.StringVar(&opts.federate.reef, "federation-reef", "", "this server's reef; enables federation with the reefs of -federation-anchors")
+	flag.StringVar(&opts.federate.anchors, "federation-anchors", "", "comma-separated files holding trust anchors from coralctl federate")
+	flag.StringVar(&opts.federate.roots, "federation-root", "", "JWKS of the reef's root keys verifying anchors (default -jwks)")
+	flag.DurationVar(&opts.federate.refresh, "federation-refresh", time.Hour, "how often federated reefs' key sets are refreshed")
+	flag.StringVar(&opts.replica.id, "replica-id", "", "name of this instance in an active-active deployment; enables replication with -replica-peers")
+	flag.StringVar(&opts.replica.peers, "replica-peers", "", "comma-separated base URLs of the other replicas")
+	flag.StringVar(&opts.replica.secretFile, "replica-secret-file", "", "file holding the secret shared by the replicas")
+	flag.DurationVar(&opts.replica.interval, "replica-interval", replication.DefaultInterval, "how often each replica's changes are pulled")
+	flag.DurationVar(&opts.replica.maxLag, "replica-max-lag", 30*time.Second, "how long a replica may go without pulling a peer's changes before /readyz and gRPC health report it unavailable; 0 disables the check")
+	flag.StringVar(&opts.shard.id, "shard-id", "", "name of this instance in -shard-map; enables sharding the registry by colony")
+	flag.StringVar(&opts.shard.mapPath, "shard-map", "", "JSON shard map listing the shards' IDs and URLs under a version; reloaded on SIGHUP")
+	flag.StringVar(&opts.shard.secretFile, "shard-secret-file", "", "file holding the secret shared by the shards")
+	flag.DurationVar(&opts.shard.interval, "shard-exchange-interval", shard.DefaultInterval, "how often the shard map is exchanged with the other shards")
+	flag.BoolVar(&opts.replica.edges, "crdt", false, "merge the deltas of edge replicas at /v1/crdt/sync (needs -replica-id)")
+	flag.StringVar(&opts.replica.origin, "crdt-origin", "", "base URL of the origin this edge replica syncs its deltas with (needs -replica-id)")
+	flag.BoolVar(&opts.metrics, "metrics", true, "serve Prometheus metrics at /metrics")
+	flag.StringVar(&opts.trace.endpoint, "otlp-endpoint", "", "OTLP/HTTP traces URL of an OpenTelemetry collector, e.g. http://localhost:4318/v1/traces; enables tracing")
+	flag.StringVar(&opts.trace.headers, "otlp-headers", "", "comma-separated name=value headers sent with every span export")
+	flag.StringVar(&opts.trace.service, "otlp-service", "corald", "service.name reported with exported spans")
+	flag.Float64Var(&opts.trace.ratio, "trace-ratio", 1, "fraction of traces started by this server that are sampled")
+	flag.StringVar(&opts.audit.file, "audit-file", "", "file the hash-chained audit log is appended to")
+	flag.StringVar(&opts.audit.webhook, "audit-webhook", "", "URL audit records are POSTed to in batches")
+	flag.StringVar(&opts.audit.secretFile, "audit-webhook-secret-file", "", "file holding the secret signing audit webhook bodies")
+	flag.BoolVar(&opts.proxied, "trust-proxy", false, "take client addresses from the last X-Forwarded-For hop and locations from Cloudflare geolocation headers (only behind a proxy setting them)")
+	flag.StringVar(&opts.didDomain, "did-domain", "", "public host (with port) of this server; enables agent did:web documents")
+	flag.StringVar(&opts.didReef, "did-reef", "", "reef whose agents -did-domain serves documents for (default -federation-reef)")
+	flag.StringVar(&opts.spiffe.bundles, "spiffe-bundle", "", "comma-separated trust-domain=bundle-file pairs; enables JWT-SVID registration and SPIFFE-bound tickets")
+	flag.StringVar(&opts.spiffe.audiences, "spiffe-audience", "", "comma-separated accepted JWT-SVID audiences (default coral-discovery)")
+	flag.StringVar(&opts.spiffe.template, "spiffe-template", spiffe.DefaultTemplate, "SPIFFE ID path mapped to {reef}, {colony} and {agent}")
+	flag.StringVar(&opts.oidc.issuer, "oidc-issuer", "", "OIDC provider issuer URL; enables POST /v1/token exchanging ID tokens for tickets (needs -signing-keys)")
+	flag.StringVar(&opts.oidc.clientID, "oidc-client-id", "", "audience of accepted ID tokens")
+	flag.StringVar(&opts.oidc.grants, "oidc-grants", "", "grants document mapping ID token groups to reefs, colonies and intents")
+	flag.StringVar(&opts.oidc.groupsClaim, "oidc-groups-claim", oidc.DefaultGroupsClaim, "ID token claim listing the user's groups")
+	flag.StringVar(&opts.oidc.subjectClaim, "oidc-subject-claim", oidc.DefaultSubjectClaim, "ID token claim used as the ticket's agent ID")
+	flag.DurationVar(&opts.oidc.ttl, "oidc-ticket-ttl", oidc.DefaultTTL, "lifetime of tickets issued by token exchange")
+	flag.StringVar(&opts.gossip.addr, "gossip-addr", "", "UDP address for colony gossip; reports SWIM failure detection to watchers (disabled when empty)")
+	flag.StringVar(&opts.gossip.colony, "gossip-colony", "", "colony whose gossip to join")
+	flag.StringVar(&opts.gossip.reef, "gossip-reef", "", "reef of -gossip-colony")
+	flag.StringVar(&opts.gossip.seeds, "gossip-seeds", "", "comma-separated host:port addresses of colony members to join through")
+	flag.StringVar(&opts.gossip.secretFile, "gossip-secret-file", "", "file holding the colony's gossip authentication secret")
+	flag.StringVar(&opts.policy, "policy", "", "intent policy document; when set, tickets must be allowed by it")
+	flag.StringVar(&opts.limits, "rate-limits", "", "rate limit document with register, lookup and verify budgets and a penalty box (no limits when empty)")
+	flag.StringVar(&opts.webhooks.endpoints, "webhooks", "", "webhook endpoint document; delivers signed agent and revocation events to each endpoint")
+	flag.StringVar(&opts.webhooks.keyFile, "webhook-signing-key-file", "", "file holding the base64 Ed25519 private key additionally signing webhook deliveries")
+	flag.IntVar(&opts.webhooks.attempts, "webhook-attempts", webhook.DefaultMaxAttempts, "deliveries of a webhook event before it is dead-lettered")
+	flag.DurationVar(&opts.webhooks.retention, "webhook-dead-letter-ttl", webhook.DefaultDeadLetterTTL, "how long undeliverable webhook events are kept for redelivery")
+	flag.StringVar(&opts.eventbus.url, "eventbus", "", "streaming platform registry changes are exported to: nats://host:4222, tls://host:4222, kafka://host:9092,host:9092 or kafka+tls://...")
+	flag.StringVar(&opts.eventbus.topic, "eventbus-topic", eventbus.DefaultTopic, "NATS subject prefix or Kafka topic of exported registry changes")
+	flag.BoolVar(&opts.eventbus.jetStream, "eventbus-jetstream", false, "wait for a NATS JetStream stream to acknowledge each exported change")
+	flag.BoolVar(&opts.history.enabled, "history", false, "archive registry changes for the /v1/history routes")
+	flag.DurationVar(&opts.history.retention, "history-retention", history.DefaultRetention, "how long individual registry changes are kept before being compacted into a checkpoint")
+	flag.DurationVar(&opts.reads.ttl, "lookup-cache-ttl", 0, "how long agent lookups and colony listings are cached for bounded-consistency reads (0 caches nothing)")
+	flag.StringVar(&opts.reads.consistency, "lookup-consistency", "", "consistency of lookups not asking for one with the Coral-Consistency header: strong, shared (coalesce concurrent identical reads) or bounded (default with -lookup-cache-ttl)")
+	flag.StringVar(&opts.apiKeys, "api-keys", "", "API key document from coralctl api-key; authenticates callers presenting a key in the Coral-API-Key header")
+	flag.StringVar(&opts.roles, "rbac-policy", "", "role policy document granting admin API permissions (built-in admin, operator and auditor roles when empty)")
+	flag.StringVar(&opts.keys.path, "signing-keys", "", "rotating signing key set file; replaces -jwks when set")
+	flag.DurationVar(&opts.keys.rotateEvery, "rotate-every", jwks.DefaultRotationPeriod, "signing key rotation period")
+	flag.DurationVar(&opts.keys.overlap, "key-overlap", jwks.DefaultOverlap, "how long keys are published before and after signing")
+	flag.StringVar(&opts.keys.reef, "signing-keys-reef", "", "reef whose admins may rotate -signing-keys, which sign the tickets of every reef; without it no one may")
+	flag.DurationVar(&opts.drain.period, "drain-period", 0, "on shutdown, how long to keep serving renewals and lookups while refusing registrations, before closing the listeners")
+	flag.StringVar(&opts.drain.file, "handoff-file", "", "file the leases are written to on shutdown and resumed from on startup, so that restarts do not expire them")
+	flag.Parse()
+
+	loader, err := config.Load(config.Config{
+		Flags:     flag.CommandLine,
+		File:      opts.config,
+		EnvPrefix: "CORALD_",
+		Skip:      []string{"config"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(&opts, loader); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves with a snapshot of flags; reloads read flags as loader
+// updates them.
+func run(flags *options, loader *config.Loader) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := *flags
+
+	keySource, jwksHandler, keySet, err := openKeys(ctx, opts)
+	if err != nil {
+		return err
+	}
+	wall := clock.Offset(clock.System, opts.offset)
+	// With -tenants each reef's tickets, and its colonies' endpoint
+	// allowlists, are verified with its own keys. Attenuable tokens and
+	// federation stay with the deployment's keys.
+	tickets := keySource
+	var tenants *tenant.Tenants
+	if opts.tenants != "" {
+		if tenants, err = tenant.Load(opts.tenants, keySource); err != nil {
+			return err
+		}
+		tickets = tenants
+	}
+	var validator registry.Verifier = verify.New(tickets, opts.verify.options(wall))
+
+	var set *metrics.Set
+	if opts.metrics {
+		set = metrics.NewSet()
+	}
+	tracer, err := opts.trace.tracer()
+	if err != nil {
+		return err
+	}
+	if tracer != nil {
+		go tracer.Run(ctx)
+		// Export the spans of requests drained at shutdown.
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = tracer.Flush(flushCtx)
+		}()
+	}
+	// Outbound fetches, such as of peers' and providers' key sets,
+	// continue the trace of the request causing them.
+	hc := tracer.Client(&http.Client{Timeout: 10 * time.Second})
+
+	st, err := openStore(ctx, opts.store)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	if set != nil {
+		st = set.Store(st)
+	}
+	if tracer != nil {
+		st = tracer.Store(st)
+	}
+
+	// Delegated tickets are accepted wherever a ticket is verified, and
+	// revoking any ticket in a chain rejects the whole chain.
+	revocations := revocation.New(revocation.Config{Store: st})
+	validator, err = delegation.New(delegation.Config{
+		Root:      validator,
+		MaxDepth:  opts.maxDepth,
+		IsRevoked: revocations.IsRevoked,
+	})
+	if err != nil {
+		return err
+	}
+	// Attenuable tokens are signed by the same keys; holders narrow them
+	// offline, e.g. during enrollment.
+	attenuable, err := caveat.New(caveat.Config{
+		Root:      keySource,
+		Clock:     wall,
+		Leeway:    opts.verify.leeway,
+		IsRevoked: revocations.IsRevoked,
+	})
+	if err != nil {
+		return err
+	}
+	validator = attenuable.Wrap(validator)
+	// SPIFFE attests workloads itself, so SVIDs skip the enrollment gate.
+	var enroller *enrollment.Enroller
+	if opts.enroll.enabled {
+		if enroller, err = opts.enroll.enroller(keySet, st, wall, opts.keys.overlap); err != nil {
+			return err
+		}
+		validator = enroller.Verifier(validator)
+		go enroller.RunPruner(ctx, opts.reapEvery)
+	}
+	// SVID registrations are subject to the policy and replay guard below.
+	adapter, err := opts.spiffe.adapter()
+	if err != nil {
+		return err
+	}
+	if adapter != nil {
+		validator = adapter.Verifier(validator)
+	}
+	var intents *policy.Policy
+	if opts.policy != "" {
+		data, err := os.ReadFile(opts.policy)
+		if err != nil {
+			return err
+		}
+		if intents, err = policy.Parse(data); err != nil {
+			return err
+		}
+		validator = intents.Verifier(validator)
+	}
+	// The replay guard goes last so that rejected tickets are not consumed.
+	if opts.oneTime != "" {
+		var intents []string
+		if opts.oneTime != "*" {
+			intents = splitList(opts.oneTime)
+		}
+		guard := replay.New(replay.Config{Store: st, Intents: intents, Clock: wall})
+		validator = guard.Verifier(validator)
+		go guard.RunPruner(ctx, opts.reapEvery)
+	}
+	if set != nil {
+		validator = set.Verifier(validator)
+	}
+	auditLog, closeAudit, err := opts.audit.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+	validator = auditLog.Verifier(validator)
+	roles, err := openRoles(opts.roles)
+	if err != nil {
+		return err
+	}
+	limiter, err := openLimiter(opts.limits)
+	if err != nil {
+		return err
+	}
+	if limiter != nil {
+		go limiter.RunPruner(ctx, opts.reapEvery)
+	}
+
+	var quotas registry.Quotas
+	if tenants != nil {
+		quotas = tenants
+	}
+	reg, err := registry.New(registry.Config{
+		Store:                st,
+		Verifier:             validator,
+		DefaultTTL:           opts.ttl,
+		GracePeriod:          opts.grace,
+		ReplicaID:            opts.replica.id,
+		WatchRetention:       opts.watchKeep,
+		TombstoneTTL:         opts.tombKeep,
+		Metrics:              set,
+		Tracer:               tracer,
+		Audit:                auditLog,
+		RBAC:                 roles,
+		Limiter:              limiter,
+		Quotas:               quotas,
+		DefaultColonyQuota:   opts.quota,
+		AllowlistKeys:        tickets,
+		RequireAllowlist:     opts.allowlist,
+		RequireSignedRecords: opts.signed,
+		Clock:                wall,
+	})
+	if err != nil {
+		return err
+	}
+	// Records stored before reefs were kept apart move to their reef.
+	if moved, err := reg.Migrate(ctx); err != nil {
+		return err
+	} else if moved > 0 {
+		log.Printf("corald: moved %d records to the keys of their reef", moved)
+	}
+	if err := opts.drain.resume(ctx, reg); err != nil {
+		return err
+	}
+	checker, err := opts.pop.checker()
+	if err != nil {
+		return err
+	}
+	meshCA, err := opts.meshCA.ca(reg, st, wall)
+	if err != nil {
+		return err
+	}
+	if meshCA != nil {
+		go meshCA.RunPruner(ctx, opts.reapEvery)
+	}
+	peerRoots, err := opts.tls.roots(meshCA)
+	if err != nil {
+		return err
+	}
+	apiKeys, err := openAPIKeys(opts.apiKeys, wall)
+	if err != nil {
+		return err
+	}
+	webhooks, err := opts.webhooks.dispatcher(reg, revocations, st, wall)
+	if err != nil {
+		return err
+	}
+	if webhooks != nil {
+		go webhooks.Run(ctx)
+		go webhooks.RunPruner(ctx, opts.reapEvery)
+	}
+	if opts.eventbus.url != "" {
+		exporter, err := opts.eventbus.exporter(reg, wall)
+		if err != nil {
+			return err
+		}
+		go exporter.Run(ctx)
+	}
+	reads, err := opts.reads.cache(wall)
+	if err != nil {
+		return err
+	}
+	archive, err := opts.history.archive(reg, st, wall)
+	if err != nil {
+		return err
+	}
+	if archive != nil {
+		go archive.Run(ctx)
+		go archive.RunPruner(ctx, opts.reapEvery)
+	}
+	cfg := server.Config{Registry: reg, Verifier: validator, APIKeys: apiKeys, Policy: intents, Revocations: revocations, PoP: checker, Enrollment: enroller, MeshCA: meshCA, PeerRoots: peerRoots, SPIFFE: adapter, TrustProxy: opts.proxied, Metrics: set, Tracer: tracer, Audit: auditLog, RBAC: roles, Keys: keySet, KeysReef: opts.keys.reef, Limiter: limiter, Webhooks: webhooks, History: archive, ReadCache: reads}
+	if opts.didDomain != "" {
+		if cfg.DID, err = openDID(opts, hc); err != nil {
+			return err
+		}
+	}
+	if opts.oidc.issuer != "" {
+		if cfg.Exchange, err = opts.oidc.exchanger(keySet, hc, wall); err != nil {
+			return err
+		}
+	}
+	if opts.threshold != "" {
+		if cfg.Threshold, err = openThreshold(opts.threshold, st); err != nil {
+			return err
+		}
+		go cfg.Threshold.RunPruner(ctx, opts.reapEvery)
+	}
+
+	if opts.signaling {
+		if cfg.Rendezvous, err = rendezvous.New(rendezvous.Config{Registry: reg, Store: st}); err != nil {
+			return err
+		}
+		go cfg.Rendezvous.RunPruner(ctx, opts.reapEvery)
+	}
+	if opts.electing {
+		cfg.Elections = election.New(election.Config{Store: st})
+	}
+	if opts.locking {
+		cfg.Locks = lock.New(lock.Config{Store: st})
+	}
+	if opts.punching {
+		if cfg.Traversal, err = traversal.New(traversal.Config{Registry: reg, Store: st}); err != nil {
+			return err
+		}
+		go cfg.Traversal.RunPruner(ctx, opts.reapEvery)
+	}
+	if opts.relay.enabled {
+		if cfg.Relay, err = opts.relay.broker(reg, st, keySet); err != nil {
+			return err
+		}
+		go cfg.Relay.RunPruner(ctx, opts.reapEvery)
+	}
+	if opts.federate.reef != "" {
+		if cfg.Federation, err = opts.federate.federation(keySource, keySet, hc); err != nil {
+			return err
+		}
+		go cfg.Federation.RunRefresher(ctx, opts.federate.refresh)
+	}
+	if opts.replica.id != "" {
+		if cfg.Replication, err = opts.replica.replicator(reg, hc); err != nil {
+			return err
+		}
+		go cfg.Replication.Run(ctx)
+		cfg.MaxReplicationLag = opts.replica.maxLag
+	}
+	if opts.replica.edges || opts.replica.origin != "" {
+		edge, err := opts.replica.crdt(reg, st)
+		if err != nil {
+			return err
+		}
+		if opts.replica.edges {
+			cfg.CRDT = edge
+		}
+		if opts.replica.origin != "" {
+			go edge.Run(ctx)
+		}
+	}
+
+	if cfg.Shards, err = opts.shard.router(hc); err != nil {
+		return err
+	}
+	if cfg.Shards != nil {
+		go cfg.Shards.Run(ctx)
+	}
+
+	go reg.RunReaper(ctx, opts.reapEvery)
+	if opts.gossip.addr != "" {
+		node, err := opts.gossip.join(ctx, reg)
+		if err != nil {
+			return err
+		}
+		defer node.Close()
+		defer node.Leave(context.Background())
+		go node.Run(ctx)
+	}
+	if opts.stunAddr != "" {
+		pc, err := net.ListenPacket("udp", opts.stunAddr)
+		if err != nil {
+			return err
+		}
+		go stun.Serve(ctx, pc)
+		log.Printf("corald STUN listening on %s", pc.LocalAddr())
+	}
+
+	tlsConf, err := opts.tls.config()
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 3)
+
+	mux := http.NewServeMux()
+	mux.Handle(jwks.Path, jwksHandler)
+	if opts.bundle != "" {
+		bundle, err := os.ReadFile(opts.bundle)
+		if err != nil {
+			return err
+		}
+		expires, err := jwks.BundleExpiry(bundle)
+		if err != nil {
+			return err
+		}
+		cfg.Checks = append(cfg.Checks, server.Check{Name: "jwks_bundle", Check: func(context.Context) error {
+			if !wall.Now().Before(expires) {
+				return fmt.Errorf("%s expired at %s", opts.bundle, expires.UTC().Format(time.RFC3339))
+			}
+			return nil
+		}})
+		mux.HandleFunc("GET "+jwks.BundlePath, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(bundle)
+		})
+	}
+	if set != nil {
+		mux.Handle("GET /metrics", set)
+	}
+	health := server.NewHealth(cfg)
+	mux.Handle("GET /healthz", health)
+	mux.Handle("GET /readyz", health)
+	mux.Handle("/", server.New(cfg))
+
+	httpSrv := &http.Server{
+		Addr:              opts.addr,
+		Handler:           mux,
+		TLSConfig:         tlsConf,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if tlsConf != nil {
+			log.Printf("corald HTTPS listening on %s", opts.addr)
+			errCh <- httpSrv.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("corald HTTP listening on %s", opts.addr)
+		errCh <- httpSrv.ListenAndServe()
+	}()
+
+	if opts.tls.quicAddr != "" {
+		quicSrv := server.NewQUIC(opts.tls.quicAddr, mux, tlsConf)
+		defer quicSrv.Close()
+		go func() {
+			log.Printf("corald HTTP/3 listening on %s", opts.tls.quicAddr)
+			errCh <- quicSrv.ListenAndServe()
+		}()
+	}
+
+	var grpcSrv *grpc.Server
+	if opts.grpcAddr != "" {
+		lis, err := net.Listen("tcp", opts.grpcAddr)
+		if err != nil {
+			return err
+		}
+		grpcSrv = grpc.NewServer(server.GRPCOptions(tracer)...)
+		registryv1.RegisterRegistryServiceServer(grpcSrv, server.NewGRPC(cfg))
+		healthpb.RegisterHealthServer(grpcSrv, health)
+		go func() {
+			log.Printf("corald gRPC listening on %s", opts.grpcAddr)
+			errCh <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	// Reloads update components in place, leaving listeners and watch
+	// streams up.
+	reloads := &live{limiter: limiter, apiKeys: apiKeys, intents: intents, roles: roles, tenants: tenants, federation: cfg.Federation, shards: cfg.Shards}
+	reloads.keys, _ = keySource.(*staticKeys)
+	loader.OnChange(func(changed []string) {
+		if err := reloads.reload(flags, changed); err != nil {
+			log.Printf("corald: reload: %v", err)
+		}
+	})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+wait:
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-hup:
+			changed, err := loader.Reload()
+			if err != nil {
+				log.Printf("corald: reload: %v", err)
+				continue
+			}
+			if len(changed) > 0 {
+				log.Printf("corald: reloaded settings; changed -%s", strings.Join(changed, ", -"))
+			} else {
+				log.Printf("corald: reloaded settings")
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	// Drain first, so that agents move to other instances, and hand the
+	// leases held here off once nothing can change them, so that they do
+	// not expire while this instance is replaced.
+	opts.drain.drain(reg)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = httpSrv.Shutdown(shutdownCtx)
+	return errors.Join(err, opts.drain.handoff(reg, cfg.Replication))
+}
+
+// tlsOptions configure TLS for the HTTP listener and the QUIC listener,
+// which cannot run without it.
+type tlsOptions struct {
+	certFile  string
+	keyFile   string
+	quicAddr  string
+	mtls      bool
+	peerRoots string
+}
+
+// config returns nil when no certificate is configured.
+func (o tlsOptions) config() (*tls.Config, error) {
+	if o.certFile == "" && o.keyFile == "" {
+		if o.quicAddr != "" {
+			return nil, errors.New("-quic-addr needs -tls-cert and -tls-key")
+		}
+		if o.mtls {
+			return nil, errors.New("-mtls needs -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if o.mtls {
+		// The server verifies certificates itself, against roots that
+		// rotate with the mesh CA.
+		conf.ClientAuth = tls.RequestClientCert
+	}
+	return conf, nil
+}
+
+// roots returns the mesh CA roots client certificates are verified
+// against with -mtls: those of -mtls-roots, or else of ca, which is nil
+// without -mesh-ca-keys.
+func (o tlsOptions) roots(ca *meshca.CA) (func() (*x509.CertPool, error), error) {
+	switch {
+	case !o.mtls:
+		return nil, nil
+	case o.peerRoots != "":
+		data, err := os.ReadFile(o.peerRoots)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := meshca.ParseRoots(data)
+		if err != nil {
+			return nil, fmt.Errorf("-mtls-roots: %w", err)
+		}
+		return func() (*x509.CertPool, error) { return pool, nil }, nil
+	case ca != nil:
+		return ca.Pool, nil
+	default:
+		return nil, errors.New("-mtls needs -mesh-ca-keys or -mtls-roots")
+	}
+}
+
+// popOptions configure proof-of-possession checks. Bound tickets always
+// require a proof; required extends that to every ticket.
+type popOptions struct {
+	required   bool
+	secretFile string
+}
+
+func (o popOptions) checker() (*pop.Checker, error) {
+	cfg := pop.Config{Required: o.required}
+	if o.secretFile != "" {
+		secret, err := os.ReadFile(o.secretFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Secret = bytes.TrimSpace(secret)
+	}
+	return pop.New(cfg)
+}
+
+// spiffeOptions configure acceptance of JWT-SVIDs.
+type spiffeOptions struct {
+	bundles   string
+	audiences string
+	template  string
+}
+
+// adapter returns nil when no bundle is configured.
+func (o spiffeOptions) adapter() (*spiffe.Adapter, error) {
+	if o.bundles == "" {
+		return nil, nil
+	}
+	cfg := spiffe.Config{Audiences: splitList(o.audiences), Template: o.template}
+	for _, pair := range splitList(o.bundles) {
+		td, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("-spiffe-bundle: expected trust-domain=file, got %q", pair)
+		}
+		b, err := spiffe.LoadBundle(td, path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Bundles = append(cfg.Bundles, b)
+	}
+	return spiffe.New(cfg)
+}
+
+// gossipOptions configure membership in a colony's gossip, through which
+// corald learns of failed agents before their leases lapse.
+type gossipOptions struct {
+	addr       string
+	colony     string
+	reef       string
+	seeds      string
+	secretFile string
+}
+
+// join starts a gossip member reporting to reg and joins the seeds.
+func (o gossipOptions) join(ctx context.Context, reg *registry.Registry) (*gossip.Node, error) {
+	if o.colony == "" || o.reef == "" {
+		return nil, errors.New("-gossip-addr needs -gossip-colony and -gossip-reef")
+	}
+	host, _ := os.Hostname()
+	cfg := gossip.Config{
+		Name:     "corald@" + host + o.addr,
+		Colony:   o.colony,
+		Reef:     o.reef,
+		Addr:     o.addr,
+		Seeds:    splitList(o.seeds),
+		Reporter: reg,
+	}
+	if o.secretFile != "" {
+		secret, err := os.ReadFile(o.secretFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Secret = bytes.TrimSpace(secret)
+	}
+	node, err := gossip.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.Join(ctx); err != nil {
+		node.Close()
+		return nil, err
+	}
+	log.Printf("corald gossiping with colony %s on %s", o.colony, node.Addr())
+	return node, nil
+}
+
+// oidcOptions configure the OIDC token exchange.
+type oidcOptions struct {
+	issuer       string
+	clientID     string
+	grants       string
+	groupsClaim  string
+	subjectClaim string
+	ttl          time.Duration
+}
+
+// exchanger returns an Exchanger signing with set, which is nil without
+// -signing-keys.
+func (o oidcOptions) exchanger(set *jwks.Set, hc *http.Client, c clock.Clock) (*oidc.Exchanger, error) {
+	if set == nil {
+		return nil, errors.New("-oidc-issuer needs -signing-keys to sign exchanged tickets")
+	}
+	cfg := oidc.Config{
+		Issuer:       o.issuer,
+		ClientID:     o.clientID,
+		GroupsClaim:  o.groupsClaim,
+		SubjectClaim: o.subjectClaim,
+		Signer:       set.Signer,
+		TTL:          o.ttl,
+		HTTPClient:   hc,
+		Clock:        c,
+	}
+	if o.grants != "" {
+		data, err := os.ReadFile(o.grants)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Grants, err = oidc.ParseGrants(data); err != nil {
+			return nil, err
+		}
+	}
+	return oidc.New(cfg)
+}
+
+// enrollOptions configure the enrollment queue.
+type enrollOptions struct {
+	enabled bool
+	auto    string
+	ttl     time.Duration
+}
+
+// enroller returns an Enroller issuing identities signed with set, which
+// is nil without -signing-keys. Identities must expire before their
+// signing key stops being published.
+func (o enrollOptions) enroller(set *jwks.Set, st store.Store, c clock.Clock, overlap time.Duration) (*enrollment.Enroller, error) {
+	if set == nil {
+		return nil, errors.New("-enrollment needs -signing-keys to sign identities")
+	}
+	if o.ttl >= overlap {
+		return nil, fmt.Errorf("-enrollment-ttl %s must be shorter than -key-overlap %s", o.ttl, overlap)
+	}
+	cfg := enrollment.Config{
+		Signer:      set.Signer,
+		Store:       st,
+		IdentityTTL: o.ttl,
+		Clock:       c,
+	}
+	if o.auto != "" {
+		cfg.AutoApprove = enrollment.AutoApproveColonies(splitList(o.auto))
+	}
+	return enrollment.New(cfg)
+}
+
+// meshCAOptions configure the mesh CA.
+type meshCAOptions struct {
+	keys        string
+	trustDomain string
+	ttl         time.Duration
+	rotateEvery time.Duration
+}
+
+// ca returns the mesh CA, or nil without -mesh-ca-keys. Its keys rotate
+// when corald starts past their rotation period; the roots of the
+// previous keys stay published.
+func (o meshCAOptions) ca(reg *registry.Registry, st store.Store, c clock.Clock) (*meshca.CA, error) {
+	if o.keys == "" {
+		return nil, nil
+	}
+	km, err := keys.NewManager(keys.ManagerConfig{StoragePath: o.keys, RotationPeriod: o.rotateEvery})
+	if err != nil {
+		return nil, err
+	}
+	return meshca.New(meshca.Config{
+		Keys:        km,
+		Registry:    reg,
+		TrustDomain: o.trustDomain,
+		TTL:         o.ttl,
+		Store:       st,
+		Clock:       c,
+	})
+}
+
+// webhookOptions configure webhook delivery.
+type webhookOptions struct {
+	endpoints string
+	keyFile   string
+	attempts  int
+	retention time.Duration
+}
+
+// dispatcher returns the webhook dispatcher, or nil without -webhooks.
+func (o webhookOptions) dispatcher(reg *registry.Registry, revocations *revocation.List, st store.Store, c clock.Clock) (*webhook.Dispatcher, error) {
+	if o.endpoints == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(o.endpoints)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := webhook.ParseEndpoints(data)
+	if err != nil {
+		return nil, err
+	}
+	var signingKey ed25519.PrivateKey
+	if o.keyFile != "" {
+		encoded, err := os.ReadFile(o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if signingKey, err = keys.DecodePrivateKey(strings.TrimSpace(string(encoded))); err != nil {
+			return nil, err
+		}
+	}
+	return webhook.New(webhook.Config{
+		Endpoints:     endpoints,
+		Registry:      reg,
+		Revocations:   revocations,
+		SigningKey:    signingKey,
+		Store:         st,
+		MaxAttempts:   o.attempts,
+		DeadLetterTTL: o.retention,
+		Clock:         c,
+	})
+}
+
+// eventbusOptions configure the export of registry changes.
+type eventbusOptions struct {
+	url       string
+	topic     string
+	jetStream bool
+}
+
+// exporter returns an Exporter publishing to the -eventbus URL, whose
+// scheme picks NATS or Kafka.
+func (o eventbusOptions) exporter(reg *registry.Registry, c clock.Clock) (*eventbus.Exporter, error) {
+	var pub eventbus.Publisher
+	var err error
+	switch scheme, rest, _ := strings.Cut(o.url, "://"); scheme {
+	case "nats", "tls":
+		pub, err = eventbus.NewNATS(eventbus.NATSConfig{URL: o.url, Subject: o.topic, JetStream: o.jetStream})
+	case "kafka", "kafka+tls":
+		cfg := eventbus.KafkaConfig{Brokers: strings.Split(rest, ","), Topic: o.topic}
+		if scheme == "kafka+tls" {
+			cfg.TLS = &tls.Config{}
+		}
+		pub, err = eventbus.NewKafka(cfg)
+	default:
+		return nil, fmt.Errorf("-eventbus: unsupported scheme %q", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return eventbus.New(eventbus.Config{Registry: reg, Publisher: pub, Clock: c})
+}
+
+// historyOptions configure the archive of registry changes.
+type historyOptions struct {
+	enabled   bool
+	retention time.Duration
+}
+
+// archive returns the history archive, or nil without -history.
+func (o historyOptions) archive(reg *registry.Registry, st store.Store, c clock.Clock) (*history.Archive, error) {
+	if !o.enabled {
+		return nil, nil
+	}
+	return history.New(history.Config{Registry: reg, Store: st, Retention: o.retention, Clock: c})
+}
+
+// readCacheOptions configure the read cache of hot lookups.
+type readCacheOptions struct {
+	ttl         time.Duration
+	consistency string
+}
+
+// cache returns the read cache, or nil when lookups are neither cached
+// nor coalesced.
+func (o readCacheOptions) cache(c clock.Clock) (*readcache.Cache, error) {
+	level, err := readcache.ParseConsistency(o.consistency)
+	if err != nil {
+		return nil, fmt.Errorf("-lookup-consistency: %w", err)
+	}
+	if o.ttl <= 0 && (level == readcache.Default || level == readcache.Strong) {
+		return nil, nil
+	}
+	return readcache.New(readcache.Config{TTL: o.ttl, Consistency: level, Clock: c.Now}), nil
+}
+
+// relayOptions configure the relay broker.
+type relayOptions struct {
+	enabled bool
+	quota   int64
+	rate    int64
+}
+
+// broker returns a Broker signing relay tickets with set, which is nil
+// without -signing-keys.
+func (o relayOptions) broker(reg *registry.Registry, st store.Store, set *jwks.Set) (*relay.Broker, error) {
+	if set == nil {
+		return nil, errors.New("-relays needs -signing-keys to sign relay tickets")
+	}
+	return relay.New(relay.Config{
+		Registry: reg,
+		Signer:   set.Signer,
+		Store:    st,
+		Quota:    o.quota,
+		Rate:     o.rate,
+	})
+}
+
+// federationOptions configure reef federation.
+type federationOptions struct {
+	reef    string
+	anchors string
+	roots   string
+	refresh time.Duration
+}
+
+// federation returns a Federation verifying anchors with -federation-root,
+// or with keySource when unset. It signs with set, which is nil without
+// -signing-keys; the server then answers peers but does not forward.
+func (o federationOptions) federation(keySource verify.KeySource, set *jwks.Set, hc *http.Client) (*federation.Federation, error) {
+	cfg := federation.Config{Reef: o.reef, Roots: keySource, HTTPClient: hc}
+	if o.roots != "" {
+		data, err := os.ReadFile(o.roots)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Roots, err = jwt.NewValidatorFromJSON(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	anchors, err := readAnchors(o.anchors)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Anchors = anchors
+	if set != nil {
+		cfg.Signer, cfg.Document = set.Signer, set.Document
+	}
+	return federation.New(cfg)
+}
+
+// readAnchors reads the trust anchors of the -federation-anchors files.
+func readAnchors(paths string) ([]string, error) {
+	var anchors []string
+	for _, path := range splitList(paths) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, strings.TrimSpace(string(data)))
+	}
+	return anchors, nil
+}
+
+// replicaOptions configure replication between active-active instances.
+type replicaOptions struct {
+	id         string
+	peers      string
+	secretFile string
+	interval   time.Duration
+	maxLag     time.Duration
+	edges      bool
+	origin     string
+}
+
+// replicator returns a Replicator pulling -replica-peers into reg.
+func (o replicaOptions) replicator(reg *registry.Registry, hc *http.Client) (*replication.Replicator, error) {
+	secret, err := o.secret()
+	if err != nil {
+		return nil, err
+	}
+	return replication.New(replication.Config{
+		Registry:   reg,
+		Peers:      splitList(o.peers),
+		Secret:     secret,
+		Interval:   o.interval,
+		HTTPClient: hc,
+	})
+}
+
+// crdt returns the CRDT replica of reg, persisted to st, syncing with
+// -crdt-origin when set.
+func (o replicaOptions) crdt(reg *registry.Registry, st store.Store) (*crdt.Replica, error) {
+	secret, err := o.secret()
+	if err != nil {
+		return nil, err
+	}
+	return crdt.New(crdt.Config{
+		Registry: reg,
+		Store:    st,
+		Origin:   o.origin,
+		Secret:   secret,
+	})
+}
+
+// secret reads -replica-secret-file.
+func (o replicaOptions) secret() ([]byte, error) {
+	if o.secretFile == "" {
+		return nil, errors.New("-replica-id needs -replica-secret-file")
+	}
+	secret, err := os.ReadFile(o.secretFile)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(secret), nil
+}
+
+// openDID returns a resolver for agent documents of the -did-reef reef,
+// or else the -federation-reef one. Agent keys are the hdkey-derived keys
+// in the -jwks file.
+func openDID(opts options, hc *http.Client) (*did.Resolver, error) {
+	reef := cmp.Or(opts.didReef, opts.federate.reef)
+	if reef == "" {
+		return nil, errors.New("-did-domain needs -did-reef or -federation-reef")
+	}
+	cfg := did.Config{Domain: opts.didDomain, Reef: reef, HTTPClient: hc}
+	if opts.jwksPath != "" {
+		data, err := os.ReadFile(opts.jwksPath)
+		if err != nil {
+			return nil, err
+		}
+		var set struct {
+			Keys []hdkey.JWK `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+		}
+		cfg.Keys = set.Keys
+	}
+	return did.New(cfg), nil
+}
+
+// openThreshold loads threshold public keys, either bare or as printed by
+// coralctl threshold-deal, and returns a Coordinator keeping sessions in st.
+func openThreshold(path string, st store.Store) (*threshold.Coordinator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		PublicKeys *threshold.PublicKeys `json:"public_keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse threshold keys: %w", err)
+	}
+	keys := doc.PublicKeys
+	if keys == nil {
+		keys = new(threshold.PublicKeys)
+		if err := json.Unmarshal(data, keys); err != nil {
+			return nil, fmt.Errorf("failed to parse threshold keys: %w", err)
+		}
+	}
+	return threshold.New(threshold.Config{Keys: keys, Store: st})
+}
+
+// traceOptions configure span export to an OpenTelemetry collector.
+type traceOptions struct {
+	endpoint string
+	headers  string
+	service  string
+	ratio    float64
+}
+
+// tracer returns a Tracer exporting to -otlp-endpoint, or nil when unset.
+func (o traceOptions) tracer() (*tracing.Tracer, error) {
+	if o.endpoint == "" {
+		return nil, nil
+	}
+	headers := map[string]string{}
+	for _, pair := range splitList(o.headers) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("-otlp-headers: %q is not name=value", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	exporter, err := tracing.NewOTLP(tracing.OTLPConfig{Endpoint: o.endpoint, Headers: headers})
+	if err != nil {
+		return nil, err
+	}
+	return tracing.New(tracing.Config{Service: o.service, Exporter: exporter, Ratio: o.ratio})
+}
+
+// auditOptions configure the audit log sinks.
+type auditOptions struct {
+	file       string
+	webhook    string
+	secretFile string
+}
+
+// open returns the audit log, nil when no sink is configured, and a
+// function delivering its last webhook records and closing its file.
+func (o auditOptions) open(ctx context.Context) (*audit.Log, func(), error) {
+	var (
+		sinks []audit.Sink
+		file  *audit.File
+		wh    *audit.Webhook
+		err   error
+	)
+	closeAll := func() {
+		if wh != nil {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_ = wh.Flush(flushCtx)
+			cancel()
+		}
+		if file != nil {
+			_ = file.Close()
+		}
+	}
+	if o.file != "" {
+		if file, err = audit.OpenFile(o.file); err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, file)
+	}
+	if o.webhook != "" {
+		cfg := audit.WebhookConfig{URL: o.webhook}
+		if o.secretFile != "" {
+			secret, err := os.ReadFile(o.secretFile)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			cfg.Secret = bytes.TrimSpace(secret)
+		}
+		if wh, err = audit.NewWebhook(cfg); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		go wh.Run(ctx)
+		sinks = append(sinks, wh)
+	}
+	if len(sinks) == 0 {
+		return nil, closeAll, nil
+	}
+	l, err := audit.New(audit.Config{Sinks: sinks})
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return l, closeAll, nil
+}
+
+// verifyOptions are the ticket expectations beyond the signature.
+type verifyOptions struct {
+	issuers   string
+	audiences string
+	algs      string
+	leeway    time.Duration
+	nbfLeeway time.Duration
+	maxTTL    time.Duration
+}
+
+func (o verifyOptions) options(c clock.Clock) verify.Options {
+	return verify.Options{
+		Issuers:         splitList(o.issuers),
+		Audiences:       splitList(o.audiences),
+		Algorithms:      splitList(o.algs),
+		Leeway:          o.leeway,
+		NotBeforeLeeway: o.nbfLeeway,
+		MaxTTL:          o.maxTTL,
+		Clock:           c,
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// openRoles reads the -rbac-policy document, or returns nil for the
+// default policy.
+func openRoles(path string) (*rbac.Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rbac.ParsePolicy(data)
+}
+
+// openAPIKeys reads the -api-keys document, whose expiries are checked
+// against c, or returns nil when API keys are not accepted.
+func openAPIKeys(path string, c clock.Clock) (*auth.APIKeys, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return auth.ParseAPIKeys(data, c)
+}
+
+// openLimiter reads the -rate-limits document, or returns nil when
+// requests are not limited.
+func openLimiter(path string) (*ratelimit.Limiter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	cfg, err := readLimits(path)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.New(cfg)
+}
+
+// readLimits reads a -rate-limits document.
+func readLimits(path string) (ratelimit.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ratelimit.Config{}, err
+	}
+	return ratelimit.ParseConfig(data)
+}
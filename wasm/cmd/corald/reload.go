@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/shard"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tenant"
+)
+
+// reloadedFlags are the settings a reload applies. Others, such as the
+// store backend or listen addresses, take effect on restart.
+var reloadedFlags = []string{"rate-limits", "api-keys", "policy", "rbac-policy", "jwks", "tenants", "federation-anchors", "shard-map"}
+
+// live holds the components corald updates in place when its settings
+// are reloaded, so that connections and watch streams stay up. Each is
+// nil when disabled at startup; enabling or disabling one takes a
+// restart.
+type live struct {
+	limiter    *ratelimit.Limiter
+	apiKeys    *auth.APIKeys
+	intents    *policy.Policy
+	roles      *rbac.Policy
+	keys       *staticKeys
+	tenants    *tenant.Tenants
+	federation *federation.Federation
+	shards     *shard.Router
+}
+
+// reload rereads the documents opts names into the live components,
+// whether or not their paths are among changed: operators usually edit
+// the documents in place. Components whose document fails to load keep
+// their settings.
+func (l *live) reload(opts *options, changed []string) error {
+	for _, name := range changed {
+		if !slices.Contains(reloadedFlags, name) {
+			log.Printf("corald: -%s changed; it takes effect on restart", name)
+		}
+	}
+	var errs []error
+	check := func(flag, path string, enabled bool, load func() error) {
+		switch {
+		case !enabled && path == "":
+		case !enabled || path == "":
+			errs = append(errs, fmt.Errorf("-%s: enabling or disabling it takes a restart", flag))
+		default:
+			if err := load(); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", flag, err))
+			}
+		}
+	}
+	check("rate-limits", opts.limits, l.limiter != nil, func() error {
+		cfg, err := readLimits(opts.limits)
+		if err != nil {
+			return err
+		}
+		return l.limiter.Update(cfg)
+	})
+	check("api-keys", opts.apiKeys, l.apiKeys != nil, func() error {
+		// The live keys keep the clock they were opened with.
+		keys, err := openAPIKeys(opts.apiKeys, nil)
+		if err != nil {
+			return err
+		}
+		l.apiKeys.Replace(keys)
+		return nil
+	})
+	check("policy", opts.policy, l.intents != nil, func() error {
+		data, err := os.ReadFile(opts.policy)
+		if err != nil {
+			return err
+		}
+		p, err := policy.Parse(data)
+		if err != nil {
+			return err
+		}
+		l.intents.Replace(p)
+		return nil
+	})
+	check("rbac-policy", opts.roles, l.roles != nil, func() error {
+		roles, err := openRoles(opts.roles)
+		if err != nil {
+			return err
+		}
+		l.roles.Replace(roles)
+		return nil
+	})
+	check("jwks", opts.jwksPath, l.keys != nil, func() error {
+		return l.keys.load(opts.jwksPath)
+	})
+	check("tenants", opts.tenants, l.tenants != nil, func() error {
+		return l.tenants.Reload(opts.tenants)
+	})
+	check("federation-anchors", opts.federate.anchors, l.federation != nil, func() error {
+		anchors, err := readAnchors(opts.federate.anchors)
+		if err != nil {
+			return err
+		}
+		return l.federation.SetAnchors(anchors)
+	})
+	check("shard-map", opts.shard.mapPath, l.shards != nil, func() error {
+		m, err := readShardMap(opts.shard.mapPath)
+		if err != nil {
+			return err
+		}
+		_, err = l.shards.Update(m)
+		return err
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/shard"
+)
+
+// shardOptions configure sharding the registry by colony.
+type shardOptions struct {
+	id         string
+	mapPath    string
+	secretFile string
+	interval   time.Duration
+}
+
+// router returns the Router placing this instance as -shard-id in the
+// -shard-map, or nil without -shard-id.
+func (o shardOptions) router(hc *http.Client) (*shard.Router, error) {
+	if o.id == "" {
+		return nil, nil
+	}
+	if o.mapPath == "" || o.secretFile == "" {
+		return nil, errors.New("-shard-id needs -shard-map and -shard-secret-file")
+	}
+	m, err := readShardMap(o.mapPath)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := os.ReadFile(o.secretFile)
+	if err != nil {
+		return nil, err
+	}
+	return shard.New(shard.Config{
+		Self:       o.id,
+		Map:        m,
+		Secret:     bytes.TrimSpace(secret),
+		Interval:   o.interval,
+		HTTPClient: hc,
+	})
+}
+
+// readShardMap reads a JSON shard map document.
+func readShardMap(path string) (shard.Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return shard.Map{}, err
+	}
+	return shard.ParseMap(data)
+}
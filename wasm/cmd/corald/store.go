@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/boltstore"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/raftstore"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/redisstore"
+)
+
+// storeOptions selects and configures the registry storage backend.
+type storeOptions struct {
+	backend   string
+	boltPath  string
+	redisAddr string
+	redisPass string
+	redisDB   int
+	raft      raftOptions
+}
+
+// raftOptions configure membership in a Raft group for -store=raft.
+type raftOptions struct {
+	id         string
+	addr       string
+	peers      string
+	dir        string
+	secretFile string
+}
+
+// openStore opens the backend named by opts.backend.
+func openStore(ctx context.Context, opts storeOptions) (store.Store, error) {
+	switch opts.backend {
+	case "", "memory":
+		return store.NewMemory(), nil
+	case "bolt":
+		return boltstore.Open(opts.boltPath)
+	case "redis":
+		return redisstore.Open(ctx, redisstore.Config{
+			Addr:     opts.redisAddr,
+			Password: opts.redisPass,
+			DB:       opts.redisDB,
+		})
+	case "raft":
+		return opts.raft.open()
+	default:
+		return nil, fmt.Errorf("unknown store backend %q (want memory, bolt, redis or raft)", opts.backend)
+	}
+}
+
+// raftStore serves the RPCs of the other members on -raft-addr until it
+// is closed.
+type raftStore struct {
+	*raftstore.Store
+	srv *http.Server
+}
+
+// Close implements store.Store.
+func (s raftStore) Close() error {
+	return errors.Join(s.srv.Close(), s.Store.Close())
+}
+
+// open joins the group of -raft-peers as -raft-id.
+func (o raftOptions) open() (store.Store, error) {
+	if o.id == "" || o.secretFile == "" {
+		return nil, errors.New("-store=raft needs -raft-id and -raft-secret-file")
+	}
+	peers := make(map[string]string)
+	for _, peer := range splitList(o.peers) {
+		id, url, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || url == "" {
+			return nil, fmt.Errorf("-raft-peers: %q is not ID=URL", peer)
+		}
+		peers[id] = url
+	}
+	secret, err := os.ReadFile(o.secretFile)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", o.addr)
+	if err != nil {
+		return nil, err
+	}
+	st, err := raftstore.Open(raftstore.Config{
+		ID:     o.id,
+		Peers:  peers,
+		Dir:    o.dir,
+		Secret: bytes.TrimSpace(secret),
+	})
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	srv := &http.Server{Handler: st.Handler()}
+	go srv.Serve(ln)
+	return raftStore{Store: st, srv: srv}, nil
+}
@@ -0,0 +1,260 @@
+// Package config layers a command's settings from a YAML or JSON file,
+// the environment and its command-line flags, each overriding the one
+// before, and reloads them on demand so that a running server can pick
+// up changes such as new rate limits without a restart.
+//
+// The settings are the flags of a flag.FlagSet. In the file, nested keys
+// join with "-" to name a flag and lists join with commas, so
+//
+//	store: redis
+//	redis:
+//	  addr: cache:6379
+//	federation:
+//	  anchors: [east.jwt, west.jwt]
+//
+// sets -store, -redis-addr and -federation-anchors. In the environment,
+// the prefix followed by the flag name in upper case, with "-" as "_",
+// sets it: CORALD_REDIS_ADDR. Flags given on the command line always
+// win and are never reloaded.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Config holds the configuration for a Loader.
+type Config struct {
+	// Flags holds the settings. It must be parsed before Load. Required.
+	Flags *flag.FlagSet
+
+	// File is the YAML or JSON settings file. No file is read when empty.
+	File string
+
+	// EnvPrefix prefixes the environment variables setting flags, such
+	// as "CORALD_". The environment is not read when empty.
+	EnvPrefix string
+
+	// Skip names flags the file and environment do not set, such as the
+	// one naming the file.
+	Skip []string
+}
+
+// Loader applies the file and environment to a flag set and reapplies
+// them on Reload. It is safe for concurrent use.
+type Loader struct {
+	cfg      Config
+	explicit map[string]bool // flags given on the command line
+
+	mu        sync.Mutex
+	listeners []func(changed []string)
+}
+
+// Load applies the file and environment of cfg to cfg.Flags.
+func Load(cfg Config) (*Loader, error) {
+	if cfg.Flags == nil {
+		return nil, errors.New("config: Flags is required")
+	}
+	if !cfg.Flags.Parsed() {
+		return nil, errors.New("config: Flags must be parsed before Load")
+	}
+	l := &Loader{cfg: cfg, explicit: map[string]bool{}}
+	cfg.Flags.Visit(func(f *flag.Flag) { l.explicit[f.Name] = true })
+	for _, name := range cfg.Skip {
+		l.explicit[name] = true
+	}
+	if _, err := l.apply(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// OnChange registers fn to be called after every successful Reload with
+// the names of the flags whose values changed. It is called even when
+// none did, as documents the flags name may have changed on disk.
+func (l *Loader) OnChange(fn func(changed []string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, fn)
+}
+
+// Reload reads the file and environment again and applies them, then
+// calls the OnChange functions. Settings no longer given revert to their
+// defaults. When any value is invalid, nothing is changed.
+func (l *Loader) Reload() ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed, err := l.apply()
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range l.listeners {
+		fn(changed)
+	}
+	return changed, nil
+}
+
+// apply sets every flag not given on the command line to its value in
+// the environment, or else the file, or else its default, undoing its
+// changes on error.
+func (l *Loader) apply() ([]string, error) {
+	fileValues, err := l.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for name := range fileValues {
+		if l.cfg.Flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("config: %s: unknown setting %q", l.cfg.File, name)
+		}
+		if slices.Contains(l.cfg.Skip, name) {
+			return nil, fmt.Errorf("config: %s: %q cannot be set in the file", l.cfg.File, name)
+		}
+	}
+
+	type change struct{ name, old string }
+	var changes []change
+	undo := func() {
+		for _, c := range changes {
+			_ = l.cfg.Flags.Set(c.name, c.old)
+		}
+	}
+	var failed error
+	l.cfg.Flags.VisitAll(func(f *flag.Flag) {
+		if failed != nil || l.explicit[f.Name] {
+			return
+		}
+		value, ok := l.env(f.Name)
+		if !ok {
+			if value, ok = fileValues[f.Name]; !ok {
+				value = f.DefValue
+			}
+		}
+		old := f.Value.String()
+		if value == old {
+			return
+		}
+		if err := l.cfg.Flags.Set(f.Name, value); err != nil {
+			// Some flag values are left changed by failed sets.
+			_ = l.cfg.Flags.Set(f.Name, old)
+			failed = fmt.Errorf("config: -%s: %w", f.Name, err)
+			return
+		}
+		changes = append(changes, change{f.Name, old})
+	})
+	if failed != nil {
+		undo()
+		return nil, failed
+	}
+	names := make([]string, len(changes))
+	for i, c := range changes {
+		names[i] = c.name
+	}
+	return names, nil
+}
+
+// env returns the value of name's environment variable.
+func (l *Loader) env(name string) (string, bool) {
+	if l.cfg.EnvPrefix == "" {
+		return "", false
+	}
+	return os.LookupEnv(l.cfg.EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+}
+
+// readFile returns the flag values of the file, by flag name.
+func (l *Loader) readFile() (map[string]string, error) {
+	if l.cfg.File == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(l.cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	values, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", l.cfg.File, err)
+	}
+	return values, nil
+}
+
+// Parse decodes a settings document, YAML or JSON, into flag values by
+// flag name.
+func Parse(data []byte) (map[string]string, error) {
+	var doc map[string]any
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if doc, err = parseYAML(data); err != nil {
+			return nil, err
+		}
+	}
+	values := map[string]string{}
+	if err := flatten(values, "", doc); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// flatten adds the values of doc to values, joining nested keys to
+// prefix with "-".
+func flatten(values map[string]string, prefix string, doc map[string]any) error {
+	for key, v := range doc {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+		var value string
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(values, name, v); err != nil {
+				return err
+			}
+			continue
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, ok := scalar(item)
+				if !ok {
+					return fmt.Errorf("%s: lists may only hold scalars", name)
+				}
+				items[i] = s
+			}
+			value = strings.Join(items, ",")
+		default:
+			s, ok := scalar(v)
+			if !ok {
+				return fmt.Errorf("%s: unsupported value", name)
+			}
+			value = s
+		}
+		if _, dup := values[name]; dup {
+			return fmt.Errorf("%s is set twice", name)
+		}
+		values[name] = value
+	}
+	return nil
+}
+
+// scalar formats a scalar JSON or YAML value as a flag value.
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a significant line of a YAML document.
+type yamlLine struct {
+	no     int // 1-based, for errors
+	indent int
+	text   string
+}
+
+// parseYAML decodes the subset of YAML settings files need: nested block
+// mappings, block and flow sequences of scalars, plain and quoted
+// scalars, and comments. Anchors, tags and multi-line scalars are not
+// supported.
+func parseYAML(data []byte) (map[string]any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripComment(raw), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		lines = append(lines, yamlLine{no: i + 1, indent: len(raw) - len(text), text: text})
+	}
+	p := &yamlParser{lines: lines}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	doc, err := p.mapping(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].no)
+	}
+	return doc, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// mapping parses the block mapping whose keys are at indent.
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		key, rest, ok := splitKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.no)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", line.no, key)
+		}
+		p.pos++
+
+		if rest != "" {
+			v, err := flowValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.no, err)
+			}
+			m[key] = v
+			continue
+		}
+		if p.pos == len(p.lines) || p.lines[p.pos].indent < indent ||
+			p.lines[p.pos].indent == indent && !isItem(p.lines[p.pos].text) {
+			m[key] = nil
+			continue
+		}
+		next := p.lines[p.pos]
+		var err error
+		if isItem(next.text) {
+			m[key], err = p.sequence(next.indent)
+		} else {
+			m[key], err = p.mapping(next.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].no)
+	}
+	return m, nil
+}
+
+// sequence parses the block sequence whose items are at indent.
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	var items []any
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if _, _, ok := splitKey(rest); ok && !strings.HasPrefix(rest, "\"") && !strings.HasPrefix(rest, "'") {
+			return nil, fmt.Errorf("line %d: lists may only hold scalars", line.no)
+		}
+		v, err := plainOrQuoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.no, err)
+		}
+		items = append(items, v)
+		p.pos++
+	}
+	return items, nil
+}
+
+// isItem reports whether text is a block sequence item.
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits "key: value" or "key:" at the colon.
+func splitKey(text string) (key, rest string, ok bool) {
+	if k, ok := strings.CutSuffix(text, ":"); ok && !strings.Contains(k, ": ") {
+		return unquoteKey(k), "", k != ""
+	}
+	k, rest, ok := strings.Cut(text, ": ")
+	if !ok || k == "" {
+		return "", "", false
+	}
+	return unquoteKey(k), strings.TrimSpace(rest), true
+}
+
+func unquoteKey(k string) string {
+	if v, err := plainOrQuoted(k); err == nil {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return k
+}
+
+// flowValue parses a scalar or a flow sequence of scalars.
+func flowValue(s string) (any, error) {
+	inner, ok := strings.CutPrefix(s, "[")
+	if !ok {
+		return plainOrQuoted(s)
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, fmt.Errorf("unterminated list %q", s)
+	}
+	items := []any{}
+	if strings.TrimSpace(inner) == "" {
+		return items, nil
+	}
+	for _, item := range splitFlow(inner) {
+		v, err := plainOrQuoted(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// splitFlow splits the items of a flow sequence at commas outside quotes.
+func splitFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// plainOrQuoted parses a scalar; null and ~ are nil.
+func plainOrQuoted(s string) (any, error) {
+	switch {
+	case s == "" || s == "~" || s == "null":
+		return nil, nil
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*") || strings.HasPrefix(s, "!"):
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+	return s, nil
+}
+
+// stripComment removes a trailing comment: a # at the start of the line
+// or after whitespace, outside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,196 @@
+package crdt
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Dot identifies a single write: the replica that made it and its
+// sequence number at that replica.
+type Dot struct {
+	Node    string `json:"node"`
+	Counter uint64 `json:"counter"`
+}
+
+// Context is a causal context: the writes a replica has seen, as the
+// highest counter seen per replica. A replica's own writes are numbered
+// without gaps and states are always joined whole, so the dots seen form
+// a prefix for each replica.
+type Context map[string]uint64
+
+// Contains reports whether c has seen d.
+func (c Context) Contains(d Dot) bool {
+	return d.Counter <= c[d.Node]
+}
+
+// includes reports whether c has seen every write o has.
+func (c Context) includes(o Context) bool {
+	for node, counter := range o {
+		if c[node] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+// merge adds the writes o has seen to c.
+func (c Context) merge(o Context) {
+	for node, counter := range o {
+		c[node] = max(c[node], counter)
+	}
+}
+
+// Register is the last-writer-wins register of one agent: its record,
+// ordered by the record's Version, and the dots of the writes that put
+// it there and have not been overwritten or removed since.
+type Register struct {
+	Dots []Dot `json:"dots"`
+
+	// Record is omitted from deltas for receivers that have seen every
+	// dot.
+	Record *registry.Record `json:"record,omitempty"`
+}
+
+// Map is an observed-remove map of registers keyed by reef and agent ID
+// (see Key). A
+// removal deletes only the writes it observed, so a concurrent write
+// wins over it; concurrent writes of the same agent keep the later
+// record. Joining maps is commutative, associative and idempotent, so
+// replicas that exchange deltas in any order and any number of times
+// converge.
+type Map struct {
+	Entries map[string]*Register `json:"entries"`
+	Context Context              `json:"context"`
+
+	// Since is, for deltas, the context they were computed against.
+	// Records the receiver is assumed to hold are left out, so a
+	// receiver that has not seen Since, having lost its state, must not
+	// join the delta.
+	Since Context `json:"since,omitempty"`
+}
+
+// Key returns the key of agentID of reefID in a Map. Agent IDs are only
+// unique within their reef.
+func Key(reefID, agentID string) string {
+	return reefID + "/" + agentID
+}
+
+// splitKey returns the reef and agent ID of key, and false for keys not
+// returned by Key.
+func splitKey(key string) (reefID, agentID string, ok bool) {
+	return strings.Cut(key, "/")
+}
+
+// NewMap returns an empty map.
+func NewMap() *Map {
+	return &Map{Entries: map[string]*Register{}, Context: Context{}}
+}
+
+// Set writes rec as node, replacing the writes of rec's agent node has
+// seen.
+func (m *Map) Set(node string, rec *registry.Record) {
+	m.Context[node]++
+	m.Entries[Key(rec.ReefID, rec.AgentID)] = &Register{
+		Dots:   []Dot{{Node: node, Counter: m.Context[node]}},
+		Record: rec.Clone(),
+	}
+}
+
+// Remove deletes the entry under key. Its dots stay in the context, so
+// the removal reaches replicas that join the map.
+func (m *Map) Remove(key string) {
+	delete(m.Entries, key)
+}
+
+// Delta returns the part of m a replica that has seen since needs to
+// reach m's state: the records with writes it has not seen and, without
+// their records, the dots of every other entry, so the receiver can tell
+// the entries m still holds from those m removed.
+func (m *Map) Delta(since Context) *Map {
+	d := &Map{
+		Entries: make(map[string]*Register, len(m.Entries)),
+		Context: maps.Clone(m.Context),
+		Since:   maps.Clone(since),
+	}
+	for id, reg := range m.Entries {
+		out := &Register{Dots: slices.Clone(reg.Dots)}
+		if slices.ContainsFunc(reg.Dots, func(dot Dot) bool { return !since.Contains(dot) }) {
+			out.Record = reg.Record.Clone()
+		}
+		d.Entries[id] = out
+	}
+	return d
+}
+
+// Join merges d, a map or a delta of one, into m and returns the IDs of
+// the agents whose record changed or was removed.
+func (m *Map) Join(d *Map) []string {
+	var changed []string
+	keys := maps.Clone(m.Entries)
+	maps.Copy(keys, d.Entries)
+	for id := range keys {
+		a, b := m.Entries[id], d.Entries[id]
+		dots := survivors(a, b, d.Context)
+		dots = append(dots, survivors(b, a, m.Context)...)
+		if len(dots) == 0 {
+			if a != nil {
+				delete(m.Entries, id)
+				changed = append(changed, id)
+			}
+			continue
+		}
+		rec := later(a, b)
+		if rec == nil {
+			// Every surviving dot is known to the receiver, which holds
+			// the record; only a malformed delta lands here.
+			continue
+		}
+		if a == nil || a.Record != rec {
+			changed = append(changed, id)
+		}
+		slices.SortFunc(dots, compareDots)
+		m.Entries[id] = &Register{Dots: slices.Compact(dots), Record: rec}
+	}
+	m.Context.merge(d.Context)
+	slices.Sort(changed)
+	return changed
+}
+
+// survivors returns the dots of a that b still holds or that the replica
+// of b has not seen yet.
+func survivors(a, b *Register, seen Context) []Dot {
+	if a == nil {
+		return nil
+	}
+	var out []Dot
+	for _, dot := range a.Dots {
+		if (b != nil && slices.Contains(b.Dots, dot)) || !seen.Contains(dot) {
+			out = append(out, dot)
+		}
+	}
+	return out
+}
+
+// later returns the later of the records of a and b, preferring a's on
+// a tie.
+func later(a, b *Register) *registry.Record {
+	var ra, rb *registry.Record
+	if a != nil {
+		ra = a.Record
+	}
+	if b != nil {
+		rb = b.Record
+	}
+	if ra == nil || (rb != nil && rb.Version.Compare(ra.Version) > 0) {
+		return rb
+	}
+	return ra
+}
+
+func compareDots(a, b Dot) int {
+	return cmp.Or(cmp.Compare(a.Node, b.Node), cmp.Compare(a.Counter, b.Counter))
+}
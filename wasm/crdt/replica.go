@@ -0,0 +1,350 @@
+// Package crdt lets edge registries, such as the Worker build's Durable
+// Objects, accept registrations locally and converge with an origin
+// server asynchronously, so agents can register while the origin is
+// unreachable. Each replica keeps the records it knows in a delta-state
+// CRDT, an observed-remove map of last-writer-wins registers (Map), fed
+// by the local registry's own writes. Edges periodically push the delta
+// the origin lacks and merge the delta the origin answers with; merged
+// records reach the local registry through registry.Apply.
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// SyncPath is the origin route edges push their deltas to. The response
+// is the delta the edge lacks.
+const SyncPath = "/v1/crdt/sync"
+
+// DefaultInterval is how often edges sync when the Config does not say.
+const DefaultInterval = 5 * time.Second
+
+// stateKey is the store key holding the replica's state.
+const stateKey = "crdt/state"
+
+// expiredRetention is how long after its lease ran out a record is
+// dropped from the map, well past any registry grace period.
+const expiredRetention = time.Hour
+
+// ErrUnauthorized is returned for sync requests without the shared
+// secret.
+var ErrUnauthorized = errors.New("invalid sync secret")
+
+// Config holds the configuration for a Replica.
+type Config struct {
+	// Registry is the local registry, which must have a ReplicaID; it
+	// names the replica's writes. Required.
+	Registry *registry.Registry
+
+	// Store persists the replica's state, typically the registry's
+	// store. Required.
+	Store store.Store
+
+	// Origin is the base URL of the origin server edges sync with.
+	// Empty on the origin, and in Workers, which sync from JavaScript
+	// with Request and Complete.
+	Origin string
+
+	// Secret is shared by the origin and its edges and authenticates
+	// syncs. Required to serve or call SyncPath.
+	Secret []byte
+
+	// Interval is how often Run syncs. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// HTTPClient reaches the origin. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Replica is one replica of the registry CRDT. It is safe for concurrent
+// use.
+type Replica struct {
+	registry *registry.Registry
+	store    store.Store
+	node     string
+	origin   string
+	secret   []byte
+	interval time.Duration
+	http     *http.Client
+
+	mu    sync.Mutex
+	state *state // nil until loaded
+}
+
+// state is what a replica persists.
+type state struct {
+	Map *Map `json:"map"`
+
+	// Cursor is how far the registry's changes have been captured.
+	Cursor uint64 `json:"cursor"`
+
+	// Peer is the context the origin last answered with.
+	Peer Context `json:"peer"`
+}
+
+// New creates a Replica from cfg.
+func New(cfg Config) (*Replica, error) {
+	if cfg.Registry == nil || cfg.Store == nil {
+		return nil, errors.New("crdt: Registry and Store are required")
+	}
+	if cfg.Registry.ReplicaID() == "" {
+		return nil, errors.New("crdt: the registry needs a ReplicaID")
+	}
+	if cfg.Origin != "" && len(cfg.Secret) == 0 {
+		return nil, errors.New("crdt: Origin needs a Secret")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Replica{
+		registry: cfg.Registry,
+		store:    cfg.Store,
+		node:     cfg.Registry.ReplicaID(),
+		origin:   strings.TrimSuffix(cfg.Origin, "/"),
+		secret:   cfg.Secret,
+		interval: cfg.Interval,
+		http:     cfg.HTTPClient,
+	}, nil
+}
+
+// Authenticate checks that token is the shared secret.
+func (r *Replica) Authenticate(token string) error {
+	got, want := sha256.Sum256([]byte(token)), sha256.Sum256(r.secret)
+	if len(r.secret) == 0 || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Handle merges delta pushed by an edge and returns the delta the edge
+// lacks, for SyncPath.
+func (r *Replica) Handle(ctx context.Context, delta *Map) (*Map, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.capture(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.merge(ctx, delta); err != nil {
+		return nil, err
+	}
+	if err := r.save(ctx); err != nil {
+		return nil, err
+	}
+	return r.state.Map.Delta(delta.Context), nil
+}
+
+// Request returns the delta to push to the origin: what the replica
+// knows beyond the origin's last answer.
+func (r *Replica) Request(ctx context.Context) (*Map, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.capture(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.save(ctx); err != nil {
+		return nil, err
+	}
+	return r.state.Map.Delta(r.state.Peer), nil
+}
+
+// Complete merges the delta the origin answered a Request with.
+func (r *Replica) Complete(ctx context.Context, delta *Map) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(ctx); err != nil {
+		return err
+	}
+	if err := r.merge(ctx, delta); err != nil {
+		return err
+	}
+	r.state.Peer = delta.Context
+	return r.save(ctx)
+}
+
+// Sync pushes a Request to the origin and Completes it with the answer.
+func (r *Replica) Sync(ctx context.Context) error {
+	if r.origin == "" {
+		return errors.New("crdt: no Origin to sync with")
+	}
+	delta, err := r.Request(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.origin+SyncPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(r.secret))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("origin: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("origin: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var answer Map
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return fmt.Errorf("origin: %w", err)
+	}
+	return r.Complete(ctx, &answer)
+}
+
+// Run calls Sync every interval until ctx is done. Failed syncs are
+// retried at the next interval; local writes stay in the map meanwhile.
+func (r *Replica) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = r.Sync(ctx)
+		}
+	}
+}
+
+// capture adds the registry's writes made by this replica since the
+// cursor to the map. Writes merged from other replicas carry their
+// writer's version and are already in it.
+func (r *Replica) capture(ctx context.Context) error {
+	if err := r.load(ctx); err != nil {
+		return err
+	}
+	changes, next, err := r.registry.Changes(ctx, r.state.Cursor, 0)
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		switch {
+		case ch.Record != nil && ch.Record.Version.Node == r.node:
+			r.state.Map.Set(r.node, ch.Record)
+		case ch.Tombstone != nil && ch.Tombstone.Version.Node == r.node:
+			r.state.Map.Remove(Key(ch.Tombstone.ReefID, ch.Tombstone.AgentID))
+		}
+	}
+	r.state.Cursor = next
+
+	// Evictions are not writes; every replica drops long expired
+	// records on its own, and a removal only loses to a renewal.
+	cutoff := time.Now().Add(-expiredRetention)
+	for id, reg := range r.state.Map.Entries {
+		if reg.Record != nil && reg.Record.ExpiresAt.Before(cutoff) {
+			r.state.Map.Remove(id)
+		}
+	}
+	return nil
+}
+
+// merge joins delta into the map and applies the changed records to the
+// registry. Deltas computed against writes the replica has not seen are
+// dropped: the replica lost state its peer assumed, and the peer sends
+// a full delta once it learns the replica's actual context.
+func (r *Replica) merge(ctx context.Context, delta *Map) error {
+	if delta.Context == nil {
+		delta.Context = Context{}
+	}
+	if !r.state.Map.Context.includes(delta.Since) {
+		return nil
+	}
+	for _, id := range r.state.Map.Join(delta) {
+		ch := &registry.Change{}
+		if reg, ok := r.state.Map.Entries[id]; ok {
+			ch.Record = reg.Record
+		} else {
+			reefID, agentID, ok := splitKey(id)
+			if !ok {
+				continue
+			}
+			rec, err := r.registry.Lookup(ctx, reefID, agentID)
+			if errors.Is(err, registry.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// Removals order after the record they remove.
+			version := rec.Version
+			version.Logical++
+			version.Node = r.node
+			ch.Tombstone = &registry.Tombstone{
+				AgentID:   rec.AgentID,
+				ColonyID:  rec.ColonyID,
+				ReefID:    rec.ReefID,
+				Version:   version,
+				DeletedAt: time.Now(),
+			}
+		}
+		if _, err := r.registry.Apply(ctx, ch); err != nil && !errors.Is(err, registry.ErrInvalidRecord) {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the persisted state on first use.
+func (r *Replica) load(ctx context.Context) error {
+	if r.state != nil {
+		return nil
+	}
+	st := &state{}
+	entry, err := r.store.Get(ctx, stateKey)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to read crdt state: %w", err)
+	default:
+		if err := json.Unmarshal(entry.Value, st); err != nil {
+			return fmt.Errorf("failed to decode crdt state: %w", err)
+		}
+	}
+	if st.Map == nil {
+		st.Map = NewMap()
+	}
+	if st.Map.Entries == nil {
+		st.Map.Entries = map[string]*Register{}
+	}
+	if st.Map.Context == nil {
+		st.Map.Context = Context{}
+	}
+	r.state = st
+	return nil
+}
+
+// save persists the state.
+func (r *Replica) save(ctx context.Context) error {
+	data, err := json.Marshal(r.state)
+	if err != nil {
+		return fmt.Errorf("failed to encode crdt state: %w", err)
+	}
+	if _, err := r.store.Put(ctx, stateKey, data); err != nil {
+		return fmt.Errorf("failed to store crdt state: %w", err)
+	}
+	return nil
+}
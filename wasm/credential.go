@@ -0,0 +1,73 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/vc"
+)
+
+// verifyCredential verifies a colony membership Verifiable Credential
+// (Ed25519Signature2020) whose issuer must be a did:key of one of the
+// keys in jwksJSON, normally the discovery JWKS.
+// Arguments: credentialJSON, jwksJSON
+// Returns: { valid: true, issuer, subject, reefId, colonyId, expiresAt } or { error: { code, message, retryable } }
+func verifyCredential(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: credentialJSON, jwksJSON")
+	}
+
+	c, err := vc.Parse([]byte(args[0].String()))
+	if err != nil {
+		return errorResult(errCredential, err.Error())
+	}
+	var set jwt.JWKS
+	if err := json.Unmarshal([]byte(args[1].String()), &set); err != nil {
+		return errorResult(errJWKSMalformed, "failed to parse JWKS JSON: "+err.Error())
+	}
+
+	pub, err := vc.Verify(context.Background(), c, nil)
+	if err != nil {
+		return errorResult(errCredential, err.Error())
+	}
+	if !trustedKey(set, pub) {
+		return errorResult(errCredential, "credential issuer "+c.Issuer+" is not in the JWKS")
+	}
+	reefID, colonyID, err := vc.ParseColonyIRI(c.CredentialSubject.MemberOf)
+	if err != nil {
+		return errorResult(errCredential, err.Error())
+	}
+	var expiresAt int64
+	if c.ExpirationDate != "" {
+		t, _ := time.Parse(time.RFC3339, c.ExpirationDate)
+		expiresAt = t.Unix()
+	}
+
+	return map[string]interface{}{
+		"valid":     true,
+		"issuer":    c.Issuer,
+		"subject":   c.CredentialSubject.ID,
+		"reefId":    reefID,
+		"colonyId":  colonyID,
+		"expiresAt": expiresAt,
+	}
+}
+
+// trustedKey reports whether pub is one of set's Ed25519 keys.
+func trustedKey(set jwt.JWKS, pub ed25519.PublicKey) bool {
+	for _, key := range set.Keys {
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err == nil && key.CRV == "Ed25519" && pub.Equal(ed25519.PublicKey(x)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package cwt
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// CBOR major types (RFC 8949 §3.1).
+const (
+	majorUint  = 0
+	majorNeg   = 1
+	majorBytes = 2
+	majorText  = 3
+	majorArray = 4
+	majorMap   = 5
+	majorTag   = 6
+)
+
+// maxDepth bounds nesting when decoding, so a hostile ticket cannot
+// exhaust the stack.
+const maxDepth = 8
+
+var errCBOR = errors.New("malformed CBOR")
+
+// encoder appends the subset of CBOR tickets use: integers, byte and text
+// strings, arrays, maps with integer keys, and tags. Lengths are always
+// definite and arguments minimal, so encodings are deterministic.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, m|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, m|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, m|27), n)
+	}
+}
+
+func (e *encoder) int(n int64) {
+	if n < 0 {
+		e.head(majorNeg, uint64(-1-n))
+		return
+	}
+	e.head(majorUint, uint64(n))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.head(majorBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) text(s string) {
+	e.head(majorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads the subset written by encoder. Integers decode to int64,
+// byte strings to []byte, text to string, arrays to []interface{}, maps
+// to map[int64]interface{} and tags to tagged.
+type decoder struct {
+	data []byte
+	off  int
+}
+
+// tagged is a decoded CBOR tag and its content.
+type tagged struct {
+	tag   uint64
+	value interface{}
+}
+
+// decode decodes data, which must hold exactly one item.
+func decode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, errors.New("trailing bytes after CBOR item")
+	}
+	return v, nil
+}
+
+func (d *decoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info := b>>5, b&0x1f
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		// Indefinite lengths, floats and simple values are not used.
+		return 0, 0, errCBOR
+	}
+	size := 1 << (info - 24)
+	if len(d.data)-d.off < size {
+		return 0, 0, errCBOR
+	}
+	var n uint64
+	for _, c := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.off += size
+	return major, n, nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("CBOR nested too deeply")
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUint:
+		if n > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(n), nil
+	case majorNeg:
+		if n > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(n), nil
+	case majorBytes, majorText:
+		if n > uint64(len(d.data)-d.off) {
+			return nil, errCBOR
+		}
+		b := d.data[d.off : d.off+int(n)]
+		d.off += int(n)
+		if major == majorText {
+			return string(b), nil
+		}
+		return b, nil
+	case majorArray:
+		// Every item takes at least one byte.
+		if n > uint64(len(d.data)-d.off) {
+			return nil, errCBOR
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case majorMap:
+		if n > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBOR
+		}
+		m := make(map[int64]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(int64)
+			if !ok {
+				return nil, errors.New("CBOR map key is not an integer")
+			}
+			if _, dup := m[key]; dup {
+				return nil, errors.New("duplicate CBOR map key")
+			}
+			if m[key], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorTag:
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return tagged{tag: n, value: v}, nil
+	default:
+		return nil, errCBOR
+	}
+}
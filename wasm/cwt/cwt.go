@@ -0,0 +1,387 @@
+// Package cwt encodes referral tickets as CBOR Web Tokens (RFC 8392)
+// signed with COSE_Sign1 (RFC 9052), a binary alternative to the JWT
+// encoding for agents behind links with hard payload limits, such as
+// LoRa. A CWT ticket carries the same claims and is typically less than
+// half the size of the equivalent JWT.
+//
+// Tickets are signed with Ed25519 (COSE alg -8, EdDSA) and verified with
+// the same keys and verify.Options as JWT tickets.
+package cwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// COSE and CWT tags (RFC 9052 §2, RFC 8392 §6).
+const (
+	tagSign1 = 18
+	tagCWT   = 61
+)
+
+// COSE header parameters and algorithm.
+const (
+	headerAlg = 1
+	headerKid = 4
+	algEdDSA  = -8
+)
+
+// CWT claim keys (RFC 8392 §4). The referral members use private-use
+// keys, below -65536.
+const (
+	claimIss      = 1
+	claimAud      = 3
+	claimExp      = 4
+	claimNbf      = 5
+	claimIat      = 6
+	claimCti      = 7
+	claimReefID   = -65537
+	claimColonyID = -65538
+	claimAgentID  = -65539
+	claimIntent   = -65540
+)
+
+// ErrMalformed is returned for data that is not a COSE_Sign1 CWT.
+var ErrMalformed = errors.New("malformed CWT")
+
+// Sign encodes claims as a CWT signed with key under kid.
+func Sign(key ed25519.PrivateKey, kid string, claims *jwt.ReferralClaims) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("no signing key available")
+	}
+
+	var protected encoder
+	protected.head(majorMap, 2)
+	protected.int(headerAlg)
+	protected.int(algEdDSA)
+	protected.int(headerKid)
+	protected.bytes([]byte(kid))
+
+	payload := encodeClaims(claims)
+	sig := ed25519.Sign(key, sigStructure(protected.buf, payload))
+
+	var e encoder
+	e.head(majorTag, tagSign1)
+	e.head(majorArray, 4)
+	e.bytes(protected.buf)
+	e.head(majorMap, 0)
+	e.bytes(payload)
+	e.bytes(sig)
+	return e.buf, nil
+}
+
+// CreateReferralTicket mints a CWT referral ticket living for ttl. Empty
+// issuer and audience default to jwt.DefaultIssuer and
+// jwt.DefaultAudience. It returns the ticket and its expiry in Unix
+// seconds, like jwt.CreateReferralTicketStatic.
+func CreateReferralTicket(key ed25519.PrivateKey, kid, reefID, colonyID, agentID, intent string, ttl time.Duration, issuer, audience string) ([]byte, int64, error) {
+	if issuer == "" {
+		issuer = jwt.DefaultIssuer
+	}
+	if audience == "" {
+		audience = jwt.DefaultAudience
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := &jwt.ReferralClaims{
+		ReefID:   reefID,
+		ColonyID: colonyID,
+		AgentID:  agentID,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    issuer,
+			Audience:  gojwt.ClaimStrings{audience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expiresAt),
+		},
+	}
+	ticket, err := Sign(key, kid, claims)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ticket, expiresAt.Unix(), nil
+}
+
+// Decode returns the kid and claims of a CWT ticket without verifying
+// it. The result must only be used for routing, never for authorization.
+func Decode(data []byte) (string, *jwt.ReferralClaims, error) {
+	msg, err := parseSign1(data)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, err := decodeClaims(msg.payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return msg.kid, claims, nil
+}
+
+// Parse verifies a CWT ticket's signature with keys and checks its claims
+// against opts, the same expectations verify.Parse applies to JWTs.
+// Errors wrap the golang-jwt sentinels so callers classify both
+// encodings alike: a bad signature or disallowed algorithm wraps
+// gojwt.ErrTokenSignatureInvalid and an unknown kid
+// gojwt.ErrTokenUnverifiable.
+func Parse(keys verify.KeySource, data []byte, opts verify.Options) (*jwt.ReferralClaims, error) {
+	msg, err := parseSign1(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", gojwt.ErrTokenMalformed, err)
+	}
+	if msg.alg != algEdDSA || !opts.AllowsAlgorithm(verify.EdDSA) {
+		return nil, fmt.Errorf("%w: COSE algorithm %d is not allowed", gojwt.ErrTokenSignatureInvalid, msg.alg)
+	}
+
+	pub, err := verify.Ed25519Key(keys, msg.kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", gojwt.ErrTokenUnverifiable, err)
+	}
+	if !ed25519.Verify(pub, sigStructure(msg.protected, msg.payload), msg.signature) {
+		return nil, gojwt.ErrTokenSignatureInvalid
+	}
+
+	claims, err := decodeClaims(msg.payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", gojwt.ErrTokenMalformed, err)
+	}
+	if err := gojwt.NewValidator(opts.ParserOptions()...).Validate(claims); err != nil {
+		return nil, err
+	}
+	if err := opts.Check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Static verifies a CWT ticket against a JWKS document with opts. Like
+// verify.Static, a bad signature yields false with a nil error and any
+// other failure an error.
+func Static(data []byte, jwksJSON string, opts verify.Options) (bool, error) {
+	keys, err := verify.ParseKeys([]byte(jwksJSON))
+	if err != nil {
+		return false, err
+	}
+	if _, err := Parse(keys, data, opts); err != nil {
+		if errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// sign1 is a decoded COSE_Sign1 message.
+type sign1 struct {
+	protected []byte
+	payload   []byte
+	signature []byte
+	alg       int64
+	kid       string
+}
+
+// parseSign1 decodes a COSE_Sign1 message, optionally wrapped in the CWT
+// tag, and its protected header.
+func parseSign1(data []byte) (*sign1, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := v.(tagged); ok && t.tag == tagCWT {
+		v = t.value
+	}
+	if t, ok := v.(tagged); ok && t.tag == tagSign1 {
+		v = t.value
+	}
+	items, ok := v.([]interface{})
+	if !ok || len(items) != 4 {
+		return nil, ErrMalformed
+	}
+	msg := &sign1{}
+	if msg.protected, ok = items[0].([]byte); !ok {
+		return nil, ErrMalformed
+	}
+	if msg.payload, ok = items[2].([]byte); !ok {
+		return nil, ErrMalformed
+	}
+	if msg.signature, ok = items[3].([]byte); !ok {
+		return nil, ErrMalformed
+	}
+
+	h, err := decode(msg.protected)
+	if err != nil {
+		return nil, fmt.Errorf("%w: protected header: %w", ErrMalformed, err)
+	}
+	header, ok := h.(map[int64]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: protected header is not a map", ErrMalformed)
+	}
+	if msg.alg, ok = header[headerAlg].(int64); !ok {
+		return nil, fmt.Errorf("%w: missing alg", ErrMalformed)
+	}
+	kid, ok := header[headerKid].([]byte)
+	if !ok || len(kid) == 0 {
+		return nil, fmt.Errorf("%w: missing kid", ErrMalformed)
+	}
+	msg.kid = string(kid)
+	return msg, nil
+}
+
+// sigStructure builds the Sig_structure signed by COSE_Sign1, with an
+// empty external_aad.
+func sigStructure(protected, payload []byte) []byte {
+	var e encoder
+	e.head(majorArray, 4)
+	e.text("Signature1")
+	e.bytes(protected)
+	e.bytes(nil)
+	e.bytes(payload)
+	return e.buf
+}
+
+// encodeClaims encodes claims as a CWT claims map in deterministic key
+// order. A UUID jti is packed into its 16 bytes; any other jti of that
+// length is written as text so it does not decode as a UUID.
+func encodeClaims(c *jwt.ReferralClaims) []byte {
+	type entry struct {
+		key   int64
+		write func(*encoder)
+	}
+	var entries []entry
+	text := func(key int64, s string) {
+		if s != "" {
+			entries = append(entries, entry{key, func(e *encoder) { e.text(s) }})
+		}
+	}
+	date := func(key int64, d *gojwt.NumericDate) {
+		if d != nil {
+			entries = append(entries, entry{key, func(e *encoder) { e.int(d.Unix()) }})
+		}
+	}
+
+	text(claimIss, c.Issuer)
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		text(claimAud, c.Audience[0])
+	default:
+		aud := c.Audience
+		entries = append(entries, entry{claimAud, func(e *encoder) {
+			e.head(majorArray, uint64(len(aud)))
+			for _, a := range aud {
+				e.text(a)
+			}
+		}})
+	}
+	date(claimExp, c.ExpiresAt)
+	date(claimNbf, c.NotBefore)
+	date(claimIat, c.IssuedAt)
+	if c.ID != "" {
+		id, err := uuid.Parse(c.ID)
+		switch {
+		case err == nil && id.String() == c.ID:
+			entries = append(entries, entry{claimCti, func(e *encoder) { e.bytes(id[:]) }})
+		case len(c.ID) == len(id):
+			text(claimCti, c.ID)
+		default:
+			entries = append(entries, entry{claimCti, func(e *encoder) { e.bytes([]byte(c.ID)) }})
+		}
+	}
+	text(claimReefID, c.ReefID)
+	text(claimColonyID, c.ColonyID)
+	text(claimAgentID, c.AgentID)
+	text(claimIntent, c.Intent)
+
+	var e encoder
+	e.head(majorMap, uint64(len(entries)))
+	for _, en := range entries {
+		e.int(en.key)
+		en.write(&e)
+	}
+	return e.buf
+}
+
+// decodeClaims decodes a CWT claims map. Unknown claims are ignored.
+func decodeClaims(payload []byte) (*jwt.ReferralClaims, error) {
+	v, err := decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[int64]interface{})
+	if !ok {
+		return nil, errors.New("claims are not a map")
+	}
+
+	c := &jwt.ReferralClaims{}
+	var bad []string
+	text := func(key int64, name string, dst *string) {
+		if v, ok := m[key]; ok {
+			if *dst, ok = v.(string); !ok {
+				bad = append(bad, name)
+			}
+		}
+	}
+	date := func(key int64, name string) *gojwt.NumericDate {
+		v, ok := m[key]
+		if !ok {
+			return nil
+		}
+		n, ok := v.(int64)
+		if !ok {
+			bad = append(bad, name)
+			return nil
+		}
+		return gojwt.NewNumericDate(time.Unix(n, 0))
+	}
+
+	text(claimIss, "iss", &c.Issuer)
+	switch aud := m[claimAud].(type) {
+	case nil:
+	case string:
+		c.Audience = gojwt.ClaimStrings{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				bad = append(bad, "aud")
+				break
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		bad = append(bad, "aud")
+	}
+	c.ExpiresAt = date(claimExp, "exp")
+	c.NotBefore = date(claimNbf, "nbf")
+	c.IssuedAt = date(claimIat, "iat")
+	switch cti := m[claimCti].(type) {
+	case nil:
+	case string:
+		c.ID = cti
+	case []byte:
+		if len(cti) == len(uuid.UUID{}) {
+			c.ID = uuid.UUID(cti).String()
+		} else {
+			c.ID = string(cti)
+		}
+	default:
+		bad = append(bad, "cti")
+	}
+	text(claimReefID, "reef_id", &c.ReefID)
+	text(claimColonyID, "colony_id", &c.ColonyID)
+	text(claimAgentID, "agent_id", &c.AgentID)
+	text(claimIntent, "intent", &c.Intent)
+
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("claims of the wrong type: %v", bad)
+	}
+	return c, nil
+}
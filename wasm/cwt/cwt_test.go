@@ -0,0 +1,119 @@
+package cwt_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/cwt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// testKeys returns a JWKS holding an Ed25519 key "k1", the key set parsed
+// from it and its private key.
+func testKeys(t *testing.T) (string, *verify.Keys, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","use":"sig","x":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(pub))
+	keys, err := verify.ParseKeys([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwks, keys, priv
+}
+
+func TestRoundTrip(t *testing.T) {
+	jwks, keys, priv := testKeys(t)
+	ticket, exp, err := cwt.CreateReferralTicket(priv, "k1", "reef", "colony", "agent", "register", time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := cwt.Parse(keys, ticket, verify.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ReefID != "reef" || claims.ColonyID != "colony" || claims.AgentID != "agent" || claims.Intent != "register" {
+		t.Fatalf("claims %+v", claims)
+	}
+	if claims.Issuer != jwt.DefaultIssuer || len(claims.Audience) != 1 || claims.Audience[0] != jwt.DefaultAudience {
+		t.Fatalf("issuer %q, audience %v", claims.Issuer, claims.Audience)
+	}
+	if claims.ExpiresAt.Unix() != exp || claims.ID == "" {
+		t.Fatalf("exp %v, jti %q; want exp %d", claims.ExpiresAt, claims.ID, exp)
+	}
+	if ok, err := cwt.Static(ticket, jwks, verify.Options{}); !ok || err != nil {
+		t.Fatalf("Static: %v, %v", ok, err)
+	}
+
+	kid, decoded, err := cwt.Decode(ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "k1" || decoded.ID != claims.ID {
+		t.Fatalf("decoded kid %q, jti %q", kid, decoded.ID)
+	}
+}
+
+func TestRejects(t *testing.T) {
+	jwks, keys, priv := testKeys(t)
+	ticket, _, err := cwt.CreateReferralTicket(priv, "k1", "reef", "colony", "agent", "register", time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped bit in the claims or signature fails the signature.
+	for _, i := range []int{len(ticket) / 2, len(ticket) - 1} {
+		tampered := append([]byte(nil), ticket...)
+		tampered[i] ^= 1
+		if _, err := cwt.Parse(keys, tampered, verify.Options{}); err == nil {
+			t.Errorf("byte %d flipped: verified", i)
+		}
+	}
+	last := append([]byte(nil), ticket...)
+	last[len(last)-1] ^= 1
+	if ok, err := cwt.Static(last, jwks, verify.Options{}); ok || err != nil {
+		t.Errorf("Static with a bad signature: %v, %v; want false, nil", ok, err)
+	}
+
+	_, _, other := testKeys(t)
+	forged, _, err := cwt.CreateReferralTicket(other, "k1", "reef", "colony", "agent", "register", time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cwt.Parse(keys, forged, verify.Options{}); !errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+		t.Errorf("ticket of another key: %v, want ErrTokenSignatureInvalid", err)
+	}
+	unknown, _, err := cwt.CreateReferralTicket(priv, "k2", "reef", "colony", "agent", "register", time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cwt.Parse(keys, unknown, verify.Options{}); !errors.Is(err, gojwt.ErrTokenUnverifiable) {
+		t.Errorf("unknown kid: %v, want ErrTokenUnverifiable", err)
+	}
+	if _, err := cwt.Parse(keys, []byte("not cbor"), verify.Options{}); !errors.Is(err, gojwt.ErrTokenMalformed) {
+		t.Errorf("garbage: %v, want ErrTokenMalformed", err)
+	}
+
+	// The same expectations as for JWTs apply.
+	if _, err := cwt.Parse(keys, ticket, verify.Options{Clock: clock.NewFake(time.Now().Add(2 * time.Hour))}); !errors.Is(err, gojwt.ErrTokenExpired) {
+		t.Errorf("expired: %v, want ErrTokenExpired", err)
+	}
+	if _, err := cwt.Parse(keys, ticket, verify.Options{Issuers: []string{"elsewhere"}}); !errors.Is(err, verify.ErrIssuer) {
+		t.Errorf("other issuer: %v, want ErrIssuer", err)
+	}
+	if _, err := cwt.Parse(keys, ticket, verify.Options{Algorithms: []string{"ES256"}}); !errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+		t.Errorf("EdDSA not allowed: %v, want ErrTokenSignatureInvalid", err)
+	}
+}
@@ -0,0 +1,103 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/cwt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// createReferralCWT creates a referral ticket in the compact CWT
+// encoding (see package cwt), returned as unpadded base64url.
+// Arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds
+// Returns: { cwt: string, expiresAt: number } or { error: { code, message, retryable } }
+func createReferralCWT(this js.Value, args []js.Value) interface{} {
+	if len(args) < 7 {
+		return errorResult(errInvalidArgument, "expected 7 arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds")
+	}
+
+	privateKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	reefID, colonyID, agentID, intent := args[2].String(), args[3].String(), args[4].String(), args[5].String()
+
+	ticket, expiresAt, err := cwt.CreateReferralTicket(privateKey, args[1].String(),
+		reefID, colonyID, agentID, intent,
+		time.Duration(args[6].Int())*time.Second, "", "")
+	if err != nil {
+		return errorResult(errTokenSign, "failed to create token: "+err.Error())
+	}
+
+	return recordIssued(map[string]interface{}{
+		"cwt":       base64.RawURLEncoding.EncodeToString(ticket),
+		"expiresAt": expiresAt,
+	}, reefID, colonyID, agentID, intent)
+}
+
+// verifyCWT verifies a base64url CWT ticket like verifySignature does a
+// JWT: against jwksJSON or, when omitted, the loadJWKS cache, with the
+// same options, revocation snapshot and policy.
+// Arguments: cwtB64, [jwksJSON], [options]
+// Returns: { valid: boolean } or { error: { code, message, retryable } }
+func verifyCWT(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected at least 1 argument: cwtB64, [jwksJSON], [options]")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(args[0].String())
+	if err != nil {
+		return errorResult(errTokenMalformed, "failed to decode CWT: "+err.Error())
+	}
+
+	var opts verify.Options
+	if len(args) > 2 {
+		o, err := verifyOptionsFromJS(args[2])
+		if err != nil {
+			return errorResult(errInvalidArgument, err.Error())
+		}
+		if o != nil {
+			opts = *o
+		}
+	}
+
+	var source verify.KeySource
+	if len(args) < 2 || args[1].IsUndefined() || args[1].IsNull() {
+		kid, _, err := cwt.Decode(data)
+		if err != nil {
+			return errorResult(errTokenMalformed, err.Error())
+		}
+		if source, err = keyCache.validator(kid); err != nil {
+			return errorResult(errKidUnknown, err.Error())
+		}
+	} else {
+		if source, err = verify.ParseKeys([]byte(args[1].String())); err != nil {
+			return errorResult(errJWKSMalformed, err.Error())
+		}
+	}
+
+	claims, err := cwt.Parse(source, data, opts)
+	switch {
+	case errors.Is(err, gojwt.ErrTokenSignatureInvalid):
+		return map[string]interface{}{"valid": false}
+	case errors.Is(err, verify.ErrIssuer), errors.Is(err, verify.ErrAudience), errors.Is(err, verify.ErrLifetime):
+		return errorResult(errTokenClaims, err.Error())
+	case err != nil:
+		return classifyTokenError(err).result()
+	}
+	if isRevoked(claims.ReefID, claims.ID) {
+		return errorResult(errTokenRevoked, "ticket "+claims.ID+" has been revoked")
+	}
+	if perr := checkPolicy(claims); perr != nil {
+		return perr.result()
+	}
+	return map[string]interface{}{"valid": true}
+}
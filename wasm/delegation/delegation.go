@@ -0,0 +1,305 @@
+// Package delegation implements delegated referral chains. A ticket that
+// names a delegation key (dk) lets its holder mint child tickets for other
+// agents, signed with that key and embedding the parent ticket. Verifying
+// a child walks the chain back to a root ticket signed by discovery,
+// checking at every hop that the child narrows its parent.
+//
+// A child is narrower than its parent when it has the same reef and
+// colony, expires no later, carries no role its parent lacks, and its
+// intent equals the parent's intent or extends it with a ":"-separated
+// suffix ("onboard" → "onboard:join").
+package delegation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// DelegatedKeyID is the kid header of tickets signed with a delegation key
+// rather than a discovery key.
+const DelegatedKeyID = "delegated"
+
+// DefaultMaxDepth is the number of delegation hops allowed below a root
+// ticket when Config.MaxDepth is zero.
+const DefaultMaxDepth = 2
+
+var (
+	// ErrChainTooDeep is returned when a chain exceeds the depth limit.
+	ErrChainTooDeep = errors.New("delegation chain too deep")
+
+	// ErrNotDelegable is returned when a parent ticket has no delegation key.
+	ErrNotDelegable = errors.New("parent ticket is not delegable")
+
+	// ErrNotNarrower is returned when a child widens its parent's scope.
+	ErrNotNarrower = errors.New("delegated ticket widens its parent")
+
+	// ErrRevoked is returned when any ticket in the chain is revoked.
+	ErrRevoked = errors.New("ticket in delegation chain revoked")
+)
+
+// Claims are referral claims extended with delegation members.
+type Claims struct {
+	jwt.ReferralClaims
+
+	// Parent is the full parent ticket; empty on root tickets.
+	Parent string `json:"parent,omitempty"`
+
+	// DelegationKey is the base64 Ed25519 public key allowed to sign
+	// children of this ticket. Empty means the ticket cannot be delegated.
+	DelegationKey string `json:"dk,omitempty"`
+
+	// Roles are the admin roles of the ticket (see package rbac). A
+	// child's roles must be a subset of its parent's.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Verifier validates root tickets. *jwt.Validator and *jwks.Set satisfy it.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Sign signs claims with key under kid. Discovery uses it to mint
+// delegable root tickets by setting DelegationKey.
+func Sign(key ed25519.PrivateKey, kid string, claims *Claims) (string, error) {
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// Child describes a ticket delegated from a parent.
+type Child struct {
+	AgentID string
+	Intent  string
+	TTL     time.Duration
+
+	// Roles optionally passes on some of the parent's roles.
+	Roles []string
+
+	// DelegationKey optionally lets the child delegate further.
+	DelegationKey ed25519.PublicKey
+}
+
+// Delegate mints a child of parent signed with holderKey, which must match
+// the parent's delegation key. The child's expiry is capped at the
+// parent's. The parent is not verified; Delegate only checks that the
+// result would pass the narrowing rules.
+func Delegate(parent string, holderKey ed25519.PrivateKey, child Child) (string, error) {
+	pc, err := unverified(parent)
+	if err != nil {
+		return "", err
+	}
+	if pc.DelegationKey == "" {
+		return "", ErrNotDelegable
+	}
+	if pc.DelegationKey != encodeKey(holderKey.Public().(ed25519.PublicKey)) {
+		return "", errors.New("holder key does not match parent delegation key")
+	}
+	if !narrows(pc.Intent, child.Intent) {
+		return "", fmt.Errorf("%w: intent %q is not within %q", ErrNotNarrower, child.Intent, pc.Intent)
+	}
+	if role, ok := extraRole(pc.Roles, child.Roles); ok {
+		return "", fmt.Errorf("%w: role %q is not held by the parent", ErrNotNarrower, role)
+	}
+
+	now := time.Now()
+	exp := now.Add(child.TTL)
+	if pc.ExpiresAt != nil && exp.After(pc.ExpiresAt.Time) {
+		exp = pc.ExpiresAt.Time
+	}
+
+	claims := &Claims{
+		ReferralClaims: jwt.ReferralClaims{
+			ReefID:   pc.ReefID,
+			ColonyID: pc.ColonyID,
+			AgentID:  child.AgentID,
+			Intent:   child.Intent,
+			RegisteredClaims: gojwt.RegisteredClaims{
+				ID:        uuid.New().String(),
+				Issuer:    pc.AgentID,
+				Audience:  pc.Audience,
+				IssuedAt:  gojwt.NewNumericDate(now),
+				NotBefore: gojwt.NewNumericDate(now),
+				ExpiresAt: gojwt.NewNumericDate(exp),
+			},
+		},
+		Parent: parent,
+		Roles:  child.Roles,
+	}
+	if child.DelegationKey != nil {
+		claims.DelegationKey = encodeKey(child.DelegationKey)
+	}
+	return Sign(holderKey, DelegatedKeyID, claims)
+}
+
+// Config holds the configuration for a ChainVerifier.
+type Config struct {
+	// Root validates tickets signed by discovery. Required.
+	Root Verifier
+
+	// MaxDepth bounds the hops below the root. Defaults to
+	// DefaultMaxDepth; negative disables delegation.
+	MaxDepth int
+
+	// IsRevoked, when set, is consulted for every jti in the chain,
+	// with the reef of its ticket.
+	IsRevoked func(ctx context.Context, reefID, jti string) (bool, error)
+}
+
+// ChainVerifier validates root and delegated tickets. It satisfies
+// registry.Verifier, returning the leaf ticket's claims.
+type ChainVerifier struct {
+	root      Verifier
+	maxDepth  int
+	isRevoked func(ctx context.Context, reefID, jti string) (bool, error)
+}
+
+// New creates a ChainVerifier from cfg.
+func New(cfg Config) (*ChainVerifier, error) {
+	if cfg.Root == nil {
+		return nil, errors.New("root verifier is required")
+	}
+	depth := cfg.MaxDepth
+	switch {
+	case depth == 0:
+		depth = DefaultMaxDepth
+	case depth < 0:
+		depth = 0
+	}
+	return &ChainVerifier{root: cfg.Root, maxDepth: depth, isRevoked: cfg.IsRevoked}, nil
+}
+
+// ValidateReferralTicket implements registry.Verifier.
+func (v *ChainVerifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	chain, err := v.Verify(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &chain[0].ReferralClaims, nil
+}
+
+// Verify validates tokenString and returns its chain, leaf first and root
+// last.
+func (v *ChainVerifier) Verify(tokenString string) ([]*Claims, error) {
+	chain, err := v.verify(tokenString, 0)
+	if err != nil {
+		return nil, err
+	}
+	if v.isRevoked != nil {
+		for _, c := range chain {
+			revoked, err := v.isRevoked(context.Background(), c.ReefID, c.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check revocation: %w", err)
+			}
+			if revoked {
+				return nil, fmt.Errorf("%w: %s", ErrRevoked, c.ID)
+			}
+		}
+	}
+	return chain, nil
+}
+
+func (v *ChainVerifier) verify(tokenString string, depth int) ([]*Claims, error) {
+	claims, err := unverified(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Parent == "" {
+		// Root ticket: the root verifier checks signature, issuer and
+		// audience; the unverified decode above supplies dk.
+		if _, err := v.root.ValidateReferralTicket(tokenString); err != nil {
+			return nil, err
+		}
+		return []*Claims{claims}, nil
+	}
+
+	if depth >= v.maxDepth {
+		return nil, fmt.Errorf("%w: limit %d", ErrChainTooDeep, v.maxDepth)
+	}
+	parents, err := v.verify(claims.Parent, depth+1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent ticket: %w", err)
+	}
+	parent := parents[0]
+	if parent.DelegationKey == "" {
+		return nil, ErrNotDelegable
+	}
+	key, err := base64.StdEncoding.DecodeString(parent.DelegationKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("parent ticket has a malformed delegation key")
+	}
+
+	verified := &Claims{}
+	_, err = gojwt.ParseWithClaims(tokenString, verified, func(t *gojwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*gojwt.SigningMethodEd25519); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v (expected EdDSA)", t.Header["alg"])
+		}
+		return ed25519.PublicKey(key), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	if err := checkNarrows(parent, verified); err != nil {
+		return nil, err
+	}
+	return append([]*Claims{verified}, parents...), nil
+}
+
+// checkNarrows enforces the narrowing rules between parent and child.
+func checkNarrows(parent, child *Claims) error {
+	if child.ReefID != parent.ReefID || child.ColonyID != parent.ColonyID {
+		return fmt.Errorf("%w: reef or colony differs from parent", ErrNotNarrower)
+	}
+	if !narrows(parent.Intent, child.Intent) {
+		return fmt.Errorf("%w: intent %q is not within %q", ErrNotNarrower, child.Intent, parent.Intent)
+	}
+	if parent.ExpiresAt != nil && (child.ExpiresAt == nil || child.ExpiresAt.After(parent.ExpiresAt.Time)) {
+		return fmt.Errorf("%w: expires after parent", ErrNotNarrower)
+	}
+	if role, ok := extraRole(parent.Roles, child.Roles); ok {
+		return fmt.Errorf("%w: role %q is not held by the parent", ErrNotNarrower, role)
+	}
+	return nil
+}
+
+// extraRole returns a role of child that parent lacks.
+func extraRole(parent, child []string) (string, bool) {
+	for _, role := range child {
+		if !slices.Contains(parent, role) {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// narrows reports whether child is parent or a ":"-suffixed refinement.
+func narrows(parent, child string) bool {
+	return child == parent || strings.HasPrefix(child, parent+":")
+}
+
+// unverified decodes claims without checking the signature.
+func unverified(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if _, _, err := gojwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	return claims, nil
+}
+
+func encodeKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
@@ -0,0 +1,233 @@
+package delegation_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+)
+
+// rootKey verifies root tickets signed with its private key.
+type rootKey ed25519.PublicKey
+
+func (k rootKey) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims := &jwt.ReferralClaims{}
+	if _, err := gojwt.ParseWithClaims(tokenString, claims, func(*gojwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(k), nil
+	}); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// claims returns claims of agent in reef/colony with intent, expiring in
+// ttl.
+func claims(agent, intent string, ttl time.Duration) delegation.Claims {
+	now := time.Now()
+	return delegation.Claims{ReferralClaims: jwt.ReferralClaims{
+		ReefID: "reef", ColonyID: "colony", AgentID: agent, Intent: intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        agent + "-" + intent,
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(ttl)),
+		},
+	}}
+}
+
+// chain returns a verifier and a delegable root ticket of "operator" with
+// the onboard intent and roles, delegable by holder.
+func chain(t *testing.T, cfg delegation.Config, roles ...string) (*delegation.ChainVerifier, string, ed25519.PrivateKey) {
+	t.Helper()
+	rootPub, rootPriv := newKey(t)
+	holderPub, holderPriv := newKey(t)
+	c := claims("operator", "onboard", time.Hour)
+	c.Roles = roles
+	c.DelegationKey = base64.StdEncoding.EncodeToString(holderPub)
+	root, err := delegation.Sign(rootPriv, "k1", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Root = rootKey(rootPub)
+	v, err := delegation.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, root, holderPriv
+}
+
+func TestDelegate(t *testing.T) {
+	v, root, holder := chain(t, delegation.Config{})
+	child, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "onboard:join", TTL: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := v.Verify(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].AgentID != "agent" || got[0].Intent != "onboard:join" || got[1].AgentID != "operator" {
+		t.Fatalf("chain %+v", got)
+	}
+	if got[0].ReefID != "reef" || got[0].ColonyID != "colony" {
+		t.Fatalf("child in reef %s, colony %s", got[0].ReefID, got[0].ColonyID)
+	}
+
+	// Delegate caps the child's expiry at its parent's.
+	long, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "onboard", TTL: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = v.Verify(long); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].ExpiresAt.After(got[1].ExpiresAt.Time) {
+		t.Fatalf("child expires at %v, after its parent at %v", got[0].ExpiresAt, got[1].ExpiresAt)
+	}
+
+	// Only the holder of the parent's delegation key may delegate.
+	_, other := newKey(t)
+	if _, err := delegation.Delegate(root, other, delegation.Child{AgentID: "agent", Intent: "onboard"}); err == nil {
+		t.Fatal("delegated with a key the parent does not name")
+	}
+	if _, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "register", TTL: time.Minute}); !errors.Is(err, delegation.ErrNotNarrower) {
+		t.Fatalf("delegated a wider intent: %v, want ErrNotNarrower", err)
+	}
+}
+
+// TestNarrowing signs children that widen their parent directly, as
+// Delegate refuses to mint them, and checks that verification rejects
+// each.
+func TestNarrowing(t *testing.T) {
+	v, root, holder := chain(t, delegation.Config{})
+	for name, widen := range map[string]func(*delegation.Claims){
+		"intent":    func(c *delegation.Claims) { c.Intent = "register" },
+		"prefix":    func(c *delegation.Claims) { c.Intent = "onboarding" },
+		"colony":    func(c *delegation.Claims) { c.ColonyID = "other" },
+		"reef":      func(c *delegation.Claims) { c.ReefID = "other" },
+		"expiry":    func(c *delegation.Claims) { c.ExpiresAt = gojwt.NewNumericDate(time.Now().Add(2 * time.Hour)) },
+		"no expiry": func(c *delegation.Claims) { c.ExpiresAt = nil },
+	} {
+		c := claims("agent", "onboard:join", time.Minute)
+		c.Parent = root
+		widen(&c)
+		child, err := delegation.Sign(holder, delegation.DelegatedKeyID, &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Verify(child); !errors.Is(err, delegation.ErrNotNarrower) {
+			t.Errorf("%s: %v, want ErrNotNarrower", name, err)
+		}
+	}
+
+	// A child signed by anyone but the delegation key is refused.
+	_, other := newKey(t)
+	c := claims("agent", "onboard", time.Minute)
+	c.Parent = root
+	forged, err := delegation.Sign(other, delegation.DelegatedKeyID, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(forged); err == nil {
+		t.Fatal("verified a child signed with another key")
+	}
+}
+
+func TestChainLimits(t *testing.T) {
+	revoked := map[string]bool{}
+	v, root, holder := chain(t, delegation.Config{
+		MaxDepth: 1,
+		IsRevoked: func(_ context.Context, reefID, jti string) (bool, error) {
+			return revoked[reefID+"/"+jti], nil
+		},
+	})
+	nextPub, nextPriv := newKey(t)
+	child, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "onboard", TTL: time.Minute, DelegationKey: nextPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grandchild, err := delegation.Delegate(child, nextPriv, delegation.Child{AgentID: "leaf", Intent: "onboard", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(grandchild); !errors.Is(err, delegation.ErrChainTooDeep) {
+		t.Fatalf("chain of two hops with limit 1: %v, want ErrChainTooDeep", err)
+	}
+
+	// A child of a ticket without a delegation key is refused.
+	leaf, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "leaf", Intent: "onboard", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := delegation.Delegate(leaf, holder, delegation.Child{AgentID: "x", Intent: "onboard"}); !errors.Is(err, delegation.ErrNotDelegable) {
+		t.Fatalf("delegated from a leaf: %v, want ErrNotDelegable", err)
+	}
+
+	// Revoking the root revokes every ticket below it.
+	if _, err := v.Verify(leaf); err != nil {
+		t.Fatal(err)
+	}
+	revoked["reef/operator-onboard"] = true
+	if _, err := v.Verify(leaf); !errors.Is(err, delegation.ErrRevoked) {
+		t.Fatalf("child of a revoked root: %v, want ErrRevoked", err)
+	}
+}
+
+// TestRoleEscalation checks that a delegate cannot grant its child admin
+// roles the root ticket does not carry: the principal of a ticket takes
+// its roles from the leaf.
+func TestRoleEscalation(t *testing.T) {
+	v, root, holder := chain(t, delegation.Config{}, rbac.RoleOperator)
+	for name, roles := range map[string][]string{
+		"admin":              {rbac.RoleAdmin},
+		"operator and admin": {rbac.RoleOperator, rbac.RoleAdmin},
+	} {
+		if _, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "onboard", TTL: time.Minute, Roles: roles}); !errors.Is(err, delegation.ErrNotNarrower) {
+			t.Errorf("%s: Delegate: %v, want ErrNotNarrower", name, err)
+		}
+		c := claims("agent", "onboard", time.Minute)
+		c.Parent = root
+		c.Roles = roles
+		child, err := delegation.Sign(holder, delegation.DelegatedKeyID, &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Ticket(v, child); !errors.Is(err, delegation.ErrNotNarrower) {
+			t.Errorf("%s: %v, want ErrNotNarrower", name, err)
+		}
+	}
+
+	// Roles the root carries may be passed on, or dropped.
+	for _, roles := range [][]string{{rbac.RoleOperator}, nil} {
+		child, err := delegation.Delegate(root, holder, delegation.Child{AgentID: "agent", Intent: "onboard", TTL: time.Minute, Roles: roles})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := auth.Ticket(v, child)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(p.Roles, roles) {
+			t.Errorf("principal has roles %v, want %v", p.Roles, roles)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+)
+
+// delegateTicket mints a child ticket from a delegable parent.
+// Arguments: parentToken, holderPrivateKeyB64, agentID, intent, ttlSeconds, [childDelegationKeyB64]
+// Returns: { jwt: string } or { error: { code, message, retryable } }
+func delegateTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 5 {
+		return errorResult(errInvalidArgument, "expected 5 arguments: parentToken, holderPrivateKeyB64, agentID, intent, ttlSeconds")
+	}
+
+	holder, err := keys.DecodePrivateKey(args[1].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	child := delegation.Child{
+		AgentID: args[2].String(),
+		Intent:  args[3].String(),
+		TTL:     time.Duration(args[4].Int()) * time.Second,
+	}
+	if len(args) > 5 && !args[5].IsUndefined() && !args[5].IsNull() {
+		if child.DelegationKey, err = keys.DecodePublicKey(args[5].String()); err != nil {
+			return errorResult(errKeyDecode, "failed to decode delegation key: "+err.Error())
+		}
+	}
+
+	token, err := delegation.Delegate(args[0].String(), holder, child)
+	if err != nil {
+		if errors.Is(err, delegation.ErrNotDelegable) || errors.Is(err, delegation.ErrNotNarrower) {
+			return errorResult(errDelegation, err.Error())
+		}
+		return errorResult(errTokenSign, err.Error())
+	}
+	return map[string]interface{}{
+		"jwt": token,
+	}
+}
+
+// verifyDelegatedTicket verifies a root or delegated ticket, walking the
+// chain back to a root signed by a key in jwksJSON or the loadJWKS cache.
+// Every ticket in the chain is checked against the loadRevocations
+// snapshot and the leaf against the loadPolicy policy.
+// Arguments: tokenString, [jwksJSON], [maxDepth]
+// Returns: { valid: true, depth, agentID, intent } or { error: { code, message, retryable } }
+func verifyDelegatedTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected at least 1 argument: tokenString, [jwksJSON], [maxDepth]")
+	}
+
+	var root delegation.Verifier = keyCache
+	if len(args) > 1 && !args[1].IsUndefined() && !args[1].IsNull() {
+		validator, err := jwt.NewValidatorFromJSON(args[1].String())
+		if err != nil {
+			return errorResult(errJWKSMalformed, err.Error())
+		}
+		root = validator
+	}
+	cfg := delegation.Config{
+		Root: root,
+		IsRevoked: func(_ context.Context, reefID, jti string) (bool, error) {
+			return isRevoked(reefID, jti), nil
+		},
+	}
+	if len(args) > 2 && args[2].Type() == js.TypeNumber {
+		cfg.MaxDepth = args[2].Int()
+	}
+
+	verifier, err := delegation.New(cfg)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	chain, err := verifier.Verify(args[0].String())
+	if err != nil {
+		return delegationError(err).result()
+	}
+
+	leaf := chain[0]
+	if perr := checkPolicy(&leaf.ReferralClaims); perr != nil {
+		return perr.result()
+	}
+	return map[string]interface{}{
+		"valid":   true,
+		"depth":   len(chain) - 1,
+		"agentID": leaf.AgentID,
+		"intent":  leaf.Intent,
+	}
+}
+
+// delegationError maps a chain verification failure to an exportError.
+func delegationError(err error) *exportError {
+	switch {
+	case errors.Is(err, delegation.ErrRevoked):
+		return newError(errTokenRevoked, err.Error())
+	case errors.Is(err, delegation.ErrChainTooDeep),
+		errors.Is(err, delegation.ErrNotDelegable),
+		errors.Is(err, delegation.ErrNotNarrower):
+		return newError(errDelegation, err.Error())
+	default:
+		return classifyTokenError(err)
+	}
+}
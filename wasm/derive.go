@@ -0,0 +1,45 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/hdkey"
+)
+
+// deriveKey derives the key at path (e.g. "agent:" + agentID) below a
+// colony master key. The JWK records the master ID and path.
+// Arguments: masterPrivateKeyB64, masterKeyID, path
+// Returns: { id, privateKey, publicKey, jwk } or { error: { code, message, retryable } }
+func deriveKey(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: masterPrivateKeyB64, masterKeyID, path")
+	}
+
+	masterKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode master key: "+err.Error())
+	}
+	masterID := args[1].String()
+	path := args[2].String()
+
+	kp, err := hdkey.DeriveKeyPair(&keys.KeyPair{ID: masterID, Algorithm: "EdDSA", PrivateKey: masterKey}, path)
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	jwkJSON, err := json.Marshal(hdkey.ToJWK(kp, masterID, path))
+	if err != nil {
+		return errorResult(errInternal, "failed to marshal JWK: "+err.Error())
+	}
+
+	return map[string]interface{}{
+		"id":         kp.ID,
+		"privateKey": keys.EncodePrivateKey(kp.PrivateKey),
+		"publicKey":  keys.EncodePublicKey(kp.PublicKey),
+		"jwk":        string(jwkJSON),
+	}
+}
@@ -0,0 +1,101 @@
+//go:build tinygo.wasm || js
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/webcrypto"
+)
+
+// signDetached signs an arbitrary payload with an Ed25519 private key.
+// Arguments: privateKeyB64, payloadBytes (Uint8Array)
+// Returns: { signature: string } or { error: { code, message, retryable } }
+func signDetached(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: privateKeyB64, payloadBytes")
+	}
+
+	privateKey, err := decodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+
+	payload, err := bytesFromJS(args[1])
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	signature := webcrypto.Sign(privateKey, payload)
+
+	return map[string]interface{}{
+		"signature": base64.StdEncoding.EncodeToString(signature),
+	}
+}
+
+// verifyDetached verifies a detached Ed25519 signature over a payload.
+// Arguments: publicKeyB64, payloadBytes (Uint8Array), sigB64
+// Returns: { valid: boolean } or { error: { code, message, retryable } }
+func verifyDetached(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: publicKeyB64, payloadBytes, sigB64")
+	}
+
+	publicKey, err := decodePublicKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode public key: "+err.Error())
+	}
+
+	payload, err := bytesFromJS(args[1])
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(args[2].String())
+	if err != nil {
+		return errorResult(errInvalidArgument, "failed to decode signature: "+err.Error())
+	}
+
+	return map[string]interface{}{
+		"valid": len(signature) == ed25519.SignatureSize && webcrypto.Verify(publicKey, payload, signature),
+	}
+}
+
+// decodePrivateKey decodes a standard base64 Ed25519 private key, the
+// counterpart of keys.EncodePrivateKey.
+func decodePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: got %d, want %d", len(data), ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(data), nil
+}
+
+// decodePublicKey decodes a standard base64 Ed25519 public key, the
+// counterpart of keys.EncodePublicKey.
+func decodePublicKey(encoded string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size: got %d, want %d", len(data), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// bytesFromJS copies a Uint8Array into a Go byte slice.
+func bytesFromJS(v js.Value) ([]byte, error) {
+	if !v.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, fmt.Errorf("payloadBytes must be a Uint8Array")
+	}
+	buf := make([]byte, v.Length())
+	js.CopyBytesToGo(buf, v)
+	return buf, nil
+}
@@ -0,0 +1,84 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+)
+
+// ID is a 256-bit node ID or record key. Distances are XOR.
+type ID [32]byte
+
+// NodeID is the ID of the node holding key pub.
+func NodeID(pub ed25519.PublicKey) ID {
+	return sha256.Sum256(pub)
+}
+
+// AgentKey is the key an agent's record is stored under.
+func AgentKey(agentID string) ID {
+	return sha256.Sum256([]byte("coral-dht-agent:" + agentID))
+}
+
+// ColonyKey is the key a colony's member records are stored under.
+func ColonyKey(colonyID string) ID {
+	return sha256.Sum256([]byte("coral-dht-colony:" + colonyID))
+}
+
+// ParseID decodes a hex ID.
+func ParseID(s string) (ID, error) {
+	var id ID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid DHT ID %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *ID) UnmarshalText(text []byte) error {
+	parsed, err := ParseID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// xor returns the distance between a and b.
+func xor(a, b ID) ID {
+	var d ID
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// closer reports whether a is closer to target than b.
+func closer(target, a, b ID) bool {
+	da, db := xor(target, a), xor(target, b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// commonPrefix returns the number of leading bits a and b share, 256 when
+// equal.
+func commonPrefix(a, b ID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
@@ -0,0 +1,523 @@
+// Package dht lets agents find each other peer to peer when the
+// discovery server is unreachable. Nodes form a Kademlia network over
+// UDP; an agent's registry record is stored on the nodes closest to the
+// hash of its agent ID (AgentKey) and of its colony ID (ColonyKey), so
+// peers can look it up or list the colony without a central registry.
+//
+// Records are signed by their publisher with an Ed25519 key from the
+// keys package and checked on every hop, so relays cannot alter them.
+// A valid signature says nothing about whether the publisher may speak
+// for the agent, though: without Config.Trust anyone can publish a
+// record for any agent ID. Node IDs and routing messages are not
+// authenticated either, so treat lookup results as hints and
+// authenticate the peer when connecting.
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Defaults used when the Config leaves a field zero.
+const (
+	DefaultK         = 20
+	DefaultAlpha     = 3
+	DefaultRecordTTL = time.Hour
+)
+
+// maxSlots caps the publishers' records a node keeps per key.
+const maxSlots = 256
+
+// maintenanceInterval is how often Run expires records and refreshes
+// stale buckets.
+const maintenanceInterval = time.Minute
+
+// refreshAfter is how long a bucket may go unheard before Run looks up
+// its contacts again.
+const refreshAfter = 15 * time.Minute
+
+// ErrNotFound is returned when no trusted record is found.
+var ErrNotFound = errors.New("dht: agent not found")
+
+// Config holds the configuration for a Node.
+type Config struct {
+	// Addr is the UDP address to listen on. Defaults to ":0".
+	Addr string
+
+	// Key determines the node ID and signs records published without a
+	// key of their own. Generated when nil.
+	Key *keys.KeyPair
+
+	// Bootstrap lists host:port addresses of known nodes for Join.
+	Bootstrap []string
+
+	// K is the bucket size and replication factor. Defaults to
+	// DefaultK.
+	K int
+
+	// Alpha is the number of parallel requests per lookup step.
+	// Defaults to DefaultAlpha.
+	Alpha int
+
+	// RecordTTL is the lifetime of published records. Run republishes
+	// them every half TTL. Defaults to DefaultRecordTTL.
+	RecordTTL time.Duration
+
+	// Trust reports whether pub may publish rec. The node neither stores
+	// nor returns records it rejects. Nil accepts every validly signed
+	// record.
+	Trust func(rec *registry.Record, pub ed25519.PublicKey) bool
+}
+
+// published is a record this node keeps alive.
+type published struct {
+	rec      *registry.Record
+	key      *keys.KeyPair
+	signedAt time.Time
+}
+
+// Node is a DHT node.
+type Node struct {
+	id        ID
+	key       *keys.KeyPair
+	conn      *net.UDPConn
+	table     *table
+	k         int
+	alpha     int
+	ttl       time.Duration
+	trust     func(*registry.Record, ed25519.PublicKey) bool
+	bootstrap []string
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	nextRPC uint64
+	pending map[uint64]chan *message
+
+	storeMu sync.Mutex
+	stored  map[ID]map[string]*verified
+
+	pubMu     sync.Mutex
+	published map[string]*published
+}
+
+// New creates a Node listening on cfg.Addr. It answers peers right away;
+// call Join to enter the network and Run to keep records alive.
+func New(cfg Config) (*Node, error) {
+	if cfg.Key == nil {
+		kp, err := keys.GenerateKeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("dht: failed to generate node key: %w", err)
+		}
+		cfg.Key = kp
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ":0"
+	}
+	if cfg.K <= 0 {
+		cfg.K = DefaultK
+	}
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = DefaultAlpha
+	}
+	if cfg.RecordTTL <= 0 {
+		cfg.RecordTTL = DefaultRecordTTL
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dht: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dht: failed to listen: %w", err)
+	}
+
+	id := NodeID(cfg.Key.PublicKey)
+	n := &Node{
+		id:        id,
+		key:       cfg.Key,
+		conn:      conn,
+		table:     newTable(id, cfg.K),
+		k:         cfg.K,
+		alpha:     cfg.Alpha,
+		ttl:       cfg.RecordTTL,
+		trust:     cfg.Trust,
+		bootstrap: cfg.Bootstrap,
+		done:      make(chan struct{}),
+		pending:   make(map[uint64]chan *message),
+		stored:    make(map[ID]map[string]*verified),
+		published: make(map[string]*published),
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+// ID returns the node's ID.
+func (n *Node) ID() ID {
+	return n.id
+}
+
+// Addr returns the address the node listens on.
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Peers returns the number of nodes in the routing table.
+func (n *Node) Peers() int {
+	return n.table.size()
+}
+
+// Close stops the node. Published records stay in the network until
+// they expire.
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.conn.Close()
+	})
+	return err
+}
+
+// Join pings the bootstrap nodes and looks up the node's own ID to fill
+// the routing table. It fails only if no bootstrap node answers.
+func (n *Node) Join(ctx context.Context) error {
+	if len(n.bootstrap) == 0 {
+		return nil
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(n.bootstrap))
+	for i, addr := range n.bootstrap {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			_, errs[i] = n.ping(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+	if n.table.size() == 0 {
+		return fmt.Errorf("dht: no bootstrap node answered: %w", errors.Join(errs...))
+	}
+	n.lookup(ctx, n.id, false)
+	return nil
+}
+
+// Run republishes records and expires stale state until ctx is done.
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		n.expire(time.Now())
+		n.republish(ctx)
+		for _, c := range n.table.stale(time.Now().Add(-refreshAfter)) {
+			n.lookup(ctx, c.ID, false)
+		}
+	}
+}
+
+// Publish signs rec with kp, or the node key when kp is nil, and stores
+// it on the nodes closest to its agent and colony keys. Run republishes
+// it until the node is closed or Unpublish is called. With no peers the
+// record is only held locally.
+func (n *Node) Publish(ctx context.Context, rec *registry.Record, kp *keys.KeyPair) error {
+	if kp == nil {
+		kp = n.key
+	}
+	p := &published{rec: rec.Clone(), key: kp}
+	if p.rec.CreatedAt.IsZero() {
+		p.rec.CreatedAt = time.Now().UTC()
+	}
+	if err := n.push(ctx, p); err != nil {
+		return err
+	}
+	n.pubMu.Lock()
+	n.published[rec.AgentID] = p
+	n.pubMu.Unlock()
+	return nil
+}
+
+// Unpublish stops republishing agentID's record. Copies already stored
+// elsewhere expire after RecordTTL.
+func (n *Node) Unpublish(agentID string) {
+	n.pubMu.Lock()
+	delete(n.published, agentID)
+	n.pubMu.Unlock()
+}
+
+// push signs p afresh and stores it.
+func (n *Node) push(ctx context.Context, p *published) error {
+	sr, err := Sign(p.key, p.rec, n.ttl)
+	if err != nil {
+		return err
+	}
+	p.signedAt = time.Now()
+
+	var wg sync.WaitGroup
+	for _, key := range []ID{AgentKey(p.rec.AgentID), ColonyKey(p.rec.ColonyID)} {
+		if err := n.store(key, sr)[0]; err != nil {
+			return err
+		}
+		contacts, _ := n.lookup(ctx, key, false)
+		for _, c := range contacts {
+			wg.Add(1)
+			go func(key ID, c Contact) {
+				defer wg.Done()
+				n.call(ctx, c.Addr, &message{Type: msgStore, Target: key, Records: []*SignedRecord{sr}})
+			}(key, c)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// republish pushes published records older than half their TTL.
+func (n *Node) republish(ctx context.Context) {
+	n.pubMu.Lock()
+	var due []*published
+	for _, p := range n.published {
+		if time.Since(p.signedAt) >= n.ttl/2 {
+			due = append(due, p)
+		}
+	}
+	n.pubMu.Unlock()
+	for _, p := range due {
+		n.push(ctx, p)
+	}
+}
+
+// Lookup returns the newest trusted record for agentID.
+func (n *Node) Lookup(ctx context.Context, agentID string) (*registry.Record, error) {
+	_, found := n.lookup(ctx, AgentKey(agentID), true)
+	var best *verified
+	for _, v := range found {
+		if v.Record.AgentID == agentID && (best == nil || v.Seq > best.Seq) {
+			best = v
+		}
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+	return best.Record.Clone(), nil
+}
+
+// List returns the newest trusted record of each agent in colonyID,
+// sorted by agent ID.
+func (n *Node) List(ctx context.Context, colonyID string) ([]*registry.Record, error) {
+	_, found := n.lookup(ctx, ColonyKey(colonyID), true)
+	newest := make(map[string]*verified)
+	for _, v := range found {
+		if v.Record.ColonyID != colonyID {
+			continue
+		}
+		if cur, ok := newest[v.Record.AgentID]; !ok || v.Seq > cur.Seq {
+			newest[v.Record.AgentID] = v
+		}
+	}
+	out := make([]*registry.Record, 0, len(newest))
+	for _, v := range newest {
+		out = append(out, v.Record.Clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
+	return out, nil
+}
+
+// lookup iteratively queries the nodes closest to target, alpha at a
+// time, until the k closest have all answered. It returns those k
+// contacts and, when findValue is set, every trusted record any of them
+// held under target, including the node's own.
+func (n *Node) lookup(ctx context.Context, target ID, findValue bool) ([]Contact, []*verified) {
+	type candidate struct {
+		Contact
+		queried, answered bool
+	}
+	var shortlist []*candidate
+	known := map[ID]bool{n.id: true}
+	add := func(cs []Contact) {
+		for _, c := range cs {
+			if !known[c.ID] {
+				known[c.ID] = true
+				shortlist = append(shortlist, &candidate{Contact: c})
+			}
+		}
+		sort.Slice(shortlist, func(i, j int) bool { return closer(target, shortlist[i].ID, shortlist[j].ID) })
+	}
+	add(n.table.closest(target, n.k))
+
+	records := make(map[string]*verified)
+	keep := func(v *verified) {
+		if cur, ok := records[v.slot()]; !ok || v.Seq > cur.Seq {
+			records[v.slot()] = v
+		}
+	}
+	if findValue {
+		n.storeMu.Lock()
+		for _, v := range n.stored[target] {
+			keep(v)
+		}
+		n.storeMu.Unlock()
+	}
+
+	typ := msgFindNode
+	if findValue {
+		typ = msgFindValue
+	}
+	type result struct {
+		c     *candidate
+		reply *message
+	}
+	for ctx.Err() == nil {
+		var batch []*candidate
+		seen := 0
+		for _, c := range shortlist {
+			if seen == n.k || len(batch) == n.alpha {
+				break
+			}
+			if !c.queried {
+				batch = append(batch, c)
+			}
+			if !c.queried || c.answered {
+				seen++
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		results := make(chan result, len(batch))
+		for _, c := range batch {
+			c.queried = true
+			go func(c *candidate) {
+				r, _ := n.call(ctx, c.Addr, &message{Type: typ, Target: target})
+				results <- result{c, r}
+			}(c)
+		}
+		for range batch {
+			res := <-results
+			if res.reply == nil {
+				n.table.evict(res.c.ID, nil)
+				continue
+			}
+			res.c.answered = true
+			add(res.reply.Nodes)
+			vs, _ := n.open(target, res.reply.Records)
+			for _, v := range vs {
+				if v != nil {
+					keep(v)
+				}
+			}
+		}
+	}
+
+	var closest []Contact
+	for _, c := range shortlist {
+		if c.answered {
+			closest = append(closest, c.Contact)
+			if len(closest) == n.k {
+				break
+			}
+		}
+	}
+	found := make([]*verified, 0, len(records))
+	for _, v := range records {
+		found = append(found, v)
+	}
+	return closest, found
+}
+
+// open verifies the records in srs as stored under key, checking their
+// signatures in one batch. The error of srs[i] is errs[i], and vs[i] is
+// nil when it is set.
+func (n *Node) open(key ID, srs []*SignedRecord) (vs []*verified, errs []error) {
+	vs, errs = verifyAll(time.Now(), srs)
+	for i, v := range vs {
+		switch {
+		case v == nil:
+		case key != AgentKey(v.Record.AgentID) && key != ColonyKey(v.Record.ColonyID):
+			errs[i] = fmt.Errorf("%w: record for %s does not belong under %s", ErrInvalidRecord, v.Record.AgentID, key)
+		case n.trust != nil && !n.trust(v.Record, v.signer):
+			errs[i] = ErrUntrusted
+		default:
+			continue
+		}
+		vs[i] = nil
+	}
+	return vs, errs
+}
+
+// store keeps each of srs under key unless an equal or newer record from
+// the same publisher is already there, returning the error of each.
+func (n *Node) store(key ID, srs ...*SignedRecord) []error {
+	vs, errs := n.open(key, srs)
+	n.storeMu.Lock()
+	defer n.storeMu.Unlock()
+	for i, v := range vs {
+		if v == nil {
+			continue
+		}
+		slots := n.stored[key]
+		if slots == nil {
+			slots = make(map[string]*verified)
+			n.stored[key] = slots
+		}
+		cur, ok := slots[v.slot()]
+		if ok && cur.Seq >= v.Seq {
+			continue
+		}
+		if !ok && len(slots) >= maxSlots {
+			errs[i] = fmt.Errorf("dht: %s holds %d records", key, maxSlots)
+			continue
+		}
+		slots[v.slot()] = v
+	}
+	return errs
+}
+
+// records returns up to maxRecords unexpired records under key.
+func (n *Node) records(key ID) []*SignedRecord {
+	now := time.Now()
+	n.storeMu.Lock()
+	defer n.storeMu.Unlock()
+	var out []*SignedRecord
+	for _, v := range n.stored[key] {
+		if len(out) == maxRecords {
+			break
+		}
+		if now.Before(v.ExpiresAt) {
+			out = append(out, v.SignedRecord)
+		}
+	}
+	return out
+}
+
+// expire drops records past their expiry.
+func (n *Node) expire(now time.Time) {
+	n.storeMu.Lock()
+	defer n.storeMu.Unlock()
+	for key, slots := range n.stored {
+		for slot, v := range slots {
+			if !now.Before(v.ExpiresAt) {
+				delete(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			delete(n.stored, key)
+		}
+	}
+}
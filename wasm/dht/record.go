@@ -0,0 +1,153 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/edbatch"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// signingContext prefixes signed payloads so record signatures cannot be
+// replayed as other Ed25519 messages.
+const signingContext = "coral-dht-record-v1\n"
+
+var (
+	// ErrInvalidRecord is returned for records that are malformed, badly
+	// signed or expired.
+	ErrInvalidRecord = errors.New("invalid DHT record")
+
+	// ErrUntrusted is returned for records whose signer Config.Trust
+	// rejects.
+	ErrUntrusted = errors.New("untrusted DHT record signer")
+)
+
+// SignedRecord is a registry record signed by its publisher. The
+// signature covers Payload as sent, so nodes running other versions can
+// verify and relay records with fields they do not know.
+type SignedRecord struct {
+	// Payload is the JSON encoding of the record and its validity.
+	Payload json.RawMessage `json:"payload"`
+
+	// PublicKey is the publisher's key, encoded with keys.EncodePublicKey.
+	PublicKey string `json:"public_key"`
+
+	// Signature is the base64 Ed25519 signature of signingContext and
+	// Payload.
+	Signature []byte `json:"signature"`
+}
+
+// payload is the signed content of a SignedRecord.
+type payload struct {
+	Record *registry.Record `json:"record"`
+
+	// Seq orders records from the same publisher; the highest wins.
+	Seq int64 `json:"seq"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sign signs rec with kp for ttl, stamping its update time and TTL.
+func Sign(kp *keys.KeyPair, rec *registry.Record, ttl time.Duration) (*SignedRecord, error) {
+	if err := rec.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	now := time.Now().UTC()
+	rec = rec.Clone()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	rec.UpdatedAt, rec.TTLSeconds = now, int(ttl.Seconds())
+	data, err := json.Marshal(payload{Record: rec, Seq: now.UnixNano(), ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return nil, err
+	}
+	return &SignedRecord{
+		Payload:   data,
+		PublicKey: keys.EncodePublicKey(kp.PublicKey),
+		Signature: ed25519.Sign(kp.PrivateKey, append([]byte(signingContext), data...)),
+	}, nil
+}
+
+// verified is a SignedRecord whose signature, shape and expiry checked
+// out.
+type verified struct {
+	*SignedRecord
+	payload
+	signer ed25519.PublicKey
+}
+
+// Open verifies the signature and expiry of s and returns its record and
+// the publisher's key. Whether the key may speak for the agent is up to
+// the caller; see Config.Trust.
+func (s *SignedRecord) Open() (*registry.Record, ed25519.PublicKey, error) {
+	v, err := s.verify(time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return v.Record, v.signer, nil
+}
+
+func (s *SignedRecord) verify(now time.Time) (*verified, error) {
+	vs, errs := verifyAll(now, []*SignedRecord{s})
+	return vs[0], errs[0]
+}
+
+// verifyAll verifies records like verify, checking their signatures in
+// one batch. The error of records[i] is errs[i], and vs[i] is nil when it
+// is set.
+func verifyAll(now time.Time, records []*SignedRecord) (vs []*verified, errs []error) {
+	vs, errs = make([]*verified, len(records)), make([]error, len(records))
+	var (
+		signed     []int
+		pubs       []ed25519.PublicKey
+		msgs, sigs [][]byte
+	)
+	for i, s := range records {
+		pub, err := keys.DecodePublicKey(s.PublicKey)
+		if err != nil {
+			errs[i] = fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+			continue
+		}
+		signed = append(signed, i)
+		pubs = append(pubs, pub)
+		msgs = append(msgs, append([]byte(signingContext), s.Payload...))
+		sigs = append(sigs, s.Signature)
+	}
+	for j, ok := range edbatch.Valid(pubs, msgs, sigs) {
+		i := signed[j]
+		if !ok {
+			errs[i] = fmt.Errorf("%w: bad signature", ErrInvalidRecord)
+			continue
+		}
+		vs[i], errs[i] = records[i].open(now, pubs[j])
+	}
+	return vs, errs
+}
+
+// open checks the payload of s, whose signature by pub checked out.
+func (s *SignedRecord) open(now time.Time, pub ed25519.PublicKey) (*verified, error) {
+	var p payload
+	if err := json.Unmarshal(s.Payload, &p); err != nil || p.Record == nil {
+		return nil, fmt.Errorf("%w: malformed payload", ErrInvalidRecord)
+	}
+	if err := p.Record.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	if !now.Before(p.ExpiresAt) {
+		return nil, fmt.Errorf("%w: expired %s", ErrInvalidRecord, p.ExpiresAt.Format(time.RFC3339))
+	}
+	p.Record.ExpiresAt = p.ExpiresAt
+	return &verified{SignedRecord: s, payload: p, signer: pub}, nil
+}
+
+// slot identifies a publisher's record for an agent; a newer record
+// from the same publisher replaces the older one.
+func (v *verified) slot() string {
+	return v.PublicKey + "/" + v.Record.AgentID
+}
@@ -0,0 +1,181 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Message types. Requests are answered with the type that follows them.
+const (
+	msgPing      = "ping"
+	msgPong      = "pong"
+	msgFindNode  = "find_node"
+	msgNodes     = "nodes"
+	msgFindValue = "find_value"
+	msgValue     = "value"
+	msgStore     = "store"
+	msgStored    = "stored"
+)
+
+// rpcTimeout is how long a request waits for its reply.
+const rpcTimeout = 2 * time.Second
+
+// maxPacket is the largest message read or written.
+const maxPacket = 64 << 10
+
+// maxRecords caps the records in one value reply, keeping it within a
+// datagram.
+const maxRecords = 32
+
+// errTimeout is returned when a peer does not reply in time.
+var errTimeout = errors.New("dht: request timed out")
+
+// message is a JSON datagram. The sender's address is taken from the
+// packet, so From carries only its ID.
+type message struct {
+	Type    string          `json:"t"`
+	RPC     uint64          `json:"id"`
+	From    ID              `json:"from"`
+	Target  ID              `json:"target"`
+	Nodes   []Contact       `json:"nodes,omitempty"`
+	Records []*SignedRecord `json:"records,omitempty"`
+}
+
+func isReply(t string) bool {
+	switch t {
+	case msgPong, msgNodes, msgValue, msgStored:
+		return true
+	}
+	return false
+}
+
+// send writes m to addr.
+func (n *Node) send(addr *net.UDPAddr, m *message) error {
+	m.From = n.id
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxPacket {
+		return fmt.Errorf("dht: %s message of %d bytes exceeds %d", m.Type, len(data), maxPacket)
+	}
+	_, err = n.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// call sends request m to addr and waits for the reply.
+func (n *Node) call(ctx context.Context, addr string, m *message) (*message, error) {
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make(chan *message, 1)
+	n.mu.Lock()
+	n.nextRPC++
+	m.RPC = n.nextRPC
+	n.pending[m.RPC] = reply
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, m.RPC)
+		n.mu.Unlock()
+	}()
+
+	if err := n.send(udp, m); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(rpcTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-reply:
+		return r, nil
+	case <-timer.C:
+		return nil, errTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// ping reports whether the node at addr answers, and its contact.
+func (n *Node) ping(ctx context.Context, addr string) (Contact, error) {
+	r, err := n.call(ctx, addr, &message{Type: msgPing})
+	if err != nil {
+		return Contact{}, err
+	}
+	return Contact{ID: r.From, Addr: addr}, nil
+}
+
+// readLoop dispatches incoming datagrams until the socket is closed.
+func (n *Node) readLoop() {
+	buf := make([]byte, maxPacket)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var m message
+		if err := json.Unmarshal(buf[:size], &m); err != nil || m.From == n.id {
+			continue
+		}
+		n.saw(Contact{ID: m.From, Addr: from.String()})
+
+		if isReply(m.Type) {
+			n.mu.Lock()
+			reply, ok := n.pending[m.RPC]
+			n.mu.Unlock()
+			if ok {
+				select {
+				case reply <- &m:
+				default:
+				}
+			}
+			continue
+		}
+		if r := n.handle(&m); r != nil {
+			r.RPC = m.RPC
+			n.send(from, r)
+		}
+	}
+}
+
+// handle answers request m, or returns nil for unknown types.
+func (n *Node) handle(m *message) *message {
+	switch m.Type {
+	case msgPing:
+		return &message{Type: msgPong}
+	case msgFindNode:
+		return &message{Type: msgNodes, Target: m.Target, Nodes: n.table.closest(m.Target, n.k)}
+	case msgFindValue:
+		return &message{Type: msgValue, Target: m.Target, Nodes: n.table.closest(m.Target, n.k), Records: n.records(m.Target)}
+	case msgStore:
+		n.store(m.Target, m.Records...)
+		return &message{Type: msgStored, Target: m.Target}
+	}
+	return nil
+}
+
+// saw adds c to the routing table. When c's bucket is full its oldest
+// contact is pinged in the background and replaced by c if it is gone.
+func (n *Node) saw(c Contact) {
+	oldest := n.table.seen(c)
+	if oldest == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+		defer cancel()
+		if _, err := n.ping(ctx, oldest.Addr); err != nil {
+			n.table.evict(oldest.ID, &c)
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Contact is a node and its UDP address.
+type Contact struct {
+	ID   ID     `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type bucketEntry struct {
+	Contact
+	lastSeen time.Time
+}
+
+// table is the routing table: one bucket of up to k contacts per shared
+// prefix length with the local node, least recently seen first.
+type table struct {
+	self ID
+	k    int
+
+	mu      sync.Mutex
+	buckets [len(ID{}) * 8][]bucketEntry
+}
+
+func newTable(self ID, k int) *table {
+	return &table{self: self, k: k}
+}
+
+// seen records contact as alive. When its bucket is full the least
+// recently seen entry is returned so the caller can ping it and call
+// evict if it is gone; the new contact is then dropped, as Kademlia
+// prefers long-lived nodes.
+func (t *table) seen(c Contact) (oldest *Contact) {
+	if c.ID == t.self {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[commonPrefix(t.self, c.ID)%len(t.buckets)]
+	for i, e := range *b {
+		if e.ID == c.ID {
+			*b = append((*b)[:i], (*b)[i+1:]...)
+			*b = append(*b, bucketEntry{Contact: c, lastSeen: time.Now()})
+			return nil
+		}
+	}
+	if len(*b) < t.k {
+		*b = append(*b, bucketEntry{Contact: c, lastSeen: time.Now()})
+		return nil
+	}
+	head := (*b)[0].Contact
+	return &head
+}
+
+// evict removes a contact that stopped answering, making room for
+// replacement.
+func (t *table) evict(id ID, replacement *Contact) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[commonPrefix(t.self, id)%len(t.buckets)]
+	for i, e := range *b {
+		if e.ID == id {
+			*b = append((*b)[:i], (*b)[i+1:]...)
+			break
+		}
+	}
+	if replacement != nil && len(*b) < t.k {
+		*b = append(*b, bucketEntry{Contact: *replacement, lastSeen: time.Now()})
+	}
+}
+
+// closest returns up to n known contacts closest to target.
+func (t *table) closest(target ID, n int) []Contact {
+	t.mu.Lock()
+	var all []Contact
+	for _, b := range t.buckets {
+		for _, e := range b {
+			all = append(all, e.Contact)
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return closer(target, all[i].ID, all[j].ID) })
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// size returns the number of known contacts.
+func (t *table) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	return n
+}
+
+// stale returns a contact per bucket not heard from since before, for
+// refreshing.
+func (t *table) stale(before time.Time) []Contact {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Contact
+	for _, b := range t.buckets {
+		if len(b) > 0 && b[0].lastSeen.Before(before) {
+			out = append(out, b[0].Contact)
+		}
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+// Package did renders coral keys and agents as W3C DID documents, so
+// partners that speak DID can use our keys without translating JWKs. Keys
+// become did:key identifiers; agents are did:web identifiers served by
+// discovery under /v1/agents/{id}/did.json.
+package did
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Verification method and service types used in documents.
+const (
+	// KeyType is the verification method type of Ed25519 keys.
+	KeyType = "Ed25519VerificationKey2020"
+
+	// ServiceType is the service type of agent endpoints.
+	ServiceType = "CoralAgentEndpoint"
+)
+
+// ed25519Multicodec is the multicodec prefix of Ed25519 public keys.
+var ed25519Multicodec = []byte{0xed, 0x01}
+
+// Contexts is the JSON-LD context of every document.
+var Contexts = []string{
+	"https://www.w3.org/ns/did/v1",
+	"https://w3id.org/security/suites/ed25519-2020/v1",
+}
+
+var (
+	// ErrInvalidDID is returned for malformed identifiers.
+	ErrInvalidDID = errors.New("invalid DID")
+
+	// ErrUnsupportedMethod is returned for DID methods other than key and
+	// web.
+	ErrUnsupportedMethod = errors.New("unsupported DID method")
+)
+
+// Document is a DID document.
+type Document struct {
+	Context            []string             `json:"@context"`
+	ID                 string               `json:"id"`
+	AlsoKnownAs        []string             `json:"alsoKnownAs,omitempty"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod"`
+	Authentication     []string             `json:"authentication"`
+	AssertionMethod    []string             `json:"assertionMethod"`
+	Service            []Service            `json:"service,omitempty"`
+}
+
+// VerificationMethod is an Ed25519 public key in a Document.
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// Service is an endpoint advertised in a Document.
+type Service struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// EncodeMultibase encodes b as base58btc multibase ("z" prefix).
+func EncodeMultibase(b []byte) string {
+	return "z" + base58Encode(b)
+}
+
+// DecodeMultibase decodes a base58btc multibase string.
+func DecodeMultibase(s string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(s, "z")
+	if !ok {
+		return nil, fmt.Errorf("%q is not base58btc multibase", s)
+	}
+	return base58Decode(rest)
+}
+
+// Multibase encodes pub as a multicodec Ed25519 key in base58btc, the
+// publicKeyMultibase form.
+func Multibase(pub ed25519.PublicKey) string {
+	return EncodeMultibase(append(append([]byte(nil), ed25519Multicodec...), pub...))
+}
+
+// ParseMultibase decodes a publicKeyMultibase Ed25519 key.
+func ParseMultibase(s string) (ed25519.PublicKey, error) {
+	b, err := DecodeMultibase(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+	}
+	if len(b) != len(ed25519Multicodec)+ed25519.PublicKeySize || b[0] != ed25519Multicodec[0] || b[1] != ed25519Multicodec[1] {
+		return nil, fmt.Errorf("%w: key %q is not Ed25519", ErrInvalidDID, s)
+	}
+	return ed25519.PublicKey(b[len(ed25519Multicodec):]), nil
+}
+
+// KeyDID returns the did:key identifier of pub.
+func KeyDID(pub ed25519.PublicKey) string {
+	return "did:key:" + Multibase(pub)
+}
+
+// ParseKeyDID returns the public key named by a did:key identifier.
+func ParseKeyDID(did string) (ed25519.PublicKey, error) {
+	id, ok := strings.CutPrefix(did, "did:key:")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is not did:key", ErrInvalidDID, did)
+	}
+	return ParseMultibase(id)
+}
+
+// KeyDocument returns the document of a did:key identifier, whose single
+// verification method is the key itself.
+func KeyDocument(pub ed25519.PublicKey) *Document {
+	did := KeyDID(pub)
+	doc := &Document{Context: Contexts, ID: did}
+	doc.addKey(did+"#"+Multibase(pub), pub)
+	return doc
+}
+
+// WebDID returns the did:web identifier for host and path segments, e.g.
+// WebDID("discovery.coral.io", "v1", "agents", "web-1"). A port in host
+// is percent-encoded as the method requires.
+func WebDID(host string, path ...string) string {
+	parts := []string{"did:web", webSegment(host)}
+	for _, p := range path {
+		parts = append(parts, webSegment(p))
+	}
+	return strings.Join(parts, ":")
+}
+
+// webSegment percent-encodes s for use between the colons of a did:web
+// identifier.
+func webSegment(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), ":", "%3A")
+}
+
+// WebURL returns the HTTPS URL of a did:web document.
+func WebURL(did string) (string, error) {
+	id, ok := strings.CutPrefix(did, "did:web:")
+	if !ok || id == "" {
+		return "", fmt.Errorf("%w: %q is not did:web", ErrInvalidDID, did)
+	}
+	segments := strings.Split(id, ":")
+	for i, s := range segments {
+		decoded, err := url.PathUnescape(s)
+		if err != nil || decoded == "" || (i > 0 && strings.Contains(decoded, "/")) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidDID, did)
+		}
+		segments[i] = decoded
+	}
+	if len(segments) == 1 {
+		return "https://" + segments[0] + "/.well-known/did.json", nil
+	}
+	path := make([]string, len(segments)-1)
+	for i, s := range segments[1:] {
+		path[i] = url.PathEscape(s)
+	}
+	return "https://" + segments[0] + "/" + strings.Join(path, "/") + "/did.json", nil
+}
+
+// AgentDocument renders rec as the document did, with a verification
+// method per JWK (the fragment is the kid) and a service per endpoint.
+// Keys that are not Ed25519 are skipped.
+func AgentDocument(did string, rec *registry.Record, jwks []keys.JWK) *Document {
+	doc := &Document{Context: Contexts, ID: did}
+	for _, jwk := range jwks {
+		if jwk.KTY != "OKP" || jwk.CRV != "Ed25519" {
+			continue
+		}
+		pub, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		doc.addKey(did+"#"+jwk.KID, pub)
+		doc.AlsoKnownAs = append(doc.AlsoKnownAs, KeyDID(pub))
+	}
+	for i, endpoint := range rec.Endpoints {
+		doc.Service = append(doc.Service, Service{
+			ID:              fmt.Sprintf("%s#endpoint-%d", did, i),
+			Type:            ServiceType,
+			ServiceEndpoint: endpoint,
+		})
+	}
+	return doc
+}
+
+// PublicKey returns the Ed25519 key of the verification method with id,
+// which may be the full method ID or just its fragment.
+func (d *Document) PublicKey(id string) (ed25519.PublicKey, error) {
+	if strings.HasPrefix(id, "#") {
+		id = d.ID + id
+	}
+	for _, vm := range d.VerificationMethod {
+		if vm.ID == id {
+			if vm.Type != KeyType {
+				return nil, fmt.Errorf("verification method %s has type %s", id, vm.Type)
+			}
+			return ParseMultibase(vm.PublicKeyMultibase)
+		}
+	}
+	return nil, fmt.Errorf("verification method %s not found in %s", id, d.ID)
+}
+
+func (d *Document) addKey(id string, pub ed25519.PublicKey) {
+	d.VerificationMethod = append(d.VerificationMethod, VerificationMethod{
+		ID:                 id,
+		Type:               KeyType,
+		Controller:         d.ID,
+		PublicKeyMultibase: Multibase(pub),
+	})
+	d.Authentication = append(d.Authentication, id)
+	d.AssertionMethod = append(d.AssertionMethod, id)
+}
@@ -0,0 +1,153 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/hdkey"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// agentPath is the did:web path of agent documents below the domain.
+var agentPath = []string{"v1", "agents"}
+
+// maxDocumentSize bounds fetched did:web documents.
+const maxDocumentSize = 1 << 20
+
+// Config holds the configuration for a Resolver.
+type Config struct {
+	// Domain is the host, with optional port, serving agent documents,
+	// e.g. "discovery.coral.io". Required for AgentDID and Document.
+	Domain string
+
+	// Reef is the reef whose agents the domain serves documents for.
+	Reef string
+
+	// Keys are the published JWKs. An agent's keys are those derived at
+	// hdkey.AgentPath(agentID).
+	Keys []hdkey.JWK
+
+	// HTTPClient fetches did:web documents. Defaults to a client with a
+	// 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Resolver maps coral agent IDs to did:web identifiers, renders their
+// documents and resolves did:key and did:web identifiers.
+type Resolver struct {
+	domain string
+	reef   string
+	keys   []hdkey.JWK
+	http   *http.Client
+}
+
+// New creates a Resolver from cfg.
+func New(cfg Config) *Resolver {
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Resolver{domain: cfg.Domain, reef: cfg.Reef, keys: cfg.Keys, http: hc}
+}
+
+// Reef returns the reef whose agents the resolver names.
+func (r *Resolver) Reef() string {
+	return r.reef
+}
+
+// AgentDID returns the DID of agentID.
+func (r *Resolver) AgentDID(agentID string) string {
+	return WebDID(r.domain, append(agentPath, agentID)...)
+}
+
+// AgentID returns the agent named by one of this resolver's agent DIDs.
+func (r *Resolver) AgentID(did string) (string, error) {
+	prefix := WebDID(r.domain, agentPath...) + ":"
+	id, ok := strings.CutPrefix(did, prefix)
+	if !ok || id == "" || strings.Contains(id, ":") {
+		return "", fmt.Errorf("%w: %q is not an agent of %s", ErrInvalidDID, did, r.domain)
+	}
+	agentID, err := url.PathUnescape(id)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDID, did)
+	}
+	return agentID, nil
+}
+
+// AgentKeys returns the published keys of agentID.
+func (r *Resolver) AgentKeys(agentID string) []keys.JWK {
+	path, err := hdkey.AgentPath(agentID)
+	if err != nil {
+		return nil
+	}
+	var out []keys.JWK
+	for _, k := range r.keys {
+		if k.Path == path {
+			out = append(out, k.JWK)
+		}
+	}
+	return out
+}
+
+// Document renders rec's DID document.
+func (r *Resolver) Document(rec *registry.Record) *Document {
+	return AgentDocument(r.AgentDID(rec.AgentID), rec, r.AgentKeys(rec.AgentID))
+}
+
+// Resolve returns the document of a did:key or did:web identifier.
+// did:web documents are fetched over HTTPS and must carry the requested
+// id.
+func (r *Resolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	switch {
+	case strings.HasPrefix(did, "did:key:"):
+		pub, err := ParseKeyDID(did)
+		if err != nil {
+			return nil, err
+		}
+		return KeyDocument(pub), nil
+	case strings.HasPrefix(did, "did:web:"):
+		return r.fetch(ctx, did)
+	case strings.HasPrefix(did, "did:"):
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, did)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDID, did)
+	}
+}
+
+func (r *Resolver) fetch(ctx context.Context, did string) (*Document, error) {
+	docURL, err := WebURL(did)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/did+json, application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", docURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", docURL, resp.Status)
+	}
+
+	var doc Document
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", docURL, err)
+	}
+	if doc.ID != did {
+		return nil, errors.New("document id " + doc.ID + " does not match " + did)
+	}
+	return &doc, nil
+}
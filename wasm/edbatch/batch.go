@@ -0,0 +1,275 @@
+// Package edbatch verifies many Ed25519 signatures at once. Rather than
+// checking [s]B = R + [k]A for each signature, it checks a random linear
+// combination of the equations with a single multi-scalar
+// multiplication, sharing the doublings between signatures and adding up
+// the terms of signatures by the same key.
+//
+// A batch can only decide the cofactored equation, [8][s]B = [8]R +
+// [8][k]A, so signatures are valid as ZIP 215 defines them, whether they
+// are checked in a batch or alone: s must be below the group order, but
+// the encodings of A and R need not be canonical. ed25519.Verify checks
+// the equation without the cofactor and requires R to be encoded
+// canonically. Every signature it accepts is valid here, but it rejects
+// some that are valid here: those whose R or A has a small-order
+// component or a non-canonical encoding. Only the signer, or whoever
+// chose a key of small order, can make such a signature, so accepting
+// it lets nobody forge one; it may still be rejected by other verifiers.
+package edbatch
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+// minBatch is the size below which Valid verifies signatures one by one.
+const minBatch = 4
+
+var (
+	// orderL is the prime order of the base point.
+	orderL, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+	// baseTable holds the odd multiples of the base point.
+	baseTable = sync.OnceValue(func() *[8]cached {
+		var b point
+		enc := [32]byte{0x58}
+		for i := 1; i < len(enc); i++ {
+			enc[i] = 0x66
+		}
+		if !b.setBytes(enc[:]) {
+			panic("edbatch: invalid base point")
+		}
+		table := new([8]cached)
+		oddMultiples(table, &b)
+		return table
+	})
+)
+
+// term is a scalar multiple of a point in the batch equation.
+type term struct {
+	table  *[8]cached
+	scalar *big.Int
+	digits [256]int8
+}
+
+// Verify reports whether sig is a valid signature of msg by pub.
+func Verify(pub ed25519.PublicKey, msg, sig []byte) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	// Signatures ed25519.Verify accepts are valid; it is the faster way
+	// to find out.
+	return ed25519.Verify(pub, msg, sig) || batch([]ed25519.PublicKey{pub}, [][]byte{msg}, [][]byte{sig})
+}
+
+// VerifyBatch reports whether every sigs[i] is a valid signature of
+// msgs[i] by pubs[i]. It reports false when the slices differ in length;
+// to learn which signatures are invalid, use Valid.
+func VerifyBatch(pubs []ed25519.PublicKey, msgs, sigs [][]byte) bool {
+	n := len(pubs)
+	switch {
+	case len(msgs) != n || len(sigs) != n:
+		return false
+	case n == 0:
+		return true
+	case n == 1:
+		return Verify(pubs[0], msgs[0], sigs[0])
+	}
+	return batch(pubs, msgs, sigs)
+}
+
+// batch reports whether the cofactored batch equation holds for the
+// signatures, that is, barring a chance of 2^-128, whether they are all
+// valid.
+func batch(pubs []ed25519.PublicKey, msgs, sigs [][]byte) bool {
+	n := len(pubs)
+	// A single equation needs no random coefficient.
+	var random []byte
+	if n > 1 {
+		random = make([]byte, 16*n)
+		if _, err := rand.Read(random); err != nil {
+			return false
+		}
+	}
+
+	// Σ[z·s]B - Σ[z]R - Σ[z·k]A = 0 for random 128-bit z.
+	base := &term{table: baseTable(), scalar: new(big.Int)}
+	terms := []*term{base}
+	byKey := make(map[string]*term)
+	tables := make([][8]cached, 0, 2*n) // never grows: terms point into it
+	for i, pub := range pubs {
+		sig := sigs[i]
+		if len(pub) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+			return false
+		}
+		s := scalar(sig[32:])
+		if s.Cmp(orderL) >= 0 {
+			return false
+		}
+		var r point
+		if !r.setBytes(sig[:32]) {
+			return false
+		}
+		h := sha512.New()
+		h.Write(sig[:32])
+		h.Write(pub)
+		h.Write(msgs[i])
+		k := new(big.Int).Mod(scalar(h.Sum(nil)), orderL)
+		z := big.NewInt(1)
+		if random != nil {
+			z = scalar(random[16*i : 16*(i+1)])
+		}
+
+		base.scalar.Add(base.scalar, s.Mul(s, z))
+
+		tables = append(tables, [8]cached{})
+		oddMultiples(&tables[len(tables)-1], r.neg())
+		terms = append(terms, &term{table: &tables[len(tables)-1], scalar: z})
+
+		a, ok := byKey[string(pub)]
+		if !ok {
+			var p point
+			if !p.setBytes(pub) {
+				return false
+			}
+			tables = append(tables, [8]cached{})
+			oddMultiples(&tables[len(tables)-1], p.neg())
+			a = &term{table: &tables[len(tables)-1], scalar: new(big.Int)}
+			byKey[string(pub)] = a
+			terms = append(terms, a)
+		}
+		a.scalar.Add(a.scalar, k.Mul(k, z))
+	}
+
+	q := multiScalarMul(terms)
+	for range 3 {
+		q.double(&q)
+	}
+	return q.isIdentity()
+}
+
+// Valid reports which sigs[i] are valid signatures of msgs[i] by
+// pubs[i]. Batches that fail the batch equation are halved until the
+// invalid signatures are found.
+func Valid(pubs []ed25519.PublicKey, msgs, sigs [][]byte) []bool {
+	valid := make([]bool, len(pubs))
+	if len(msgs) != len(pubs) || len(sigs) != len(pubs) {
+		return valid
+	}
+	var check func(lo, hi int)
+	check = func(lo, hi int) {
+		if hi-lo < minBatch {
+			for i := lo; i < hi; i++ {
+				valid[i] = Verify(pubs[i], msgs[i], sigs[i])
+			}
+			return
+		}
+		if batch(pubs[lo:hi], msgs[lo:hi], sigs[lo:hi]) {
+			for i := lo; i < hi; i++ {
+				valid[i] = true
+			}
+			return
+		}
+		mid := (lo + hi) / 2
+		check(lo, mid)
+		check(mid, hi)
+	}
+	check(0, len(pubs))
+	return valid
+}
+
+// multiScalarMul returns the sum of the terms by interleaving their
+// width-5 NAFs (Straus), so that all terms share one run of doublings.
+func multiScalarMul(terms []*term) point {
+	top := -1
+	for _, t := range terms {
+		t.scalar.Mod(t.scalar, orderL)
+		naf(&t.digits, t.scalar)
+		for i := len(t.digits) - 1; i > top; i-- {
+			if t.digits[i] != 0 {
+				top = i
+			}
+		}
+	}
+
+	var q point
+	q.setIdentity()
+	for i := top; i >= 0; i-- {
+		q.double(&q)
+		for _, t := range terms {
+			if d := t.digits[i]; d > 0 {
+				q.add(&q, &t.table[d/2], false)
+			} else if d < 0 {
+				q.add(&q, &t.table[-d/2], true)
+			}
+		}
+	}
+	return q
+}
+
+// naf sets digits to the width-5 non-adjacent form of s < 2^255: each
+// digit is zero or odd and below 16 in absolute value, and
+// s = Σ digits[i]·2^i.
+func naf(digits *[256]int8, s *big.Int) {
+	var be [32]byte
+	s.FillBytes(be[:])
+	var k [5]uint64
+	for i := range 4 {
+		k[i] = binary.BigEndian.Uint64(be[24-8*i:])
+	}
+
+	*digits = [256]int8{}
+	for i := 0; k != [5]uint64{}; {
+		// Skip to the next set bit.
+		if k[0] == 0 {
+			k = [5]uint64{k[1], k[2], k[3], k[4]}
+			i += 64
+			continue
+		}
+		if z := bits.TrailingZeros64(k[0]); z > 0 {
+			shiftRight(&k, uint(z))
+			i += z
+		}
+
+		d := int64(k[0] & 31)
+		if d >= 16 {
+			d -= 32
+		}
+		digits[i] = int8(d)
+		// k -= d clears the low bits, carrying through the limbs when d
+		// is negative.
+		if d > 0 {
+			k[0] -= uint64(d)
+		} else {
+			carry := uint64(-d)
+			for j := range k {
+				k[j] += carry
+				if k[j] >= carry {
+					break
+				}
+				carry = 1
+			}
+		}
+	}
+}
+
+// shiftRight shifts k right by n < 64 bits.
+func shiftRight(k *[5]uint64, n uint) {
+	for j := range 4 {
+		k[j] = k[j]>>n | k[j+1]<<(64-n)
+	}
+	k[4] >>= n
+}
+
+// scalar decodes a little-endian integer.
+func scalar(le []byte) *big.Int {
+	be := make([]byte, len(le))
+	for i, b := range le {
+		be[len(le)-1-i] = b
+	}
+	return new(big.Int).SetBytes(be)
+}
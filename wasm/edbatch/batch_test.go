@@ -0,0 +1,235 @@
+package edbatch_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/edbatch"
+)
+
+var (
+	orderL, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+	fieldP    = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+)
+
+func le(b []byte) *big.Int {
+	return new(big.Int).SetBytes(reverse(b))
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func encode(v *big.Int) []byte {
+	return reverse(v.FillBytes(make([]byte, 32)))
+}
+
+// secret returns the clamped scalar of an RFC 8032 private key.
+func secret(key ed25519.PrivateKey) *big.Int {
+	h := sha512.Sum512(key.Seed())
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return le(h[:32])
+}
+
+// challenge returns H(R || A || msg) mod L.
+func challenge(r, pub, msg []byte) *big.Int {
+	h := sha512.New()
+	h.Write(r)
+	h.Write(pub)
+	h.Write(msg)
+	return new(big.Int).Mod(le(h.Sum(nil)), orderL)
+}
+
+// torsioned returns a signature of msg by key whose R carries the point
+// of order 2, (0, -1): it satisfies the cofactored equation, which
+// multiplies it away, but not the one ed25519.Verify checks.
+func torsioned(key ed25519.PrivateKey, msg []byte) []byte {
+	nonce := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{9}, ed25519.SeedSize))
+	// Adding (0, -1) to (x, y) gives (-x, -y).
+	enc := nonce.Public().(ed25519.PublicKey)
+	y := le(append(bytes.Clone(enc[:31]), enc[31]&0x7f))
+	r := encode(new(big.Int).Sub(fieldP, y))
+	r[31] |= ^enc[31] & 0x80
+
+	pub := key.Public().(ed25519.PublicKey)
+	k := challenge(r, pub, msg)
+	s := k.Mul(k, secret(key))
+	s.Add(s, secret(nonce)).Mod(s, orderL)
+	return append(r, encode(s)...)
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestRFC8032 checks the first three test vectors of RFC 8032, section
+// 7.1, alone and in batches.
+func TestRFC8032(t *testing.T) {
+	vectors := [][3]string{
+		{"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a", "",
+			"e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b"},
+		{"3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c", "72",
+			"92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00"},
+		{"fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025", "af82",
+			"6291d657deec24024827e69c3abe01a30ce548a284743a445e3680d7db5ac3ac18ff9b538d16f290ae67f760984dc6594a7c15e9716ed28dc027beceea1ec40a"},
+	}
+	var pubs []ed25519.PublicKey
+	var msgs, sigs [][]byte
+	for _, v := range vectors {
+		pubs = append(pubs, unhex(t, v[0]))
+		msgs = append(msgs, unhex(t, v[1]))
+		sigs = append(sigs, unhex(t, v[2]))
+	}
+	// Repeat them past the size below which Valid skips batching.
+	for range 2 {
+		pubs, msgs, sigs = append(pubs, pubs[:3]...), append(msgs, msgs[:3]...), append(sigs, sigs[:3]...)
+	}
+
+	if !edbatch.VerifyBatch(pubs, msgs, sigs) {
+		t.Fatal("VerifyBatch rejects the RFC 8032 vectors")
+	}
+	for i, ok := range edbatch.Valid(pubs, msgs, sigs) {
+		if !ok {
+			t.Fatalf("Valid rejects vector %d", i%3+1)
+		}
+	}
+
+	msgs[4] = []byte("other")
+	if edbatch.VerifyBatch(pubs, msgs, sigs) {
+		t.Fatal("VerifyBatch accepts a signature of another message")
+	}
+	for i, ok := range edbatch.Valid(pubs, msgs, sigs) {
+		if ok != (i != 4) {
+			t.Fatalf("Valid reports %v for signature %d", ok, i)
+		}
+	}
+	if edbatch.VerifyBatch(pubs, msgs[1:], sigs) {
+		t.Fatal("VerifyBatch accepts slices of different lengths")
+	}
+}
+
+// TestZIP215 checks malleable, small-order and non-canonical signatures
+// alone and in batches large enough to take the batch equation, and
+// that every signature ed25519.Verify accepts is valid.
+func TestZIP215(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	pub := key.Public().(ed25519.PublicKey)
+	msg := []byte("ticket")
+	sig := ed25519.Sign(key, msg)
+
+	// S + L decodes to the same scalar as S.
+	malleable := append(bytes.Clone(sig[:32]), encode(new(big.Int).Add(le(sig[32:]), orderL))...)
+	// The neutral element, encoded canonically and with y = p + 1.
+	identity := append([]byte{1}, make([]byte, 31)...)
+	nonCanonical := encode(new(big.Int).Add(fieldP, big.NewInt(1)))
+	// (0, -1) has order 2. Setting the sign bit of the identity's
+	// encoding gives it a negative zero x.
+	order2 := encode(new(big.Int).Sub(fieldP, big.NewInt(1)))
+	negativeZero := bytes.Clone(identity)
+	negativeZero[31] |= 0x80
+	zero := make([]byte, 32)
+
+	cases := map[string]struct {
+		pub  ed25519.PublicKey
+		sig  []byte
+		want bool
+	}{
+		"valid":                      {pub, sig, true},
+		"S + L":                      {pub, malleable, false},
+		"torsion in R":               {pub, torsioned(key, msg), true},
+		"identity key and R":         {identity, append(bytes.Clone(identity), zero...), true},
+		"non-canonical identity key": {nonCanonical, append(bytes.Clone(identity), zero...), true},
+		"non-canonical R":            {identity, append(bytes.Clone(nonCanonical), zero...), true},
+		"R of order 2":               {identity, append(bytes.Clone(order2), zero...), true},
+		"R with negative zero x":     {identity, append(bytes.Clone(negativeZero), zero...), true},
+		"key of order 2":             {order2, append(bytes.Clone(identity), zero...), true},
+		"key with negative zero x":   {negativeZero, append(bytes.Clone(identity), zero...), true},
+		"other message":              {pub, ed25519.Sign(key, []byte("other")), false},
+		"short key":                  {pub[:31], sig, false},
+		"short signature":            {pub, sig[:63], false},
+	}
+	for name, c := range cases {
+		if len(c.pub) == ed25519.PublicKeySize && ed25519.Verify(c.pub, msg, c.sig) && !c.want {
+			t.Fatalf("%s: ed25519.Verify accepts a signature the case rejects", name)
+		}
+		if got := edbatch.Verify(c.pub, msg, c.sig); got != c.want {
+			t.Errorf("%s: Verify reports %v, want %v", name, got, c.want)
+		}
+		// Surround the case with valid signatures by other keys.
+		pubs := []ed25519.PublicKey{c.pub}
+		msgs, sigs := [][]byte{msg}, [][]byte{c.sig}
+		for i := range 7 {
+			other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i + 2)}, ed25519.SeedSize))
+			pubs = append(pubs, other.Public().(ed25519.PublicKey))
+			msgs = append(msgs, msg)
+			sigs = append(sigs, ed25519.Sign(other, msg))
+		}
+		for i, ok := range edbatch.Valid(pubs, msgs, sigs) {
+			if i == 0 && ok != c.want {
+				t.Errorf("%s: Valid reports %v, want %v", name, ok, c.want)
+			}
+			if i > 0 && !ok {
+				t.Errorf("%s: Valid rejects valid signature %d", name, i)
+			}
+		}
+		if got := edbatch.VerifyBatch(pubs, msgs, sigs); got != c.want {
+			t.Errorf("%s: VerifyBatch reports %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+// signatures returns n signatures of distinct messages, made by keys
+// different keys in turn.
+func signatures(n, keys int) ([]ed25519.PublicKey, [][]byte, [][]byte) {
+	pubs := make([]ed25519.PublicKey, n)
+	msgs, sigs := make([][]byte, n), make([][]byte, n)
+	for i := range n {
+		key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i%keys + 1)}, ed25519.SeedSize))
+		pubs[i] = key.Public().(ed25519.PublicKey)
+		msgs[i] = []byte{byte(i), byte(i >> 8)}
+		sigs[i] = ed25519.Sign(key, msgs[i])
+	}
+	return pubs, msgs, sigs
+}
+
+// BenchmarkVerifyBatch compares VerifyBatch with ed25519.Verify on each
+// signature, for batches by one key and by as many keys as signatures.
+func BenchmarkVerifyBatch(b *testing.B) {
+	for _, n := range []int{8, 64} {
+		for _, keys := range []int{1, n} {
+			pubs, msgs, sigs := signatures(n, keys)
+			b.Run(fmt.Sprintf("n=%d/keys=%d/batch", n, keys), func(b *testing.B) {
+				for b.Loop() {
+					if !edbatch.VerifyBatch(pubs, msgs, sigs) {
+						b.Fatal("VerifyBatch rejects valid signatures")
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("n=%d/keys=%d/loop", n, keys), func(b *testing.B) {
+				for b.Loop() {
+					for i, pub := range pubs {
+						if !ed25519.Verify(pub, msgs[i], sigs[i]) {
+							b.Fatal("ed25519.Verify rejects a valid signature")
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package edbatch
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// This file implements arithmetic modulo p = 2^255 - 19 on five 51-bit
+// limbs, as crypto/ed25519 does internally. Everything here is
+// variable-time: batch verification only handles public values.
+
+const maskLow51 = 1<<51 - 1
+
+// fe is a field element v = l[0] + l[1]·2^51 + ... + l[4]·2^204. Limbs
+// stay below 2^52 between operations.
+type fe [5]uint64
+
+var (
+	feZero = fe{}
+	feOne  = fe{1}
+
+	// feD is the curve constant d = -121665/121666.
+	feD = fe{929955233495203, 466365720129213, 1662059464998953, 2033849074728123, 1442794654840575}
+
+	// feD2 is 2·d.
+	feD2 = fe{1859910466990425, 932731440258426, 1072319116312658, 1815898335770999, 633789495995903}
+
+	// feSqrtM1 is a square root of -1.
+	feSqrtM1 = fe{1718705420411056, 234908883556509, 2233514472574048, 2117202627021982, 765476049583133}
+)
+
+// carry brings every limb of v back below 2^52.
+func (v *fe) carry() *fe {
+	c0, c1, c2, c3, c4 := v[0]>>51, v[1]>>51, v[2]>>51, v[3]>>51, v[4]>>51
+	v[0] = v[0]&maskLow51 + c4*19
+	v[1] = v[1]&maskLow51 + c0
+	v[2] = v[2]&maskLow51 + c1
+	v[3] = v[3]&maskLow51 + c2
+	v[4] = v[4]&maskLow51 + c3
+	return v
+}
+
+// add sets v = a + b.
+func (v *fe) add(a, b *fe) *fe {
+	for i := range v {
+		v[i] = a[i] + b[i]
+	}
+	return v.carry()
+}
+
+// sub sets v = a - b, adding 2p first so that limbs do not underflow.
+func (v *fe) sub(a, b *fe) *fe {
+	v[0] = a[0] + 0xFFFFFFFFFFFDA - b[0]
+	for i := 1; i < 5; i++ {
+		v[i] = a[i] + 0xFFFFFFFFFFFFE - b[i]
+	}
+	return v.carry()
+}
+
+// neg sets v = -a.
+func (v *fe) neg(a *fe) *fe {
+	return v.sub(&feZero, a)
+}
+
+// uint128 is an intermediate product.
+type uint128 struct {
+	lo, hi uint64
+}
+
+func mul64(a, b uint64) uint128 {
+	hi, lo := bits.Mul64(a, b)
+	return uint128{lo, hi}
+}
+
+func addMul64(v uint128, a, b uint64) uint128 {
+	hi, lo := bits.Mul64(a, b)
+	lo, c := bits.Add64(lo, v.lo, 0)
+	hi, _ = bits.Add64(hi, v.hi, c)
+	return uint128{lo, hi}
+}
+
+func shiftRight51(a uint128) uint64 {
+	return a.hi<<13 | a.lo>>51
+}
+
+// mul sets v = a · b. Products of limbs past 2^255 wrap around
+// multiplied by 19, since 2^255 = 19 mod p.
+func (v *fe) mul(a, b *fe) *fe {
+	a0, a1, a2, a3, a4 := a[0], a[1], a[2], a[3], a[4]
+	b0, b1, b2, b3, b4 := b[0], b[1], b[2], b[3], b[4]
+	a1x19, a2x19, a3x19, a4x19 := a1*19, a2*19, a3*19, a4*19
+
+	r0 := mul64(a0, b0)
+	r0 = addMul64(r0, a1x19, b4)
+	r0 = addMul64(r0, a2x19, b3)
+	r0 = addMul64(r0, a3x19, b2)
+	r0 = addMul64(r0, a4x19, b1)
+
+	r1 := mul64(a0, b1)
+	r1 = addMul64(r1, a1, b0)
+	r1 = addMul64(r1, a2x19, b4)
+	r1 = addMul64(r1, a3x19, b3)
+	r1 = addMul64(r1, a4x19, b2)
+
+	r2 := mul64(a0, b2)
+	r2 = addMul64(r2, a1, b1)
+	r2 = addMul64(r2, a2, b0)
+	r2 = addMul64(r2, a3x19, b4)
+	r2 = addMul64(r2, a4x19, b3)
+
+	r3 := mul64(a0, b3)
+	r3 = addMul64(r3, a1, b2)
+	r3 = addMul64(r3, a2, b1)
+	r3 = addMul64(r3, a3, b0)
+	r3 = addMul64(r3, a4x19, b4)
+
+	r4 := mul64(a0, b4)
+	r4 = addMul64(r4, a1, b3)
+	r4 = addMul64(r4, a2, b2)
+	r4 = addMul64(r4, a3, b1)
+	r4 = addMul64(r4, a4, b0)
+
+	return v.reduceProducts(r0, r1, r2, r3, r4)
+}
+
+// reduceProducts sets v from the 128-bit sums of limb products of mul
+// and square.
+func (v *fe) reduceProducts(r0, r1, r2, r3, r4 uint128) *fe {
+	c0, c1, c2, c3, c4 := shiftRight51(r0), shiftRight51(r1), shiftRight51(r2), shiftRight51(r3), shiftRight51(r4)
+	v[0] = r0.lo&maskLow51 + c4*19
+	v[1] = r1.lo&maskLow51 + c0
+	v[2] = r2.lo&maskLow51 + c1
+	v[3] = r3.lo&maskLow51 + c2
+	v[4] = r4.lo&maskLow51 + c3
+	return v.carry()
+}
+
+// square sets v = a², with the symmetric products of mul computed once.
+func (v *fe) square(a *fe) *fe {
+	l0, l1, l2, l3, l4 := a[0], a[1], a[2], a[3], a[4]
+	l0x2, l1x2 := l0*2, l1*2
+	l1x38, l2x38, l3x38 := l1*38, l2*38, l3*38
+	l3x19, l4x19 := l3*19, l4*19
+
+	r0 := mul64(l0, l0)
+	r0 = addMul64(r0, l1x38, l4)
+	r0 = addMul64(r0, l2x38, l3)
+
+	r1 := mul64(l0x2, l1)
+	r1 = addMul64(r1, l2x38, l4)
+	r1 = addMul64(r1, l3x19, l3)
+
+	r2 := mul64(l0x2, l2)
+	r2 = addMul64(r2, l1, l1)
+	r2 = addMul64(r2, l3x38, l4)
+
+	r3 := mul64(l0x2, l3)
+	r3 = addMul64(r3, l1x2, l2)
+	r3 = addMul64(r3, l4x19, l4)
+
+	r4 := mul64(l0x2, l4)
+	r4 = addMul64(r4, l1x2, l3)
+	r4 = addMul64(r4, l2, l2)
+
+	return v.reduceProducts(r0, r1, r2, r3, r4)
+}
+
+// squareN sets v = a^(2^n).
+func (v *fe) squareN(a *fe, n int) *fe {
+	v.square(a)
+	for i := 1; i < n; i++ {
+		v.square(v)
+	}
+	return v
+}
+
+// pow22523 sets v = a^((p-5)/8) = a^(2^252-3).
+func (v *fe) pow22523(a *fe) *fe {
+	var t0, t1, t2 fe
+	t0.square(a)         // 2
+	t1.squareN(&t0, 2)   // 8
+	t1.mul(a, &t1)       // 9
+	t0.mul(&t0, &t1)     // 11
+	t0.square(&t0)       // 22
+	t0.mul(&t1, &t0)     // 2^5 - 1
+	t1.squareN(&t0, 5)   // 2^10 - 2^5
+	t0.mul(&t1, &t0)     // 2^10 - 1
+	t1.squareN(&t0, 10)  // 2^20 - 2^10
+	t1.mul(&t1, &t0)     // 2^20 - 1
+	t2.squareN(&t1, 20)  // 2^40 - 2^20
+	t1.mul(&t2, &t1)     // 2^40 - 1
+	t1.squareN(&t1, 10)  // 2^50 - 2^10
+	t0.mul(&t1, &t0)     // 2^50 - 1
+	t1.squareN(&t0, 50)  // 2^100 - 2^50
+	t1.mul(&t1, &t0)     // 2^100 - 1
+	t2.squareN(&t1, 100) // 2^200 - 2^100
+	t1.mul(&t2, &t1)     // 2^200 - 1
+	t1.squareN(&t1, 50)  // 2^250 - 2^50
+	t0.mul(&t1, &t0)     // 2^250 - 1
+	t0.squareN(&t0, 2)   // 2^252 - 4
+	return v.mul(&t0, a) // 2^252 - 3
+}
+
+// reduce returns v fully reduced modulo p.
+func (v *fe) reduce() fe {
+	t := *v
+	t.carry()
+	// t < 2p now, so t ≥ p exactly when t + 19 carries past 2^255.
+	c := (t[0] + 19) >> 51
+	c = (t[1] + c) >> 51
+	c = (t[2] + c) >> 51
+	c = (t[3] + c) >> 51
+	c = (t[4] + c) >> 51
+	t[0] += 19 * c
+	t[1] += t[0] >> 51
+	t[0] &= maskLow51
+	t[2] += t[1] >> 51
+	t[1] &= maskLow51
+	t[3] += t[2] >> 51
+	t[2] &= maskLow51
+	t[4] += t[3] >> 51
+	t[3] &= maskLow51
+	t[4] &= maskLow51
+	return t
+}
+
+// setBytes sets v to the little-endian b, ignoring its top bit. Values
+// from p to 2^255 - 1 are accepted and reduced.
+func (v *fe) setBytes(b *[32]byte) *fe {
+	v[0] = binary.LittleEndian.Uint64(b[0:8]) & maskLow51
+	v[1] = binary.LittleEndian.Uint64(b[6:14]) >> 3 & maskLow51
+	v[2] = binary.LittleEndian.Uint64(b[12:20]) >> 6 & maskLow51
+	v[3] = binary.LittleEndian.Uint64(b[19:27]) >> 1 & maskLow51
+	v[4] = binary.LittleEndian.Uint64(b[24:32]) >> 12 & maskLow51
+	return v
+}
+
+// isZero reports whether v = 0 mod p.
+func (v *fe) isZero() bool {
+	return v.reduce() == feZero
+}
+
+// equal reports whether v = u mod p.
+func (v *fe) equal(u *fe) bool {
+	return v.reduce() == u.reduce()
+}
+
+// isNegative reports whether v is odd, the sign of x in point encodings.
+func (v *fe) isNegative() bool {
+	return v.reduce()[0]&1 == 1
+}
+
+// sqrtRatio sets v to the non-negative square root of u/w and reports
+// whether u/w is a square (RFC 8032, section 5.1.3).
+func (v *fe) sqrtRatio(u, w *fe) bool {
+	var w3, w7, uw3, uw7, r, check fe
+	w3.mul(w3.square(w), w)
+	w7.mul(w7.square(&w3), w)
+	uw3.mul(u, &w3)
+	uw7.mul(u, &w7)
+	r.mul(&uw3, r.pow22523(&uw7))
+
+	check.mul(w, check.square(&r))
+	var uNeg, uNegI fe
+	uNeg.neg(u)
+	uNegI.mul(&uNeg, &feSqrtM1)
+	correct, flipped, flippedI := check.equal(u), check.equal(&uNeg), check.equal(&uNegI)
+	if flipped || flippedI {
+		r.mul(&r, &feSqrtM1)
+	}
+	if r.isNegative() {
+		r.neg(&r)
+	}
+	*v = r
+	return correct || flipped
+}
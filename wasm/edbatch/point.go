@@ -0,0 +1,125 @@
+package edbatch
+
+// point is an edwards25519 point in extended coordinates (X:Y:Z:T) with
+// x = X/Z, y = Y/Z and xy = T/Z.
+type point struct {
+	x, y, z, t fe
+}
+
+// cached is a point prepared for repeated addition.
+type cached struct {
+	yPlusX, yMinusX, z, t2d fe
+}
+
+func (p *point) setIdentity() *point {
+	*p = point{y: feOne, z: feOne}
+	return p
+}
+
+// setBytes decodes an RFC 8032 point encoding and reports whether it is
+// valid. As ZIP 215 requires, it accepts y encoded at or above p and a
+// negative zero x.
+func (p *point) setBytes(b []byte) bool {
+	if len(b) != 32 {
+		return false
+	}
+	enc := [32]byte(b)
+	var y fe
+	y.setBytes(&enc)
+
+	// x² = (y² - 1) / (d·y² + 1)
+	var y2, u, w, x fe
+	y2.square(&y)
+	u.sub(&y2, &feOne)
+	w.add(w.mul(&feD, &y2), &feOne)
+	if !x.sqrtRatio(&u, &w) {
+		return false
+	}
+	if enc[31]>>7 == 1 {
+		x.neg(&x)
+	}
+
+	p.x, p.y, p.z = x, y, feOne
+	p.t.mul(&x, &y)
+	return true
+}
+
+// neg sets p = -p.
+func (p *point) neg() *point {
+	p.x.neg(&p.x)
+	p.t.neg(&p.t)
+	return p
+}
+
+// isIdentity reports whether p is the neutral element.
+func (p *point) isIdentity() bool {
+	return p.x.isZero() && p.y.equal(&p.z)
+}
+
+// set prepares p for addition.
+func (c *cached) set(p *point) *cached {
+	c.yPlusX.add(&p.y, &p.x)
+	c.yMinusX.sub(&p.y, &p.x)
+	c.z = p.z
+	c.t2d.mul(&p.t, &feD2)
+	return c
+}
+
+// double sets p = 2q (dbl-2008-hwcd with a = -1).
+func (p *point) double(q *point) *point {
+	var a, b, c, e, f, g, h fe
+	a.square(&q.x)
+	b.square(&q.y)
+	c.add(c.square(&q.z), &c)
+	e.sub(e.sub(e.square(e.add(&q.x, &q.y)), &a), &b)
+	g.sub(&b, &a)
+	f.sub(&g, &c)
+	h.neg(h.add(&a, &b))
+	p.x.mul(&e, &f)
+	p.y.mul(&g, &h)
+	p.t.mul(&e, &h)
+	p.z.mul(&f, &g)
+	return p
+}
+
+// add sets p = q + c (add-2008-hwcd-3 with a = -1), or q - c when sub
+// is set.
+func (p *point) add(q *point, c *cached, sub bool) *point {
+	plus, minus := &c.yPlusX, &c.yMinusX
+	if sub {
+		plus, minus = minus, plus
+	}
+	var a, b, cc, d, e, f, g, h fe
+	a.mul(a.sub(&q.y, &q.x), minus)
+	b.mul(b.add(&q.y, &q.x), plus)
+	cc.mul(&q.t, &c.t2d)
+	d.add(d.mul(&q.z, &c.z), &d)
+	e.sub(&b, &a)
+	h.add(&b, &a)
+	if sub {
+		f.add(&d, &cc)
+		g.sub(&d, &cc)
+	} else {
+		f.sub(&d, &cc)
+		g.add(&d, &cc)
+	}
+	p.x.mul(&e, &f)
+	p.y.mul(&g, &h)
+	p.t.mul(&e, &h)
+	p.z.mul(&f, &g)
+	return p
+}
+
+// oddMultiples fills table with P, 3P, 5P, ... for the digits of a
+// width-5 NAF.
+func oddMultiples(table *[8]cached, p *point) {
+	var p2, cur point
+	var c2 cached
+	c2.set(p2.double(p))
+	cur = *p
+	table[0].set(&cur)
+	for i := 1; i < len(table); i++ {
+		cur.add(&cur, &c2, false)
+		table[i].set(&cur)
+	}
+}
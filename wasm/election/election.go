@@ -0,0 +1,317 @@
+// Package election elects leaders among the agents of a colony. An agent
+// campaigns for a named election and, once no other agent holds it,
+// leads for a term it keeps alive by renewing its lease. Each term gets
+// a fencing token greater than those of the terms before it, so that the
+// systems a leader writes to can refuse writes from a leader whose term
+// has ended without it noticing. Elections are scoped to the reef and
+// colony of the candidates' tickets, and kept in a store.Store with
+// compare-and-swap writes.
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Defaults and bounds of the lease of a term.
+const (
+	DefaultTTL = 15 * time.Second
+	MinTTL     = time.Second
+	MaxTTL     = 5 * time.Minute
+)
+
+// MaxNameSize bounds the length of an election name.
+const MaxNameSize = 256
+
+// keyPrefix namespaces elections in the store; an election lives under
+// keyPrefix + reef + "/" + colony + "/" + name.
+const keyPrefix = "elections/"
+
+// maxWriteAttempts bounds the compare-and-swap retries of one write.
+const maxWriteAttempts = 8
+
+var (
+	// ErrInvalid is returned for malformed election names, sessions and
+	// TTLs.
+	ErrInvalid = errors.New("invalid election request")
+
+	// ErrNoLeader is returned by Leader when nobody leads.
+	ErrNoLeader = errors.New("election has no leader")
+
+	// ErrNotLeader is returned for renewals and resignations of a term
+	// that has ended.
+	ErrNotLeader = errors.New("not the leader")
+)
+
+// Leader is the state of an election: its current or last term.
+type Leader struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	Name     string `json:"name"`
+
+	// AgentID is the leader, empty once it resigned.
+	AgentID string `json:"agent_id,omitempty"`
+
+	// Session tells the campaigns of one agent apart, so that two
+	// instances of an agent do not both lead.
+	Session string `json:"session,omitempty"`
+
+	// Fence is the fencing token of the term. It is greater than the
+	// tokens of every earlier term of the election.
+	Fence uint64 `json:"fence"`
+
+	TTLSeconds int       `json:"ttl_seconds"`
+	ElectedAt  time.Time `json:"elected_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Candidate is an agent campaigning in, or leading, an election. ReefID,
+// ColonyID and AgentID must come from its verified ticket.
+type Candidate struct {
+	ReefID   string
+	ColonyID string
+	AgentID  string
+	Name     string
+	Session  string
+}
+
+// Config holds the configuration for Elections.
+type Config struct {
+	// Store holds the elections. Defaults to an in-memory store; use a
+	// shared backend when several instances serve the same colony.
+	Store store.Store
+}
+
+// Elections runs the elections of every colony.
+type Elections struct {
+	store store.Store
+}
+
+// New creates Elections from cfg.
+func New(cfg Config) *Elections {
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	return &Elections{store: st}
+}
+
+// Campaign makes c the leader of its election for ttl, when nobody else
+// leads, and returns the election's state: c leads when its AgentID and
+// Session are c's. A leader campaigning again keeps its term. While
+// another agent leads, Campaign waits up to wait for its term to end.
+func (e *Elections) Campaign(ctx context.Context, c Candidate, ttl, wait time.Duration) (*Leader, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < MinTTL || ttl > MaxTTL || ttl%time.Second != 0 {
+		return nil, fmt.Errorf("%w: ttl must be whole seconds between %s and %s", ErrInvalid, MinTTL, MaxTTL)
+	}
+	key := electionKey(c.ReefID, c.ColonyID, c.Name)
+	deadline := time.Now().Add(wait)
+	for {
+		l, err := e.campaign(ctx, key, c, ttl)
+		if err != nil || l.leads(c) || wait <= 0 {
+			return l, err
+		}
+
+		// Subscribe before looking again, so that a resignation in
+		// between is not missed, and wake up when the term runs out.
+		until := l.ExpiresAt
+		if deadline.Before(until) {
+			until = deadline
+		}
+		waitCtx, cancel := context.WithDeadline(ctx, until)
+		events, err := e.store.Watch(waitCtx, key)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if l, err = e.campaign(ctx, key, c, ttl); err != nil || l.leads(c) {
+			cancel()
+			return l, err
+		}
+		for range events {
+			break
+		}
+		cancel()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return e.campaign(ctx, key, c, ttl)
+		}
+	}
+}
+
+// campaign makes one attempt at leading the election at key.
+func (e *Elections) campaign(ctx context.Context, key string, c Candidate, ttl time.Duration) (*Leader, error) {
+	for range maxWriteAttempts {
+		cur, rev, err := e.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		if cur != nil && cur.live(now) {
+			return cur, nil
+		}
+		next := &Leader{
+			ReefID:     c.ReefID,
+			ColonyID:   c.ColonyID,
+			Name:       c.Name,
+			AgentID:    c.AgentID,
+			Session:    c.Session,
+			Fence:      1,
+			TTLSeconds: int(ttl / time.Second),
+			ElectedAt:  now,
+			ExpiresAt:  now.Add(ttl),
+		}
+		if cur != nil {
+			next.Fence = cur.Fence + 1
+		}
+		err = e.put(ctx, key, rev, next)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return next, nil
+	}
+	return nil, fmt.Errorf("election %s: too many concurrent writes", c.Name)
+}
+
+// Renew extends the term of c, the leader holding fence, by its TTL. It
+// returns ErrNotLeader once the term has ended.
+func (e *Elections) Renew(ctx context.Context, c Candidate, fence uint64) (*Leader, error) {
+	return e.update(ctx, c, fence, func(l *Leader, now time.Time) {
+		l.ExpiresAt = now.Add(time.Duration(l.TTLSeconds) * time.Second)
+	})
+}
+
+// Resign ends the term of c, the leader holding fence, so that another
+// candidate can lead right away. It returns ErrNotLeader once the term
+// has ended.
+func (e *Elections) Resign(ctx context.Context, c Candidate, fence uint64) error {
+	_, err := e.update(ctx, c, fence, func(l *Leader, now time.Time) {
+		l.AgentID, l.Session = "", ""
+		l.ExpiresAt = now
+	})
+	return err
+}
+
+// update applies fn to the term of c holding fence, while it lasts.
+func (e *Elections) update(ctx context.Context, c Candidate, fence uint64, fn func(*Leader, time.Time)) (*Leader, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	key := electionKey(c.ReefID, c.ColonyID, c.Name)
+	for range maxWriteAttempts {
+		cur, rev, err := e.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		if cur == nil || !cur.live(now) || !cur.leads(c) || cur.Fence != fence {
+			return nil, fmt.Errorf("%w: term %d of election %s has ended", ErrNotLeader, fence, c.Name)
+		}
+		fn(cur, now)
+		err = e.put(ctx, key, rev, cur)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return cur, nil
+	}
+	return nil, fmt.Errorf("election %s: too many concurrent writes", c.Name)
+}
+
+// Leader returns the current term of the election name of colonyID in
+// reefID, or ErrNoLeader.
+func (e *Elections) Leader(ctx context.Context, reefID, colonyID, name string) (*Leader, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	l, _, err := e.get(ctx, electionKey(reefID, colonyID, name))
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || !l.live(time.Now()) {
+		return nil, fmt.Errorf("%w: %s", ErrNoLeader, name)
+	}
+	return l, nil
+}
+
+// get reads the election at key, returning nil for elections never held.
+func (e *Elections) get(ctx context.Context, key string) (*Leader, uint64, error) {
+	entry, err := e.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var l Leader
+	if err := json.Unmarshal(entry.Value, &l); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode election: %w", err)
+	}
+	return &l, entry.Revision, nil
+}
+
+// put writes l at key if it is still at revision rev.
+func (e *Elections) put(ctx context.Context, key string, rev uint64, l *Leader) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = e.store.CompareAndSwap(ctx, key, rev, data)
+	return err
+}
+
+// live reports whether somebody leads at now.
+func (l *Leader) live(now time.Time) bool {
+	return l.AgentID != "" && now.Before(l.ExpiresAt)
+}
+
+// leads reports whether l is a term of c.
+func (l *Leader) leads(c Candidate) bool {
+	return l.AgentID == c.AgentID && l.Session == c.Session
+}
+
+func (c Candidate) validate() error {
+	if c.ReefID == "" || c.ColonyID == "" || c.AgentID == "" || strings.Contains(c.ReefID, "/") || strings.Contains(c.ColonyID, "/") {
+		return fmt.Errorf("%w: candidate agent, colony and reef are required", ErrInvalid)
+	}
+	if len(c.Session) > 64 {
+		return fmt.Errorf("%w: session longer than 64 bytes", ErrInvalid)
+	}
+	return validateName(c.Name)
+}
+
+// validateName accepts names of printable characters without spaces,
+// such as "colony/x/leader".
+func validateName(name string) error {
+	if name == "" || len(name) > MaxNameSize {
+		return fmt.Errorf("%w: name must be 1 to %d bytes", ErrInvalid, MaxNameSize)
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) || unicode.IsSpace(r) }) >= 0 {
+		return fmt.Errorf("%w: name %q holds spaces or control characters", ErrInvalid, name)
+	}
+	return nil
+}
+
+func electionKey(reefID, colonyID, name string) string {
+	return keyPrefix + reefID + "/" + colonyID + "/" + name
+}
@@ -0,0 +1,192 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+)
+
+func candidate(agent string) election.Candidate {
+	return election.Candidate{ReefID: "reef", ColonyID: "colony", AgentID: agent, Name: "leader"}
+}
+
+func campaign(t *testing.T, e *election.Elections, c election.Candidate, ttl, wait time.Duration) *election.Leader {
+	t.Helper()
+	l, err := e.Campaign(context.Background(), c, ttl, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestCampaign(t *testing.T) {
+	ctx := context.Background()
+	e := election.New(election.Config{})
+	a, b := candidate("a"), candidate("b")
+
+	term := campaign(t, e, a, 0, 0)
+	if term.AgentID != "a" || term.Fence != 1 || term.TTLSeconds != int(election.DefaultTTL/time.Second) {
+		t.Fatalf("first term %+v, want a leading with fence 1", term)
+	}
+	if again := campaign(t, e, a, 0, 0); again.Fence != term.Fence {
+		t.Fatalf("leader campaigning again got fence %d, want %d", again.Fence, term.Fence)
+	}
+	if l := campaign(t, e, b, 0, 0); l.AgentID != "a" {
+		t.Fatalf("b campaigning while a leads: leader %q", l.AgentID)
+	}
+	// Another instance of a is another candidate.
+	other := a
+	other.Session = "second"
+	if l := campaign(t, e, other, 0, 0); l.Session != "" {
+		t.Fatalf("second session of a took the lead: %+v", l)
+	}
+	if l, err := e.Leader(ctx, "reef", "colony", "leader"); err != nil || l.AgentID != "a" {
+		t.Fatalf("Leader: %+v, %v; want a", l, err)
+	}
+
+	renewed, err := e.Renew(ctx, a, term.Fence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Fence != term.Fence || renewed.ExpiresAt.Before(term.ExpiresAt) {
+		t.Fatalf("renewed term %+v of %+v", renewed, term)
+	}
+	if _, err := e.Renew(ctx, b, term.Fence); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("renewal by b: %v, want ErrNotLeader", err)
+	}
+	if _, err := e.Renew(ctx, a, term.Fence+1); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("renewal with a later fence: %v, want ErrNotLeader", err)
+	}
+	if err := e.Resign(ctx, b, term.Fence); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("resignation by b: %v, want ErrNotLeader", err)
+	}
+
+	if err := e.Resign(ctx, a, term.Fence); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Leader(ctx, "reef", "colony", "leader"); !errors.Is(err, election.ErrNoLeader) {
+		t.Fatalf("Leader after resigning: %v, want ErrNoLeader", err)
+	}
+	if _, err := e.Renew(ctx, a, term.Fence); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("renewal after resigning: %v, want ErrNotLeader", err)
+	}
+	if next := campaign(t, e, b, 0, 0); next.AgentID != "b" || next.Fence != term.Fence+1 {
+		t.Fatalf("term after a resigned: %+v, want b with fence %d", next, term.Fence+1)
+	}
+
+	// Elections of other colonies and reefs are apart.
+	elsewhere := a
+	elsewhere.ColonyID = "other"
+	if l := campaign(t, e, elsewhere, 0, 0); l.AgentID != "a" || l.Fence != 1 {
+		t.Fatalf("election of another colony: %+v, want a with fence 1", l)
+	}
+}
+
+// TestCampaignWait checks that a waiting candidate takes the lead as
+// soon as the leader resigns.
+func TestCampaignWait(t *testing.T) {
+	ctx := context.Background()
+	e := election.New(election.Config{})
+	term := campaign(t, e, candidate("a"), 0, 0)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := e.Resign(ctx, candidate("a"), term.Fence); err != nil {
+			t.Error(err)
+		}
+	}()
+	start := time.Now()
+	next := campaign(t, e, candidate("b"), 0, 10*time.Second)
+	if next.AgentID != "b" || next.Fence != term.Fence+1 {
+		t.Fatalf("waiting candidate got %+v, want b with fence %d", next, term.Fence+1)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("took the lead after %v, long after the resignation", d)
+	}
+}
+
+// TestExpiry checks that a candidate takes over once the leader's lease
+// runs out, and that the old leader can no longer renew.
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	e := election.New(election.Config{})
+	term := campaign(t, e, candidate("a"), election.MinTTL, 0)
+
+	next := campaign(t, e, candidate("b"), 0, 10*time.Second)
+	if next.AgentID != "b" || next.Fence <= term.Fence {
+		t.Fatalf("term after the lease expired: %+v, want b with a fence above %d", next, term.Fence)
+	}
+	if time.Now().Before(term.ExpiresAt) {
+		t.Fatalf("b took the lead before a's lease expired at %v", term.ExpiresAt)
+	}
+	if _, err := e.Renew(ctx, candidate("a"), term.Fence); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("renewal of an expired term: %v, want ErrNotLeader", err)
+	}
+	if err := e.Resign(ctx, candidate("a"), term.Fence); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("resignation of an expired term: %v, want ErrNotLeader", err)
+	}
+}
+
+// TestFences has candidates campaign concurrently, term after term, and
+// checks that each term has one leader and a greater fence than the
+// last.
+func TestFences(t *testing.T) {
+	ctx := context.Background()
+	e := election.New(election.Config{})
+	var last uint64
+	for range 10 {
+		var wg sync.WaitGroup
+		terms := make([]*election.Leader, 8)
+		for i := range terms {
+			wg.Go(func() {
+				l, err := e.Campaign(ctx, candidate(fmt.Sprint("agent-", i)), 0, 0)
+				if err != nil {
+					t.Error(err)
+				}
+				terms[i] = l
+			})
+		}
+		wg.Wait()
+		if t.Failed() {
+			t.FailNow()
+		}
+		leader := terms[0]
+		for _, l := range terms {
+			if l.AgentID != leader.AgentID || l.Fence != leader.Fence {
+				t.Fatalf("candidates saw leaders %s (fence %d) and %s (fence %d)", leader.AgentID, leader.Fence, l.AgentID, l.Fence)
+			}
+		}
+		if leader.Fence <= last {
+			t.Fatalf("fence %d after %d", leader.Fence, last)
+		}
+		last = leader.Fence
+		if err := e.Resign(ctx, candidate(leader.AgentID), leader.Fence); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	ctx := context.Background()
+	e := election.New(election.Config{})
+	for name, c := range map[string]election.Candidate{
+		"no agent":      {ReefID: "reef", ColonyID: "colony", Name: "leader"},
+		"slash in reef": {ReefID: "a/b", ColonyID: "colony", AgentID: "a", Name: "leader"},
+		"no name":       {ReefID: "reef", ColonyID: "colony", AgentID: "a"},
+		"space in name": {ReefID: "reef", ColonyID: "colony", AgentID: "a", Name: "the leader"},
+	} {
+		if _, err := e.Campaign(ctx, c, 0, 0); !errors.Is(err, election.ErrInvalid) {
+			t.Errorf("%s: %v, want ErrInvalid", name, err)
+		}
+	}
+	for _, ttl := range []time.Duration{time.Millisecond, 1500 * time.Millisecond, election.MaxTTL + time.Second} {
+		if _, err := e.Campaign(ctx, candidate("a"), ttl, 0); !errors.Is(err, election.ErrInvalid) {
+			t.Errorf("ttl %v: %v, want ErrInvalid", ttl, err)
+		}
+	}
+}
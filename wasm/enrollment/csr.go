@@ -0,0 +1,88 @@
+package enrollment
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+)
+
+// CSR is an enrollment request, the counterpart of a PKCS#10 certificate
+// signing request: the agent's public key and labels, signed with the
+// matching private key to prove possession. The identity it asks for
+// comes from the enrollment ticket, not the CSR.
+type CSR struct {
+	// PublicKey is the agent's base64 Ed25519 public key.
+	PublicKey string `json:"public_key"`
+
+	// Labels are shown to approvers and copied into the enrollment.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Signature is the base64url Ed25519 signature over the CSR's
+	// signing input by the key.
+	Signature string `json:"signature"`
+}
+
+// csrBody is what a CSR signature covers. Naming the identity binds the
+// CSR to one agent, so it cannot be replayed under another ticket.
+type csrBody struct {
+	Type      string            `json:"typ"`
+	ReefID    string            `json:"reef_id"`
+	ColonyID  string            `json:"colony_id"`
+	AgentID   string            `json:"agent_id"`
+	PublicKey string            `json:"public_key"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// csrType separates CSR signatures from other uses of agent keys.
+const csrType = "coral-csr"
+
+// NewCSR signs a CSR for key as agentID of colonyID in reefID.
+func NewCSR(key ed25519.PrivateKey, reefID, colonyID, agentID string, labels map[string]string) (*CSR, error) {
+	c := &CSR{
+		PublicKey: keys.EncodePublicKey(key.Public().(ed25519.PublicKey)),
+		Labels:    labels,
+	}
+	input, err := c.signingInput(reefID, colonyID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	c.Signature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, input))
+	return c, nil
+}
+
+// Verify checks that c is signed by its key for the given identity and
+// returns the key.
+func (c *CSR) Verify(reefID, colonyID, agentID string) (ed25519.PublicKey, error) {
+	pub, err := keys.DecodePublicKey(c.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: public key: %v", ErrInvalidCSR, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidCSR, err)
+	}
+	input, err := c.signingInput(reefID, colonyID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, input, sig) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCSR)
+	}
+	return pub, nil
+}
+
+// signingInput is the JSON of the CSR's csrBody. Map keys are sorted by
+// encoding/json, so the encoding is deterministic.
+func (c *CSR) signingInput(reefID, colonyID, agentID string) ([]byte, error) {
+	return json.Marshal(csrBody{
+		Type:      csrType,
+		ReefID:    reefID,
+		ColonyID:  colonyID,
+		AgentID:   agentID,
+		PublicKey: c.PublicKey,
+		Labels:    c.Labels,
+	})
+}
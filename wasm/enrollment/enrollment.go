@@ -0,0 +1,490 @@
+// Package enrollment gates registration behind an approval queue. A new
+// agent submits a CSR under a ticket with the "enroll" intent; the
+// enrollment waits until an admin, or an auto-approval rule, approves it.
+// Approval records the agent's key as its long-lived identity and issues
+// a registration ticket bound to that key (see package pop). Once
+// enrollment is enabled, registration tickets are only accepted when
+// bound to the key their agent enrolled with, so holding a referral
+// ticket no longer suffices to join a colony.
+package enrollment
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/caveat"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// IntentEnroll is the ticket intent agents submit enrollments with.
+const IntentEnroll = "enroll"
+
+// Defaults for Config.
+const (
+	// DefaultIdentityTTL is the lifetime of identity tickets.
+	DefaultIdentityTTL = 12 * time.Hour
+
+	// DefaultPendingTTL is how long an enrollment may wait for a
+	// decision, and how long decided ones are kept.
+	DefaultPendingTTL = 7 * 24 * time.Hour
+)
+
+// Store key prefixes. Enrollments are stored by ID; identities, the
+// approved key of each agent, by reef, colony and agent.
+const (
+	enrollmentPrefix = "enrollment/requests/"
+	identityPrefix   = "enrollment/identities/"
+)
+
+// Enrollment states.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+var (
+	// ErrNotFound is returned for unknown or pruned enrollments.
+	ErrNotFound = errors.New("enrollment not found")
+
+	// ErrInvalidCSR is returned for CSRs that are malformed or not signed
+	// by their key.
+	ErrInvalidCSR = errors.New("invalid CSR")
+
+	// ErrDecided is returned when approving or denying an enrollment that
+	// was already decided, or reissuing one that was not approved.
+	ErrDecided = errors.New("enrollment already decided")
+
+	// ErrNotEnrolled is returned for registration tickets of agents that
+	// have not enrolled, or that are not bound to the enrolled key.
+	ErrNotEnrolled = errors.New("agent is not enrolled")
+)
+
+// Enrollment is an agent's request to join a colony and its outcome.
+type Enrollment struct {
+	ID       string `json:"id"`
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	AgentID  string `json:"agent_id"`
+
+	// PublicKey is the agent's base64 Ed25519 key from the CSR.
+	PublicKey string            `json:"public_key"`
+	Labels    map[string]string `json:"labels,omitempty"`
+
+	Status      string    `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"`
+
+	// DecidedBy is the agent ID of the approving or denying admin, or
+	// "auto" for auto-approvals.
+	DecidedBy string    `json:"decided_by,omitempty"`
+	DecidedAt time.Time `json:"decided_at,omitzero"`
+	Reason    string    `json:"reason,omitempty"`
+
+	// Identity is the registration ticket issued on approval, bound to
+	// PublicKey. It is useless without the private key, so it is
+	// returned to anyone holding the enrollment ID.
+	Identity          string    `json:"identity,omitempty"`
+	IdentityExpiresAt time.Time `json:"identity_expires_at,omitzero"`
+}
+
+// identity is the enrolled key of an agent.
+type identity struct {
+	EnrollmentID string `json:"enrollment_id"`
+	Thumbprint   string `json:"jkt"`
+}
+
+// Verifier validates referral tickets. registry.Verifier satisfies it.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Config holds the configuration for an Enroller.
+type Config struct {
+	// Signer returns the key identity tickets are signed with, which must
+	// verify wherever registrations do. Required.
+	Signer func() (signer.Signer, error)
+
+	// Store holds enrollments and identities. Defaults to an in-memory
+	// store; use the registry's store so that replicas share them.
+	Store store.Store
+
+	// IdentityTTL is the lifetime of identity tickets. Defaults to
+	// DefaultIdentityTTL. With rotating signing keys it must be shorter
+	// than the key overlap.
+	IdentityTTL time.Duration
+
+	// PendingTTL is how long enrollments are kept. Defaults to
+	// DefaultPendingTTL.
+	PendingTTL time.Duration
+
+	// AutoApprove approves matching enrollments on submission. Nil
+	// leaves every enrollment to an admin.
+	AutoApprove func(*Enrollment) bool
+
+	// Clock is the time source. Defaults to clock.System.
+	Clock clock.Clock
+}
+
+// AutoApproveColonies returns a Config.AutoApprove rule approving the
+// enrollments of colonies, or of every colony when colonies holds "*".
+func AutoApproveColonies(colonies []string) func(*Enrollment) bool {
+	return func(e *Enrollment) bool {
+		return slices.Contains(colonies, "*") || slices.Contains(colonies, e.ColonyID)
+	}
+}
+
+// Enroller runs the enrollment queue.
+type Enroller struct {
+	signer      func() (signer.Signer, error)
+	store       store.Store
+	identityTTL time.Duration
+	pendingTTL  time.Duration
+	autoApprove func(*Enrollment) bool
+	clock       clock.Clock
+}
+
+// New creates an Enroller from cfg.
+func New(cfg Config) (*Enroller, error) {
+	if cfg.Signer == nil {
+		return nil, errors.New("enrollment: Signer is required")
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	identityTTL := cfg.IdentityTTL
+	if identityTTL <= 0 {
+		identityTTL = DefaultIdentityTTL
+	}
+	pendingTTL := cfg.PendingTTL
+	if pendingTTL <= 0 {
+		pendingTTL = DefaultPendingTTL
+	}
+	return &Enroller{
+		signer:      cfg.Signer,
+		store:       st,
+		identityTTL: identityTTL,
+		pendingTTL:  pendingTTL,
+		autoApprove: cfg.AutoApprove,
+		clock:       clock.Or(cfg.Clock),
+	}, nil
+}
+
+// Submit queues csr for the agent named by claims, the verified claims
+// of an enrollment ticket. Auto-approved enrollments are returned
+// approved, with their identity.
+func (e *Enroller) Submit(ctx context.Context, claims *jwt.ReferralClaims, csr *CSR) (*Enrollment, error) {
+	if claims.ReefID == "" || claims.ColonyID == "" || claims.AgentID == "" {
+		return nil, fmt.Errorf("%w: enrollment ticket must name a reef, colony and agent", ErrInvalidCSR)
+	}
+	if _, err := csr.Verify(claims.ReefID, claims.ColonyID, claims.AgentID); err != nil {
+		return nil, err
+	}
+
+	en := &Enrollment{
+		ID:          uuid.New().String(),
+		ReefID:      claims.ReefID,
+		ColonyID:    claims.ColonyID,
+		AgentID:     claims.AgentID,
+		PublicKey:   csr.PublicKey,
+		Labels:      csr.Labels,
+		Status:      StatusPending,
+		SubmittedAt: e.clock.Now().UTC(),
+	}
+	if e.autoApprove != nil && e.autoApprove(en) {
+		if err := e.approve(ctx, en, "auto"); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.save(ctx, en, 0); err != nil {
+		return nil, err
+	}
+	return en, nil
+}
+
+// Get returns the enrollment with id.
+func (e *Enroller) Get(ctx context.Context, id string) (*Enrollment, error) {
+	en, _, err := e.load(ctx, id)
+	return en, err
+}
+
+// List returns the enrollments of reefID, oldest first, optionally only
+// those with status.
+func (e *Enroller) List(ctx context.Context, reefID, status string) ([]*Enrollment, error) {
+	entries, err := e.store.List(ctx, enrollmentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Enrollment
+	for _, entry := range entries {
+		var en Enrollment
+		if err := json.Unmarshal(entry.Value, &en); err != nil {
+			continue
+		}
+		if en.ReefID != reefID || (status != "" && en.Status != status) {
+			continue
+		}
+		out = append(out, &en)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SubmittedAt.Before(out[j].SubmittedAt) })
+	return out, nil
+}
+
+// Approve approves the pending enrollment id of reefID on behalf of
+// approver, enrolling the agent's key and issuing its identity ticket. A
+// key enrolled earlier for the same agent is replaced.
+func (e *Enroller) Approve(ctx context.Context, reefID, id, approver string) (*Enrollment, error) {
+	return e.decide(ctx, reefID, id, func(en *Enrollment) error {
+		return e.approve(ctx, en, approver)
+	})
+}
+
+// Deny denies the pending enrollment id of reefID on behalf of approver.
+func (e *Enroller) Deny(ctx context.Context, reefID, id, approver, reason string) (*Enrollment, error) {
+	return e.decide(ctx, reefID, id, func(en *Enrollment) error {
+		en.Status, en.DecidedBy, en.DecidedAt, en.Reason = StatusDenied, approver, e.clock.Now().UTC(), reason
+		return nil
+	})
+}
+
+// Reissue issues a fresh identity ticket for the approved enrollment id
+// to the holder of ticket, a verified identity ticket of the same
+// enrollment. Bound tickets need a proof of possession wherever they are
+// presented, so only the enrolled key holder can reissue.
+func (e *Enroller) Reissue(ctx context.Context, id, ticket string) (*Enrollment, error) {
+	jkt, err := pop.Confirmed(ticket)
+	if err != nil {
+		return nil, err
+	}
+	return e.update(ctx, id, func(en *Enrollment) error {
+		if en.Status != StatusApproved {
+			return fmt.Errorf("%w: enrollment is %s", ErrDecided, en.Status)
+		}
+		pub, err := keys.DecodePublicKey(en.PublicKey)
+		if err != nil {
+			return err
+		}
+		if jkt == "" || jkt != pop.Thumbprint(pub) {
+			return fmt.Errorf("%w: ticket is not bound to the enrolled key", ErrNotEnrolled)
+		}
+		return e.issue(ctx, en, pub)
+	})
+}
+
+// Enrolled returns nil when agentID of colonyID in reefID enrolled the
+// key with RFC 7638 thumbprint jkt, and ErrNotEnrolled otherwise.
+func (e *Enroller) Enrolled(ctx context.Context, reefID, colonyID, agentID, jkt string) error {
+	entry, err := e.store.Get(ctx, identityKey(reefID, colonyID, agentID))
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotEnrolled, agentID)
+	}
+	if err != nil {
+		return err
+	}
+	var id identity
+	if err := json.Unmarshal(entry.Value, &id); err != nil {
+		return fmt.Errorf("failed to decode identity of %s: %w", agentID, err)
+	}
+	if jkt == "" || jkt != id.Thumbprint {
+		return fmt.Errorf("%w: ticket for %s is not bound to its enrolled key", ErrNotEnrolled, agentID)
+	}
+	return nil
+}
+
+// Verifier wraps inner so that registration tickets are only accepted
+// for enrolled agents and when bound to their enrolled key. Tickets with
+// other intents pass through.
+func (e *Enroller) Verifier(inner Verifier) Verifier {
+	return &verifier{enroller: e, inner: inner}
+}
+
+type verifier struct {
+	enroller *Enroller
+	inner    Verifier
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil || claims.Intent != registry.IntentRegister {
+		return claims, err
+	}
+	// Attenuable tokens are not JWTs and are never bound.
+	var jkt string
+	if !caveat.IsToken(tokenString) {
+		if jkt, err = pop.Confirmed(tokenString); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.enroller.Enrolled(context.Background(), claims.ReefID, claims.ColonyID, claims.AgentID, jkt); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Prune deletes enrollments older than the pending TTL and returns how
+// many were removed. Identities of approved agents are kept.
+func (e *Enroller) Prune(ctx context.Context) (int, error) {
+	entries, err := e.store.List(ctx, enrollmentPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := e.clock.Now().Add(-e.pendingTTL)
+	pruned := 0
+	for _, entry := range entries {
+		var en Enrollment
+		if err := json.Unmarshal(entry.Value, &en); err == nil && en.SubmittedAt.After(cutoff) {
+			continue
+		}
+		if err := e.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (e *Enroller) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = e.Prune(ctx)
+		}
+	}
+}
+
+// decide applies fn to the pending enrollment id of reefID.
+func (e *Enroller) decide(ctx context.Context, reefID, id string, fn func(*Enrollment) error) (*Enrollment, error) {
+	return e.update(ctx, id, func(en *Enrollment) error {
+		if en.ReefID != reefID {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		if en.Status != StatusPending {
+			return fmt.Errorf("%w: enrollment is %s", ErrDecided, en.Status)
+		}
+		return fn(en)
+	})
+}
+
+// approve enrolls en's key and issues its identity.
+func (e *Enroller) approve(ctx context.Context, en *Enrollment, approver string) error {
+	pub, err := keys.DecodePublicKey(en.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: public key: %v", ErrInvalidCSR, err)
+	}
+	if err := e.issue(ctx, en, pub); err != nil {
+		return err
+	}
+	data, err := json.Marshal(identity{EnrollmentID: en.ID, Thumbprint: pop.Thumbprint(pub)})
+	if err != nil {
+		return err
+	}
+	if _, err := e.store.Put(ctx, identityKey(en.ReefID, en.ColonyID, en.AgentID), data); err != nil {
+		return err
+	}
+	en.Status, en.DecidedBy, en.DecidedAt = StatusApproved, approver, e.clock.Now().UTC()
+	return nil
+}
+
+// issue signs a registration ticket for en bound to pub.
+func (e *Enroller) issue(ctx context.Context, en *Enrollment, pub ed25519.PublicKey) error {
+	s, err := e.signer()
+	if err != nil {
+		return fmt.Errorf("no signing key: %w", err)
+	}
+	now := e.clock.Now()
+	expiresAt := now.Add(e.identityTTL)
+	claims := &pop.Claims{ReferralClaims: jwt.ReferralClaims{
+		ReefID:   en.ReefID,
+		ColonyID: en.ColonyID,
+		AgentID:  en.AgentID,
+		Intent:   registry.IntentRegister,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			NotBefore: gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expiresAt),
+		},
+	}}
+	pop.Bind(claims, pub)
+	if en.Identity, err = signer.SignToken(ctx, s, claims); err != nil {
+		return err
+	}
+	en.IdentityExpiresAt = expiresAt.UTC()
+	return nil
+}
+
+// load reads an enrollment and its revision.
+func (e *Enroller) load(ctx context.Context, id string) (*Enrollment, uint64, error) {
+	entry, err := e.store.Get(ctx, enrollmentPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var en Enrollment
+	if err := json.Unmarshal(entry.Value, &en); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode enrollment %s: %w", id, err)
+	}
+	return &en, entry.Revision, nil
+}
+
+// save writes en if its stored revision is still revision.
+func (e *Enroller) save(ctx context.Context, en *Enrollment, revision uint64) error {
+	data, err := json.Marshal(en)
+	if err != nil {
+		return err
+	}
+	_, err = e.store.CompareAndSwap(ctx, enrollmentPrefix+en.ID, revision, data)
+	return err
+}
+
+// update applies fn to enrollment id, retrying on concurrent writes.
+func (e *Enroller) update(ctx context.Context, id string, fn func(*Enrollment) error) (*Enrollment, error) {
+	for {
+		en, rev, err := e.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(en); err != nil {
+			return nil, err
+		}
+		err = e.save(ctx, en, rev)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return en, nil
+	}
+}
+
+// identityKey is the store key of an agent's enrolled key.
+func identityKey(reefID, colonyID, agentID string) string {
+	return identityPrefix + reefID + "/" + colonyID + "/" + agentID
+}
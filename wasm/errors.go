@@ -0,0 +1,84 @@
+//go:build tinygo.wasm || js
+
+package main
+
+// Error codes returned by coralCrypto exports as { error: { code, ... } }.
+const (
+	errInvalidArgument = "ERR_INVALID_ARGUMENT"
+	errKeyDecode       = "ERR_KEY_DECODE"
+	errKeyGenerate     = "ERR_KEY_GENERATE"
+	errKeyUnseal       = "ERR_KEY_UNSEAL"
+	errJWKSMalformed   = "ERR_JWKS_MALFORMED"
+	errKidUnknown      = "ERR_KID_UNKNOWN"
+	errTokenMalformed  = "ERR_TOKEN_MALFORMED"
+	errTokenExpired    = "ERR_TOKEN_EXPIRED"
+	errTokenNotYet     = "ERR_TOKEN_NOT_YET_VALID"
+	errTokenSign       = "ERR_TOKEN_SIGN"
+	errTokenRevoked    = "ERR_TOKEN_REVOKED"
+	errTokenReplayed   = "ERR_TOKEN_REPLAYED"
+	errTokenClaims     = "ERR_TOKEN_CLAIMS"
+	errDelegation      = "ERR_DELEGATION"
+	errProof           = "ERR_PROOF"
+	errThreshold       = "ERR_THRESHOLD"
+	errCredential      = "ERR_CREDENTIAL"
+	errPolicyDenied    = "ERR_POLICY_DENIED"
+	errNotFound        = "ERR_NOT_FOUND"
+	errUnauthorized    = "ERR_UNAUTHORIZED"
+	errLeaseExpired    = "ERR_LEASE_EXPIRED"
+	errRateLimited     = "ERR_RATE_LIMITED"
+	errQuotaExceeded   = "ERR_QUOTA_EXCEEDED"
+	errStaleRecord     = "ERR_STALE_RECORD"
+	errResyncRequired  = "ERR_RESYNC_REQUIRED"
+	errInvalidMetadata = "ERR_INVALID_METADATA"
+	errLockUnavailable = "ERR_LOCK_UNAVAILABLE"
+	errLockNotHeld     = "ERR_LOCK_NOT_HELD"
+	errFetch           = "ERR_FETCH"
+	errInternal        = "ERR_INTERNAL"
+)
+
+// retryableCodes lists codes where the caller may succeed by retrying,
+// possibly after refreshing state such as the JWKS cache.
+var retryableCodes = map[string]bool{
+	errKidUnknown:      true,
+	errFetch:           true,
+	errRateLimited:     true,
+	errLockUnavailable: true,
+	errInternal:        true,
+}
+
+// exportError is the structured error surfaced to JavaScript.
+type exportError struct {
+	Code      string
+	Message   string
+	Retryable bool
+}
+
+func (e *exportError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newError creates an exportError, deriving retryability from the code.
+func newError(code, message string) *exportError {
+	return &exportError{Code: code, Message: message, Retryable: retryableCodes[code]}
+}
+
+// toJS converts the error into the { code, message, retryable } object.
+func (e *exportError) toJS() map[string]interface{} {
+	return map[string]interface{}{
+		"code":      e.Code,
+		"message":   e.Message,
+		"retryable": e.Retryable,
+	}
+}
+
+// errorResult builds the { error: { code, message, retryable } } result.
+func errorResult(code, message string) map[string]interface{} {
+	return newError(code, message).result()
+}
+
+// result wraps the error in an export result object.
+func (e *exportError) result() map[string]interface{} {
+	return map[string]interface{}{
+		"error": e.toJS(),
+	}
+}
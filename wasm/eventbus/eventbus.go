@@ -0,0 +1,180 @@
+// Package eventbus exports registry changes to a streaming platform. An
+// Exporter watches the registry and publishes every change, as a
+// versioned Message, through a Publisher: NATS, optionally with
+// JetStream acknowledgements, or Kafka.
+//
+// Messages are published in the order the registry emits them. A
+// failed publish is retried with backoff until it succeeds, holding up
+// the messages behind it; if the registry watch falls behind meanwhile,
+// it resumes after the last change published, and only changes past
+// the registry's watch retention are lost. Consumers that need the full
+// state should reconcile against a listing.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Schema names the version of the Message layout. It changes only when
+// fields are removed or change meaning; added fields keep it.
+const Schema = "coral.registry.event/v1"
+
+// Message headers, set alongside the body by both publishers.
+const (
+	// SchemaHeader carries Schema.
+	SchemaHeader = "Coral-Schema"
+
+	// TypeHeader carries the Message type.
+	TypeHeader = "Coral-Event-Type"
+)
+
+// DefaultTopic is the NATS subject prefix or Kafka topic.
+const DefaultTopic = "coral.registry"
+
+// Exporter defaults.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Message is a registry change as published.
+type Message struct {
+	Schema string    `json:"schema"`
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+
+	// Replica is the registry's ReplicaID, when replicated.
+	Replica string `json:"replica,omitempty"`
+
+	Record *registry.Record `json:"record"`
+}
+
+// Key is the partition key of m, which keeps the changes of one agent in
+// order.
+func (m *Message) Key() string {
+	return m.Record.ReefID + "/" + m.Record.ColonyID + "/" + m.Record.AgentID
+}
+
+// Publisher sends messages to a streaming platform.
+type Publisher interface {
+	// Publish sends m, returning once the platform has accepted it.
+	Publish(ctx context.Context, m *Message) error
+
+	// Close releases the publisher's connections.
+	Close() error
+}
+
+// Config holds the configuration for an Exporter.
+type Config struct {
+	// Registry is watched for changes. Required.
+	Registry *registry.Registry
+
+	// Publisher sends them. Required.
+	Publisher Publisher
+
+	// Filter selects the records exported. Defaults to all of them.
+	Filter registry.Filter
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// attempts to publish a message. Default to DefaultMinBackoff and
+	// DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Clock stamps messages. Defaults to clock.System.
+	Clock clock.Clock
+}
+
+// Exporter publishes registry changes.
+type Exporter struct {
+	registry   *registry.Registry
+	publisher  Publisher
+	filter     registry.Filter
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	running bool
+}
+
+// New creates an Exporter from cfg.
+func New(cfg Config) (*Exporter, error) {
+	if cfg.Registry == nil {
+		return nil, errors.New("eventbus: registry is required")
+	}
+	if cfg.Publisher == nil {
+		return nil, errors.New("eventbus: publisher is required")
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Exporter{
+		registry:   cfg.Registry,
+		publisher:  cfg.Publisher,
+		filter:     cfg.Filter,
+		minBackoff: cfg.MinBackoff,
+		maxBackoff: cfg.MaxBackoff,
+		clock:      clock.Or(cfg.Clock),
+	}, nil
+}
+
+// Run publishes registry changes until ctx is done, then closes the
+// publisher.
+func (e *Exporter) Run(ctx context.Context) {
+	e.mu.Lock()
+	if e.running {
+		e.mu.Unlock()
+		return
+	}
+	e.running = true
+	e.mu.Unlock()
+	defer e.publisher.Close()
+
+	e.registry.Follow(ctx, e.filter, func(ev registry.Event) {
+		e.publish(ctx, &Message{
+			Schema:  Schema,
+			ID:      uuid.New().String(),
+			Type:    ev.Type.String(),
+			Time:    e.clock.Now().UTC(),
+			Replica: e.registry.ReplicaID(),
+			Record:  ev.Record,
+		})
+	})
+}
+
+// publish sends m, retrying with backoff until it is accepted or ctx is
+// done.
+func (e *Exporter) publish(ctx context.Context, m *Message) {
+	backoff := e.minBackoff
+	for e.publisher.Publish(ctx, m) != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, e.maxBackoff)
+	}
+}
+
+// encode returns the body and headers of m.
+func encode(m *Message) ([]byte, [][2]string, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, [][2]string{{SchemaHeader, m.Schema}, {TypeHeader, m.Type}}, nil
+}
@@ -0,0 +1,497 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka API keys and the versions spoken.
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 4
+)
+
+// kafkaAcksAll waits for every in-sync replica to accept a message.
+const kafkaAcksAll = -1
+
+// KafkaConfig holds the configuration for a Kafka publisher.
+type KafkaConfig struct {
+	// Brokers are host:port addresses the cluster metadata is read
+	// from. Required.
+	Brokers []string
+
+	// Topic receives every message, keyed by Message.Key. Defaults to
+	// DefaultTopic.
+	Topic string
+
+	// ClientID names the publisher in broker logs. Defaults to
+	// "coral-eventbus".
+	ClientID string
+
+	// TLS, when set, encrypts broker connections.
+	TLS *tls.Config
+
+	// Timeout bounds connecting and each request. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// Kafka publishes messages to a Kafka topic over the Kafka protocol,
+// waiting for all in-sync replicas to accept each one. Messages are
+// partitioned by the murmur2 hash of their key, as the Java client's
+// default partitioner does, so that the changes of one agent stay in
+// order. Partition leaders are looked up on first use and again after an
+// error.
+type Kafka struct {
+	brokers  []string
+	topic    string
+	clientID string
+	tls      *tls.Config
+	timeout  time.Duration
+
+	mu          sync.Mutex
+	correlation int32
+	addrs       map[int32]string
+	leaders     map[int32]int32
+	partitions  []int32
+	conns       map[int32]net.Conn
+}
+
+// NewKafka creates a Kafka publisher from cfg.
+func NewKafka(cfg KafkaConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("eventbus: Kafka brokers are required")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = DefaultTopic
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "coral-eventbus"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Kafka{
+		brokers:  cfg.Brokers,
+		topic:    cfg.Topic,
+		clientID: cfg.ClientID,
+		tls:      cfg.TLS,
+		timeout:  cfg.Timeout,
+		conns:    make(map[int32]net.Conn),
+	}, nil
+}
+
+// Publish implements Publisher.
+func (k *Kafka) Publish(ctx context.Context, m *Message) error {
+	body, headers, err := encode(m)
+	if err != nil {
+		return err
+	}
+	key := []byte(m.Key())
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.partitions == nil {
+		if err := k.refresh(ctx); err != nil {
+			return err
+		}
+	}
+	partition := k.partitions[int(murmur2(key)&0x7fffffff)%len(k.partitions)]
+	leader := k.leaders[partition]
+	if err := k.produce(ctx, leader, partition, recordBatch(m.Time, key, body, headers)); err != nil {
+		k.drop(leader)
+		k.partitions = nil
+		return err
+	}
+	return nil
+}
+
+// Close implements Publisher.
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id := range k.conns {
+		k.drop(id)
+	}
+	return nil
+}
+
+// refresh reads the topic's partitions and their leaders from the first
+// bootstrap broker that answers.
+func (k *Kafka) refresh(ctx context.Context) error {
+	var req kafkaWriter
+	req.int32(1)
+	req.string(k.topic)
+	req.int8(0) // allow_auto_topic_creation
+	var errs []error
+	for _, addr := range k.brokers {
+		conn, err := k.dial(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := k.roundTrip(ctx, conn, kafkaMetadata, kafkaMetadataVersion, req.Bytes())
+		conn.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return k.parseMetadata(resp)
+	}
+	return fmt.Errorf("kafka: no broker answered: %w", errors.Join(errs...))
+}
+
+// parseMetadata records the brokers and partition leaders of a
+// Metadata v4 response.
+func (k *Kafka) parseMetadata(resp []byte) error {
+	r := kafkaReader{buf: resp}
+	r.int32() // throttle_time_ms
+	addrs := make(map[int32]string)
+	for range r.count() {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	leaders := make(map[int32]int32)
+	var partitions []int32
+	for range r.count() {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		if name == k.topic && code != 0 {
+			return fmt.Errorf("kafka: topic %s: error code %d", k.topic, code)
+		}
+		for range r.count() {
+			pcode := r.int16()
+			partition := r.int32()
+			leader := r.int32()
+			r.skipInt32s() // replica_nodes
+			r.skipInt32s() // isr_nodes
+			if name != k.topic {
+				continue
+			}
+			if pcode != 0 || leader < 0 {
+				return fmt.Errorf("kafka: partition %s/%d has no leader (error code %d)", k.topic, partition, pcode)
+			}
+			leaders[partition] = leader
+			partitions = append(partitions, partition)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("kafka: malformed metadata: %w", r.err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("kafka: topic %s not found", k.topic)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	k.addrs, k.leaders, k.partitions = addrs, leaders, partitions
+	return nil
+}
+
+// produce sends batch to partition on broker leader.
+func (k *Kafka) produce(ctx context.Context, leader, partition int32, batch []byte) error {
+	conn, err := k.conn(ctx, leader)
+	if err != nil {
+		return err
+	}
+	var req kafkaWriter
+	req.int16(-1) // transactional_id: null
+	req.int16(kafkaAcksAll)
+	req.int32(int32(k.timeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(batch)
+	resp, err := k.roundTrip(ctx, conn, kafkaProduce, kafkaProduceVersion, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r := kafkaReader{buf: resp}
+	for range r.count() {
+		r.string() // topic
+		for range r.count() {
+			p := r.int32()
+			code := r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time
+			if r.err == nil && code != 0 {
+				return fmt.Errorf("kafka: produce to %s/%d: error code %d", k.topic, p, code)
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("kafka: malformed produce response: %w", r.err)
+	}
+	return nil
+}
+
+// conn returns the connection to broker id, dialing it when needed.
+func (k *Kafka) conn(ctx context.Context, id int32) (net.Conn, error) {
+	if conn, ok := k.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := k.addrs[id]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", id)
+	}
+	conn, err := k.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[id] = conn
+	return conn, nil
+}
+
+// drop closes the connection to broker id.
+func (k *Kafka) drop(id int32) {
+	if conn, ok := k.conns[id]; ok {
+		conn.Close()
+		delete(k.conns, id)
+	}
+}
+
+func (k *Kafka) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: k.timeout}
+	if k.tls != nil {
+		td := &tls.Dialer{NetDialer: dialer, Config: k.tls}
+		return td.DialContext(ctx, "tcp", addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// roundTrip sends a request with a v1 header and returns the response
+// body following its correlation ID.
+func (k *Kafka) roundTrip(ctx context.Context, conn net.Conn, apiKey, version int16, body []byte) ([]byte, error) {
+	deadline := time.Now().Add(k.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	k.correlation++
+	var req kafkaWriter
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(k.correlation)
+	req.string(k.clientID)
+	req.Write(body)
+	data := req.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 1<<24 {
+		return nil, fmt.Errorf("kafka: invalid response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != k.correlation {
+		return nil, fmt.Errorf("kafka: response %d does not answer request %d", id, k.correlation)
+	}
+	return resp[4:], nil
+}
+
+// recordBatch encodes a v2 record batch holding one record.
+func recordBatch(ts time.Time, key, value []byte, headers [][2]string) []byte {
+	var rec kafkaWriter
+	rec.int8(0)   // attributes
+	rec.varint(0) // timestamp_delta
+	rec.varint(0) // offset_delta
+	rec.varbytes(key)
+	rec.varbytes(value)
+	rec.varint(int64(len(headers)))
+	for _, h := range headers {
+		rec.varbytes([]byte(h[0]))
+		rec.varbytes([]byte(h[1]))
+	}
+
+	// The CRC covers everything from the attributes on.
+	var tail kafkaWriter
+	tail.int16(0) // attributes
+	tail.int32(0) // last_offset_delta
+	tail.int64(ts.UnixMilli())
+	tail.int64(ts.UnixMilli())
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(1)
+	tail.varint(int64(rec.Len()))
+	tail.Write(rec.Bytes())
+
+	var batch kafkaWriter
+	batch.int64(0) // base_offset
+	batch.int32(int32(4 + 1 + 4 + tail.Len()))
+	batch.int32(-1) // partition_leader_epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(tail.Bytes(), crc32.MakeTable(crc32.Castagnoli))))
+	batch.Write(tail.Bytes())
+	return batch.Bytes()
+}
+
+// murmur2 is the hash of the Java client's default partitioner.
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	n := len(data)
+	h := uint32(seed) ^ uint32(n)
+	for i := 0; i+4 <= n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[n&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaWriter encodes Kafka protocol primitives.
+type kafkaWriter struct {
+	bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(v int8) { w.WriteByte(byte(v)) }
+
+func (w *kafkaWriter) int16(v int16) { w.Write(binary.BigEndian.AppendUint16(nil, uint16(v))) }
+
+func (w *kafkaWriter) int32(v int32) { w.Write(binary.BigEndian.AppendUint32(nil, uint32(v))) }
+
+func (w *kafkaWriter) int64(v int64) { w.Write(binary.BigEndian.AppendUint64(nil, uint64(v))) }
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.Write(b)
+}
+
+// varint writes a zig-zag varint, as records use.
+func (w *kafkaWriter) varint(v int64) { w.Write(binary.AppendVarint(nil, v)) }
+
+func (w *kafkaWriter) varbytes(b []byte) {
+	w.varint(int64(len(b)))
+	w.Write(b)
+}
+
+// kafkaReader decodes Kafka protocol primitives. The first error sticks,
+// and reads after it return zero values.
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, returning "" for null.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// count reads an array length, bounded by the bytes left so that a
+// malformed length cannot run away.
+func (r *kafkaReader) count() int {
+	n := int(r.int32())
+	if n < 0 || r.err != nil {
+		return 0
+	}
+	if n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
+
+func (r *kafkaReader) skipInt32s() {
+	r.next(4 * r.count())
+}
@@ -0,0 +1,334 @@
+package eventbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NATSConfig holds the configuration for a NATS publisher.
+type NATSConfig struct {
+	// URL is the server, as nats://[user:password@]host:port or
+	// tls://host:port. Required.
+	URL string
+
+	// Subject prefixes message subjects, which are the prefix, a
+	// period and the message type, for example coral.registry.put.
+	// Defaults to DefaultTopic.
+	Subject string
+
+	// JetStream waits for the stream capturing the subjects to
+	// acknowledge each message, and sets Nats-Msg-Id so that retried
+	// publishes are deduplicated. Without it, messages are acknowledged
+	// by the server alone and lost when nobody is subscribed.
+	JetStream bool
+
+	// Token authenticates with a token instead of the URL's user.
+	Token string
+
+	// TLS configures tls:// connections. Defaults to the system roots.
+	TLS *tls.Config
+
+	// Timeout bounds connecting and each acknowledgement. Defaults to
+	// 10s.
+	Timeout time.Duration
+}
+
+// NATS publishes messages to a NATS server over its text protocol. It
+// holds one connection, dialed on first use and again after an error.
+type NATS struct {
+	addr      string
+	secure    bool
+	user      string
+	password  string
+	token     string
+	subject   string
+	jetStream bool
+	tls       *tls.Config
+	timeout   time.Duration
+	inbox     string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewNATS creates a NATS publisher from cfg.
+func NewNATS(cfg NATSConfig) (*NATS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("eventbus: invalid NATS URL: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("eventbus: NATS URL scheme must be nats or tls, not %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("eventbus: NATS URL host is required")
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = DefaultTopic
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	n := &NATS{
+		addr:      addr,
+		secure:    u.Scheme == "tls",
+		token:     cfg.Token,
+		subject:   cfg.Subject,
+		jetStream: cfg.JetStream,
+		tls:       cfg.TLS,
+		timeout:   cfg.Timeout,
+		inbox:     "_INBOX." + strings.ReplaceAll(uuid.New().String(), "-", ""),
+	}
+	if u.User != nil {
+		n.user = u.User.Username()
+		n.password, _ = u.User.Password()
+	}
+	if n.tls == nil {
+		n.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	return n, nil
+}
+
+// Publish implements Publisher.
+func (n *NATS) Publish(ctx context.Context, m *Message) error {
+	body, headers, err := encode(m)
+	if err != nil {
+		return err
+	}
+	if n.jetStream {
+		headers = append(headers, [2]string{"Nats-Msg-Id", m.ID})
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.connect(ctx); err != nil {
+		return err
+	}
+	if err := n.publish(ctx, n.subject+"."+m.Type, headers, body); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close implements Publisher.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// connect dials the server unless connected, reading its INFO and
+// sending CONNECT, and subscribes to the JetStream reply inbox.
+func (n *NATS) connect(ctx context.Context) error {
+	if n.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: n.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if n.secure {
+		tc := tls.Client(conn, n.tls)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn, r = tc, bufio.NewReader(tc)
+	}
+	opts, err := json.Marshal(map[string]any{
+		"verbose":       false,
+		"pedantic":      false,
+		"lang":          "go",
+		"name":          "coral-eventbus",
+		"protocol":      1,
+		"headers":       true,
+		"no_responders": true,
+		"user":          n.user,
+		"pass":          n.password,
+		"auth_token":    n.token,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	cmd := "CONNECT " + string(opts) + "\r\n"
+	if n.jetStream {
+		cmd += "SUB " + n.inbox + ".* 1\r\n"
+	}
+	n.conn, n.r = conn, r
+	if _, err := conn.Write([]byte(cmd + "PING\r\n")); err != nil {
+		n.conn = nil
+		conn.Close()
+		return err
+	}
+	if _, _, err := n.await(""); err != nil {
+		n.conn = nil
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// publish sends one HPUB and waits for its acknowledgement: the
+// JetStream reply when enabled, or else the PONG to a PING behind it.
+func (n *NATS) publish(ctx context.Context, subject string, headers [][2]string, body []byte) error {
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	n.conn.SetDeadline(deadline)
+
+	var hdr bytes.Buffer
+	hdr.WriteString("NATS/1.0\r\n")
+	for _, h := range headers {
+		hdr.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	hdr.WriteString("\r\n")
+
+	var cmd bytes.Buffer
+	reply := ""
+	if n.jetStream {
+		reply = n.inbox + "." + strings.ReplaceAll(uuid.New().String(), "-", "")
+		fmt.Fprintf(&cmd, "HPUB %s %s %d %d\r\n", subject, reply, hdr.Len(), hdr.Len()+len(body))
+	} else {
+		fmt.Fprintf(&cmd, "HPUB %s %d %d\r\n", subject, hdr.Len(), hdr.Len()+len(body))
+	}
+	cmd.Write(hdr.Bytes())
+	cmd.Write(body)
+	cmd.WriteString("\r\n")
+	if !n.jetStream {
+		cmd.WriteString("PING\r\n")
+	}
+	if _, err := n.conn.Write(cmd.Bytes()); err != nil {
+		return err
+	}
+
+	status, payload, err := n.await(reply)
+	if err != nil || !n.jetStream {
+		return err
+	}
+	if status == "503" {
+		return fmt.Errorf("jetstream: no stream captures subject %s", subject)
+	}
+	var ack struct {
+		Stream string `json:"stream"`
+		Error  *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return fmt.Errorf("jetstream: invalid acknowledgement: %w", err)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("jetstream: %s (%d)", ack.Error.Description, ack.Error.Code)
+	}
+	return nil
+}
+
+// await reads protocol lines, answering PINGs, until the PONG when reply
+// is empty or else the message sent to reply, returning its status
+// header code and payload.
+func (n *NATS) await(reply string) (string, []byte, error) {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return "", nil, err
+			}
+		case "PONG":
+			if reply == "" {
+				return "", nil, nil
+			}
+		case "+OK", "INFO":
+		case "-ERR":
+			return "", nil, fmt.Errorf("nats: %s", strings.Trim(args, " '"))
+		case "MSG", "HMSG":
+			subject, status, payload, err := n.readMessage(strings.ToUpper(op) == "HMSG", strings.Fields(args))
+			if err != nil {
+				return "", nil, err
+			}
+			if subject == reply {
+				return status, payload, nil
+			}
+		default:
+			return "", nil, fmt.Errorf("nats: unexpected %q", line)
+		}
+	}
+}
+
+// readMessage reads the body of a MSG or HMSG with args, returning its
+// subject, header status code and payload.
+func (n *NATS) readMessage(headers bool, args []string) (string, string, []byte, error) {
+	if len(args) < 3 || headers && len(args) < 4 {
+		return "", "", nil, errors.New("nats: malformed message")
+	}
+	total, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || total < 0 {
+		return "", "", nil, errors.New("nats: malformed message size")
+	}
+	data := make([]byte, total+2)
+	if _, err := io.ReadFull(n.r, data); err != nil {
+		return "", "", nil, err
+	}
+	data = data[:total]
+	if !headers {
+		return args[0], "", data, nil
+	}
+	hdrLen, err := strconv.Atoi(args[len(args)-2])
+	if err != nil || hdrLen < 0 || hdrLen > total {
+		return "", "", nil, errors.New("nats: malformed header size")
+	}
+	// The status line is "NATS/1.0" with an optional code and reason.
+	status := ""
+	if first, _, ok := bytes.Cut(data[:hdrLen], []byte("\r\n")); ok {
+		if fields := strings.Fields(string(first)); len(fields) > 1 {
+			status = fields[1]
+		}
+	}
+	return args[0], status, data[hdrLen:], nil
+}
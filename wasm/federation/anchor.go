@@ -0,0 +1,106 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Audiences keep the federation's tokens from passing for one another or
+// for referral tickets.
+const (
+	// AnchorAudience is the aud of trust anchors.
+	AnchorAudience = "coral-federation-anchor"
+
+	// Audience is the aud of the tokens reefs authenticate lookups with.
+	Audience = "coral-federation"
+
+	// KeysAudience is the aud of the key set documents peers refresh
+	// anchored keys from.
+	KeysAudience = "coral-federation-keys"
+)
+
+// ErrInvalidAnchor is returned for anchors that do not verify against
+// the local root or are incomplete.
+var ErrInvalidAnchor = errors.New("invalid trust anchor")
+
+// Peer is a federated reef: where its discovery server is and the key
+// set its tokens are signed with.
+type Peer struct {
+	Reef string          `json:"reef"`
+	URL  string          `json:"url"`
+	JWKS json.RawMessage `json:"jwks"`
+}
+
+// AnchorClaims are the claims of a trust anchor: the local reef (iss)
+// vouching for a peer reef (sub) and its key set.
+type AnchorClaims struct {
+	Peer Peer `json:"peer"`
+	gojwt.RegisteredClaims
+}
+
+// SignAnchor signs a trust anchor for peer with s, a root key of reef
+// localReef, valid for ttl. Each reef of a federation signs an anchor for
+// every other; exchanging the peers' JWKS documents is all the setup
+// needed.
+func SignAnchor(ctx context.Context, s signer.Signer, localReef string, peer Peer, ttl time.Duration) (string, error) {
+	if err := peer.validate(); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAnchor, err)
+	}
+	now := time.Now()
+	return signer.SignToken(ctx, s, &AnchorClaims{
+		Peer: peer,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    localReef,
+			Subject:   peer.Reef,
+			Audience:  gojwt.ClaimStrings{AnchorAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+// ParseAnchor verifies an anchor against roots, the root keys of reef
+// localReef, and returns its claims.
+func ParseAnchor(roots verify.KeySource, token, localReef string) (*AnchorClaims, error) {
+	claims := &AnchorClaims{}
+	_, err := gojwt.ParseWithClaims(token, claims, roots.GetKeyFunc(),
+		gojwt.WithAudience(AnchorAudience),
+		gojwt.WithIssuer(localReef),
+		gojwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAnchor, err)
+	}
+	if err := claims.Peer.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAnchor, err)
+	}
+	if claims.Subject != claims.Peer.Reef || claims.Peer.Reef == localReef {
+		return nil, fmt.Errorf("%w: anchors reef %q", ErrInvalidAnchor, claims.Peer.Reef)
+	}
+	return claims, nil
+}
+
+// validate checks that p names a reef, an absolute URL and a usable key
+// set.
+func (p *Peer) validate() error {
+	if p.Reef == "" {
+		return errors.New("peer reef is required")
+	}
+	if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid peer URL %q", p.URL)
+	}
+	if _, err := jwt.NewValidatorFromJSON(string(p.JWKS)); err != nil {
+		return fmt.Errorf("invalid peer JWKS: %v", err)
+	}
+	return nil
+}
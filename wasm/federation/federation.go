@@ -0,0 +1,377 @@
+// Package federation lets separate reefs find each other's agents. Each
+// reef signs a trust anchor for every reef it federates with: the peer's
+// JWKS and server URL, signed by the local reef's root key. A discovery
+// server holding anchors forwards lookups for agents of a peer reef to
+// the peer's server, authenticated by a short-lived token signed with
+// its own key; the peer checks the token against its anchor for the
+// calling reef. Peers follow each other's key rotation by fetching the
+// key set the other side signs with a key they already trust.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Paths of the routes a federated server serves its peers.
+const (
+	// AgentsPath is followed by the agent ID; it returns the agent's
+	// record to peers presenting a federation token.
+	AgentsPath = "/v1/federation/agents/"
+
+	// KeysPath returns the server's key set as a token signed with its
+	// current key.
+	KeysPath = "/v1/federation/keys"
+)
+
+// tokenTTL is the lifetime of federation and key set tokens.
+const tokenTTL = time.Minute
+
+var (
+	// ErrUnknownReef is returned for reefs without an anchor.
+	ErrUnknownReef = errors.New("reef is not federated")
+
+	// ErrUnauthorized is returned for federation tokens that do not
+	// verify against the calling reef's anchored keys.
+	ErrUnauthorized = errors.New("invalid federation token")
+
+	// ErrNoSigner is returned when forwarding or serving keys without a
+	// signing key.
+	ErrNoSigner = errors.New("federation needs a signing key")
+)
+
+// Config holds the configuration for a Federation.
+type Config struct {
+	// Reef is the local reef's ID. Required.
+	Reef string
+
+	// Anchors are the trust anchors of the federated reefs, signed by
+	// one of Roots.
+	Anchors []string
+
+	// Roots verifies anchors: the local reef's root keys. Required.
+	Roots verify.KeySource
+
+	// Signer returns the key the server signs federation and key set
+	// tokens with, which must be in Document. Without it the server
+	// answers peers' lookups but cannot forward its own.
+	Signer func() (signer.Signer, error)
+
+	// Document returns the server's published key set, for KeysPath.
+	Document func() *jwks.Document
+
+	// HTTPClient reaches the peers. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Federation forwards lookups to federated reefs and authenticates
+// theirs. It is safe for concurrent use.
+type Federation struct {
+	reef     string
+	roots    verify.KeySource
+	signer   func() (signer.Signer, error)
+	document func() *jwks.Document
+	http     *http.Client
+
+	mu    sync.RWMutex
+	peers map[string]*peer
+}
+
+type peer struct {
+	Peer
+	anchor  string
+	keys    *jwt.Validator
+	expires time.Time
+}
+
+// New verifies cfg's anchors and creates a Federation.
+func New(cfg Config) (*Federation, error) {
+	if cfg.Reef == "" || cfg.Roots == nil {
+		return nil, errors.New("federation: Reef and Roots are required")
+	}
+	f := &Federation{
+		reef:     cfg.Reef,
+		roots:    cfg.Roots,
+		signer:   cfg.Signer,
+		document: cfg.Document,
+		http:     cfg.HTTPClient,
+		peers:    map[string]*peer{},
+	}
+	if f.http == nil {
+		f.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	if err := f.SetAnchors(cfg.Anchors); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetAnchors federates with the reefs of anchors in place of the current
+// ones, once all of them verify. Peers whose anchor is unchanged keep the
+// key sets they were refreshed to.
+func (f *Federation) SetAnchors(anchors []string) error {
+	peers := make(map[string]*peer, len(anchors))
+	for _, token := range anchors {
+		claims, err := ParseAnchor(f.roots, token, f.reef)
+		if err != nil {
+			return err
+		}
+		keys, err := jwt.NewValidatorFromJSON(string(claims.Peer.JWKS))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAnchor, err)
+		}
+		peers[claims.Peer.Reef] = &peer{Peer: claims.Peer, anchor: token, keys: keys, expires: claims.ExpiresAt.Time}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, p := range peers {
+		if cur, ok := f.peers[id]; ok && cur.anchor == p.anchor {
+			peers[id] = cur
+		}
+	}
+	f.peers = peers
+	return nil
+}
+
+// Reef returns the local reef's ID.
+func (f *Federation) Reef() string {
+	return f.reef
+}
+
+// Peers returns the IDs of the federated reefs with unexpired anchors.
+func (f *Federation) Peers() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var out []string
+	for id, p := range f.peers {
+		if time.Now().Before(p.expires) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Lookup asks the server of reefID for agentID's record.
+func (f *Federation) Lookup(ctx context.Context, reefID, agentID string) (*registry.Record, error) {
+	p, err := f.peer(reefID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := f.token(ctx, reefID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.URL, "/")+AgentsPath+url.PathEscape(agentID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reef %s: %w", reefID, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s in reef %s", registry.ErrNotFound, agentID, reefID)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("reef %s: %s: %s", reefID, resp.Status, bytes.TrimSpace(body))
+	}
+	var rec registry.Record
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("reef %s: %w", reefID, err)
+	}
+	if rec.ReefID != reefID || rec.AgentID != agentID {
+		return nil, fmt.Errorf("reef %s answered for %s in reef %s", reefID, rec.AgentID, rec.ReefID)
+	}
+	return &rec, nil
+}
+
+// Authenticate verifies a federation token presented by a peer's server
+// and returns the peer's reef ID.
+func (f *Federation) Authenticate(token string) (string, error) {
+	unverified := &gojwt.RegisteredClaims{}
+	if _, _, err := gojwt.NewParser().ParseUnverified(token, unverified); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	p, err := f.peer(unverified.Issuer)
+	if err != nil {
+		return "", err
+	}
+	claims := &gojwt.RegisteredClaims{}
+	_, err = gojwt.ParseWithClaims(token, claims, p.keys.GetKeyFunc(),
+		gojwt.WithAudience(Audience),
+		gojwt.WithIssuer(p.Reef),
+		gojwt.WithSubject(f.reef),
+		gojwt.WithExpirationRequired(),
+		gojwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if claims.ExpiresAt.Sub(claims.IssuedAt.Time) > tokenTTL {
+		return "", fmt.Errorf("%w: lifetime exceeds %s", ErrUnauthorized, tokenTTL)
+	}
+	return p.Reef, nil
+}
+
+// KeysToken returns the server's key set as a token signed with its
+// current key, for KeysPath.
+func (f *Federation) KeysToken(ctx context.Context) (string, error) {
+	if f.signer == nil || f.document == nil {
+		return "", ErrNoSigner
+	}
+	s, err := f.signer()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoSigner, err)
+	}
+	doc, err := json.Marshal(f.document())
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return signer.SignToken(ctx, s, &keysClaims{
+		JWKS: doc,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    f.reef,
+			Audience:  gojwt.ClaimStrings{KeysAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(tokenTTL)),
+		},
+	})
+}
+
+// Refresh fetches every peer's key set from KeysPath and adopts it when
+// it is signed by a key the peer is trusted with. Peers pre-publish
+// their next key, so refreshing more often than they rotate follows
+// their rotation without new anchors. It returns the first error and
+// keeps the previous keys of peers that failed.
+func (f *Federation) Refresh(ctx context.Context) error {
+	var first error
+	for _, reefID := range f.Peers() {
+		if err := f.refresh(ctx, reefID); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// RunRefresher calls Refresh every interval until ctx is done.
+func (f *Federation) RunRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = f.Refresh(ctx)
+		}
+	}
+}
+
+// keysClaims carry a server's key set.
+type keysClaims struct {
+	JWKS json.RawMessage `json:"jwks"`
+	gojwt.RegisteredClaims
+}
+
+func (f *Federation) refresh(ctx context.Context, reefID string) error {
+	p, err := f.peer(reefID)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.URL, "/")+KeysPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("reef %s: %w", reefID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reef %s: %s", reefID, resp.Status)
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reef %s: %w", reefID, err)
+	}
+
+	claims := &keysClaims{}
+	_, err = gojwt.ParseWithClaims(string(bytes.TrimSpace(token)), claims, p.keys.GetKeyFunc(),
+		gojwt.WithAudience(KeysAudience),
+		gojwt.WithIssuer(reefID),
+		gojwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return fmt.Errorf("reef %s: key set: %w", reefID, err)
+	}
+	keys, err := jwt.NewValidatorFromJSON(string(claims.JWKS))
+	if err != nil {
+		return fmt.Errorf("reef %s: key set: %w", reefID, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cur, ok := f.peers[reefID]; ok {
+		next := *cur
+		next.JWKS, next.keys = claims.JWKS, keys
+		f.peers[reefID] = &next
+	}
+	return nil
+}
+
+// peer returns reefID's live anchor.
+func (f *Federation) peer(reefID string) (*peer, error) {
+	f.mu.RLock()
+	p, ok := f.peers[reefID]
+	f.mu.RUnlock()
+	if !ok || !time.Now().Before(p.expires) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownReef, reefID)
+	}
+	return p, nil
+}
+
+// token signs the federation token for a request to reefID.
+func (f *Federation) token(ctx context.Context, reefID string) (string, error) {
+	if f.signer == nil {
+		return "", ErrNoSigner
+	}
+	s, err := f.signer()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoSigner, err)
+	}
+	now := time.Now()
+	return signer.SignToken(ctx, s, &gojwt.RegisteredClaims{
+		ID:        uuid.New().String(),
+		Issuer:    f.reef,
+		Subject:   reefID,
+		Audience:  gojwt.ClaimStrings{Audience},
+		IssuedAt:  gojwt.NewNumericDate(now),
+		ExpiresAt: gojwt.NewNumericDate(now.Add(tokenTTL)),
+	})
+}
@@ -0,0 +1,191 @@
+package federation_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+)
+
+func newSet(t *testing.T) *jwks.Set {
+	t.Helper()
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+// peerOf describes the reef signing with set, served at url.
+func peerOf(t *testing.T, reef, url string, set *jwks.Set) federation.Peer {
+	t.Helper()
+	doc, err := json.Marshal(set.Document())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return federation.Peer{Reef: reef, URL: url, JWKS: doc}
+}
+
+func mustSigner(t *testing.T, set *jwks.Set) signer.Signer {
+	t.Helper()
+	s, err := set.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sign signs claims with the current key of set.
+func sign(t *testing.T, set *jwks.Set, claims gojwt.Claims) string {
+	t.Helper()
+	token, err := signer.SignToken(context.Background(), mustSigner(t, set), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestParseAnchor checks that anchors not signed by the local root for
+// the local reef, or not vouching for a peer, are rejected.
+func TestParseAnchor(t *testing.T) {
+	root, other := newSet(t), newSet(t)
+	peer := peerOf(t, "b", "https://b.example.com", newSet(t))
+	anchor := func(set *jwks.Set, local string, peer federation.Peer, ttl time.Duration) string {
+		token, err := federation.SignAnchor(context.Background(), mustSigner(t, set), local, peer, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	claims := func(aud, sub string) *federation.AnchorClaims {
+		now := time.Now()
+		return &federation.AnchorClaims{Peer: peer, RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer: "a", Subject: sub, Audience: gojwt.ClaimStrings{aud},
+			IssuedAt: gojwt.NewNumericDate(now), ExpiresAt: gojwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+	}
+	self := peer
+	self.Reef = "a"
+
+	if _, err := federation.ParseAnchor(root, anchor(root, "a", peer, time.Hour), "a"); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{
+		"other root":          anchor(other, "a", peer, time.Hour),
+		"other local reef":    anchor(root, "c", peer, time.Hour),
+		"expired":             anchor(root, "a", peer, -time.Minute),
+		"local reef":          anchor(root, "a", self, time.Hour),
+		"federation token":    sign(t, root, claims(federation.Audience, "b")),
+		"subject is not peer": sign(t, root, claims(federation.AnchorAudience, "c")),
+		"not a token":         "anchor",
+	} {
+		if _, err := federation.ParseAnchor(root, token, "a"); !errors.Is(err, federation.ErrInvalidAnchor) {
+			t.Errorf("%s: %v, want ErrInvalidAnchor", name, err)
+		}
+	}
+
+	for name, p := range map[string]federation.Peer{
+		"no reef":      {URL: peer.URL, JWKS: peer.JWKS},
+		"relative URL": {Reef: "b", URL: "b.example.com", JWKS: peer.JWKS},
+		"bad keys":     {Reef: "b", URL: peer.URL, JWKS: json.RawMessage(`{"keys": 1}`)},
+	} {
+		if _, err := federation.SignAnchor(context.Background(), mustSigner(t, root), "a", p, time.Hour); !errors.Is(err, federation.ErrInvalidAnchor) {
+			t.Errorf("%s: %v, want ErrInvalidAnchor", name, err)
+		}
+	}
+
+	// A federation keeps its anchors when a replacement set has a bad one.
+	f, err := federation.New(federation.Config{Reef: "a", Roots: root, Anchors: []string{anchor(root, "a", peer, time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetAnchors([]string{anchor(other, "a", peer, time.Hour)}); !errors.Is(err, federation.ErrInvalidAnchor) {
+		t.Fatalf("SetAnchors: %v, want ErrInvalidAnchor", err)
+	}
+	if peers := f.Peers(); len(peers) != 1 || peers[0] != "b" {
+		t.Fatalf("peers %v after a refused SetAnchors, want [b]", peers)
+	}
+}
+
+// TestLookup forwards a lookup from reef a to reef b and checks that b
+// only answers tokens signed with the keys a's anchor names.
+func TestLookup(t *testing.T) {
+	rootA, rootB := newSet(t), newSet(t)
+	keysA, keysB, stranger := newSet(t), newSet(t), newSet(t)
+
+	var b *federation.Federation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reef, err := b.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(&registry.Record{ReefID: "b", ColonyID: reef, AgentID: strings.TrimPrefix(r.URL.Path, federation.AgentsPath)})
+	}))
+	defer server.Close()
+
+	anchorB, err := federation.SignAnchor(context.Background(), mustSigner(t, rootA), "a", peerOf(t, "b", server.URL, keysB), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchorA, err := federation.SignAnchor(context.Background(), mustSigner(t, rootB), "b", peerOf(t, "a", "https://a.example.com", keysA), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := federation.New(federation.Config{Reef: "a", Roots: rootA, Anchors: []string{anchorB}, Signer: keysA.Signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = federation.New(federation.Config{Reef: "b", Roots: rootB, Anchors: []string{anchorA}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := a.Lookup(context.Background(), "b", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.AgentID != "agent" || rec.ColonyID != "a" {
+		t.Fatalf("record %+v, want agent looked up by reef a", rec)
+	}
+	if _, err := a.Lookup(context.Background(), "c", "agent"); !errors.Is(err, federation.ErrUnknownReef) {
+		t.Fatalf("lookup in a reef without anchor: %v, want ErrUnknownReef", err)
+	}
+
+	now := time.Now()
+	token := func(keys *jwks.Set, iss, sub string) string {
+		return sign(t, keys, &gojwt.RegisteredClaims{
+			Issuer: iss, Subject: sub, Audience: gojwt.ClaimStrings{federation.Audience},
+			IssuedAt: gojwt.NewNumericDate(now), ExpiresAt: gojwt.NewNumericDate(now.Add(time.Minute)),
+		})
+	}
+	for name, c := range map[string]struct {
+		token string
+		err   error
+	}{
+		"key outside the anchor": {token(stranger, "a", "b"), federation.ErrUnauthorized},
+		"unanchored reef":        {token(stranger, "c", "b"), federation.ErrUnknownReef},
+		"for another reef":       {token(keysA, "a", "c"), federation.ErrUnauthorized},
+		"long-lived": {sign(t, keysA, &gojwt.RegisteredClaims{
+			Issuer: "a", Subject: "b", Audience: gojwt.ClaimStrings{federation.Audience},
+			IssuedAt: gojwt.NewNumericDate(now), ExpiresAt: gojwt.NewNumericDate(now.Add(time.Hour)),
+		}), federation.ErrUnauthorized},
+	} {
+		if _, err := b.Authenticate(c.token); !errors.Is(err, c.err) {
+			t.Errorf("%s: %v, want %v", name, err, c.err)
+		}
+	}
+	if reef, err := b.Authenticate(token(keysA, "a", "b")); err != nil || reef != "a" {
+		t.Fatalf("token of reef a: %q, %v", reef, err)
+	}
+}
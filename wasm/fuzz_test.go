@@ -0,0 +1,118 @@
+//go:build js && !lite
+
+// The fuzz targets of the Wasm exports run their seeds with
+//
+//	GOOS=js GOARCH=wasm go test -exec "$(go env GOROOT)/lib/wasm/go_js_wasm_exec" .
+//
+// The fuzzing engine does not support js/wasm, so new inputs are found by
+// the targets of packages verify, wire and jsonscan, which cover the same
+// parsers natively.
+
+package main
+
+import (
+	"strconv"
+	"syscall/js"
+	"testing"
+)
+
+// parseJS returns the JS value of a JSON document, or false if JSON.parse
+// rejects it.
+func parseJS(text string) (v js.Value, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return js.Global().Get("JSON").Call("parse", text), true
+}
+
+// FuzzVerifyOptions checks that verify options of any shape are read or
+// rejected without panicking.
+func FuzzVerifyOptions(f *testing.F) {
+	for _, seed := range []string{
+		`{"issuer":"coral-discovery","audience":["coral-colony"],"algorithms":["EdDSA","ES256"],"clockSkewSeconds":30,"notBeforeSkewSeconds":5,"maxTTLSeconds":3600}`,
+		`{"issuer":{},"audience":{"length":1e9}}`,
+		`{"audience":{"0":"a","length":1}}`,
+		`{"algorithms":[1,null]}`,
+		`{"clockSkewSeconds":"30","maxTTLSeconds":1e308}`,
+		`[]`,
+		`"options"`,
+		`null`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		v, ok := parseJS(text)
+		if !ok {
+			return
+		}
+		if opts, err := verifyOptionsFromJS(v); err != nil && opts != nil {
+			t.Fatal("options returned with an error")
+		}
+	})
+}
+
+// FuzzTicketArguments checks that malformed tokens are rejected by the
+// routing helpers without panicking, and that both read the same kid.
+func FuzzTicketArguments(f *testing.F) {
+	for _, seed := range []string{
+		"eyJhbGciOiJFZERTQSIsImtpZCI6ImsxIn0.eyJyZWVmX2lkIjoiciIsImV4cCI6MX0.c2ln",
+		"eyJhbGciOiJFZERTQSIsImtpZCI6ImsxIn0=.e30=.",
+		"bnVsbA.bnVsbA.",
+		"W10.e30.",
+		"..",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, token string) {
+		header, _, err := decodeTicket(token)
+		kid, kidErr := tokenKeyID(token)
+		if err == nil && header.Kid != "" && (kidErr != nil || kid != header.Kid) {
+			t.Fatalf("decodeTicket read kid %q, tokenKeyID %q (%v)", header.Kid, kid, kidErr)
+		}
+	})
+}
+
+// FuzzExports calls the ticket and JWKS exports with fuzzed arguments and
+// checks that none of them fails internally.
+func FuzzExports(f *testing.F) {
+	exports := map[string]exportFunc{
+		"verifySignature":     verifySignature,
+		"verifySignatures":    verifySignatures,
+		"parseReferralTicket": parseReferralTicket,
+		"loadJWKS":            loadJWKS,
+		"verifyJWKSBundle":    verifyJWKSBundle,
+	}
+	jwks := `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`
+	for _, seed := range []string{
+		`["eyJhbGciOiJFZERTQSIsImtpZCI6ImsxIn0.e30.c2ln", ` + strconv.Quote(jwks) + `]`,
+		`["eyJhbGciOiJFZERTQSIsImtpZCI6ImsxIn0.e30.c2ln", "{\"keys\":[null]}", {"issuer":{}}]`,
+		`[[{"token":"a.b.c","jwksJSON":"{}","options":{"audience":[1]}}, 5, null]]`,
+		`[{"length":1e9}]`,
+		`["{\"keys\":[{\"kty\":\"RSA\",\"n\":\"AQAB\",\"e\":\"AQAB\"}]}", "60"]`,
+		`["{\"keys\":[]}", -1]`,
+		`["{}", "{}", "reef", "60"]`,
+		`[{}, {}, {}]`,
+		`[]`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		v, ok := parseJS(text)
+		if !ok || !js.Global().Get("Array").Call("isArray", v).Bool() {
+			return
+		}
+		args := make([]js.Value, v.Length())
+		for i := range args {
+			args[i] = v.Index(i)
+		}
+		for name, fn := range exports {
+			result, _ := call(fn, js.Undefined(), args).(map[string]interface{})
+			if e, ok := result["error"].(map[string]interface{}); ok && e["code"] == errInternal {
+				t.Fatalf("%s: %v", name, e["message"])
+			}
+		}
+	})
+}
@@ -0,0 +1,1394 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: coral/registry/v1/registry.proto
+
+package registryv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Kind of registry change.
+type EventType int32
+
+const (
+	EventType_EVENT_TYPE_UNSPECIFIED EventType = 0
+	EventType_EVENT_TYPE_PUT         EventType = 1
+	EventType_EVENT_TYPE_DELETE      EventType = 2
+	EventType_EVENT_TYPE_EXPIRE      EventType = 3
+	EventType_EVENT_TYPE_SUSPECT     EventType = 4
+	EventType_EVENT_TYPE_ALIVE       EventType = 5
+	EventType_EVENT_TYPE_FAIL        EventType = 6
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_TYPE_UNSPECIFIED",
+		1: "EVENT_TYPE_PUT",
+		2: "EVENT_TYPE_DELETE",
+		3: "EVENT_TYPE_EXPIRE",
+		4: "EVENT_TYPE_SUSPECT",
+		5: "EVENT_TYPE_ALIVE",
+		6: "EVENT_TYPE_FAIL",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_TYPE_UNSPECIFIED": 0,
+		"EVENT_TYPE_PUT":         1,
+		"EVENT_TYPE_DELETE":      2,
+		"EVENT_TYPE_EXPIRE":      3,
+		"EVENT_TYPE_SUSPECT":     4,
+		"EVENT_TYPE_ALIVE":       5,
+		"EVENT_TYPE_FAIL":        6,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_coral_registry_v1_registry_proto_enumTypes[0].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_coral_registry_v1_registry_proto_enumTypes[0]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{0}
+}
+
+// AgentRecord describes a registered agent.
+type AgentRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Agent identifier
+	AgentId string `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// Colony the agent belongs to
+	ColonyId string `protobuf:"bytes,2,opt,name=colony_id,json=colonyId,proto3" json:"colony_id,omitempty"`
+	// Reef the colony belongs to
+	ReefId string `protobuf:"bytes,3,opt,name=reef_id,json=reefId,proto3" json:"reef_id,omitempty"`
+	// Agent endpoints (host:port pairs or URLs)
+	Endpoints []string `protobuf:"bytes,4,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+	// Capability labels advertised by the agent
+	Capabilities []string `protobuf:"bytes,5,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	// Requested registration TTL in seconds (0 uses the server default)
+	TtlSeconds int32 `protobuf:"varint,6,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	// When the agent first registered
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// When the record was last written
+	UpdatedAt *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// When the record expires
+	ExpiresAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// Public endpoints inferred by the server from the registration's
+	// source address (ignored on input)
+	ObservedEndpoints []string `protobuf:"bytes,10,rep,name=observed_endpoints,json=observedEndpoints,proto3" json:"observed_endpoints,omitempty"`
+	// Coarse position of the agent, filled from edge geolocation hints
+	// when the agent does not report it
+	Location *Location `protobuf:"bytes,11,opt,name=location,proto3" json:"location,omitempty"`
+	// Round trip times in milliseconds the agent measured to peers, by
+	// agent ID
+	RttMs map[string]uint32 `protobuf:"bytes,12,rep,name=rtt_ms,json=rttMs,proto3" json:"rtt_ms,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// Load the agent last reported
+	Load *Load `protobuf:"bytes,13,opt,name=load,proto3" json:"load,omitempty"`
+	// Pub/sub topics the agent produces
+	Publishes []string `protobuf:"bytes,14,rep,name=publishes,proto3" json:"publishes,omitempty"`
+	// Pub/sub topics the agent consumes
+	Subscribes []string `protobuf:"bytes,15,rep,name=subscribes,proto3" json:"subscribes,omitempty"`
+	// The agent's signature over the record, when it signed it
+	Envelope *RecordEnvelope `protobuf:"bytes,16,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	// Number of writes to the record. Registrations naming one only
+	// replace a record still at it
+	Seq uint64 `protobuf:"varint,17,opt,name=seq,proto3" json:"seq,omitempty"`
+	// JSON object describing the agent. It must match the colony's
+	// metadata schema, when the colony has one
+	Metadata      []byte `protobuf:"bytes,18,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentRecord) Reset() {
+	*x = AgentRecord{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentRecord) ProtoMessage() {}
+
+func (x *AgentRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentRecord.ProtoReflect.Descriptor instead.
+func (*AgentRecord) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AgentRecord) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AgentRecord) GetColonyId() string {
+	if x != nil {
+		return x.ColonyId
+	}
+	return ""
+}
+
+func (x *AgentRecord) GetReefId() string {
+	if x != nil {
+		return x.ReefId
+	}
+	return ""
+}
+
+func (x *AgentRecord) GetEndpoints() []string {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetTtlSeconds() int32 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *AgentRecord) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetObservedEndpoints() []string {
+	if x != nil {
+		return x.ObservedEndpoints
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetLocation() *Location {
+	if x != nil {
+		return x.Location
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetRttMs() map[string]uint32 {
+	if x != nil {
+		return x.RttMs
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetLoad() *Load {
+	if x != nil {
+		return x.Load
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetPublishes() []string {
+	if x != nil {
+		return x.Publishes
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetSubscribes() []string {
+	if x != nil {
+		return x.Subscribes
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetEnvelope() *RecordEnvelope {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+func (x *AgentRecord) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *AgentRecord) GetMetadata() []byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+// Load is the load an agent reports with its heartbeats.
+type Load struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Share of the agent's capacity in use, from 0 to 1
+	Utilization float64 `protobuf:"fixed64,1,opt,name=utilization,proto3" json:"utilization,omitempty"`
+	// Sessions the agent is serving
+	Sessions      uint32 `protobuf:"varint,2,opt,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Load) Reset() {
+	*x = Load{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Load) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Load) ProtoMessage() {}
+
+func (x *Load) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Load.ProtoReflect.Descriptor instead.
+func (*Load) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Load) GetUtilization() float64 {
+	if x != nil {
+		return x.Utilization
+	}
+	return 0
+}
+
+func (x *Load) GetSessions() uint32 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+// Location is an agent's coarse position, for ranking peers by
+// proximity.
+type Location struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ISO 3166-1 alpha-2 country code
+	Country string `protobuf:"bytes,1,opt,name=country,proto3" json:"country,omitempty"`
+	// Two-letter continent code
+	Continent string `protobuf:"bytes,2,opt,name=continent,proto3" json:"continent,omitempty"`
+	// IATA code of the edge data center the agent connected through
+	Colo string `protobuf:"bytes,3,opt,name=colo,proto3" json:"colo,omitempty"`
+	// Latitude in degrees, rounded to a tenth
+	Latitude float64 `protobuf:"fixed64,4,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	// Longitude in degrees, rounded to a tenth
+	Longitude     float64 `protobuf:"fixed64,5,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Location) Reset() {
+	*x = Location{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Location) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Location) ProtoMessage() {}
+
+func (x *Location) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Location.ProtoReflect.Descriptor instead.
+func (*Location) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Location) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Location) GetContinent() string {
+	if x != nil {
+		return x.Continent
+	}
+	return ""
+}
+
+func (x *Location) GetColo() string {
+	if x != nil {
+		return x.Colo
+	}
+	return ""
+}
+
+func (x *Location) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *Location) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+// RegisterRequest registers or updates an agent.
+type RegisterRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Record to store
+	Record        *AgentRecord `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterRequest) Reset() {
+	*x = RegisterRequest{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterRequest) ProtoMessage() {}
+
+func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
+func (*RegisterRequest) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RegisterRequest) GetRecord() *AgentRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+// RegisterResponse returns the stored record.
+type RegisterResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Record as stored, including timestamps
+	Record        *AgentRecord `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterResponse) Reset() {
+	*x = RegisterResponse{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterResponse) ProtoMessage() {}
+
+func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterResponse.ProtoReflect.Descriptor instead.
+func (*RegisterResponse) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RegisterResponse) GetRecord() *AgentRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+// DeregisterRequest removes an agent.
+type DeregisterRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Agent ID to remove
+	AgentId       string `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeregisterRequest) Reset() {
+	*x = DeregisterRequest{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeregisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeregisterRequest) ProtoMessage() {}
+
+func (x *DeregisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeregisterRequest.ProtoReflect.Descriptor instead.
+func (*DeregisterRequest) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeregisterRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+// DeregisterResponse confirms removal.
+type DeregisterResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeregisterResponse) Reset() {
+	*x = DeregisterResponse{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeregisterResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeregisterResponse) ProtoMessage() {}
+
+func (x *DeregisterResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeregisterResponse.ProtoReflect.Descriptor instead.
+func (*DeregisterResponse) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{6}
+}
+
+// RenewRequest extends an agent's lease.
+type RenewRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Agent ID whose lease to extend
+	AgentId string `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// Current load of the agent; unset keeps the last report
+	Load *Load `protobuf:"bytes,2,opt,name=load,proto3" json:"load,omitempty"`
+	// Renews only a record still at this seq when set
+	Seq           uint64 `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenewRequest) Reset() {
+	*x = RenewRequest{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewRequest) ProtoMessage() {}
+
+func (x *RenewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewRequest.ProtoReflect.Descriptor instead.
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RenewRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *RenewRequest) GetLoad() *Load {
+	if x != nil {
+		return x.Load
+	}
+	return nil
+}
+
+func (x *RenewRequest) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+// RenewResponse returns the renewed record.
+type RenewResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Record with the extended expiry
+	Record        *AgentRecord `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenewResponse) Reset() {
+	*x = RenewResponse{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewResponse) ProtoMessage() {}
+
+func (x *RenewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewResponse.ProtoReflect.Descriptor instead.
+func (*RenewResponse) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RenewResponse) GetRecord() *AgentRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+// LookupRequest looks up agents. Exactly one of agent_id, colony_id,
+// topic or service must be set.
+type LookupRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Agent ID to look up
+	AgentId string `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// Colony ID whose agents to list
+	ColonyId string `protobuf:"bytes,2,opt,name=colony_id,json=colonyId,proto3" json:"colony_id,omitempty"`
+	// Label selector restricting a colony listing, e.g.
+	// "gpu=true,region in (eu-west,eu-central)"
+	Selector string `protobuf:"bytes,3,opt,name=selector,proto3" json:"selector,omitempty"`
+	// Return only the agents of a colony listing nearest the caller, at
+	// most this many, ranked by estimated round trip time
+	Nearest uint32 `protobuf:"varint,4,opt,name=nearest,proto3" json:"nearest,omitempty"`
+	// Return only the least loaded agents of a colony listing, at most
+	// this many, ordered by reported utilization
+	LeastLoaded uint32 `protobuf:"varint,5,opt,name=least_loaded,json=leastLoaded,proto3" json:"least_loaded,omitempty"`
+	// Return up to this many agents of a colony listing drawn at random,
+	// weighted by health score. At most one of nearest, least_loaded and
+	// weighted may be set.
+	Weighted uint32 `protobuf:"varint,6,opt,name=weighted,proto3" json:"weighted,omitempty"`
+	// Page size of a colony listing (0 uses the server default of 500, at
+	// most 5000)
+	PageSize uint32 `protobuf:"varint,7,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// next_page_token of the previous page, to continue a colony listing
+	PageToken string `protobuf:"bytes,8,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// Reef of agent_id, when it belongs to a federated reef the lookup is
+	// forwarded to
+	ReefId string `protobuf:"bytes,9,opt,name=reef_id,json=reefId,proto3" json:"reef_id,omitempty"`
+	// Topic whose publishers and subscribers in the caller's reef to list,
+	// in agent ID order; colony_id, when also set, narrows the listing to
+	// that colony
+	Topic string `protobuf:"bytes,10,opt,name=topic,proto3" json:"topic,omitempty"`
+	// Role in topic to match: "publisher", "subscriber", or empty for both
+	TopicRole string `protobuf:"bytes,11,opt,name=topic_role,json=topicRole,proto3" json:"topic_role,omitempty"`
+	// Service whose agents in the caller's reef to list, in agent ID
+	// order, as advertised in "service=<name>/v1.2.3" capabilities;
+	// colony_id, when also set, narrows the listing to that colony
+	Service string `protobuf:"bytes,12,opt,name=service,proto3" json:"service,omitempty"`
+	// Version constraint on service, comma-separated comparisons that must
+	// all hold, e.g. ">=2.3,<3"
+	ServiceVersion string `protobuf:"bytes,13,opt,name=service_version,json=serviceVersion,proto3" json:"service_version,omitempty"`
+	// Exclude pre-release versions of service, such as 2.4.0-rc.1
+	Stable bool `protobuf:"varint,14,opt,name=stable,proto3" json:"stable,omitempty"`
+	// Percentage of callers, from 1 to 100, steered to the newest matching
+	// version of service while older ones still run; the others only see
+	// the older versions. Callers stay in the same share across lookups.
+	Canary        uint32 `protobuf:"varint,15,opt,name=canary,proto3" json:"canary,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *LookupRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetColonyId() string {
+	if x != nil {
+		return x.ColonyId
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetNearest() uint32 {
+	if x != nil {
+		return x.Nearest
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetLeastLoaded() uint32 {
+	if x != nil {
+		return x.LeastLoaded
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetWeighted() uint32 {
+	if x != nil {
+		return x.Weighted
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetReefId() string {
+	if x != nil {
+		return x.ReefId
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetTopicRole() string {
+	if x != nil {
+		return x.TopicRole
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetServiceVersion() string {
+	if x != nil {
+		return x.ServiceVersion
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetStable() bool {
+	if x != nil {
+		return x.Stable
+	}
+	return false
+}
+
+func (x *LookupRequest) GetCanary() uint32 {
+	if x != nil {
+		return x.Canary
+	}
+	return 0
+}
+
+// LookupResponse returns matching agents.
+type LookupResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Matching records, in agent ID order for colony listings
+	Records []*AgentRecord `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	// Token for the next page of a colony listing; empty on the last page
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupResponse) Reset() {
+	*x = LookupResponse{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupResponse) ProtoMessage() {}
+
+func (x *LookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupResponse.ProtoReflect.Descriptor instead.
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LookupResponse) GetRecords() []*AgentRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *LookupResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// WatchRequest subscribes to registry changes. Empty filter fields match
+// every record.
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only stream changes for this colony
+	ColonyId string `protobuf:"bytes,1,opt,name=colony_id,json=colonyId,proto3" json:"colony_id,omitempty"`
+	// Only stream changes for this reef
+	ReefId string `protobuf:"bytes,2,opt,name=reef_id,json=reefId,proto3" json:"reef_id,omitempty"`
+	// Only stream changes for agents advertising this capability
+	Capability string `protobuf:"bytes,3,opt,name=capability,proto3" json:"capability,omitempty"`
+	// Only stream changes for agents whose labels match this selector
+	Selector string `protobuf:"bytes,4,opt,name=selector,proto3" json:"selector,omitempty"`
+	// Only stream changes for agents publishing or subscribing to this
+	// topic
+	Topic string `protobuf:"bytes,5,opt,name=topic,proto3" json:"topic,omitempty"`
+	// Role in topic to match: "publisher", "subscriber", or empty for both
+	TopicRole string `protobuf:"bytes,6,opt,name=topic_role,json=topicRole,proto3" json:"topic_role,omitempty"`
+	// Resume after the change with this cursor, from a previous
+	// WatchResponse, streaming only the changes missed since. Fails with
+	// OUT_OF_RANGE when they are no longer kept and a resync is required.
+	Cursor        string `protobuf:"bytes,7,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchRequest) GetColonyId() string {
+	if x != nil {
+		return x.ColonyId
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetReefId() string {
+	if x != nil {
+		return x.ReefId
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetCapability() string {
+	if x != nil {
+		return x.Capability
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetTopicRole() string {
+	if x != nil {
+		return x.TopicRole
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+// WatchResponse carries a single registry change.
+type WatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Kind of change
+	Type EventType `protobuf:"varint,1,opt,name=type,proto3,enum=coral.registry.v1.EventType" json:"type,omitempty"`
+	// Record after a put, or the last known record before a delete/expire,
+	// or the record a liveness change (suspect/alive/fail) refers to
+	Record *AgentRecord `protobuf:"bytes,2,opt,name=record,proto3" json:"record,omitempty"`
+	// Cursor of this change, resuming a watch after it
+	Cursor        string `protobuf:"bytes,3,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchResponse) Reset() {
+	*x = WatchResponse{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchResponse) ProtoMessage() {}
+
+func (x *WatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchResponse.ProtoReflect.Descriptor instead.
+func (*WatchResponse) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WatchResponse) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func (x *WatchResponse) GetRecord() *AgentRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+func (x *WatchResponse) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+// RecordEnvelope is an agent's signature over its own record, for peers
+// to check the record against the agent's key instead of trusting the
+// registry.
+type RecordEnvelope struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// JSON encoding of the signed fields and sequence number
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	// The agent's Ed25519 public key, base64 encoded
+	PublicKey string `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// Ed25519 signature of the signing context and payload
+	Signature     []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordEnvelope) Reset() {
+	*x = RecordEnvelope{}
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordEnvelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordEnvelope) ProtoMessage() {}
+
+func (x *RecordEnvelope) ProtoReflect() protoreflect.Message {
+	mi := &file_coral_registry_v1_registry_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordEnvelope.ProtoReflect.Descriptor instead.
+func (*RecordEnvelope) Descriptor() ([]byte, []int) {
+	return file_coral_registry_v1_registry_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RecordEnvelope) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *RecordEnvelope) GetPublicKey() string {
+	if x != nil {
+		return x.PublicKey
+	}
+	return ""
+}
+
+func (x *RecordEnvelope) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+var File_coral_registry_v1_registry_proto protoreflect.FileDescriptor
+
+const file_coral_registry_v1_registry_proto_rawDesc = "" +
+	"\n" +
+	" coral/registry/v1/registry.proto\x12\x11coral.registry.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xae\x06\n" +
+	"\vAgentRecord\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1b\n" +
+	"\tcolony_id\x18\x02 \x01(\tR\bcolonyId\x12\x17\n" +
+	"\areef_id\x18\x03 \x01(\tR\x06reefId\x12\x1c\n" +
+	"\tendpoints\x18\x04 \x03(\tR\tendpoints\x12\"\n" +
+	"\fcapabilities\x18\x05 \x03(\tR\fcapabilities\x12\x1f\n" +
+	"\vttl_seconds\x18\x06 \x01(\x05R\n" +
+	"ttlSeconds\x129\n" +
+	"\n" +
+	"created_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x129\n" +
+	"\n" +
+	"expires_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12-\n" +
+	"\x12observed_endpoints\x18\n" +
+	" \x03(\tR\x11observedEndpoints\x127\n" +
+	"\blocation\x18\v \x01(\v2\x1b.coral.registry.v1.LocationR\blocation\x12@\n" +
+	"\x06rtt_ms\x18\f \x03(\v2).coral.registry.v1.AgentRecord.RttMsEntryR\x05rttMs\x12+\n" +
+	"\x04load\x18\r \x01(\v2\x17.coral.registry.v1.LoadR\x04load\x12\x1c\n" +
+	"\tpublishes\x18\x0e \x03(\tR\tpublishes\x12\x1e\n" +
+	"\n" +
+	"subscribes\x18\x0f \x03(\tR\n" +
+	"subscribes\x12=\n" +
+	"\benvelope\x18\x10 \x01(\v2!.coral.registry.v1.RecordEnvelopeR\benvelope\x12\x10\n" +
+	"\x03seq\x18\x11 \x01(\x04R\x03seq\x12\x1a\n" +
+	"\bmetadata\x18\x12 \x01(\fR\bmetadata\x1a8\n" +
+	"\n" +
+	"RttMsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\rR\x05value:\x028\x01\"D\n" +
+	"\x04Load\x12 \n" +
+	"\vutilization\x18\x01 \x01(\x01R\vutilization\x12\x1a\n" +
+	"\bsessions\x18\x02 \x01(\rR\bsessions\"\x90\x01\n" +
+	"\bLocation\x12\x18\n" +
+	"\acountry\x18\x01 \x01(\tR\acountry\x12\x1c\n" +
+	"\tcontinent\x18\x02 \x01(\tR\tcontinent\x12\x12\n" +
+	"\x04colo\x18\x03 \x01(\tR\x04colo\x12\x1a\n" +
+	"\blatitude\x18\x04 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x05 \x01(\x01R\tlongitude\"I\n" +
+	"\x0fRegisterRequest\x126\n" +
+	"\x06record\x18\x01 \x01(\v2\x1e.coral.registry.v1.AgentRecordR\x06record\"J\n" +
+	"\x10RegisterResponse\x126\n" +
+	"\x06record\x18\x01 \x01(\v2\x1e.coral.registry.v1.AgentRecordR\x06record\".\n" +
+	"\x11DeregisterRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\"\x14\n" +
+	"\x12DeregisterResponse\"h\n" +
+	"\fRenewRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12+\n" +
+	"\x04load\x18\x02 \x01(\v2\x17.coral.registry.v1.LoadR\x04load\x12\x10\n" +
+	"\x03seq\x18\x03 \x01(\x04R\x03seq\"G\n" +
+	"\rRenewResponse\x126\n" +
+	"\x06record\x18\x01 \x01(\v2\x1e.coral.registry.v1.AgentRecordR\x06record\"\xb9\x03\n" +
+	"\rLookupRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1b\n" +
+	"\tcolony_id\x18\x02 \x01(\tR\bcolonyId\x12\x1a\n" +
+	"\bselector\x18\x03 \x01(\tR\bselector\x12\x18\n" +
+	"\anearest\x18\x04 \x01(\rR\anearest\x12!\n" +
+	"\fleast_loaded\x18\x05 \x01(\rR\vleastLoaded\x12\x1a\n" +
+	"\bweighted\x18\x06 \x01(\rR\bweighted\x12\x1b\n" +
+	"\tpage_size\x18\a \x01(\rR\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\b \x01(\tR\tpageToken\x12\x17\n" +
+	"\areef_id\x18\t \x01(\tR\x06reefId\x12\x14\n" +
+	"\x05topic\x18\n" +
+	" \x01(\tR\x05topic\x12\x1d\n" +
+	"\n" +
+	"topic_role\x18\v \x01(\tR\ttopicRole\x12\x18\n" +
+	"\aservice\x18\f \x01(\tR\aservice\x12'\n" +
+	"\x0fservice_version\x18\r \x01(\tR\x0eserviceVersion\x12\x16\n" +
+	"\x06stable\x18\x0e \x01(\bR\x06stable\x12\x16\n" +
+	"\x06canary\x18\x0f \x01(\rR\x06canary\"r\n" +
+	"\x0eLookupResponse\x128\n" +
+	"\arecords\x18\x01 \x03(\v2\x1e.coral.registry.v1.AgentRecordR\arecords\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\xcd\x01\n" +
+	"\fWatchRequest\x12\x1b\n" +
+	"\tcolony_id\x18\x01 \x01(\tR\bcolonyId\x12\x17\n" +
+	"\areef_id\x18\x02 \x01(\tR\x06reefId\x12\x1e\n" +
+	"\n" +
+	"capability\x18\x03 \x01(\tR\n" +
+	"capability\x12\x1a\n" +
+	"\bselector\x18\x04 \x01(\tR\bselector\x12\x14\n" +
+	"\x05topic\x18\x05 \x01(\tR\x05topic\x12\x1d\n" +
+	"\n" +
+	"topic_role\x18\x06 \x01(\tR\ttopicRole\x12\x16\n" +
+	"\x06cursor\x18\a \x01(\tR\x06cursor\"\x91\x01\n" +
+	"\rWatchResponse\x120\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1c.coral.registry.v1.EventTypeR\x04type\x126\n" +
+	"\x06record\x18\x02 \x01(\v2\x1e.coral.registry.v1.AgentRecordR\x06record\x12\x16\n" +
+	"\x06cursor\x18\x03 \x01(\tR\x06cursor\"g\n" +
+	"\x0eRecordEnvelope\x12\x18\n" +
+	"\apayload\x18\x01 \x01(\fR\apayload\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x02 \x01(\tR\tpublicKey\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature*\xac\x01\n" +
+	"\tEventType\x12\x1a\n" +
+	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x12\n" +
+	"\x0eEVENT_TYPE_PUT\x10\x01\x12\x15\n" +
+	"\x11EVENT_TYPE_DELETE\x10\x02\x12\x15\n" +
+	"\x11EVENT_TYPE_EXPIRE\x10\x03\x12\x16\n" +
+	"\x12EVENT_TYPE_SUSPECT\x10\x04\x12\x14\n" +
+	"\x10EVENT_TYPE_ALIVE\x10\x05\x12\x13\n" +
+	"\x0fEVENT_TYPE_FAIL\x10\x062\xaa\x03\n" +
+	"\x0fRegistryService\x12S\n" +
+	"\bRegister\x12\".coral.registry.v1.RegisterRequest\x1a#.coral.registry.v1.RegisterResponse\x12Y\n" +
+	"\n" +
+	"Deregister\x12$.coral.registry.v1.DeregisterRequest\x1a%.coral.registry.v1.DeregisterResponse\x12J\n" +
+	"\x05Renew\x12\x1f.coral.registry.v1.RenewRequest\x1a .coral.registry.v1.RenewResponse\x12M\n" +
+	"\x06Lookup\x12 .coral.registry.v1.LookupRequest\x1a!.coral.registry.v1.LookupResponse\x12L\n" +
+	"\x05Watch\x12\x1f.coral.registry.v1.WatchRequest\x1a .coral.registry.v1.WatchResponse0\x01BUZSgithub.com/coral-mesh/coral-discovery-workers/wasm/gen/coral/registry/v1;registryv1b\x06proto3"
+
+var (
+	file_coral_registry_v1_registry_proto_rawDescOnce sync.Once
+	file_coral_registry_v1_registry_proto_rawDescData []byte
+)
+
+func file_coral_registry_v1_registry_proto_rawDescGZIP() []byte {
+	file_coral_registry_v1_registry_proto_rawDescOnce.Do(func() {
+		file_coral_registry_v1_registry_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_coral_registry_v1_registry_proto_rawDesc), len(file_coral_registry_v1_registry_proto_rawDesc)))
+	})
+	return file_coral_registry_v1_registry_proto_rawDescData
+}
+
+var file_coral_registry_v1_registry_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_coral_registry_v1_registry_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_coral_registry_v1_registry_proto_goTypes = []any{
+	(EventType)(0),                // 0: coral.registry.v1.EventType
+	(*AgentRecord)(nil),           // 1: coral.registry.v1.AgentRecord
+	(*Load)(nil),                  // 2: coral.registry.v1.Load
+	(*Location)(nil),              // 3: coral.registry.v1.Location
+	(*RegisterRequest)(nil),       // 4: coral.registry.v1.RegisterRequest
+	(*RegisterResponse)(nil),      // 5: coral.registry.v1.RegisterResponse
+	(*DeregisterRequest)(nil),     // 6: coral.registry.v1.DeregisterRequest
+	(*DeregisterResponse)(nil),    // 7: coral.registry.v1.DeregisterResponse
+	(*RenewRequest)(nil),          // 8: coral.registry.v1.RenewRequest
+	(*RenewResponse)(nil),         // 9: coral.registry.v1.RenewResponse
+	(*LookupRequest)(nil),         // 10: coral.registry.v1.LookupRequest
+	(*LookupResponse)(nil),        // 11: coral.registry.v1.LookupResponse
+	(*WatchRequest)(nil),          // 12: coral.registry.v1.WatchRequest
+	(*WatchResponse)(nil),         // 13: coral.registry.v1.WatchResponse
+	(*RecordEnvelope)(nil),        // 14: coral.registry.v1.RecordEnvelope
+	nil,                           // 15: coral.registry.v1.AgentRecord.RttMsEntry
+	(*timestamppb.Timestamp)(nil), // 16: google.protobuf.Timestamp
+}
+var file_coral_registry_v1_registry_proto_depIdxs = []int32{
+	16, // 0: coral.registry.v1.AgentRecord.created_at:type_name -> google.protobuf.Timestamp
+	16, // 1: coral.registry.v1.AgentRecord.updated_at:type_name -> google.protobuf.Timestamp
+	16, // 2: coral.registry.v1.AgentRecord.expires_at:type_name -> google.protobuf.Timestamp
+	3,  // 3: coral.registry.v1.AgentRecord.location:type_name -> coral.registry.v1.Location
+	15, // 4: coral.registry.v1.AgentRecord.rtt_ms:type_name -> coral.registry.v1.AgentRecord.RttMsEntry
+	2,  // 5: coral.registry.v1.AgentRecord.load:type_name -> coral.registry.v1.Load
+	14, // 6: coral.registry.v1.AgentRecord.envelope:type_name -> coral.registry.v1.RecordEnvelope
+	1,  // 7: coral.registry.v1.RegisterRequest.record:type_name -> coral.registry.v1.AgentRecord
+	1,  // 8: coral.registry.v1.RegisterResponse.record:type_name -> coral.registry.v1.AgentRecord
+	2,  // 9: coral.registry.v1.RenewRequest.load:type_name -> coral.registry.v1.Load
+	1,  // 10: coral.registry.v1.RenewResponse.record:type_name -> coral.registry.v1.AgentRecord
+	1,  // 11: coral.registry.v1.LookupResponse.records:type_name -> coral.registry.v1.AgentRecord
+	0,  // 12: coral.registry.v1.WatchResponse.type:type_name -> coral.registry.v1.EventType
+	1,  // 13: coral.registry.v1.WatchResponse.record:type_name -> coral.registry.v1.AgentRecord
+	4,  // 14: coral.registry.v1.RegistryService.Register:input_type -> coral.registry.v1.RegisterRequest
+	6,  // 15: coral.registry.v1.RegistryService.Deregister:input_type -> coral.registry.v1.DeregisterRequest
+	8,  // 16: coral.registry.v1.RegistryService.Renew:input_type -> coral.registry.v1.RenewRequest
+	10, // 17: coral.registry.v1.RegistryService.Lookup:input_type -> coral.registry.v1.LookupRequest
+	12, // 18: coral.registry.v1.RegistryService.Watch:input_type -> coral.registry.v1.WatchRequest
+	5,  // 19: coral.registry.v1.RegistryService.Register:output_type -> coral.registry.v1.RegisterResponse
+	7,  // 20: coral.registry.v1.RegistryService.Deregister:output_type -> coral.registry.v1.DeregisterResponse
+	9,  // 21: coral.registry.v1.RegistryService.Renew:output_type -> coral.registry.v1.RenewResponse
+	11, // 22: coral.registry.v1.RegistryService.Lookup:output_type -> coral.registry.v1.LookupResponse
+	13, // 23: coral.registry.v1.RegistryService.Watch:output_type -> coral.registry.v1.WatchResponse
+	19, // [19:24] is the sub-list for method output_type
+	14, // [14:19] is the sub-list for method input_type
+	14, // [14:14] is the sub-list for extension type_name
+	14, // [14:14] is the sub-list for extension extendee
+	0,  // [0:14] is the sub-list for field type_name
+}
+
+func init() { file_coral_registry_v1_registry_proto_init() }
+func file_coral_registry_v1_registry_proto_init() {
+	if File_coral_registry_v1_registry_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_coral_registry_v1_registry_proto_rawDesc), len(file_coral_registry_v1_registry_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_coral_registry_v1_registry_proto_goTypes,
+		DependencyIndexes: file_coral_registry_v1_registry_proto_depIdxs,
+		EnumInfos:         file_coral_registry_v1_registry_proto_enumTypes,
+		MessageInfos:      file_coral_registry_v1_registry_proto_msgTypes,
+	}.Build()
+	File_coral_registry_v1_registry_proto = out.File
+	file_coral_registry_v1_registry_proto_goTypes = nil
+	file_coral_registry_v1_registry_proto_depIdxs = nil
+}
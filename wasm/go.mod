@@ -2,10 +2,26 @@ module github.com/coral-mesh/coral-discovery-workers/wasm
 
 go 1.25
 
-require github.com/coral-mesh/coral-crypto v0.1.1
+require (
+	github.com/coral-mesh/coral-crypto v0.1.1
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/google/uuid v1.6.0
+	github.com/quic-go/quic-go v0.48.2
+	github.com/redis/go-redis/v9 v9.7.0
+	go.etcd.io/bbolt v1.4.0
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.30.0
+	google.golang.org/grpc v1.69.4
+	google.golang.org/protobuf v1.36.6
+)
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/oklog/ulid/v2 v2.1.0 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
 )
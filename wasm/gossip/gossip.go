@@ -0,0 +1,484 @@
+// Package gossip detects failed agents inside a colony with the SWIM
+// membership protocol, so watchers hear of a crashed agent within a few
+// seconds rather than when its lease runs out. Members probe one peer
+// per protocol period, ask others to probe indirectly when it does not
+// answer, and spread the resulting alive, suspect and dead claims by
+// piggybacking them on probes and periodic gossip.
+//
+// The Lifeguard refinements reduce false positives from members that
+// are themselves slow: a member that misses acks and nacks raises its
+// awareness score and probes less aggressively, suspicion timeouts start
+// long and shorten as independent members confirm the suspicion, and a
+// suspect is told of the suspicion in the probe itself so it can refute
+// quickly.
+//
+// State transitions are passed to a Reporter, which *registry.Registry
+// satisfies, and so reach the registry Watch stream. Without
+// Config.Secret anyone who can reach a member's port can join the colony
+// or declare members dead; set it to a key shared by the colony.
+package gossip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults used when the Config leaves a field zero.
+const (
+	DefaultAddr             = ":7947"
+	DefaultProbeInterval    = time.Second
+	DefaultProbeTimeout     = 500 * time.Millisecond
+	DefaultIndirectChecks   = 3
+	DefaultSuspicionMult    = 4
+	DefaultSuspicionMaxMult = 6
+	DefaultRetransmitMult   = 4
+	DefaultGossipInterval   = 200 * time.Millisecond
+	DefaultGossipNodes      = 3
+	DefaultPushPullInterval = 30 * time.Second
+	DefaultMaxAwareness     = 8
+)
+
+// deadRetention is how long dead and departed members are remembered,
+// and gossiped to, before they are forgotten.
+const deadRetention = time.Minute
+
+// changeBuffer is the number of state changes queued for the Reporter.
+const changeBuffer = 256
+
+// ErrNoSeeds is returned by Join when no seed answers.
+var ErrNoSeeds = errors.New("gossip: no seed answered")
+
+// State is a member's state.
+type State string
+
+// Member states.
+const (
+	StateAlive   State = "alive"
+	StateSuspect State = "suspect"
+	StateDead    State = "dead"
+	StateLeft    State = "left"
+)
+
+// Member is a colony member as this node sees it.
+type Member struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	Incarnation uint64 `json:"incarnation"`
+	State       State  `json:"state"`
+}
+
+// Reporter receives state transitions of other members. *registry.Registry
+// satisfies it; errors, such as for members that are not registered, are
+// ignored.
+type Reporter interface {
+	MarkSuspect(ctx context.Context, reefID, colonyID, agentID string) error
+	MarkAlive(ctx context.Context, reefID, colonyID, agentID string) error
+	MarkFailed(ctx context.Context, reefID, colonyID, agentID string) error
+}
+
+// Config holds the configuration for a Node.
+type Config struct {
+	// Name identifies the member, normally its agent ID. Required.
+	Name string
+
+	// Colony is the colony whose members gossip together. Packets for
+	// other colonies are dropped. Required.
+	Colony string
+
+	// Reef is the reef of the colony, passed on to the Reporter. A
+	// *registry.Registry Reporter only knows members of a named reef.
+	Reef string
+
+	// Addr is the UDP address to listen on. Defaults to DefaultAddr.
+	Addr string
+
+	// Advertise is the address peers reach this member at. Defaults to
+	// the listen address; an unspecified host is filled in by peers from
+	// the packet source.
+	Advertise string
+
+	// Seeds lists host:port addresses of members to contact on Join.
+	Seeds []string
+
+	// Secret authenticates packets with HMAC-SHA256 when set. Every
+	// member of the colony must use the same secret.
+	Secret []byte
+
+	// ProbeInterval is the protocol period: one member is probed per
+	// interval.
+	ProbeInterval time.Duration
+
+	// ProbeTimeout is how long a direct probe waits for an ack before
+	// asking IndirectChecks members to probe.
+	ProbeTimeout   time.Duration
+	IndirectChecks int
+
+	// SuspicionMult scales the minimum suspicion timeout,
+	// SuspicionMult * max(1, log10(n)) * ProbeInterval for n members;
+	// SuspicionMaxMult scales the minimum to the timeout a suspicion
+	// starts with before confirmations shorten it.
+	SuspicionMult    int
+	SuspicionMaxMult int
+
+	// RetransmitMult scales how many times each update is piggybacked,
+	// RetransmitMult * ceil(log10(n+1)).
+	RetransmitMult int
+
+	// GossipInterval is how often pending updates are sent to
+	// GossipNodes random members.
+	GossipInterval time.Duration
+	GossipNodes    int
+
+	// PushPullInterval is how often the full member list is exchanged
+	// with a random member to repair missed updates.
+	PushPullInterval time.Duration
+
+	// MaxAwareness bounds the Lifeguard awareness score, which
+	// multiplies probe intervals and timeouts while this member misses
+	// acks.
+	MaxAwareness int
+
+	// Reporter receives other members' state transitions. May be nil.
+	Reporter Reporter
+}
+
+// member is a member's state and an active suspicion of it.
+type member struct {
+	Member
+	changed   time.Time
+	suspicion *suspicion
+}
+
+// change is a state transition for the Reporter.
+type change struct {
+	name  string
+	state State
+}
+
+// Node is a colony member.
+type Node struct {
+	cfg       Config
+	conn      *net.UDPConn
+	addr      string
+	done      chan struct{}
+	closeOnce sync.Once
+	changes   chan change
+
+	mu          sync.Mutex
+	incarnation uint64
+	leaving     bool
+	awareness   int
+	members     map[string]*member
+	order       []string
+	probeIndex  int
+	queue       []*broadcast
+
+	ackMu sync.Mutex
+	seq   uint64
+	acks  map[uint64]*ackWaiter
+}
+
+// New creates a Node listening on cfg.Addr. It answers probes right
+// away; call Join to enter the colony and Run to start probing.
+func New(cfg Config) (*Node, error) {
+	if cfg.Name == "" || cfg.Colony == "" {
+		return nil, fmt.Errorf("gossip: name and colony are required")
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	setDefault(&cfg.ProbeInterval, DefaultProbeInterval)
+	setDefault(&cfg.ProbeTimeout, DefaultProbeTimeout)
+	setDefault(&cfg.GossipInterval, DefaultGossipInterval)
+	setDefault(&cfg.PushPullInterval, DefaultPushPullInterval)
+	setDefault(&cfg.IndirectChecks, DefaultIndirectChecks)
+	setDefault(&cfg.SuspicionMult, DefaultSuspicionMult)
+	setDefault(&cfg.SuspicionMaxMult, DefaultSuspicionMaxMult)
+	setDefault(&cfg.RetransmitMult, DefaultRetransmitMult)
+	setDefault(&cfg.GossipNodes, DefaultGossipNodes)
+	setDefault(&cfg.MaxAwareness, DefaultMaxAwareness)
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("gossip: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("gossip: failed to listen: %w", err)
+	}
+	if cfg.Advertise == "" {
+		cfg.Advertise = conn.LocalAddr().String()
+	}
+
+	n := &Node{
+		cfg:     cfg,
+		conn:    conn,
+		addr:    cfg.Advertise,
+		done:    make(chan struct{}),
+		changes: make(chan change, changeBuffer),
+		// Restarted members must outrank claims about their previous
+		// run, so incarnations start at the clock.
+		incarnation: uint64(time.Now().Unix()),
+		members:     make(map[string]*member),
+		acks:        make(map[uint64]*ackWaiter),
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+func setDefault[T int | time.Duration](v *T, def T) {
+	if *v <= 0 {
+		*v = def
+	}
+}
+
+// Addr returns the address the node listens on.
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Members returns every known member, including this one, sorted by
+// name.
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	out := []Member{n.self()}
+	for _, m := range n.members {
+		out = append(out, m.Member)
+	}
+	n.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Join exchanges member lists with the seeds. It fails only if none of
+// them answers.
+func (n *Node) Join(ctx context.Context) error {
+	if len(n.cfg.Seeds) == 0 {
+		return nil
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(n.cfg.Seeds))
+	for i, seed := range n.cfg.Seeds {
+		wg.Add(1)
+		go func(i int, seed string) {
+			defer wg.Done()
+			errs[i] = n.pushPull(ctx, seed)
+		}(i, seed)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrNoSeeds, errors.Join(errs...))
+}
+
+// Leave tells the colony this member is leaving, so peers report it
+// failed at once rather than after a suspicion timeout. The node keeps
+// answering probes until closed.
+func (n *Node) Leave(ctx context.Context) error {
+	n.mu.Lock()
+	n.leaving = true
+	n.incarnation++
+	left := n.self()
+	left.State = StateLeft
+	var peers []string
+	for _, m := range n.members {
+		if m.State == StateAlive || m.State == StateSuspect {
+			peers = append(peers, m.Addr)
+		}
+	}
+	n.mu.Unlock()
+
+	p := &packet{Type: msgGossip, Updates: []update{{Name: left.Name, Addr: left.Addr, Incarnation: left.Incarnation, State: StateLeft}}}
+	for _, addr := range peers {
+		if err := n.send(addr, p); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close stops the node.
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.conn.Close()
+	})
+	return err
+}
+
+// Run probes members, gossips updates and reports state changes until
+// ctx is done or the node is closed.
+func (n *Node) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-n.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go n.report(ctx)
+	go n.every(ctx, n.cfg.GossipInterval, n.gossip)
+	go n.every(ctx, n.cfg.PushPullInterval, func(ctx context.Context) {
+		if addr := n.randomPeer(); addr != "" {
+			n.pushPull(ctx, addr)
+		}
+	})
+
+	for ctx.Err() == nil {
+		n.probe(ctx)
+	}
+}
+
+// every calls fn every interval until ctx is done.
+func (n *Node) every(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// report passes state changes to the Reporter.
+func (n *Node) report(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-n.changes:
+			r := n.cfg.Reporter
+			if r == nil {
+				continue
+			}
+			switch c.state {
+			case StateSuspect:
+				r.MarkSuspect(ctx, n.cfg.Reef, n.cfg.Colony, c.name)
+			case StateAlive:
+				r.MarkAlive(ctx, n.cfg.Reef, n.cfg.Colony, c.name)
+			case StateDead, StateLeft:
+				r.MarkFailed(ctx, n.cfg.Reef, n.cfg.Colony, c.name)
+			}
+		}
+	}
+}
+
+// gossip sends pending updates to a few random members, and forgets
+// members dead for longer than deadRetention.
+func (n *Node) gossip(context.Context) {
+	n.mu.Lock()
+	now := time.Now()
+	var targets []string
+	var expired []string
+	for name, m := range n.members {
+		if (m.State == StateDead || m.State == StateLeft) && now.Sub(m.changed) > deadRetention {
+			expired = append(expired, name)
+		}
+	}
+	for _, name := range expired {
+		n.forget(name)
+	}
+	for _, i := range rand.Perm(len(n.order)) {
+		if len(targets) == n.cfg.GossipNodes {
+			break
+		}
+		targets = append(targets, n.members[n.order[i]].Addr)
+	}
+	var batches [][]update
+	for range targets {
+		batches = append(batches, n.piggyback(maxPiggyback))
+	}
+	n.mu.Unlock()
+
+	for i, addr := range targets {
+		if len(batches[i]) > 0 {
+			n.send(addr, &packet{Type: msgGossip, Updates: batches[i]})
+		}
+	}
+}
+
+// randomPeer returns the address of a random live member, or "".
+func (n *Node) randomPeer() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, i := range rand.Perm(len(n.order)) {
+		if m := n.members[n.order[i]]; m.State == StateAlive {
+			return m.Addr
+		}
+	}
+	return ""
+}
+
+// pushPull sends the full member list to addr and merges its reply.
+func (n *Node) pushPull(ctx context.Context, addr string) error {
+	w := n.expect()
+	defer n.forgetAck(w.seq)
+	if err := n.send(addr, &packet{Type: msgSync, Seq: w.seq, Updates: n.snapshot()}); err != nil {
+		return err
+	}
+	timer := time.NewTimer(2 * n.cfg.ProbeInterval)
+	defer timer.Stop()
+	select {
+	case <-w.ack:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("gossip: %s did not answer", addr)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// self returns this member's own entry. n.mu must be held.
+func (n *Node) self() Member {
+	state := StateAlive
+	if n.leaving {
+		state = StateLeft
+	}
+	return Member{Name: n.cfg.Name, Addr: n.addr, Incarnation: n.incarnation, State: state}
+}
+
+// snapshot returns every member, including this one, as updates.
+func (n *Node) snapshot() []update {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.self()
+	out := []update{{Name: s.Name, Addr: s.Addr, Incarnation: s.Incarnation, State: s.State}}
+	for _, m := range n.members {
+		out = append(out, update{Name: m.Name, Addr: m.Addr, Incarnation: m.Incarnation, State: m.State, From: n.cfg.Name})
+	}
+	return out
+}
+
+// liveCount is the number of members, including this one, that are not
+// dead. n.mu must be held.
+func (n *Node) liveCount() int {
+	count := 1
+	for _, m := range n.members {
+		if m.State == StateAlive || m.State == StateSuspect {
+			count++
+		}
+	}
+	return count
+}
+
+// retransmits is how many times an update is piggybacked. n.mu must be
+// held.
+func (n *Node) retransmits() int {
+	return n.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(n.liveCount()+1))))
+}
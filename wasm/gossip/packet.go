@@ -0,0 +1,111 @@
+package gossip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Packet types.
+const (
+	msgPing    = "ping"
+	msgAck     = "ack"
+	msgPingReq = "ping_req"
+	msgNack    = "nack"
+	msgGossip  = "gossip"
+	msgSync    = "sync"
+	msgSyncAck = "sync_ack"
+)
+
+// maxPacket is the largest packet read or written.
+const maxPacket = 64 << 10
+
+// maxPiggyback is the budget for updates piggybacked on probes, keeping
+// them well within a typical MTU.
+const maxPiggyback = 1200
+
+// errBadMAC is returned for packets that fail authentication.
+var errBadMAC = errors.New("gossip: packet authentication failed")
+
+// packet is a JSON datagram. When Config.Secret is set it is preceded by
+// an HMAC-SHA256 of the JSON under the secret.
+type packet struct {
+	Type   string `json:"t"`
+	Seq    uint64 `json:"seq,omitempty"`
+	Colony string `json:"colony"`
+	From   string `json:"from"`
+
+	// Target names the member a ping or ping_req is meant for, so a
+	// member that reused another's address does not ack for it.
+	Target     string `json:"target,omitempty"`
+	TargetAddr string `json:"target_addr,omitempty"`
+
+	// Updates are piggybacked membership changes, or the full member
+	// list in sync packets.
+	Updates []update `json:"updates,omitempty"`
+}
+
+// update is a claim about a member's state at an incarnation.
+type update struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	Incarnation uint64 `json:"inc"`
+	State       State  `json:"state"`
+
+	// From is the member making a suspect claim, counted towards
+	// independent confirmations.
+	From string `json:"from,omitempty"`
+}
+
+// encode serializes p, authenticating it when secret is set.
+func encode(p *packet, secret []byte) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) > 0 {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		data = append(mac.Sum(nil), data...)
+	}
+	if len(data) > maxPacket {
+		return nil, fmt.Errorf("gossip: %s packet of %d bytes exceeds %d", p.Type, len(data), maxPacket)
+	}
+	return data, nil
+}
+
+// decode parses and, when secret is set, authenticates a packet.
+func decode(data, secret []byte) (*packet, error) {
+	if len(secret) > 0 {
+		if len(data) < sha256.Size {
+			return nil, errBadMAC
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data[sha256.Size:])
+		if !hmac.Equal(mac.Sum(nil), data[:sha256.Size]) {
+			return nil, errBadMAC
+		}
+		data = data[sha256.Size:]
+	}
+	var p packet
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// fixAddr fills in the host of a member's self-reported addr from the
+// packet source when it listens on an unspecified address.
+func fixAddr(addr string, src *net.UDPAddr) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return src.String()
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return net.JoinHostPort(src.IP.String(), port)
+	}
+	return addr
+}
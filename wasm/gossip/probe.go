@@ -0,0 +1,245 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ackWaiter collects the ack, and nacks, for a probe sequence number.
+type ackWaiter struct {
+	seq   uint64
+	ack   chan struct{}
+	nacks atomic.Int32
+}
+
+// expect registers a waiter for a new sequence number.
+func (n *Node) expect() *ackWaiter {
+	n.ackMu.Lock()
+	defer n.ackMu.Unlock()
+	n.seq++
+	w := &ackWaiter{seq: n.seq, ack: make(chan struct{}, 1)}
+	n.acks[w.seq] = w
+	return w
+}
+
+func (n *Node) forgetAck(seq uint64) {
+	n.ackMu.Lock()
+	delete(n.acks, seq)
+	n.ackMu.Unlock()
+}
+
+func (n *Node) waiter(seq uint64) *ackWaiter {
+	n.ackMu.Lock()
+	defer n.ackMu.Unlock()
+	return n.acks[seq]
+}
+
+// probe runs one protocol period: ping the next member, fall back to
+// indirect probes through IndirectChecks others, and suspect it if
+// nobody gets an ack before the period ends. Periods and timeouts are
+// stretched by the awareness score.
+func (n *Node) probe(ctx context.Context) {
+	n.mu.Lock()
+	target, ok := n.nextTarget()
+	scale := time.Duration(n.awareness + 1)
+	var buddy []update
+	if ok && target.State == StateSuspect {
+		// Tell the suspect it is suspected so it can refute at once.
+		buddy = []update{{Name: target.Name, Addr: target.Addr, Incarnation: target.Incarnation, State: StateSuspect, From: n.cfg.Name}}
+	}
+	n.mu.Unlock()
+
+	interval := n.cfg.ProbeInterval * scale
+	period := time.NewTimer(interval)
+	defer period.Stop()
+	if !ok {
+		select {
+		case <-ctx.Done():
+		case <-period.C:
+		}
+		return
+	}
+
+	w := n.expect()
+	defer n.forgetAck(w.seq)
+	n.send(target.Addr, &packet{Type: msgPing, Seq: w.seq, Target: target.Name, Updates: buddy})
+
+	direct := time.NewTimer(n.cfg.ProbeTimeout * scale)
+	defer direct.Stop()
+	select {
+	case <-w.ack:
+		n.mu.Lock()
+		n.adjustAwareness(-1)
+		n.mu.Unlock()
+		n.wait(ctx, period)
+		return
+	case <-direct.C:
+	case <-ctx.Done():
+		return
+	}
+
+	helpers := n.randomMembers(n.cfg.IndirectChecks, target.Name)
+	for _, h := range helpers {
+		n.send(h.Addr, &packet{Type: msgPingReq, Seq: w.seq, Target: target.Name, TargetAddr: target.Addr})
+	}
+	select {
+	case <-w.ack:
+		n.wait(ctx, period)
+		return
+	case <-period.C:
+	case <-ctx.Done():
+		return
+	}
+
+	// Helpers that could not reach the target still nack; missing nacks
+	// mean the problem is likely here, not at the target.
+	delta := 1
+	if len(helpers) > 0 {
+		delta = len(helpers) - int(w.nacks.Load())
+	}
+	n.mu.Lock()
+	n.adjustAwareness(delta)
+	if m, ok := n.members[target.Name]; ok && m.Incarnation == target.Incarnation {
+		n.apply(update{Name: target.Name, Addr: target.Addr, Incarnation: target.Incarnation, State: StateSuspect, From: n.cfg.Name})
+	}
+	n.mu.Unlock()
+}
+
+// wait blocks until the period timer fires or ctx is done.
+func (n *Node) wait(ctx context.Context, period *time.Timer) {
+	select {
+	case <-period.C:
+	case <-ctx.Done():
+	}
+}
+
+// nextTarget returns the next live member in round-robin order,
+// reshuffling after each round. n.mu must be held.
+func (n *Node) nextTarget() (Member, bool) {
+	for range n.order {
+		if n.probeIndex >= len(n.order) {
+			n.probeIndex = 0
+			rand.Shuffle(len(n.order), func(i, j int) { n.order[i], n.order[j] = n.order[j], n.order[i] })
+		}
+		m := n.members[n.order[n.probeIndex]]
+		n.probeIndex++
+		if m.State == StateAlive || m.State == StateSuspect {
+			return m.Member, true
+		}
+	}
+	return Member{}, false
+}
+
+// randomMembers returns up to k random alive members other than
+// exclude.
+func (n *Node) randomMembers(k int, exclude string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []Member
+	for _, i := range rand.Perm(len(n.order)) {
+		if len(out) == k {
+			break
+		}
+		if m := n.members[n.order[i]]; m.Name != exclude && m.State == StateAlive {
+			out = append(out, m.Member)
+		}
+	}
+	return out
+}
+
+// send writes p to addr, piggybacking pending updates after any it
+// already carries.
+func (n *Node) send(addr string, p *packet) error {
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	p.Colony, p.From = n.cfg.Colony, n.cfg.Name
+	if p.Type != msgSync && p.Type != msgSyncAck && p.Type != msgGossip {
+		n.mu.Lock()
+		p.Updates = append(p.Updates, n.piggyback(maxPiggyback)...)
+		n.mu.Unlock()
+	}
+	data, err := encode(p, n.cfg.Secret)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(data, udp)
+	return err
+}
+
+// readLoop handles packets until the socket is closed.
+func (n *Node) readLoop() {
+	buf := make([]byte, maxPacket)
+	for {
+		size, src, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		p, err := decode(buf[:size], n.cfg.Secret)
+		if err != nil || p.Colony != n.cfg.Colony || p.From == n.cfg.Name {
+			continue
+		}
+
+		n.mu.Lock()
+		for _, u := range p.Updates {
+			if u.Name == p.From && u.State == StateAlive {
+				u.Addr = fixAddr(u.Addr, src)
+			}
+			n.apply(u)
+		}
+		n.mu.Unlock()
+
+		n.handle(p, src.String())
+	}
+}
+
+// handle answers p after its updates were merged.
+func (n *Node) handle(p *packet, src string) {
+	switch p.Type {
+	case msgPing:
+		if p.Target == "" || p.Target == n.cfg.Name {
+			n.send(src, &packet{Type: msgAck, Seq: p.Seq})
+		}
+	case msgAck, msgSyncAck:
+		if w := n.waiter(p.Seq); w != nil {
+			select {
+			case w.ack <- struct{}{}:
+			default:
+			}
+		}
+	case msgNack:
+		if w := n.waiter(p.Seq); w != nil {
+			w.nacks.Add(1)
+		}
+	case msgPingReq:
+		go n.relay(p, src)
+	case msgSync:
+		n.send(src, &packet{Type: msgSyncAck, Seq: p.Seq, Updates: n.snapshot()})
+	}
+}
+
+// relay probes p.Target for a member that could not reach it, acking on
+// its behalf or nacking when the target does not answer either.
+func (n *Node) relay(p *packet, src string) {
+	w := n.expect()
+	defer n.forgetAck(w.seq)
+	n.send(p.TargetAddr, &packet{Type: msgPing, Seq: w.seq, Target: p.Target})
+
+	timer := time.NewTimer(n.cfg.ProbeTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ack:
+		n.send(src, &packet{Type: msgAck, Seq: p.Seq})
+	case <-timer.C:
+		n.send(src, &packet{Type: msgNack, Seq: p.Seq})
+	case <-n.done:
+	}
+}
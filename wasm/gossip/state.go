@@ -0,0 +1,256 @@
+package gossip
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// broadcast is an update waiting to be piggybacked.
+type broadcast struct {
+	update
+	transmits int
+}
+
+// suspicion times out a suspect member. Its timeout starts at max and
+// shrinks towards min as further members independently confirm it
+// (Lifeguard's local health aware suspicion).
+type suspicion struct {
+	incarnation uint64
+	from        map[string]bool
+	k           int
+	min, max    time.Duration
+	start       time.Time
+	timer       *time.Timer
+}
+
+// timeout is the suspicion timeout after confirmations beyond the first
+// suspector.
+func (s *suspicion) timeout() time.Duration {
+	if s.k < 1 {
+		return s.min
+	}
+	c := len(s.from) - 1
+	if c <= 0 {
+		return s.max
+	}
+	frac := math.Log(float64(c)+1) / math.Log(float64(s.k)+1)
+	d := s.max - time.Duration(frac*float64(s.max-s.min))
+	if d < s.min {
+		d = s.min
+	}
+	return d
+}
+
+// confirm counts a suspicion claim from a member and shortens the
+// timeout. It reports whether the member was new.
+func (s *suspicion) confirm(from string) bool {
+	if from == "" || s.from[from] || len(s.from) > s.k {
+		return false
+	}
+	s.from[from] = true
+	remaining := s.timeout() - time.Since(s.start)
+	if remaining < 0 {
+		remaining = 0
+	}
+	s.timer.Reset(remaining)
+	return true
+}
+
+// apply merges a claim about a member. n.mu must be held.
+func (n *Node) apply(u update) {
+	if u.Name == n.cfg.Name {
+		n.refute(u)
+		return
+	}
+	switch u.State {
+	case StateAlive:
+		n.applyAlive(u)
+	case StateSuspect:
+		n.applySuspect(u)
+	case StateDead, StateLeft:
+		n.applyDead(u)
+	}
+}
+
+// refute answers a suspect or dead claim about this member by
+// announcing a higher incarnation. Being suspected is also a hint that
+// this member is slow, so its awareness rises.
+func (n *Node) refute(u update) {
+	if u.State == StateAlive || u.Incarnation < n.incarnation || n.leaving {
+		return
+	}
+	n.incarnation = u.Incarnation + 1
+	n.adjustAwareness(1)
+	s := n.self()
+	n.enqueue(update{Name: s.Name, Addr: s.Addr, Incarnation: s.Incarnation, State: StateAlive})
+}
+
+func (n *Node) applyAlive(u update) {
+	m, ok := n.members[u.Name]
+	if !ok {
+		n.members[u.Name] = &member{Member: Member{Name: u.Name, Addr: u.Addr, Incarnation: u.Incarnation, State: StateAlive}, changed: time.Now()}
+		// Insert at a random position so new members are probed within a
+		// round.
+		i := rand.Intn(len(n.order) + 1)
+		n.order = append(n.order, "")
+		copy(n.order[i+1:], n.order[i:])
+		n.order[i] = u.Name
+		n.enqueue(u)
+		return
+	}
+	if u.Incarnation <= m.Incarnation {
+		return
+	}
+	prev := m.State
+	m.stopSuspicion()
+	m.Addr, m.Incarnation, m.State, m.changed = u.Addr, u.Incarnation, StateAlive, time.Now()
+	n.enqueue(u)
+	if prev != StateAlive {
+		n.emit(m.Name, StateAlive)
+	}
+}
+
+func (n *Node) applySuspect(u update) {
+	m, ok := n.members[u.Name]
+	if !ok || u.Incarnation < m.Incarnation || m.State == StateDead || m.State == StateLeft {
+		return
+	}
+	if m.State == StateSuspect && m.suspicion != nil && u.Incarnation == m.suspicion.incarnation {
+		if m.suspicion.confirm(u.From) {
+			n.enqueue(u)
+		}
+		return
+	}
+
+	m.stopSuspicion()
+	m.Incarnation, m.State, m.changed = u.Incarnation, StateSuspect, time.Now()
+	m.suspicion = n.newSuspicion(m.Name, u.Incarnation, u.From)
+	n.enqueue(u)
+	n.emit(m.Name, StateSuspect)
+}
+
+func (n *Node) applyDead(u update) {
+	m, ok := n.members[u.Name]
+	if !ok || u.Incarnation < m.Incarnation || m.State == StateDead || m.State == StateLeft {
+		return
+	}
+	m.stopSuspicion()
+	m.Incarnation, m.State, m.changed = u.Incarnation, u.State, time.Now()
+	n.enqueue(u)
+	n.emit(m.Name, u.State)
+}
+
+// newSuspicion starts the suspicion timer of name. n.mu must be held.
+func (n *Node) newSuspicion(name string, incarnation uint64, from string) *suspicion {
+	count := n.liveCount()
+	scale := math.Max(1, math.Log10(float64(count)))
+	min := time.Duration(float64(n.cfg.SuspicionMult) * scale * float64(n.cfg.ProbeInterval))
+	k := n.cfg.SuspicionMult - 2
+	if count-2 < k {
+		k = 0
+	}
+	s := &suspicion{
+		incarnation: incarnation,
+		from:        map[string]bool{},
+		k:           k,
+		min:         min,
+		max:         time.Duration(n.cfg.SuspicionMaxMult) * min,
+		start:       time.Now(),
+	}
+	if from != "" {
+		s.from[from] = true
+	}
+	s.timer = time.AfterFunc(s.timeout(), func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if m, ok := n.members[name]; ok && m.suspicion == s {
+			n.applyDead(update{Name: name, Addr: m.Addr, Incarnation: incarnation, State: StateDead, From: n.cfg.Name})
+		}
+	})
+	return s
+}
+
+func (m *member) stopSuspicion() {
+	if m.suspicion != nil {
+		m.suspicion.timer.Stop()
+		m.suspicion = nil
+	}
+}
+
+// forget drops a member. n.mu must be held.
+func (n *Node) forget(name string) {
+	if m, ok := n.members[name]; ok {
+		m.stopSuspicion()
+		delete(n.members, name)
+	}
+	for i, o := range n.order {
+		if o == name {
+			n.order = append(n.order[:i], n.order[i+1:]...)
+			if n.probeIndex > i {
+				n.probeIndex--
+			}
+			break
+		}
+	}
+}
+
+// emit queues a change for the Reporter, dropping it if Run is not
+// keeping up.
+func (n *Node) emit(name string, state State) {
+	select {
+	case n.changes <- change{name: name, state: state}:
+	default:
+	}
+}
+
+// enqueue queues u for piggybacking, replacing older updates about the
+// same member, except that suspicion confirmations from different
+// members are kept side by side. n.mu must be held.
+func (n *Node) enqueue(u update) {
+	for i, b := range n.queue {
+		if b.Name == u.Name && (b.State != StateSuspect || u.State != StateSuspect || b.From == u.From) {
+			n.queue = append(n.queue[:i], n.queue[i+1:]...)
+			break
+		}
+	}
+	n.queue = append(n.queue, &broadcast{update: u})
+}
+
+// piggyback takes the least transmitted updates that fit in budget
+// bytes. n.mu must be held.
+func (n *Node) piggyback(budget int) []update {
+	if len(n.queue) == 0 {
+		return nil
+	}
+	sort.SliceStable(n.queue, func(i, j int) bool { return n.queue[i].transmits < n.queue[j].transmits })
+	limit := n.retransmits()
+	var out []update
+	kept := n.queue[:0]
+	for _, b := range n.queue {
+		if data, _ := json.Marshal(b.update); len(data) < budget {
+			budget -= len(data)
+			out = append(out, b.update)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	n.queue = kept
+	return out
+}
+
+// adjustAwareness moves the awareness score by delta within bounds.
+// n.mu must be held.
+func (n *Node) adjustAwareness(delta int) {
+	n.awareness += delta
+	if n.awareness < 0 {
+		n.awareness = 0
+	}
+	if n.awareness > n.cfg.MaxAwareness-1 {
+		n.awareness = n.cfg.MaxAwareness - 1
+	}
+}
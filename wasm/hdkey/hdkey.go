@@ -0,0 +1,100 @@
+// Package hdkey derives per-agent Ed25519 keys from a colony master key,
+// so a colony can provision any number of agents while storing a single
+// private key. Derivation is hardened: a derived key reveals nothing about
+// its master or siblings, and public keys cannot be derived without the
+// master private key.
+//
+// A path is a "/"-separated list of segments such as "agent:web-1".
+// Each segment derives a child seed from its parent's with HKDF-SHA256,
+// so Derive(Derive(m, "a"), "b") equals Derive(m, "a/b").
+package hdkey
+
+import (
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+)
+
+// salt domain-separates hdkey derivation from other HKDF uses.
+const salt = "coral-hdkey-v1"
+
+// ErrInvalidPath is returned for empty paths or empty segments, and for
+// agent IDs that are empty or contain "/".
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// AgentPath returns the derivation path of agentID's signing key. The
+// path is a single segment: an agent ID containing "/" is rejected, as
+// the key of agent "web" would otherwise derive that of "web/1".
+func AgentPath(agentID string) (string, error) {
+	if agentID == "" || strings.Contains(agentID, "/") {
+		return "", fmt.Errorf("%w: agent ID %q", ErrInvalidPath, agentID)
+	}
+	return "agent:" + agentID, nil
+}
+
+// Derive returns the key at path below master.
+func Derive(master ed25519.PrivateKey, path string) (ed25519.PrivateKey, error) {
+	if len(master) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes", ed25519.PrivateKeySize)
+	}
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+
+	seed := master.Seed()
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		child, err := hkdf.Key(sha256.New, seed, []byte(salt), segment, ed25519.SeedSize)
+		if err != nil {
+			return nil, err
+		}
+		seed = child
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// DeriveKeyPair derives the key pair at path below master. Its ID is the
+// master ID and path joined by "/", so the kid alone names the key.
+func DeriveKeyPair(master *keys.KeyPair, path string) (*keys.KeyPair, error) {
+	priv, err := Derive(master.PrivateKey, path)
+	if err != nil {
+		return nil, err
+	}
+	return &keys.KeyPair{
+		ID:         KeyID(master.ID, path),
+		Algorithm:  master.Algorithm,
+		CreatedAt:  time.Now(),
+		PublicKey:  priv.Public().(ed25519.PublicKey),
+		PrivateKey: priv,
+	}, nil
+}
+
+// KeyID returns the ID of the key at path below the key masterID.
+func KeyID(masterID, path string) string {
+	return masterID + "/" + path
+}
+
+// JWK is a public JWK annotated with its derivation. Verifiers ignore the
+// extra members; operators use them to re-derive the private key.
+type JWK struct {
+	keys.JWK
+
+	// Parent is the ID of the master key.
+	Parent string `json:"coral_parent"`
+
+	// Path is the derivation path below Parent.
+	Path string `json:"coral_path"`
+}
+
+// ToJWK returns the annotated JWK of a key derived from masterID at path.
+func ToJWK(kp *keys.KeyPair, masterID, path string) JWK {
+	return JWK{JWK: kp.ToJWK(), Parent: masterID, Path: path}
+}
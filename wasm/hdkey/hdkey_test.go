@@ -0,0 +1,91 @@
+package hdkey_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/hdkey"
+)
+
+var master = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+func derive(t *testing.T, key ed25519.PrivateKey, path string) ed25519.PrivateKey {
+	t.Helper()
+	child, err := hdkey.Derive(key, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return child
+}
+
+func TestDerive(t *testing.T) {
+	path, err := hdkey.AgentPath("web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := derive(t, master, path)
+	if !web.Equal(derive(t, master, "agent:web-1")) {
+		t.Fatal("derivation is not deterministic")
+	}
+	// Paths compose segment by segment.
+	if !derive(t, derive(t, master, "a"), "b").Equal(derive(t, master, "a/b")) {
+		t.Fatal(`Derive(Derive(m, "a"), "b") differs from Derive(m, "a/b")`)
+	}
+
+	seen := map[string]bool{string(master.Seed()): true}
+	for _, path := range []string{"agent:web-1", "agent:web-2", "agent:web-1/x", "a/b", "ab", "b/a"} {
+		seed := string(derive(t, master, path).Seed())
+		if seen[seed] {
+			t.Fatalf("path %s derives a key already seen", path)
+		}
+		seen[seed] = true
+	}
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	if derive(t, other, "agent:web-1").Equal(web) {
+		t.Fatal("two masters derive the same key")
+	}
+}
+
+func TestInvalidPath(t *testing.T) {
+	for _, path := range []string{"", "/", "a/", "/a", "a//b"} {
+		if _, err := hdkey.Derive(master, path); !errors.Is(err, hdkey.ErrInvalidPath) {
+			t.Errorf("path %q: %v, want ErrInvalidPath", path, err)
+		}
+	}
+	if _, err := hdkey.Derive(master.Seed(), "a"); err == nil {
+		t.Error("derived from a seed in place of a private key")
+	}
+}
+
+// TestAgentPath checks that no agent's key derives another's.
+func TestAgentPath(t *testing.T) {
+	for _, id := range []string{"", "web/1", "/web", "web/"} {
+		if _, err := hdkey.AgentPath(id); !errors.Is(err, hdkey.ErrInvalidPath) {
+			t.Errorf("agent %q: %v, want ErrInvalidPath", id, err)
+		}
+	}
+	if path, err := hdkey.AgentPath("web"); err != nil || path != "agent:web" {
+		t.Fatalf("path of web: %q, %v", path, err)
+	}
+}
+
+func TestDeriveKeyPair(t *testing.T) {
+	kp, err := hdkey.DeriveKeyPair(&keys.KeyPair{ID: "colony-1", Algorithm: "EdDSA", PrivateKey: master, PublicKey: master.Public().(ed25519.PublicKey)}, "agent:web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kp.ID != "colony-1/agent:web-1" || kp.ID != hdkey.KeyID("colony-1", "agent:web-1") {
+		t.Fatalf("key ID %q", kp.ID)
+	}
+	if !kp.PrivateKey.Equal(derive(t, master, "agent:web-1")) || !kp.PublicKey.Equal(kp.PrivateKey.Public()) {
+		t.Fatal("key pair does not hold the derived key")
+	}
+	jwk := hdkey.ToJWK(kp, "colony-1", "agent:web-1")
+	if jwk.Parent != "colony-1" || jwk.Path != "agent:web-1" || jwk.KID != kp.ID {
+		t.Fatalf("JWK %+v", jwk)
+	}
+}
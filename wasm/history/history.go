@@ -0,0 +1,412 @@
+// Package history archives registry changes so that operators can
+// reconstruct the mesh after the fact: which agents were registered,
+// where, and when they came and went. An Archive watches the registry
+// and appends each membership change to a log in the store. Lease
+// renewals and load reports, which only move timestamps, are left out.
+// Prune compacts the log by folding entries older than the retention
+// into a checkpoint of the topology at that time, so the topology can
+// be rebuilt at any time since the checkpoint.
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Store keys: log entries sort by time under logPrefix, and the
+// checkpoint holds the topology entries were folded into.
+const (
+	logPrefix     = "history/log/"
+	checkpointKey = "history/checkpoint"
+)
+
+// Archive defaults.
+const (
+	DefaultRetention = 30 * 24 * time.Hour
+	DefaultPageSize  = 500
+	MaxPageSize      = 5000
+)
+
+var (
+	// ErrCompacted is returned for topology queries before the
+	// checkpoint, whose changes are no longer kept.
+	ErrCompacted = errors.New("history compacted")
+
+	// ErrInvalidCursor is returned for cursors that were not issued by
+	// History.
+	ErrInvalidCursor = errors.New("invalid history cursor")
+)
+
+// Entry is one archived registry change.
+type Entry struct {
+	// ID orders entries and resumes listings (see Page.NextCursor).
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+
+	// Type is the registry.EventType name: put, delete, expire,
+	// suspect, alive or fail.
+	Type   string           `json:"type"`
+	Record *registry.Record `json:"record"`
+}
+
+// present reports whether the agent is registered and live after e.
+func (e *Entry) present() bool {
+	switch e.Type {
+	case "delete", "expire", "fail":
+		return false
+	}
+	return true
+}
+
+// checkpoint is the topology at Time, the newest entry folded into it.
+type checkpoint struct {
+	Time    time.Time          `json:"time"`
+	Records []*registry.Record `json:"records"`
+}
+
+// Config holds the configuration for an Archive.
+type Config struct {
+	// Registry is watched for changes. Required.
+	Registry *registry.Registry
+
+	// Store keeps the log and checkpoint. Defaults to an in-memory
+	// store.
+	Store store.Store
+
+	// Retention is how long Prune keeps individual entries before
+	// folding them into the checkpoint. Defaults to DefaultRetention.
+	Retention time.Duration
+
+	// Clock stamps entries. Defaults to clock.System.
+	Clock clock.Clock
+}
+
+// Archive records registry changes and answers historical queries.
+type Archive struct {
+	registry  *registry.Registry
+	store     store.Store
+	retention time.Duration
+	clock     clock.Clock
+
+	mu      sync.Mutex
+	running bool
+	seq     uint64
+	last    map[string]string // agent key -> Fingerprint of its last put
+}
+
+// New creates an Archive from cfg.
+func New(cfg Config) (*Archive, error) {
+	if cfg.Registry == nil {
+		return nil, errors.New("history: registry is required")
+	}
+	if cfg.Store == nil {
+		cfg.Store = store.NewMemory()
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	return &Archive{
+		registry:  cfg.Registry,
+		store:     cfg.Store,
+		retention: cfg.Retention,
+		clock:     clock.Or(cfg.Clock),
+		last:      make(map[string]string),
+	}, nil
+}
+
+// Run archives registry changes until ctx is done (see
+// registry.Registry.Follow).
+func (a *Archive) Run(ctx context.Context) {
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		return
+	}
+	a.running = true
+	a.mu.Unlock()
+
+	a.registry.Follow(ctx, registry.Filter{}, func(ev registry.Event) {
+		_ = a.Record(ctx, ev)
+	})
+}
+
+// Record appends ev to the log, unless it is a put that only renews the
+// agent's lease or reports its load.
+func (a *Archive) Record(ctx context.Context, ev registry.Event) error {
+	if ev.Record == nil {
+		return nil
+	}
+	key := agentKey(ev.Record)
+	now := a.clock.Now().UTC()
+
+	a.mu.Lock()
+	switch ev.Type {
+	case registry.EventPut:
+		fp := ev.Record.Fingerprint()
+		if a.last[key] == fp {
+			a.mu.Unlock()
+			return nil
+		}
+		a.last[key] = fp
+	case registry.EventDelete, registry.EventExpire, registry.EventFail:
+		delete(a.last, key)
+	}
+	a.seq++
+	id := fmt.Sprintf("%020d-%08d", now.UnixNano(), a.seq%1e8)
+	a.mu.Unlock()
+
+	data, err := json.Marshal(&Entry{ID: id, Time: now, Type: ev.Type.String(), Record: ev.Record})
+	if err != nil {
+		return err
+	}
+	_, err = a.store.Put(ctx, logPrefix+id, data)
+	return err
+}
+
+// agentKey identifies an agent across reefs.
+func agentKey(rec *registry.Record) string {
+	return rec.ReefID + "/" + rec.ColonyID + "/" + rec.AgentID
+}
+
+// Query selects archived entries.
+type Query struct {
+	// ReefID restricts entries to agents of the reef. Required.
+	ReefID string
+
+	// ColonyID, when set, restricts entries to agents of the colony.
+	ColonyID string
+
+	// Since and Until, when set, bound the entries' times: after Since,
+	// and not after Until.
+	Since time.Time
+	Until time.Time
+
+	// Cursor continues a listing from its Page.NextCursor.
+	Cursor string
+
+	// Limit bounds the entries returned. 0 uses DefaultPageSize; it is
+	// capped at MaxPageSize.
+	Limit int
+}
+
+// Page is one page of History.
+type Page struct {
+	Entries []*Entry `json:"entries"`
+
+	// NextCursor continues the listing; empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// History returns the archived entries matching q, oldest first.
+// Entries folded into the checkpoint are no longer listed.
+func (a *Archive) History(ctx context.Context, q Query) (*Page, error) {
+	if q.Cursor != "" && !validID(q.Cursor) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCursor, q.Cursor)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	entries, err := a.store.List(ctx, logPrefix)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{Entries: []*Entry{}}
+	for _, entry := range entries {
+		if q.Cursor != "" && strings.TrimPrefix(entry.Key, logPrefix) <= q.Cursor {
+			continue
+		}
+		e, err := decodeEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !q.Until.IsZero() && e.Time.After(q.Until) {
+			break
+		}
+		if !e.Time.After(q.Since) || !matches(e.Record, q.ReefID, q.ColonyID) {
+			continue
+		}
+		if len(page.Entries) == limit {
+			page.NextCursor = page.Entries[limit-1].ID
+			break
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	return page, nil
+}
+
+// Topology returns the agents of reefID, or only of colonyID when set,
+// that were registered and live at at, in agent ID order, as last
+// recorded before then. It returns ErrCompacted when at precedes the
+// checkpoint.
+func (a *Archive) Topology(ctx context.Context, reefID, colonyID string, at time.Time) ([]*registry.Record, error) {
+	cp, _, err := a.loadCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if at.Before(cp.Time) {
+		return nil, fmt.Errorf("%w: changes before %s are no longer kept", ErrCompacted, cp.Time.Format(time.RFC3339))
+	}
+	entries, err := a.store.List(ctx, logPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make(map[string]*registry.Record, len(cp.Records))
+	for _, rec := range cp.Records {
+		agents[agentKey(rec)] = rec
+	}
+	for _, entry := range entries {
+		e, err := decodeEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if e.Time.After(at) {
+			break
+		}
+		apply(agents, e)
+	}
+
+	out := make([]*registry.Record, 0, len(agents))
+	for _, rec := range agents {
+		if matches(rec, reefID, colonyID) {
+			out = append(out, rec)
+		}
+	}
+	sortRecords(out)
+	return out, nil
+}
+
+// Prune folds the entries older than the retention into the checkpoint
+// and returns how many were folded.
+func (a *Archive) Prune(ctx context.Context) (int, error) {
+	entries, err := a.store.List(ctx, logPrefix)
+	if err != nil {
+		return 0, err
+	}
+	cp, rev, err := a.loadCheckpoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := a.clock.Now().Add(-a.retention)
+	agents := make(map[string]*registry.Record, len(cp.Records))
+	for _, rec := range cp.Records {
+		agents[agentKey(rec)] = rec
+	}
+	var folded []string
+	for _, entry := range entries {
+		e, err := decodeEntry(entry)
+		if err != nil {
+			return 0, err
+		}
+		if !e.Time.Before(cutoff) {
+			break
+		}
+		apply(agents, e)
+		cp.Time = e.Time
+		folded = append(folded, entry.Key)
+	}
+	if len(folded) == 0 {
+		return 0, nil
+	}
+
+	cp.Records = make([]*registry.Record, 0, len(agents))
+	for _, rec := range agents {
+		cp.Records = append(cp.Records, rec)
+	}
+	sortRecords(cp.Records)
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return 0, err
+	}
+	// Only the instance that wins the checkpoint deletes the entries it
+	// folded in.
+	if _, err := a.store.CompareAndSwap(ctx, checkpointKey, rev, data); err != nil {
+		return 0, err
+	}
+	for _, key := range folded {
+		if err := a.store.Delete(ctx, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return 0, err
+		}
+	}
+	return len(folded), nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (a *Archive) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = a.Prune(ctx)
+		}
+	}
+}
+
+// apply updates agents, keyed by agentKey, with e.
+func apply(agents map[string]*registry.Record, e *Entry) {
+	key := agentKey(e.Record)
+	if e.present() {
+		agents[key] = e.Record
+	} else {
+		delete(agents, key)
+	}
+}
+
+func matches(rec *registry.Record, reefID, colonyID string) bool {
+	return rec.ReefID == reefID && (colonyID == "" || rec.ColonyID == colonyID)
+}
+
+func sortRecords(recs []*registry.Record) {
+	slices.SortFunc(recs, func(a, b *registry.Record) int {
+		return strings.Compare(agentKey(a), agentKey(b))
+	})
+}
+
+// loadCheckpoint reads the checkpoint and its revision; a missing
+// checkpoint is empty, at the zero time.
+func (a *Archive) loadCheckpoint(ctx context.Context) (*checkpoint, uint64, error) {
+	entry, err := a.store.Get(ctx, checkpointKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return &checkpoint{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(entry.Value, &cp); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode history checkpoint: %w", err)
+	}
+	return &cp, entry.Revision, nil
+}
+
+func decodeEntry(entry *store.Entry) (*Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(entry.Value, &e); err != nil {
+		return nil, fmt.Errorf("failed to decode history entry %s: %w", entry.Key, err)
+	}
+	return &e, nil
+}
+
+// validID reports whether id has the form of an entry ID.
+func validID(id string) bool {
+	nanos, seq, ok := strings.Cut(id, "-")
+	return ok && len(nanos) == 20 && len(seq) == 8 && strings.Trim(nanos+seq, "0123456789") == ""
+}
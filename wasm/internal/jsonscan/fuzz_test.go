@@ -0,0 +1,54 @@
+package jsonscan
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// FuzzScanner checks that documents are accepted as encoding/json accepts
+// them and that strings decode to the same values.
+func FuzzScanner(f *testing.F) {
+	for _, seed := range []string{
+		`{"a":[1,-0.5e+3,true,false,null],"b":{"c":"d"}}`,
+		`"plain"`,
+		`"esc\"\\\/\b\f\n\r\té"`,
+		`"😀 \ud83d \ude00 \ud800A"`,
+		"\"\xff\xfe invalid \xc3\"",
+		` [ ] `,
+		`01`,
+		`1.`,
+		`-`,
+		`1e`,
+		`[1,]`,
+		`{"a" 1}`,
+		`"\u12"`,
+		`"\x"`,
+		"\"\x01\"",
+		`tru`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := New(data)
+		s.Skip()
+		err := s.End()
+		if valid := json.Valid(data); valid != (err == nil) {
+			t.Fatalf("json.Valid is %t, scanner error is %v", valid, err)
+		}
+
+		var want string
+		if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\n\r"), []byte(`"`)) || json.Unmarshal(data, &want) != nil {
+			return
+		}
+		s = New(data)
+		got := s.Str()
+		if err := s.End(); err != nil {
+			t.Fatalf("string not read: %v", err)
+		}
+		if !bytes.Equal(got, []byte(want)) {
+			t.Fatalf("decoded %q, encoding/json %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,352 @@
+// Package jsonscan reads the members of JSON objects in place, without
+// reflection or decoding them into maps. It backs the ticket fast path of
+// package verify and the lite Wasm build, which leaves out encoding/json.
+package jsonscan
+
+import (
+	"errors"
+	"strconv"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of skipped values, as encoding/json does.
+const maxDepth = 10000
+
+// Scanner reads a JSON document. Errors are sticky: after the first,
+// reads return zero values and End and Err report it.
+type Scanner struct {
+	data  []byte
+	pos   int
+	depth int
+	err   error
+}
+
+// New returns a Scanner reading data.
+func New(data []byte) Scanner {
+	return Scanner{data: data}
+}
+
+// Err returns the first error met, if any.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Expect consumes c.
+func (s *Scanner) Expect(c byte) {
+	if s.Peek() != c {
+		s.syntax()
+		return
+	}
+	s.pos++
+}
+
+// More consumes the separator before element i of an array or object, or
+// its closing character, reporting whether there is an element to read.
+func (s *Scanner) More(i int, close byte) bool {
+	if s.err != nil {
+		return false
+	}
+	c := s.Peek()
+	if c == close {
+		s.pos++
+		return false
+	}
+	if i > 0 {
+		if c != ',' {
+			s.syntax()
+			return false
+		}
+		s.pos++
+	}
+	return true
+}
+
+// Name reads a member name and the colon after it.
+func (s *Scanner) Name() []byte {
+	name := s.Str()
+	s.Expect(':')
+	return name
+}
+
+// End checks that nothing but whitespace follows the value read.
+func (s *Scanner) End() error {
+	if s.Peek(); s.err == nil && s.pos < len(s.data) {
+		s.syntax()
+	}
+	return s.err
+}
+
+// Str reads a string. Strings holding escapes or invalid UTF-8 are
+// decoded as encoding/json would into a new slice; the rest are returned
+// in place.
+func (s *Scanner) Str() []byte {
+	if s.Peek() != '"' {
+		s.syntax()
+		return nil
+	}
+	start, escaped, ascii := s.pos+1, false, true
+	for i := start; i < len(s.data); i++ {
+		switch c := s.data[i]; {
+		case c == '"':
+			s.pos = i + 1
+			v := s.data[start:i]
+			if escaped || !ascii && !utf8.Valid(v) {
+				u, err := unquote(v)
+				if err != nil {
+					s.Fail(err)
+					return nil
+				}
+				return u
+			}
+			return v
+		case c == '\\':
+			escaped = true
+			i++
+		case c < 0x20:
+			s.pos = i
+			s.syntax()
+			return nil
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	s.pos = len(s.data)
+	s.syntax()
+	return nil
+}
+
+// StringOrSkip reads a string, or skips a value of another type and
+// returns nil.
+func (s *Scanner) StringOrSkip() []byte {
+	if s.Peek() != '"' {
+		s.Skip()
+		return nil
+	}
+	return s.Str()
+}
+
+// StringInto reads a string into dst, leaving it unchanged for null.
+func (s *Scanner) StringInto(dst *string) {
+	if s.Peek() == 'n' {
+		s.Literal("null")
+		return
+	}
+	if v := s.Str(); s.err == nil {
+		*dst = string(v)
+	}
+}
+
+// Number reads a number.
+func (s *Scanner) Number() []byte {
+	s.Peek()
+	n := ScanNumber(s.data[s.pos:])
+	if n == 0 {
+		s.syntax()
+		return nil
+	}
+	s.pos += n
+	return s.data[s.pos-n : s.pos]
+}
+
+// Literal consumes word.
+func (s *Scanner) Literal(word string) {
+	s.Peek()
+	if len(s.data)-s.pos < len(word) || string(s.data[s.pos:s.pos+len(word)]) != word {
+		s.syntax()
+		return
+	}
+	s.pos += len(word)
+}
+
+// Skip reads a value of any type.
+func (s *Scanner) Skip() {
+	if s.err != nil {
+		return
+	}
+	switch c := s.Peek(); c {
+	case '"':
+		s.Str()
+	case '{', '[':
+		if s.depth++; s.depth > maxDepth {
+			s.Fail(errors.New("exceeded max depth"))
+			return
+		}
+		s.pos++
+		end := byte('}')
+		if c == '[' {
+			end = ']'
+		}
+		for i := 0; s.More(i, end); i++ {
+			if c == '{' {
+				s.Name()
+			}
+			s.Skip()
+		}
+		s.depth--
+	case 't':
+		s.Literal("true")
+	case 'f':
+		s.Literal("false")
+	case 'n':
+		s.Literal("null")
+	default:
+		s.Number()
+	}
+}
+
+// Peek skips whitespace and returns the next byte, or 0 at the end and
+// for a NUL byte, which no JSON value starts with.
+func (s *Scanner) Peek() byte {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return s.data[s.pos]
+		}
+	}
+	return 0
+}
+
+// Fail records err unless an error was met before.
+func (s *Scanner) Fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Scanner) syntax() {
+	if s.pos >= len(s.data) {
+		s.Fail(errors.New("unexpected end of JSON input"))
+		return
+	}
+	s.Fail(errors.New("invalid character " + strconv.QuoteRune(rune(s.data[s.pos])) + " at offset " + strconv.Itoa(s.pos)))
+}
+
+// unquote decodes the escapes in the string contents v and replaces
+// invalid UTF-8 and unpaired surrogates with U+FFFD, as encoding/json
+// does.
+func unquote(v []byte) ([]byte, error) {
+	out := make([]byte, 0, len(v))
+	for i := 0; i < len(v); {
+		c := v[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(v) {
+				return nil, errors.New("unexpected end of JSON input")
+			}
+			e := v[i+1]
+			i += 2
+			switch e {
+			case '"', '\\', '/':
+				out = append(out, e)
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'u':
+				r, ok := hex4(v[i:])
+				if !ok {
+					return nil, errors.New("invalid unicode escape in string")
+				}
+				i += 4
+				if utf16.IsSurrogate(r) {
+					r2, ok := rune(-1), false
+					if len(v)-i >= 6 && v[i] == '\\' && v[i+1] == 'u' {
+						r2, ok = hex4(v[i+2:])
+					}
+					if dec := utf16.DecodeRune(r, r2); ok && dec != unicode.ReplacementChar {
+						r = dec
+						i += 6
+					} else {
+						r = unicode.ReplacementChar
+					}
+				}
+				out = utf8.AppendRune(out, r)
+			default:
+				return nil, errors.New("invalid character " + strconv.QuoteRune(rune(e)) + " in string escape code")
+			}
+		case c < utf8.RuneSelf:
+			out = append(out, c)
+			i++
+		default:
+			r, size := utf8.DecodeRune(v[i:])
+			out = utf8.AppendRune(out, r)
+			i += size
+		}
+	}
+	return out, nil
+}
+
+// hex4 decodes the four hex digits at the start of b.
+func hex4(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// ScanNumber returns the length of the JSON number at the start of b, or
+// 0 if there is none.
+func ScanNumber(b []byte) int {
+	i := 0
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(b) && b[i] == '0':
+		i++
+	case i < len(b) && '1' <= b[i] && b[i] <= '9':
+		i = digits(b, i+1)
+	default:
+		return 0
+	}
+	if i < len(b) && b[i] == '.' {
+		j := digits(b, i+1)
+		if j == i+1 {
+			return 0
+		}
+		i = j
+	}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		j := digits(b, i)
+		if j == i {
+			return 0
+		}
+		i = j
+	}
+	return i
+}
+
+func digits(b []byte, i int) int {
+	for i < len(b) && '0' <= b[i] && b[i] <= '9' {
+		i++
+	}
+	return i
+}
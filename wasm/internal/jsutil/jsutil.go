@@ -0,0 +1,71 @@
+//go:build js
+
+// Package jsutil holds helpers for calling JavaScript APIs from Go code
+// compiled to Wasm.
+package jsutil
+
+import (
+	"errors"
+	"syscall/js"
+)
+
+// Await blocks the calling goroutine until promise settles and returns its
+// fulfilment value or rejection reason. It must not be called from the
+// goroutine running a js.FuncOf callback, or the event loop deadlocks.
+func Await(promise js.Value) (js.Value, error) {
+	type settled struct {
+		value js.Value
+		err   error
+	}
+	done := make(chan settled, 1)
+
+	onFulfilled := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		done <- settled{value: arg(args)}
+		return nil
+	})
+	defer onFulfilled.Release()
+
+	onRejected := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		done <- settled{err: errorFromJS(arg(args))}
+		return nil
+	})
+	defer onRejected.Release()
+
+	promise.Call("then", onFulfilled, onRejected)
+	result := <-done
+	return result.value, result.err
+}
+
+// BytesToJS copies b into a new Uint8Array.
+func BytesToJS(b []byte) js.Value {
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	return arr
+}
+
+// BytesFromJS copies a Uint8Array into a Go byte slice.
+func BytesFromJS(v js.Value) ([]byte, error) {
+	if !v.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, errors.New("value is not a Uint8Array")
+	}
+	b := make([]byte, v.Length())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+func arg(args []js.Value) js.Value {
+	if len(args) == 0 {
+		return js.Undefined()
+	}
+	return args[0]
+}
+
+// errorFromJS converts a rejection reason into a Go error.
+func errorFromJS(v js.Value) error {
+	if v.Type() == js.TypeObject {
+		if msg := v.Get("message"); msg.Type() == js.TypeString {
+			return errors.New(msg.String())
+		}
+	}
+	return errors.New(v.String())
+}
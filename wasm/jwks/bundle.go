@@ -0,0 +1,185 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// BundlePath is the well-known URL path a signed trust bundle is served at.
+const BundlePath = "/.well-known/coral-jwks-bundle.json"
+
+// BundleType is the typ header of bundle signatures.
+const BundleType = "coral-jwks+jws"
+
+// ErrInvalidBundle is returned for bundles that are malformed, expired,
+// for another reef or not signed by one of its root keys.
+var ErrInvalidBundle = errors.New("invalid JWKS bundle")
+
+// Bundle is a JWKS document with a detached signature by a reef root key
+// (RFC 7515 Appendix F). Verifiers holding the root keys can accept it
+// from any source, such as an untrusted mirror or a DNS TXT record,
+// instead of fetching the key set from the discovery origin over HTTPS.
+type Bundle struct {
+	// JWKS is the key set. Its compact encoding is what is signed, so
+	// whitespace changes in transit do not break the signature.
+	JWKS json.RawMessage `json:"jwks"`
+
+	// Signature is a compact JWS with an empty payload segment, whose
+	// protected header names the root key, the reef and the validity
+	// window.
+	Signature string `json:"signature"`
+}
+
+// bundleHeader is the protected header of a bundle signature.
+type bundleHeader struct {
+	Alg       string `json:"alg"`
+	Kid       string `json:"kid"`
+	Typ       string `json:"typ"`
+	Reef      string `json:"reef"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SignBundle signs jwksJSON with s, a root key of reef, for ttl. A bundle
+// must be re-signed before it expires, so ttl should comfortably exceed
+// the interval at which it is republished.
+func SignBundle(ctx context.Context, s signer.Signer, reef string, jwksJSON []byte, ttl time.Duration) (*Bundle, error) {
+	if reef == "" {
+		return nil, errors.New("reef is required")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+	payload, err := compactKeySet(jwksJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(bundleHeader{
+		Alg:       verify.EdDSA,
+		Kid:       s.KeyID(),
+		Typ:       BundleType,
+		Reef:      reef,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	h := base64.RawURLEncoding.EncodeToString(header)
+	input := signingInput(h, payload)
+	sig, err := s.Sign(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign bundle: %w", err)
+	}
+	if !ed25519.Verify(s.Public(), input, sig) {
+		return nil, signer.ErrBadSignature
+	}
+	return &Bundle{
+		JWKS:      payload,
+		Signature: h + ".." + base64.RawURLEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// VerifyJWKSBundle checks that bundle, the JSON of a Bundle, is signed by
+// one of roots, the root keys of reef, and has not expired at c's time
+// (clock.System when nil). It returns the verified JWKS document.
+func VerifyJWKSBundle(bundle []byte, roots verify.KeySource, reef string, c clock.Clock) (json.RawMessage, error) {
+	var b Bundle
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	payload, err := compactKeySet(b.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	parts, header, err := parseSignature(b.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != verify.EdDSA || header.Typ != BundleType {
+		return nil, fmt.Errorf("%w: unexpected alg %q or typ %q", ErrInvalidBundle, header.Alg, header.Typ)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidBundle, err)
+	}
+	key, err := verify.Ed25519Key(roots, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if !ed25519.Verify(key, signingInput(parts[0], payload), sig) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidBundle)
+	}
+
+	if header.Reef != reef {
+		return nil, fmt.Errorf("%w: signed for reef %q", ErrInvalidBundle, header.Reef)
+	}
+	if !clock.Or(c).Now().Before(time.Unix(header.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidBundle)
+	}
+	return payload, nil
+}
+
+// BundleExpiry returns when bundle, the JSON of a Bundle, expires,
+// without verifying it, so that servers can tell when the bundle they
+// publish needs re-signing.
+func BundleExpiry(bundle []byte) (time.Time, error) {
+	var b Bundle
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	_, header, err := parseSignature(b.Signature)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(header.ExpiresAt, 0), nil
+}
+
+// parseSignature splits a bundle signature and decodes its header.
+func parseSignature(signature string) ([]string, *bundleHeader, error) {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return nil, nil, fmt.Errorf("%w: signature is not a detached compact JWS", ErrInvalidBundle)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidBundle, err)
+	}
+	var header bundleHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidBundle, err)
+	}
+	return parts, &header, nil
+}
+
+// signingInput is the JWS signing input of a detached payload.
+func signingInput(header string, payload []byte) []byte {
+	return []byte(header + "." + base64.RawURLEncoding.EncodeToString(payload))
+}
+
+// compactKeySet returns the compact encoding of a JWKS document, checking
+// that it parses as one.
+func compactKeySet(jwksJSON []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, jwksJSON); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS JSON: %w", err)
+	}
+	if _, err := verify.ParseKeys(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package jwks
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+)
+
+// PublishedKey is a JWK with its validity window in Unix seconds. The
+// extra nbf and exp members let verifiers such as the Wasm key cache drop
+// a key when its window closes rather than when their cache TTL expires.
+type PublishedKey struct {
+	jwt.JWK
+	NotBefore int64 `json:"nbf,omitempty"`
+	Expires   int64 `json:"exp,omitempty"`
+}
+
+// Document is the JSON body served at Path.
+type Document struct {
+	Keys []PublishedKey `json:"keys"`
+}
+
+func (d *Document) toJWKS() *jwt.JWKS {
+	out := &jwt.JWKS{Keys: make([]jwt.JWK, len(d.Keys))}
+	for i, k := range d.Keys {
+		out.Keys[i] = k.JWK
+	}
+	return out
+}
+
+// Document returns the currently published key set.
+func (s *Set) Document() *Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.document(s.cfg.Now())
+}
+
+func (s *Set) document(now time.Time) *Document {
+	published := s.published(now)
+	doc := &Document{Keys: make([]PublishedKey, 0, len(published))}
+	for _, k := range published {
+		pk := PublishedKey{
+			JWK: jwt.JWK{
+				KID: k.ID,
+				KTY: "OKP",
+				CRV: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(k.PublicKey),
+				USE: "sig",
+				ALG: "EdDSA",
+			},
+			NotBefore: k.NotBefore.Unix(),
+		}
+		if !k.ExpiresAt.IsZero() {
+			pk.Expires = k.ExpiresAt.Unix()
+		}
+		doc.Keys = append(doc.Keys, pk)
+	}
+	return doc
+}
+
+// ServeHTTP serves the published key set. Responses may be cached for a
+// small fraction of the overlap window so verifiers pick up
+// pre-published keys well before they sign.
+func (s *Set) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxAge := s.cfg.Overlap / 24
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	_ = json.NewEncoder(w).Encode(s.Document())
+}
+
+// Run calls Tick every interval until ctx is done.
+func (s *Set) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Tick(); err != nil {
+				log.Printf("jwks: rotation failed: %v", err)
+			}
+		}
+	}
+}
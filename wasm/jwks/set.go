@@ -0,0 +1,355 @@
+// Package jwks maintains the discovery signing key set. It supports
+// several published keys at once, scheduled rotation with the next key
+// pre-published before it starts signing, and an overlap window during
+// which retired keys still verify outstanding tickets. The set is served
+// at Path and implements registry.Verifier.
+package jwks
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+)
+
+// Path is the well-known URL path the key set is served at.
+const Path = "/.well-known/coral-jwks.json"
+
+// Defaults for Config.
+const (
+	DefaultRotationPeriod = 30 * 24 * time.Hour
+	DefaultOverlap        = 24 * time.Hour
+)
+
+var (
+	// ErrNoSigningKey is returned when no key is active for signing.
+	ErrNoSigningKey = errors.New("no active signing key")
+
+	// ErrRotationOverdue is returned by Fresh when the signing key has
+	// outlived its rotation period, because Tick is not called or fails.
+	ErrRotationOverdue = errors.New("signing key rotation is overdue")
+)
+
+// Key is a signing key and its validity window.
+type Key struct {
+	ID         string             `json:"id"`
+	PrivateKey ed25519.PrivateKey `json:"private_key"`
+	PublicKey  ed25519.PublicKey  `json:"public_key"`
+
+	// NotBefore is when the key starts signing. Keys are published
+	// before NotBefore so verifiers learn them ahead of use.
+	NotBefore time.Time `json:"not_before"`
+
+	// ExpiresAt is when the key is withdrawn from the published set. It
+	// is zero until a successor is scheduled, and then set to the
+	// successor's NotBefore plus the overlap window.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Config holds the configuration for a Set.
+type Config struct {
+	// Path persists the key set as JSON. Empty keeps keys in memory.
+	Path string
+
+	// RotationPeriod is how long each key signs. Defaults to
+	// DefaultRotationPeriod.
+	RotationPeriod time.Duration
+
+	// Overlap is how long a key is published before it starts signing
+	// and after it stops. It must exceed the longest ticket TTL.
+	// Defaults to DefaultOverlap.
+	Overlap time.Duration
+
+	// Now overrides the clock, for tests.
+	Now func() time.Time
+}
+
+// Set is a rotating signing key set. It is safe for concurrent use.
+type Set struct {
+	mu        sync.RWMutex
+	cfg       Config
+	keys      []*Key // ordered by NotBefore
+	validator *jwt.Validator
+}
+
+// New loads the key set from cfg.Path, or generates an initial key when
+// the file does not exist.
+func New(cfg Config) (*Set, error) {
+	if cfg.RotationPeriod <= 0 {
+		cfg.RotationPeriod = DefaultRotationPeriod
+	}
+	if cfg.Overlap <= 0 {
+		cfg.Overlap = DefaultOverlap
+	}
+	if cfg.Overlap >= cfg.RotationPeriod {
+		return nil, fmt.Errorf("overlap %s must be shorter than rotation period %s", cfg.Overlap, cfg.RotationPeriod)
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	s := &Set{cfg: cfg}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if len(s.keys) == 0 {
+		if err := s.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, s.rebuild()
+}
+
+// Current returns the key that signs new tickets: the newest key whose
+// NotBefore has passed.
+func (s *Set) Current() (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current(s.cfg.Now())
+}
+
+func (s *Set) current(now time.Time) (*Key, error) {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if !s.keys[i].NotBefore.After(now) {
+			return s.keys[i], nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Fresh checks that a key is signing and that its successor was
+// scheduled in time: verifiers learn keys ahead of use only while the
+// set rotates on schedule.
+func (s *Set) Fresh() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.cfg.Now()
+	if _, err := s.current(now); err != nil {
+		return err
+	}
+	latest := s.keys[len(s.keys)-1]
+	if due := latest.NotBefore.Add(s.cfg.RotationPeriod); !now.Before(due) {
+		return fmt.Errorf("%w: key %s was due to be succeeded at %s", ErrRotationOverdue, latest.ID, due.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Published returns the keys currently offered to verifiers, including
+// pre-published successors and retired keys inside their overlap.
+func (s *Set) Published() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.published(s.cfg.Now())
+}
+
+func (s *Set) published(now time.Time) []*Key {
+	out := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		if k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// Rotate immediately replaces the signing key. The previous key keeps
+// verifying for the overlap window; pre-published keys that never signed
+// are discarded.
+func (s *Set) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.cfg.Now()
+	kept := s.keys[:0]
+	for _, k := range s.keys {
+		if !k.NotBefore.After(now) {
+			kept = append(kept, k)
+		}
+	}
+	s.keys = kept
+
+	next, err := newKey(now)
+	if err != nil {
+		return err
+	}
+	for _, k := range s.keys {
+		if k.ExpiresAt.IsZero() || k.ExpiresAt.After(now.Add(s.cfg.Overlap)) {
+			k.ExpiresAt = now.Add(s.cfg.Overlap)
+		}
+	}
+	s.keys = append(s.keys, next)
+	return s.commit()
+}
+
+// Tick advances the schedule: it pre-publishes the successor of the
+// newest key once that key is within Overlap of the end of its rotation
+// period, and withdraws expired keys. Call it periodically, or use Run.
+func (s *Set) Tick() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.cfg.Now()
+	changed := false
+
+	latest := s.keys[len(s.keys)-1]
+	successorAt := latest.NotBefore.Add(s.cfg.RotationPeriod)
+	if !now.Before(successorAt.Add(-s.cfg.Overlap)) {
+		if successorAt.Before(now) {
+			successorAt = now
+		}
+		next, err := newKey(successorAt)
+		if err != nil {
+			return err
+		}
+		latest.ExpiresAt = successorAt.Add(s.cfg.Overlap)
+		s.keys = append(s.keys, next)
+		changed = true
+	}
+
+	live := s.published(now)
+	if len(live) != len(s.keys) {
+		s.keys = live
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return s.commit()
+}
+
+// CreateReferralTicket signs a referral ticket with the current key.
+func (s *Set) CreateReferralTicket(reefID, colonyID, agentID, intent string, ttl time.Duration) (string, int64, error) {
+	key, err := s.Current()
+	if err != nil {
+		return "", 0, err
+	}
+	if ttl >= s.cfg.Overlap {
+		return "", 0, fmt.Errorf("ticket TTL %s must be shorter than key overlap %s", ttl, s.cfg.Overlap)
+	}
+	return jwt.CreateReferralTicketStatic(
+		key.PrivateKey, key.ID,
+		reefID, colonyID, agentID, intent,
+		int(ttl.Seconds()),
+		"", "",
+	)
+}
+
+// Signer returns a signer.Signer for the current key. Call it for each
+// ticket so rotations are followed.
+func (s *Set) Signer() (signer.Signer, error) {
+	key, err := s.Current()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Local(key.ID, key.PrivateKey), nil
+}
+
+// ValidateReferralTicket implements registry.Verifier against every
+// published key, selecting the key by the ticket's kid.
+func (s *Set) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	s.mu.RLock()
+	validator := s.validator
+	s.mu.RUnlock()
+	return validator.ValidateReferralTicket(tokenString)
+}
+
+// GetKeyFunc returns a key function resolving kids against the published
+// keys, for use with verify.Parse.
+func (s *Set) GetKeyFunc() gojwt.Keyfunc {
+	s.mu.RLock()
+	validator := s.validator
+	s.mu.RUnlock()
+	return validator.GetKeyFunc()
+}
+
+// commit rebuilds the validator and persists the set. Callers hold mu.
+func (s *Set) commit() error {
+	sort.SliceStable(s.keys, func(i, j int) bool {
+		return s.keys[i].NotBefore.Before(s.keys[j].NotBefore)
+	})
+	if err := s.save(); err != nil {
+		return err
+	}
+	return s.rebuildLocked()
+}
+
+func (s *Set) rebuild() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rebuildLocked()
+}
+
+func (s *Set) rebuildLocked() error {
+	validator, err := jwt.NewValidator(s.document(s.cfg.Now()).toJWKS())
+	if err != nil {
+		return fmt.Errorf("failed to build validator: %w", err)
+	}
+	s.validator = validator
+	return nil
+}
+
+func newKey(notBefore time.Time) (*Key, error) {
+	kp, err := keys.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	return &Key{
+		ID:         kp.ID,
+		PrivateKey: kp.PrivateKey,
+		PublicKey:  kp.PublicKey,
+		NotBefore:  notBefore,
+	}, nil
+}
+
+// load reads the persisted set, if any.
+func (s *Set) load() error {
+	if s.cfg.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key set: %w", err)
+	}
+
+	var file struct {
+		Keys []*Key `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse key set: %w", err)
+	}
+	s.keys = file.Keys
+	sort.SliceStable(s.keys, func(i, j int) bool {
+		return s.keys[i].NotBefore.Before(s.keys[j].NotBefore)
+	})
+	return nil
+}
+
+// save writes the set atomically with owner-only permissions.
+func (s *Set) save() error {
+	if s.cfg.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"keys": s.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.cfg.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write key set: %w", err)
+	}
+	return os.Rename(tmp, s.cfg.Path)
+}
@@ -0,0 +1,207 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// cachedKey is a single JWKS entry held in the module-level cache.
+type cachedKey struct {
+	keys      *verify.Keys
+	expiresAt time.Time
+}
+
+// jwksCache holds parsed verification keys keyed by kid so that repeated
+// verifications skip JWKS parsing entirely.
+type jwksCache struct {
+	mu   sync.RWMutex
+	keys map[string]cachedKey
+}
+
+var keyCache = &jwksCache{keys: make(map[string]cachedKey)}
+
+// publishedKey is a JWK with the optional exp member added by the jwks
+// package to mark the end of a retired key's overlap window.
+type publishedKey struct {
+	KID string `json:"kid"`
+	Exp int64  `json:"exp,omitempty"`
+}
+
+// load parses jwksJSON and caches each Ed25519, P-256 and RSA key for ttl.
+// Keys with a kid already in the cache are replaced and keys absent from
+// jwksJSON are kept until they expire, so tickets signed by a key being
+// rotated out keep verifying. Returns the number of keys loaded.
+func (c *jwksCache) load(jwksJSON string, ttl time.Duration) (int, error) {
+	var set struct {
+		Keys []publishedKey `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(jwksJSON), &set); err != nil {
+		return 0, fmt.Errorf("failed to parse JWKS JSON: %w", err)
+	}
+	keys, err := verify.ParseKeys([]byte(jwksJSON))
+	if err != nil {
+		return 0, err
+	}
+	exps := make(map[string]int64, len(set.Keys))
+	for _, key := range set.Keys {
+		exps[key.KID] = key.Exp
+	}
+
+	now := time.Now()
+	loaded := make(map[string]cachedKey, len(set.Keys))
+	for _, kid := range keys.KeyIDs() {
+		// Keys past their published expiry are skipped, and keys that
+		// expire before the cache TTL are evicted when they do.
+		expiresAt := now.Add(ttl)
+		if e := exps[kid]; e != 0 {
+			exp := time.Unix(e, 0)
+			if !exp.After(now) {
+				continue
+			}
+			if exp.Before(expiresAt) {
+				expiresAt = exp
+			}
+		}
+		loaded[kid] = cachedKey{keys: keys, expiresAt: expiresAt}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for kid, key := range loaded {
+		c.keys[kid] = key
+	}
+	return len(loaded), nil
+}
+
+// validator returns the cached keys for kid, evicting them if expired.
+func (c *jwksCache) validator(kid string) (*verify.Keys, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in JWKS cache", kid)
+	}
+	if time.Now().After(key.expiresAt) {
+		c.mu.Lock()
+		delete(c.keys, kid)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("key %q expired from JWKS cache", kid)
+	}
+	return key.keys, nil
+}
+
+// GetKeyFunc implements verify.KeySource with the cached key named by the
+// token's kid.
+func (c *jwksCache) GetKeyFunc() gojwt.Keyfunc {
+	return func(token *gojwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid in token header")
+		}
+		keys, err := c.validator(kid)
+		if err != nil {
+			return nil, err
+		}
+		return keys.GetKeyFunc()(token)
+	}
+}
+
+// verify checks a token using the cached key named by its kid.
+func (c *jwksCache) verify(tokenString string, opts *verify.Options) (bool, *exportError) {
+	validator, verr := c.tokenValidator(tokenString)
+	if verr != nil {
+		return false, verr
+	}
+	return verifyToken(validator, tokenString, opts)
+}
+
+// tokenValidator returns the cached key named by the kid of tokenString.
+func (c *jwksCache) tokenValidator(tokenString string) (*verify.Keys, *exportError) {
+	kid, err := tokenKeyID(tokenString)
+	if err != nil {
+		return nil, newError(errTokenMalformed, err.Error())
+	}
+	validator, err := c.validator(kid)
+	if err != nil {
+		return nil, newError(errKidUnknown, err.Error())
+	}
+	return validator, nil
+}
+
+// ValidateReferralTicket validates a ticket, including issuer, audience
+// and the default algorithm allowlist, against the cached key named by its
+// kid.
+func (c *jwksCache) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	kid, err := tokenKeyID(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := c.validator(kid)
+	if err != nil {
+		return nil, newError(errKidUnknown, err.Error())
+	}
+	return verify.Parse(validator, tokenString, verify.Options{})
+}
+
+// parsedJWKS holds the JWKS document last passed to verifySignature or
+// verifySignatures, so that callers passing the same document with every
+// ticket only have it parsed once.
+var parsedJWKS struct {
+	sync.Mutex
+	json string
+	keys *verify.Keys
+}
+
+// parseJWKS parses jwksJSON, reusing the keys of the previous document
+// when it is the same.
+func parseJWKS(jwksJSON string) (*verify.Keys, error) {
+	parsedJWKS.Lock()
+	defer parsedJWKS.Unlock()
+	if parsedJWKS.keys != nil && parsedJWKS.json == jwksJSON {
+		return parsedJWKS.keys, nil
+	}
+	keys, err := verify.ParseKeys([]byte(jwksJSON))
+	if err != nil {
+		return nil, err
+	}
+	parsedJWKS.json, parsedJWKS.keys = jwksJSON, keys
+	return keys, nil
+}
+
+// loadJWKS parses a JWKS document into the module-level key cache.
+// Arguments: jwksJSON, ttlSeconds
+// Returns: { loaded: number } or { error: { code, message, retryable } }
+func loadJWKS(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: jwksJSON, ttlSeconds")
+	}
+
+	jwksJSON := args[0].String()
+	if args[1].Type() != js.TypeNumber {
+		return errorResult(errInvalidArgument, "ttlSeconds must be a number")
+	}
+	ttlSeconds := args[1].Int()
+	if ttlSeconds <= 0 {
+		return errorResult(errInvalidArgument, "ttlSeconds must be positive")
+	}
+
+	loaded, err := keyCache.load(jwksJSON, time.Duration(ttlSeconds)*time.Second)
+	if err != nil {
+		return errorResult(errJWKSMalformed, err.Error())
+	}
+
+	return map[string]interface{}{
+		"loaded": loaded,
+	}
+}
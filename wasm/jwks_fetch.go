@@ -0,0 +1,115 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsutil"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/kvcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+)
+
+// fetchJWKS reads a JWKS document through a KV read-through cache and
+// loads it into the module-level key cache. A document past freshSeconds
+// but within staleSeconds is served while it is refetched in the
+// background. When traceparent is given, the fetch continues that trace
+// (see configureTracing).
+// Arguments: kv (KVNamespace), url, freshSeconds, staleSeconds, [traceparent]
+// Returns: { loaded: number, hit: boolean, stale: boolean } or { error: {...} }
+func fetchJWKS(this js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return errorResult(errInvalidArgument, "expected 4 arguments: kv, url, freshSeconds, staleSeconds")
+	}
+
+	url := args[1].String()
+	fresh := time.Duration(args[2].Int()) * time.Second
+	stale := time.Duration(args[3].Int()) * time.Second
+	if fresh <= 0 {
+		return errorResult(errInvalidArgument, "freshSeconds must be positive")
+	}
+
+	cache, err := kvcache.New(kvcache.Config{
+		Namespace: args[0],
+		Prefix:    "coral:jwks:",
+		FreshTTL:  fresh,
+		StaleTTL:  stale,
+	})
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	ctx := context.Background()
+	if len(args) > 4 && args[4].Type() == js.TypeString {
+		if sc, err := tracing.ParseTraceparent(args[4].String()); err == nil {
+			ctx = tracing.ContextWithRemote(ctx, sc)
+		}
+	}
+	ctx, span := tracer.Load().Start(ctx, tracing.Internal, "fetch JWKS", tracing.String("url.full", url))
+	defer span.End()
+
+	res, err := cache.Get(ctx, url, func(ctx context.Context) ([]byte, error) {
+		return fetchText(ctx, url)
+	})
+	if err != nil {
+		span.SetError(err)
+		return errorResult(errFetch, "failed to fetch JWKS: "+err.Error())
+	}
+	span.SetAttributes(tracing.Bool("cache.hit", res.Hit), tracing.Bool("cache.stale", res.Stale))
+
+	// Keep keys usable for the whole stale window so verification does
+	// not fail while a refresh is in flight.
+	ttl := fresh
+	if stale > ttl {
+		ttl = stale
+	}
+	loaded, err := keyCache.load(string(res.Value), ttl)
+	if err != nil {
+		return errorResult(errJWKSMalformed, err.Error())
+	}
+
+	return map[string]interface{}{
+		"loaded": loaded,
+		"hit":    res.Hit,
+		"stale":  res.Stale,
+	}
+}
+
+// fetchText GETs url with the global fetch, in a client span carried in
+// its traceparent header, and returns the body.
+func fetchText(ctx context.Context, url string) ([]byte, error) {
+	ctx, span := tracer.Load().Start(ctx, tracing.Client, "HTTP GET",
+		tracing.String("http.request.method", "GET"),
+		tracing.String("url.full", url),
+	)
+	defer span.End()
+
+	headers := http.Header{}
+	tracing.Inject(ctx, headers)
+	init := map[string]interface{}{}
+	if tp := headers.Get(tracing.TraceparentHeader); tp != "" {
+		init["headers"] = map[string]interface{}{tracing.TraceparentHeader: tp}
+	}
+	resp, err := jsutil.Await(js.Global().Call("fetch", url, init))
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	status := resp.Get("status").Int()
+	span.SetAttributes(tracing.Int("http.response.status_code", status))
+	if !resp.Get("ok").Bool() {
+		err := errors.New("unexpected status " + strconv.Itoa(status))
+		span.SetError(err)
+		return nil, err
+	}
+	body, err := jsutil.Await(resp.Call("text"))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(body.String()), nil
+}
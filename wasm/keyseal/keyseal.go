@@ -0,0 +1,144 @@
+// Package keyseal encrypts Ed25519 private keys under a passphrase so that
+// configuration and secrets never hold raw key material. The key is
+// derived with Argon2id and the private key sealed with
+// XChaCha20-Poly1305.
+//
+// A sealed key is standard base64 of:
+//
+//	"CSK1" | time u32 | memory KiB u32 | threads u8 | salt (16) | nonce (24) | ciphertext
+//
+// The header is authenticated as additional data.
+package keyseal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	magic      = "CSK1"
+	saltSize   = 16
+	headerSize = len(magic) + 4 + 4 + 1 + saltSize + chacha20poly1305.NonceSizeX
+)
+
+var (
+	// ErrMalformed is returned when a sealed key cannot be decoded.
+	ErrMalformed = errors.New("malformed sealed key")
+
+	// ErrWrongPassphrase is returned when a sealed key does not open,
+	// either because the passphrase is wrong or the data was altered.
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted sealed key")
+)
+
+// Params are the Argon2id cost parameters.
+type Params struct {
+	Time    uint32 // iterations
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// DefaultParams follow the OWASP minimum for Argon2id (19 MiB, 2
+// iterations, 1 lane), which stays within Worker memory limits.
+var DefaultParams = Params{Time: 2, Memory: 19 * 1024, Threads: 1}
+
+// maxMemory and maxTime bound the cost accepted from a sealed key so a
+// crafted header cannot exhaust memory or CPU when unsealing.
+const (
+	maxMemory = 1 << 20 // 1 GiB
+	maxTime   = 16
+)
+
+// valid reports whether p is within the accepted cost.
+func (p Params) valid() bool {
+	return p.Time != 0 && p.Time <= maxTime && p.Memory != 0 && p.Memory <= maxMemory && p.Threads != 0
+}
+
+// Seal encrypts key under passphrase with DefaultParams.
+func Seal(key ed25519.PrivateKey, passphrase []byte) (string, error) {
+	return SealWithParams(key, passphrase, DefaultParams)
+}
+
+// SealWithParams encrypts key under passphrase with the given cost.
+func SealWithParams(key ed25519.PrivateKey, passphrase []byte, params Params) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("private key must be %d bytes", ed25519.PrivateKeySize)
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("passphrase is required")
+	}
+	if !params.valid() {
+		return "", fmt.Errorf("invalid Argon2id parameters %+v", params)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[4:], params.Time)
+	binary.BigEndian.PutUint32(header[8:], params.Memory)
+	header[12] = params.Threads
+	salt := header[13 : 13+saltSize]
+	nonce := header[13+saltSize:]
+	if _, err := rand.Read(header[13:]); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(deriveKey(passphrase, salt, params))
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(header, nonce, key.Seed(), header)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unseal decrypts a key sealed by Seal.
+func Unseal(sealed string, passphrase []byte) (ed25519.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(data) < headerSize+chacha20poly1305.Overhead || string(data[:len(magic)]) != magic {
+		return nil, ErrMalformed
+	}
+
+	header := data[:headerSize]
+	params := Params{
+		Time:    binary.BigEndian.Uint32(header[4:]),
+		Memory:  binary.BigEndian.Uint32(header[8:]),
+		Threads: header[12],
+	}
+	if !params.valid() {
+		return nil, fmt.Errorf("%w: invalid Argon2id parameters", ErrMalformed)
+	}
+	salt := header[13 : 13+saltSize]
+	nonce := header[13+saltSize:]
+
+	aead, err := chacha20poly1305.NewX(deriveKey(passphrase, salt, params))
+	if err != nil {
+		return nil, err
+	}
+	seed, err := aead.Open(nil, nonce, data[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrMalformed
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// IsSealed reports whether s looks like a sealed key rather than a raw
+// base64 private key.
+func IsSealed(s string) bool {
+	data, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(data) >= len(magic) && string(data[:len(magic)]) == magic
+}
+
+func deriveKey(passphrase, salt []byte, params Params) []byte {
+	return argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize)
+}
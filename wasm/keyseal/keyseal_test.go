@@ -0,0 +1,111 @@
+package keyseal_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/keyseal"
+)
+
+// cheap keeps Argon2id fast in tests.
+var cheap = keyseal.Params{Time: 1, Memory: 64, Threads: 1}
+
+func sealed(t *testing.T) (ed25519.PrivateKey, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := keyseal.SealWithParams(key, []byte("correct horse"), cheap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, s
+}
+
+func TestSeal(t *testing.T) {
+	key, s := sealed(t)
+	if !keyseal.IsSealed(s) || keyseal.IsSealed(base64.StdEncoding.EncodeToString(key)) {
+		t.Fatal("IsSealed does not tell sealed from raw keys")
+	}
+	got, err := keyseal.Unseal(s, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(key) {
+		t.Fatal("unsealed another key")
+	}
+
+	// Sealing twice picks a fresh salt and nonce.
+	again, err := keyseal.SealWithParams(key, []byte("correct horse"), cheap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == s {
+		t.Fatal("sealed twice to the same output")
+	}
+}
+
+func TestUnsealRejects(t *testing.T) {
+	_, s := sealed(t)
+	if _, err := keyseal.Unseal(s, []byte("wrong horse")); !errors.Is(err, keyseal.ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is authenticated: raising the cost to one the sealer
+	// did not use fails like a flipped ciphertext bit.
+	for name, tamper := range map[string]func([]byte){
+		"ciphertext": func(b []byte) { b[len(b)-1] ^= 1 },
+		"salt":       func(b []byte) { b[13] ^= 1 },
+		"time":       func(b []byte) { binary.BigEndian.PutUint32(b[4:], 2) },
+	} {
+		b := append([]byte(nil), data...)
+		tamper(b)
+		if _, err := keyseal.Unseal(base64.StdEncoding.EncodeToString(b), []byte("correct horse")); !errors.Is(err, keyseal.ErrWrongPassphrase) {
+			t.Errorf("%s altered: %v, want ErrWrongPassphrase", name, err)
+		}
+	}
+
+	huge := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(huge[8:], 1<<30)
+	slow := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(slow[4:], 1<<31)
+	for name, s := range map[string]string{
+		"not base64":  "!!!",
+		"truncated":   base64.StdEncoding.EncodeToString(data[:40]),
+		"magic":       base64.StdEncoding.EncodeToString(append([]byte("CSK0"), data[4:]...)),
+		"memory cost": base64.StdEncoding.EncodeToString(huge),
+		"time cost":   base64.StdEncoding.EncodeToString(slow),
+	} {
+		if _, err := keyseal.Unseal(s, []byte("correct horse")); !errors.Is(err, keyseal.ErrMalformed) {
+			t.Errorf("%s: %v, want ErrMalformed", name, err)
+		}
+	}
+}
+
+// TestSealRejectsCost checks that keys are not sealed at a cost Unseal
+// would refuse.
+func TestSealRejectsCost(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, params := range map[string]keyseal.Params{
+		"no time":     {Time: 0, Memory: 64, Threads: 1},
+		"time cost":   {Time: 1 << 31, Memory: 64, Threads: 1},
+		"memory cost": {Time: 1, Memory: 1 << 30, Threads: 1},
+		"no threads":  {Time: 1, Memory: 64, Threads: 0},
+	} {
+		if _, err := keyseal.SealWithParams(key, []byte("correct horse"), params); err == nil {
+			t.Errorf("%s: sealed", name)
+		}
+	}
+}
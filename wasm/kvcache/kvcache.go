@@ -0,0 +1,181 @@
+//go:build js
+
+// Package kvcache is a read-through cache over a Cloudflare Workers KV
+// namespace with stale-while-revalidate semantics. Entries younger than
+// FreshTTL are served as-is; entries younger than StaleTTL are served
+// immediately while a background load refreshes them; older or missing
+// entries are loaded synchronously.
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsutil"
+)
+
+// minExpiration is the lowest expirationTtl KV accepts.
+const minExpiration = 60 * time.Second
+
+// Loader fetches a value from origin on a cache miss or revalidation.
+type Loader func(ctx context.Context) ([]byte, error)
+
+// Config configures a Cache.
+type Config struct {
+	// Namespace is the KVNamespace binding.
+	Namespace js.Value
+	// Prefix is prepended to every key. Defaults to "coral:".
+	Prefix string
+	// FreshTTL is how long an entry is served without revalidation.
+	FreshTTL time.Duration
+	// StaleTTL is how long an entry may be served while revalidating.
+	// Must be at least FreshTTL.
+	StaleTTL time.Duration
+}
+
+// Cache is a KV-backed read-through cache.
+type Cache struct {
+	kv       js.Value
+	prefix   string
+	freshTTL time.Duration
+	staleTTL time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]bool
+}
+
+// Result is a value read through the cache.
+type Result struct {
+	Value []byte
+	// Stale reports that Value was served past FreshTTL and a refresh
+	// was started.
+	Stale bool
+	// Hit reports that Value came from KV rather than origin.
+	Hit bool
+}
+
+// New creates a Cache.
+func New(cfg Config) (*Cache, error) {
+	if cfg.Namespace.IsUndefined() || cfg.Namespace.IsNull() {
+		return nil, errors.New("KV namespace binding is required")
+	}
+	if cfg.StaleTTL < cfg.FreshTTL {
+		cfg.StaleTTL = cfg.FreshTTL
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "coral:"
+	}
+	return &Cache{
+		kv:       cfg.Namespace,
+		prefix:   cfg.Prefix,
+		freshTTL: cfg.FreshTTL,
+		staleTTL: cfg.StaleTTL,
+		inflight: make(map[string]bool),
+	}, nil
+}
+
+// Get returns the value for key, calling load on a miss or when the cached
+// entry is past StaleTTL. Load errors are returned only when there is no
+// servable cached value.
+func (c *Cache) Get(ctx context.Context, key string, load Loader) (*Result, error) {
+	value, storedAt, ok, err := c.read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		age := time.Since(storedAt)
+		if age < c.freshTTL {
+			return &Result{Value: value, Hit: true}, nil
+		}
+		if age < c.staleTTL {
+			c.revalidate(key, load)
+			return &Result{Value: value, Hit: true, Stale: true}, nil
+		}
+	}
+
+	value, err = load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Put(key, value); err != nil {
+		return nil, err
+	}
+	return &Result{Value: value}, nil
+}
+
+// Put writes value for key, stamping it with the current time.
+func (c *Cache) Put(key string, value []byte) error {
+	expiration := c.staleTTL
+	if expiration < minExpiration {
+		expiration = minExpiration
+	}
+
+	metadata := js.Global().Get("Object").New()
+	metadata.Set("stored_at", float64(time.Now().UnixMilli()))
+	opts := js.Global().Get("Object").New()
+	opts.Set("expirationTtl", int(expiration.Seconds()))
+	opts.Set("metadata", metadata)
+
+	if _, err := jsutil.Await(c.kv.Call("put", c.prefix+key, string(value), opts)); err != nil {
+		return errors.New("failed to write KV entry: " + err.Error())
+	}
+	return nil
+}
+
+// Delete invalidates key.
+func (c *Cache) Delete(key string) error {
+	if _, err := jsutil.Await(c.kv.Call("delete", c.prefix+key)); err != nil {
+		return errors.New("failed to delete KV entry: " + err.Error())
+	}
+	return nil
+}
+
+// read fetches key and its write time from KV.
+func (c *Cache) read(key string) ([]byte, time.Time, bool, error) {
+	result, err := jsutil.Await(c.kv.Call("getWithMetadata", c.prefix+key, "text"))
+	if err != nil {
+		return nil, time.Time{}, false, errors.New("failed to read KV entry: " + err.Error())
+	}
+
+	value := result.Get("value")
+	if value.IsNull() || value.IsUndefined() {
+		return nil, time.Time{}, false, nil
+	}
+
+	// Entries without a timestamp are reloaded from origin.
+	var storedAt time.Time
+	if md := result.Get("metadata"); md.Type() == js.TypeObject {
+		if ts := md.Get("stored_at"); ts.Type() == js.TypeNumber {
+			storedAt = time.UnixMilli(int64(ts.Float()))
+		}
+	}
+	return []byte(value.String()), storedAt, true, nil
+}
+
+// revalidate refreshes key in the background, at most once at a time.
+func (c *Cache) revalidate(key string, load Loader) {
+	c.mu.Lock()
+	if c.inflight[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+		}()
+		value, err := load(context.Background())
+		if err != nil {
+			return
+		}
+		_ = c.Put(key, value)
+	}()
+}
@@ -0,0 +1,255 @@
+//go:build (tinygo.wasm || js) && lite
+
+// Package main provides Wasm entrypoint for coral-crypto operations.
+// Built with -tags lite, it exports only ticket issuance and verification
+// and leaves out encoding/json, golang-jwt and coral-crypto, keeping the
+// TinyGo module small enough for tight Worker script limits.
+package main
+
+import (
+	"strconv"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+func main() {
+	// The lite build registers a subset of the coralCrypto exports, with
+	// the same arguments and results. Options arguments are rejected.
+	js.Global().Set("coralCrypto", js.ValueOf(map[string]interface{}{
+		"createReferralTicket": promisify(createReferralTicket),
+		"verifySignature":      promisify(verifySignature),
+		"verifySignatures":     promisify(verifySignatures),
+		"parseReferralTicket":  promisify(parseReferralTicket),
+		"loadJWKS":             promisify(loadJWKS),
+		"signDetached":         promisify(signDetached),
+		"verifyDetached":       promisify(verifyDetached),
+	}))
+
+	// Keep the program running.
+	select {}
+}
+
+// keyCache holds the keys loaded by loadJWKS.
+var keyCache struct {
+	sync.RWMutex
+	keys map[string]liteKey
+}
+
+// createReferralTicket creates a new referral ticket JWT with the default
+// issuer and audience. Holder-bound tickets need the full build.
+// Arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds
+// Returns: { jwt: string, expiresAt: number } or { error: { code, message, retryable } }
+func createReferralTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 7 {
+		return errorResult(errInvalidArgument, "expected 7 arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds")
+	}
+	if len(args) > 7 && args[7].Type() == js.TypeString {
+		return errorResult(errInvalidArgument, "holder-bound tickets are not supported by the lite build")
+	}
+
+	privateKey, err := decodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+
+	now := time.Now()
+	claims := liteClaims{
+		ReefID:    args[2].String(),
+		ColonyID:  args[3].String(),
+		AgentID:   args[4].String(),
+		Intent:    args[5].String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Duration(args[6].Int()) * time.Second).Unix(),
+		HasExp:    true,
+	}
+	token, err := signTicket(privateKey, args[1].String(), &claims)
+	if err != nil {
+		return errorResult(errTokenSign, "failed to create token: "+err.Error())
+	}
+
+	return map[string]interface{}{
+		"jwt":       token,
+		"expiresAt": claims.ExpiresAt,
+	}
+}
+
+// verifySignature verifies an EdDSA JWT against jwksJSON, or against the
+// keys loaded by loadJWKS when it is omitted, and checks exp and nbf. A
+// bad signature or another algorithm yields { valid: false }.
+// Arguments: tokenString, [jwksJSON]
+// Returns: { valid: boolean } or { error: { code, message, retryable } }
+func verifySignature(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected at least 1 argument: tokenString, [jwksJSON]")
+	}
+	if len(args) > 2 && !args[2].IsUndefined() && !args[2].IsNull() {
+		return errorResult(errInvalidArgument, "verify options are not supported by the lite build")
+	}
+
+	var jwks js.Value
+	if len(args) > 1 {
+		jwks = args[1]
+	}
+	valid, verr := verifyLite(args[0].String(), jwks)
+	if verr != nil {
+		return verr.result()
+	}
+	return map[string]interface{}{
+		"valid": valid,
+	}
+}
+
+// verifySignatures verifies a batch of JWT signatures in a single call.
+// Arguments: [{ token, [jwksJSON] }, ...]
+// Returns: { results: [{ valid: boolean } | { error: {...} }, ...] } or { error: {...} }
+func verifySignatures(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || !js.Global().Get("Array").Call("isArray", args[0]).Bool() {
+		return errorResult(errInvalidArgument, "expected 1 argument: array of { token, jwksJSON }")
+	}
+
+	items := args[0]
+	n := items.Length()
+	results := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		item := items.Index(i)
+		if item.Type() != js.TypeObject {
+			results[i] = errorResult(errInvalidArgument, "expected object with token and jwksJSON")
+			continue
+		}
+		if o := item.Get("options"); !o.IsUndefined() && !o.IsNull() {
+			results[i] = errorResult(errInvalidArgument, "verify options are not supported by the lite build")
+			continue
+		}
+		valid, verr := verifyLite(item.Get("token").String(), item.Get("jwksJSON"))
+		if verr != nil {
+			results[i] = verr.result()
+			continue
+		}
+		results[i] = map[string]interface{}{
+			"valid": valid,
+		}
+	}
+
+	return map[string]interface{}{
+		"results": results,
+	}
+}
+
+// verifyLite checks tokenString against the keys of jwks, a JWKS document
+// string, or the loadJWKS cache when jwks is undefined or null.
+func verifyLite(tokenString string, jwks js.Value) (bool, *exportError) {
+	t, err := decodeLiteTicket(tokenString)
+	if err != nil {
+		return false, newError(errTokenMalformed, "failed to parse token: "+err.Error())
+	}
+	if t.Kid == "" {
+		return false, newError(errTokenMalformed, "missing kid in token header")
+	}
+
+	var key liteKey
+	var ok bool
+	if jwks.IsUndefined() || jwks.IsNull() {
+		keyCache.RLock()
+		key, ok = keyCache.keys[t.Kid]
+		keyCache.RUnlock()
+		if ok && !time.Now().Before(key.ExpiresAt) {
+			ok = false
+		}
+	} else {
+		keys, err := parseLiteJWKS([]byte(jwks.String()))
+		if err != nil {
+			return false, newError(errJWKSMalformed, err.Error())
+		}
+		key, ok = keys[t.Kid]
+	}
+	if !ok {
+		return false, newError(errKidUnknown, "key "+strconv.Quote(t.Kid)+" not found in JWKS")
+	}
+
+	if !t.verify(key.PublicKey) {
+		return false, nil
+	}
+	now := time.Now().Unix()
+	if t.Claims.HasExp && now >= t.Claims.ExpiresAt {
+		return false, newError(errTokenExpired, "token has invalid claims: token is expired")
+	}
+	if t.Claims.HasNbf && now < t.Claims.NotBefore {
+		return false, newError(errTokenNotYet, "token has invalid claims: token is not valid yet")
+	}
+	return true, nil
+}
+
+// parseReferralTicket extracts unverified claims from a referral ticket.
+// Arguments: tokenString
+// Returns: { reefID, colonyID, agentID, intent, exp, kid } or { error: { code, message, retryable } }
+func parseReferralTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: tokenString")
+	}
+
+	t, err := decodeLiteTicket(args[0].String())
+	if err != nil {
+		return errorResult(errTokenMalformed, "failed to parse token: "+err.Error())
+	}
+
+	var exp int64
+	if t.Claims.HasExp {
+		exp = t.Claims.ExpiresAt
+	}
+	return map[string]interface{}{
+		"reefID":   t.Claims.ReefID,
+		"colonyID": t.Claims.ColonyID,
+		"agentID":  t.Claims.AgentID,
+		"intent":   t.Claims.Intent,
+		"exp":      exp,
+		"kid":      t.Kid,
+	}
+}
+
+// loadJWKS adds the Ed25519 keys of a JWKS document to the cache; keys of
+// other types are skipped.
+// Arguments: jwksJSON, ttlSeconds
+// Returns: { loaded: number } or { error: { code, message, retryable } }
+func loadJWKS(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: jwksJSON, ttlSeconds")
+	}
+
+	if args[1].Type() != js.TypeNumber {
+		return errorResult(errInvalidArgument, "ttlSeconds must be a number")
+	}
+	ttlSeconds := args[1].Int()
+	if ttlSeconds <= 0 {
+		return errorResult(errInvalidArgument, "ttlSeconds must be positive")
+	}
+
+	keys, err := parseLiteJWKS([]byte(args[0].String()))
+	if err != nil {
+		return errorResult(errJWKSMalformed, err.Error())
+	}
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(ttlSeconds) * time.Second)
+	loaded := 0
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	if keyCache.keys == nil {
+		keyCache.keys = make(map[string]liteKey, len(keys))
+	}
+	for kid, key := range keys {
+		// Keys past their published expiry are skipped, as in the full
+		// build.
+		if !key.ExpiresAt.IsZero() && !key.ExpiresAt.After(now) {
+			continue
+		}
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(expiresAt) {
+			key.ExpiresAt = expiresAt
+		}
+		keyCache.keys[kid] = key
+		loaded++
+	}
+
+	return map[string]interface{}{
+		"loaded": loaded,
+	}
+}
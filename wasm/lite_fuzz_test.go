@@ -0,0 +1,53 @@
+//go:build js && lite
+
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+// FuzzParseLiteJWKS checks that malformed JWKS documents are rejected
+// without panicking and that accepted keys are Ed25519 keys.
+func FuzzParseLiteJWKS(f *testing.F) {
+	for _, seed := range []string{
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","alg":"EdDSA","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo","exp":"4102444800"}]}`,
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":"AA"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":1,"y":null},null]}`,
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","exp":"1e400"}]}`,
+		`{"keys":null,"other":[{}]}`,
+		`{"keys":{}}`,
+		"{\"keys\":[]}\x00",
+		`null`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		keys, err := parseLiteJWKS(data)
+		if err != nil {
+			return
+		}
+		for kid, key := range keys {
+			if len(key.PublicKey) != ed25519.PublicKeySize {
+				t.Fatalf("key %q has %d bytes", kid, len(key.PublicKey))
+			}
+		}
+	})
+}
+
+// FuzzDecodeLiteTicket checks that malformed tokens are rejected without
+// panicking.
+func FuzzDecodeLiteTicket(f *testing.F) {
+	for _, seed := range []string{
+		"eyJhbGciOiJFZERTQSIsImtpZCI6ImsxIn0.eyJyZWVmX2lkIjoiciIsImV4cCI6MX0.c2ln",
+		"eyJhbGciOiJFZERTQSJ9.eyJFWFAiOiIxLjUiLCJuYmYiOm51bGx9.",
+		"bnVsbA.bnVsbA.",
+		"e30.W10.",
+		"..",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, token string) {
+		decodeLiteTicket(token)
+	})
+}
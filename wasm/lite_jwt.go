@@ -0,0 +1,278 @@
+//go:build (tinygo.wasm || js) && lite
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsonscan"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/webcrypto"
+)
+
+// The issuer and audience stamped on tickets, as jwt.DefaultIssuer and
+// jwt.DefaultAudience.
+const (
+	liteIssuer   = "coral-discovery"
+	liteAudience = "coral-colony"
+)
+
+// liteClaims are the referral ticket claims the lite build reads and
+// writes. Other claims are skipped when decoding.
+type liteClaims struct {
+	ReefID, ColonyID, AgentID, Intent string
+	ID                                string
+
+	ExpiresAt, NotBefore, IssuedAt int64
+	HasExp, HasNbf                 bool
+}
+
+// liteTicket is a decoded compact JWT.
+type liteTicket struct {
+	Alg, Kid string
+	Claims   liteClaims
+
+	signed    string
+	signature []byte
+}
+
+// liteKey is an Ed25519 key from a JWKS document.
+type liteKey struct {
+	PublicKey ed25519.PublicKey
+
+	// ExpiresAt is the key's published exp, or the cache expiry once
+	// loaded; zero when neither is set.
+	ExpiresAt time.Time
+}
+
+// signTicket encodes claims as an EdDSA JWT signed by privateKey, in the
+// shape jwt.CreateReferralTicketStatic produces, filling in the issuer,
+// audience and a random jti.
+func signTicket(privateKey ed25519.PrivateKey, kid string, c *liteClaims) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40 // Version 4.
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant.
+	h := hex.EncodeToString(id[:])
+	c.ID = h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+
+	header := append([]byte(`{"alg":"EdDSA","kid":`), quote(kid)...)
+	header = append(header, `,"typ":"JWT"}`...)
+
+	var claims []byte
+	claims = append(claims, `{"reef_id":`...)
+	claims = append(claims, quote(c.ReefID)...)
+	claims = append(claims, `,"colony_id":`...)
+	claims = append(claims, quote(c.ColonyID)...)
+	claims = append(claims, `,"agent_id":`...)
+	claims = append(claims, quote(c.AgentID)...)
+	claims = append(claims, `,"intent":`...)
+	claims = append(claims, quote(c.Intent)...)
+	claims = append(claims, `,"iss":"`+liteIssuer+`","aud":["`+liteAudience+`"],"exp":`...)
+	claims = strconv.AppendInt(claims, c.ExpiresAt, 10)
+	claims = append(claims, `,"iat":`...)
+	claims = strconv.AppendInt(claims, c.IssuedAt, 10)
+	claims = append(claims, `,"jti":"`+c.ID+`"}`...)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig := webcrypto.Sign(privateKey, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// quote encodes s as a JSON string, replacing invalid UTF-8 with U+FFFD.
+func quote(s string) []byte {
+	const hexDigits = "0123456789abcdef"
+	out := make([]byte, 0, len(s)+2)
+	out = append(out, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				out = append(out, '\\', c)
+			case c < 0x20:
+				out = append(out, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				out = append(out, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		out = utf8.AppendRune(out, r)
+		i += size
+	}
+	return append(out, '"')
+}
+
+// decodeLiteTicket splits a compact JWT and decodes its header and
+// claims. The signature is decoded but not checked.
+func decodeLiteTicket(token string) (*liteTicket, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token must have three segments")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid header: " + err.Error())
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid claims: " + err.Error())
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid signature: " + err.Error())
+	}
+
+	t := &liteTicket{signed: token[:len(parts[0])+1+len(parts[1])], signature: sig}
+	s := jsonscan.New(header)
+	s.Expect('{')
+	for i := 0; s.More(i, '}'); i++ {
+		switch string(s.Name()) {
+		case "alg":
+			t.Alg = string(s.StringOrSkip())
+		case "kid":
+			t.Kid = string(s.StringOrSkip())
+		default:
+			s.Skip()
+		}
+	}
+	if err := s.End(); err != nil {
+		return nil, errors.New("invalid header: " + err.Error())
+	}
+	if err := t.Claims.decode(payload); err != nil {
+		return nil, errors.New("invalid claims: " + err.Error())
+	}
+	return t, nil
+}
+
+// decode reads the claims from a JWT payload. Like encoding/json, it
+// matches names ignoring case and leaves the claims unchanged for null.
+func (c *liteClaims) decode(payload []byte) error {
+	s := jsonscan.New(payload)
+	if s.Peek() == 'n' {
+		s.Literal("null")
+		return s.End()
+	}
+	s.Expect('{')
+	for i := 0; s.More(i, '}'); i++ {
+		switch name := string(s.Name()); {
+		case strings.EqualFold(name, "reef_id"):
+			s.StringInto(&c.ReefID)
+		case strings.EqualFold(name, "colony_id"):
+			s.StringInto(&c.ColonyID)
+		case strings.EqualFold(name, "agent_id"):
+			s.StringInto(&c.AgentID)
+		case strings.EqualFold(name, "intent"):
+			s.StringInto(&c.Intent)
+		case strings.EqualFold(name, "jti"):
+			s.StringInto(&c.ID)
+		case strings.EqualFold(name, "exp"):
+			c.ExpiresAt, c.HasExp = numericDate(&s)
+		case strings.EqualFold(name, "nbf"):
+			c.NotBefore, c.HasNbf = numericDate(&s)
+		case strings.EqualFold(name, "iat"):
+			c.IssuedAt, _ = numericDate(&s)
+		default:
+			s.Skip()
+		}
+	}
+	return s.End()
+}
+
+// numericDate reads a NumericDate given as a number or a string holding
+// one, truncated to whole seconds, reporting false for null.
+func numericDate(s *jsonscan.Scanner) (int64, bool) {
+	var raw []byte
+	switch s.Peek() {
+	case 'n':
+		s.Literal("null")
+		return 0, false
+	case '"':
+		raw = s.Str()
+		if s.Err() == nil && (len(raw) == 0 || jsonscan.ScanNumber(raw) != len(raw)) {
+			s.Fail(errors.New("invalid number literal " + strconv.Quote(string(raw))))
+		}
+	default:
+		raw = s.Number()
+	}
+	if s.Err() != nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		s.Fail(err)
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// verify reports whether the ticket is EdDSA-signed by pub.
+func (t *liteTicket) verify(pub ed25519.PublicKey) bool {
+	return t.Alg == "EdDSA" && len(pub) == ed25519.PublicKeySize &&
+		webcrypto.Verify(pub, []byte(t.signed), t.signature)
+}
+
+// parseLiteJWKS returns the Ed25519 keys of a JWKS document by kid,
+// skipping keys of other types and keys pinned to another algorithm.
+func parseLiteJWKS(data []byte) (map[string]liteKey, error) {
+	keys := make(map[string]liteKey)
+	s := jsonscan.New(data)
+	s.Expect('{')
+	for i := 0; s.More(i, '}'); i++ {
+		if string(s.Name()) != "keys" || s.Peek() == 'n' {
+			s.Skip()
+			continue
+		}
+		s.Expect('[')
+		for j := 0; s.More(j, ']'); j++ {
+			var kty, crv, kid, alg, x string
+			var exp int64
+			s.Expect('{')
+			for k := 0; s.More(k, '}'); k++ {
+				switch string(s.Name()) {
+				case "kty":
+					s.StringInto(&kty)
+				case "crv":
+					s.StringInto(&crv)
+				case "kid":
+					s.StringInto(&kid)
+				case "alg":
+					s.StringInto(&alg)
+				case "x":
+					s.StringInto(&x)
+				case "exp":
+					exp, _ = numericDate(&s)
+				default:
+					s.Skip()
+				}
+			}
+			if s.Err() != nil || kty != "OKP" || crv != "Ed25519" || alg != "" && alg != "EdDSA" {
+				continue
+			}
+			pub, err := base64.RawURLEncoding.DecodeString(x)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return nil, errors.New("invalid Ed25519 key " + strconv.Quote(kid))
+			}
+			key := liteKey{PublicKey: pub}
+			if exp != 0 {
+				key.ExpiresAt = time.Unix(exp, 0)
+			}
+			keys[kid] = key
+		}
+	}
+	if err := s.End(); err != nil {
+		return nil, errors.New("failed to parse JWKS JSON: " + err.Error())
+	}
+	return keys, nil
+}
@@ -0,0 +1,382 @@
+// Package lock provides distributed locks and counting semaphores to the
+// agents of a colony, for coordination among edge agents that share no
+// other service than discovery. An agent acquires a lease on a named
+// semaphore, of which at most its limit are held at once; a lock is a
+// semaphore with a limit of one. Leases expire unless renewed, so a
+// crashed holder frees its slot, and each carries a fencing token
+// greater than those of the leases acquired before it, so that the
+// systems a holder writes to can refuse writes from a holder whose lease
+// has expired without it noticing. Semaphores are scoped to the reef and
+// colony of their holders' tickets, and kept in a store.Store with
+// compare-and-swap writes.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Defaults and bounds of a lease.
+const (
+	DefaultTTL = 30 * time.Second
+	MinTTL     = time.Second
+	MaxTTL     = 10 * time.Minute
+)
+
+// MaxLimit bounds the number of leases of a semaphore.
+const MaxLimit = 256
+
+// MaxNameSize bounds the length of a lock name.
+const MaxNameSize = 256
+
+// keyPrefix namespaces semaphores in the store; a semaphore lives under
+// keyPrefix + reef + "/" + colony + "/" + name.
+const keyPrefix = "locks/"
+
+// maxWriteAttempts bounds the compare-and-swap retries of one write.
+const maxWriteAttempts = 8
+
+var (
+	// ErrInvalid is returned for malformed lock names, sessions, limits
+	// and TTLs.
+	ErrInvalid = errors.New("invalid lock request")
+
+	// ErrUnavailable is returned when a lock is held, or every lease of
+	// a semaphore is, until the acquisition stops waiting.
+	ErrUnavailable = errors.New("lock unavailable")
+
+	// ErrNotHeld is returned for renewals and releases of a lease that
+	// has expired or been released.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// Lease is a hold on a lock or semaphore.
+type Lease struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	Name     string `json:"name"`
+	AgentID  string `json:"agent_id"`
+
+	// Session tells the leases of one agent apart, so that two
+	// instances of an agent do not share a lease.
+	Session string `json:"session,omitempty"`
+
+	// Fence is the fencing token of the lease. It is greater than the
+	// tokens of every lease acquired on the semaphore before.
+	Fence uint64 `json:"fence"`
+
+	// Limit is how many leases of the semaphore may be held at once.
+	Limit int `json:"limit"`
+
+	TTLSeconds int       `json:"ttl_seconds"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Semaphore is the state of a lock or semaphore.
+type Semaphore struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	Name     string `json:"name"`
+
+	// Limit is the limit of the live leases, or zero when there are none.
+	Limit int `json:"limit"`
+
+	// Fence is the fencing token of the latest lease.
+	Fence uint64 `json:"fence"`
+
+	// Leases are the live leases, oldest first.
+	Leases []*Lease `json:"leases"`
+}
+
+// Holder is an agent acquiring, or holding, a lease. ReefID, ColonyID
+// and AgentID must come from its verified ticket.
+type Holder struct {
+	ReefID   string
+	ColonyID string
+	AgentID  string
+	Name     string
+	Session  string
+}
+
+// Config holds the configuration for Locks.
+type Config struct {
+	// Store holds the semaphores. Defaults to an in-memory store; use a
+	// shared backend when several instances serve the same colony.
+	Store store.Store
+}
+
+// Locks keeps the locks and semaphores of every colony.
+type Locks struct {
+	store store.Store
+}
+
+// New creates Locks from cfg.
+func New(cfg Config) *Locks {
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	return &Locks{store: st}
+}
+
+// Acquire leases h a slot of its semaphore, of which at most limit may
+// be held at once (1 for a lock, the default), for ttl. A holder that
+// holds a lease already gets it back. While the semaphore is full,
+// Acquire waits up to wait for a lease to be released or expire, then
+// returns ErrUnavailable. Every holder of a semaphore must name the same
+// limit.
+func (l *Locks) Acquire(ctx context.Context, h Holder, limit int, ttl, wait time.Duration) (*Lease, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = 1
+	}
+	if limit < 1 || limit > MaxLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalid, MaxLimit)
+	}
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < MinTTL || ttl > MaxTTL || ttl%time.Second != 0 {
+		return nil, fmt.Errorf("%w: ttl must be whole seconds between %s and %s", ErrInvalid, MinTTL, MaxTTL)
+	}
+	key := lockKey(h.ReefID, h.ColonyID, h.Name)
+	deadline := time.Now().Add(wait)
+	for {
+		lease, sem, err := l.acquire(ctx, key, h, limit, ttl)
+		if err != nil || lease != nil {
+			return lease, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s holds all %d leases of %s", ErrUnavailable, holders(sem), sem.Limit, h.Name)
+		}
+
+		// Subscribe before looking again, so that a release in between
+		// is not missed, and wake up when the first lease runs out.
+		until := sem.Leases[0].ExpiresAt
+		for _, held := range sem.Leases {
+			if held.ExpiresAt.Before(until) {
+				until = held.ExpiresAt
+			}
+		}
+		if deadline.Before(until) {
+			until = deadline
+		}
+		waitCtx, cancel := context.WithDeadline(ctx, until)
+		events, err := l.store.Watch(waitCtx, key)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if lease, _, err := l.acquire(ctx, key, h, limit, ttl); err != nil || lease != nil {
+			cancel()
+			return lease, err
+		}
+		for range events {
+			break
+		}
+		cancel()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// acquire makes one attempt at leasing a slot of the semaphore at key. It
+// returns the semaphore, and no lease, when it is full.
+func (l *Locks) acquire(ctx context.Context, key string, h Holder, limit int, ttl time.Duration) (*Lease, *Semaphore, error) {
+	for range maxWriteAttempts {
+		sem, rev, err := l.get(ctx, key, h.ReefID, h.ColonyID, h.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		now := time.Now().UTC()
+		sem.prune(now)
+		if i := slices.IndexFunc(sem.Leases, h.holds); i >= 0 {
+			return sem.Leases[i], sem, nil
+		}
+		if len(sem.Leases) > 0 && sem.Limit != limit {
+			return nil, nil, fmt.Errorf("%w: %s is held with a limit of %d", ErrInvalid, h.Name, sem.Limit)
+		}
+		if len(sem.Leases) >= limit {
+			return nil, sem, nil
+		}
+		lease := &Lease{
+			ReefID:     h.ReefID,
+			ColonyID:   h.ColonyID,
+			Name:       h.Name,
+			AgentID:    h.AgentID,
+			Session:    h.Session,
+			Fence:      sem.Fence + 1,
+			Limit:      limit,
+			TTLSeconds: int(ttl / time.Second),
+			AcquiredAt: now,
+			ExpiresAt:  now.Add(ttl),
+		}
+		sem.Limit, sem.Fence = limit, lease.Fence
+		sem.Leases = append(sem.Leases, lease)
+		err = l.put(ctx, key, rev, sem)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return lease, sem, nil
+	}
+	return nil, nil, fmt.Errorf("lock %s: too many concurrent writes", h.Name)
+}
+
+// Renew extends the lease of h holding fence by its TTL. It returns
+// ErrNotHeld once the lease has expired or been released.
+func (l *Locks) Renew(ctx context.Context, h Holder, fence uint64) (*Lease, error) {
+	return l.update(ctx, h, fence, func(sem *Semaphore, i int, now time.Time) {
+		lease := sem.Leases[i]
+		lease.ExpiresAt = now.Add(time.Duration(lease.TTLSeconds) * time.Second)
+	})
+}
+
+// Release gives up the lease of h holding fence, so that another holder
+// can acquire it right away. It returns ErrNotHeld once the lease has
+// expired or been released.
+func (l *Locks) Release(ctx context.Context, h Holder, fence uint64) error {
+	_, err := l.update(ctx, h, fence, func(sem *Semaphore, i int, _ time.Time) {
+		sem.Leases = slices.Delete(sem.Leases, i, i+1)
+	})
+	return err
+}
+
+// update applies fn to the semaphore of the lease of h holding fence,
+// while it lasts, and returns the lease.
+func (l *Locks) update(ctx context.Context, h Holder, fence uint64, fn func(sem *Semaphore, i int, now time.Time)) (*Lease, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	key := lockKey(h.ReefID, h.ColonyID, h.Name)
+	for range maxWriteAttempts {
+		sem, rev, err := l.get(ctx, key, h.ReefID, h.ColonyID, h.Name)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		sem.prune(now)
+		i := slices.IndexFunc(sem.Leases, func(lease *Lease) bool { return h.holds(lease) && lease.Fence == fence })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: lease %d of %s has expired or been released", ErrNotHeld, fence, h.Name)
+		}
+		lease := sem.Leases[i]
+		fn(sem, i, now)
+		err = l.put(ctx, key, rev, sem)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return lease, nil
+	}
+	return nil, fmt.Errorf("lock %s: too many concurrent writes", h.Name)
+}
+
+// Semaphore returns the state of the lock or semaphore name of colonyID
+// in reefID: its live leases.
+func (l *Locks) Semaphore(ctx context.Context, reefID, colonyID, name string) (*Semaphore, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	sem, _, err := l.get(ctx, lockKey(reefID, colonyID, name), reefID, colonyID, name)
+	if err != nil {
+		return nil, err
+	}
+	sem.prune(time.Now())
+	return sem, nil
+}
+
+// get reads the semaphore at key, returning an empty one for semaphores
+// never held.
+func (l *Locks) get(ctx context.Context, key, reefID, colonyID, name string) (*Semaphore, uint64, error) {
+	entry, err := l.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return &Semaphore{ReefID: reefID, ColonyID: colonyID, Name: name, Leases: []*Lease{}}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var sem Semaphore
+	if err := json.Unmarshal(entry.Value, &sem); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode semaphore: %w", err)
+	}
+	return &sem, entry.Revision, nil
+}
+
+// put writes sem at key if it is still at revision rev.
+func (l *Locks) put(ctx context.Context, key string, rev uint64, sem *Semaphore) error {
+	data, err := json.Marshal(sem)
+	if err != nil {
+		return err
+	}
+	_, err = l.store.CompareAndSwap(ctx, key, rev, data)
+	return err
+}
+
+// prune drops the leases expired at now. The fence is kept, so that
+// leases acquired later get greater tokens.
+func (sem *Semaphore) prune(now time.Time) {
+	sem.Leases = slices.DeleteFunc(sem.Leases, func(lease *Lease) bool { return !now.Before(lease.ExpiresAt) })
+	if sem.Leases == nil {
+		sem.Leases = []*Lease{}
+	}
+	if len(sem.Leases) == 0 {
+		sem.Limit = 0
+	}
+}
+
+// holders lists the agents holding the leases of sem.
+func holders(sem *Semaphore) string {
+	agents := make([]string, len(sem.Leases))
+	for i, lease := range sem.Leases {
+		agents[i] = lease.AgentID
+	}
+	return strings.Join(agents, ", ")
+}
+
+// holds reports whether lease is one of h.
+func (h Holder) holds(lease *Lease) bool {
+	return lease.AgentID == h.AgentID && lease.Session == h.Session
+}
+
+func (h Holder) validate() error {
+	if h.ReefID == "" || h.ColonyID == "" || h.AgentID == "" || strings.Contains(h.ReefID, "/") || strings.Contains(h.ColonyID, "/") {
+		return fmt.Errorf("%w: holder agent, colony and reef are required", ErrInvalid)
+	}
+	if len(h.Session) > 64 {
+		return fmt.Errorf("%w: session longer than 64 bytes", ErrInvalid)
+	}
+	return validateName(h.Name)
+}
+
+// validateName accepts names of printable characters without spaces,
+// such as "colony/x/deploy".
+func validateName(name string) error {
+	if name == "" || len(name) > MaxNameSize {
+		return fmt.Errorf("%w: name must be 1 to %d bytes", ErrInvalid, MaxNameSize)
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) || unicode.IsSpace(r) }) >= 0 {
+		return fmt.Errorf("%w: name %q holds spaces or control characters", ErrInvalid, name)
+	}
+	return nil
+}
+
+func lockKey(reefID, colonyID, name string) string {
+	return keyPrefix + reefID + "/" + colonyID + "/" + name
+}
@@ -0,0 +1,280 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+)
+
+func holder(agent string) lock.Holder {
+	return lock.Holder{ReefID: "reef", ColonyID: "colony", AgentID: agent, Name: "deploy"}
+}
+
+func acquire(t *testing.T, l *lock.Locks, h lock.Holder, limit int, ttl, wait time.Duration) *lock.Lease {
+	t.Helper()
+	lease, err := l.Acquire(context.Background(), h, limit, ttl, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lease
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+	a, b := holder("a"), holder("b")
+
+	lease := acquire(t, l, a, 0, 0, 0)
+	if lease.AgentID != "a" || lease.Limit != 1 || lease.Fence != 1 {
+		t.Fatalf("first lease %+v, want a's with limit 1 and fence 1", lease)
+	}
+	if again := acquire(t, l, a, 0, 0, 0); again.Fence != lease.Fence {
+		t.Fatalf("holder acquiring again got fence %d, want %d", again.Fence, lease.Fence)
+	}
+	if _, err := l.Acquire(ctx, b, 0, 0, 0); !errors.Is(err, lock.ErrUnavailable) {
+		t.Fatalf("b acquiring a held lock: %v, want ErrUnavailable", err)
+	}
+	// Another instance of a is another holder.
+	other := a
+	other.Session = "second"
+	if _, err := l.Acquire(ctx, other, 0, 0, 0); !errors.Is(err, lock.ErrUnavailable) {
+		t.Fatalf("second session of a: %v, want ErrUnavailable", err)
+	}
+	if _, err := l.Renew(ctx, b, lease.Fence); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatalf("renewal by b: %v, want ErrNotHeld", err)
+	}
+	if err := l.Release(ctx, b, lease.Fence); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatalf("release by b: %v, want ErrNotHeld", err)
+	}
+	renewed, err := l.Renew(ctx, a, lease.Fence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.ExpiresAt.Before(lease.ExpiresAt) {
+		t.Fatalf("renewal moved the expiry from %v to %v", lease.ExpiresAt, renewed.ExpiresAt)
+	}
+
+	if err := l.Release(ctx, a, lease.Fence); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(ctx, a, lease.Fence); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatalf("second release: %v, want ErrNotHeld", err)
+	}
+	if next := acquire(t, l, b, 0, 0, 0); next.AgentID != "b" || next.Fence != lease.Fence+1 {
+		t.Fatalf("lease after a released: %+v, want b's with fence %d", next, lease.Fence+1)
+	}
+
+	// Locks of other colonies and reefs are apart.
+	elsewhere := a
+	elsewhere.ReefID = "other"
+	if lease := acquire(t, l, elsewhere, 0, 0, 0); lease.Fence != 1 {
+		t.Fatalf("lock of another reef: fence %d, want 1", lease.Fence)
+	}
+}
+
+// TestContention has holders wait for a lock in turn, and checks that it
+// is never held twice at once.
+func TestContention(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+	var held, acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			h := holder(fmt.Sprint("agent-", i))
+			lease, err := l.Acquire(ctx, h, 0, 0, 20*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n := held.Add(1); n != 1 {
+				t.Errorf("%d holders at once", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+			held.Add(-1)
+			acquired.Add(1)
+			if err := l.Release(ctx, h, lease.Fence); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := acquired.Load(); n != 8 {
+		t.Fatalf("%d of 8 holders acquired the lock", n)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+
+	var wg sync.WaitGroup
+	leases := make([]*lock.Lease, 10)
+	for i := range leases {
+		wg.Go(func() {
+			lease, err := l.Acquire(ctx, holder(fmt.Sprint("agent-", i)), 3, 0, 0)
+			if err != nil && !errors.Is(err, lock.ErrUnavailable) {
+				t.Error(err)
+			}
+			leases[i] = lease
+		})
+	}
+	wg.Wait()
+	var held []*lock.Lease
+	for _, lease := range leases {
+		if lease != nil {
+			held = append(held, lease)
+		}
+	}
+	if len(held) != 3 {
+		t.Fatalf("%d holders acquired a semaphore of 3", len(held))
+	}
+
+	sem, err := l.Semaphore(ctx, "reef", "colony", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sem.Limit != 3 || len(sem.Leases) != 3 || sem.Fence != 3 {
+		t.Fatalf("semaphore with limit %d, %d leases and fence %d; want 3 of each", sem.Limit, len(sem.Leases), sem.Fence)
+	}
+	if _, err := l.Acquire(ctx, holder("late"), 3, 0, 0); !errors.Is(err, lock.ErrUnavailable) {
+		t.Fatalf("acquiring a full semaphore: %v, want ErrUnavailable", err)
+	}
+	if _, err := l.Acquire(ctx, holder("late"), 4, 0, 0); !errors.Is(err, lock.ErrInvalid) {
+		t.Fatalf("acquiring with another limit: %v, want ErrInvalid", err)
+	}
+
+	// A release frees a slot for a waiting holder.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := l.Release(ctx, holder(held[0].AgentID), held[0].Fence); err != nil {
+			t.Error(err)
+		}
+	}()
+	lease := acquire(t, l, holder("late"), 3, 0, 10*time.Second)
+	if lease.Fence != 4 {
+		t.Fatalf("fence %d after 3 leases, want 4", lease.Fence)
+	}
+}
+
+// TestExpiry checks that an expired lease frees its slot without
+// resetting the fence, and can no longer be renewed or released.
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+	a := holder("a")
+	lease := acquire(t, l, a, 0, lock.MinTTL, 0)
+
+	time.Sleep(time.Until(lease.ExpiresAt) + 10*time.Millisecond)
+	sem, err := l.Semaphore(ctx, "reef", "colony", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sem.Leases) != 0 || sem.Limit != 0 || sem.Fence != lease.Fence {
+		t.Fatalf("expired semaphore with %d leases, limit %d and fence %d; want none, 0 and %d", len(sem.Leases), sem.Limit, sem.Fence, lease.Fence)
+	}
+	if _, err := l.Renew(ctx, a, lease.Fence); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatalf("renewal of an expired lease: %v, want ErrNotHeld", err)
+	}
+	if err := l.Release(ctx, a, lease.Fence); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatalf("release of an expired lease: %v, want ErrNotHeld", err)
+	}
+	// Pruning kept the fence: the next lease, even under another limit,
+	// gets a greater token.
+	if next := acquire(t, l, holder("b"), 2, 0, 0); next.Fence != lease.Fence+1 {
+		t.Fatalf("fence %d after an expired lease of %d", next.Fence, lease.Fence)
+	}
+}
+
+// TestWaitForExpiry checks that a waiting holder takes a lock over once
+// its lease runs out.
+func TestWaitForExpiry(t *testing.T) {
+	l := lock.New(lock.Config{})
+	lease := acquire(t, l, holder("a"), 0, lock.MinTTL, 0)
+	next := acquire(t, l, holder("b"), 0, 0, 10*time.Second)
+	if next.Fence != lease.Fence+1 {
+		t.Fatalf("fence %d after %d", next.Fence, lease.Fence)
+	}
+	if time.Now().Before(lease.ExpiresAt) {
+		t.Fatalf("b acquired the lock before a's lease expired at %v", lease.ExpiresAt)
+	}
+}
+
+// TestFences checks that every lease gets a greater token than those
+// acquired before it, across releases and concurrent acquisitions.
+func TestFences(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+	var mu sync.Mutex
+	seen := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := range 6 {
+		wg.Go(func() {
+			h := holder(fmt.Sprint("agent-", i))
+			var prev uint64
+			for range 5 {
+				lease, err := l.Acquire(ctx, h, 2, 0, 20*time.Second)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[lease.Fence] {
+					t.Errorf("fence %d handed out twice", lease.Fence)
+				}
+				seen[lease.Fence] = true
+				mu.Unlock()
+				if lease.Fence <= prev {
+					t.Errorf("%s got fence %d after %d", h.AgentID, lease.Fence, prev)
+				}
+				prev = lease.Fence
+				if err := l.Release(ctx, h, lease.Fence); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if len(seen) != 30 {
+		t.Fatalf("%d fences for 30 leases", len(seen))
+	}
+	sem, err := l.Semaphore(ctx, "reef", "colony", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sem.Fence != 30 {
+		t.Fatalf("semaphore fence %d after 30 leases", sem.Fence)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	ctx := context.Background()
+	l := lock.New(lock.Config{})
+	for name, h := range map[string]lock.Holder{
+		"no reef":         {ColonyID: "colony", AgentID: "a", Name: "deploy"},
+		"slash in colony": {ReefID: "reef", ColonyID: "a/b", AgentID: "a", Name: "deploy"},
+		"no name":         {ReefID: "reef", ColonyID: "colony", AgentID: "a"},
+		"long session":    {ReefID: "reef", ColonyID: "colony", AgentID: "a", Name: "deploy", Session: string(make([]byte, 65))},
+	} {
+		if _, err := l.Acquire(ctx, h, 0, 0, 0); !errors.Is(err, lock.ErrInvalid) {
+			t.Errorf("%s: %v, want ErrInvalid", name, err)
+		}
+	}
+	for _, limit := range []int{-1, lock.MaxLimit + 1} {
+		if _, err := l.Acquire(ctx, holder("a"), limit, 0, 0); !errors.Is(err, lock.ErrInvalid) {
+			t.Errorf("limit %d: %v, want ErrInvalid", limit, err)
+		}
+	}
+	for _, ttl := range []time.Duration{time.Millisecond, 1500 * time.Millisecond, lock.MaxTTL + time.Second} {
+		if _, err := l.Acquire(ctx, holder("a"), 0, ttl, 0); !errors.Is(err, lock.ErrInvalid) {
+			t.Errorf("ttl %v: %v, want ErrInvalid", ttl, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+)
+
+// errTicket marks tickets refused by the lock exports.
+var errTicket = errors.New("invalid referral ticket")
+
+// holder authenticates ticket and returns its agent as holder of the
+// lock name of its colony. Writes are charged to the agent and colony.
+func (h *registryHandle) holder(ticket, name, session string, class ratelimit.Class) (lock.Holder, error) {
+	p, err := auth.Ticket(h.verifier, ticket)
+	if err != nil {
+		return lock.Holder{}, fmt.Errorf("%w: %v", errTicket, err)
+	}
+	if err := limiter.Load().Allow(class, ratelimit.Key{ReefID: p.ReefID, AgentID: p.AgentID, ColonyID: p.ColonyID}); err != nil {
+		return lock.Holder{}, err
+	}
+	return lock.Holder{ReefID: p.ReefID, ColonyID: p.ColonyID, AgentID: p.AgentID, Name: name, Session: session}, nil
+}
+
+// lockStatus returns the live leases of a lock or semaphore of the
+// ticket's colony. Arguments: ticket, name
+// Returns: { semaphore }
+func (h *registryHandle) lockStatus(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: ticket, name")
+	}
+	hd, err := h.holder(args[0].String(), args[1].String(), "", ratelimit.Lookup)
+	if err != nil {
+		return lockError(err)
+	}
+	sem, err := h.locks.Semaphore(context.Background(), hd.ReefID, hd.ColonyID, hd.Name)
+	if err != nil {
+		return lockError(err)
+	}
+	obj, err := toJSObject(sem)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"semaphore": obj}
+}
+
+// acquireLock leases the ticket's agent a slot of a lock or semaphore of
+// its colony, of which at most limit (default 1) are held at once, for
+// ttlSeconds (default 30). It does not wait: while every slot is held it
+// fails with ERR_LOCK_UNAVAILABLE, and the caller retries.
+// Arguments: ticket, name, [limit], [ttlSeconds], [session]
+// Returns: { lease }
+func (h *registryHandle) acquireLock(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: ticket, name")
+	}
+	var limit, ttlSeconds int
+	if len(args) > 2 && args[2].Type() == js.TypeNumber {
+		limit = args[2].Int()
+	}
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		ttlSeconds = args[3].Int()
+	}
+	hd, err := h.holder(args[0].String(), args[1].String(), optionalString(args, 4), ratelimit.Register)
+	if err != nil {
+		return lockError(err)
+	}
+	lease, err := h.locks.Acquire(context.Background(), hd, limit, time.Duration(ttlSeconds)*time.Second, 0)
+	if err != nil {
+		return lockError(err)
+	}
+	return leaseResult(lease)
+}
+
+// renewLock extends a lease of the ticket's agent by its TTL.
+// Arguments: ticket, name, fence, [session]
+// Returns: { lease }
+func (h *registryHandle) renewLock(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: ticket, name, fence")
+	}
+	hd, err := h.holder(args[0].String(), args[1].String(), optionalString(args, 3), ratelimit.Register)
+	if err != nil {
+		return lockError(err)
+	}
+	lease, err := h.locks.Renew(context.Background(), hd, uint64(args[2].Int()))
+	if err != nil {
+		return lockError(err)
+	}
+	return leaseResult(lease)
+}
+
+// releaseLock gives up a lease of the ticket's agent, so that another
+// holder can acquire it right away. Arguments: ticket, name, fence, [session]
+// Returns: { released: true }
+func (h *registryHandle) releaseLock(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: ticket, name, fence")
+	}
+	hd, err := h.holder(args[0].String(), args[1].String(), optionalString(args, 3), ratelimit.Register)
+	if err != nil {
+		return lockError(err)
+	}
+	if err := h.locks.Release(context.Background(), hd, uint64(args[2].Int())); err != nil {
+		return lockError(err)
+	}
+	return map[string]interface{}{"released": true}
+}
+
+// optionalString returns args[i] when it is a string, or "".
+func optionalString(args []js.Value, i int) string {
+	if len(args) > i && args[i].Type() == js.TypeString {
+		return args[i].String()
+	}
+	return ""
+}
+
+func leaseResult(lease *lock.Lease) interface{} {
+	obj, err := toJSObject(lease)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"lease": obj}
+}
+
+// lockError maps a lock error to an export error result.
+func lockError(err error) interface{} {
+	switch {
+	case errors.Is(err, lock.ErrUnavailable):
+		return errorResult(errLockUnavailable, err.Error())
+	case errors.Is(err, lock.ErrNotHeld):
+		return errorResult(errLockNotHeld, err.Error())
+	case errors.Is(err, lock.ErrInvalid):
+		return errorResult(errInvalidArgument, err.Error())
+	case errors.Is(err, errTicket):
+		return errorResult(errUnauthorized, err.Error())
+	case errors.Is(err, ratelimit.ErrLimited):
+		return errorResult(errRateLimited, err.Error())
+	default:
+		return errorResult(errInternal, err.Error())
+	}
+}
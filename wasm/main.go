@@ -1,37 +1,87 @@
-//go:build tinygo.wasm || js
+//go:build (tinygo.wasm || js) && !lite
 
 // Package main provides Wasm entrypoint for coral-crypto operations.
 // This is compiled with TinyGo and bundled with the Cloudflare Worker.
 package main
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"syscall/js"
+	"time"
 
 	"github.com/coral-mesh/coral-crypto/jwt"
 	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/webcrypto"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
 )
 
 func main() {
-	// Register functions for JavaScript interop.
+	// Check ticket signatures with the host's native Ed25519 where it has
+	// one.
+	verify.Ed25519Verifier = webcrypto.Valid
+
+	// Register functions for JavaScript interop. Every export returns a
+	// Promise so callers never stall the Worker event loop.
 	js.Global().Set("coralCrypto", js.ValueOf(map[string]interface{}{
-		"createReferralTicket": js.FuncOf(createReferralTicket),
-		"verifySignature":      js.FuncOf(verifySignature),
-		"generateKeyPair":      js.FuncOf(generateKeyPair),
+		"createReferralTicket":   promisify(createReferralTicket),
+		"verifySignature":        promisify(verifySignature),
+		"verifySignatures":       promisify(verifySignatures),
+		"parseReferralTicket":    promisify(parseReferralTicket),
+		"createReferralCWT":      promisify(createReferralCWT),
+		"verifyCWT":              promisify(verifyCWT),
+		"delegateTicket":         promisify(delegateTicket),
+		"verifyDelegatedTicket":  promisify(verifyDelegatedTicket),
+		"createAttenuableTicket": promisify(createAttenuableTicket),
+		"attenuateTicket":        promisify(attenuateTicket),
+		"verifyAttenuatedTicket": promisify(verifyAttenuatedTicket),
+		"createProof":            promisify(createProof),
+		"verifyProof":            promisify(verifyProof),
+		"verifyCredential":       promisify(verifyCredential),
+		"thresholdCommit":        promisify(thresholdCommit),
+		"thresholdSign":          promisify(thresholdSign),
+		"thresholdAggregate":     promisify(thresholdAggregate),
+		"loadJWKS":               promisify(loadJWKS),
+		"fetchJWKS":              promisify(fetchJWKS),
+		"verifyJWKSBundle":       promisify(verifyJWKSBundle),
+		"configureTracing":       promisify(configureTracing),
+		"flushTraces":            promisify(flushTraces),
+		"configureAudit":         promisify(configureAudit),
+		"configureRateLimit":     promisify(configureRateLimit),
+		"rateLimit":              promisify(rateLimit),
+		"rateLimitFailure":       promisify(rateLimitFailure),
+		"loadRevocations":        promisify(loadRevocations),
+		"loadPolicy":             promisify(loadPolicy),
+		"signDetached":           promisify(signDetached),
+		"verifyDetached":         promisify(verifyDetached),
+		"generateKeyPair":        promisify(generateKeyPair),
+		"deriveKey":              promisify(deriveKey),
+		"deriveSharedSecret":     promisify(deriveSharedSecret),
+		"sealPrivateKey":         promisify(sealPrivateKey),
+		"unsealPrivateKey":       promisify(unsealPrivateKey),
+		"keyToMnemonic":          promisify(keyToMnemonic),
+		"keyFromMnemonic":        promisify(keyFromMnemonic),
+		"openRegistry":           promisify(openRegistry),
+		"openReplayGuard":        promisify(openReplayGuard),
 	}))
 
 	// Keep the program running.
 	select {}
 }
 
-// createReferralTicket creates a new referral ticket JWT.
-// Arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds
-// Returns: { jwt: string, expiresAt: number } or { error: string }
+// createReferralTicket creates a new referral ticket JWT. When
+// holderPublicKeyB64 is given the ticket is bound to it (cnf) and must be
+// presented with a proof from createProof.
+// Arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds, [holderPublicKeyB64]
+// Returns: { jwt: string, expiresAt: number } or { error: { code, message, retryable } }
 func createReferralTicket(this js.Value, args []js.Value) interface{} {
 	if len(args) < 7 {
-		return map[string]interface{}{
-			"error": "expected 7 arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds",
-		}
+		return errorResult(errInvalidArgument, "expected 7 arguments: privateKeyB64, keyID, reefID, colonyID, agentID, intent, ttlSeconds")
 	}
 
 	privateKeyB64 := args[0].String()
@@ -45,52 +95,91 @@ func createReferralTicket(this js.Value, args []js.Value) interface{} {
 	// Decode private key.
 	privateKey, err := keys.DecodePrivateKey(privateKeyB64)
 	if err != nil {
-		return map[string]interface{}{
-			"error": "failed to decode private key: " + err.Error(),
-		}
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+
+	if len(args) > 7 && args[7].Type() == js.TypeString {
+		return recordIssued(createBoundTicket(privateKey, keyID, reefID, colonyID, agentID, intent, ttlSeconds, args[7].String()),
+			reefID, colonyID, agentID, intent)
 	}
 
 	// Create token.
-	token, expiresAt, err := jwt.CreateReferralTicketStatic(
-		privateKey,
-		keyID,
-		reefID,
-		colonyID,
-		agentID,
-		intent,
-		ttlSeconds,
-		"", "", // Use defaults for issuer and audience.
-	)
+	token, expiresAt, err := signReferralTicket(privateKey, keyID, reefID, colonyID, agentID, intent, ttlSeconds)
 	if err != nil {
-		return map[string]interface{}{
-			"error": "failed to create token: " + err.Error(),
-		}
+		return errorResult(errTokenSign, "failed to create token: "+err.Error())
 	}
 
-	return map[string]interface{}{
+	return recordIssued(map[string]interface{}{
 		"jwt":       token,
 		"expiresAt": expiresAt,
+	}, reefID, colonyID, agentID, intent)
+}
+
+// signReferralTicket is jwt.CreateReferralTicketStatic with the default
+// issuer and audience, signing through WebCrypto where available.
+func signReferralTicket(privateKey ed25519.PrivateKey, keyID, reefID, colonyID, agentID, intent string, ttlSeconds int) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(ttlSeconds) * time.Second)
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, &jwt.ReferralClaims{
+		ReefID:   reefID,
+		ColonyID: colonyID,
+		AgentID:  agentID,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expiresAt),
+		},
+	})
+	token.Header["kid"] = keyID
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign token: %w", err)
 	}
+	sig := webcrypto.Sign(privateKey, []byte(signingString))
+	return signingString + "." + token.EncodeSegment(sig), expiresAt.Unix(), nil
 }
 
-// verifySignature verifies a JWT signature against JWKS.
-// Arguments: tokenString, jwksJSON
-// Returns: { valid: boolean } or { error: string }
+// verifySignature verifies a JWT signature against JWKS. When jwksJSON is
+// omitted the key is taken from the cache populated by loadJWKS. When
+// options is given the issuer, audience and lifetime are checked as well
+// (see verifyOptionsFromJS). A bad signature yields { valid: false };
+// expired, malformed, unknown-kid or unexpected tokens yield a structured
+// error.
+// Arguments: tokenString, [jwksJSON], [options]
+// Returns: { valid: boolean } or { error: { code, message, retryable } }
 func verifySignature(this js.Value, args []js.Value) interface{} {
-	if len(args) < 2 {
-		return map[string]interface{}{
-			"error": "expected 2 arguments: tokenString, jwksJSON",
-		}
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected at least 1 argument: tokenString, [jwksJSON], [options]")
 	}
 
 	tokenString := args[0].String()
-	jwksJSON := args[1].String()
 
-	valid, err := jwt.VerifySignatureStatic(tokenString, jwksJSON)
-	if err != nil {
-		return map[string]interface{}{
-			"error": err.Error(),
+	var opts *verify.Options
+	if len(args) > 2 {
+		o, err := verifyOptionsFromJS(args[2])
+		if err != nil {
+			return errorResult(errInvalidArgument, err.Error())
 		}
+		opts = o
+	}
+
+	var valid bool
+	var verr *exportError
+	if len(args) < 2 || args[1].IsUndefined() || args[1].IsNull() {
+		valid, verr = keyCache.verify(tokenString, opts)
+	} else {
+		keys, err := parseJWKS(args[1].String())
+		if err != nil {
+			return errorResult(errJWKSMalformed, err.Error())
+		}
+		valid, verr = verifyToken(keys, tokenString, opts)
+	}
+	if verr != nil {
+		return verr.result()
 	}
 
 	return map[string]interface{}{
@@ -98,22 +187,150 @@ func verifySignature(this js.Value, args []js.Value) interface{} {
 	}
 }
 
+// verifySignatures verifies a batch of JWT signatures in a single call.
+// Validators are built once per distinct JWKS document in the batch. Items
+// without jwksJSON are verified against the loadJWKS cache. EdDSA
+// signatures are checked together (see verify.ParseClaimsBatch).
+// Arguments: [{ token, [jwksJSON], [options] }, ...]
+// Returns: { results: [{ valid: boolean } | { error: {...} }, ...] } or { error: {...} }
+func verifySignatures(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || !js.Global().Get("Array").Call("isArray", args[0]).Bool() {
+		return errorResult(errInvalidArgument, "expected 1 argument: array of { token, jwksJSON }")
+	}
+
+	items := args[0]
+	n := items.Length()
+	validators := make(map[string]*verify.Keys)
+	results := make([]interface{}, n)
+	tickets := make([]verify.Ticket, 0, n)
+	indexes := make([]int, 0, n)
+
+	for i := 0; i < n; i++ {
+		item := items.Index(i)
+		if item.Type() != js.TypeObject {
+			results[i] = errorResult(errInvalidArgument, "expected object with token and jwksJSON")
+			continue
+		}
+
+		tokenString := item.Get("token").String()
+		jwksValue := item.Get("jwksJSON")
+		opts, err := verifyOptionsFromJS(item.Get("options"))
+		if err != nil {
+			results[i] = errorResult(errInvalidArgument, err.Error())
+			continue
+		}
+
+		var validator *verify.Keys
+		if jwksValue.IsUndefined() || jwksValue.IsNull() {
+			v, verr := keyCache.tokenValidator(tokenString)
+			if verr != nil {
+				results[i] = verr.result()
+				continue
+			}
+			validator = v
+		} else {
+			jwksJSON := jwksValue.String()
+			v, ok := validators[jwksJSON]
+			if !ok {
+				v, err = parseJWKS(jwksJSON)
+				if err != nil {
+					results[i] = errorResult(errJWKSMalformed, err.Error())
+					continue
+				}
+				validators[jwksJSON] = v
+			}
+			validator = v
+		}
+		tickets = append(tickets, verify.Ticket{
+			Keys:   validator,
+			Token:  tokenString,
+			Opts:   opts,
+			Claims: &jwt.ReferralClaims{},
+		})
+		indexes = append(indexes, i)
+	}
+
+	for j, err := range verify.ParseClaimsBatch(tickets) {
+		valid, verr := checkToken(tickets[j].Claims, tickets[j].Opts, err)
+		if verr != nil {
+			results[indexes[j]] = verr.result()
+			continue
+		}
+		results[indexes[j]] = map[string]interface{}{
+			"valid": valid,
+		}
+	}
+
+	return map[string]interface{}{
+		"results": results,
+	}
+}
+
+// verifyToken checks tokenString against keys and, when opts is non-nil,
+// the expectations in opts; without opts only verify.DefaultAlgorithms are
+// accepted. A bad signature or disallowed algorithm is reported as
+// valid=false; every other failure, including a jti present in
+// the loadRevocations snapshot or an intent denied by the loadPolicy
+// policy, returns an exportError.
+func verifyToken(keys verify.KeySource, tokenString string, opts *verify.Options) (bool, *exportError) {
+	claims := jwt.ReferralClaims{}
+	return checkToken(&claims, opts, verify.ParseClaims(keys, tokenString, opts, &claims))
+}
+
+// checkToken finishes verifyToken for claims that verify.ParseClaims
+// returned err for.
+func checkToken(claims *jwt.ReferralClaims, opts *verify.Options, err error) (bool, *exportError) {
+	if err == nil && opts != nil {
+		if cerr := opts.Check(claims); errors.Is(cerr, gojwt.ErrTokenExpired) {
+			return false, classifyTokenError(cerr)
+		} else if cerr != nil {
+			return false, newError(errTokenClaims, cerr.Error())
+		}
+	}
+	if err == nil {
+		if isRevoked(claims.ReefID, claims.ID) {
+			return false, newError(errTokenRevoked, "ticket "+claims.ID+" has been revoked")
+		}
+		if perr := checkPolicy(claims); perr != nil {
+			return false, perr
+		}
+		return true, nil
+	}
+	if errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+		return false, nil
+	}
+	return false, classifyTokenError(err)
+}
+
+// classifyTokenError maps a golang-jwt parse error to an exportError.
+func classifyTokenError(err error) *exportError {
+	var exportErr *exportError
+	switch {
+	case errors.As(err, &exportErr):
+		return exportErr
+	case errors.Is(err, gojwt.ErrTokenExpired):
+		return newError(errTokenExpired, err.Error())
+	case errors.Is(err, gojwt.ErrTokenNotValidYet), errors.Is(err, gojwt.ErrTokenUsedBeforeIssued):
+		return newError(errTokenNotYet, err.Error())
+	case errors.Is(err, gojwt.ErrTokenUnverifiable):
+		return newError(errKidUnknown, err.Error())
+	default:
+		return newError(errTokenMalformed, err.Error())
+	}
+}
+
 // generateKeyPair generates a new Ed25519 key pair.
-// Returns: { id, privateKey, publicKey, jwk } or { error: string }
+// Returns: { id, privateKey, publicKey, jwk } or { error: { code, message, retryable } }
 func generateKeyPair(this js.Value, args []js.Value) interface{} {
 	kp, err := keys.GenerateKeyPair()
 	if err != nil {
-		return map[string]interface{}{
-			"error": "failed to generate key pair: " + err.Error(),
-		}
+		return errorResult(errKeyGenerate, "failed to generate key pair: "+err.Error())
 	}
 
 	jwk := kp.ToJWK()
 	jwkJSON, err := json.Marshal(jwk)
 	if err != nil {
-		return map[string]interface{}{
-			"error": "failed to marshal JWK: " + err.Error(),
-		}
+		return errorResult(errInternal, "failed to marshal JWK: "+err.Error())
 	}
 
 	return map[string]interface{}{
@@ -0,0 +1,218 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Query intervals: the first query is repeated after a second and the
+// interval doubles up to a minute (RFC 6762 section 5.2).
+const (
+	firstQueryInterval = time.Second
+	maxQueryInterval   = time.Minute
+)
+
+// Browse queries the link for agents and sends an event whenever one
+// appears, changes, says goodbye or expires, until ctx is done. Records
+// carry the announced TTL; ExpiresAt is when the entry lapses unless it
+// is announced again.
+func Browse(ctx context.Context, cfg Config) (<-chan registry.Event, error) {
+	conn, err := listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	query, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: mustName(Service), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	answers := make(chan []announcement)
+	go func() {
+		defer close(answers)
+		buf := make([]byte, maxPacket)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if found := parseResponse(buf[:n]); len(found) > 0 {
+				select {
+				case answers <- found:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	events := make(chan registry.Event, 16)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+
+		b := browser{events: events, seen: make(map[string]*entry), query: func() { _, _ = conn.WriteToUDP(query, Group) }}
+		interval := firstQueryInterval
+		queryTimer := time.NewTimer(0)
+		defer queryTimer.Stop()
+		expiry := time.NewTicker(time.Second)
+		defer expiry.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-queryTimer.C:
+				b.query()
+				queryTimer.Reset(interval)
+				interval = min(2*interval, maxQueryInterval)
+			case found, ok := <-answers:
+				if !ok {
+					return
+				}
+				for _, a := range found {
+					b.apply(ctx, a)
+				}
+			case now := <-expiry.C:
+				b.expire(ctx, now)
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Lookup browses for wait and returns the agents then known.
+func Lookup(ctx context.Context, cfg Config, wait time.Duration) ([]*registry.Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	events, err := Browse(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*registry.Record)
+	var order []string
+	for ev := range events {
+		id := ev.Record.AgentID
+		switch ev.Type {
+		case registry.EventPut:
+			if _, ok := found[id]; !ok {
+				order = append(order, id)
+			}
+			found[id] = ev.Record
+		case registry.EventDelete:
+			delete(found, id)
+		}
+	}
+
+	recs := make([]*registry.Record, 0, len(found))
+	for _, id := range order {
+		if rec, ok := found[id]; ok {
+			recs = append(recs, rec)
+		}
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return recs, err
+	}
+	return recs, nil
+}
+
+// announcement is one instance's records from a response.
+type announcement struct {
+	txt []string
+	ttl uint32
+}
+
+// parseResponse extracts the TXT records of coral instances.
+func parseResponse(packet []byte) []announcement {
+	var msg dnsmessage.Message
+	if msg.Unpack(packet) != nil || !msg.Response {
+		return nil
+	}
+	var found []announcement
+	for _, r := range append(msg.Answers, msg.Additionals...) {
+		txt, ok := r.Body.(*dnsmessage.TXTResource)
+		if !ok || !strings.HasSuffix(strings.ToLower(r.Header.Name.String()), "."+Service) {
+			continue
+		}
+		found = append(found, announcement{txt: txt.TXT, ttl: r.Header.TTL})
+	}
+	return found
+}
+
+type entry struct {
+	rec       *registry.Record
+	txt       string
+	refreshAt time.Time
+}
+
+// browser tracks the agents seen by Browse.
+type browser struct {
+	events chan<- registry.Event
+	seen   map[string]*entry // by agent ID
+	query  func()
+}
+
+func (b *browser) apply(ctx context.Context, a announcement) {
+	rec, err := parseTXT(a.txt)
+	if err != nil {
+		return
+	}
+	old := b.seen[rec.AgentID]
+	if a.ttl == 0 {
+		if old != nil {
+			delete(b.seen, rec.AgentID)
+			b.emit(ctx, registry.EventDelete, old.rec)
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	rec.TTLSeconds = int(a.ttl)
+	rec.UpdatedAt = now
+	rec.ExpiresAt = now.Add(time.Duration(a.ttl) * time.Second)
+	rec.CreatedAt = now
+	if old != nil {
+		rec.CreatedAt = old.rec.CreatedAt
+	}
+	key := strings.Join(a.txt, "\x00")
+	// Re-query at 80% of the TTL so live agents are refreshed before
+	// they expire (RFC 6762 section 5.2).
+	refreshAt := now.Add(time.Duration(a.ttl) * time.Second * 8 / 10)
+	b.seen[rec.AgentID] = &entry{rec: rec, txt: key, refreshAt: refreshAt}
+	if old == nil || old.txt != key {
+		b.emit(ctx, registry.EventPut, rec)
+	}
+}
+
+func (b *browser) expire(ctx context.Context, now time.Time) {
+	refresh := false
+	for id, e := range b.seen {
+		switch {
+		case !now.Before(e.rec.ExpiresAt):
+			delete(b.seen, id)
+			b.emit(ctx, registry.EventDelete, e.rec)
+		case !now.Before(e.refreshAt):
+			e.refreshAt = e.rec.ExpiresAt
+			refresh = true
+		}
+	}
+	if refresh {
+		b.query()
+	}
+}
+
+func (b *browser) emit(ctx context.Context, t registry.EventType, rec *registry.Record) {
+	select {
+	case b.events <- registry.Event{Type: t, Record: rec}:
+	case <-ctx.Done():
+	}
+}
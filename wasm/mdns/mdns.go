@@ -0,0 +1,192 @@
+// Package mdns announces agents on the local network as DNS-SD services
+// and browses for peers, for air-gapped deployments without a reachable
+// discovery server. Each agent is an instance of
+// _coral._tcp.local with a SRV record for its first endpoint and a TXT
+// record carrying its identity, endpoints and capabilities; browsing
+// turns the answers back into registry.Record values.
+//
+// Records are multicast over IPv4 (224.0.0.251:5353) without
+// authentication: anyone on the link can announce. Treat browsed
+// records as hints and authenticate the peer when connecting.
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Service is the DNS-SD service type agents are announced as.
+const Service = "_coral._tcp.local."
+
+// DefaultTTL is the record TTL when Config.TTL is zero.
+const DefaultTTL = 120 * time.Second
+
+// Group is the mDNS IPv4 multicast group.
+var Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// ErrInvalidRecord is returned for announcements that cannot be turned
+// into a record, and for records that cannot be announced.
+var ErrInvalidRecord = errors.New("invalid mDNS record")
+
+// cacheFlush marks unique records (RFC 6762 section 10.2).
+const cacheFlush = 1 << 15
+
+// maxPacket is the largest mDNS message read or written.
+const maxPacket = 9000
+
+// Config holds the settings shared by Responder and Browse.
+type Config struct {
+	// Interface is the network interface to use; nil lets the system
+	// choose.
+	Interface *net.Interface
+
+	// TTL is the TTL of announced records. Browsed records expire after
+	// the TTL their responder announced.
+	TTL time.Duration
+}
+
+func (c Config) ttl() uint32 {
+	if c.TTL <= 0 {
+		return uint32(DefaultTTL.Seconds())
+	}
+	return uint32(c.TTL.Seconds())
+}
+
+// listen joins the mDNS group on cfg's interface. Multicast loopback,
+// which ListenMulticastUDP disables, is turned back on so agents on the
+// same host see each other.
+func listen(cfg Config) (*net.UDPConn, error) {
+	conn, err := net.ListenMulticastUDP("udp4", cfg.Interface, Group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+	if err := ipv4.NewPacketConn(conn).SetMulticastLoopback(true); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable multicast loopback: %w", err)
+	}
+	return conn, nil
+}
+
+// instanceName is the DNS-SD instance name of agentID. Dots, which
+// dnsmessage cannot escape, are replaced; the TXT record carries the real
+// ID.
+func instanceName(agentID string) string {
+	label := strings.ReplaceAll(agentID, ".", "-")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label + "." + Service
+}
+
+// hostName returns the .local host name announced in SRV targets.
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "coral"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	if len(host) > 63 {
+		host = host[:63]
+	}
+	return host + ".local."
+}
+
+// TXT keys. Endpoints are numbered because DNS-SD only honours the first
+// occurrence of a key.
+const (
+	txtVersion      = "txtvers"
+	txtAgent        = "agent"
+	txtColony       = "colony"
+	txtReef         = "reef"
+	txtCapabilities = "caps"
+	txtEndpoint     = "ep"
+)
+
+// recordTXT encodes rec as TXT strings.
+func recordTXT(rec *registry.Record) ([]string, error) {
+	txt := []string{
+		txtVersion + "=1",
+		txtAgent + "=" + rec.AgentID,
+		txtColony + "=" + rec.ColonyID,
+		txtReef + "=" + rec.ReefID,
+	}
+	for i, ep := range rec.Endpoints {
+		txt = append(txt, txtEndpoint+strconv.Itoa(i)+"="+ep)
+	}
+	if len(rec.Capabilities) > 0 {
+		txt = append(txt, txtCapabilities+"="+strings.Join(rec.Capabilities, ","))
+	}
+	for _, s := range txt {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("%w: TXT entry %q exceeds 255 bytes", ErrInvalidRecord, s[:strings.IndexByte(s, '=')])
+		}
+	}
+	return txt, nil
+}
+
+// parseTXT decodes TXT strings made by recordTXT.
+func parseTXT(txt []string) (*registry.Record, error) {
+	values := make(map[string]string, len(txt))
+	for _, s := range txt {
+		k, v, _ := strings.Cut(s, "=")
+		if _, dup := values[k]; !dup {
+			values[k] = v
+		}
+	}
+	if values[txtVersion] != "1" {
+		return nil, fmt.Errorf("%w: unsupported txtvers %q", ErrInvalidRecord, values[txtVersion])
+	}
+
+	rec := &registry.Record{
+		AgentID:  values[txtAgent],
+		ColonyID: values[txtColony],
+		ReefID:   values[txtReef],
+	}
+	for i := 0; ; i++ {
+		ep, ok := values[txtEndpoint+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		rec.Endpoints = append(rec.Endpoints, ep)
+	}
+	if caps := values[txtCapabilities]; caps != "" {
+		rec.Capabilities = strings.Split(caps, ",")
+	}
+	if err := rec.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	return rec, nil
+}
+
+// endpointPort is the port of an endpoint given as host:port or a URL.
+func endpointPort(endpoint string) (uint16, bool) {
+	if _, rest, ok := strings.Cut(endpoint, "://"); ok {
+		endpoint, _, _ = strings.Cut(rest, "/")
+	}
+	_, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return uint16(n), err == nil
+}
+
+func mustName(s string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		// Names are at most two 63-byte labels under Service or
+		// .local, well inside the 255-byte limit.
+		panic(err)
+	}
+	return n
+}
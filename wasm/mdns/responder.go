@@ -0,0 +1,207 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Responder announces agents and answers queries for them.
+type Responder struct {
+	conn  *net.UDPConn
+	iface *net.Interface
+	host  string
+	ttl   uint32
+
+	mu     sync.Mutex
+	agents map[string]*registry.Record // by instance name
+}
+
+// NewResponder joins the mDNS group. Call Serve to answer queries.
+func NewResponder(cfg Config) (*Responder, error) {
+	conn, err := listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{
+		conn:   conn,
+		iface:  cfg.Interface,
+		host:   hostName(),
+		ttl:    cfg.ttl(),
+		agents: make(map[string]*registry.Record),
+	}, nil
+}
+
+// Announce starts answering for rec and multicasts it unsolicited.
+// Announcing an agent again replaces its record.
+func (r *Responder) Announce(rec *registry.Record) error {
+	if err := rec.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	if _, ok := endpointPort(rec.Endpoints[0]); !ok {
+		return fmt.Errorf("%w: first endpoint has no port", ErrInvalidRecord)
+	}
+	if _, err := recordTXT(rec); err != nil {
+		return err
+	}
+
+	name := instanceName(rec.AgentID)
+	r.mu.Lock()
+	r.agents[name] = rec
+	r.mu.Unlock()
+	return r.send(name, r.ttl)
+}
+
+// Withdraw stops answering for agentID and multicasts a goodbye so peers
+// drop it immediately.
+func (r *Responder) Withdraw(agentID string) error {
+	name := instanceName(agentID)
+	r.mu.Lock()
+	_, ok := r.agents[name]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	err := r.send(name, 0)
+
+	r.mu.Lock()
+	delete(r.agents, name)
+	r.mu.Unlock()
+	return err
+}
+
+// Serve answers queries for the announced agents until ctx is done or the
+// Responder is closed. The connection stays open so Close can still say
+// goodbye.
+func (r *Responder) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = r.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, maxPacket)
+	for {
+		n, _, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		var msg dnsmessage.Message
+		if msg.Unpack(buf[:n]) != nil || msg.Response {
+			continue
+		}
+		for _, q := range msg.Questions {
+			for _, name := range r.matching(q) {
+				_ = r.send(name, r.ttl)
+			}
+		}
+	}
+}
+
+// Close sends goodbyes for every announced agent and leaves the group.
+func (r *Responder) Close() error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.agents))
+	for name := range r.agents {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, r.send(name, 0))
+	}
+	errs = append(errs, r.conn.Close())
+	return errors.Join(errs...)
+}
+
+// matching returns the instances a question asks about.
+func (r *Responder) matching(q dnsmessage.Question) []string {
+	asked := strings.ToLower(q.Name.String())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for name := range r.agents {
+		switch {
+		case asked == Service && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			names = append(names, name)
+		case asked == strings.ToLower(name):
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// send multicasts the PTR, SRV, TXT and address records of an instance
+// with ttl; zero says goodbye.
+func (r *Responder) send(name string, ttl uint32) error {
+	r.mu.Lock()
+	rec := r.agents[name]
+	r.mu.Unlock()
+	if rec == nil {
+		return nil
+	}
+	txt, err := recordTXT(rec)
+	if err != nil {
+		return err
+	}
+	port, _ := endpointPort(rec.Endpoints[0])
+
+	instance, host := mustName(name), mustName(r.host)
+	header := func(n dnsmessage.Name, t dnsmessage.Type, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= cacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: n, Type: t, Class: class, TTL: ttl}
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{Header: header(mustName(Service), dnsmessage.TypePTR, false), Body: &dnsmessage.PTRResource{PTR: instance}},
+			{Header: header(instance, dnsmessage.TypeSRV, true), Body: &dnsmessage.SRVResource{Target: host, Port: port}},
+			{Header: header(instance, dnsmessage.TypeTXT, true), Body: &dnsmessage.TXTResource{TXT: txt}},
+		},
+	}
+	for _, ip := range r.addresses() {
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: header(host, dnsmessage.TypeA, true),
+			Body:   &dnsmessage.AResource{A: [4]byte(ip)},
+		})
+	}
+
+	packet, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.WriteToUDP(packet, Group)
+	return err
+}
+
+// addresses returns the IPv4 addresses announced for the host.
+func (r *Responder) addresses() []net.IP {
+	var addrs []net.Addr
+	if r.iface != nil {
+		addrs, _ = r.iface.Addrs()
+	} else {
+		addrs, _ = net.InterfaceAddrs()
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if ip := n.IP.To4(); ip != nil && !ip.IsLoopback() {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,432 @@
+// Package meshca is a minimal certificate authority for the mesh. It
+// issues short-lived Ed25519 X.509 certificates to registered agents so
+// that peers found through the registry can authenticate each other
+// directly over mTLS. Each certificate names its agent with a SPIFFE ID
+// URI SAN, spiffe://<trust domain>/reef/R/colony/C/agent/A, and chains
+// to a self-signed root per CA key.
+//
+// CA keys are managed with the coral-crypto keys package: a keys.Manager
+// persists and rotates them, the current key signs, and the roots of
+// every key it still holds are published so certificates issued before
+// a rotation keep verifying until they expire.
+//
+// Agents renew their certificates without a ticket through orders (see
+// Order): the certified key answers a challenge, so thousands of agents
+// rotate their credentials before expiry without operator involvement.
+package meshca
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Defaults for Config.
+const (
+	// DefaultTTL is the lifetime of agent certificates.
+	DefaultTTL = time.Hour
+
+	// DefaultRootTTL is the lifetime of root certificates, counted from
+	// the creation of their key.
+	DefaultRootTTL = 10 * 365 * 24 * time.Hour
+
+	// DefaultOrderTTL is how long a renewal order may take to finalize.
+	DefaultOrderTTL = 10 * time.Minute
+)
+
+// backdate is subtracted from NotBefore to tolerate peers whose clocks
+// run behind.
+const backdate = 5 * time.Minute
+
+var (
+	// ErrInvalidCSR is returned for certificate requests that do not
+	// parse, are not for an Ed25519 key, are badly signed or are for a
+	// key other than the one the ticket is bound to.
+	ErrInvalidCSR = errors.New("invalid certificate request")
+
+	// ErrNotRegistered is returned when the ticket's agent has no live
+	// registration in its colony.
+	ErrNotRegistered = errors.New("agent is not registered")
+
+	// ErrInvalidCertificate is returned for peer certificates that do
+	// not chain to a root or carry no agent identity.
+	ErrInvalidCertificate = errors.New("invalid mesh certificate")
+)
+
+// KeySource holds the CA keys. *keys.Manager satisfies it.
+type KeySource interface {
+	CurrentKey() *keys.KeyPair
+	AllKeys() []*keys.KeyPair
+}
+
+// Config holds the configuration for a CA.
+type Config struct {
+	// Keys holds the CA keys. Required.
+	Keys KeySource
+
+	// Registry is consulted for the live registration of every agent
+	// requesting a certificate. Required.
+	Registry *registry.Registry
+
+	// TrustDomain is the SPIFFE trust domain of agent IDs. Required.
+	TrustDomain string
+
+	// TTL is the lifetime of agent certificates. Defaults to DefaultTTL.
+	TTL time.Duration
+
+	// RootTTL is the lifetime of root certificates. Defaults to
+	// DefaultRootTTL.
+	RootTTL time.Duration
+
+	// Store holds renewal orders. Defaults to an in-memory store.
+	Store store.Store
+
+	// OrderTTL is how long a renewal order may take to finalize.
+	// Defaults to DefaultOrderTTL.
+	OrderTTL time.Duration
+
+	// Clock is the time source. Defaults to clock.System.
+	Clock clock.Clock
+}
+
+// CA issues agent certificates.
+type CA struct {
+	keys        KeySource
+	registry    *registry.Registry
+	trustDomain string
+	ttl         time.Duration
+	rootTTL     time.Duration
+	store       store.Store
+	orderTTL    time.Duration
+	clock       clock.Clock
+}
+
+// Certificate is an issued agent certificate.
+type Certificate struct {
+	// Certificate is the PEM leaf certificate.
+	Certificate string `json:"certificate"`
+
+	// Chain is the PEM root the leaf was signed by.
+	Chain string `json:"chain"`
+
+	// SPIFFEID is the agent's ID, as in the URI SAN.
+	SPIFFEID  string    `json:"spiffe_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Identity is the agent a mesh certificate was issued to.
+type Identity struct {
+	ReefID   string
+	ColonyID string
+	AgentID  string
+}
+
+// New creates a CA from cfg.
+func New(cfg Config) (*CA, error) {
+	if cfg.Keys == nil {
+		return nil, errors.New("meshca: Keys is required")
+	}
+	if cfg.Registry == nil {
+		return nil, errors.New("meshca: Registry is required")
+	}
+	if _, err := spiffe.ParseID("spiffe://" + cfg.TrustDomain); err != nil || cfg.TrustDomain == "" {
+		return nil, fmt.Errorf("meshca: invalid trust domain %q", cfg.TrustDomain)
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	rootTTL := cfg.RootTTL
+	if rootTTL <= 0 {
+		rootTTL = DefaultRootTTL
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	orderTTL := cfg.OrderTTL
+	if orderTTL <= 0 {
+		orderTTL = DefaultOrderTTL
+	}
+	return &CA{
+		keys:        cfg.Keys,
+		registry:    cfg.Registry,
+		trustDomain: cfg.TrustDomain,
+		ttl:         ttl,
+		rootTTL:     rootTTL,
+		store:       st,
+		orderTTL:    orderTTL,
+		clock:       clock.Or(cfg.Clock),
+	}, nil
+}
+
+// Issue signs a certificate for the agent named by claims, the verified
+// claims of its ticket, over the key of csrDER, a DER PKCS#10 request.
+// The agent must hold a live registration in the ticket's colony, and
+// when ticket is bound to a key (see package pop) the request must be for
+// that key.
+func (ca *CA) Issue(ctx context.Context, claims *jwt.ReferralClaims, ticket string, csrDER []byte) (*Certificate, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	pub, ok := csr.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is %T, not Ed25519", ErrInvalidCSR, csr.PublicKey)
+	}
+	// Tickets in other formats than JWT are never bound.
+	jkt, _ := pop.Confirmed(ticket)
+	if jkt != "" && jkt != pop.Thumbprint(pub) {
+		return nil, fmt.Errorf("%w: key does not match the ticket's binding", ErrInvalidCSR)
+	}
+	return ca.certify(ctx, Identity{ReefID: claims.ReefID, ColonyID: claims.ColonyID, AgentID: claims.AgentID}, pub)
+}
+
+// certify signs a certificate for pub naming id, which must hold a live
+// registration.
+func (ca *CA) certify(ctx context.Context, id Identity, pub ed25519.PublicKey) (*Certificate, error) {
+	rec, err := ca.registry.Lookup(ctx, id.ReefID, id.AgentID)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegistered, id.AgentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.ColonyID != id.ColonyID {
+		return nil, fmt.Errorf("%w: %s in colony %s", ErrNotRegistered, id.AgentID, id.ColonyID)
+	}
+
+	key := ca.keys.CurrentKey()
+	if key == nil {
+		return nil, errors.New("meshca: no CA key")
+	}
+	root, err := ca.root(key)
+	if err != nil {
+		return nil, err
+	}
+	sid := ca.SPIFFEID(id)
+	uri, err := url.Parse(sid.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: agent ID does not form a URI: %v", ErrInvalidCSR, err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := ca.clock.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: rec.AgentID, Organization: []string{rec.ReefID}, OrganizationalUnit: []string{rec.ColonyID}},
+		URIs:         []*url.URL{uri},
+		NotBefore:    now.Add(-backdate),
+		NotAfter:     now.Add(ca.ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root, pub, key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return &Certificate{
+		Certificate: encodePEM(der),
+		Chain:       encodePEM(root.Raw),
+		SPIFFEID:    sid.String(),
+		ExpiresAt:   tmpl.NotAfter.UTC(),
+	}, nil
+}
+
+// Roots returns the root certificates of every CA key.
+func (ca *CA) Roots() ([]*x509.Certificate, error) {
+	all := ca.keys.AllKeys()
+	roots := make([]*x509.Certificate, 0, len(all))
+	for _, key := range all {
+		root, err := ca.root(key)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// Pool returns Roots as a pool, to verify agent certificates against.
+func (ca *CA) Pool() (*x509.CertPool, error) {
+	roots, err := ca.Roots()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	return pool, nil
+}
+
+// RootsPEM returns Roots as a PEM bundle.
+func (ca *CA) RootsPEM() (string, error) {
+	roots, err := ca.Roots()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, root := range roots {
+		b.WriteString(encodePEM(root.Raw))
+	}
+	return b.String(), nil
+}
+
+// SPIFFEID returns the SPIFFE ID certificates name id with.
+func (ca *CA) SPIFFEID(id Identity) spiffe.ID {
+	return spiffe.ID{
+		TrustDomain: ca.trustDomain,
+		Path:        "/reef/" + id.ReefID + "/colony/" + id.ColonyID + "/agent/" + id.AgentID,
+	}
+}
+
+// root returns the self-signed root certificate of key. It is rebuilt
+// deterministically from the key, so every instance holding the key
+// presents the same root.
+func (ca *CA) root(key *keys.KeyPair) (*x509.Certificate, error) {
+	sum := sha256.Sum256(key.PublicKey)
+	tmpl := &x509.Certificate{
+		SerialNumber:          new(big.Int).SetBytes(sum[:16]),
+		Subject:               pkix.Name{CommonName: "Coral mesh CA " + key.ID, Organization: []string{ca.trustDomain}},
+		SubjectKeyId:          sum[:20],
+		NotBefore:             key.CreatedAt.Add(-backdate),
+		NotAfter:              key.CreatedAt.Add(ca.rootTTL),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		PermittedURIDomains:   []string{ca.trustDomain},
+	}
+	// Ed25519 signatures are deterministic, so is the DER.
+	der, err := x509.CreateCertificate(nil, tmpl, tmpl, key.PublicKey, key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build CA root %s: %w", key.ID, err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Verify checks that cert chains to one of roots at c's time (clock.System
+// when nil) and returns the agent it was issued to.
+func Verify(cert *x509.Certificate, roots *x509.CertPool, c clock.Clock) (*Identity, error) {
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: clock.Or(c).Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return ParseIdentity(cert)
+}
+
+// Authenticator authenticates requests by the client certificate they
+// presented over TLS, which must chain to the pool roots returns. The
+// certificate stands in for a ticket with the registry.IntentRegister
+// intent, as certificates are only issued to registered agents.
+func Authenticator(roots func() (*x509.CertPool, error), c clock.Clock) auth.Authenticator {
+	return auth.AuthenticatorFunc(func(r *http.Request) (*auth.Principal, error) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil, auth.ErrNoCredentials
+		}
+		pool, err := roots()
+		if err != nil {
+			return nil, err
+		}
+		id, err := Verify(r.TLS.PeerCertificates[0], pool, c)
+		if err != nil {
+			return nil, err
+		}
+		return &auth.Principal{Method: auth.MethodCertificate, ReferralClaims: &jwt.ReferralClaims{
+			ReefID:   id.ReefID,
+			ColonyID: id.ColonyID,
+			AgentID:  id.AgentID,
+			Intent:   registry.IntentRegister,
+		}}, nil
+	})
+}
+
+// ParseIdentity returns the agent named by cert's SPIFFE ID. The
+// certificate is not verified.
+func ParseIdentity(cert *x509.Certificate) (*Identity, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("%w: want one URI SAN, got %d", ErrInvalidCertificate, len(cert.URIs))
+	}
+	id, err := spiffe.ParseID(cert.URIs[0].String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	segs := strings.Split(strings.TrimPrefix(id.Path, "/"), "/")
+	if len(segs) != 6 || segs[0] != "reef" || segs[2] != "colony" || segs[4] != "agent" {
+		return nil, fmt.Errorf("%w: %s does not name an agent", ErrInvalidCertificate, id)
+	}
+	return &Identity{ReefID: segs[1], ColonyID: segs[3], AgentID: segs[5]}, nil
+}
+
+// ParseRoots parses a PEM bundle such as RootsPEM's into a pool.
+func ParseRoots(bundle []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("no certificates in CA bundle")
+	}
+	return pool, nil
+}
+
+// TLSCertificate pairs cert with its key for a tls.Config, e.g. to
+// present it as a client certificate from GetClientCertificate.
+func TLSCertificate(cert *Certificate, key ed25519.PrivateKey) (*tls.Certificate, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	pair, err := tls.X509KeyPair([]byte(cert.Certificate), keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return &pair, nil
+}
+
+// NewCSR returns a DER certificate request for key.
+func NewCSR(key ed25519.PrivateKey) ([]byte, error) {
+	return x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+}
+
+// newSerial returns a random 128-bit serial number.
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	return serial, nil
+}
+
+func encodePEM(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
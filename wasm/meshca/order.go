@@ -0,0 +1,263 @@
+package meshca
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// orderPrefix is the store key prefix of renewal orders, stored by ID.
+const orderPrefix = "meshca/orders/"
+
+// Order states, named after their ACME (RFC 8555) counterparts.
+const (
+	// OrderPending orders await the response to their challenge.
+	OrderPending = "pending"
+
+	// OrderReady orders had their challenge answered and await a CSR.
+	OrderReady = "ready"
+
+	// OrderValid orders were finalized and carry the new certificate.
+	OrderValid = "valid"
+)
+
+var (
+	// ErrOrderNotFound is returned for unknown or pruned orders.
+	ErrOrderNotFound = errors.New("order not found")
+
+	// ErrOrderState is returned when an order is not in the state the
+	// step requires, or has expired.
+	ErrOrderState = errors.New("order is not in the required state")
+
+	// ErrChallengeFailed is returned for challenge responses not signed
+	// by the certified key.
+	ErrChallengeFailed = errors.New("challenge response is not signed by the certified key")
+)
+
+// Order renews the certificate of an agent without a referral ticket,
+// after the flow of ACME: the agent opens the order with its current
+// certificate, answers the challenge by signing KeyAuthorization with
+// the certified key, then finalizes with a request for that same key.
+type Order struct {
+	ID       string `json:"id"`
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	AgentID  string `json:"agent_id"`
+
+	// PublicKey is the certified key, base64-encoded. The challenge is
+	// answered, and the renewed certificate issued, for this key only.
+	PublicKey string `json:"public_key"`
+
+	Status string `json:"status"`
+
+	// Challenge is the random token the agent signs to prove possession
+	// of the key.
+	Challenge string    `json:"challenge"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Certificate is the renewed certificate, once valid.
+	Certificate *Certificate `json:"certificate,omitempty"`
+}
+
+// KeyAuthorization returns the message whose Ed25519 signature by the
+// certified key answers o's challenge.
+func (o *Order) KeyAuthorization() []byte {
+	return []byte("coral-mesh-renewal\n" + o.ID + "\n" + o.Challenge)
+}
+
+// NewOrder opens a renewal order for the agent certified by certPEM,
+// which must be a current certificate of this CA for an Ed25519 key.
+// Expired certificates are not renewed; agents request new ones with a
+// ticket instead (see Issue).
+func (ca *CA) NewOrder(ctx context.Context, certPEM []byte) (*Order, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%w: no PEM certificate", ErrInvalidCertificate)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is %T, not Ed25519", ErrInvalidCertificate, cert.PublicKey)
+	}
+	pool, err := ca.Pool()
+	if err != nil {
+		return nil, err
+	}
+	id, err := Verify(cert, pool, ca.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	now := ca.clock.Now().UTC()
+	o := &Order{
+		ID:        uuid.New().String(),
+		ReefID:    id.ReefID,
+		ColonyID:  id.ColonyID,
+		AgentID:   id.AgentID,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Status:    OrderPending,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ca.orderTTL),
+	}
+	if err := ca.saveOrder(ctx, o, 0); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Order returns the order with id.
+func (ca *CA) Order(ctx context.Context, id string) (*Order, error) {
+	o, _, err := ca.loadOrder(ctx, id)
+	return o, err
+}
+
+// Respond answers the challenge of pending order id with sig, the
+// certified key's signature of the order's KeyAuthorization.
+func (ca *CA) Respond(ctx context.Context, id string, sig []byte) (*Order, error) {
+	return ca.updateOrder(ctx, id, OrderPending, func(o *Order, pub ed25519.PublicKey) error {
+		if !ed25519.Verify(pub, o.KeyAuthorization(), sig) {
+			return ErrChallengeFailed
+		}
+		o.Status = OrderReady
+		return nil
+	})
+}
+
+// Finalize issues the renewed certificate of ready order id for csrDER,
+// a DER PKCS#10 request for the certified key. The agent must still hold
+// a live registration in its colony.
+func (ca *CA) Finalize(ctx context.Context, id string, csrDER []byte) (*Order, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	return ca.updateOrder(ctx, id, OrderReady, func(o *Order, pub ed25519.PublicKey) error {
+		if !pub.Equal(csr.PublicKey) {
+			return fmt.Errorf("%w: key is not the certified key", ErrInvalidCSR)
+		}
+		cert, err := ca.certify(ctx, Identity{ReefID: o.ReefID, ColonyID: o.ColonyID, AgentID: o.AgentID}, pub)
+		if err != nil {
+			return err
+		}
+		o.Status, o.Certificate = OrderValid, cert
+		return nil
+	})
+}
+
+// Prune deletes expired orders and returns how many were removed.
+func (ca *CA) Prune(ctx context.Context) (int, error) {
+	entries, err := ca.store.List(ctx, orderPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := ca.clock.Now()
+	pruned := 0
+	for _, entry := range entries {
+		var o Order
+		if err := json.Unmarshal(entry.Value, &o); err == nil && o.ExpiresAt.After(now) {
+			continue
+		}
+		if err := ca.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (ca *CA) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = ca.Prune(ctx)
+		}
+	}
+}
+
+// updateOrder applies fn to order id, which must be unexpired and in
+// state status, retrying on concurrent writes.
+func (ca *CA) updateOrder(ctx context.Context, id, status string, fn func(*Order, ed25519.PublicKey) error) (*Order, error) {
+	for {
+		o, rev, err := ca.loadOrder(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if o.Status != status {
+			return nil, fmt.Errorf("%w: order is %s, not %s", ErrOrderState, o.Status, status)
+		}
+		if !ca.clock.Now().Before(o.ExpiresAt) {
+			return nil, fmt.Errorf("%w: order expired at %s", ErrOrderState, o.ExpiresAt.Format(time.RFC3339))
+		}
+		pub, err := base64.StdEncoding.DecodeString(o.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("failed to decode key of order %s", id)
+		}
+		if err := fn(o, pub); err != nil {
+			return nil, err
+		}
+		err = ca.saveOrder(ctx, o, rev)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+}
+
+// loadOrder reads an order and its revision.
+func (ca *CA) loadOrder(ctx context.Context, id string) (*Order, uint64, error) {
+	entry, err := ca.store.Get(ctx, orderPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var o Order
+	if err := json.Unmarshal(entry.Value, &o); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode order %s: %w", id, err)
+	}
+	return &o, entry.Revision, nil
+}
+
+// saveOrder writes o if its stored revision is still revision.
+func (ca *CA) saveOrder(ctx context.Context, o *Order, revision uint64) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	_, err = ca.store.CompareAndSwap(ctx, orderPrefix+o.ID, revision, data)
+	return err
+}
@@ -0,0 +1,379 @@
+// Package metrics collects counters, gauges and histograms and serves
+// them in the Prometheus text exposition format. It has no dependencies
+// beyond the standard library, so it builds for the Worker as well.
+//
+// Instruments are created on a Set and addressed by label values given
+// in the order of the label names they were created with. Methods on nil
+// instruments do nothing, so components can hold instruments from an
+// optional Set without checking for one.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, used
+// when Histogram is given none: from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Set is a collection of instruments. It is safe for concurrent use and
+// serves its instruments as an http.Handler.
+type Set struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// family is a named instrument and all its labelled series.
+type family interface {
+	kind() string
+	write(w *bufio.Writer)
+}
+
+// NewSet returns an empty Set.
+func NewSet() *Set {
+	return &Set{families: map[string]family{}}
+}
+
+// Counter creates a counter, or returns the existing one of that name.
+func (s *Set) Counter(name, help string, labels ...string) *Counter {
+	if s == nil {
+		return nil
+	}
+	return register(s, name, func() *Counter {
+		return &Counter{vec: newVec[float64](name, help, labels)}
+	})
+}
+
+// Gauge creates a gauge, or returns the existing one of that name.
+func (s *Set) Gauge(name, help string, labels ...string) *Gauge {
+	if s == nil {
+		return nil
+	}
+	return register(s, name, func() *Gauge {
+		return &Gauge{vec: newVec[float64](name, help, labels)}
+	})
+}
+
+// GaugeFunc creates an unlabelled gauge whose value is read from fn at
+// every scrape.
+func (s *Set) GaugeFunc(name, help string, fn func() float64) {
+	if s == nil {
+		return
+	}
+	register(s, name, func() *gaugeFunc {
+		return &gaugeFunc{name: name, help: help, fn: fn}
+	})
+}
+
+// Histogram creates a histogram with the given bucket upper bounds, or
+// DefaultBuckets when nil, or returns the existing one of that name.
+func (s *Set) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if s == nil {
+		return nil
+	}
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return register(s, name, func() *Histogram {
+		return &Histogram{vec: newVec[*histogramValue](name, help, labels), buckets: buckets}
+	})
+}
+
+// register adds the family built by create under name, unless a family
+// of that name, which must be of the same type, exists already.
+func register[F family](s *Set, name string, create func() F) F {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.families[name]; ok {
+		existing, ok := f.(F)
+		if !ok {
+			panic(fmt.Sprintf("metrics: %s registered as a %s", name, f.kind()))
+		}
+		return existing
+	}
+	f := create()
+	s.families[name] = f
+	return f
+}
+
+// WriteTo writes every instrument to w in the exposition format, sorted
+// by name.
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	families := make([]family, 0, len(names))
+	slices.Sort(names)
+	for _, name := range names {
+		families = append(families, s.families[name])
+	}
+	s.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements http.Handler for the /metrics route.
+func (s *Set) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = s.WriteTo(w)
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter struct {
+	*vec[float64]
+}
+
+// Inc adds one to the series of labels.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds delta, which must not be negative, to the series of labels.
+func (c *Counter) Add(delta float64, labels ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+	c.update(labels, func(v *float64) { *v += delta })
+}
+
+func (c *Counter) kind() string { return "counter" }
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeScalars(w, c.vec, c.kind())
+}
+
+// Gauge is a value per label set that may go up and down.
+type Gauge struct {
+	*vec[float64]
+}
+
+// Set sets the series of labels to v.
+func (g *Gauge) Set(v float64, labels ...string) {
+	if g == nil {
+		return
+	}
+	g.update(labels, func(cur *float64) { *cur = v })
+}
+
+// Add adds delta to the series of labels.
+func (g *Gauge) Add(delta float64, labels ...string) {
+	if g == nil {
+		return
+	}
+	g.update(labels, func(v *float64) { *v += delta })
+}
+
+func (g *Gauge) kind() string { return "gauge" }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeScalars(w, g.vec, g.kind())
+}
+
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) kind() string { return "gauge" }
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, g.kind())
+	writeSample(w, g.name, "", g.fn())
+}
+
+// Histogram counts observations, such as latencies, into buckets per
+// label set.
+type Histogram struct {
+	*vec[*histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Observe records v in the series of labels.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	if h == nil {
+		return
+	}
+	h.update(labels, func(hv **histogramValue) {
+		if *hv == nil {
+			*hv = &histogramValue{counts: make([]uint64, len(h.buckets)+1)}
+		}
+		i, _ := slices.BinarySearch(h.buckets, v)
+		(*hv).counts[i]++
+		(*hv).sum += v
+		(*hv).count++
+	})
+}
+
+// Since records the seconds elapsed since start in the series of labels.
+func (h *Histogram) Since(start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+func (h *Histogram) kind() string { return "histogram" }
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, h.kind())
+	h.each(func(labels string, hv *histogramValue) {
+		if hv == nil {
+			hv = &histogramValue{counts: make([]uint64, len(h.buckets)+1)}
+		}
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			writeSample(w, h.name+"_bucket", labels+sep+`le="`+formatFloat(bound)+`"`, float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", labels+sep+`le="+Inf"`, float64(hv.count))
+		writeSample(w, h.name+"_sum", labels, hv.sum)
+		writeSample(w, h.name+"_count", labels, float64(hv.count))
+	})
+}
+
+// vec holds the series of an instrument, keyed by label values.
+type vec[V any] struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series[V]
+}
+
+type series[V any] struct {
+	labels string // rendered name="value" pairs
+	value  V
+}
+
+// newVec creates a vec. Without labels its one series exists from the
+// start, so it is exposed before the first observation.
+func newVec[V any](name, help string, labels []string) *vec[V] {
+	v := &vec[V]{name: name, help: help, labels: labels, series: map[string]*series[V]{}}
+	if len(labels) == 0 {
+		v.series[""] = &series[V]{}
+	}
+	return v
+}
+
+// update calls fn on the value of the series of values, creating it.
+// Missing values are empty and extra ones are dropped.
+func (v *vec[V]) update(values []string, fn func(*V)) {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[V]{labels: v.render(values)}
+		v.series[key] = s
+	}
+	fn(&s.value)
+}
+
+func (v *vec[V]) render(values []string) string {
+	var b strings.Builder
+	for i, name := range v.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name + `="` + escape(value, true) + `"`)
+	}
+	return b.String()
+}
+
+// each calls fn for every series, sorted by labels. Updates wait until
+// it returns.
+func (v *vec[V]) each(fn func(labels string, value V)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	all := make([]*series[V], 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	slices.SortFunc(all, func(a, b *series[V]) int { return strings.Compare(a.labels, b.labels) })
+	for _, s := range all {
+		fn(s.labels, s.value)
+	}
+}
+
+func writeScalars(w *bufio.Writer, v *vec[float64], kind string) {
+	writeHeader(w, v.name, v.help, kind)
+	v.each(func(labels string, value float64) {
+		writeSample(w, v.name, labels, value)
+	})
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escape(help, false), name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes backslashes and newlines, and in label values quotes.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Store wraps st so that its operations are timed in
+// coral_store_operation_duration_seconds and their failures counted in
+// coral_store_errors_total, both by operation. Missing keys and
+// compare-and-swap conflicts are expected outcomes, not failures.
+func (s *Set) Store(st store.Store) store.Store {
+	return &timedStore{
+		Store: st,
+		duration: s.Histogram("coral_store_operation_duration_seconds",
+			"Latency of store operations, by operation.", nil, "op"),
+		errors: s.Counter("coral_store_errors_total",
+			"Failed store operations, by operation.", "op"),
+	}
+}
+
+type timedStore struct {
+	store.Store
+	duration *Histogram
+	errors   *Counter
+}
+
+func (t *timedStore) observe(op string, start time.Time, err error) {
+	t.duration.Since(start, op)
+	if err != nil && !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrConflict) {
+		t.errors.Inc(op)
+	}
+}
+
+func (t *timedStore) Get(ctx context.Context, key string) (*store.Entry, error) {
+	start := time.Now()
+	entry, err := t.Store.Get(ctx, key)
+	t.observe("get", start, err)
+	return entry, err
+}
+
+func (t *timedStore) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	start := time.Now()
+	rev, err := t.Store.Put(ctx, key, value)
+	t.observe("put", start, err)
+	return rev, err
+}
+
+func (t *timedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := t.Store.Delete(ctx, key)
+	t.observe("delete", start, err)
+	return err
+}
+
+func (t *timedStore) List(ctx context.Context, prefix string) ([]*store.Entry, error) {
+	start := time.Now()
+	entries, err := t.Store.List(ctx, prefix)
+	t.observe("list", start, err)
+	return entries, err
+}
+
+func (t *timedStore) CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	start := time.Now()
+	rev, err := t.Store.CompareAndSwap(ctx, key, revision, value)
+	t.observe("cas", start, err)
+	return rev, err
+}
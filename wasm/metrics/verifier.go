@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Verifier validates referral tickets; registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// TicketFailures returns the coral_ticket_verification_failures_total
+// counter of rejected referral tickets, labelled by reason.
+func (s *Set) TicketFailures() *Counter {
+	return s.Counter("coral_ticket_verification_failures_total",
+		"Referral tickets rejected, by reason.", "reason")
+}
+
+// Verifier wraps inner so that its rejections are counted in
+// TicketFailures by FailureReason. Wrap the outermost verifier to see
+// every rejection.
+func (s *Set) Verifier(inner Verifier) Verifier {
+	return &verifier{inner: inner, failures: s.TicketFailures()}
+}
+
+type verifier struct {
+	inner    Verifier
+	failures *Counter
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil {
+		v.failures.Inc(FailureReason(err))
+	}
+	return claims, err
+}
+
+// FailureReason classifies a ticket verification error for the reason
+// label: expired, not_yet_valid, signature, malformed, issuer, audience,
+// lifetime, revoked, delegation, policy, replayed or other.
+func FailureReason(err error) string {
+	switch {
+	case errors.Is(err, gojwt.ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, gojwt.ErrTokenNotValidYet), errors.Is(err, gojwt.ErrTokenUsedBeforeIssued):
+		return "not_yet_valid"
+	case errors.Is(err, gojwt.ErrTokenSignatureInvalid), errors.Is(err, gojwt.ErrTokenUnverifiable):
+		return "signature"
+	case errors.Is(err, gojwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, verify.ErrIssuer):
+		return "issuer"
+	case errors.Is(err, verify.ErrAudience):
+		return "audience"
+	case errors.Is(err, verify.ErrLifetime), errors.Is(err, gojwt.ErrTokenRequiredClaimMissing):
+		return "lifetime"
+	case errors.Is(err, revocation.ErrRevoked), errors.Is(err, delegation.ErrRevoked):
+		return "revoked"
+	case errors.Is(err, delegation.ErrChainTooDeep), errors.Is(err, delegation.ErrNotDelegable), errors.Is(err, delegation.ErrNotNarrower):
+		return "delegation"
+	case errors.Is(err, policy.ErrDenied):
+		return "policy"
+	case errors.Is(err, replay.ErrReplayed), errors.Is(err, replay.ErrMissingJTI), errors.Is(err, replay.ErrMissingExpiry):
+		return "replayed"
+	}
+	return "other"
+}
@@ -0,0 +1,53 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"crypto/ed25519"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/mnemonic"
+)
+
+// keyToMnemonic encodes a private key as a 24-word backup phrase.
+// Arguments: privateKeyB64
+// Returns: { mnemonic: string } or { error: { code, message, retryable } }
+func keyToMnemonic(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: privateKeyB64")
+	}
+
+	privateKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	phrase, err := mnemonic.FromKey(privateKey)
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	return map[string]interface{}{
+		"mnemonic": phrase,
+	}
+}
+
+// keyFromMnemonic restores the key pair backed up by keyToMnemonic.
+// Arguments: mnemonic
+// Returns: { privateKey, publicKey } or { error: { code, message, retryable } }
+func keyFromMnemonic(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: mnemonic")
+	}
+
+	privateKey, err := mnemonic.ToKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, err.Error())
+	}
+
+	return map[string]interface{}{
+		"privateKey": keys.EncodePrivateKey(privateKey),
+		"publicKey":  keys.EncodePublicKey(privateKey.Public().(ed25519.PublicKey)),
+	}
+}
@@ -0,0 +1,96 @@
+// Package mnemonic backs up Ed25519 keys as BIP39 word mnemonics. The
+// 32-byte key seed is the BIP39 entropy, so every key maps to exactly one
+// 24-word phrase from the standard English list and back; the 8-bit
+// checksum catches most transcription errors.
+//
+// The phrase encodes the seed itself. It is not run through the BIP39
+// PBKDF2 seed derivation, so it will not restore the same key in wallet
+// software.
+package mnemonic
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Words is the number of words in a key mnemonic.
+const Words = 24
+
+var (
+	// ErrLength is returned for phrases that are not Words words long.
+	ErrLength = errors.New("mnemonic must have 24 words")
+
+	// ErrUnknownWord is returned for words outside the English list.
+	ErrUnknownWord = errors.New("unknown mnemonic word")
+
+	// ErrChecksum is returned when the phrase checksum does not match.
+	ErrChecksum = errors.New("mnemonic checksum mismatch")
+)
+
+//go:embed english.txt
+var english string
+
+var (
+	wordList  = strings.Fields(english)
+	wordIndex = func() map[string]int {
+		m := make(map[string]int, len(wordList))
+		for i, w := range wordList {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+// FromKey returns the mnemonic for key's seed.
+func FromKey(key ed25519.PrivateKey) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("private key must be %d bytes", ed25519.PrivateKeySize)
+	}
+	seed := key.Seed()
+	sum := sha256.Sum256(seed)
+
+	// 256 bits of entropy plus an 8-bit checksum make 24 11-bit indexes.
+	bits := append(seed, sum[0])
+	words := make([]string, Words)
+	for i := range words {
+		idx := 0
+		for b := i * 11; b < (i+1)*11; b++ {
+			idx = idx<<1 | int(bits[b/8]>>(7-b%8)&1)
+		}
+		words[i] = wordList[idx]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// ToKey restores the key backed up by FromKey. Words are matched case
+// insensitively and may be separated by any whitespace.
+func ToKey(phrase string) (ed25519.PrivateKey, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != Words {
+		return nil, fmt.Errorf("%w, got %d", ErrLength, len(words))
+	}
+
+	bits := make([]byte, ed25519.SeedSize+1)
+	for i, w := range words {
+		idx, ok := wordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q (word %d)", ErrUnknownWord, w, i+1)
+		}
+		for j := 0; j < 11; j++ {
+			if idx>>(10-j)&1 == 1 {
+				b := i*11 + j
+				bits[b/8] |= 1 << (7 - b%8)
+			}
+		}
+	}
+
+	seed := bits[:ed25519.SeedSize]
+	if sum := sha256.Sum256(seed); sum[0] != bits[ed25519.SeedSize] {
+		return nil, ErrChecksum
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
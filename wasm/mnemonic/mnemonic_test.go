@@ -0,0 +1,79 @@
+package mnemonic_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/mnemonic"
+)
+
+// TestVectors checks 256-bit entropy vectors of the BIP39 reference
+// implementation.
+func TestVectors(t *testing.T) {
+	for _, v := range []struct {
+		seed   byte
+		phrase string
+	}{
+		{0x00, strings.Repeat("abandon ", 23) + "art"},
+		{0x7f, strings.Repeat("legal winner thank year wave sausage worth useful ", 2) + "legal winner thank year wave sausage worth title"},
+		{0x80, strings.Repeat("letter advice cage absurd amount doctor acoustic avoid ", 2) + "letter advice cage absurd amount doctor acoustic bless"},
+		{0xff, strings.Repeat("zoo ", 23) + "vote"},
+	} {
+		key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{v.seed}, ed25519.SeedSize))
+		phrase, err := mnemonic.FromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if phrase != v.phrase {
+			t.Errorf("seed %#x: %q, want %q", v.seed, phrase, v.phrase)
+		}
+		got, err := mnemonic.ToKey(v.phrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(key) {
+			t.Errorf("%q restored another key", v.phrase)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phrase, err := mnemonic.FromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Case and spacing do not matter.
+	got, err := mnemonic.ToKey("  " + strings.ToUpper(strings.ReplaceAll(phrase, " ", "\n\t")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(key) {
+		t.Fatal("restored another key")
+	}
+}
+
+func TestInvalidPhrases(t *testing.T) {
+	valid := strings.Repeat("abandon ", 23) + "art"
+	for name, v := range map[string]struct {
+		phrase string
+		want   error
+	}{
+		"short":    {strings.Repeat("abandon ", 23), mnemonic.ErrLength},
+		"long":     {valid + " art", mnemonic.ErrLength},
+		"unknown":  {strings.Repeat("abandon ", 23) + "reefs", mnemonic.ErrUnknownWord},
+		"checksum": {strings.Repeat("abandon ", 23) + "about", mnemonic.ErrChecksum},
+		"swapped":  {"art " + strings.Repeat("abandon ", 23), mnemonic.ErrChecksum},
+	} {
+		if _, err := mnemonic.ToKey(v.phrase); !errors.Is(err, v.want) {
+			t.Errorf("%s: %v, want %v", name, err, v.want)
+		}
+	}
+}
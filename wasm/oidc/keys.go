@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jwk"
+)
+
+// refreshInterval is the minimum time between key set fetches, so tokens
+// with unknown kids cannot make every exchange hit the provider.
+const refreshInterval = time.Minute
+
+// providerKeys caches the provider's signing keys, refetching them when a
+// token names an unknown kid.
+type providerKeys struct {
+	issuer  string
+	jwksURL string
+	http    *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func (p *providerKeys) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.fetched) < refreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if err := p.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh fetches the key set, discovering its URL from the provider
+// configuration on first use. Callers hold mu.
+func (p *providerKeys) refresh(ctx context.Context) error {
+	p.fetched = time.Now()
+	if p.jwksURL == "" {
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := p.get(ctx, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+			return fmt.Errorf("failed to discover provider configuration: %w", err)
+		}
+		if doc.Issuer != p.issuer || doc.JWKSURI == "" {
+			return fmt.Errorf("provider configuration is for issuer %q", doc.Issuer)
+		}
+		p.jwksURL = doc.JWKSURI
+	}
+
+	var raw json.RawMessage
+	if err := p.get(ctx, p.jwksURL, &raw); err != nil {
+		return fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+	set, err := jwk.ParseSet(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse provider keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set))
+	for _, k := range set {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped so one odd key does not
+		// break the whole set.
+		if pub, err := k.PublicKey(); err == nil {
+			keys[k.KID] = pub
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+func (p *providerKeys) get(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
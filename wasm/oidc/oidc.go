@@ -0,0 +1,342 @@
+// Package oidc exchanges OIDC ID tokens from the corporate identity
+// provider for short-lived referral tickets (RFC 8693 token exchange), so
+// human operators can use the mesh without holding Ed25519 keys.
+//
+// The requested colony is the exchange's resource, as a colony IRI
+// (urn:coral:reef:<reef>:colony:<colony>), and the requested intent is its
+// scope. Grants map the ID token's group claim to the reefs, colonies and
+// intents members may request, with policy pattern syntax:
+//
+//	{
+//	  "grants": [
+//	    {"group": "mesh-admins", "intents": ["*"]},
+//	    {"group": "sre", "reef": "prod", "intents": ["read-*", "revoke"]}
+//	  ]
+//	}
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/vc"
+)
+
+// RFC 8693 identifiers.
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// Defaults for Config fields left empty.
+const (
+	DefaultTTL          = 15 * time.Minute
+	DefaultGroupsClaim  = "groups"
+	DefaultSubjectClaim = "sub"
+)
+
+// idTokenMethods are the accepted ID token signing algorithms.
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// Error is an RFC 6749 error response.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// RFC 6749 and RFC 8693 error codes.
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrInvalidScope         = "invalid_scope"
+	ErrInvalidTarget        = "invalid_target"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+)
+
+func newError(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// Grant allows members of Group to request tickets for the matching
+// reefs, colonies and intents. Empty patterns match anything.
+type Grant struct {
+	Group   string   `json:"group"`
+	Reef    string   `json:"reef,omitempty"`
+	Colony  string   `json:"colony,omitempty"`
+	Intents []string `json:"intents"`
+}
+
+// ParseGrants decodes and validates a grants document.
+func ParseGrants(data []byte) ([]Grant, error) {
+	var doc struct {
+		Grants []Grant `json:"grants"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse grants: %w", err)
+	}
+	if _, err := grantPolicies(doc.Grants); err != nil {
+		return nil, err
+	}
+	return doc.Grants, nil
+}
+
+// grantPolicies builds an allow-only policy per group.
+func grantPolicies(grants []Grant) (map[string]*policy.Policy, error) {
+	policies := make(map[string]*policy.Policy)
+	for _, g := range grants {
+		if g.Group == "" {
+			return nil, errors.New("grant without group")
+		}
+		p := policies[g.Group]
+		if p == nil {
+			p = new(policy.Policy)
+			policies[g.Group] = p
+		}
+		p.Rules = append(p.Rules, policy.Rule{Effect: policy.Allow, Reef: g.Reef, Colony: g.Colony, Intents: g.Intents})
+	}
+	for group, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("grants for %s: %w", group, err)
+		}
+	}
+	return policies, nil
+}
+
+// Config configures an Exchanger.
+type Config struct {
+	// Issuer is the provider's issuer URL. Required. Its keys are found
+	// through OIDC discovery unless JWKSURL is set.
+	Issuer string
+
+	// ClientID is the audience ID tokens must be issued for. Required.
+	ClientID string
+
+	// JWKSURL overrides the discovered key set URL.
+	JWKSURL string
+
+	// GroupsClaim names the ID token claim listing the user's groups;
+	// DefaultGroupsClaim when empty.
+	GroupsClaim string
+
+	// SubjectClaim names the claim used as the ticket's agent ID;
+	// DefaultSubjectClaim when empty.
+	SubjectClaim string
+
+	// Grants authorize groups. Users in no granted group cannot exchange.
+	Grants []Grant
+
+	// Signer returns the signer for each new ticket, so a rotating key
+	// set such as jwks.(*Set).Signer is followed. Required.
+	Signer func() (signer.Signer, error)
+
+	// TTL is the lifetime of issued tickets; DefaultTTL when zero.
+	TTL time.Duration
+
+	// Leeway is the allowed clock skew on ID tokens.
+	Leeway time.Duration
+
+	// Clock is the time ID tokens are checked against and tickets are
+	// issued at. Defaults to clock.System.
+	Clock clock.Clock
+
+	// HTTPClient fetches provider keys. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Exchanger swaps ID tokens for referral tickets.
+type Exchanger struct {
+	cfg      Config
+	keys     *providerKeys
+	policies map[string]*policy.Policy
+}
+
+// New creates an Exchanger from cfg.
+func New(cfg Config) (*Exchanger, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("issuer and client ID are required")
+	}
+	if cfg.Signer == nil {
+		return nil, errors.New("signer is required")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = DefaultSubjectClaim
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Clock = clock.Or(cfg.Clock)
+	policies, err := grantPolicies(cfg.Grants)
+	if err != nil {
+		return nil, err
+	}
+	return &Exchanger{
+		cfg:      cfg,
+		keys:     &providerKeys{issuer: cfg.Issuer, jwksURL: cfg.JWKSURL, http: cfg.HTTPClient},
+		policies: policies,
+	}, nil
+}
+
+// Request is a token exchange request, with the RFC 8693 form parameter
+// names.
+type Request struct {
+	GrantType          string
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+
+	// Resource is the colony IRI of the requested ticket.
+	Resource string
+
+	// Scope is the requested intent.
+	Scope string
+}
+
+// Token is a successful exchange response.
+type Token struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	Scope           string `json:"scope"`
+}
+
+// Exchange verifies req's ID token and, if the user's groups grant the
+// requested intent on the requested colony, returns a referral ticket for
+// it. Failures are *Error values.
+func (e *Exchanger) Exchange(ctx context.Context, req Request) (*Token, error) {
+	if req.GrantType != GrantTypeTokenExchange {
+		return nil, newError(ErrUnsupportedGrantType, "grant_type must be %s", GrantTypeTokenExchange)
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType != TokenTypeIDToken {
+		return nil, newError(ErrInvalidRequest, "subject_token must be an ID token (%s)", TokenTypeIDToken)
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeJWT {
+		return nil, newError(ErrInvalidRequest, "only %s tokens can be issued", TokenTypeJWT)
+	}
+	reef, colony, err := vc.ParseColonyIRI(req.Resource)
+	if err != nil {
+		return nil, newError(ErrInvalidTarget, "resource must be a colony IRI (urn:coral:reef:<reef>:colony:<colony>)")
+	}
+	intents := strings.Fields(req.Scope)
+	if len(intents) != 1 {
+		return nil, newError(ErrInvalidScope, "scope must name exactly one intent")
+	}
+	intent := intents[0]
+
+	claims, err := e.verifyIDToken(ctx, req.SubjectToken)
+	if err != nil {
+		return nil, newError(ErrInvalidGrant, "%v", err)
+	}
+	subject, _ := claims[e.cfg.SubjectClaim].(string)
+	if subject == "" {
+		return nil, newError(ErrInvalidGrant, "ID token has no %s claim", e.cfg.SubjectClaim)
+	}
+	groups := stringList(claims[e.cfg.GroupsClaim])
+	if !e.allowed(groups, reef, colony, intent) {
+		return nil, newError(ErrInvalidScope, "groups %v are not granted %q on reef %q colony %q", groups, intent, reef, colony)
+	}
+
+	s, err := e.cfg.Signer()
+	if err != nil {
+		return nil, fmt.Errorf("no signing key: %w", err)
+	}
+	now := e.cfg.Clock.Now()
+	ticket, err := signer.SignToken(ctx, s, &jwt.ReferralClaims{
+		ReefID:   reef,
+		ColonyID: colony,
+		AgentID:  subject,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Subject:   subject,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			NotBefore: gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(e.cfg.TTL)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken:     ticket,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(e.cfg.TTL.Seconds()),
+		Scope:           intent,
+	}, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience and
+// lifetime and returns its claims.
+func (e *Exchanger) verifyIDToken(ctx context.Context, token string) (gojwt.MapClaims, error) {
+	claims := gojwt.MapClaims{}
+	_, err := gojwt.ParseWithClaims(token, claims, func(t *gojwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return e.keys.key(ctx, kid)
+	},
+		gojwt.WithValidMethods(idTokenMethods),
+		gojwt.WithIssuer(e.cfg.Issuer),
+		gojwt.WithAudience(e.cfg.ClientID),
+		gojwt.WithExpirationRequired(),
+		gojwt.WithIssuedAt(),
+		gojwt.WithLeeway(e.cfg.Leeway),
+		gojwt.WithTimeFunc(e.cfg.Clock.Now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	return claims, nil
+}
+
+// allowed reports whether any of groups is granted intent on the colony.
+func (e *Exchanger) allowed(groups []string, reef, colony, intent string) bool {
+	for _, g := range groups {
+		if p := e.policies[g]; p != nil && p.Allowed(reef, colony, intent) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList reads a claim that is a string or a list of strings.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
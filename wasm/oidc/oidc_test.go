@@ -0,0 +1,134 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+)
+
+const (
+	issuer   = "https://idp.example.com"
+	clientID = "coral"
+	resource = "urn:coral:reef:prod:colony:api"
+)
+
+// provider serves an identity provider's key set and signs its ID
+// tokens.
+type provider struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kid": "idp", "kty": "OKP", "crv": "Ed25519", "use": "sig", "x": base64.RawURLEncoding.EncodeToString(pub)},
+		}})
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// idToken signs claims with key, defaulting the issuer, audience,
+// subject, groups and lifetime to those of a valid token for an "sre".
+func (p *provider) idToken(t *testing.T, key ed25519.PrivateKey, claims gojwt.MapClaims) string {
+	t.Helper()
+	now := time.Now()
+	token := gojwt.MapClaims{"iss": issuer, "aud": clientID, "sub": "alice", "groups": []string{"sre"}, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range claims {
+		if v == nil {
+			delete(token, k)
+		} else {
+			token[k] = v
+		}
+	}
+	tok := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, token)
+	tok.Header["kid"] = "idp"
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func request(idToken string) oidc.Request {
+	return oidc.Request{GrantType: oidc.GrantTypeTokenExchange, SubjectToken: idToken, SubjectTokenType: oidc.TokenTypeIDToken, Resource: resource, Scope: "read-catalog"}
+}
+
+// TestExchange checks that ID tokens of another issuer or audience, or
+// not signed by the provider, are refused, and that a valid one is
+// exchanged for a ticket of its subject.
+func TestExchange(t *testing.T) {
+	p := newProvider(t)
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := oidc.ParseGrants([]byte(`{"grants": [{"group": "sre", "reef": "prod", "intents": ["read-*"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := oidc.New(oidc.Config{Issuer: issuer, ClientID: clientID, JWKSURL: p.URL, Grants: grants, Signer: set.Signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, forger, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		req  oidc.Request
+		code string
+	}{
+		"other issuer":     {request(p.idToken(t, p.key, gojwt.MapClaims{"iss": "https://evil.example.com"})), oidc.ErrInvalidGrant},
+		"no issuer":        {request(p.idToken(t, p.key, gojwt.MapClaims{"iss": nil})), oidc.ErrInvalidGrant},
+		"other audience":   {request(p.idToken(t, p.key, gojwt.MapClaims{"aud": "other-client"})), oidc.ErrInvalidGrant},
+		"no audience":      {request(p.idToken(t, p.key, gojwt.MapClaims{"aud": nil})), oidc.ErrInvalidGrant},
+		"expired":          {request(p.idToken(t, p.key, gojwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), oidc.ErrInvalidGrant},
+		"no expiry":        {request(p.idToken(t, p.key, gojwt.MapClaims{"exp": nil})), oidc.ErrInvalidGrant},
+		"forged":           {request(p.idToken(t, forger, nil)), oidc.ErrInvalidGrant},
+		"no subject":       {request(p.idToken(t, p.key, gojwt.MapClaims{"sub": nil})), oidc.ErrInvalidGrant},
+		"ungranted group":  {request(p.idToken(t, p.key, gojwt.MapClaims{"groups": []string{"dev"}})), oidc.ErrInvalidScope},
+		"ungranted intent": {oidc.Request{GrantType: oidc.GrantTypeTokenExchange, SubjectToken: p.idToken(t, p.key, nil), SubjectTokenType: oidc.TokenTypeIDToken, Resource: resource, Scope: "revoke"}, oidc.ErrInvalidScope},
+		"not a colony":     {oidc.Request{GrantType: oidc.GrantTypeTokenExchange, SubjectToken: p.idToken(t, p.key, nil), SubjectTokenType: oidc.TokenTypeIDToken, Resource: "https://api", Scope: "read-catalog"}, oidc.ErrInvalidTarget},
+		"other grant type": {oidc.Request{GrantType: "password"}, oidc.ErrUnsupportedGrantType},
+		"access token":     {oidc.Request{GrantType: oidc.GrantTypeTokenExchange, SubjectToken: "token", SubjectTokenType: "urn:ietf:params:oauth:token-type:access_token"}, oidc.ErrInvalidRequest},
+	} {
+		_, err := e.Exchange(context.Background(), c.req)
+		var oerr *oidc.Error
+		if !errors.As(err, &oerr) || oerr.Code != c.code {
+			t.Errorf("%s: %v, want %s", name, err, c.code)
+		}
+	}
+
+	// The audience may be a list naming the client among others.
+	token, err := e.Exchange(context.Background(), request(p.idToken(t, p.key, gojwt.MapClaims{"aud": []string{"other-client", clientID}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := set.ValidateReferralTicket(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.AgentID != "alice" || claims.ReefID != "prod" || claims.ColonyID != "api" || claims.Intent != "read-catalog" {
+		t.Fatalf("ticket for %s/%s/%s with intent %q", claims.ReefID, claims.ColonyID, claims.AgentID, claims.Intent)
+	}
+}
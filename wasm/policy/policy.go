@@ -0,0 +1,197 @@
+// Package policy authorizes verified referral tickets. A Policy is a list
+// of allow and deny rules matching a ticket's reef, colony and intent,
+// evaluated after signature verification. Deny rules win over allow rules
+// and a ticket matching no allow rule is denied.
+//
+// Patterns use path.Match syntax, so "*" matches any value and
+// "read-*" matches "read-catalog". Values are not paths: "*" and "?"
+// match "/" too. An empty pattern matches anything.
+//
+// Example policy document:
+//
+//	{
+//	  "rules": [
+//	    {"effect": "allow", "reef": "prod", "intents": ["register", "renew", "read-*"]},
+//	    {"effect": "allow", "colony": "edge-*", "intents": ["relay"]},
+//	    {"effect": "deny",  "colony": "quarantine", "intents": ["*"]}
+//	  ]
+//	}
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+)
+
+// Rule effects.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// ErrDenied is returned when a ticket is not authorized by the policy.
+var ErrDenied = errors.New("denied by policy")
+
+// Rule matches tickets by reef, colony and intent.
+type Rule struct {
+	Effect  string   `json:"effect"`
+	Reef    string   `json:"reef,omitempty"`
+	Colony  string   `json:"colony,omitempty"`
+	Intents []string `json:"intents"`
+}
+
+// Policy is an ordered set of rules. Replace swaps them while the policy
+// is in use; Rules must not be modified otherwise.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+
+	mu sync.RWMutex
+}
+
+// Verifier validates referral tickets. It matches registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Parse decodes and validates a JSON policy document.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks effects and pattern syntax.
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %d: effect must be %q or %q", i, Allow, Deny)
+		}
+		if len(r.Intents) == 0 {
+			return fmt.Errorf("rule %d: at least one intent pattern is required", i)
+		}
+		patterns := append([]string{r.Reef, r.Colony}, r.Intents...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(unslash(pattern), ""); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether a ticket for intent in reef and colony is
+// authorized.
+func (p *Policy) Allowed(reef, colony, intent string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	allowed := false
+	for _, r := range p.Rules {
+		if !r.matches(reef, colony, intent) {
+			continue
+		}
+		if r.Effect == Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// Check returns ErrDenied unless claims are authorized.
+func (p *Policy) Check(claims *jwt.ReferralClaims) error {
+	if !p.Allowed(claims.ReefID, claims.ColonyID, claims.Intent) {
+		return fmt.Errorf("%w: intent %q for reef %q colony %q", ErrDenied, claims.Intent, claims.ReefID, claims.ColonyID)
+	}
+	return nil
+}
+
+// Replace applies the rules of other, such as a reread document, in
+// place of p's.
+func (p *Policy) Replace(other *Policy) {
+	other.mu.RLock()
+	rules := other.Rules
+	other.mu.RUnlock()
+	p.mu.Lock()
+	p.Rules = rules
+	p.mu.Unlock()
+}
+
+// Verifier wraps inner so that tickets are checked against p after
+// verification.
+func (p *Policy) Verifier(inner Verifier) Verifier {
+	return &verifier{policy: p, inner: inner}
+}
+
+type verifier struct {
+	policy *Policy
+	inner  Verifier
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.policy.Check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Authenticator wraps inner so that principals, however they
+// authenticated, are checked against p. Tickets verified through a
+// policy Verifier are checked twice, to the same result.
+func (p *Policy) Authenticator(inner auth.Authenticator) auth.Authenticator {
+	return auth.AuthenticatorFunc(func(r *http.Request) (*auth.Principal, error) {
+		principal, err := inner.Authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.Check(principal.ReferralClaims); err != nil {
+			return nil, err
+		}
+		return principal, nil
+	})
+}
+
+func (r *Rule) matches(reef, colony, intent string) bool {
+	if !match(r.Reef, reef) || !match(r.Colony, colony) {
+		return false
+	}
+	for _, pattern := range r.Intents {
+		if match(pattern, intent) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether value matches pattern; empty matches anything.
+// Patterns are validated up front, so match errors cannot occur.
+func match(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(unslash(pattern), unslash(value))
+	return ok
+}
+
+// unslash replaces the slashes of s, at which path.Match would stop "*",
+// with NUL.
+func unslash(s string) string {
+	return strings.ReplaceAll(s, "/", "\x00")
+}
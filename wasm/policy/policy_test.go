@@ -0,0 +1,103 @@
+package policy_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+)
+
+const document = `{
+  "rules": [
+    {"effect": "allow", "reef": "prod", "intents": ["register", "renew", "read-*"]},
+    {"effect": "allow", "colony": "edge-*", "intents": ["relay"]},
+    {"effect": "deny", "colony": "quarantine*", "intents": ["*"]}
+  ]
+}`
+
+// TestAllowed checks rule matching, and that deny rules win.
+func TestAllowed(t *testing.T) {
+	p, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]struct {
+		reef, colony, intent string
+		want                 bool
+	}{
+		"listed intent":              {"prod", "api", "register", true},
+		"intent glob":                {"prod", "api", "read-catalog", true},
+		"unlisted intent":            {"prod", "api", "admin", false},
+		"other reef":                 {"staging", "api", "register", false},
+		"colony glob":                {"staging", "edge-eu", "relay", true},
+		"denied colony":              {"prod", "quarantine", "register", false},
+		"denied colony glob":         {"prod", "quarantine-1", "renew", false},
+		"glob across slash":          {"prod", "api", "read-catalog/items", true},
+		"deny glob across slash":     {"prod", "quarantine/1", "register", false},
+		"deny intent across slash":   {"prod", "quarantine", "read-a/b", false},
+		"colony glob across slash":   {"staging", "edge-eu/1", "relay", true},
+		"literal does not glob":      {"prod", "api", "register/extra", false},
+		"question mark across slash": {"prod", "api", "read-/", true},
+	} {
+		if got := p.Allowed(c.reef, c.colony, c.intent); got != c.want {
+			t.Errorf("%s: Allowed = %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+// TestParse checks that malformed rules are refused.
+func TestParse(t *testing.T) {
+	for name, doc := range map[string]string{
+		"unknown effect":  `{"rules": [{"effect": "permit", "intents": ["*"]}]}`,
+		"no intents":      `{"rules": [{"effect": "allow", "reef": "prod"}]}`,
+		"invalid pattern": `{"rules": [{"effect": "allow", "colony": "[", "intents": ["*"]}]}`,
+		"malformed":       `{"rules": {}}`,
+	} {
+		if _, err := policy.Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+// tickets verifies every ticket as the claims it names.
+type tickets map[string]*jwt.ReferralClaims
+
+func (v tickets) ValidateReferralTicket(token string) (*jwt.ReferralClaims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, errors.New("unknown ticket")
+	}
+	return claims, nil
+}
+
+// TestVerifier checks that tickets are checked against the policy after
+// verification, and against the rules that replaced it.
+func TestVerifier(t *testing.T) {
+	p, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := p.Verifier(tickets{
+		"register": {ReefID: "prod", ColonyID: "api", Intent: "register"},
+		"admin":    {ReefID: "prod", ColonyID: "api", Intent: "admin"},
+	})
+	if _, err := v.ValidateReferralTicket("register"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.ValidateReferralTicket("admin"); !errors.Is(err, policy.ErrDenied) {
+		t.Fatalf("unlisted intent: %v, want ErrDenied", err)
+	}
+	if _, err := v.ValidateReferralTicket("forged"); err == nil || errors.Is(err, policy.ErrDenied) {
+		t.Fatalf("unverified ticket: %v, want the verifier's error", err)
+	}
+
+	p.Replace(&policy.Policy{Rules: []policy.Rule{{Effect: policy.Allow, Intents: []string{"admin"}}}})
+	if _, err := v.ValidateReferralTicket("register"); !errors.Is(err, policy.ErrDenied) {
+		t.Fatalf("intent of the replaced rules: %v, want ErrDenied", err)
+	}
+	if _, err := v.ValidateReferralTicket("admin"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"sync"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+)
+
+// activePolicy is the module-level intent policy. While nil, every
+// verified ticket is authorized.
+var activePolicy struct {
+	mu     sync.RWMutex
+	policy *policy.Policy
+}
+
+// checkPolicy applies the loaded policy to verified claims.
+func checkPolicy(claims *jwt.ReferralClaims) *exportError {
+	activePolicy.mu.RLock()
+	p := activePolicy.policy
+	activePolicy.mu.RUnlock()
+
+	if p == nil {
+		return nil
+	}
+	if err := p.Check(claims); err != nil {
+		return newError(errPolicyDenied, err.Error())
+	}
+	return nil
+}
+
+// loadPolicy replaces the intent policy consulted after signature
+// verification. Pass null to remove it.
+// Arguments: policyJSON | null
+// Returns: { rules: number } or { error: { code, message, retryable } }
+func loadPolicy(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: policyJSON")
+	}
+
+	var p *policy.Policy
+	if !args[0].IsNull() && !args[0].IsUndefined() {
+		var err error
+		if p, err = policy.Parse([]byte(args[0].String())); err != nil {
+			return errorResult(errInvalidArgument, err.Error())
+		}
+	}
+
+	activePolicy.mu.Lock()
+	activePolicy.policy = p
+	activePolicy.mu.Unlock()
+
+	rules := 0
+	if p != nil {
+		rules = len(p.Rules)
+	}
+	return map[string]interface{}{
+		"rules": rules,
+	}
+}
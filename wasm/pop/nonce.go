@@ -0,0 +1,121 @@
+package pop
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultNonceTTL is how long an issued nonce is accepted when
+// Config.NonceTTL is zero.
+const DefaultNonceTTL = 5 * time.Minute
+
+// ErrNonce is returned for unknown or expired nonces. Servers answer it
+// with a fresh nonce so the client can retry.
+var ErrNonce = errors.New("invalid or expired nonce")
+
+// Config holds the configuration for a Checker.
+type Config struct {
+	// Secret authenticates issued nonces. Instances sharing a secret
+	// accept each other's nonces. Defaults to a random secret.
+	Secret []byte
+
+	// NonceTTL bounds how long a nonce is accepted. Defaults to
+	// DefaultNonceTTL.
+	NonceTTL time.Duration
+
+	// MaxAge bounds the age of a proof. Defaults to DefaultMaxAge.
+	MaxAge time.Duration
+
+	// Required rejects tickets that are not bound to a key. When false
+	// only bound tickets need a proof.
+	Required bool
+}
+
+// Checker issues nonces and checks proofs presented with tickets.
+// Nonces are stateless: an HMAC over their issue time.
+type Checker struct {
+	secret   []byte
+	nonceTTL time.Duration
+	maxAge   time.Duration
+	required bool
+}
+
+// New creates a Checker from cfg.
+func New(cfg Config) (*Checker, error) {
+	c := &Checker{
+		secret:   cfg.Secret,
+		nonceTTL: cfg.NonceTTL,
+		maxAge:   cfg.MaxAge,
+		required: cfg.Required,
+	}
+	if len(c.secret) == 0 {
+		c.secret = make([]byte, 32)
+		if _, err := rand.Read(c.secret); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce secret: %w", err)
+		}
+	}
+	if c.nonceTTL <= 0 {
+		c.nonceTTL = DefaultNonceTTL
+	}
+	if c.maxAge <= 0 {
+		c.maxAge = DefaultMaxAge
+	}
+	return c, nil
+}
+
+// Nonce issues a fresh nonce.
+func (c *Checker) Nonce() string {
+	var buf [8 + sha256.Size]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().UnixMilli()))
+	copy(buf[8:], c.mac(buf[:8]))
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// checkNonce validates a nonce issued by Nonce.
+func (c *Checker) checkNonce(nonce string) error {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 8+sha256.Size || !hmac.Equal(buf[8:], c.mac(buf[:8])) {
+		return ErrNonce
+	}
+	issued := time.UnixMilli(int64(binary.BigEndian.Uint64(buf[:8])))
+	if time.Since(issued) > c.nonceTTL {
+		return ErrNonce
+	}
+	return nil
+}
+
+func (c *Checker) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Check verifies the proof presented with an already verified ticket.
+// Unbound tickets pass without a proof unless binding is required.
+func (c *Checker) Check(ticket, proof string) error {
+	jkt, err := Confirmed(ticket)
+	if err != nil {
+		return err
+	}
+	if jkt == "" && !c.required {
+		return nil
+	}
+	if jkt == "" {
+		return fmt.Errorf("%w: ticket is not bound to a key", ErrProofRequired)
+	}
+	if proof == "" {
+		return ErrProofRequired
+	}
+
+	p, err := VerifyProof(ticket, proof, c.maxAge)
+	if err != nil {
+		return err
+	}
+	return c.checkNonce(p.Nonce)
+}
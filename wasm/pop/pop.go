@@ -0,0 +1,210 @@
+// Package pop binds referral tickets to a holder key (DPoP-style proof of
+// possession). A bound ticket carries the RFC 7638 thumbprint of the
+// holder's Ed25519 public key in its cnf claim; presenting it additionally
+// requires a proof: a short-lived JWT signed by that key over a nonce
+// issued by the server and the hash of the ticket.
+package pop
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// ProofType is the typ header of proof JWTs.
+const ProofType = "coral-pop+jwt"
+
+// Proof-of-possession HTTP headers. Clients send the proof in ProofHeader;
+// servers return a fresh nonce in NonceHeader with every proof failure.
+// gRPC uses the lower-cased names as metadata keys.
+const (
+	ProofHeader = "Coral-PoP"
+	NonceHeader = "Coral-PoP-Nonce"
+)
+
+// DefaultMaxAge is how old a proof's iat may be when Config.MaxAge is zero.
+const DefaultMaxAge = time.Minute
+
+var (
+	// ErrProofRequired is returned when a bound ticket, or any ticket when
+	// binding is required, is presented without a proof.
+	ErrProofRequired = errors.New("proof of possession required")
+
+	// ErrUnbound is returned when a proof is checked against a ticket
+	// without a cnf claim.
+	ErrUnbound = errors.New("ticket is not bound to a key")
+
+	// ErrInvalidProof is returned when a proof is malformed, badly signed,
+	// stale or made for a different ticket or key.
+	ErrInvalidProof = errors.New("invalid proof of possession")
+)
+
+// Confirmation is the cnf claim of a bound ticket.
+type Confirmation struct {
+	// JKT is the base64url SHA-256 JWK thumbprint of the holder key.
+	JKT string `json:"jkt"`
+}
+
+// Claims are referral claims extended with a key confirmation.
+type Claims struct {
+	jwt.ReferralClaims
+
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of an Ed25519 key.
+func Thumbprint(key ed25519.PublicKey) string {
+	// Members in lexicographic order, without whitespace, per RFC 7638.
+	canonical := `{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(key) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Bind sets claims' confirmation to holder.
+func Bind(claims *Claims, holder ed25519.PublicKey) {
+	claims.Confirmation = &Confirmation{JKT: Thumbprint(holder)}
+}
+
+// Sign signs claims with key under kid.
+func Sign(key ed25519.PrivateKey, kid string, claims *Claims) (string, error) {
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// proofClaims are the claims of a proof JWT.
+type proofClaims struct {
+	Nonce       string `json:"nonce"`
+	TicketHash  string `json:"ath"`
+	IssuedAtSec int64  `json:"iat"`
+}
+
+// proofJWK is the public key embedded in a proof header.
+type proofJWK struct {
+	KTY string `json:"kty"`
+	CRV string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// NewProof signs a proof for presenting ticket with the given nonce.
+func NewProof(holder ed25519.PrivateKey, ticket, nonce string) (string, error) {
+	pub := holder.Public().(ed25519.PublicKey)
+	token := gojwt.New(gojwt.SigningMethodEdDSA)
+	token.Header["typ"] = ProofType
+	token.Header["jwk"] = proofJWK{KTY: "OKP", CRV: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}
+	token.Claims = gojwt.MapClaims{
+		"nonce": nonce,
+		"ath":   ticketHash(ticket),
+		"iat":   time.Now().Unix(),
+	}
+	signed, err := token.SignedString(holder)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign proof: %w", err)
+	}
+	return signed, nil
+}
+
+// Proof is a verified proof of possession.
+type Proof struct {
+	Nonce      string
+	Thumbprint string
+	IssuedAt   time.Time
+}
+
+// VerifyProof checks that proof was signed by the key ticket is bound to,
+// for ticket, no more than maxAge ago. The ticket signature is not
+// checked; verify the ticket first. The caller must validate the nonce.
+func VerifyProof(ticket, proof string, maxAge time.Duration) (*Proof, error) {
+	jkt, err := Confirmed(ticket)
+	if err != nil {
+		return nil, err
+	}
+	if jkt == "" {
+		return nil, ErrUnbound
+	}
+
+	var key ed25519.PublicKey
+	var claims proofClaims
+	_, err = gojwt.NewParser(gojwt.WithValidMethods([]string{gojwt.SigningMethodEdDSA.Alg()})).
+		ParseWithClaims(proof, &mapClaims{&claims}, func(t *gojwt.Token) (interface{}, error) {
+			if t.Header["typ"] != ProofType {
+				return nil, errors.New("unexpected typ")
+			}
+			raw, err := json.Marshal(t.Header["jwk"])
+			if err != nil {
+				return nil, err
+			}
+			var jwk proofJWK
+			if err := json.Unmarshal(raw, &jwk); err != nil || jwk.KTY != "OKP" || jwk.CRV != "Ed25519" {
+				return nil, errors.New("unsupported jwk")
+			}
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, errors.New("malformed jwk")
+			}
+			key = ed25519.PublicKey(x)
+			return key, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	if Thumbprint(key) != jkt {
+		return nil, fmt.Errorf("%w: key does not match ticket cnf", ErrInvalidProof)
+	}
+	if claims.TicketHash != ticketHash(ticket) {
+		return nil, fmt.Errorf("%w: proof is for a different ticket", ErrInvalidProof)
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	issuedAt := time.Unix(claims.IssuedAtSec, 0)
+	if age := time.Since(issuedAt); age > maxAge || age < -maxAge {
+		return nil, fmt.Errorf("%w: proof issued %s ago", ErrInvalidProof, age.Round(time.Second))
+	}
+
+	return &Proof{Nonce: claims.Nonce, Thumbprint: jkt, IssuedAt: issuedAt}, nil
+}
+
+// Confirmed returns the cnf thumbprint of ticket, or "" when the ticket is
+// not bound. The ticket signature is not checked.
+func Confirmed(ticket string) (string, error) {
+	var claims Claims
+	if _, _, err := gojwt.NewParser().ParseUnverified(ticket, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse ticket: %w", err)
+	}
+	if claims.Confirmation == nil {
+		return "", nil
+	}
+	return claims.Confirmation.JKT, nil
+}
+
+// ticketHash is the base64url SHA-256 of a ticket, carried as ath.
+func ticketHash(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// mapClaims adapts proofClaims to gojwt.Claims. Time validation is done
+// by VerifyProof against maxAge rather than by the parser.
+type mapClaims struct {
+	*proofClaims
+}
+
+func (mapClaims) GetExpirationTime() (*gojwt.NumericDate, error) { return nil, nil }
+func (mapClaims) GetIssuedAt() (*gojwt.NumericDate, error)       { return nil, nil }
+func (mapClaims) GetNotBefore() (*gojwt.NumericDate, error)      { return nil, nil }
+func (mapClaims) GetIssuer() (string, error)                     { return "", nil }
+func (mapClaims) GetSubject() (string, error)                    { return "", nil }
+func (mapClaims) GetAudience() (gojwt.ClaimStrings, error)       { return nil, nil }
@@ -0,0 +1,119 @@
+package pop_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// ticket returns a ticket for agent bound to holder, or unbound when
+// holder is nil.
+func ticket(t *testing.T, agent string, holder ed25519.PrivateKey) string {
+	t.Helper()
+	claims := &pop.Claims{ReferralClaims: jwt.ReferralClaims{
+		ReefID: "reef", ColonyID: "colony", AgentID: agent, Intent: "register",
+		RegisteredClaims: gojwt.RegisteredClaims{ID: agent, ExpiresAt: gojwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}}
+	if holder != nil {
+		pop.Bind(claims, holder.Public().(ed25519.PublicKey))
+	}
+	signed, err := pop.Sign(newKey(t), "k1", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func proof(t *testing.T, holder ed25519.PrivateKey, ticket, nonce string) string {
+	t.Helper()
+	p, err := pop.NewProof(holder, ticket, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestThumbprint checks the RFC 8037 appendix A.3 example.
+func TestThumbprint(t *testing.T) {
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pop.Thumbprint(x), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Fatalf("thumbprint %s, want %s", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c, err := pop.New(pop.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := newKey(t)
+	bound := ticket(t, "agent", holder)
+	if jkt, err := pop.Confirmed(bound); err != nil || jkt != pop.Thumbprint(holder.Public().(ed25519.PublicKey)) {
+		t.Fatalf("confirmed key %q (%v)", jkt, err)
+	}
+	if err := c.Check(bound, proof(t, holder, bound, c.Nonce())); err != nil {
+		t.Fatal(err)
+	}
+
+	other := ticket(t, "other", holder)
+	stranger, err := pop.New(pop.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]struct {
+		proof string
+		want  error
+	}{
+		"no proof":        {"", pop.ErrProofRequired},
+		"other key":       {proof(t, newKey(t), bound, c.Nonce()), pop.ErrInvalidProof},
+		"other ticket":    {proof(t, holder, other, c.Nonce()), pop.ErrInvalidProof},
+		"foreign nonce":   {proof(t, holder, bound, stranger.Nonce()), pop.ErrNonce},
+		"malformed nonce": {proof(t, holder, bound, "nonce"), pop.ErrNonce},
+		"not a jwt":       {"proof", pop.ErrInvalidProof},
+	} {
+		if err := c.Check(bound, v.proof); !errors.Is(err, v.want) {
+			t.Errorf("%s: %v, want %v", name, err, v.want)
+		}
+	}
+}
+
+func TestUnbound(t *testing.T) {
+	unbound := ticket(t, "agent", nil)
+	optional, err := pop.New(pop.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := optional.Check(unbound, ""); err != nil {
+		t.Fatalf("unbound ticket, binding optional: %v", err)
+	}
+	required, err := pop.New(pop.Config{Required: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := required.Check(unbound, ""); !errors.Is(err, pop.ErrProofRequired) {
+		t.Fatalf("unbound ticket, binding required: %v, want ErrProofRequired", err)
+	}
+	if _, err := pop.VerifyProof(unbound, proof(t, newKey(t), unbound, optional.Nonce()), 0); !errors.Is(err, pop.ErrUnbound) {
+		t.Fatalf("proof for an unbound ticket: %v, want ErrUnbound", err)
+	}
+}
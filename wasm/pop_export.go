@@ -0,0 +1,101 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"github.com/coral-mesh/coral-crypto/keys"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+)
+
+// createProof signs a proof of possession for presenting a bound ticket.
+// Arguments: holderPrivateKeyB64, tokenString, nonce
+// Returns: { proof } or { error: { code, message, retryable } }
+func createProof(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: holderPrivateKeyB64, tokenString, nonce")
+	}
+
+	holder, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode holder key: "+err.Error())
+	}
+	proof, err := pop.NewProof(holder, args[1].String(), args[2].String())
+	if err != nil {
+		return errorResult(errTokenSign, err.Error())
+	}
+	return map[string]interface{}{"proof": proof}
+}
+
+// verifyProof checks a proof presented with a bound ticket against the
+// nonce the Worker issued. The ticket must already be verified.
+// Arguments: tokenString, proof, nonce, [maxAgeSeconds]
+// Returns: { valid: true, thumbprint } or { error: { code, message, retryable } }
+func verifyProof(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected at least 3 arguments: tokenString, proof, nonce, [maxAgeSeconds]")
+	}
+
+	var maxAge time.Duration
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		maxAge = time.Duration(args[3].Int()) * time.Second
+	}
+	p, err := pop.VerifyProof(args[0].String(), args[1].String(), maxAge)
+	switch {
+	case errors.Is(err, pop.ErrUnbound), errors.Is(err, pop.ErrInvalidProof):
+		return errorResult(errProof, err.Error())
+	case err != nil:
+		return errorResult(errTokenMalformed, err.Error())
+	}
+	if p.Nonce != args[2].String() {
+		return errorResult(errProof, pop.ErrNonce.Error())
+	}
+
+	return map[string]interface{}{
+		"valid":      true,
+		"thumbprint": p.Thumbprint,
+	}
+}
+
+// createBoundTicket mints a ticket bound to the holder key holderB64.
+func createBoundTicket(key ed25519.PrivateKey, keyID, reefID, colonyID, agentID, intent string, ttlSeconds int, holderB64 string) interface{} {
+	holder, err := keys.DecodePublicKey(holderB64)
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode holder key: "+err.Error())
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(ttlSeconds) * time.Second)
+	claims := &pop.Claims{ReferralClaims: jwt.ReferralClaims{
+		ReefID:   reefID,
+		ColonyID: colonyID,
+		AgentID:  agentID,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			NotBefore: gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expiresAt),
+		},
+	}}
+	pop.Bind(claims, holder)
+
+	token, err := pop.Sign(key, keyID, claims)
+	if err != nil {
+		return errorResult(errTokenSign, err.Error())
+	}
+	return map[string]interface{}{
+		"jwt":       token,
+		"expiresAt": expiresAt.Unix(),
+	}
+}
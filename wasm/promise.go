@@ -0,0 +1,74 @@
+//go:build tinygo.wasm || js
+
+package main
+
+import "syscall/js"
+
+// exportFunc is the signature shared by every coralCrypto export.
+type exportFunc func(this js.Value, args []js.Value) interface{}
+
+// promisify wraps an export so that it returns a Promise instead of
+// blocking the caller. The export runs on its own goroutine; a result
+// carrying an "error" key rejects the Promise with a JS Error that has
+// code and retryable properties, and any other result resolves it. A
+// panic rejects the Promise too (see call) instead of exiting the Go
+// program, which would fail every later call.
+func promisify(fn exportFunc) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		// Copy arguments, the slice is not guaranteed to outlive the call.
+		callArgs := make([]js.Value, len(args))
+		copy(callArgs, args)
+
+		executor := js.FuncOf(func(_ js.Value, promiseArgs []js.Value) interface{} {
+			resolve, reject := promiseArgs[0], promiseArgs[1]
+
+			go func() {
+				result := call(fn, this, callArgs)
+				if m, ok := result.(map[string]interface{}); ok {
+					if e, ok := m["error"].(map[string]interface{}); ok {
+						reject.Invoke(jsError(e))
+						return
+					}
+				}
+				resolve.Invoke(result)
+			}()
+
+			return nil
+		})
+		// The executor runs synchronously inside the Promise constructor.
+		defer executor.Release()
+
+		return js.Global().Get("Promise").New(executor)
+	})
+}
+
+// call runs fn, turning a panic into an error result. A js.ValueError,
+// raised when an argument is read as the wrong type, is an invalid
+// argument; other panics are internal errors.
+func call(fn exportFunc, this js.Value, args []js.Value) (result interface{}) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		code, message := errInternal, "panic"
+		switch r := r.(type) {
+		case *js.ValueError:
+			code, message = errInvalidArgument, r.Error()
+		case error:
+			message = r.Error()
+		case string:
+			message = r
+		}
+		result = errorResult(code, message)
+	}()
+	return fn(this, args)
+}
+
+// jsError converts a { code, message, retryable } map into a JS Error.
+func jsError(e map[string]interface{}) js.Value {
+	err := js.Global().Get("Error").New(e["message"])
+	err.Set("code", e["code"])
+	err.Set("retryable", e["retryable"])
+	return err
+}
@@ -0,0 +1,341 @@
+// Package ratelimit throttles discovery requests with token buckets keyed
+// by agent, colony, reef and source IP, with separate budgets for registry
+// writes, lookups and ticket verification, and boxes source IPs that keep
+// presenting bad tickets. State is kept in memory, so each corald
+// process or Worker isolate enforces its budgets on its own.
+//
+// Methods on a nil *Limiter allow everything, so components can hold a
+// limiter from an optional Config without checking for one.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Class is a kind of request with its own budget.
+type Class string
+
+// Request classes.
+const (
+	// Register covers registrations, renewals and deregistrations.
+	Register Class = "register"
+	// Lookup covers agent lookups, colony listings and watches.
+	Lookup Class = "lookup"
+	// Verify covers every request presenting a ticket, charged before
+	// its signature is checked.
+	Verify Class = "verify"
+)
+
+// ErrLimited is returned when a budget is exhausted or the source is in
+// the penalty box.
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Error reports which budget refused a request and when to retry.
+type Error struct {
+	Class      Class
+	Key        string // such as "agent a1" or "ip 192.0.2.1"
+	RetryAfter time.Duration
+
+	// Penalized is set when the key is in the penalty box rather than
+	// out of budget.
+	Penalized bool
+}
+
+func (e *Error) Error() string {
+	if e.Penalized {
+		return fmt.Sprintf("%v: %s is in the penalty box for rejected tickets, retry in %s", ErrLimited, e.Key, e.RetryAfter.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%v: %s budget of %s exhausted, retry in %s", ErrLimited, e.Class, e.Key, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *Error) Unwrap() error { return ErrLimited }
+
+// Limit is a token bucket: Rate requests per second on average, in bursts
+// of up to Burst. A zero Rate does not limit.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst,omitempty"` // defaults to Rate, at least 1
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Budget limits one class of requests per agent, per colony, per reef
+// and per source IP. Agents and colonies are told apart by reef, so that
+// reefs sharing a deployment do not share their buckets.
+type Budget struct {
+	Agent  Limit `json:"agent"`
+	Colony Limit `json:"colony"`
+	Reef   Limit `json:"reef"`
+	IP     Limit `json:"ip"`
+}
+
+// Penalty boxes a source IP for BoxSeconds once it presented Failures
+// rejected tickets within WindowSeconds. Zero Failures disables it.
+type Penalty struct {
+	Failures      int `json:"failures"`
+	WindowSeconds int `json:"window_seconds"`
+	BoxSeconds    int `json:"box_seconds"`
+}
+
+// Config holds the budgets of a Limiter. Its JSON form, read by
+// ParseConfig, is
+//
+//	{"register": {"agent": {"rate": 1, "burst": 5}, "ip": {"rate": 20}},
+//	 "lookup":   {"agent": {"rate": 50}, "colony": {"rate": 500}},
+//	 "verify":   {"ip": {"rate": 100, "burst": 200}},
+//	 "penalty":  {"failures": 10, "window_seconds": 60, "box_seconds": 900},
+//	 "reefs":    {"acme": {"register": {"rate": 50}, "lookup": {"rate": 2000}}}}
+type Config struct {
+	Register Budget  `json:"register"`
+	Lookup   Budget  `json:"lookup"`
+	Verify   Budget  `json:"verify"`
+	Penalty  Penalty `json:"penalty"`
+
+	// Reefs replaces the reef limits of the register and lookup budgets
+	// for the reefs it names, such as tenants on different plans.
+	Reefs map[string]ReefLimits `json:"reefs,omitempty"`
+}
+
+// ReefLimits are the reef limits of one reef.
+type ReefLimits struct {
+	Register Limit `json:"register"`
+	Lookup   Limit `json:"lookup"`
+}
+
+// ParseConfig parses the JSON form of a Config.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("ratelimit: failed to parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Key is the identity a request is charged to. Empty fields are not
+// charged.
+type Key struct {
+	AgentID  string
+	ColonyID string
+	ReefID   string
+	IP       string
+}
+
+// Limiter enforces a Config. It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	budgets map[Class]Budget
+	reefs   map[string]ReefLimits
+	penalty Penalty
+	buckets map[string]*bucket
+	strikes map[string]*strikes
+	boxed   map[string]time.Time // source IP -> end of its penalty
+}
+
+// New creates a Limiter from cfg.
+func New(cfg Config) (*Limiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{
+		budgets: cfg.budgets(),
+		reefs:   cfg.Reefs,
+		penalty: cfg.Penalty,
+		buckets: map[string]*bucket{},
+		strikes: map[string]*strikes{},
+		boxed:   map[string]time.Time{},
+	}, nil
+}
+
+// Update replaces the budgets and penalty with cfg's, so that limits
+// change without dropping the connections a restart would. Buckets start
+// over, full, under the new limits; IPs in the penalty box stay there.
+func (l *Limiter) Update(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.budgets, l.reefs, l.penalty = cfg.budgets(), cfg.Reefs, cfg.Penalty
+	l.buckets = map[string]*bucket{}
+	return nil
+}
+
+func (cfg Config) validate() error {
+	for class, b := range cfg.budgets() {
+		for _, l := range []Limit{b.Agent, b.Colony, b.Reef, b.IP} {
+			if l.Rate < 0 || l.Burst < 0 {
+				return fmt.Errorf("ratelimit: %s limits must not be negative", class)
+			}
+		}
+	}
+	for reef, r := range cfg.Reefs {
+		for _, l := range []Limit{r.Register, r.Lookup} {
+			if l.Rate < 0 || l.Burst < 0 {
+				return fmt.Errorf("ratelimit: limits of reef %s must not be negative", reef)
+			}
+		}
+	}
+	p := cfg.Penalty
+	if p.Failures < 0 || (p.Failures > 0 && (p.WindowSeconds <= 0 || p.BoxSeconds <= 0)) {
+		return errors.New("ratelimit: penalty needs positive failures, window_seconds and box_seconds")
+	}
+	return nil
+}
+
+func (cfg Config) budgets() map[Class]Budget {
+	return map[Class]Budget{Register: cfg.Register, Lookup: cfg.Lookup, Verify: cfg.Verify}
+}
+
+// Allow charges one request of class to key. It returns an *Error
+// wrapping ErrLimited, without charging anything, when any of key's
+// budgets is exhausted or its IP is in the penalty box.
+func (l *Limiter) Allow(class Class, key Key) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until, ok := l.boxed[key.IP]; ok && key.IP != "" {
+		if now.Before(until) {
+			return &Error{Class: class, Key: "ip " + key.IP, RetryAfter: until.Sub(now), Penalized: true}
+		}
+		delete(l.boxed, key.IP)
+	}
+
+	b := l.budgets[class]
+	if r, ok := l.reefs[key.ReefID]; ok && key.ReefID != "" {
+		switch class {
+		case Register:
+			b.Reef = r.Register
+		case Lookup:
+			b.Reef = r.Lookup
+		}
+	}
+	charges := []struct {
+		kind, value string
+		limit       Limit
+	}{
+		{"agent", qualify(key.ReefID, key.AgentID), b.Agent},
+		{"colony", qualify(key.ReefID, key.ColonyID), b.Colony},
+		{"reef", key.ReefID, b.Reef},
+		{"ip", key.IP, b.IP},
+	}
+	var take []*bucket
+	for _, c := range charges {
+		if c.value == "" || c.limit.Rate == 0 {
+			continue
+		}
+		id := string(class) + "\xff" + c.kind + "\xff" + c.value
+		bk, ok := l.buckets[id]
+		if !ok {
+			bk = &bucket{limit: c.limit, tokens: c.limit.burst(), last: now}
+			l.buckets[id] = bk
+		}
+		if wait := bk.refill(now); wait > 0 {
+			return &Error{Class: class, Key: c.kind + " " + c.value, RetryAfter: wait}
+		}
+		take = append(take, bk)
+	}
+	for _, bk := range take {
+		bk.tokens--
+	}
+	return nil
+}
+
+// qualify prefixes id, an agent or colony ID, with its reef when known.
+func qualify(reefID, id string) string {
+	if reefID == "" || id == "" {
+		return id
+	}
+	return reefID + "/" + id
+}
+
+// Fail records a rejected ticket from ip, boxing ip once it reaches the
+// penalty's failures within its window.
+func (l *Limiter) Fail(ip string) {
+	if l == nil || ip == "" {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.penalty.Failures == 0 {
+		return
+	}
+	window := time.Duration(l.penalty.WindowSeconds) * time.Second
+
+	s, ok := l.strikes[ip]
+	if !ok || now.Sub(s.since) > window {
+		s = &strikes{since: now}
+		l.strikes[ip] = s
+	}
+	s.count++
+	if s.count >= l.penalty.Failures {
+		l.boxed[ip] = now.Add(time.Duration(l.penalty.BoxSeconds) * time.Second)
+		delete(l.strikes, ip)
+	}
+}
+
+// Prune drops buckets that have refilled, lapsed penalties and failures
+// outside the window, bounding memory to the sources seen lately.
+func (l *Limiter) Prune() {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window := time.Duration(l.penalty.WindowSeconds) * time.Second
+
+	for id, bk := range l.buckets {
+		bk.refill(now)
+		if bk.tokens >= bk.limit.burst() {
+			delete(l.buckets, id)
+		}
+	}
+	for ip, s := range l.strikes {
+		if now.Sub(s.since) > window {
+			delete(l.strikes, ip)
+		}
+	}
+	for ip, until := range l.boxed {
+		if !now.Before(until) {
+			delete(l.boxed, ip)
+		}
+	}
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (l *Limiter) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Prune()
+		}
+	}
+}
+
+// strikes counts the rejected tickets of a source IP since the start of
+// its window.
+type strikes struct {
+	count int
+	since time.Time
+}
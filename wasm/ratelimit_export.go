@@ -0,0 +1,104 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+)
+
+// rateLimitPruneEvery is how often rateLimit drops idle buckets; Workers
+// have no background goroutines to do it.
+const rateLimitPruneEvery = time.Minute
+
+// limiter throttles requests; nil until configureRateLimit.
+var limiter atomic.Pointer[ratelimit.Limiter]
+
+// lastRateLimitPrune is when rateLimit last pruned, in Unix nanoseconds.
+var lastRateLimitPrune atomic.Int64
+
+// configureRateLimit installs the budgets of configJSON, in the format of
+// ratelimit.ParseConfig, for rateLimit and for the registrations and
+// renewals of registries opened afterwards. Budgets are kept per isolate.
+// A null config removes the limits.
+// Arguments: configJSON
+// Returns: { enabled: boolean }
+func configureRateLimit(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: configJSON")
+	}
+	if args[0].IsNull() || args[0].IsUndefined() {
+		limiter.Store(nil)
+		return map[string]interface{}{"enabled": false}
+	}
+	cfg, err := ratelimit.ParseConfig([]byte(args[0].String()))
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	l, err := ratelimit.New(cfg)
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	limiter.Store(l)
+	return map[string]interface{}{"enabled": true}
+}
+
+// rateLimit charges a request of class ("register", "lookup" or
+// "verify") to the source IP, typically CF-Connecting-IP, and to the
+// agent, colony and reef when given; pass those only once the ticket
+// naming them is verified. Without configureRateLimit every request is
+// allowed.
+// Arguments: class, ip, [agentID], [colonyID], [reefID]
+// Returns: { allowed: boolean, retryAfterMs?: number }
+func rateLimit(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: class, ip")
+	}
+	class := ratelimit.Class(args[0].String())
+	switch class {
+	case ratelimit.Register, ratelimit.Lookup, ratelimit.Verify:
+	default:
+		return errorResult(errInvalidArgument, "unknown class "+string(class))
+	}
+	key := ratelimit.Key{IP: args[1].String()}
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		key.AgentID = args[2].String()
+	}
+	if len(args) > 3 && args[3].Type() == js.TypeString {
+		key.ColonyID = args[3].String()
+	}
+	if len(args) > 4 && args[4].Type() == js.TypeString {
+		key.ReefID = args[4].String()
+	}
+
+	l := limiter.Load()
+	if last := lastRateLimitPrune.Load(); time.Since(time.Unix(0, last)) > rateLimitPruneEvery &&
+		lastRateLimitPrune.CompareAndSwap(last, time.Now().UnixNano()) {
+		l.Prune()
+	}
+	if err := l.Allow(class, key); err != nil {
+		var limited *ratelimit.Error
+		retryAfter := time.Duration(0)
+		if errors.As(err, &limited) {
+			retryAfter = limited.RetryAfter
+		}
+		return map[string]interface{}{"allowed": false, "retryAfterMs": retryAfter.Milliseconds()}
+	}
+	return map[string]interface{}{"allowed": true}
+}
+
+// rateLimitFailure counts a rejected ticket from ip towards the penalty
+// box. Call it when verification fails.
+// Arguments: ip
+// Returns: {}
+func rateLimitFailure(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: ip")
+	}
+	limiter.Load().Fail(args[0].String())
+	return map[string]interface{}{}
+}
@@ -0,0 +1,96 @@
+package rbac_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+)
+
+// TestDefaultPolicy checks the permissions of the built-in roles.
+func TestDefaultPolicy(t *testing.T) {
+	p := rbac.DefaultPolicy()
+	for name, c := range map[string]struct {
+		roles []string
+		perm  rbac.Permission
+		want  bool
+	}{
+		"admin rotates keys":            {[]string{rbac.RoleAdmin}, rbac.PermRotateKeys, true},
+		"operator evicts":               {[]string{rbac.RoleOperator}, rbac.PermEvictAgents, true},
+		"operator rotates keys":         {[]string{rbac.RoleOperator}, rbac.PermRotateKeys, false},
+		"auditor lists":                 {[]string{rbac.RoleAuditor}, rbac.PermListAgents, true},
+		"auditor evicts":                {[]string{rbac.RoleAuditor}, rbac.PermEvictAgents, false},
+		"auditor and operator evict":    {[]string{rbac.RoleAuditor, rbac.RoleOperator}, rbac.PermEvictAgents, true},
+		"unknown role":                  {[]string{"root"}, rbac.PermListAgents, false},
+		"no roles":                      {nil, rbac.PermListAgents, false},
+		"role names are case-sensitive": {[]string{"Admin"}, rbac.PermListAgents, false},
+	} {
+		if got := p.Allows(c.roles, c.perm); got != c.want {
+			t.Errorf("%s: Allows = %v, want %v", name, got, c.want)
+		}
+		if got := (*rbac.Policy)(nil).Allows(c.roles, c.perm); got != c.want {
+			t.Errorf("%s: nil policy Allows = %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+// TestParsePolicy checks that a policy document grants exactly what it
+// lists, and that Replace swaps the grants of a policy in use.
+func TestParsePolicy(t *testing.T) {
+	p, err := rbac.ParsePolicy([]byte(`{"roles": {"reader": ["agents:list"], "root": ["*"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Allows([]string{"reader"}, rbac.PermListAgents) || p.Allows([]string{"reader"}, rbac.PermEvictAgents) {
+		t.Fatal("reader is not granted exactly agents:list")
+	}
+	if !p.Allows([]string{"root"}, rbac.PermRotateKeys) {
+		t.Fatal(`"*" does not grant keys:rotate`)
+	}
+	if p.Allows([]string{rbac.RoleAdmin}, rbac.PermListAgents) {
+		t.Fatal("a parsed policy grants the built-in roles")
+	}
+
+	for name, doc := range map[string]string{
+		"unknown permission": `{"roles": {"reader": ["agents:read"]}}`,
+		"malformed":          `{"roles": ["reader"]}`,
+	} {
+		if _, err := rbac.ParsePolicy([]byte(doc)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+
+	p.Replace(rbac.DefaultPolicy())
+	if p.Allows([]string{"root"}, rbac.PermRotateKeys) || !p.Allows([]string{rbac.RoleAdmin}, rbac.PermRotateKeys) {
+		t.Fatal("Replace kept the grants of the old document")
+	}
+}
+
+// TestAuthorize checks that a ticket is authorized by the roles it
+// carries.
+func TestAuthorize(t *testing.T) {
+	sign := func(roles ...string) string {
+		claims := rbac.Claims{ReferralClaims: jwt.ReferralClaims{ReefID: "reef", AgentID: "operator", Intent: rbac.IntentAdmin}, Roles: roles}
+		token, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, claims).SignedString([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	p := rbac.DefaultPolicy()
+	if err := p.Authorize(sign(rbac.RoleOperator), rbac.PermEvictAgents); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize(sign(rbac.RoleOperator), rbac.PermRotateKeys); !errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("operator rotating keys: %v, want ErrForbidden", err)
+	}
+	if err := p.Authorize(sign(), rbac.PermListAgents); !errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("ticket without roles: %v, want ErrForbidden", err)
+	}
+	if err := p.Authorize("not a ticket", rbac.PermListAgents); err == nil || errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("malformed ticket: %v, want a parse error", err)
+	}
+}
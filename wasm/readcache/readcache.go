@@ -0,0 +1,213 @@
+// Package readcache coalesces and caches hot registry reads in the
+// server. Concurrent identical reads share a single registry read
+// (singleflight), and results are kept for a short TTL. How fresh a
+// result must be is chosen per read with a Consistency, so callers that
+// must see their own writes can bypass both.
+//
+// Read with a nil *Cache always loads, so the server can hold a cache
+// from an optional Config without checking for one.
+package readcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries bounds the cache when Config.MaxEntries is unset.
+const DefaultMaxEntries = 10000
+
+// Consistency is how fresh a read's result must be.
+type Consistency int
+
+// Consistency levels, weakest last.
+const (
+	// Default uses the cache's configured consistency.
+	Default Consistency = iota
+	// Strong always reads the registry.
+	Strong
+	// Shared joins a read of the same key already in flight, so the
+	// result may predate the request by that read's latency.
+	Shared
+	// Bounded also serves results cached up to the TTL ago.
+	Bounded
+)
+
+// ErrInvalidConsistency is returned by ParseConsistency for unknown
+// levels.
+var ErrInvalidConsistency = errors.New("invalid consistency")
+
+// ParseConsistency parses "strong", "shared" or "bounded"; the empty
+// string is Default.
+func ParseConsistency(s string) (Consistency, error) {
+	switch s {
+	case "":
+		return Default, nil
+	case "strong":
+		return Strong, nil
+	case "shared":
+		return Shared, nil
+	case "bounded":
+		return Bounded, nil
+	}
+	return Default, fmt.Errorf("%w: %q (want strong, shared or bounded)", ErrInvalidConsistency, s)
+}
+
+func (c Consistency) String() string {
+	switch c {
+	case Strong:
+		return "strong"
+	case Shared:
+		return "shared"
+	case Bounded:
+		return "bounded"
+	}
+	return ""
+}
+
+// Config configures a Cache.
+type Config struct {
+	// TTL is how long results are served to Bounded reads. Zero keeps
+	// nothing, so Bounded reads behave as Shared.
+	TTL time.Duration
+
+	// Consistency applies to reads asking for Default. Defaults to
+	// Bounded.
+	Consistency Consistency
+
+	// MaxEntries bounds the number of cached results. Defaults to
+	// DefaultMaxEntries.
+	MaxEntries int
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// Cache coalesces and caches reads by key. It is safe for concurrent use.
+type Cache struct {
+	ttl         time.Duration
+	consistency Consistency
+	maxEntries  int
+	clock       func() time.Time
+
+	mu      sync.Mutex
+	calls   map[string]*call
+	entries map[string]entry
+}
+
+// call is a read in flight.
+type call struct {
+	done chan struct{}
+	val  any
+	err  error
+}
+
+// entry is a cached result.
+type entry struct {
+	val any
+	at  time.Time
+}
+
+// New creates a Cache.
+func New(cfg Config) *Cache {
+	c := &Cache{
+		ttl:         cfg.TTL,
+		consistency: cfg.Consistency,
+		maxEntries:  cfg.MaxEntries,
+		clock:       cfg.Clock,
+		calls:       make(map[string]*call),
+		entries:     make(map[string]entry),
+	}
+	if c.consistency == Default {
+		c.consistency = Bounded
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = DefaultMaxEntries
+	}
+	if c.clock == nil {
+		c.clock = time.Now
+	}
+	return c
+}
+
+// Read returns the result of load for key at the given consistency,
+// along with its age: zero unless it was served from the cache. Results
+// are shared between callers and must not be modified. Errors are
+// neither cached nor shared beyond the reads in flight.
+func Read[T any](ctx context.Context, c *Cache, level Consistency, key string, load func(context.Context) (T, error)) (T, time.Duration, error) {
+	if c == nil {
+		v, err := load(ctx)
+		return v, 0, err
+	}
+	if level == Default {
+		level = c.consistency
+	}
+	if level == Strong {
+		v, err := load(ctx)
+		return v, 0, err
+	}
+
+	c.mu.Lock()
+	now := c.clock()
+	if e, ok := c.entries[key]; ok && level == Bounded {
+		if age := now.Sub(e.at); age < c.ttl {
+			c.mu.Unlock()
+			return e.val.(T), age, nil
+		}
+		delete(c.entries, key)
+	}
+	cl, ok := c.calls[key]
+	if !ok {
+		cl = &call{done: make(chan struct{})}
+		c.calls[key] = cl
+		go c.run(context.WithoutCancel(ctx), key, cl, func(ctx context.Context) (any, error) {
+			return load(ctx)
+		})
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, 0, ctx.Err()
+	case <-cl.done:
+	}
+	if cl.err != nil {
+		var zero T
+		return zero, 0, cl.err
+	}
+	return cl.val.(T), 0, nil
+}
+
+// run performs the read of cl, so that it completes for the readers
+// still waiting when the one that started it gives up.
+func (c *Cache) run(ctx context.Context, key string, cl *call, load func(context.Context) (any, error)) {
+	cl.val, cl.err = load(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, key)
+	close(cl.done)
+	if cl.err != nil || c.ttl <= 0 {
+		return
+	}
+	now := c.clock()
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = entry{val: cl.val, at: now}
+}
+
+// evict drops expired entries, and all of them when that frees nothing.
+func (c *Cache) evict(now time.Time) {
+	for key, e := range c.entries {
+		if now.Sub(e.at) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		clear(c.entries)
+	}
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// The methods in this file serve the admin API. They take no ticket:
+// callers authorize the operator first (see package rbac).
+
+// Records returns every stored record of reefID, or of its colonyID when
+// not empty, including expired leases still within the grace period.
+func (r *Registry) Records(ctx context.Context, reefID, colonyID string) ([]*Record, error) {
+	return r.listRecords(ctx, reefID, colonyID)
+}
+
+// Evict removes agentID of reefID at once, as Deregister does. It
+// returns the removed record.
+func (r *Registry) Evict(ctx context.Context, reefID, agentID string) (*Record, error) {
+	rec, _, err := r.loadRecord(ctx, reefID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.remove(ctx, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ExpireLease ends the lease of agentID of reefID now. Lookups stop
+// returning the agent, which must renew within the grace period or be
+// evicted by the reaper.
+// Watchers see an EventExpire.
+func (r *Registry) ExpireLease(ctx context.Context, reefID, agentID string) (*Record, error) {
+	for attempt := 0; ; attempt++ {
+		rec, revision, err := r.loadRecord(ctx, reefID, agentID)
+		if err != nil {
+			return nil, err
+		}
+		now := r.now()
+		if rec.Expired(now) {
+			return rec, nil
+		}
+		rec.UpdatedAt = now
+		rec.ExpiresAt = now
+		rec.Seq++
+		rec.Version = r.clock.Now()
+		err = r.saveRecord(ctx, rec, revision)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.hub.publish(Event{Type: EventExpire, Record: rec})
+		return rec.Clone(), nil
+	}
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// allowlistPrefix is where published endpoint allowlists live, under
+// allowlists/<reef>/<colony>.
+const allowlistPrefix = "allowlists/"
+
+func allowlistKey(reefID, colonyID string) string {
+	return allowlistPrefix + colonyKey(reefID, colonyID)
+}
+
+// PublishAllowlist verifies token, a signed allowlist of colonyID in
+// reefID (see package allowlist), with Config.AllowlistKeys and makes it
+// the colony's allowlist. Registrations of the colony's agents are
+// checked against it from then on. Lists issued before the published one
+// are rejected with allowlist.ErrInvalid, so an old, wider list cannot be
+// replayed.
+func (r *Registry) PublishAllowlist(ctx context.Context, reefID, colonyID, token string) (*allowlist.Published, error) {
+	if r.allowlistKeys == nil {
+		return nil, fmt.Errorf("%w: no allowlist keys are configured", allowlist.ErrInvalid)
+	}
+	claims, err := allowlist.Parse(r.allowlistKeys, token, r.now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.ReefID != reefID || claims.ColonyID != colonyID {
+		return nil, fmt.Errorf("%w: list is for colony %s of reef %s", allowlist.ErrInvalid, claims.ColonyID, claims.ReefID)
+	}
+	published := &allowlist.Published{Token: token, Claims: claims}
+	data, err := json.Marshal(published)
+	if err != nil {
+		return nil, err
+	}
+	key := allowlistKey(reefID, colonyID)
+	for attempt := 0; ; attempt++ {
+		current, revision, err := r.loadAllowlist(ctx, reefID, colonyID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if current != nil && claims.IssuedAt.Before(current.Claims.IssuedAt.Time) {
+			return nil, fmt.Errorf("%w: list was issued before the published one", allowlist.ErrInvalid)
+		}
+		_, err = r.store.CompareAndSwap(ctx, key, revision, data)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store allowlist: %w", err)
+		}
+		return published, nil
+	}
+}
+
+// Allowlist returns the allowlist published for colonyID in reefID, or
+// ErrNotFound.
+func (r *Registry) Allowlist(ctx context.Context, reefID, colonyID string) (*allowlist.Published, error) {
+	published, _, err := r.loadAllowlist(ctx, reefID, colonyID)
+	return published, err
+}
+
+// loadAllowlist reads the allowlist of colonyID in reefID and its store
+// revision.
+func (r *Registry) loadAllowlist(ctx context.Context, reefID, colonyID string) (*allowlist.Published, uint64, error) {
+	entry, err := r.store.Get(ctx, allowlistKey(reefID, colonyID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: colony %s has no allowlist", ErrNotFound, colonyID)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	var published allowlist.Published
+	if err := json.Unmarshal(entry.Value, &published); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode allowlist: %w", err)
+	}
+	if published.Claims == nil {
+		return nil, 0, errors.New("failed to decode allowlist: no claims")
+	}
+	return &published, entry.Revision, nil
+}
+
+// checkEndpoints returns allowlist.ErrNotAllowed unless the allowlist of
+// rec's colony allows its endpoints. Colonies without one allow any
+// endpoint, unless Config.RequireAllowlist is set.
+func (r *Registry) checkEndpoints(ctx context.Context, rec *Record) error {
+	published, err := r.Allowlist(ctx, rec.ReefID, rec.ColonyID)
+	if errors.Is(err, ErrNotFound) {
+		if r.requireAllowlist {
+			return fmt.Errorf("%w: colony %s has no allowlist", allowlist.ErrNotAllowed, rec.ColonyID)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if exp := published.Claims.ExpiresAt; exp != nil && r.now().After(exp.Time) {
+		return fmt.Errorf("%w: the allowlist of colony %s has expired", allowlist.ErrNotAllowed, rec.ColonyID)
+	}
+	return published.Claims.Check(rec.AgentID, rec.Endpoints)
+}
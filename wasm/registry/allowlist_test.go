@@ -0,0 +1,110 @@
+package registry_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+func registerAt(reg *registry.Registry, agentID, endpoint string) error {
+	_, err := reg.Register(as(agentID, registry.IntentRegister), "", &registry.Record{
+		AgentID: agentID, ColonyID: "colony", ReefID: "reef", Endpoints: []string{endpoint},
+	})
+	return err
+}
+
+func TestAllowlist(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := verify.ParseKeys(fmt.Appendf(nil, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","use":"sig","x":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(pub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := signer.Local("k1", priv)
+	now := clock.NewFake(time.Now())
+	reg, err := registry.New(registry.Config{Store: store.NewMemory(), Verifier: noTickets{}, AllowlistKeys: keys, Clock: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Colonies without an allowlist accept any endpoint.
+	if err := registerAt(reg, "one", "192.0.2.1:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := allowlist.List{
+		ReefID: "reef", ColonyID: "colony",
+		CIDRs:   []string{"10.0.0.0/8"},
+		Domains: []string{"*.example.com"},
+		Pins:    map[string][]string{"gateway": {"203.0.113.7:443"}},
+	}
+	token, err := allowlist.Sign(ctx, s, list, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.PublishAllowlist(ctx, "reef", "other", token); !errors.Is(err, allowlist.ErrInvalid) {
+		t.Fatalf("list of another colony: %v, want ErrInvalid", err)
+	}
+	if _, err := reg.PublishAllowlist(ctx, "reef", "colony", token); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		agent, endpoint string
+		allowed         bool
+	}{
+		{"one", "192.0.2.1:9000", false},
+		{"one", "10.1.2.3:9000", true},
+		{"two", "[::ffff:10.0.0.1]:9000", true},
+		{"three", "node.example.com:443", true},
+		{"three", "example.com:443", false},
+		{"three", "node.example.com.evil.net:443", false},
+		{"gateway", "10.0.0.1:443", false},
+		{"gateway", "203.0.113.7:443", true},
+	} {
+		err := registerAt(reg, tc.agent, tc.endpoint)
+		if tc.allowed && err != nil {
+			t.Errorf("%s at %s: %v", tc.agent, tc.endpoint, err)
+		}
+		if !tc.allowed && !errors.Is(err, allowlist.ErrNotAllowed) {
+			t.Errorf("%s at %s: %v, want ErrNotAllowed", tc.agent, tc.endpoint, err)
+		}
+	}
+
+	// A list issued before the published one cannot replace it.
+	older, err := signer.SignToken(ctx, s, &allowlist.Claims{
+		List: allowlist.List{ReefID: "reef", ColonyID: "colony", CIDRs: []string{"0.0.0.0/0"}},
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Audience: gojwt.ClaimStrings{allowlist.Audience},
+			IssuedAt: gojwt.NewNumericDate(now.Now().Add(-time.Hour)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.PublishAllowlist(ctx, "reef", "colony", older); !errors.Is(err, allowlist.ErrInvalid) {
+		t.Fatalf("older list: %v, want ErrInvalid", err)
+	}
+
+	now.Advance(2 * time.Hour)
+	if err := registerAt(reg, "one", "10.1.2.3:9000"); !errors.Is(err, allowlist.ErrNotAllowed) {
+		t.Fatalf("registration under an expired list: %v, want ErrNotAllowed", err)
+	}
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// deltaMargin is how far Delta.Through and the times watch cursors carry
+// lag the listing or event they follow. A write stamped before them but
+// stored after, as writes of other instances sharing the store or of
+// lagging replicas may be, is then listed again by the next delta rather
+// than missed.
+const deltaMargin = 5 * time.Second
+
+// Delta holds the changes to the records matching a Filter since a time,
+// for holders of an earlier listing or watch to catch up with: the
+// records written since and the tombstones of the agents removed since.
+// Leases the reaper evicted leave no tombstone; their records had expired
+// already, which holders can tell from ExpiresAt.
+type Delta struct {
+	// Records are the records written since, expired leases included.
+	Records []*Record `json:"records"`
+
+	// Tombstones are those of the agents deregistered or evicted since
+	// and not registered again.
+	Tombstones []*Tombstone `json:"tombstones"`
+
+	// Through is the time to ask for the next delta since.
+	Through Timestamp `json:"through"`
+}
+
+// Delta returns the changes to the records matching filter made after
+// since, in every reef when filter.ReefID is empty. A zero since returns
+// every record. Tombstones are kept for Config.TombstoneTTL, so a since
+// older than that is refused with ErrCursorExpired: the holder must list
+// the registry again.
+func (r *Registry) Delta(ctx context.Context, filter Filter, since Timestamp) (*Delta, error) {
+	now := r.now()
+	if !since.IsZero() && since.Wall < now.Add(-r.tombstoneTTL).UnixNano() {
+		return nil, fmt.Errorf("%w: the tombstones since %v are no longer kept", ErrCursorExpired, time.Unix(0, since.Wall).UTC())
+	}
+	d := &Delta{Records: []*Record{}, Tombstones: []*Tombstone{}, Through: Timestamp{Wall: now.Add(-deltaMargin).UnixNano()}}
+
+	prefix, tombPrefix := agentPrefix, tombstonePrefix
+	if filter.ReefID != "" {
+		prefix, tombPrefix = reefPrefix(filter.ReefID), tombstonePrefix+filter.ReefID+"/"
+	}
+	recs, err := r.scanRecords(ctx, prefix, filter.ColonyID)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]Timestamp, len(recs))
+	for _, rec := range recs {
+		stored[rec.ReefID+"/"+rec.AgentID] = rec.Version
+		if rec.Version.Compare(since) > 0 && filter.Matches(rec) {
+			d.Records = append(d.Records, rec)
+		}
+	}
+
+	entries, err := r.store.List(ctx, tombPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	for _, entry := range entries {
+		var tomb Tombstone
+		if err := json.Unmarshal(entry.Value, &tomb); err != nil {
+			return nil, fmt.Errorf("failed to decode tombstone: %w", err)
+		}
+		if tomb.Version.Compare(since) <= 0 || filter.ColonyID != "" && tomb.ColonyID != filter.ColonyID {
+			continue
+		}
+		if v, ok := stored[tomb.ReefID+"/"+tomb.AgentID]; ok && v.Compare(tomb.Version) > 0 {
+			continue
+		}
+		d.Tombstones = append(d.Tombstones, &tomb)
+	}
+	return d, nil
+}
+
+// events returns d as watch events carrying cursor: a put for each live
+// record, an expire for each expired one and a delete, with a record
+// holding the agent's identity, for each tombstone.
+func (d *Delta) events(now time.Time, cursor string) []Event {
+	out := make([]Event, 0, len(d.Records)+len(d.Tombstones))
+	for _, rec := range d.Records {
+		typ := EventPut
+		if rec.Expired(now) {
+			typ = EventExpire
+		}
+		out = append(out, Event{Type: typ, Record: rec, Cursor: cursor})
+	}
+	for _, tomb := range d.Tombstones {
+		rec := &Record{AgentID: tomb.AgentID, ColonyID: tomb.ColonyID, ReefID: tomb.ReefID, Version: tomb.Version}
+		out = append(out, Event{Type: EventDelete, Record: rec, Cursor: cursor})
+	}
+	return out
+}
+
+// replay subscribes a watcher whose cursor's changes are no longer
+// journaled, as after a restart or when resuming with another instance
+// sharing the store. The changes since the time the cursor carries are
+// read from the stored records and tombstones (see Delta) and delivered
+// first, carrying the cursor, then those journaled since. It returns err,
+// the journal's refusal, for cursors carrying no time.
+func (r *Registry) replay(ctx context.Context, filter Filter, cursor string, err error) (<-chan Event, error) {
+	since, ok := cursorSince(cursor)
+	if !ok {
+		return nil, err
+	}
+	mark := r.hub.position()
+	d, err := r.Delta(ctx, filter, since)
+	if err != nil {
+		return nil, err
+	}
+	return r.hub.subscribe(ctx, filter, mark, d.events(r.now(), cursor))
+}
@@ -0,0 +1,91 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+func TestDelta(t *testing.T) {
+	reg, _, now := newRegistry(t)
+	ctx := context.Background()
+	register(t, reg, "a", 60)
+	register(t, reg, "b", 60)
+	now.Advance(10 * time.Second)
+
+	all, err := reg.Delta(ctx, registry.Filter{}, registry.Timestamp{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Records) != 2 || len(all.Tombstones) != 0 {
+		t.Fatalf("full delta: %d records, %d tombstones, want 2 and 0", len(all.Records), len(all.Tombstones))
+	}
+
+	if err := reg.Deregister(as("b", registry.IntentRegister), "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := reg.Delta(ctx, registry.Filter{}, all.Through)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Records) != 0 || len(d.Tombstones) != 1 || d.Tombstones[0].AgentID != "b" {
+		t.Fatalf("delta after deregistration: %+v, want the tombstone of b alone", d)
+	}
+	if d, err := reg.Delta(ctx, registry.Filter{ColonyID: "other"}, all.Through); err != nil || len(d.Tombstones) != 0 {
+		t.Fatalf("delta of another colony: %+v, %v, want no tombstones", d, err)
+	}
+
+	// A registration supersedes the tombstone.
+	register(t, reg, "b", 60)
+	d, err = reg.Delta(ctx, registry.Filter{}, all.Through)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Records) != 1 || d.Records[0].AgentID != "b" || len(d.Tombstones) != 0 {
+		t.Fatalf("delta after registering again: %+v, want the record of b alone", d)
+	}
+
+	now.Advance(registry.DefaultTombstoneTTL)
+	if _, err := reg.Delta(ctx, registry.Filter{}, all.Through); !errors.Is(err, registry.ErrCursorExpired) {
+		t.Fatalf("delta past the tombstone retention: %v, want ErrCursorExpired", err)
+	}
+}
+
+func TestWatchReplay(t *testing.T) {
+	reg, st, now := newRegistry(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := reg.WatchFrom(ctx, registry.Filter{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	register(t, reg, "agent", 60)
+	ev := <-events
+	now.Advance(10 * time.Second)
+	if err := reg.Deregister(as("agent", registry.IntentRegister), "", "agent"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance sharing the store, which never journaled the
+	// deletion, replays it from the tombstone.
+	other, err := registry.New(registry.Config{Store: st, Verifier: noTickets{}, Clock: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := other.WatchFrom(ctx, registry.Filter{}, ev.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-replayed:
+		if ev.Type != registry.EventDelete || ev.Record.AgentID != "agent" {
+			t.Fatalf("replayed %s of %s, want the delete of agent", ev.Type, ev.Record.AgentID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deletion not replayed")
+	}
+}
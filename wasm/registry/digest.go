@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// digestFalsePositives is the false positive rate Digest filters are
+// sized for.
+const digestFalsePositives = 0.01
+
+// Digest summarizes the membership of a colony, so that clients holding
+// a listing can tell without downloading it again whether it is still
+// current (Matches) and, if not, which of their records changed (Changed).
+// Renewals and heartbeats do not change a digest; see Fingerprint.
+type Digest struct {
+	ColonyID string `json:"colony_id"`
+
+	// Count is the number of live records.
+	Count int `json:"count"`
+
+	// Hash is the hex SHA-256 of the records' agent IDs and
+	// fingerprints, in agent ID order.
+	Hash string `json:"hash"`
+
+	// Filter is a Bloom filter holding each record's agent ID and
+	// fingerprint.
+	Filter Bloom `json:"filter"`
+}
+
+// Bloom is a Bloom filter of K hash functions over the bits of Bits,
+// derived by double hashing the SHA-256 of each item.
+type Bloom struct {
+	Bits []byte `json:"bits"`
+	K    int    `json:"k"`
+}
+
+// Fingerprint is the hex SHA-256 of the parts of r that describe the
+// agent, leaving out those that renewals and heartbeats change: the
+// lease times, load, RTTs and version.
+func (r *Record) Fingerprint() string {
+	c := r.Clone()
+	c.UpdatedAt, c.ExpiresAt = time.Time{}, time.Time{}
+	c.Load, c.RTT, c.Version = nil, nil, Timestamp{}
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Digest returns the digest of the live records of colonyID in reefID.
+func (r *Registry) Digest(ctx context.Context, reefID, colonyID string) (*Digest, error) {
+	recs, err := r.List(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+	return NewDigest(colonyID, recs), nil
+}
+
+// NewDigest returns the digest of recs, the records of colonyID.
+func NewDigest(colonyID string, recs []*Record) *Digest {
+	items := digestItems(recs)
+	h := sha256.New()
+	for _, item := range items {
+		h.Write(item)
+		h.Write([]byte{'\n'})
+	}
+
+	// Size the filter for the false positive rate: m = -n ln p / (ln 2)²
+	// bits and k = m/n ln 2 hash functions.
+	n := max(len(items), 1)
+	m := int(math.Ceil(-float64(n) * math.Log(digestFalsePositives) / (math.Ln2 * math.Ln2)))
+	bloom := Bloom{Bits: make([]byte, (m+7)/8), K: max(1, int(math.Round(float64(m)/float64(n)*math.Ln2)))}
+	for _, item := range items {
+		bloom.add(item)
+	}
+	return &Digest{ColonyID: colonyID, Count: len(items), Hash: hex.EncodeToString(h.Sum(nil)), Filter: bloom}
+}
+
+// Matches reports whether recs, a listing of the digest's colony, is
+// the one the digest was made from.
+func (d *Digest) Matches(recs []*Record) bool {
+	return len(recs) == d.Count && NewDigest(d.ColonyID, recs).Hash == d.Hash
+}
+
+// Changed returns the agent IDs of those of recs, a listing of the
+// digest's colony, that have since changed or left it. A small fraction
+// of changed records may be missed (see digestFalsePositives), and
+// agents that joined are not reported; compare Count and Hash to find
+// out whether any did.
+func (d *Digest) Changed(recs []*Record) []string {
+	var changed []string
+	for _, rec := range recs {
+		if !d.Filter.has(digestItem(rec)) {
+			changed = append(changed, rec.AgentID)
+		}
+	}
+	return changed
+}
+
+// digestItems returns the digest items of recs in agent ID order.
+func digestItems(recs []*Record) [][]byte {
+	sorted := slices.Clone(recs)
+	slices.SortFunc(sorted, func(a, b *Record) int {
+		return strings.Compare(a.AgentID, b.AgentID)
+	})
+	items := make([][]byte, len(sorted))
+	for i, rec := range sorted {
+		items[i] = digestItem(rec)
+	}
+	return items
+}
+
+// digestItem identifies the content of rec in a digest.
+func digestItem(rec *Record) []byte {
+	return []byte(rec.AgentID + "\x00" + rec.Fingerprint())
+}
+
+// positions returns the bits of item, by double hashing its SHA-256.
+func (b *Bloom) positions(item []byte) []uint64 {
+	sum := sha256.Sum256(item)
+	h1, h2 := binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16])
+	m := uint64(len(b.Bits)) * 8
+	out := make([]uint64, b.K)
+	for i := range out {
+		out[i] = (h1 + uint64(i)*h2) % m
+	}
+	return out
+}
+
+func (b *Bloom) add(item []byte) {
+	for _, p := range b.positions(item) {
+		b.Bits[p/8] |= 1 << (p % 8)
+	}
+}
+
+// has reports whether item may be in the filter; false means it is not.
+func (b *Bloom) has(item []byte) bool {
+	if len(b.Bits) == 0 || b.K <= 0 {
+		return false
+	}
+	for _, p := range b.positions(item) {
+		if b.Bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
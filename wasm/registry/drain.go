@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrDraining is returned for registrations once the registry drains
+// before shutting down. Agents should register with another instance.
+var ErrDraining = errors.New("registry is draining")
+
+// Drain prepares the registry for shutdown. Registrations are refused
+// with ErrDraining, and every watch ends with an EventDrain, as do
+// watches started later, so that watchers move to another instance.
+// Renewals, lookups and deregistrations are still served, so that the
+// leases handed off with Snapshot, or replicated to peers, are current.
+func (r *Registry) Drain() {
+	r.draining.Store(true)
+	r.hub.drain()
+}
+
+// Draining reports whether Drain was called.
+func (r *Registry) Draining() bool {
+	return r.draining.Load()
+}
+
+// Resume restores a snapshot a draining registry took, like Restore, and
+// credits each lease that was live then with the time since, up to its
+// TTL: its agent could not renew while no instance served it, and should
+// not lose its lease for it. It returns the number of records restored.
+func (r *Registry) Resume(ctx context.Context, rd io.Reader) (int, error) {
+	takenAt, recs, tombs, err := readSnapshot(rd)
+	if err != nil {
+		return 0, err
+	}
+	down := r.now().Sub(takenAt)
+	for _, rec := range recs {
+		if r.evictable(rec, takenAt) {
+			continue
+		}
+		rec.ExpiresAt = rec.ExpiresAt.Add(min(down, time.Duration(rec.TTLSeconds)*time.Second))
+	}
+	if err := r.restore(ctx, "", recs, tombs); err != nil {
+		return 0, err
+	}
+	return len(recs), nil
+}
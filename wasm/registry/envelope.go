@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+)
+
+// envelopeContext prefixes signed record payloads so their signatures
+// cannot be replayed as other Ed25519 messages, such as DHT records.
+const envelopeContext = "coral-registry-record-v1\n"
+
+// Envelope is an agent's signature over its own record. The registry
+// stores it with the record and returns it with lookups, so peers can
+// check the record's endpoints and capabilities against a key they know
+// for the agent, such as the key of its DID document, instead of trusting
+// the registry. The signature covers Payload as sent.
+type Envelope struct {
+	// Payload is the JSON encoding of the SignedFields.
+	Payload json.RawMessage `json:"payload"`
+
+	// PublicKey is the agent's key, encoded with keys.EncodePublicKey.
+	PublicKey string `json:"public_key"`
+
+	// Signature is the base64 Ed25519 signature of envelopeContext and
+	// Payload.
+	Signature []byte `json:"signature"`
+}
+
+// SignedFields are the fields of a record its agent signs: those the
+// agent sets and peers act on. The registry sets the rest.
+type SignedFields struct {
+	AgentID      string   `json:"agent_id"`
+	ColonyID     string   `json:"colony_id"`
+	ReefID       string   `json:"reef_id"`
+	Endpoints    []string `json:"endpoints"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Publishes    []string `json:"publishes,omitempty"`
+	Subscribes   []string `json:"subscribes,omitempty"`
+
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// Seq orders the agent's envelopes. The registry only accepts one
+	// above the stored record's, so an old envelope cannot be replayed.
+	Seq uint64 `json:"seq"`
+}
+
+// signedFields returns the fields of r an envelope covers.
+func (r *Record) signedFields(seq uint64) *SignedFields {
+	return &SignedFields{
+		AgentID:      r.AgentID,
+		ColonyID:     r.ColonyID,
+		ReefID:       r.ReefID,
+		Endpoints:    r.Endpoints,
+		Capabilities: r.Capabilities,
+		Publishes:    r.Publishes,
+		Subscribes:   r.Subscribes,
+		Metadata:     r.Metadata,
+		Seq:          seq,
+	}
+}
+
+// Sign signs the record's SignedFields with key, the agent's key, at
+// seq, and sets its Envelope. Sign again after changing those fields.
+func (r *Record) Sign(key ed25519.PrivateKey, seq uint64) error {
+	data, err := json.Marshal(r.signedFields(seq))
+	if err != nil {
+		return err
+	}
+	r.Envelope = &Envelope{
+		Payload:   data,
+		PublicKey: keys.EncodePublicKey(key.Public().(ed25519.PublicKey)),
+		Signature: ed25519.Sign(key, append([]byte(envelopeContext), data...)),
+	}
+	return nil
+}
+
+// VerifyEnvelope checks that the record's Envelope is a valid signature
+// over its SignedFields and returns the signing key and the envelope's
+// seq. Whether the key is the agent's is up to the caller.
+func (r *Record) VerifyEnvelope() (ed25519.PublicKey, uint64, error) {
+	e := r.Envelope
+	if e == nil {
+		return nil, 0, errors.New("record is not signed")
+	}
+	pub, err := keys.DecodePublicKey(e.PublicKey)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envelope: %v", err)
+	}
+	if !ed25519.Verify(pub, append([]byte(envelopeContext), e.Payload...), e.Signature) {
+		return nil, 0, errors.New("envelope: bad signature")
+	}
+	var signed SignedFields
+	if err := json.Unmarshal(e.Payload, &signed); err != nil {
+		return nil, 0, fmt.Errorf("envelope: malformed payload: %v", err)
+	}
+	if !signed.covers(r) {
+		return nil, 0, errors.New("envelope does not match the record")
+	}
+	return pub, signed.Seq, nil
+}
+
+// covers reports whether f holds the signed fields of r.
+func (f *SignedFields) covers(r *Record) bool {
+	return f.AgentID == r.AgentID && f.ColonyID == r.ColonyID && f.ReefID == r.ReefID &&
+		slices.Equal(f.Endpoints, r.Endpoints) && slices.Equal(f.Capabilities, r.Capabilities) &&
+		slices.Equal(f.Publishes, r.Publishes) && slices.Equal(f.Subscribes, r.Subscribes) &&
+		sameJSON(f.Metadata, r.Metadata)
+}
+
+// sameJSON reports whether a and b encode the same JSON text, ignoring
+// insignificant white space: records are compacted when stored.
+func sameJSON(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// clone returns a deep copy of e.
+func (e *Envelope) clone() *Envelope {
+	if e == nil {
+		return nil
+	}
+	return &Envelope{
+		Payload:   slices.Clone(e.Payload),
+		PublicKey: e.PublicKey,
+		Signature: slices.Clone(e.Signature),
+	}
+}
+
+// seq returns the seq of e, an envelope verified already.
+func (e *Envelope) seq() uint64 {
+	var signed SignedFields
+	_ = json.Unmarshal(e.Payload, &signed)
+	return signed.Seq
+}
+
+// checkEnvelope verifies the envelope of rec, registered by p. Tickets
+// bound to a key (see package pop) must come with records signed by that
+// key. Unsigned records are refused when Config.RequireSignedRecords is
+// set.
+func (r *Registry) checkEnvelope(p *auth.Principal, rec *Record) error {
+	if rec.Envelope == nil {
+		if r.requireSigned {
+			return fmt.Errorf("%w: record must be signed by its agent", ErrInvalidRecord)
+		}
+		return nil
+	}
+	pub, _, err := rec.VerifyEnvelope()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	if p.Ticket == "" {
+		return nil
+	}
+	jkt, err := pop.Confirmed(p.Ticket)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if jkt != "" && jkt != pop.Thumbprint(pub) {
+		return fmt.Errorf("%w: record is not signed with the key its ticket is bound to", ErrUnauthorized)
+	}
+	return nil
+}
+
+// checkSuccession checks that rec may replace existing, the stored record
+// of its agent. Once an agent has registered a signed record, its later
+// records must be signed with the same key at a higher seq, until the
+// record is gone. Resending the registered envelope, as retries do, is
+// allowed.
+func checkSuccession(existing, rec *Record) error {
+	if existing == nil || existing.Envelope == nil {
+		return nil
+	}
+	if rec.Envelope == nil {
+		return fmt.Errorf("%w: agent %s is registered with a signed record, which an unsigned one cannot replace", ErrUnauthorized, rec.AgentID)
+	}
+	if rec.Envelope.PublicKey != existing.Envelope.PublicKey {
+		return fmt.Errorf("%w: record is signed with another key than the registered one", ErrUnauthorized)
+	}
+	if bytes.Equal(rec.Envelope.Payload, existing.Envelope.Payload) {
+		return nil
+	}
+	if seq, prev := rec.Envelope.seq(), existing.Envelope.seq(); seq <= prev {
+		return fmt.Errorf("%w: envelope seq %d is not above the registered %d", ErrInvalidRecord, seq, prev)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package registry_test
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+func signedRecord(t *testing.T, key ed25519.PrivateKey, endpoint string, seq uint64) *registry.Record {
+	t.Helper()
+	rec := &registry.Record{AgentID: "agent", ColonyID: "colony", ReefID: "reef", Endpoints: []string{endpoint}}
+	if err := rec.Sign(key, seq); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestSignedRecords(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _, _ := newRegistry(t)
+	ctx := as("agent", registry.IntentRegister)
+
+	rec := signedRecord(t, key, "10.0.0.1:9000", 1)
+	if _, err := reg.Register(ctx, "", rec); err != nil {
+		t.Fatal(err)
+	}
+	// Retries resend the same envelope.
+	if _, err := reg.Register(ctx, "", rec); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	got, err := reg.Lookup(ctx, "reef", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, seq, err := got.VerifyEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Equal(pub) || seq != 1 {
+		t.Fatalf("signed by %x at %d, want %x at 1", signer, seq, pub)
+	}
+
+	tampered := signedRecord(t, key, "10.0.0.1:9000", 2)
+	tampered.Endpoints = []string{"192.0.2.1:9000"}
+	if _, err := reg.Register(ctx, "", tampered); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("tampered record: %v, want ErrInvalidRecord", err)
+	}
+	if _, err := reg.Register(ctx, "", signedRecord(t, key, "10.0.0.2:9000", 1)); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("replayed seq: %v, want ErrInvalidRecord", err)
+	}
+	if _, err := reg.Register(ctx, "", signedRecord(t, other, "10.0.0.2:9000", 2)); !errors.Is(err, registry.ErrUnauthorized) {
+		t.Fatalf("record of another key: %v, want ErrUnauthorized", err)
+	}
+	unsigned := &registry.Record{AgentID: "agent", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.2:9000"}}
+	if _, err := reg.Register(ctx, "", unsigned); !errors.Is(err, registry.ErrUnauthorized) {
+		t.Fatalf("unsigned record: %v, want ErrUnauthorized", err)
+	}
+	if _, err := reg.Register(ctx, "", signedRecord(t, key, "10.0.0.2:9000", 2)); err != nil {
+		t.Fatalf("next record: %v", err)
+	}
+}
+
+func TestRequireSignedRecords(t *testing.T) {
+	reg, err := registry.New(registry.Config{Store: store.NewMemory(), Verifier: noTickets{}, RequireSignedRecords: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registerAt(reg, "agent", "10.0.0.1:9000"); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("unsigned record: %v, want ErrInvalidRecord", err)
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Register(as("agent", registry.IntentRegister), "", signedRecord(t, key, "10.0.0.1:9000", 1)); err != nil {
+		t.Fatal(err)
+	}
+}
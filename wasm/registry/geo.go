@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Location is an agent's coarse position. The server fills it from the
+// geolocation hints of the edge in front of it (Cloudflare's
+// CF-IPCountry and visitor location headers, or request.cf in the Worker
+// build); agents may report it themselves. Coordinates are rounded to a
+// tenth of a degree when stored.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 country code.
+	Country string `json:"country,omitempty"`
+
+	// Continent is the two-letter continent code, e.g. "OC" or "EU".
+	Continent string `json:"continent,omitempty"`
+
+	// Colo is the IATA code of the edge data center the agent reached
+	// the server through.
+	Colo string `json:"colo,omitempty"`
+
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// maxRTTProbes bounds the RTT measurements a record may carry.
+const maxRTTProbes = 64
+
+// Rough round trip times, in milliseconds, assumed for records that are
+// only known to share an edge data center, country or continent, or
+// neither. Light in fiber covers about 100 km of great-circle distance
+// per millisecond of round trip.
+const (
+	sameColoRTT      = 5
+	sameCountryRTT   = 30
+	sameContinentRTT = 80
+	otherRTT         = 250
+	kmPerRTTMilli    = 100
+	earthRadiusKM    = 6371
+)
+
+// hasCoordinates reports whether l carries coordinates. (0, 0) is in the
+// Gulf of Guinea and taken as unset.
+func (l *Location) hasCoordinates() bool {
+	return l != nil && (l.Latitude != 0 || l.Longitude != 0)
+}
+
+// validate checks that l's fields are well formed.
+func (l *Location) validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Latitude < -90 || l.Latitude > 90 || l.Longitude < -180 || l.Longitude > 180 {
+		return errors.New("location coordinates out of range")
+	}
+	for name, code := range map[string]string{"country": l.Country, "continent": l.Continent} {
+		if code != "" && len(code) != 2 {
+			return fmt.Errorf("location %s must be a two-letter code", name)
+		}
+	}
+	if len(l.Colo) > 8 {
+		return errors.New("location colo must be an IATA code")
+	}
+	return nil
+}
+
+// coarsen normalizes codes to upper case and rounds the coordinates.
+func (l *Location) coarsen() {
+	if l == nil {
+		return
+	}
+	l.Country = strings.ToUpper(l.Country)
+	l.Continent = strings.ToUpper(l.Continent)
+	l.Colo = strings.ToUpper(l.Colo)
+	l.Latitude = math.Round(l.Latitude*10) / 10
+	l.Longitude = math.Round(l.Longitude*10) / 10
+}
+
+// Merge returns l with its empty fields taken from hint. Either may be
+// nil.
+func (l *Location) Merge(hint *Location) *Location {
+	switch {
+	case hint == nil:
+		return l
+	case l == nil:
+		c := *hint
+		return &c
+	}
+	m := *l
+	if m.Country == "" {
+		m.Country = hint.Country
+	}
+	if m.Continent == "" {
+		m.Continent = hint.Continent
+	}
+	if m.Colo == "" {
+		m.Colo = hint.Colo
+	}
+	if !m.hasCoordinates() {
+		m.Latitude, m.Longitude = hint.Latitude, hint.Longitude
+	}
+	return &m
+}
+
+// EstimateRTT estimates the round trip time between the agents of a and
+// b in milliseconds. RTTs either agent measured to the other are
+// preferred, then the great-circle distance between their coordinates,
+// then whether they share an edge data center, country or continent.
+// ok is false when neither record carries anything to go by.
+func EstimateRTT(a, b *Record) (ms float64, ok bool) {
+	ab, okAB := a.RTT[b.AgentID]
+	ba, okBA := b.RTT[a.AgentID]
+	switch {
+	case okAB && okBA:
+		return float64(min(ab, ba)), true
+	case okAB:
+		return float64(ab), true
+	case okBA:
+		return float64(ba), true
+	}
+
+	la, lb := a.Location, b.Location
+	if la == nil || lb == nil {
+		return 0, false
+	}
+	if la.hasCoordinates() && lb.hasCoordinates() {
+		return distanceKM(la, lb) / kmPerRTTMilli, true
+	}
+	switch {
+	case la.Colo != "" && la.Colo == lb.Colo:
+		return sameColoRTT, true
+	case la.Country != "" && la.Country == lb.Country:
+		return sameCountryRTT, true
+	case la.Continent != "" && la.Continent == lb.Continent:
+		return sameContinentRTT, true
+	case (la.Country != "" || la.Continent != "") && (lb.Country != "" || lb.Continent != ""):
+		return otherRTT, true
+	}
+	return 0, false
+}
+
+// Nearest returns the records of recs other than origin's own, ranked by
+// EstimateRTT from origin, at most n of them when n is positive.
+// Records without an estimate come last, in their original order.
+func Nearest(recs []*Record, origin *Record, n int) []*Record {
+	type ranked struct {
+		rec *Record
+		ms  float64
+		ok  bool
+	}
+	candidates := make([]ranked, 0, len(recs))
+	for _, rec := range recs {
+		if rec.AgentID == origin.AgentID && origin.AgentID != "" {
+			continue
+		}
+		ms, ok := EstimateRTT(origin, rec)
+		candidates = append(candidates, ranked{rec, ms, ok})
+	}
+	slices.SortStableFunc(candidates, func(x, y ranked) int {
+		switch {
+		case x.ok != y.ok:
+			if x.ok {
+				return -1
+			}
+			return 1
+		case x.ms < y.ms:
+			return -1
+		case x.ms > y.ms:
+			return 1
+		}
+		return 0
+	})
+	if n > 0 && len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*Record, len(candidates))
+	for i, c := range candidates {
+		out[i] = c.rec
+	}
+	return out
+}
+
+// distanceKM is the great-circle distance between a and b.
+func distanceKM(a, b *Location) float64 {
+	const rad = math.Pi / 180
+	lat1, lat2 := a.Latitude*rad, b.Latitude*rad
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading: wall time in nanoseconds,
+// a counter ordering events within the same nanosecond or arriving from
+// a replica whose clock runs ahead, and the replica that took the
+// reading, which breaks ties. Timestamps of causally related writes are
+// ordered like the writes.
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// IsZero reports whether t is the zero Timestamp, which every other
+// timestamp follows.
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Compare returns -1, 0 or +1 as t orders before, equal to or after u.
+func (t Timestamp) Compare(u Timestamp) int {
+	if c := cmp.Compare(t.Wall, u.Wall); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(t.Logical, u.Logical); c != 0 {
+		return c
+	}
+	return cmp.Compare(t.Node, u.Node)
+}
+
+// String formats t as wall.logical@node.
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d@%s", t.Wall, t.Logical, t.Node)
+}
+
+// ParseTimestamp parses a Timestamp formatted by String, or its wall time
+// alone.
+func ParseTimestamp(s string) (Timestamp, error) {
+	if w, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Timestamp{Wall: w}, nil
+	}
+	wall, rest, ok1 := strings.Cut(s, ".")
+	logical, node, ok2 := strings.Cut(rest, "@")
+	w, err1 := strconv.ParseInt(wall, 10, 64)
+	l, err2 := strconv.ParseUint(logical, 10, 32)
+	if !ok1 || !ok2 || err1 != nil || err2 != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q: want wall.logical@node or wall", s)
+	}
+	return Timestamp{Wall: w, Logical: uint32(l), Node: node}, nil
+}
+
+// Clock is a hybrid logical clock. It is safe for concurrent use.
+type Clock struct {
+	node string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewClock creates a Clock stamping readings with node.
+func NewClock(node string) *Clock {
+	return &Clock{node: node, now: time.Now}
+}
+
+// Now returns a timestamp after every timestamp the clock returned or
+// observed before.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick(Timestamp{})
+	return c.last
+}
+
+// Observe advances the clock past t, a timestamp received from another
+// replica, so that later local writes order after it.
+func (c *Clock) Observe(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick(t)
+}
+
+// tick advances last past remote and the wall clock.
+func (c *Clock) tick(remote Timestamp) {
+	wall := c.now().UnixNano()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case remote.Wall == c.last.Wall:
+		c.last.Logical = max(c.last.Logical, remote.Logical) + 1
+	default:
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+}
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Renew extends the lease on agentID by its TTL. The ticket must carry the
+// "renew" or "register" intent and name the agent and its reef. Leases that expired
+// more than the grace period ago cannot be renewed.
+func (r *Registry) Renew(ctx context.Context, ticket, agentID string) (*Record, error) {
+	return r.Heartbeat(ctx, ticket, agentID, nil)
+}
+
+// Heartbeat renews agentID's lease like Renew and records load, when
+// non-nil, as the agent's current load.
+func (r *Registry) Heartbeat(ctx context.Context, ticket, agentID string, load *Load) (*Record, error) {
+	return r.HeartbeatSeq(ctx, ticket, agentID, 0, load)
+}
+
+// HeartbeatSeq is Heartbeat, refused with ErrStaleRecord unless the
+// record is still at seq when seq is nonzero (see Record.Seq). Agents
+// renew at the Seq of the record they last wrote, so an instance another
+// one has replaced learns of it instead of renewing its record.
+func (r *Registry) HeartbeatSeq(ctx context.Context, ticket, agentID string, seq uint64, load *Load) (*Record, error) {
+	if err := load.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	claims, err := r.authorize(ctx, ticket, "")
+	if err != nil {
+		return nil, err
+	}
+	if claims.Intent != IntentRenew && claims.Intent != IntentRegister {
+		return nil, fmt.Errorf("%w: ticket intent %q cannot renew", ErrUnauthorized, claims.Intent)
+	}
+
+	for attempt := 0; ; attempt++ {
+		rec, err := r.extend(ctx, claims.ReferralClaims, agentID, seq, load)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.hub.publish(Event{Type: EventPut, Record: rec})
+		return rec.Clone(), nil
+	}
+}
+
+// extend pushes agentID's expiry out by its TTL, replacing its load when
+// load is non-nil. A nonzero seq must be the record's.
+func (r *Registry) extend(ctx context.Context, claims *jwt.ReferralClaims, agentID string, seq uint64, load *Load) (*Record, error) {
+	rec, revision, err := r.loadRecord(ctx, claims.ReefID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ColonyID != rec.ColonyID || claims.AgentID != rec.AgentID {
+		return nil, fmt.Errorf("%w: ticket does not match record identity", ErrUnauthorized)
+	}
+	if err := checkSeq(agentID, rec, seq); err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	if r.evictable(rec, now) {
+		return nil, ErrLeaseExpired
+	}
+
+	rec.UpdatedAt = now
+	rec.ExpiresAt = now.Add(time.Duration(rec.TTLSeconds) * time.Second)
+	if load != nil {
+		l := *load
+		rec.Load = &l
+	}
+	rec.Seq++
+	rec.Version = r.clock.Now()
+	if err := r.saveRecord(ctx, rec, revision); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Reap evicts every record whose lease expired more than the grace period
+// ago and emits an EventExpire for each, and drops tombstones older than
+// the tombstone TTL and the registration counts of past quota windows.
+// It returns the number of records evicted.
+func (r *Registry) Reap(ctx context.Context) (int, error) {
+	recs, err := r.listAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := r.now()
+	evicted := 0
+	for _, rec := range recs {
+		if !r.evictable(rec, now) {
+			continue
+		}
+		if err := r.deleteRecord(ctx, rec.ReefID, rec.AgentID); err != nil {
+			continue
+		}
+		r.hub.publish(Event{Type: EventExpire, Record: rec})
+		r.metrics.expirations.Inc()
+		_ = r.audit.Record(ctx, audit.Event{Type: audit.LeaseExpired, Outcome: audit.Success, Reef: rec.ReefID, Colony: rec.ColonyID, Subject: rec.AgentID})
+		evicted++
+	}
+	r.pruneUsage(now)
+	return evicted, r.pruneTombstones(ctx, now)
+}
+
+// RunReaper calls Reap every interval until ctx is done.
+func (r *Registry) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = r.Reap(ctx)
+		}
+	}
+}
+
+// evictable reports whether rec is past its expiry plus the grace period.
+func (r *Registry) evictable(rec *Record, now time.Time) bool {
+	return !rec.ExpiresAt.IsZero() && now.After(rec.ExpiresAt.Add(r.gracePeriod))
+}
@@ -0,0 +1,173 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/testutil"
+)
+
+// noTickets rejects every ticket: the tests act as agents through
+// auth.NewContext instead.
+type noTickets struct{}
+
+func (noTickets) ValidateReferralTicket(string) (*jwt.ReferralClaims, error) {
+	return nil, errors.New("no tickets")
+}
+
+// as returns a context acting as agentID with intent.
+func as(agentID, intent string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{
+		Method:         auth.MethodTicket,
+		ReferralClaims: &jwt.ReferralClaims{ReefID: "reef", ColonyID: "colony", AgentID: agentID, Intent: intent},
+	})
+}
+
+// newRegistry returns a registry with a 30s grace period on a faulty
+// store, reading a fake clock.
+func newRegistry(t *testing.T) (*registry.Registry, *testutil.Store, *clock.Fake) {
+	t.Helper()
+	st := testutil.NewStore(store.NewMemory(), 1)
+	now := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg, err := registry.New(registry.Config{Store: st, Verifier: noTickets{}, GracePeriod: 30 * time.Second, Clock: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg, st, now
+}
+
+func register(t *testing.T, reg *registry.Registry, agentID string, ttl int) *registry.Record {
+	t.Helper()
+	rec, err := reg.Register(as(agentID, registry.IntentRegister), "", &registry.Record{
+		AgentID: agentID, ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.1:9000"}, TTLSeconds: ttl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	reg, _, now := newRegistry(t)
+	ctx := context.Background()
+	start := now.Now()
+	rec := register(t, reg, "agent", 60)
+	if want := start.Add(time.Minute); !rec.ExpiresAt.Equal(want) {
+		t.Fatalf("lease expires at %v, want %v", rec.ExpiresAt, want)
+	}
+
+	now.Advance(time.Minute)
+	if _, err := reg.Lookup(ctx, "reef", "agent"); err != nil {
+		t.Fatalf("lookup at expiry: %v", err)
+	}
+	now.Advance(time.Nanosecond)
+	if _, err := reg.Lookup(ctx, "reef", "agent"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("lookup after expiry: %v, want ErrNotFound", err)
+	}
+	if n, err := reg.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("reaped %d leases within the grace period (%v)", n, err)
+	}
+
+	// A renewal within the grace period revives the lease.
+	renewed, err := reg.Renew(as("agent", registry.IntentRenew), "", "agent")
+	if err != nil {
+		t.Fatalf("renew within the grace period: %v", err)
+	}
+	if want := now.Now().Add(time.Minute); !renewed.ExpiresAt.Equal(want) || !renewed.CreatedAt.Equal(start) {
+		t.Fatalf("renewed lease created %v, expiring %v; want %v, %v", renewed.CreatedAt, renewed.ExpiresAt, start, want)
+	}
+
+	now.Advance(90 * time.Second)
+	if _, err := reg.Lookup(ctx, "reef", "agent"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("lookup in the grace period: %v, want ErrNotFound", err)
+	}
+	now.Advance(time.Nanosecond)
+	if _, err := reg.Renew(as("agent", registry.IntentRenew), "", "agent"); !errors.Is(err, registry.ErrLeaseExpired) {
+		t.Fatalf("renew after the grace period: %v, want ErrLeaseExpired", err)
+	}
+	if n, err := reg.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("reaped %d leases (%v), want 1", n, err)
+	}
+	if _, err := reg.Renew(as("agent", registry.IntentRenew), "", "agent"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("renew after reaping: %v, want ErrNotFound", err)
+	}
+}
+
+func TestStaleWrites(t *testing.T) {
+	reg, _, _ := newRegistry(t)
+	ctx := as("agent", registry.IntentRegister)
+	first := register(t, reg, "agent", 60)
+	if first.Seq != 1 {
+		t.Fatalf("registered at seq %d, want 1", first.Seq)
+	}
+
+	// The old instance renews at the seq it wrote; the new one registers.
+	renewed, err := reg.HeartbeatSeq(ctx, "", "agent", first.Seq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Seq != 2 {
+		t.Fatalf("renewed at seq %d, want 2", renewed.Seq)
+	}
+	next := &registry.Record{AgentID: "agent", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.2:9000"}}
+	replaced, err := reg.Register(ctx, "", next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.HeartbeatSeq(ctx, "", "agent", renewed.Seq, nil); !errors.Is(err, registry.ErrStaleRecord) {
+		t.Fatalf("renewal of a replaced record: %v, want ErrStaleRecord", err)
+	}
+	stale := first.Clone()
+	stale.Seq = renewed.Seq
+	if _, err := reg.Register(ctx, "", stale); !errors.Is(err, registry.ErrStaleRecord) {
+		t.Fatalf("registration over a replaced record: %v, want ErrStaleRecord", err)
+	}
+
+	// Reading the record again lets the write through.
+	current, err := reg.Lookup(ctx, "reef", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Seq != replaced.Seq || current.Endpoints[0] != "10.0.0.2:9000" {
+		t.Fatalf("record at seq %d with endpoints %v after a stale write", current.Seq, current.Endpoints)
+	}
+	current.Capabilities = []string{"relay"}
+	if _, err := reg.Register(ctx, "", current); err != nil {
+		t.Fatalf("registration at the current seq: %v", err)
+	}
+
+	if err := reg.Deregister(ctx, "", "agent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Register(ctx, "", current); !errors.Is(err, registry.ErrStaleRecord) {
+		t.Fatalf("conditional registration of a removed agent: %v, want ErrStaleRecord", err)
+	}
+}
+
+func TestReapStoreUnreachable(t *testing.T) {
+	reg, st, now := newRegistry(t)
+	ctx := context.Background()
+	register(t, reg, "agent", 60)
+	now.Advance(2 * time.Minute)
+
+	st.Partition()
+	if _, err := reg.Reap(ctx); !errors.Is(err, testutil.ErrPartitioned) {
+		t.Fatalf("reap with the store unreachable: %v", err)
+	}
+	if _, err := reg.Lookup(ctx, "reef", "agent"); !errors.Is(err, testutil.ErrPartitioned) {
+		t.Fatalf("lookup with the store unreachable: %v", err)
+	}
+
+	st.Heal()
+	if n, err := reg.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("reaped %d leases (%v), want 1", n, err)
+	}
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Liveness changes are reported by failure detectors running inside a
+// colony, such as the gossip package, so watchers learn of crashed agents
+// well before their leases run out. They are trusted calls: expose them
+// only to detectors that authenticate their members.
+
+// MarkSuspect emits an EventSuspect for agentID of colonyID in reefID.
+// The record is unchanged.
+func (r *Registry) MarkSuspect(ctx context.Context, reefID, colonyID, agentID string) error {
+	rec, err := r.member(ctx, reefID, colonyID, agentID)
+	if err != nil {
+		return err
+	}
+	r.hub.publish(Event{Type: EventSuspect, Record: rec})
+	return nil
+}
+
+// MarkAlive emits an EventAlive for agentID. A lease ended by MarkFailed
+// stays ended until the agent renews it.
+func (r *Registry) MarkAlive(ctx context.Context, reefID, colonyID, agentID string) error {
+	rec, err := r.member(ctx, reefID, colonyID, agentID)
+	if err != nil {
+		return err
+	}
+	r.hub.publish(Event{Type: EventAlive, Record: rec})
+	return nil
+}
+
+// MarkFailed ends agentID's lease now and emits an EventFail. The agent
+// drops out of Lookup and List at once but, like any expired lease, may
+// renew within the grace period if it was declared failed by mistake.
+func (r *Registry) MarkFailed(ctx context.Context, reefID, colonyID, agentID string) error {
+	for attempt := 0; ; attempt++ {
+		rec, revision, err := r.loadRecord(ctx, reefID, agentID)
+		if err != nil {
+			return err
+		}
+		if rec.ColonyID != colonyID {
+			return fmt.Errorf("%w: %s is not in colony %s", ErrNotFound, agentID, colonyID)
+		}
+		now := r.now()
+		if rec.Expired(now) {
+			return nil
+		}
+		rec.ExpiresAt = now
+		rec.Seq++
+		rec.Version = r.clock.Now()
+		err = r.saveRecord(ctx, rec, revision)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.hub.publish(Event{Type: EventFail, Record: rec})
+		return nil
+	}
+}
+
+// member returns agentID's record in reefID if it belongs to colonyID.
+func (r *Registry) member(ctx context.Context, reefID, colonyID, agentID string) (*Record, error) {
+	rec, err := r.Lookup(ctx, reefID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if rec.ColonyID != colonyID {
+		return nil, fmt.Errorf("%w: %s is not in colony %s", ErrNotFound, agentID, colonyID)
+	}
+	return rec, nil
+}
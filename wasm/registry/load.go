@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Load is the load an agent reports with its heartbeats.
+type Load struct {
+	// Utilization is the share of the agent's capacity in use, from 0
+	// (idle) to 1 (saturated).
+	Utilization float64 `json:"utilization"`
+
+	// Sessions is the number of sessions the agent is serving, e.g.
+	// relayed reservations.
+	Sessions int `json:"sessions,omitempty"`
+}
+
+// validate checks that l's fields are in range.
+func (l *Load) validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Utilization < 0 || l.Utilization > 1 || math.IsNaN(l.Utilization) {
+		return errors.New("load utilization must be between 0 and 1")
+	}
+	if l.Sessions < 0 {
+		return errors.New("load sessions must not be negative")
+	}
+	return nil
+}
+
+// Health scores rec at now between 0 and 1. An idle agent that renewed
+// within its heartbeat interval, a third of its TTL as clients renew by
+// default, scores 1. The score halves with every interval missed since
+// and scales with the capacity the agent reports free; agents reporting
+// no load count as half utilized.
+func Health(rec *Record, now time.Time) float64 {
+	utilization := unknownUtilization
+	if rec.Load != nil {
+		utilization = rec.Load.Utilization
+	}
+	return freshness(rec, now) * (1 - utilization)
+}
+
+// unknownUtilization is assumed by Health for agents reporting no load.
+const unknownUtilization = 0.5
+
+// freshness is 1 for records renewed within their heartbeat interval,
+// halving with every interval missed since.
+func freshness(rec *Record, now time.Time) float64 {
+	interval := time.Duration(rec.TTLSeconds) * time.Second / 3
+	if interval <= 0 {
+		interval = DefaultTTL / 3
+	}
+	missed := float64(now.Sub(rec.UpdatedAt))/float64(interval) - 1
+	if missed <= 0 {
+		return 1
+	}
+	return math.Exp2(-missed)
+}
+
+// LeastLoaded returns recs ordered by reported utilization and then
+// sessions, at most n of them when n is positive. Agents that missed a
+// heartbeat come after the punctual ones and agents reporting no load
+// after those that do, so a stale "idle" report does not attract
+// traffic.
+func LeastLoaded(recs []*Record, now time.Time, n int) []*Record {
+	out := slices.Clone(recs)
+	slices.SortStableFunc(out, func(a, b *Record) int {
+		if c := cmp.Compare(freshness(b, now), freshness(a, now)); c != 0 {
+			return c
+		}
+		if (a.Load == nil) != (b.Load == nil) {
+			if a.Load == nil {
+				return 1
+			}
+			return -1
+		}
+		if a.Load == nil {
+			return 0
+		}
+		if c := cmp.Compare(a.Load.Utilization, b.Load.Utilization); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Load.Sessions, b.Load.Sessions)
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Weighted returns up to n records drawn from recs at random without
+// replacement, each draw weighted by Health, all of them in draw order
+// when n is not positive. Once only records scoring zero remain they are
+// drawn uniformly, so a saturated colony still yields peers.
+func Weighted(recs []*Record, now time.Time, n int) []*Record {
+	pool := slices.Clone(recs)
+	weights := make([]float64, len(pool))
+	for i, rec := range pool {
+		weights[i] = Health(rec, now)
+	}
+	if n <= 0 || n > len(pool) {
+		n = len(pool)
+	}
+	out := make([]*Record, 0, n)
+	for len(out) < n {
+		total := 0.0
+		for _, w := range weights {
+			total += w
+		}
+		i := rand.IntN(len(pool))
+		if total > 0 {
+			x := rand.Float64() * total
+			for i = 0; i < len(pool)-1; i++ {
+				if x -= weights[i]; x < 0 {
+					break
+				}
+			}
+		}
+		out = append(out, pool[i])
+		pool = slices.Delete(pool, i, i+1)
+		weights = slices.Delete(weights, i, i+1)
+	}
+	return out
+}
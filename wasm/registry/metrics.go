@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"errors"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+)
+
+// instruments are the registry's metrics. They are nil, and discard
+// observations, without Config.Metrics.
+type instruments struct {
+	registrations *metrics.Counter
+	lookups       *metrics.Histogram
+	expirations   *metrics.Counter
+}
+
+func newInstruments(set *metrics.Set, h *hub) instruments {
+	set.GaugeFunc("coral_registry_watch_subscribers",
+		"Open registry watch subscriptions.", func() float64 { return float64(h.count()) })
+	return instruments{
+		registrations: set.Counter("coral_registry_registrations_total",
+			"Registration attempts, by result.", "result"),
+		lookups: set.Histogram("coral_registry_lookup_duration_seconds",
+			"Latency of agent lookups and colony listings, by operation.", nil, "op"),
+		expirations: set.Counter("coral_registry_lease_expirations_total",
+			"Records evicted after their lease and grace period ran out."),
+	}
+}
+
+// registrationResult labels the outcome of a Register call.
+func registrationResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrInvalidRecord):
+		return "invalid"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ratelimit.ErrLimited):
+		return "limited"
+	case errors.Is(err, ErrDraining):
+		return "draining"
+	case errors.Is(err, allowlist.ErrNotAllowed):
+		return "not_allowed"
+	case errors.Is(err, ErrStaleRecord):
+		return "stale"
+	}
+	return "error"
+}
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Migrate moves records and tombstones stored by earlier versions under
+// keys without their reef, such as agents/<agent>, to the keys of their
+// reef. Where an entry exists under both, the one under the reef's key
+// is kept. It returns the number of entries moved, and is safe to run
+// on every start.
+func (r *Registry) Migrate(ctx context.Context) (int, error) {
+	moved := 0
+	for _, prefix := range []string{agentPrefix, tombstonePrefix} {
+		entries, err := r.store.List(ctx, prefix)
+		if err != nil {
+			return moved, fmt.Errorf("failed to list entries to migrate: %w", err)
+		}
+		for _, entry := range entries {
+			var id struct {
+				AgentID string `json:"agent_id"`
+				ReefID  string `json:"reef_id"`
+			}
+			if json.Unmarshal(entry.Value, &id) != nil || id.AgentID == "" || id.ReefID == "" {
+				continue
+			}
+			key := agentKey(id.ReefID, id.AgentID)
+			if prefix == tombstonePrefix {
+				key = tombstoneKey(id.ReefID, id.AgentID)
+			}
+			if entry.Key == key {
+				continue
+			}
+			r.writes.RLock()
+			_, err := r.store.CompareAndSwap(ctx, key, 0, entry.Value)
+			r.writes.RUnlock()
+			if err != nil && !errors.Is(err, store.ErrConflict) {
+				return moved, fmt.Errorf("failed to migrate %s: %w", entry.Key, err)
+			}
+			if err := r.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return moved, fmt.Errorf("failed to migrate %s: %w", entry.Key, err)
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublicAddr reports whether a is globally routable: not private,
+// carrier-grade NAT, loopback, link-local or unspecified.
+func IsPublicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsValid() && a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddressSpace.Contains(a)
+}
+
+// ObservedEndpoints pairs the public address a request came from with
+// the ports of the agent's self-reported endpoints that are not public
+// themselves. Agents behind a NAT usually only know their private
+// address; many NATs keep the source port, which makes these the first
+// candidates to try from outside. It returns nil when remote is not
+// public, e.g. for agents on the server's own network.
+func ObservedEndpoints(endpoints []string, remote netip.Addr) []string {
+	if !IsPublicAddr(remote) {
+		return nil
+	}
+	remote = remote.Unmap()
+	seen := map[string]bool{}
+	var out []string
+	for _, ep := range endpoints {
+		host, port, err := net.SplitHostPort(ep)
+		if err != nil {
+			continue
+		}
+		if a, err := netip.ParseAddr(host); err != nil || IsPublicAddr(a) {
+			continue
+		}
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+			continue
+		}
+		obs := net.JoinHostPort(remote.String(), port)
+		if !seen[obs] {
+			seen[obs] = true
+			out = append(out, obs)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Page sizes of colony listings.
+const (
+	// DefaultPageSize is the page size of listings that do not ask for
+	// one.
+	DefaultPageSize = 500
+
+	// MaxPageSize caps the page size, and the agents a selection
+	// strategy may return, so responses stay within the Worker's
+	// limits.
+	MaxPageSize = 5000
+)
+
+// ErrInvalidCursor is returned for cursors that are malformed or were
+// issued for another colony.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorVersion prefixes cursors so their encoding can change.
+const cursorVersion = "1"
+
+// Page is one page of a colony listing. NextCursor continues the listing
+// and is empty on the last page.
+type Page struct {
+	Records    []*Record `json:"agents"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// QueryPage returns up to limit live records in colonyID of reefID whose
+// labels match sel, in agent ID order, starting after cursor (from the start
+// when empty). limit defaults to DefaultPageSize and is capped at
+// MaxPageSize. Records registered while a listing is paged through show
+// up if they sort after the cursor.
+func (r *Registry) QueryPage(ctx context.Context, reefID, colonyID string, sel *Selector, cursor string, limit int) (*Page, error) {
+	if _, err := decodeCursor(colonyID, cursor); err != nil {
+		return nil, err
+	}
+	// Records come sorted by key, and so by agent ID.
+	recs, err := r.Query(ctx, reefID, colonyID, sel)
+	if err != nil {
+		return nil, err
+	}
+	return Paginate(colonyID, recs, cursor, limit)
+}
+
+// Paginate returns the page of recs, colonyID's records as returned by
+// Query, that QueryPage would return for cursor and limit. recs is not
+// modified.
+func Paginate(colonyID string, recs []*Record, cursor string, limit int) (*Page, error) {
+	after, err := decodeCursor(colonyID, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	page := &Page{Records: []*Record{}}
+	for _, rec := range recs {
+		if rec.AgentID <= after && after != "" {
+			continue
+		}
+		if len(page.Records) == limit {
+			page.NextCursor = encodeCursor(colonyID, page.Records[limit-1].AgentID)
+			break
+		}
+		page.Records = append(page.Records, rec)
+	}
+	return page, nil
+}
+
+// encodeCursor makes the opaque cursor continuing colonyID's listing
+// after agentID.
+func encodeCursor(colonyID, agentID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorVersion + "\x00" + colonyID + "\x00" + agentID))
+}
+
+// decodeCursor returns the agent ID cursor continues after.
+func decodeCursor(colonyID, cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	parts := strings.SplitN(string(data), "\x00", 3)
+	if len(parts) != 3 || parts[0] != cursorVersion || parts[2] == "" {
+		return "", fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	if parts[1] != colonyID {
+		return "", fmt.Errorf("%w: issued for colony %s", ErrInvalidCursor, parts[1])
+	}
+	return parts[2], nil
+}
@@ -0,0 +1,346 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// quotaPrefix is where colony quotas set through SetColonyQuota live,
+// under quotas/<reef>/<colony>.
+const quotaPrefix = "quotas/"
+
+// quotaWindow is the window registrations are counted in for
+// ColonyQuota.RegistrationsPerMinute.
+const quotaWindow = time.Minute
+
+// Quotas of a QuotaError.
+const (
+	QuotaReefAgents    = "reef_agents"
+	QuotaAgents        = "agents"
+	QuotaRegistrations = "registrations"
+	QuotaWatches       = "watches"
+)
+
+// Quotas limits how many agents a reef may hold, so that one tenant of a
+// shared deployment cannot fill its store.
+type Quotas interface {
+	// MaxAgents returns the most agents reefID may hold, or 0 for no
+	// limit.
+	MaxAgents(reefID string) int
+}
+
+// ColonyQuota bounds what the agents of one colony may do. Zero fields
+// mean no limit.
+type ColonyQuota struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+
+	// MaxAgents bounds the agents registered in the colony, counting
+	// leases within their grace period.
+	MaxAgents int `json:"max_agents,omitempty"`
+
+	// RegistrationsPerMinute bounds the registrations, new or updating,
+	// the colony's agents make per minute on each instance.
+	RegistrationsPerMinute int `json:"registrations_per_minute,omitempty"`
+
+	// MaxWatches bounds the watch streams the colony's agents hold open
+	// on each instance.
+	MaxWatches int `json:"max_watches,omitempty"`
+}
+
+// QuotaUsage is how much of its quota a colony uses on this instance.
+type QuotaUsage struct {
+	Agents        int `json:"agents"`
+	Registrations int `json:"registrations_this_minute"`
+	Watches       int `json:"watches"`
+}
+
+// QuotaStatus is a colony's quota and its use.
+type QuotaStatus struct {
+	Quota ColonyQuota `json:"quota"`
+
+	// Custom reports whether the quota was set for the colony rather
+	// than being Config.DefaultColonyQuota.
+	Custom bool `json:"custom"`
+
+	Usage QuotaUsage `json:"usage"`
+}
+
+// QuotaError is the error of a request over a quota. It wraps
+// ErrQuotaExceeded.
+type QuotaError struct {
+	// Quota is the quota exceeded: QuotaReefAgents, QuotaAgents,
+	// QuotaRegistrations or QuotaWatches.
+	Quota    string
+	ReefID   string
+	ColonyID string // empty for QuotaReefAgents
+	Limit    int
+
+	// RetryAfter is how long until a quota that refills, such as
+	// QuotaRegistrations, admits the request; zero for the others.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Quota {
+	case QuotaReefAgents:
+		return fmt.Sprintf("%v: reef %s holds its limit of %d agents", ErrQuotaExceeded, e.ReefID, e.Limit)
+	case QuotaAgents:
+		return fmt.Sprintf("%v: colony %s holds its limit of %d agents", ErrQuotaExceeded, e.ColonyID, e.Limit)
+	case QuotaRegistrations:
+		return fmt.Sprintf("%v: colony %s made its limit of %d registrations this minute", ErrQuotaExceeded, e.ColonyID, e.Limit)
+	case QuotaWatches:
+		return fmt.Sprintf("%v: colony %s holds its limit of %d watch streams", ErrQuotaExceeded, e.ColonyID, e.Limit)
+	}
+	return fmt.Sprintf("%v: %s limit of %d", ErrQuotaExceeded, e.Quota, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// usage counts the registrations and watch streams of each colony on
+// this instance, by colonyKey.
+type usage struct {
+	mu            sync.Mutex
+	registrations map[string]*window
+	watches       map[string]int
+}
+
+// window counts registrations since start.
+type window struct {
+	start time.Time
+	n     int
+}
+
+func newUsage() *usage {
+	return &usage{registrations: map[string]*window{}, watches: map[string]int{}}
+}
+
+func colonyKey(reefID, colonyID string) string {
+	return reefID + "/" + colonyID
+}
+
+func quotaKey(reefID, colonyID string) string {
+	return quotaPrefix + colonyKey(reefID, colonyID)
+}
+
+// ColonyQuota returns the quota of colonyID in reefID: the one set with
+// SetColonyQuota, or else Config.DefaultColonyQuota.
+func (r *Registry) ColonyQuota(ctx context.Context, reefID, colonyID string) (*ColonyQuota, error) {
+	q, _, err := r.colonyQuota(ctx, reefID, colonyID)
+	return q, err
+}
+
+// colonyQuota is ColonyQuota, also reporting whether the quota was set
+// for the colony.
+func (r *Registry) colonyQuota(ctx context.Context, reefID, colonyID string) (*ColonyQuota, bool, error) {
+	entry, err := r.store.Get(ctx, quotaKey(reefID, colonyID))
+	if errors.Is(err, store.ErrNotFound) {
+		q := r.defaultQuota
+		q.ReefID, q.ColonyID = reefID, colonyID
+		return &q, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read quota: %w", err)
+	}
+	var q ColonyQuota
+	if err := json.Unmarshal(entry.Value, &q); err != nil {
+		return nil, false, fmt.Errorf("failed to decode quota: %w", err)
+	}
+	return &q, true, nil
+}
+
+// ColonyQuotas returns the quotas set for colonies of reefID, in colony
+// order. Other colonies have Config.DefaultColonyQuota.
+func (r *Registry) ColonyQuotas(ctx context.Context, reefID string) ([]*ColonyQuota, error) {
+	if reefID == "" {
+		return []*ColonyQuota{}, nil
+	}
+	entries, err := r.store.List(ctx, quotaPrefix+reefID+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quotas: %w", err)
+	}
+	quotas := make([]*ColonyQuota, 0, len(entries))
+	for _, entry := range entries {
+		var q ColonyQuota
+		if err := json.Unmarshal(entry.Value, &q); err != nil {
+			continue
+		}
+		quotas = append(quotas, &q)
+	}
+	return quotas, nil
+}
+
+// DefaultColonyQuota returns Config.DefaultColonyQuota.
+func (r *Registry) DefaultColonyQuota() ColonyQuota {
+	return r.defaultQuota
+}
+
+// SetColonyQuota sets the quota of q's colony in place of the default.
+// Agents already over the new quota stay registered; the quota only
+// refuses further registrations and watches.
+func (r *Registry) SetColonyQuota(ctx context.Context, q *ColonyQuota) error {
+	if err := q.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	if _, err := r.store.Put(ctx, quotaKey(q.ReefID, q.ColonyID), data); err != nil {
+		return fmt.Errorf("failed to store quota: %w", err)
+	}
+	return nil
+}
+
+// ResetColonyQuota returns colonyID of reefID to the default quota. It
+// returns ErrNotFound when the colony has none of its own.
+func (r *Registry) ResetColonyQuota(ctx context.Context, reefID, colonyID string) error {
+	err := r.store.Delete(ctx, quotaKey(reefID, colonyID))
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: colony %s has the default quota", ErrNotFound, colonyID)
+	}
+	return err
+}
+
+// ColonyQuotaStatus returns the quota of colonyID in reefID and how much
+// of it the colony uses.
+func (r *Registry) ColonyQuotaStatus(ctx context.Context, reefID, colonyID string) (*QuotaStatus, error) {
+	q, custom, err := r.colonyQuota(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := r.listRecords(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+	status := &QuotaStatus{Quota: *q, Custom: custom, Usage: QuotaUsage{Agents: len(recs)}}
+	key := colonyKey(reefID, colonyID)
+	r.usage.mu.Lock()
+	if w := r.usage.registrations[key]; w != nil && r.now().Before(w.start.Add(quotaWindow)) {
+		status.Usage.Registrations = w.n
+	}
+	status.Usage.Watches = r.usage.watches[key]
+	r.usage.mu.Unlock()
+	return status, nil
+}
+
+func (q *ColonyQuota) validate() error {
+	switch {
+	case q.ReefID == "" || q.ColonyID == "":
+		return fmt.Errorf("%w: quota needs reef_id and colony_id", ErrInvalidRecord)
+	case strings.Contains(q.ReefID, "/"):
+		return fmt.Errorf("%w: reef_id must not contain '/'", ErrInvalidRecord)
+	case q.MaxAgents < 0 || q.RegistrationsPerMinute < 0 || q.MaxWatches < 0:
+		return fmt.Errorf("%w: quota limits must not be negative", ErrInvalidRecord)
+	}
+	return nil
+}
+
+// chargeRegistration counts a registration in colony q against its
+// RegistrationsPerMinute.
+func (r *Registry) chargeRegistration(q *ColonyQuota) error {
+	now := r.now()
+	key := colonyKey(q.ReefID, q.ColonyID)
+	r.usage.mu.Lock()
+	defer r.usage.mu.Unlock()
+	w := r.usage.registrations[key]
+	if w == nil || !now.Before(w.start.Add(quotaWindow)) {
+		w = &window{start: now}
+		r.usage.registrations[key] = w
+	}
+	if q.RegistrationsPerMinute > 0 && w.n >= q.RegistrationsPerMinute {
+		return &QuotaError{Quota: QuotaRegistrations, ReefID: q.ReefID, ColonyID: q.ColonyID, Limit: q.RegistrationsPerMinute, RetryAfter: w.start.Add(quotaWindow).Sub(now)}
+	}
+	w.n++
+	return nil
+}
+
+// admit checks that registering rec, an agent new to its reef, keeps the
+// reef and the colony, whose quota is q, within their quotas. Records in
+// their grace period count against them. Concurrent registrations are
+// counted separately and may overshoot a quota by as many.
+func (r *Registry) admit(ctx context.Context, rec *Record, q *ColonyQuota) error {
+	reefLimit := 0
+	if r.quotas != nil {
+		reefLimit = r.quotas.MaxAgents(rec.ReefID)
+	}
+	if reefLimit <= 0 && q.MaxAgents <= 0 {
+		return nil
+	}
+	recs, err := r.listRecords(ctx, rec.ReefID, "")
+	if err != nil {
+		return err
+	}
+	if reefLimit > 0 && len(recs) >= reefLimit {
+		return &QuotaError{Quota: QuotaReefAgents, ReefID: rec.ReefID, Limit: reefLimit}
+	}
+	if q.MaxAgents > 0 {
+		n := 0
+		for _, other := range recs {
+			if other.ColonyID == rec.ColonyID {
+				n++
+			}
+		}
+		if n >= q.MaxAgents {
+			return &QuotaError{Quota: QuotaAgents, ReefID: rec.ReefID, ColonyID: rec.ColonyID, Limit: q.MaxAgents}
+		}
+	}
+	return nil
+}
+
+// holdWatch counts a watch stream against the MaxWatches of the colony
+// of ctx's principal, if it carries one, until ctx is done. release
+// gives the stream back early.
+func (r *Registry) holdWatch(ctx context.Context) (release func(), err error) {
+	p := auth.FromContext(ctx)
+	if p == nil {
+		return func() {}, nil
+	}
+	q, err := r.ColonyQuota(ctx, p.ReefID, p.ColonyID)
+	if err != nil {
+		return nil, err
+	}
+	key := colonyKey(p.ReefID, p.ColonyID)
+	r.usage.mu.Lock()
+	if q.MaxWatches > 0 && r.usage.watches[key] >= q.MaxWatches {
+		r.usage.mu.Unlock()
+		return nil, &QuotaError{Quota: QuotaWatches, ReefID: p.ReefID, ColonyID: p.ColonyID, Limit: q.MaxWatches}
+	}
+	r.usage.watches[key]++
+	r.usage.mu.Unlock()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			r.usage.mu.Lock()
+			if r.usage.watches[key]--; r.usage.watches[key] <= 0 {
+				delete(r.usage.watches, key)
+			}
+			r.usage.mu.Unlock()
+		})
+	}
+	go func() {
+		<-ctx.Done()
+		release()
+	}()
+	return release, nil
+}
+
+// pruneUsage drops the registration counts of windows that ended.
+func (r *Registry) pruneUsage(now time.Time) {
+	r.usage.mu.Lock()
+	defer r.usage.mu.Unlock()
+	for key, w := range r.usage.registrations {
+		if !now.Before(w.start.Add(quotaWindow)) {
+			delete(r.usage.registrations, key)
+		}
+	}
+}
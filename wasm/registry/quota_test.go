@@ -0,0 +1,86 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// quotaError returns the *registry.QuotaError err carries, failing the
+// test without one.
+func quotaError(t *testing.T, err error, quota string) *registry.QuotaError {
+	t.Helper()
+	var qe *registry.QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, registry.ErrQuotaExceeded) || qe.Quota != quota {
+		t.Fatalf("got %v, want a %s quota error", err, quota)
+	}
+	return qe
+}
+
+func TestColonyQuota(t *testing.T) {
+	reg, _, now := newRegistry(t)
+	ctx := context.Background()
+	if err := reg.SetColonyQuota(ctx, &registry.ColonyQuota{ReefID: "reef", ColonyID: "colony", MaxAgents: 2, RegistrationsPerMinute: 3, MaxWatches: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	register(t, reg, "one", 60)
+	register(t, reg, "two", 60)
+	_, err := reg.Register(as("three", registry.IntentRegister), "", &registry.Record{
+		AgentID: "three", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.1:9000"},
+	})
+	quotaError(t, err, registry.QuotaAgents)
+
+	// The rejected registration counted as the third this minute.
+	_, err = reg.Register(as("one", registry.IntentRegister), "", &registry.Record{
+		AgentID: "one", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.1:9000"},
+	})
+	if qe := quotaError(t, err, registry.QuotaRegistrations); qe.RetryAfter != time.Minute {
+		t.Fatalf("retry after %v, want 1m", qe.RetryAfter)
+	}
+	now.Advance(time.Minute)
+	register(t, reg, "one", 60)
+
+	watchCtx, cancel := context.WithCancel(as("one", registry.IntentRegister))
+	if _, err := reg.WatchFrom(watchCtx, registry.Filter{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reg.WatchFrom(as("two", registry.IntentRegister), registry.Filter{}, "")
+	quotaError(t, err, registry.QuotaWatches)
+
+	status, err := reg.ColonyQuotaStatus(ctx, "reef", "colony")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Custom || status.Usage != (registry.QuotaUsage{Agents: 2, Registrations: 1, Watches: 1}) {
+		t.Fatalf("status %+v", status)
+	}
+
+	// Closed streams give their slot back.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		status, err := reg.ColonyQuotaStatus(ctx, "reef", "colony")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Usage.Watches == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch stream still counted after its context ended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := reg.ResetColonyQuota(ctx, "reef", "colony"); err != nil {
+		t.Fatal(err)
+	}
+	register(t, reg, "three", 60)
+	if err := reg.ResetColonyQuota(ctx, "reef", "colony"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("reset of a default quota: %v, want ErrNotFound", err)
+	}
+}
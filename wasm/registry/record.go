@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxTTLSeconds is the longest TTL whose duration fits a time.Duration.
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+// maxMetadataSize bounds the encoded size of Record.Metadata.
+const maxMetadataSize = 16 << 10
+
+// Record describes a registered agent.
+type Record struct {
+	AgentID      string    `json:"agent_id"`
+	ColonyID     string    `json:"colony_id"`
+	ReefID       string    `json:"reef_id"`
+	Endpoints    []string  `json:"endpoints"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	TTLSeconds   int       `json:"ttl_seconds"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	// ObservedEndpoints are public endpoints the server inferred from the
+	// address the registration came from (see ObservedEndpoints). They
+	// are set by the server; values sent by agents are discarded.
+	ObservedEndpoints []string `json:"observed_endpoints,omitempty"`
+
+	// Location is the agent's coarse position, for ranking peers by
+	// proximity (see Nearest).
+	Location *Location `json:"location,omitempty"`
+
+	// RTT holds round trip times in milliseconds the agent measured to
+	// peers, by agent ID. Measurements beat Location when ranking.
+	RTT map[string]int `json:"rtt_ms,omitempty"`
+
+	// Load is the load the agent last reported, by registering or with
+	// Heartbeat.
+	Load *Load `json:"load,omitempty"`
+
+	// Publishes and Subscribes name the pub/sub topics the agent
+	// produces and consumes, for lookups and watches by topic (see
+	// ByTopic).
+	Publishes  []string `json:"publishes,omitempty"`
+	Subscribes []string `json:"subscribes,omitempty"`
+
+	// Metadata is a JSON object the agent describes itself with, for
+	// consumers to read. It must match its colony's schema, when the
+	// colony has registered one (see SetColonySchema).
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// Envelope is the agent's signature over the record, when it signed
+	// it (see Sign).
+	Envelope *Envelope `json:"envelope,omitempty"`
+
+	// Seq counts the writes to the record: the registry stores every
+	// registration, renewal and lease change one above the record it
+	// replaces. A registration or renewal naming the Seq it read is
+	// refused with ErrStaleRecord once the record has moved past it, so
+	// writers can read, modify and write the record without undoing each
+	// other's writes. Zero writes unconditionally.
+	Seq uint64 `json:"seq,omitempty"`
+
+	// Version stamps the record's last write for replication (see
+	// Apply). It is set by the registry; values sent by agents are
+	// discarded.
+	Version Timestamp `json:"version,omitzero"`
+}
+
+// Validate checks that the record carries the fields required to register.
+func (r *Record) Validate() error {
+	if r.AgentID == "" {
+		return errors.New("agent_id is required")
+	}
+	if strings.Contains(r.AgentID, "/") {
+		return errors.New("agent_id must not contain '/'")
+	}
+	if r.ColonyID == "" {
+		return errors.New("colony_id is required")
+	}
+	if r.ReefID == "" {
+		return errors.New("reef_id is required")
+	}
+	if strings.Contains(r.ReefID, "/") {
+		return errors.New("reef_id must not contain '/'")
+	}
+	if len(r.Endpoints) == 0 {
+		return errors.New("at least one endpoint is required")
+	}
+	if r.TTLSeconds < 0 {
+		return errors.New("ttl_seconds must not be negative")
+	}
+	if int64(r.TTLSeconds) > maxTTLSeconds {
+		return fmt.Errorf("ttl_seconds must not exceed %d", maxTTLSeconds)
+	}
+	if err := r.Location.validate(); err != nil {
+		return err
+	}
+	if err := r.Load.validate(); err != nil {
+		return err
+	}
+	if err := validateServices(r.Capabilities); err != nil {
+		return err
+	}
+	if err := validateTopics("publishes", r.Publishes); err != nil {
+		return err
+	}
+	if err := validateTopics("subscribes", r.Subscribes); err != nil {
+		return err
+	}
+	if len(r.RTT) > maxRTTProbes {
+		return fmt.Errorf("at most %d rtt_ms entries are allowed", maxRTTProbes)
+	}
+	for _, ms := range r.RTT {
+		if ms < 0 {
+			return errors.New("rtt_ms must not be negative")
+		}
+	}
+	if len(r.Metadata) > maxMetadataSize {
+		return fmt.Errorf("metadata must not exceed %d bytes", maxMetadataSize)
+	}
+	if len(r.Metadata) > 0 {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(r.Metadata, &obj); err != nil || obj == nil {
+			return errors.New("metadata must be a JSON object")
+		}
+	}
+	return nil
+}
+
+// Expired reports whether the record has passed its expiry time.
+func (r *Record) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// HasCapability reports whether the record advertises capability.
+func (r *Record) HasCapability(capability string) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the record.
+func (r *Record) Clone() *Record {
+	c := *r
+	c.Endpoints = append([]string(nil), r.Endpoints...)
+	c.Capabilities = append([]string(nil), r.Capabilities...)
+	c.ObservedEndpoints = append([]string(nil), r.ObservedEndpoints...)
+	c.Publishes = append([]string(nil), r.Publishes...)
+	c.Subscribes = append([]string(nil), r.Subscribes...)
+	c.Metadata = bytes.Clone(r.Metadata)
+	if r.Location != nil {
+		l := *r.Location
+		c.Location = &l
+	}
+	c.RTT = maps.Clone(r.RTT)
+	c.Envelope = r.Envelope.clone()
+	if r.Load != nil {
+		l := *r.Load
+		c.Load = &l
+	}
+	return &c
+}
@@ -0,0 +1,228 @@
+package registry_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// quotas allows each reef a fixed number of agents.
+type quotas map[string]int
+
+func (q quotas) MaxAgents(reefID string) int { return q[reefID] }
+
+// inReef returns a context acting as agentID of reefID with intent.
+func inReef(reefID, agentID, intent string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{
+		Method:         auth.MethodTicket,
+		ReferralClaims: &jwt.ReferralClaims{ReefID: reefID, ColonyID: "colony", AgentID: agentID, Intent: intent},
+	})
+}
+
+func registerIn(reg *registry.Registry, reefID, agentID, endpoint string) error {
+	_, err := reg.Register(inReef(reefID, agentID, registry.IntentRegister), "", &registry.Record{
+		AgentID: agentID, ColonyID: "colony", ReefID: reefID, Endpoints: []string{endpoint},
+	})
+	return err
+}
+
+// TestReefIsolation registers the same agent and colony IDs in two reefs
+// and checks that neither reef sees or removes the other's.
+func TestReefIsolation(t *testing.T) {
+	reg, _, _ := newRegistry(t)
+	ctx := context.Background()
+	if err := registerIn(reg, "a", "agent", "10.0.0.1:9000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerIn(reg, "b", "agent", "10.0.0.2:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	for reef, want := range map[string]string{"a": "10.0.0.1:9000", "b": "10.0.0.2:9000"} {
+		rec, err := reg.Lookup(ctx, reef, "agent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.ReefID != reef || rec.Endpoints[0] != want {
+			t.Fatalf("reef %s: got %s %v, want %s", reef, rec.ReefID, rec.Endpoints, want)
+		}
+		recs, err := reg.List(ctx, reef, "colony")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != 1 {
+			t.Fatalf("reef %s lists %d agents, want 1", reef, len(recs))
+		}
+	}
+	if _, err := reg.Lookup(ctx, "", "agent"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("lookup without reef: %v, want ErrNotFound", err)
+	}
+
+	if err := reg.Deregister(inReef("a", "agent", registry.IntentRegister), "", "agent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Lookup(ctx, "a", "agent"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("deregistered agent: %v, want ErrNotFound", err)
+	}
+	if _, err := reg.Lookup(ctx, "b", "agent"); err != nil {
+		t.Fatalf("agent of the other reef: %v", err)
+	}
+}
+
+// TestColonyIsolation checks that a ticket for another colony of the
+// reef neither replaces an agent's record nor bypasses its own colony's
+// quota by claiming the agent's ID.
+func TestColonyIsolation(t *testing.T) {
+	reg, _, _ := newRegistry(t)
+	ctx := context.Background()
+	if err := registerIn(reg, "reef", "agent", "10.0.0.1:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := auth.NewContext(ctx, &auth.Principal{
+		Method:         auth.MethodTicket,
+		ReferralClaims: &jwt.ReferralClaims{ReefID: "reef", ColonyID: "other", AgentID: "agent", Intent: registry.IntentRegister},
+	})
+	_, err := reg.Register(other, "", &registry.Record{
+		AgentID: "agent", ColonyID: "other", ReefID: "reef", Endpoints: []string{"10.6.6.6:9000"},
+	})
+	if !errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("registration from another colony: %v, want ErrForbidden", err)
+	}
+	rec, err := reg.Lookup(ctx, "reef", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ColonyID != "colony" || rec.Endpoints[0] != "10.0.0.1:9000" {
+		t.Fatalf("record replaced: %s %v", rec.ColonyID, rec.Endpoints)
+	}
+}
+
+// TestSnapshotReefScope checks that a reef's snapshot holds only that
+// reef, that restoring it leaves other reefs alone, and that a snapshot
+// of another reef is refused.
+func TestSnapshotReefScope(t *testing.T) {
+	reg, _, now := newRegistry(t)
+	ctx := context.Background()
+	for _, reef := range []string{"a", "b"} {
+		if err := registerIn(reg, reef, "agent", "10.0.0.1:9000"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var a, all bytes.Buffer
+	if err := reg.Snapshot(ctx, "a", &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Snapshot(ctx, "", &all); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registerIn(reg, "a", "later", "10.0.0.2:9000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerIn(reg, "b", "later", "10.0.0.2:9000"); err != nil {
+		t.Fatal(err)
+	}
+	now.Advance(time.Minute)
+	takenAt, n, err := reg.Restore(ctx, "a", bytes.NewReader(a.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || !takenAt.Equal(now.Now().Add(-time.Minute)) {
+		t.Fatalf("restored %d records taken at %v, want 1 at %v", n, takenAt, now.Now().Add(-time.Minute))
+	}
+	if _, err := reg.Lookup(ctx, "a", "later"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("agent registered after the snapshot: %v, want ErrNotFound", err)
+	}
+	if _, err := reg.Lookup(ctx, "b", "later"); err != nil {
+		t.Fatalf("agent of the other reef: %v", err)
+	}
+
+	if _, _, err := reg.Restore(ctx, "b", bytes.NewReader(a.Bytes())); !errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("restore of reef a into reef b: %v, want ErrForbidden", err)
+	}
+	if _, _, err := reg.Restore(ctx, "a", bytes.NewReader(all.Bytes())); !errors.Is(err, rbac.ErrForbidden) {
+		t.Fatalf("restore of every reef into reef a: %v, want ErrForbidden", err)
+	}
+	if _, err := reg.Lookup(ctx, "b", "later"); err != nil {
+		t.Fatalf("agent of reef b after refused restores: %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	st := store.NewMemory()
+	reg, err := registry.New(registry.Config{Store: st, Verifier: noTickets{}, Quotas: quotas{"a": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"one", "two"} {
+		if err := registerIn(reg, "a", id, "10.0.0.1:9000"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registerIn(reg, "a", "three", "10.0.0.1:9000"); !errors.Is(err, registry.ErrQuotaExceeded) {
+		t.Fatalf("registration over quota: %v, want ErrQuotaExceeded", err)
+	}
+	// Agents already registered update their records; other reefs have
+	// their own quota.
+	if err := registerIn(reg, "a", "two", "10.0.0.2:9000"); err != nil {
+		t.Fatalf("update within quota: %v", err)
+	}
+	if err := registerIn(reg, "b", "three", "10.0.0.1:9000"); err != nil {
+		t.Fatalf("registration in unlimited reef: %v", err)
+	}
+}
+
+// TestMigrate stores a record under the key used before reefs were kept
+// apart and checks that Migrate moves it once.
+func TestMigrate(t *testing.T) {
+	reg, st, now := newRegistry(t)
+	ctx := context.Background()
+	legacy, err := json.Marshal(&registry.Record{
+		AgentID: "agent", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.1:9000"},
+		UpdatedAt: now.Now(), ExpiresAt: now.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Put(ctx, "agents/agent", legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	for want := 1; want >= 0; want-- {
+		moved, err := reg.Migrate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != want {
+			t.Fatalf("moved %d records, want %d", moved, want)
+		}
+	}
+	if _, err := reg.Lookup(ctx, "reef", "agent"); err != nil {
+		t.Fatalf("migrated record: %v", err)
+	}
+	if _, err := st.Get(ctx, "agents/agent"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("legacy key: %v, want ErrNotFound", err)
+	}
+}
+
+// TestSlashInID checks that agent and reef IDs holding "/" are refused.
+func TestSlashInID(t *testing.T) {
+	reg, _, _ := newRegistry(t)
+	if err := registerIn(reg, "a", "web/1", "10.0.0.1:9000"); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("agent web/1: %v, want ErrInvalidRecord", err)
+	}
+	if err := registerIn(reg, "a/b", "web", "10.0.0.1:9000"); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("reef a/b: %v, want ErrInvalidRecord", err)
+	}
+}
@@ -0,0 +1,566 @@
+// Package registry implements the discovery registry: agents register
+// their endpoints and capabilities under a referral ticket and peers look
+// them up by agent or colony.
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Intents accepted by the registry.
+const (
+	IntentRegister = "register"
+	IntentRenew    = "renew"
+)
+
+// DefaultTTL is used when neither the record nor the Config sets a TTL.
+const DefaultTTL = 5 * time.Minute
+
+var (
+	// ErrNotFound is returned when an agent is not registered.
+	ErrNotFound = errors.New("agent not found")
+
+	// ErrUnauthorized is returned when a referral ticket is missing,
+	// invalid or does not cover the requested operation.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrInvalidRecord is returned when a record fails validation.
+	ErrInvalidRecord = errors.New("invalid record")
+
+	// ErrLeaseExpired is returned when renewing a lease that has passed
+	// its grace period. The agent must register again.
+	ErrLeaseExpired = errors.New("lease expired")
+
+	// ErrQuotaExceeded is returned, wrapped in a *QuotaError, for
+	// registrations and watches over a reef or colony quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrStaleRecord is returned for registrations and renewals naming a
+	// Seq the record has moved past (see Record.Seq). The writer should
+	// read the record again before deciding whether to write.
+	ErrStaleRecord = errors.New("stale record")
+)
+
+// Verifier validates referral tickets. *jwt.Validator satisfies it.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Config holds the configuration for a Registry.
+type Config struct {
+	// Store persists records. Defaults to an in-memory store.
+	Store store.Store
+
+	// Verifier validates referral tickets presented on registration.
+	Verifier Verifier
+
+	// DefaultTTL applies to records registered without a TTL.
+	DefaultTTL time.Duration
+
+	// GracePeriod is how long an expired record is kept before the reaper
+	// evicts it. A Renew within the grace period revives the lease.
+	GracePeriod time.Duration
+
+	// ReplicaID names this registry among the replicas of an
+	// active-active deployment and breaks ties between writes stamped at
+	// the same time.
+	ReplicaID string
+
+	// TombstoneTTL is how long the tombstones deregistrations and
+	// evictions leave are kept, for the other replicas (see Apply) and
+	// for watchers and holders of deltas catching up (see Delta). The
+	// reaper compacts them afterwards. Defaults to DefaultTombstoneTTL.
+	TombstoneTTL time.Duration
+
+	// WatchRetention is the least number of recent changes kept for
+	// watchers resuming with WatchFrom. Defaults to
+	// DefaultWatchRetention.
+	WatchRetention int
+
+	// Metrics receives the registry's registration, lookup, lease
+	// expiration and watch metrics when set.
+	Metrics *metrics.Set
+
+	// Tracer records spans for registrations, lookups, listings and
+	// ticket verification when set.
+	Tracer *tracing.Tracer
+
+	// Audit records registrations, deregistrations and lease
+	// expirations when set.
+	Audit *audit.Log
+
+	// RBAC decides which roles may deregister agents other than their
+	// own. Defaults to rbac.DefaultPolicy.
+	RBAC *rbac.Policy
+
+	// Limiter charges registrations, renewals and deregistrations to the
+	// ticket's agent, colony and reef under ratelimit.Register when set.
+	Limiter *ratelimit.Limiter
+
+	// Quotas bounds the agents each reef may register when set.
+	Quotas Quotas
+
+	// DefaultColonyQuota applies to colonies without a quota of their
+	// own (see SetColonyQuota). Its ReefID and ColonyID are ignored. The
+	// zero value sets no limits.
+	DefaultColonyQuota ColonyQuota
+
+	// AllowlistKeys verify the endpoint allowlists colonies publish (see
+	// PublishAllowlist), usually the keys verifying their tickets.
+	// Without them no allowlist can be published.
+	AllowlistKeys verify.KeySource
+
+	// RequireAllowlist refuses registrations in colonies that have not
+	// published an endpoint allowlist.
+	RequireAllowlist bool
+
+	// RequireSignedRecords refuses records their agents have not signed
+	// (see Record.Sign).
+	RequireSignedRecords bool
+
+	// Clock is the time source of lease expiry and of the hybrid logical
+	// clock stamping writes for replication. Defaults to clock.System;
+	// tests may use a clock.Fake.
+	Clock clock.Clock
+}
+
+// Registry is the discovery registry.
+type Registry struct {
+	store            store.Store
+	verifier         Verifier
+	defaultTTL       time.Duration
+	gracePeriod      time.Duration
+	replicaID        string
+	tombstoneTTL     time.Duration
+	clock            *Clock
+	hub              *hub
+	metrics          instruments
+	tracer           *tracing.Tracer
+	audit            *audit.Log
+	rbac             *rbac.Policy
+	limiter          *ratelimit.Limiter
+	quotas           Quotas
+	defaultQuota     ColonyQuota
+	usage            *usage
+	allowlistKeys    verify.KeySource
+	requireAllowlist bool
+	requireSigned    bool
+	wall             clock.Clock
+	draining         atomic.Bool
+
+	// writes is held for reading across every store write of a record
+	// or tombstone, so that Changes can wait out those in flight.
+	writes sync.RWMutex
+}
+
+// New creates a Registry from cfg.
+func New(cfg Config) (*Registry, error) {
+	if cfg.Verifier == nil {
+		return nil, fmt.Errorf("registry: verifier is required")
+	}
+	if cfg.Store == nil {
+		cfg.Store = store.NewMemory()
+	}
+	if cfg.DefaultTTL == 0 {
+		cfg.DefaultTTL = DefaultTTL
+	}
+	if cfg.TombstoneTTL == 0 {
+		cfg.TombstoneTTL = DefaultTombstoneTTL
+	}
+	if cfg.WatchRetention <= 0 {
+		cfg.WatchRetention = DefaultWatchRetention
+	}
+
+	wall := clock.Or(cfg.Clock)
+	h := newHub(cfg.WatchRetention, wall.Now)
+	return &Registry{
+		store:            cfg.Store,
+		verifier:         cfg.Verifier,
+		defaultTTL:       cfg.DefaultTTL,
+		gracePeriod:      cfg.GracePeriod,
+		replicaID:        cfg.ReplicaID,
+		tombstoneTTL:     cfg.TombstoneTTL,
+		clock:            &Clock{node: cfg.ReplicaID, now: wall.Now},
+		hub:              h,
+		metrics:          newInstruments(cfg.Metrics, h),
+		tracer:           cfg.Tracer,
+		audit:            cfg.Audit,
+		rbac:             cfg.RBAC,
+		limiter:          cfg.Limiter,
+		quotas:           cfg.Quotas,
+		defaultQuota:     cfg.DefaultColonyQuota,
+		usage:            newUsage(),
+		allowlistKeys:    cfg.AllowlistKeys,
+		requireAllowlist: cfg.RequireAllowlist,
+		requireSigned:    cfg.RequireSignedRecords,
+		wall:             wall,
+	}, nil
+}
+
+// ReplicaID returns the Config's ReplicaID.
+func (r *Registry) ReplicaID() string {
+	return r.replicaID
+}
+
+// pingKey is read by Ping. Nothing is stored under it.
+const pingKey = "ping"
+
+// Ping checks that the registry's store is reachable, by reading a key.
+func (r *Registry) Ping(ctx context.Context) error {
+	if _, err := r.store.Get(ctx, pingKey); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("store unreachable: %w", err)
+	}
+	return nil
+}
+
+// Register creates or updates rec on behalf of the holder of ticket. The
+// ticket must carry the "register" intent and name the same reef, colony
+// and agent as the record, and the colony's endpoint allowlist, if it
+// has published one, must allow the record's endpoints. A record the
+// agent signed (see Record.Sign) must be signed with the key the ticket
+// is bound to, if any, and replaces a signed record only when signed
+// with the same key at a higher envelope seq. A record naming a Seq is
+// refused with ErrStaleRecord unless the stored record is still at it,
+// and the stored record is written at the next Seq. Register and the
+// other writes act for the principal of ctx instead, when it carries
+// one (see auth.NewContext).
+func (r *Registry) Register(ctx context.Context, ticket string, rec *Record) (*Record, error) {
+	ctx, span := r.tracer.Start(ctx, tracing.Internal, "registry register", tracing.String("agent.id", rec.AgentID))
+	stored, err := r.register(ctx, ticket, rec)
+	span.SetError(err)
+	span.End()
+	r.metrics.registrations.Inc(registrationResult(err))
+	ev := audit.Event{Type: audit.AgentRegistered, Outcome: audit.Success, Actor: rec.AgentID, Reef: rec.ReefID, Colony: rec.ColonyID, Subject: rec.AgentID}
+	if err != nil {
+		ev.Outcome, ev.Reason = audit.Failure, err.Error()
+	}
+	_ = r.audit.Record(ctx, ev)
+	return stored, err
+}
+
+func (r *Registry) register(ctx context.Context, ticket string, rec *Record) (*Record, error) {
+	if r.draining.Load() {
+		return nil, ErrDraining
+	}
+	if err := rec.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	claims, err := r.authorize(ctx, ticket, IntentRegister)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ReefID != rec.ReefID || claims.ColonyID != rec.ColonyID || claims.AgentID != rec.AgentID {
+		return nil, fmt.Errorf("%w: ticket does not match record identity", ErrUnauthorized)
+	}
+	if err := r.checkEnvelope(claims, rec); err != nil {
+		return nil, err
+	}
+	if err := r.checkEndpoints(ctx, rec); err != nil {
+		return nil, err
+	}
+	if err := r.checkMetadata(ctx, rec); err != nil {
+		return nil, err
+	}
+	quota, err := r.ColonyQuota(ctx, rec.ReefID, rec.ColonyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.chargeRegistration(quota); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		stored, err := r.upsert(ctx, rec, quota)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.hub.publish(Event{Type: EventPut, Record: stored})
+		return stored.Clone(), nil
+	}
+}
+
+// upsert writes rec, preserving the creation time of an existing record.
+// New agents must fit the colony's quota q, and a signed record may only
+// be replaced by its successor (see checkSuccession). A nonzero rec.Seq
+// must be the stored record's. An agent registered in another colony is
+// not replaced.
+func (r *Registry) upsert(ctx context.Context, rec *Record, q *ColonyQuota) (*Record, error) {
+	existing, revision, err := r.loadRecord(ctx, rec.ReefID, rec.AgentID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.ColonyID != rec.ColonyID {
+		return nil, fmt.Errorf("%w: agent %s is registered in colony %s", rbac.ErrForbidden, rec.AgentID, existing.ColonyID)
+	}
+	if err := checkSeq(rec.AgentID, existing, rec.Seq); err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		if err := r.admit(ctx, rec, q); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSuccession(existing, rec); err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	stored := rec.Clone()
+	if stored.TTLSeconds == 0 {
+		stored.TTLSeconds = int(r.defaultTTL / time.Second)
+	}
+	stored.CreatedAt = now
+	stored.Seq = 1
+	if existing != nil {
+		stored.CreatedAt = existing.CreatedAt
+		stored.Seq = existing.Seq + 1
+	}
+	stored.UpdatedAt = now
+	stored.Location.coarsen()
+	stored.ExpiresAt = now.Add(time.Duration(stored.TTLSeconds) * time.Second)
+	stored.Version = r.clock.Now()
+
+	if err := r.saveRecord(ctx, stored, revision); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// checkSeq returns ErrStaleRecord unless seq is zero or the Seq of
+// existing, the stored record of agentID, if any.
+func checkSeq(agentID string, existing *Record, seq uint64) error {
+	switch {
+	case seq == 0:
+		return nil
+	case existing == nil:
+		return fmt.Errorf("%w: agent %s is no longer registered", ErrStaleRecord, agentID)
+	case existing.Seq != seq:
+		return fmt.Errorf("%w: agent %s is at seq %d, not %d", ErrStaleRecord, agentID, existing.Seq, seq)
+	}
+	return nil
+}
+
+// Deregister removes agentID from the ticket's reef. The ticket must be
+// valid for the agent's colony and name the agent, unless its roles
+// grant rbac.PermEvictAgents.
+func (r *Registry) Deregister(ctx context.Context, ticket, agentID string) error {
+	claims, err := r.deregister(ctx, ticket, agentID)
+	ev := audit.FromClaims(audit.AgentDeregistered, audit.Success, claims)
+	ev.Subject = agentID
+	if err != nil {
+		ev.Outcome, ev.Reason = audit.Failure, err.Error()
+	}
+	_ = r.audit.Record(ctx, ev)
+	return err
+}
+
+// deregister removes agentID and returns the claims of ticket. Agents
+// may only remove themselves unless the ticket's roles grant
+// rbac.PermEvictAgents.
+func (r *Registry) deregister(ctx context.Context, ticket, agentID string) (*jwt.ReferralClaims, error) {
+	p, err := r.authorize(ctx, ticket, "")
+	if err != nil {
+		if p != nil {
+			return p.ReferralClaims, err
+		}
+		return nil, err
+	}
+	claims := p.ReferralClaims
+
+	rec, _, err := r.loadRecord(ctx, claims.ReefID, agentID)
+	if err != nil {
+		return claims, err
+	}
+	if claims.ColonyID != rec.ColonyID {
+		return claims, fmt.Errorf("%w: ticket does not cover colony %s", ErrUnauthorized, rec.ColonyID)
+	}
+	if claims.AgentID != rec.AgentID {
+		if err := r.rbac.AuthorizeRoles(p.Roles, rbac.PermEvictAgents); err != nil {
+			return claims, fmt.Errorf("%w: ticket for %s cannot deregister %s: %w", ErrUnauthorized, claims.AgentID, rec.AgentID, err)
+		}
+	}
+	return claims, r.remove(ctx, rec)
+}
+
+// remove deletes rec, leaving a tombstone for the other replicas and
+// for watchers that missed the deletion.
+func (r *Registry) remove(ctx context.Context, rec *Record) error {
+	tomb := &Tombstone{
+		AgentID:   rec.AgentID,
+		ColonyID:  rec.ColonyID,
+		ReefID:    rec.ReefID,
+		Version:   r.clock.Now(),
+		DeletedAt: r.now(),
+	}
+	if err := r.saveTombstone(ctx, tomb); err != nil {
+		return err
+	}
+	if err := r.deleteRecord(ctx, rec.ReefID, rec.AgentID); err != nil {
+		return err
+	}
+	r.hub.publish(Event{Type: EventDelete, Record: rec})
+	return nil
+}
+
+// Lookup returns the live record for agentID of reefID. Agents of other
+// reefs are not found.
+func (r *Registry) Lookup(ctx context.Context, reefID, agentID string) (*Record, error) {
+	defer r.metrics.lookups.Since(time.Now(), "lookup")
+	ctx, span := r.tracer.Start(ctx, tracing.Internal, "registry lookup", tracing.String("agent.id", agentID))
+	defer span.End()
+	rec, _, err := r.loadRecord(ctx, reefID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Expired(r.now()) {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// List returns the live records in colonyID of reefID.
+func (r *Registry) List(ctx context.Context, reefID, colonyID string) ([]*Record, error) {
+	defer r.metrics.lookups.Since(time.Now(), "list")
+	ctx, span := r.tracer.Start(ctx, tracing.Internal, "registry list", tracing.String("colony.id", colonyID))
+	defer span.End()
+	recs, err := r.listRecords(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	live := recs[:0]
+	for _, rec := range recs {
+		if !rec.Expired(now) {
+			live = append(live, rec)
+		}
+	}
+	return live, nil
+}
+
+// Query returns the live records in colonyID of reefID whose labels
+// match sel.
+func (r *Registry) Query(ctx context.Context, reefID, colonyID string, sel *Selector) ([]*Record, error) {
+	recs, err := r.List(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+	matched := recs[:0]
+	for _, rec := range recs {
+		if sel.Matches(rec) {
+			matched = append(matched, rec)
+		}
+	}
+	return matched, nil
+}
+
+// Watch streams changes to records matching filter until ctx is done. The
+// channel is closed when the subscription ends, including when the
+// subscriber falls too far behind.
+func (r *Registry) Watch(ctx context.Context, filter Filter) <-chan Event {
+	events, _ := r.hub.subscribe(ctx, filter, "", nil)
+	return events
+}
+
+// WatchFrom is Watch, resuming after the event with cursor: the kept
+// changes since that match filter are delivered first. Changes no longer
+// kept (see Config.WatchRetention), or made before a restart or on
+// another instance sharing the store, are replayed from the stored
+// records and tombstones instead, as puts, expires and deletes in no
+// particular order, carrying cursor. It returns ErrCursorExpired when
+// the tombstones since are no longer kept either (see
+// Config.TombstoneTTL). When ctx carries a principal (see
+// auth.NewContext), the stream counts against the MaxWatches of its
+// colony until ctx is done, and is refused with a *QuotaError beyond it.
+func (r *Registry) WatchFrom(ctx context.Context, filter Filter, cursor string) (<-chan Event, error) {
+	release, err := r.holdWatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events, err := r.hub.subscribe(ctx, filter, cursor, nil)
+	if errors.Is(err, ErrCursorExpired) {
+		events, err = r.replay(ctx, filter, cursor, err)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return events, nil
+}
+
+// Follow calls fn with each change matching filter until ctx is done or
+// the registry drains. When fn falls too far behind and the watch is
+// closed, it resumes after the last change delivered, or from the current
+// state when the changes since are no longer kept.
+func (r *Registry) Follow(ctx context.Context, filter Filter, fn func(Event)) {
+	var cursor string
+	for ctx.Err() == nil {
+		events, err := r.WatchFrom(ctx, filter, cursor)
+		if err != nil {
+			cursor = ""
+			continue
+		}
+		for ev := range events {
+			if ev.Type == EventDrain {
+				return
+			}
+			fn(ev)
+			cursor = ev.Cursor
+		}
+	}
+}
+
+// now reads the registry's clock.
+func (r *Registry) now() time.Time {
+	return r.wall.Now()
+}
+
+// authorize authenticates the caller, by the principal of ctx when the
+// request was authenticated already (see package auth) or else by
+// ticket, and, when intent is non-empty, checks that it may act with
+// it, then charges the write to the limiter.
+func (r *Registry) authorize(ctx context.Context, ticket, intent string) (*auth.Principal, error) {
+	p := auth.FromContext(ctx)
+	if p == nil {
+		if ticket == "" {
+			return nil, fmt.Errorf("%w: referral ticket is required", ErrUnauthorized)
+		}
+		_, span := r.tracer.Start(ctx, tracing.Internal, "verify ticket")
+		var err error
+		p, err = auth.Ticket(r.verifier, ticket)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+	}
+	if intent != "" && p.Intent != intent {
+		return nil, fmt.Errorf("%w: ticket intent %q, want %q", ErrUnauthorized, p.Intent, intent)
+	}
+	// Only writes present tickets to the registry.
+	if err := r.limiter.Allow(ratelimit.Register, ratelimit.Key{ReefID: p.ReefID, AgentID: p.AgentID, ColonyID: p.ColonyID}); err != nil {
+		return p, err
+	}
+	return p, nil
+}
@@ -0,0 +1,260 @@
+package registry
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Registries sharing records across regions replicate each other's
+// writes asynchronously: every write is stamped with the writing
+// replica's hybrid logical clock, replicas exchange the writes with
+// Changes and Apply, and the later write of an agent wins. Deregistrations
+// leave tombstones so a deleted record is not revived by a replica that
+// has not seen the deletion yet. Leases expire the same way everywhere,
+// so evictions are not replicated.
+
+// DefaultTombstoneTTL is how long tombstones are kept when the Config
+// does not say. Replicas partitioned for longer may revive deleted
+// records until their leases run out, and watchers disconnected for
+// longer must list the registry again.
+const DefaultTombstoneTTL = 24 * time.Hour
+
+// tombstonePrefix is the store key prefix for tombstones, kept by reef
+// like records.
+const tombstonePrefix = "tombstones/"
+
+func tombstoneKey(reefID, agentID string) string {
+	return tombstonePrefix + reefID + "/" + agentID
+}
+
+// Tombstone records the deregistration or eviction of an agent.
+type Tombstone struct {
+	AgentID   string    `json:"agent_id"`
+	ColonyID  string    `json:"colony_id"`
+	ReefID    string    `json:"reef_id"`
+	Version   Timestamp `json:"version"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Change is a replicated write: a record or, for deregistrations, a
+// tombstone.
+type Change struct {
+	Record    *Record    `json:"record,omitempty"`
+	Tombstone *Tombstone `json:"tombstone,omitempty"`
+}
+
+// agent returns the reef and ID of the agent c is about.
+func (c *Change) agent() (reefID, agentID string) {
+	if c.Record != nil {
+		return c.Record.ReefID, c.Record.AgentID
+	}
+	return c.Tombstone.ReefID, c.Tombstone.AgentID
+}
+
+// version returns the timestamp of c's write.
+func (c *Change) version() Timestamp {
+	if c.Record != nil {
+		return c.Record.Version
+	}
+	return c.Tombstone.Version
+}
+
+// Changes returns up to limit writes stored after revision after, in
+// revision order, and the revision to pass as after to continue. Writes
+// applied from other replicas are included, so replicas may relay each
+// other's changes. Revisions are local to the store: a replica pulling
+// from r keeps one cursor per peer.
+//
+// A listing may miss a write still in flight yet include a later one,
+// which would move the puller's cursor past the missed write for good.
+// So the changes are bounded by the latest revision of a first listing
+// and read from a second, once the writes in flight during the first are
+// done. Writes of other instances sharing the store are not waited for.
+func (r *Registry) Changes(ctx context.Context, after uint64, limit int) ([]*Change, uint64, error) {
+	first, err := r.listChanges(ctx)
+	if err != nil {
+		return nil, after, err
+	}
+	bound := after
+	for _, entry := range first {
+		bound = max(bound, entry.Revision)
+	}
+	if bound == after {
+		return []*Change{}, after, nil
+	}
+	r.writes.Lock()
+	r.writes.Unlock()
+
+	listed, err := r.listChanges(ctx)
+	if err != nil {
+		return nil, after, err
+	}
+	var entries []*store.Entry
+	for _, entry := range listed {
+		if entry.Revision > after && entry.Revision <= bound {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *store.Entry) int {
+		return cmp.Compare(a.Revision, b.Revision)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	out := make([]*Change, 0, len(entries))
+	for _, entry := range entries {
+		ch := &Change{}
+		var err error
+		if strings.HasPrefix(entry.Key, tombstonePrefix) {
+			err = json.Unmarshal(entry.Value, &ch.Tombstone)
+		} else {
+			ch.Record, err = decodeRecord(entry.Value)
+		}
+		if err != nil {
+			return nil, after, fmt.Errorf("%s: %w", entry.Key, err)
+		}
+		out = append(out, ch)
+		after = entry.Revision
+	}
+	return out, after, nil
+}
+
+// listChanges lists the stored records and tombstones.
+func (r *Registry) listChanges(ctx context.Context) ([]*store.Entry, error) {
+	var entries []*store.Entry
+	for _, prefix := range []string{agentPrefix, tombstonePrefix} {
+		list, err := r.store.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changes: %w", err)
+		}
+		entries = append(entries, list...)
+	}
+	return entries, nil
+}
+
+// Apply merges a change pulled from another replica, keeping it if it is
+// later than the record or tombstone stored for the agent. Applied
+// records are published to watchers like local writes. It reports
+// whether the change won.
+func (r *Registry) Apply(ctx context.Context, ch *Change) (bool, error) {
+	switch {
+	case (ch.Record == nil) == (ch.Tombstone == nil):
+		return false, fmt.Errorf("%w: change must carry a record or a tombstone", ErrInvalidRecord)
+	case ch.Record != nil:
+		if err := ch.Record.Validate(); err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+	case ch.Tombstone.AgentID == "":
+		return false, fmt.Errorf("%w: agent_id is required", ErrInvalidRecord)
+	case ch.Tombstone.ReefID == "" || strings.Contains(ch.Tombstone.ReefID, "/"):
+		return false, fmt.Errorf("%w: invalid reef_id %q", ErrInvalidRecord, ch.Tombstone.ReefID)
+	}
+	r.clock.Observe(ch.version())
+
+	for attempt := 0; ; attempt++ {
+		applied, err := r.apply(ctx, ch)
+		if errors.Is(err, store.ErrConflict) && attempt+1 < maxWriteAttempts {
+			continue
+		}
+		return applied, err
+	}
+}
+
+func (r *Registry) apply(ctx context.Context, ch *Change) (bool, error) {
+	reefID, agentID := ch.agent()
+	version := ch.version()
+	current, revision, err := r.loadRecord(ctx, reefID, agentID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if current != nil && current.Version.Compare(version) >= 0 {
+		return false, nil
+	}
+	tomb, err := r.loadTombstone(ctx, reefID, agentID)
+	if err != nil {
+		return false, err
+	}
+	if tomb != nil && tomb.Version.Compare(version) >= 0 {
+		return false, nil
+	}
+
+	if ch.Record != nil {
+		if r.evictable(ch.Record, r.now()) {
+			return false, nil
+		}
+		rec := ch.Record.Clone()
+		if err := r.saveRecord(ctx, rec, revision); err != nil {
+			return false, err
+		}
+		r.hub.publish(Event{Type: EventPut, Record: rec})
+		return true, nil
+	}
+
+	if err := r.saveTombstone(ctx, ch.Tombstone); err != nil {
+		return false, err
+	}
+	if current != nil {
+		if err := r.deleteRecord(ctx, reefID, agentID); err != nil && !errors.Is(err, ErrNotFound) {
+			return false, err
+		}
+		r.hub.publish(Event{Type: EventDelete, Record: current})
+	}
+	return true, nil
+}
+
+// loadTombstone returns the tombstone of agentID in reefID, or nil when
+// there is none.
+func (r *Registry) loadTombstone(ctx context.Context, reefID, agentID string) (*Tombstone, error) {
+	entry, err := r.store.Get(ctx, tombstoneKey(reefID, agentID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tombstone: %w", err)
+	}
+	var tomb Tombstone
+	if err := json.Unmarshal(entry.Value, &tomb); err != nil {
+		return nil, fmt.Errorf("failed to decode tombstone: %w", err)
+	}
+	return &tomb, nil
+}
+
+// saveTombstone writes tomb.
+func (r *Registry) saveTombstone(ctx context.Context, tomb *Tombstone) error {
+	data, err := json.Marshal(tomb)
+	if err != nil {
+		return fmt.Errorf("failed to encode tombstone: %w", err)
+	}
+	r.writes.RLock()
+	_, err = r.store.Put(ctx, tombstoneKey(tomb.ReefID, tomb.AgentID), data)
+	r.writes.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to store tombstone: %w", err)
+	}
+	return nil
+}
+
+// pruneTombstones deletes tombstones older than the tombstone TTL.
+func (r *Registry) pruneTombstones(ctx context.Context, now time.Time) error {
+	entries, err := r.store.List(ctx, tombstonePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	for _, entry := range entries {
+		var tomb Tombstone
+		if json.Unmarshal(entry.Value, &tomb) == nil && now.Sub(tomb.DeletedAt) < r.tombstoneTTL {
+			continue
+		}
+		_ = r.store.Delete(ctx, entry.Key)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// schemaPrefix is where colony metadata schemas live, under
+// schemas/<reef>/<colony>.
+const schemaPrefix = "schemas/"
+
+func schemaKey(reefID, colonyID string) string {
+	return schemaPrefix + colonyKey(reefID, colonyID)
+}
+
+// ColonySchema is the JSON Schema the metadata of a colony's agents must
+// match (see package schema).
+type ColonySchema struct {
+	ReefID    string          `json:"reef_id"`
+	ColonyID  string          `json:"colony_id"`
+	Schema    json.RawMessage `json:"schema"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// SetColonySchema makes s.Schema the metadata schema of s's colony,
+// refusing schemas that do not compile with schema.ErrInvalid.
+// Registrations of the colony's agents are checked against it from then
+// on; agents registered before keep their metadata until they register
+// again.
+func (r *Registry) SetColonySchema(ctx context.Context, s *ColonySchema) error {
+	if s.ReefID == "" || s.ColonyID == "" {
+		return fmt.Errorf("%w: schema needs reef_id and colony_id", ErrInvalidRecord)
+	}
+	compiled, err := schema.Compile(s.Schema)
+	if err != nil {
+		return err
+	}
+	s.Schema, _ = compiled.MarshalJSON()
+	s.UpdatedAt = r.now().UTC()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if _, err := r.store.Put(ctx, schemaKey(s.ReefID, s.ColonyID), data); err != nil {
+		return fmt.Errorf("failed to store schema: %w", err)
+	}
+	return nil
+}
+
+// ColonySchema returns the metadata schema of colonyID in reefID, or
+// ErrNotFound.
+func (r *Registry) ColonySchema(ctx context.Context, reefID, colonyID string) (*ColonySchema, error) {
+	entry, err := r.store.Get(ctx, schemaKey(reefID, colonyID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("%w: colony %s has no metadata schema", ErrNotFound, colonyID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var s ColonySchema
+	if err := json.Unmarshal(entry.Value, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode schema: %w", err)
+	}
+	return &s, nil
+}
+
+// DeleteColonySchema removes the metadata schema of colonyID in reefID,
+// so its agents may register any metadata object. It returns ErrNotFound
+// when the colony has none.
+func (r *Registry) DeleteColonySchema(ctx context.Context, reefID, colonyID string) error {
+	err := r.store.Delete(ctx, schemaKey(reefID, colonyID))
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: colony %s has no metadata schema", ErrNotFound, colonyID)
+	}
+	return err
+}
+
+// checkMetadata returns ErrInvalidRecord, wrapping schema.ErrMismatch,
+// unless rec's metadata matches the schema of its colony. Colonies
+// without one accept any metadata object.
+func (r *Registry) checkMetadata(ctx context.Context, rec *Record) error {
+	s, err := r.ColonySchema(ctx, rec.ReefID, rec.ColonyID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	compiled, err := schema.Compile(s.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to compile the schema of colony %s: %v", rec.ColonyID, err)
+	}
+	metadata := rec.Metadata
+	if len(metadata) == 0 {
+		metadata = json.RawMessage("{}")
+	}
+	if err := compiled.Validate(metadata); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+)
+
+func registerWith(reg *registry.Registry, metadata string) error {
+	_, err := reg.Register(as("agent", registry.IntentRegister), "", &registry.Record{
+		AgentID: "agent", ColonyID: "colony", ReefID: "reef", Endpoints: []string{"10.0.0.1:9000"}, Metadata: json.RawMessage(metadata),
+	})
+	return err
+}
+
+func TestColonySchema(t *testing.T) {
+	reg, _, _ := newRegistry(t)
+	ctx := context.Background()
+
+	if err := registerWith(reg, `["not", "an", "object"]`); !errors.Is(err, registry.ErrInvalidRecord) {
+		t.Fatalf("metadata array: %v, want ErrInvalidRecord", err)
+	}
+	if err := registerWith(reg, `{"build": 7}`); err != nil {
+		t.Fatalf("metadata without schema: %v", err)
+	}
+
+	bad := &registry.ColonySchema{ReefID: "reef", ColonyID: "colony", Schema: json.RawMessage(`{"oneOf": []}`)}
+	if err := reg.SetColonySchema(ctx, bad); !errors.Is(err, schema.ErrInvalid) {
+		t.Fatalf("unsupported keyword: %v, want schema.ErrInvalid", err)
+	}
+	cs := &registry.ColonySchema{ReefID: "reef", ColonyID: "colony", Schema: json.RawMessage(`{
+		"type": "object",
+		"properties": {"build": {"type": "string", "pattern": "^[0-9a-f]{7}$"}, "gpus": {"type": "integer", "minimum": 0}},
+		"required": ["build"],
+		"additionalProperties": false
+	}`)}
+	if err := reg.SetColonySchema(ctx, cs); err != nil {
+		t.Fatal(err)
+	}
+	for _, metadata := range []string{``, `{"build": 7}`, `{"build": "abcdef0", "gpus": 1.5}`, `{"build": "abcdef0", "extra": true}`} {
+		err := registerWith(reg, metadata)
+		if !errors.Is(err, registry.ErrInvalidRecord) || !errors.Is(err, schema.ErrMismatch) {
+			t.Fatalf("metadata %q: %v, want ErrInvalidRecord and schema.ErrMismatch", metadata, err)
+		}
+	}
+	if err := registerWith(reg, `{"build": "abcdef0", "gpus": 2}`); err != nil {
+		t.Fatalf("matching metadata: %v", err)
+	}
+	rec, err := reg.Lookup(ctx, "reef", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.Metadata) != `{"build":"abcdef0","gpus":2}` {
+		t.Fatalf("stored metadata %s", rec.Metadata)
+	}
+
+	if err := reg.DeleteColonySchema(ctx, "reef", "colony"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerWith(reg, `{"build": 7}`); err != nil {
+		t.Fatalf("metadata after deleting the schema: %v", err)
+	}
+	if err := reg.DeleteColonySchema(ctx, "reef", "colony"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("deleting again: %v, want ErrNotFound", err)
+	}
+}
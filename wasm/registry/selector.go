@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Capabilities double as typed labels: "gpu=true", "region=eu-west" or
+// "service=indexer/v2" label the record with a key and value, a bare
+// "relay" with a key and no value. Selectors query those labels.
+//
+// A selector is a comma-separated list of terms, all of which must hold:
+//
+//	key                 the label is present
+//	!key                the label is absent
+//	key=value           equality (== works too)
+//	key!=value          inequality; also holds when the label is absent
+//	key in (a, b)       set membership
+//	key notin (a, b)    set exclusion; also holds when the label is absent
+//	key>=v1.2           version constraints, with >, >=, < and <=
+//
+// Versions are dotted numbers with an optional leading "v", missing
+// components counting as zero, and an optional pre-release (see
+// parseVersion). A constraint may name a service, as in
+// "service>=indexer/v2.1", which then only holds for values of the same
+// name, here indexer/v2.1 and newer.
+
+// ErrInvalidSelector is returned for selectors that do not parse.
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// Selector is a parsed label query. The zero value matches every record.
+type Selector struct {
+	terms []term
+	src   string
+}
+
+type term struct {
+	key    string
+	op     string
+	values []string
+}
+
+// ParseSelector parses s. An empty s yields a Selector matching every
+// record.
+func ParseSelector(s string) (*Selector, error) {
+	p := &selectorParser{src: s}
+	sel := &Selector{src: strings.TrimSpace(s)}
+	if sel.src == "" {
+		return sel, nil
+	}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+		}
+		sel.terms = append(sel.terms, t)
+		p.space()
+		if p.done() {
+			return sel, nil
+		}
+		if !p.accept(",") {
+			return nil, fmt.Errorf("%w: expected , at offset %d", ErrInvalidSelector, p.pos)
+		}
+	}
+}
+
+// String returns the selector as parsed, for query parameters.
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.src
+}
+
+// Matches reports whether rec's labels satisfy every term of s.
+func (s *Selector) Matches(rec *Record) bool {
+	if s == nil || len(s.terms) == 0 {
+		return true
+	}
+	labels := rec.Labels()
+	for _, t := range s.terms {
+		if !t.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Labels returns the record's capabilities as labels: "key=value"
+// entries map key to value, bare entries map to the empty string.
+func (r *Record) Labels() map[string]string {
+	labels := make(map[string]string, len(r.Capabilities))
+	for _, c := range r.Capabilities {
+		key, value, _ := strings.Cut(c, "=")
+		labels[key] = value
+	}
+	return labels
+}
+
+func (t term) matches(labels map[string]string) bool {
+	value, ok := labels[t.key]
+	switch t.op {
+	case "exists":
+		return ok
+	case "!":
+		return !ok
+	case "=":
+		return ok && value == t.values[0]
+	case "!=":
+		return !ok || value != t.values[0]
+	case "in":
+		return ok && slices.Contains(t.values, value)
+	case "notin":
+		return !ok || !slices.Contains(t.values, value)
+	}
+	if !ok {
+		return false
+	}
+	name, have, err := parseVersion(value)
+	if err != nil {
+		return false
+	}
+	wantName, want, _ := parseVersion(t.values[0])
+	if name != wantName {
+		return false
+	}
+	c := compareVersions(have, want)
+	switch t.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// selectorParser is a hand-written recursive descent parser.
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) term() (term, error) {
+	p.space()
+	if p.accept("!") {
+		key := p.word()
+		if key == "" {
+			return term{}, fmt.Errorf("expected key at offset %d", p.pos)
+		}
+		return term{key: key, op: "!"}, nil
+	}
+	key := p.word()
+	if key == "" {
+		return term{}, fmt.Errorf("expected key at offset %d", p.pos)
+	}
+	p.space()
+	for _, op := range []string{"==", "!=", ">=", "<=", "=", ">", "<"} {
+		if !p.accept(op) {
+			continue
+		}
+		p.space()
+		value := p.word()
+		if op == "==" {
+			op = "="
+		}
+		if strings.ContainsAny(op, "<>") {
+			if _, _, err := parseVersion(value); err != nil {
+				return term{}, err
+			}
+		}
+		return term{key: key, op: op, values: []string{value}}, nil
+	}
+
+	// Set operators are words, so "key in" must be followed by a space or
+	// the opening parenthesis.
+	save := p.pos
+	if op := p.word(); op == "in" || op == "notin" {
+		values, err := p.set()
+		if err != nil {
+			return term{}, err
+		}
+		return term{key: key, op: op, values: values}, nil
+	}
+	p.pos = save
+	return term{key: key, op: "exists"}, nil
+}
+
+func (p *selectorParser) set() ([]string, error) {
+	p.space()
+	if !p.accept("(") {
+		return nil, fmt.Errorf("expected ( at offset %d", p.pos)
+	}
+	var values []string
+	for {
+		p.space()
+		values = append(values, p.word())
+		p.space()
+		if p.accept(")") {
+			return values, nil
+		}
+		if !p.accept(",") {
+			return nil, fmt.Errorf("expected , or ) at offset %d", p.pos)
+		}
+	}
+}
+
+// word consumes a key or value: anything up to whitespace or one of the
+// selector's punctuation characters.
+func (p *selectorParser) word() string {
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t,()!=<>", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *selectorParser) space() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *selectorParser) accept(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.src)
+}
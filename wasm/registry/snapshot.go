@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Intents of the operator tickets that take and restore snapshots.
+const (
+	IntentBackup  = "backup"
+	IntentRestore = "restore"
+)
+
+// snapshotMagic prefixes snapshots; its last byte is the format version.
+var snapshotMagic = [4]byte{'C', 'R', 'S', '1'}
+
+// Snapshot frame types.
+const (
+	frameEnd       byte = 0
+	frameRecord    byte = 1
+	frameTombstone byte = 2
+)
+
+// maxFrameSize bounds a single record or tombstone in a snapshot.
+const maxFrameSize = 1 << 20
+
+// ErrMalformedSnapshot is returned by Restore for snapshots that are
+// truncated, corrupt or of an unknown version.
+var ErrMalformedSnapshot = errors.New("malformed registry snapshot")
+
+// Snapshot writes the stored records and tombstones of reefID to w, or
+// those of every reef when reefID is empty, as
+//
+//	"CRS1" | taken_at i64 (Unix nanoseconds)
+//	frames: type u8 | length u32 | JSON payload
+//	end:    0 u8 | count u32 | CRC-32 (IEEE) u32 of everything before
+//
+// big-endian, with type 1 for records and 2 for tombstones. Records
+// still in their grace period are included, so Restore can revive them.
+// Each key is read consistently; writes during the snapshot may or may
+// not be included.
+func (r *Registry) Snapshot(ctx context.Context, reefID string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+
+	var header [12]byte
+	copy(header[:], snapshotMagic[:])
+	binary.BigEndian.PutUint64(header[4:], uint64(r.now().UnixNano()))
+	if _, err := out.Write(header[:]); err != nil {
+		return err
+	}
+
+	count := uint32(0)
+	for _, typ := range []byte{frameRecord, frameTombstone} {
+		prefix := snapshotPrefix(typ, reefID)
+		entries, err := r.store.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", strings.TrimSuffix(prefix, "/"), err)
+		}
+		for _, entry := range entries {
+			var frame [5]byte
+			frame[0] = typ
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(entry.Value)))
+			if _, err := out.Write(frame[:]); err != nil {
+				return err
+			}
+			if _, err := out.Write(entry.Value); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	var end [9]byte
+	binary.BigEndian.PutUint32(end[1:], count)
+	if _, err := out.Write(end[:5]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(end[5:], crc.Sum32())
+	if _, err := bw.Write(end[5:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore replaces the records and tombstones of reefID, or of every reef
+// when reefID is empty, with those of a snapshot read from rd, publishing
+// the puts and deletes to watchers. The snapshot is verified in full
+// before anything is written, and refused with rbac.ErrForbidden when it
+// holds entries of another reef. It returns the time the snapshot was
+// taken and the number of records restored.
+func (r *Registry) Restore(ctx context.Context, reefID string, rd io.Reader) (time.Time, int, error) {
+	takenAt, recs, tombs, err := readSnapshot(rd)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if reefID != "" {
+		for _, rec := range recs {
+			if rec.ReefID != reefID {
+				return time.Time{}, 0, fmt.Errorf("%w: snapshot holds agent %s of reef %s", rbac.ErrForbidden, rec.AgentID, rec.ReefID)
+			}
+		}
+		for _, tomb := range tombs {
+			if tomb.ReefID != reefID {
+				return time.Time{}, 0, fmt.Errorf("%w: snapshot holds agent %s of reef %s", rbac.ErrForbidden, tomb.AgentID, tomb.ReefID)
+			}
+		}
+	}
+	if err := r.restore(ctx, reefID, recs, tombs); err != nil {
+		return time.Time{}, 0, err
+	}
+	return takenAt, len(recs), nil
+}
+
+// restore replaces the records and tombstones of reefID, or of every
+// reef when it is empty, with recs and tombs.
+func (r *Registry) restore(ctx context.Context, reefID string, recs []*Record, tombs []*Tombstone) error {
+	restored := make(map[string]bool, len(recs))
+	for _, rec := range recs {
+		restored[agentKey(rec.ReefID, rec.AgentID)] = true
+	}
+	current, err := r.scanRecords(ctx, snapshotPrefix(frameRecord, reefID), "")
+	if err != nil {
+		return err
+	}
+	for _, rec := range current {
+		if restored[agentKey(rec.ReefID, rec.AgentID)] {
+			continue
+		}
+		if err := r.deleteRecord(ctx, rec.ReefID, rec.AgentID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		r.hub.publish(Event{Type: EventDelete, Record: rec})
+	}
+	tombstones, err := r.store.List(ctx, snapshotPrefix(frameTombstone, reefID))
+	if err != nil {
+		return fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	for _, entry := range tombstones {
+		if err := r.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("failed to delete tombstone: %w", err)
+		}
+	}
+
+	for _, tomb := range tombs {
+		r.clock.Observe(tomb.Version)
+		if err := r.saveTombstone(ctx, tomb); err != nil {
+			return err
+		}
+	}
+	for _, rec := range recs {
+		r.clock.Observe(rec.Version)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+		r.writes.RLock()
+		_, err = r.store.Put(ctx, agentKey(rec.ReefID, rec.AgentID), data)
+		r.writes.RUnlock()
+		if err != nil {
+			return fmt.Errorf("failed to store record: %w", err)
+		}
+		r.hub.publish(Event{Type: EventPut, Record: rec})
+	}
+	return nil
+}
+
+// snapshotPrefix returns the store key prefix of the frames of type typ
+// in reefID, or in every reef when reefID is empty.
+func snapshotPrefix(typ byte, reefID string) string {
+	prefix := agentPrefix
+	if typ == frameTombstone {
+		prefix = tombstonePrefix
+	}
+	if reefID == "" {
+		return prefix
+	}
+	return prefix + reefID + "/"
+}
+
+// readSnapshot decodes and verifies a snapshot. Payloads are decoded
+// only once the checksum matched.
+func readSnapshot(rd io.Reader) (time.Time, []*Record, []*Tombstone, error) {
+	crc := crc32.NewIEEE()
+	in := io.TeeReader(bufio.NewReader(rd), crc)
+
+	var header [12]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil || [4]byte(header[:4]) != snapshotMagic {
+		return time.Time{}, nil, nil, fmt.Errorf("%w: bad header", ErrMalformedSnapshot)
+	}
+	takenAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[4:])))
+
+	type frame struct {
+		typ     byte
+		payload []byte
+	}
+	var frames []frame
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(in, head[:]); err != nil {
+			return time.Time{}, nil, nil, fmt.Errorf("%w: truncated", ErrMalformedSnapshot)
+		}
+		size := binary.BigEndian.Uint32(head[1:])
+		if head[0] == frameEnd {
+			sum := crc.Sum32()
+			var trailer [4]byte
+			if _, err := io.ReadFull(in, trailer[:]); err != nil {
+				return time.Time{}, nil, nil, fmt.Errorf("%w: truncated", ErrMalformedSnapshot)
+			}
+			if binary.BigEndian.Uint32(trailer[:]) != sum {
+				return time.Time{}, nil, nil, fmt.Errorf("%w: checksum mismatch", ErrMalformedSnapshot)
+			}
+			if int(size) != len(frames) {
+				return time.Time{}, nil, nil, fmt.Errorf("%w: %d entries, trailer says %d", ErrMalformedSnapshot, len(frames), size)
+			}
+			break
+		}
+		if head[0] != frameRecord && head[0] != frameTombstone {
+			return time.Time{}, nil, nil, fmt.Errorf("%w: unknown frame type %d", ErrMalformedSnapshot, head[0])
+		}
+		if size > maxFrameSize {
+			return time.Time{}, nil, nil, fmt.Errorf("%w: %d byte frame", ErrMalformedSnapshot, size)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			return time.Time{}, nil, nil, fmt.Errorf("%w: truncated", ErrMalformedSnapshot)
+		}
+		frames = append(frames, frame{typ: head[0], payload: payload})
+	}
+
+	var recs []*Record
+	var tombs []*Tombstone
+	for _, f := range frames {
+		if f.typ == frameTombstone {
+			var tomb Tombstone
+			if err := json.Unmarshal(f.payload, &tomb); err != nil || tomb.AgentID == "" {
+				return time.Time{}, nil, nil, fmt.Errorf("%w: invalid tombstone", ErrMalformedSnapshot)
+			}
+			tombs = append(tombs, &tomb)
+			continue
+		}
+		rec, err := decodeRecord(f.payload)
+		if err == nil && rec.AgentID == "" {
+			err = errors.New("record without agent_id")
+		}
+		if err != nil {
+			return time.Time{}, nil, nil, fmt.Errorf("%w: %v", ErrMalformedSnapshot, err)
+		}
+		recs = append(recs, rec)
+	}
+	return takenAt, recs, tombs, nil
+}
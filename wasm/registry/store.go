@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// agentPrefix is the store key prefix for agent records. Each reef's
+// records are kept under a prefix of their own, agents/<reef>/, so that
+// reefs sharing a deployment are listed and counted apart and an agent ID
+// taken in one reef is free in the others.
+const agentPrefix = "agents/"
+
+// maxWriteAttempts bounds read-modify-write retries on revision conflicts.
+const maxWriteAttempts = 3
+
+func agentKey(reefID, agentID string) string {
+	return reefPrefix(reefID) + agentID
+}
+
+// reefPrefix returns the store key prefix of reefID's records.
+func reefPrefix(reefID string) string {
+	return agentPrefix + reefID + "/"
+}
+
+// loadRecord reads the record for agentID of reefID together with its
+// store revision.
+func (r *Registry) loadRecord(ctx context.Context, reefID, agentID string) (*Record, uint64, error) {
+	if reefID == "" {
+		return nil, 0, ErrNotFound
+	}
+	entry, err := r.store.Get(ctx, agentKey(reefID, agentID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read record: %w", err)
+	}
+	rec, err := decodeRecord(entry.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rec, entry.Revision, nil
+}
+
+// saveRecord writes rec if its stored revision is still revision (0 for
+// a new record). It returns store.ErrConflict when another writer won.
+func (r *Registry) saveRecord(ctx context.Context, rec *Record, revision uint64) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	r.writes.RLock()
+	_, err = r.store.CompareAndSwap(ctx, agentKey(rec.ReefID, rec.AgentID), revision, data)
+	r.writes.RUnlock()
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return err
+		}
+		return fmt.Errorf("failed to store record: %w", err)
+	}
+	return nil
+}
+
+// deleteRecord removes the record for agentID of reefID.
+func (r *Registry) deleteRecord(ctx context.Context, reefID, agentID string) error {
+	err := r.store.Delete(ctx, agentKey(reefID, agentID))
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// listRecords returns every stored record of reefID in colonyID, or in
+// all its colonies when colonyID is empty, sorted by agent ID.
+func (r *Registry) listRecords(ctx context.Context, reefID, colonyID string) ([]*Record, error) {
+	if reefID == "" {
+		return []*Record{}, nil
+	}
+	return r.scanRecords(ctx, reefPrefix(reefID), colonyID)
+}
+
+// listAll returns every stored record of every reef.
+func (r *Registry) listAll(ctx context.Context) ([]*Record, error) {
+	return r.scanRecords(ctx, agentPrefix, "")
+}
+
+// scanRecords returns the stored records under prefix in colonyID, or in
+// all colonies when colonyID is empty.
+func (r *Registry) scanRecords(ctx context.Context, prefix, colonyID string) ([]*Record, error) {
+	entries, err := r.store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+
+	out := make([]*Record, 0, len(entries))
+	for _, entry := range entries {
+		rec, err := decodeRecord(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.TrimPrefix(entry.Key, agentPrefix), err)
+		}
+		if colonyID == "" || rec.ColonyID == colonyID {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+func decodeRecord(data []byte) (*Record, error) {
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return &rec, nil
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+)
+
+// ErrInvalidTopic is returned for topic lookups naming no topic or an
+// unknown role.
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Topic limits.
+const (
+	// maxTopics bounds the topics a record may publish, and separately
+	// subscribe to.
+	maxTopics = 64
+
+	// maxTopicLength bounds the length of a topic name.
+	maxTopicLength = 255
+)
+
+// TopicRole is how an agent takes part in a pub/sub topic.
+type TopicRole string
+
+const (
+	// AnyRole matches publishers and subscribers.
+	AnyRole TopicRole = ""
+
+	// Publisher matches agents listing the topic in Publishes.
+	Publisher TopicRole = "publisher"
+
+	// Subscriber matches agents listing the topic in Subscribes.
+	Subscriber TopicRole = "subscriber"
+)
+
+// ParseTopicRole parses "publisher", "subscriber" or "" (AnyRole).
+func ParseTopicRole(s string) (TopicRole, error) {
+	switch role := TopicRole(s); role {
+	case AnyRole, Publisher, Subscriber:
+		return role, nil
+	default:
+		return "", fmt.Errorf("%w: unknown role %q", ErrInvalidTopic, s)
+	}
+}
+
+// HasTopic reports whether the record publishes or subscribes to topic
+// as role.
+func (r *Record) HasTopic(topic string, role TopicRole) bool {
+	if role != Subscriber && slices.Contains(r.Publishes, topic) {
+		return true
+	}
+	return role != Publisher && slices.Contains(r.Subscribes, topic)
+}
+
+// validateTopics checks the topics of one role of a record.
+func validateTopics(field string, topics []string) error {
+	if len(topics) > maxTopics {
+		return fmt.Errorf("at most %d %s topics are allowed", maxTopics, field)
+	}
+	for _, t := range topics {
+		if t == "" || len(t) > maxTopicLength || strings.ContainsFunc(t, isSpace) {
+			return fmt.Errorf("%s topic %q must be 1 to %d characters without spaces", field, t, maxTopicLength)
+		}
+	}
+	return nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// ByTopic returns the live records of reefID that take part in topic as
+// role, in agent ID order, optionally only those of colonyID.
+func (r *Registry) ByTopic(ctx context.Context, reefID, colonyID, topic string, role TopicRole) ([]*Record, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("%w: topic is required", ErrInvalidTopic)
+	}
+	defer r.metrics.lookups.Since(time.Now(), "topic")
+	ctx, span := r.tracer.Start(ctx, tracing.Internal, "registry topic lookup", tracing.String("topic", topic))
+	defer span.End()
+	recs, err := r.listRecords(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	matched := recs[:0]
+	for _, rec := range recs {
+		if !rec.Expired(now) && rec.HasTopic(topic, role) {
+			matched = append(matched, rec)
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,322 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+)
+
+// Agents advertise the services they run, and their versions, as
+// "service=name/v2.3.1" capabilities. Versions follow semantic
+// versioning: a pre-release ("2.4.0-rc.1") sorts before its release and
+// is not stable, build metadata ("2.3.1+sha.5114f85") is ignored.
+
+// ErrInvalidService is returned for service lookups naming no service or
+// carrying a version constraint or canary share that does not parse.
+var ErrInvalidService = errors.New("invalid service query")
+
+// serviceLabel is the capability key services are advertised under.
+const serviceLabel = "service"
+
+// version is a parsed semantic version.
+type version struct {
+	parts []int
+	pre   []string
+}
+
+// stable reports whether v is a release rather than a pre-release.
+func (v version) stable() bool {
+	return len(v.pre) == 0
+}
+
+// parseVersion splits "name/v1.2.3-rc.1" or "1.2.3" into its name and
+// version. Missing numeric components count as zero.
+func parseVersion(s string) (string, version, error) {
+	name, text := "", s
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		name, text = s[:i], s[i+1:]
+	}
+	text = strings.TrimPrefix(text, "v")
+	text, _, _ = strings.Cut(text, "+")
+	text, pre, hasPre := strings.Cut(text, "-")
+	if text == "" {
+		return "", version{}, fmt.Errorf("no version in %q", s)
+	}
+	var v version
+	for _, p := range strings.Split(text, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return "", version{}, fmt.Errorf("invalid version %q", s)
+		}
+		v.parts = append(v.parts, n)
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return "", version{}, fmt.Errorf("invalid pre-release in %q", s)
+			}
+		}
+	}
+	return name, v, nil
+}
+
+// compareVersions orders a and b by semantic version precedence.
+func compareVersions(a, b version) int {
+	for i := 0; i < max(len(a.parts), len(b.parts)); i++ {
+		var x, y int
+		if i < len(a.parts) {
+			x = a.parts[i]
+		}
+		if i < len(b.parts) {
+			y = b.parts[i]
+		}
+		if x != y {
+			return cmpInt(x, y)
+		}
+	}
+
+	// A release outranks its pre-releases, which compare identifier by
+	// identifier: numerically when both are numbers, numbers before
+	// words, and a shorter list first when one is a prefix of the other.
+	switch {
+	case a.stable() && b.stable():
+		return 0
+	case a.stable():
+		return 1
+	case b.stable():
+		return -1
+	}
+	for i := 0; i < min(len(a.pre), len(b.pre)); i++ {
+		x, errX := strconv.Atoi(a.pre[i])
+		y, errY := strconv.Atoi(b.pre[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				return cmpInt(x, y)
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		default:
+			if c := strings.Compare(a.pre[i], b.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(a.pre), len(b.pre))
+}
+
+func cmpInt(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// ServiceVersion returns the version of service the record advertises,
+// as written after the "v", and whether it advertises one.
+func (r *Record) ServiceVersion(service string) (string, bool) {
+	for _, c := range r.Capabilities {
+		value, ok := strings.CutPrefix(c, serviceLabel+"=")
+		if !ok {
+			continue
+		}
+		name, v, ok := strings.Cut(value, "/")
+		if ok && name == service {
+			return strings.TrimPrefix(v, "v"), true
+		}
+	}
+	return "", false
+}
+
+// validateServices checks that versioned service capabilities carry a
+// semantic version.
+func validateServices(capabilities []string) error {
+	for _, c := range capabilities {
+		value, ok := strings.CutPrefix(c, serviceLabel+"=")
+		if !ok || !strings.Contains(value, "/") {
+			continue
+		}
+		if _, _, err := parseVersion(value); err != nil {
+			return fmt.Errorf("capability %q: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// constraint is one comparison of a version constraint.
+type constraint struct {
+	op string
+	v  version
+}
+
+// parseConstraints parses a comma-separated list of comparisons, all of
+// which must hold: ">=2.3", "<3", "=2.3.1" or a bare "2.3.1".
+func parseConstraints(s string) ([]constraint, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var out []constraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, o := range []string{">=", "<=", "==", "=", ">", "<"} {
+			if rest, ok := strings.CutPrefix(part, o); ok {
+				op, part = o, strings.TrimSpace(rest)
+				break
+			}
+		}
+		if op == "==" {
+			op = "="
+		}
+		_, v, err := parseVersion(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, constraint{op: op, v: v})
+	}
+	return out, nil
+}
+
+func (c constraint) holds(v version) bool {
+	cmp := compareVersions(v, c.v)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// ServiceQuery selects the agents running a service.
+type ServiceQuery struct {
+	// Service is the name agents advertise in "service=name/v1.2.3"
+	// capabilities.
+	Service string
+
+	// ColonyID, when set, narrows the lookup to one colony.
+	ColonyID string
+
+	// Version constrains the service version, as comma-separated
+	// comparisons that must all hold, e.g. ">=2.3,<3". Empty matches
+	// every version.
+	Version string
+
+	// Stable excludes pre-release versions such as 2.4.0-rc.1.
+	Stable bool
+
+	// Canary, from 1 to 100, is the percentage of lookups answered with
+	// only the agents of the newest matching version; the others are
+	// answered with only the older versions. 0 returns every match.
+	Canary int
+
+	// Key places the caller in the canary or the baseline share, so that
+	// lookups with the same Key, typically the caller's agent ID, see
+	// the same versions. Lookups without one are placed at random.
+	Key string
+}
+
+// ByService returns the live records of reefID advertising q.Service at
+// a version satisfying q, in agent ID order. With q.Canary set, a
+// rollout is under way whenever more than one version matches: that
+// share of callers is steered to the newest version, the rest to the
+// versions before it.
+func (r *Registry) ByService(ctx context.Context, reefID string, q ServiceQuery) ([]*Record, error) {
+	if q.Service == "" {
+		return nil, fmt.Errorf("%w: service is required", ErrInvalidService)
+	}
+	if q.Canary < 0 || q.Canary > 100 {
+		return nil, fmt.Errorf("%w: canary must be between 0 and 100", ErrInvalidService)
+	}
+	constraints, err := parseConstraints(q.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidService, err)
+	}
+	defer r.metrics.lookups.Since(time.Now(), "service")
+	ctx, span := r.tracer.Start(ctx, tracing.Internal, "registry service lookup", tracing.String("service", q.Service))
+	defer span.End()
+	recs, err := r.listRecords(ctx, reefID, q.ColonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	matched := recs[:0]
+	versions := make([]version, 0, len(recs))
+	for _, rec := range recs {
+		if rec.Expired(now) {
+			continue
+		}
+		text, ok := rec.ServiceVersion(q.Service)
+		if !ok {
+			continue
+		}
+		_, v, err := parseVersion(text)
+		if err != nil || (q.Stable && !v.stable()) || !satisfies(v, constraints) {
+			continue
+		}
+		matched = append(matched, rec)
+		versions = append(versions, v)
+	}
+	if q.Canary == 0 || len(matched) == 0 {
+		return matched, nil
+	}
+
+	newest := versions[0]
+	for _, v := range versions[1:] {
+		if compareVersions(v, newest) > 0 {
+			newest = v
+		}
+	}
+	canary := cohort(q.Key, q.Service) < q.Canary
+	var out []*Record
+	for i, rec := range matched {
+		if (compareVersions(versions[i], newest) == 0) == canary {
+			out = append(out, rec)
+		}
+	}
+	if out == nil {
+		// Only the newest version is running: there is nothing to steer.
+		return matched, nil
+	}
+	return out, nil
+}
+
+func satisfies(v version, constraints []constraint) bool {
+	for _, c := range constraints {
+		if !c.holds(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// cohort places key in one of 100 buckets, stable per service, or a
+// random one when key is empty.
+func cohort(key, service string) int {
+	if key == "" {
+		return rand.IntN(100)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(service))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,304 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change carried by an Event.
+type EventType int
+
+const (
+	// EventPut is emitted when a record is created or updated.
+	EventPut EventType = iota + 1
+
+	// EventDelete is emitted when a record is removed.
+	EventDelete
+
+	// EventExpire is emitted when the reaper evicts an expired lease.
+	EventExpire
+
+	// EventSuspect is emitted when a liveness detector suspects an agent
+	// has failed.
+	EventSuspect
+
+	// EventAlive is emitted when a suspected or failed agent is seen
+	// alive again.
+	EventAlive
+
+	// EventFail is emitted when a liveness detector declares an agent
+	// failed, ending its lease early.
+	EventFail
+
+	// EventResync is delivered by client watches, without a record, in
+	// place of changes that were missed and can no longer be replayed.
+	// Watchers holding a view of the registry should list it again.
+	EventResync
+
+	// EventDrain ends every watch, without a record, when the registry
+	// drains before shutting down (see Registry.Drain). Watchers should
+	// resume with another instance.
+	EventDrain
+)
+
+// String returns the lower-case name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventSuspect:
+		return "suspect"
+	case EventAlive:
+		return "alive"
+	case EventFail:
+		return "fail"
+	case EventResync:
+		return "resync"
+	case EventDrain:
+		return "drain"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a single registry change.
+type Event struct {
+	Type   EventType `json:"type"`
+	Record *Record   `json:"record"`
+
+	// Cursor identifies the change to WatchFrom, which resumes a watch
+	// after it. Only events delivered by Watch and WatchFrom carry one.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// DefaultWatchRetention is the number of recent changes kept for
+// WatchFrom when Config.WatchRetention is unset.
+const DefaultWatchRetention = 4096
+
+// ErrCursorExpired is returned by WatchFrom for cursors whose change is
+// no longer kept, or that another registry issued: the changes since
+// cannot be replayed, so the watcher must list the registry again.
+var ErrCursorExpired = errors.New("watch cursor expired, resync required")
+
+// Filter selects the records a Watch subscription receives. Empty fields
+// match everything.
+type Filter struct {
+	ColonyID   string
+	ReefID     string
+	Capability string
+
+	// Selector, when set, must match the record's labels.
+	Selector *Selector
+
+	// Topic, when set, must be published or subscribed to by the
+	// record, as TopicRole.
+	Topic     string
+	TopicRole TopicRole
+}
+
+// Matches reports whether rec satisfies every non-empty field of f.
+func (f Filter) Matches(rec *Record) bool {
+	if f.ColonyID != "" && rec.ColonyID != f.ColonyID {
+		return false
+	}
+	if f.ReefID != "" && rec.ReefID != f.ReefID {
+		return false
+	}
+	if f.Capability != "" && !rec.HasCapability(f.Capability) {
+		return false
+	}
+	if f.Topic != "" && !rec.HasTopic(f.Topic, f.TopicRole) {
+		return false
+	}
+	return f.Selector.Matches(rec)
+}
+
+// watchBuffer is the per-subscriber event buffer. Subscribers that fall
+// further behind than this are disconnected rather than blocking writers.
+const watchBuffer = 64
+
+// watcher is a single Watch subscription.
+type watcher struct {
+	filter Filter
+	ch     chan Event
+}
+
+// hub fans registry events out to watchers and keeps the most recent in
+// a journal, so watchers can resume after a gap.
+type hub struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+
+	// Cursors are "<epoch>.<seq>.<time>": epoch tells this hub's cursors
+	// from those of other processes, seq numbers its events from 1, and
+	// time, in Unix nanoseconds, is deltaMargin before the event, for
+	// replaying the changes since from the store once the journal no
+	// longer holds them (see Registry.replay).
+	epoch     string
+	seq       uint64
+	journal   []Event // events seq-len(journal)+1 through seq
+	retention int
+	now       func() time.Time
+
+	draining bool
+}
+
+func newHub(retention int, now func() time.Time) *hub {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return &hub{watchers: make(map[*watcher]struct{}), epoch: hex.EncodeToString(b[:]), retention: retention, now: now}
+}
+
+// subscribe registers a watcher that is removed when ctx is done. The
+// replayed events are delivered first and then, with a cursor, the
+// journaled events after it that match filter. Once draining, the watch
+// ends at once with an EventDrain.
+func (h *hub) subscribe(ctx context.Context, filter Filter, cursor string, replayed []Event) (<-chan Event, error) {
+	h.mu.Lock()
+	if h.draining {
+		h.mu.Unlock()
+		ch := make(chan Event, 1)
+		ch <- Event{Type: EventDrain}
+		close(ch)
+		return ch, nil
+	}
+	backlog := replayed
+	if cursor != "" {
+		after, err := h.parseCursor(cursor)
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		for _, ev := range h.journal[len(h.journal)-int(h.seq-after):] {
+			if filter.Matches(ev.Record) {
+				backlog = append(backlog, Event{Type: ev.Type, Record: ev.Record.Clone(), Cursor: ev.Cursor})
+			}
+		}
+	}
+	w := &watcher{filter: filter, ch: make(chan Event, len(backlog)+watchBuffer)}
+	for _, ev := range backlog {
+		w.ch <- ev
+	}
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.remove(w)
+	}()
+
+	return w.ch, nil
+}
+
+// cursor returns the cursor of the event numbered seq.
+func (h *hub) cursor(seq uint64) string {
+	return h.epoch + "." + strconv.FormatUint(seq, 10) + "." + strconv.FormatInt(h.now().Add(-deltaMargin).UnixNano(), 10)
+}
+
+// position returns the cursor of the latest event, to subscribe after.
+func (h *hub) position() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cursor(h.seq)
+}
+
+// cursorSince returns the time cursor carries, as the Delta since to
+// replay the changes after it from. Cursors of older releases carry none.
+func cursorSince(cursor string) (Timestamp, bool) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 3 {
+		return Timestamp{}, false
+	}
+	wall, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return Timestamp{}, false
+	}
+	return Timestamp{Wall: wall}, true
+}
+
+// parseCursor returns the seq of cursor, provided the journal still
+// holds every event after it.
+func (h *hub) parseCursor(cursor string) (uint64, error) {
+	epoch, n, ok := strings.Cut(cursor, ".")
+	n, _, _ = strings.Cut(n, ".")
+	seq, err := strconv.ParseUint(n, 10, 64)
+	if !ok || err != nil || epoch != h.epoch || seq > h.seq {
+		return 0, fmt.Errorf("%w: %q was not issued by this server", ErrCursorExpired, cursor)
+	}
+	if h.seq-seq > uint64(len(h.journal)) {
+		return 0, fmt.Errorf("%w: the %d changes since %q are no longer all kept", ErrCursorExpired, h.seq-seq, cursor)
+	}
+	return seq, nil
+}
+
+// publish journals ev and delivers it to every matching watcher without
+// blocking.
+func (h *hub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	ev = Event{Type: ev.Type, Record: ev.Record.Clone(), Cursor: h.cursor(h.seq)}
+	if len(h.journal) >= 2*h.retention {
+		// Trim in bulk, so publishing stays amortized constant time.
+		h.journal = append(h.journal[:0], h.journal[len(h.journal)-h.retention:]...)
+	}
+	h.journal = append(h.journal, ev)
+
+	for w := range h.watchers {
+		if !w.filter.Matches(ev.Record) {
+			continue
+		}
+		select {
+		case w.ch <- Event{Type: ev.Type, Record: ev.Record.Clone(), Cursor: ev.Cursor}:
+		default:
+			// Slow subscriber: drop it so it can resync.
+			delete(h.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+// drain ends every watch with an EventDrain, and those started later.
+func (h *hub) drain() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.draining = true
+	for w := range h.watchers {
+		select {
+		case w.ch <- Event{Type: EventDrain}:
+		default:
+			// Full buffer: closing alone asks the watcher to resync.
+		}
+		delete(h.watchers, w)
+		close(w.ch)
+	}
+}
+
+// count returns the number of watchers.
+func (h *hub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.watchers)
+}
+
+// remove unregisters w and closes its channel if still open.
+func (h *hub) remove(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		close(w.ch)
+	}
+}
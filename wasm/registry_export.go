@@ -0,0 +1,554 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/crdt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/kvcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/dostore"
+)
+
+// Peer lookups served from the optional KV cache are fresh for
+// peerFreshTTL and may be served stale for up to peerStaleTTL.
+const (
+	peerFreshTTL = 10 * time.Second
+	peerStaleTTL = 60 * time.Second
+)
+
+// openRegistry creates a registry persisted to Durable Object storage.
+// When a KV namespace is given, lookup reads through it and writes
+// invalidate it. When a replica ID is given, the registry is an edge
+// replica of the origin's (see package crdt) and also returns
+// syncRequest and syncComplete. Registries opened after configureTracing
+// record spans of their operations and storage calls, and those opened
+// after configureAudit audit registrations and rejected tickets. Those
+// opened after configureRateLimit charge writes to the ticket's agent and
+// colony. Locks and semaphores leased with acquireLock are kept in the
+// same storage.
+// Arguments: storage (DurableObjectStorage), jwksJSON, defaultTTLSeconds, [kv], [replicaID]
+// Returns: { register, deregister, renew, lookup, list, nearest, topic, service, reap, migrate,
+// lock, acquireLock, renewLock, releaseLock } where each method returns a Promise.
+func openRegistry(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: storage, jwksJSON, defaultTTLSeconds")
+	}
+
+	validator, err := jwt.NewValidatorFromJSON(args[1].String())
+	if err != nil {
+		return errorResult(errJWKSMalformed, "failed to create validator: "+err.Error())
+	}
+
+	replicaID := ""
+	if len(args) > 4 && args[4].Type() == js.TypeString {
+		replicaID = args[4].String()
+	}
+	l := auditLog.Load()
+	t := tracer.Load()
+	var st store.Store = dostore.New(args[0])
+	if t != nil {
+		st = t.Store(st)
+	}
+	reg, err := registry.New(registry.Config{
+		Store:      st,
+		Verifier:   l.Verifier(validator),
+		DefaultTTL: time.Duration(args[2].Int()) * time.Second,
+		ReplicaID:  replicaID,
+		Tracer:     t,
+		Audit:      l,
+		Limiter:    limiter.Load(),
+	})
+	if err != nil {
+		return errorResult(errInternal, "failed to create registry: "+err.Error())
+	}
+
+	h := &registryHandle{reg: reg, verifier: l.Verifier(validator), locks: lock.New(lock.Config{Store: st})}
+	if len(args) > 3 && !args[3].IsUndefined() && !args[3].IsNull() {
+		h.peers, err = kvcache.New(kvcache.Config{
+			Namespace: args[3],
+			Prefix:    "coral:peer:",
+			FreshTTL:  peerFreshTTL,
+			StaleTTL:  peerStaleTTL,
+		})
+		if err != nil {
+			return errorResult(errInvalidArgument, err.Error())
+		}
+	}
+	exports := map[string]interface{}{
+		"register":     promisify(h.register),
+		"deregister":   promisify(h.deregister),
+		"renew":        promisify(h.renew),
+		"lookup":       promisify(h.lookup),
+		"list":         promisify(h.list),
+		"changes":      promisify(h.changes),
+		"schema":       promisify(h.schema),
+		"setSchema":    promisify(h.setSchema),
+		"deleteSchema": promisify(h.deleteSchema),
+		"nearest":      promisify(h.nearest),
+		"topic":        promisify(h.topic),
+		"service":      promisify(h.service),
+		"reap":         promisify(h.reap),
+		"migrate":      promisify(h.migrate),
+		"lock":         promisify(h.lockStatus),
+		"acquireLock":  promisify(h.acquireLock),
+		"renewLock":    promisify(h.renewLock),
+		"releaseLock":  promisify(h.releaseLock),
+	}
+	if replicaID != "" {
+		if h.replica, err = crdt.New(crdt.Config{Registry: reg, Store: st}); err != nil {
+			return errorResult(errInternal, "failed to create replica: "+err.Error())
+		}
+		exports["syncRequest"] = promisify(h.syncRequest)
+		exports["syncComplete"] = promisify(h.syncComplete)
+	}
+	return exports
+}
+
+// registryHandle binds registry exports to a single Registry.
+type registryHandle struct {
+	reg      *registry.Registry
+	verifier registry.Verifier
+	locks    *lock.Locks
+	peers    *kvcache.Cache // nil when no KV namespace was given
+	replica  *crdt.Replica  // nil when no replica ID was given
+}
+
+// register stores a record. Arguments: ticket, recordJSON
+// Returns: { record }
+func (h *registryHandle) register(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: ticket, recordJSON")
+	}
+	var rec registry.Record
+	if err := json.Unmarshal([]byte(args[1].String()), &rec); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse record JSON: "+err.Error())
+	}
+	stored, err := h.reg.Register(context.Background(), args[0].String(), &rec)
+	if err != nil {
+		return registryError(err)
+	}
+	h.invalidate(stored.ReefID, stored.AgentID)
+	return recordResult(stored)
+}
+
+// deregister removes a record. Arguments: ticket, agentID
+// Returns: { deleted: true }
+func (h *registryHandle) deregister(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: ticket, agentID")
+	}
+	if err := h.reg.Deregister(context.Background(), args[0].String(), args[1].String()); err != nil {
+		return registryError(err)
+	}
+	// The registry verified the ticket; its reef names the removed record.
+	var claims jwt.ReferralClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(args[0].String(), &claims); err == nil {
+		h.invalidate(claims.ReefID, args[1].String())
+	}
+	return map[string]interface{}{"deleted": true}
+}
+
+// renew extends a record's lease, recording the agent's load when
+// loadJSON is given and renewing only a record still at seq when seq is
+// given. Arguments: ticket, agentID, [loadJSON], [seq]
+// Returns: { record }
+func (h *registryHandle) renew(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: ticket, agentID")
+	}
+	var load *registry.Load
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		load = new(registry.Load)
+		if err := json.Unmarshal([]byte(args[2].String()), load); err != nil {
+			return errorResult(errInvalidArgument, "failed to parse load JSON: "+err.Error())
+		}
+	}
+	var seq uint64
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		seq = uint64(args[3].Int())
+	}
+	rec, err := h.reg.HeartbeatSeq(context.Background(), args[0].String(), args[1].String(), seq, load)
+	if err != nil {
+		return registryError(err)
+	}
+	h.invalidate(rec.ReefID, rec.AgentID)
+	return recordResult(rec)
+}
+
+// lookup fetches a live record of a reef. Arguments: reefID, agentID
+// Returns: { record }
+func (h *registryHandle) lookup(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, agentID")
+	}
+	rec, err := h.cachedLookup(context.Background(), args[0].String(), args[1].String())
+	if err != nil {
+		return registryError(err)
+	}
+	return recordResult(rec)
+}
+
+// cachedLookup reads a record through the peer cache when configured.
+// Cached records whose lease has lapsed fall back to the registry.
+func (h *registryHandle) cachedLookup(ctx context.Context, reefID, agentID string) (*registry.Record, error) {
+	if h.peers == nil {
+		return h.reg.Lookup(ctx, reefID, agentID)
+	}
+
+	res, err := h.peers.Get(ctx, crdt.Key(reefID, agentID), func(ctx context.Context) ([]byte, error) {
+		rec, err := h.reg.Lookup(ctx, reefID, agentID)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rec registry.Record
+	if err := json.Unmarshal(res.Value, &rec); err != nil || rec.Expired(time.Now()) {
+		return h.reg.Lookup(ctx, reefID, agentID)
+	}
+	return &rec, nil
+}
+
+// invalidate drops agentID of reefID from the peer cache. Failures only
+// delay convergence until the cached entry ages out.
+func (h *registryHandle) invalidate(reefID, agentID string) {
+	h.forget(crdt.Key(reefID, agentID))
+}
+
+// forget drops a peer cache entry by its key, as built by crdt.Key.
+func (h *registryHandle) forget(key string) {
+	if h.peers != nil {
+		_ = h.peers.Delete(key)
+	}
+}
+
+// list returns a page of the live records in a colony of a reef, in
+// agent ID order. Arguments: reefID, colonyID, [cursor], [limit]
+// Returns: { agents: Record[], nextCursor?: string }
+func (h *registryHandle) list(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, colonyID")
+	}
+	cursor, limit := "", 0
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		cursor = args[2].String()
+	}
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		limit = args[3].Int()
+	}
+	page, err := h.reg.QueryPage(context.Background(), args[0].String(), args[1].String(), nil, cursor, limit)
+	if err != nil {
+		return registryError(err)
+	}
+	agents, err := toJSObject(page.Records)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	result := map[string]interface{}{"agents": agents}
+	if page.NextCursor != "" {
+		result["nextCursor"] = page.NextCursor
+	}
+	return result
+}
+
+// changes returns the records of a colony of a reef written since a
+// registry.Timestamp, every reef's when reefID is empty, and the
+// tombstones of the agents removed since (see registry.Delta). The delta
+// is returned as JSON text: its clock readings exceed
+// Number.MAX_SAFE_INTEGER. Pass its through.wall as since next time.
+// Arguments: reefID, colonyID, [since]
+// Returns: { deltaJSON }
+func (h *registryHandle) changes(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, colonyID")
+	}
+	var since registry.Timestamp
+	if len(args) > 2 && args[2].Type() == js.TypeString && args[2].String() != "" {
+		var err error
+		if since, err = registry.ParseTimestamp(args[2].String()); err != nil {
+			return errorResult(errInvalidArgument, err.Error())
+		}
+	}
+	d, err := h.reg.Delta(context.Background(), registry.Filter{ReefID: args[0].String(), ColonyID: args[1].String()}, since)
+	if err != nil {
+		return registryError(err)
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return errorResult(errInternal, "failed to encode delta: "+err.Error())
+	}
+	return map[string]interface{}{"deltaJSON": string(data)}
+}
+
+// schema returns the metadata schema of a colony of a reef.
+// Arguments: reefID, colonyID
+// Returns: { schema: ColonySchema }
+func (h *registryHandle) schema(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, colonyID")
+	}
+	cs, err := h.reg.ColonySchema(context.Background(), args[0].String(), args[1].String())
+	if err != nil {
+		return registryError(err)
+	}
+	obj, err := toJSObject(cs)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"schema": obj}
+}
+
+// setSchema makes schemaJSON, a JSON Schema, the metadata schema of a
+// colony of a reef. Registrations whose metadata does not match it fail
+// with ERR_INVALID_METADATA from then on.
+// Arguments: reefID, colonyID, schemaJSON
+// Returns: { schema: ColonySchema }
+func (h *registryHandle) setSchema(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errInvalidArgument, "expected 3 arguments: reefID, colonyID, schemaJSON")
+	}
+	cs := &registry.ColonySchema{ReefID: args[0].String(), ColonyID: args[1].String(), Schema: json.RawMessage(args[2].String())}
+	if err := h.reg.SetColonySchema(context.Background(), cs); err != nil {
+		return registryError(err)
+	}
+	obj, err := toJSObject(cs)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"schema": obj}
+}
+
+// deleteSchema removes the metadata schema of a colony of a reef.
+// Arguments: reefID, colonyID
+// Returns: { deleted: true }
+func (h *registryHandle) deleteSchema(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, colonyID")
+	}
+	if err := h.reg.DeleteColonySchema(context.Background(), args[0].String(), args[1].String()); err != nil {
+		return registryError(err)
+	}
+	return map[string]interface{}{"deleted": true}
+}
+
+// nearest returns the live records in a colony of a reef nearest an
+// agent, ranked by registry.Nearest. originJSON is a partial record
+// naming the agent, whose location is typically built from request.cf;
+// the agent's stored record fills in what it lacks.
+// Arguments: reefID, colonyID, originJSON, n
+// Returns: { agents: Record[] }
+func (h *registryHandle) nearest(this js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return errorResult(errInvalidArgument, "expected 4 arguments: reefID, colonyID, originJSON, n")
+	}
+	reefID := args[0].String()
+	var origin registry.Record
+	if err := json.Unmarshal([]byte(args[2].String()), &origin); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse origin JSON: "+err.Error())
+	}
+	ctx := context.Background()
+	if origin.AgentID != "" {
+		if rec, err := h.reg.Lookup(ctx, reefID, origin.AgentID); err == nil {
+			rec.Location = rec.Location.Merge(origin.Location)
+			origin = *rec
+		}
+	}
+	recs, err := h.reg.List(ctx, reefID, args[1].String())
+	if err != nil {
+		return registryError(err)
+	}
+	agents, err := toJSObject(registry.Nearest(recs, &origin, args[3].Int()))
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"agents": agents}
+}
+
+// topic returns the live records of a reef that publish or subscribe to
+// a topic, in agent ID order. role is "publisher", "subscriber" or empty
+// for both. Arguments: reefID, topic, [role], [colonyID]
+// Returns: { agents: Record[] }
+func (h *registryHandle) topic(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, topic")
+	}
+	role, colonyID := registry.AnyRole, ""
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		var err error
+		if role, err = registry.ParseTopicRole(args[2].String()); err != nil {
+			return registryError(err)
+		}
+	}
+	if len(args) > 3 && args[3].Type() == js.TypeString {
+		colonyID = args[3].String()
+	}
+	recs, err := h.reg.ByTopic(context.Background(), args[0].String(), colonyID, args[1].String(), role)
+	if err != nil {
+		return registryError(err)
+	}
+	agents, err := toJSObject(recs)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"agents": agents}
+}
+
+// service returns the live records of a reef running a service at a
+// compatible version, in agent ID order, steering the canary share of
+// callers to the newest version (see registry.ServiceQuery).
+// Arguments: reefID, service, [{ version?, stable?, canary?, key?, colonyID? }]
+// Returns: { agents: Record[] }
+func (h *registryHandle) service(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: reefID, service")
+	}
+	q := registry.ServiceQuery{Service: args[1].String()}
+	if len(args) > 2 && args[2].Type() == js.TypeObject {
+		opts := args[2]
+		if v := opts.Get("version"); v.Type() == js.TypeString {
+			q.Version = v.String()
+		}
+		if v := opts.Get("stable"); v.Type() == js.TypeBoolean {
+			q.Stable = v.Bool()
+		}
+		if v := opts.Get("canary"); v.Type() == js.TypeNumber {
+			q.Canary = v.Int()
+		}
+		if v := opts.Get("key"); v.Type() == js.TypeString {
+			q.Key = v.String()
+		}
+		if v := opts.Get("colonyID"); v.Type() == js.TypeString {
+			q.ColonyID = v.String()
+		}
+	}
+	recs, err := h.reg.ByService(context.Background(), args[0].String(), q)
+	if err != nil {
+		return registryError(err)
+	}
+	agents, err := toJSObject(recs)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"agents": agents}
+}
+
+// reap evicts expired records. Call it from the Durable Object alarm.
+// Returns: { evicted: number }
+func (h *registryHandle) reap(this js.Value, args []js.Value) interface{} {
+	n, err := h.reg.Reap(context.Background())
+	if err != nil {
+		return registryError(err)
+	}
+	return map[string]interface{}{"evicted": n}
+}
+
+// migrate moves records stored before reefs were isolated to the keys of
+// their reef (see registry.Migrate). Call it when the Durable Object
+// starts. Returns: { moved: number }
+func (h *registryHandle) migrate(this js.Value, args []js.Value) interface{} {
+	n, err := h.reg.Migrate(context.Background())
+	if err != nil {
+		return registryError(err)
+	}
+	return map[string]interface{}{"moved": n}
+}
+
+// syncRequest returns the delta to POST to the origin's /v1/crdt/sync,
+// with the replicas' shared secret as bearer token. The delta stays JSON
+// text: its clock readings do not fit JavaScript numbers.
+// Returns: { deltaJSON }
+func (h *registryHandle) syncRequest(this js.Value, args []js.Value) interface{} {
+	delta, err := h.replica.Request(context.Background())
+	if err != nil {
+		return registryError(err)
+	}
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"deltaJSON": string(data)}
+}
+
+// syncComplete merges the origin's answer to a syncRequest, the
+// response body as text. Arguments: deltaJSON
+// Returns: { merged: true }
+func (h *registryHandle) syncComplete(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: deltaJSON")
+	}
+	var delta crdt.Map
+	if err := json.Unmarshal([]byte(args[0].String()), &delta); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse delta JSON: "+err.Error())
+	}
+	if err := h.replica.Complete(context.Background(), &delta); err != nil {
+		return registryError(err)
+	}
+	// Merged records may replace cached ones.
+	for key := range delta.Entries {
+		h.forget(key)
+	}
+	return map[string]interface{}{"merged": true}
+}
+
+// recordResult builds the { record } result.
+func recordResult(rec *registry.Record) interface{} {
+	obj, err := toJSObject(rec)
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"record": obj}
+}
+
+// toJSObject converts v to a plain JS object via its JSON encoding, so
+// field names match the HTTP API.
+func toJSObject(v interface{}) (js.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return js.Undefined(), errors.New("failed to encode result: " + err.Error())
+	}
+	return js.Global().Get("JSON").Call("parse", string(data)), nil
+}
+
+// registryError maps a registry error to an export error result.
+func registryError(err error) interface{} {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		return errorResult(errNotFound, err.Error())
+	case errors.Is(err, registry.ErrLeaseExpired):
+		return errorResult(errLeaseExpired, err.Error())
+	case errors.Is(err, registry.ErrStaleRecord):
+		return errorResult(errStaleRecord, err.Error())
+	case errors.Is(err, registry.ErrCursorExpired):
+		return errorResult(errResyncRequired, err.Error())
+	case errors.Is(err, schema.ErrMismatch):
+		return errorResult(errInvalidMetadata, err.Error())
+	case errors.Is(err, ratelimit.ErrLimited):
+		return errorResult(errRateLimited, err.Error())
+	case errors.Is(err, registry.ErrQuotaExceeded):
+		return errorResult(errQuotaExceeded, err.Error())
+	case errors.Is(err, registry.ErrUnauthorized), errors.Is(err, rbac.ErrForbidden):
+		return errorResult(errUnauthorized, err.Error())
+	case errors.Is(err, registry.ErrInvalidRecord), errors.Is(err, registry.ErrInvalidCursor), errors.Is(err, registry.ErrInvalidTopic), errors.Is(err, registry.ErrInvalidService), errors.Is(err, schema.ErrInvalid):
+		return errorResult(errInvalidArgument, err.Error())
+	default:
+		return errorResult(errInternal, err.Error())
+	}
+}
@@ -0,0 +1,328 @@
+// Package relay is the fallback for agent pairs whose NATs defeat hole
+// punching. Agents designated as relays register with the Capability
+// capability; the Broker reserves one of them for a pair of agents and
+// hands each a signed relay ticket carrying the reservation's bandwidth
+// quota. The relay checks tickets against the discovery server's JWKS
+// and enforces the quota while forwarding datagrams (see Server), so it
+// needs no state shared with the broker.
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Capability marks the registration of an agent serving as a relay. Its
+// endpoints are the UDP addresses of its Server.
+const Capability = "relay"
+
+// Defaults for Config.
+const (
+	// DefaultQuota is the byte quota of a reservation, both directions
+	// together.
+	DefaultQuota = 256 << 20
+
+	// DefaultTTL is the lifetime of a reservation and its tickets.
+	DefaultTTL = 30 * time.Minute
+)
+
+// keyPrefix namespaces reservations in the store.
+const keyPrefix = "relay/"
+
+var (
+	// ErrNoRelay is returned when the colony has no live relay.
+	ErrNoRelay = errors.New("no relay available")
+
+	// ErrPeerNotFound is returned when the peer is not a live agent of
+	// the requester's colony.
+	ErrPeerNotFound = errors.New("peer not found")
+
+	// ErrInvalidQuota is returned for quotas outside the broker's limit.
+	ErrInvalidQuota = errors.New("invalid quota")
+)
+
+// Reservation is a relay reserved for a pair of agents. Ticket is the
+// relay ticket of the agent the reservation was returned to; it is not
+// stored.
+type Reservation struct {
+	ID             string    `json:"id"`
+	ReefID         string    `json:"reef_id"`
+	ColonyID       string    `json:"colony_id"`
+	Relay          string    `json:"relay"`
+	RelayEndpoints []string  `json:"relay_endpoints"`
+	Initiator      string    `json:"initiator"`
+	Target         string    `json:"target"`
+	QuotaBytes     int64     `json:"quota_bytes"`
+	RateBytes      int64     `json:"rate_bytes,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Ticket         string    `json:"ticket,omitempty"`
+}
+
+// Config holds the configuration for a Broker.
+type Config struct {
+	// Registry resolves agents and relays. Required.
+	Registry *registry.Registry
+
+	// Signer returns the key relay tickets are signed with, which must be
+	// in the JWKS relays verify against. Required.
+	Signer func() (signer.Signer, error)
+
+	// Store holds reservations. Defaults to an in-memory store; use a
+	// shared backend when several instances serve the same colony.
+	Store store.Store
+
+	// Quota is the quota of reservations that do not ask for less, and
+	// the most one may ask for. Defaults to DefaultQuota.
+	Quota int64
+
+	// Rate caps the bytes per second a relay forwards for a reservation;
+	// zero leaves it to the relay.
+	Rate int64
+
+	// TTL bounds the life of reservations. Defaults to DefaultTTL.
+	TTL time.Duration
+}
+
+// Broker reserves relays.
+type Broker struct {
+	registry *registry.Registry
+	signer   func() (signer.Signer, error)
+	store    store.Store
+	quota    int64
+	rate     int64
+	ttl      time.Duration
+}
+
+// New creates a Broker from cfg.
+func New(cfg Config) (*Broker, error) {
+	if cfg.Registry == nil {
+		return nil, errors.New("relay: Registry is required")
+	}
+	if cfg.Signer == nil {
+		return nil, errors.New("relay: Signer is required")
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	quota := cfg.Quota
+	if quota <= 0 {
+		quota = DefaultQuota
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Broker{registry: cfg.Registry, signer: cfg.Signer, store: st, quota: quota, rate: cfg.Rate, ttl: ttl}, nil
+}
+
+// Reserve reserves a relay of colonyID in reefID for agents from and to, with a
+// quota of quota bytes (the broker's when zero), and returns it with
+// from's ticket. The least loaded relay is picked.
+func (b *Broker) Reserve(ctx context.Context, reefID, colonyID, from, to string, quota int64) (*Reservation, error) {
+	if quota == 0 {
+		quota = b.quota
+	}
+	if quota < 0 || quota > b.quota {
+		return nil, fmt.Errorf("%w: at most %d bytes", ErrInvalidQuota, b.quota)
+	}
+	if to == "" || to == from {
+		return nil, fmt.Errorf("%w: invalid peer %q", ErrPeerNotFound, to)
+	}
+	for _, agentID := range []string{from, to} {
+		rec, err := b.registry.Lookup(ctx, reefID, agentID)
+		if errors.Is(err, registry.ErrNotFound) || (err == nil && rec.ColonyID != colonyID) {
+			return nil, fmt.Errorf("%w: %s is not in colony %s", ErrPeerNotFound, agentID, colonyID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	relay, err := b.pick(ctx, reefID, colonyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reservation{
+		ID:             uuid.New().String(),
+		ReefID:         reefID,
+		ColonyID:       colonyID,
+		Relay:          relay.AgentID,
+		RelayEndpoints: relay.Endpoints,
+		Initiator:      from,
+		Target:         to,
+		QuotaBytes:     quota,
+		RateBytes:      b.rate,
+		ExpiresAt:      time.Now().UTC().Add(b.ttl),
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.store.Put(ctx, keyPrefix+r.ID, data); err != nil {
+		return nil, err
+	}
+	return b.withTicket(ctx, r, from)
+}
+
+// Reservations returns the live reservations targeting agentID of
+// reefID, each with agentID's ticket. When there are none it waits up to
+// wait for one.
+func (b *Broker) Reservations(ctx context.Context, reefID, agentID string, wait time.Duration) ([]*Reservation, error) {
+	found, err := b.targeting(ctx, reefID, agentID)
+	if err != nil || len(found) > 0 || wait <= 0 {
+		return b.withTickets(ctx, found, agentID, err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	events, err := b.store.Watch(waitCtx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if found, err := b.targeting(ctx, reefID, agentID); err != nil || len(found) > 0 {
+		return b.withTickets(ctx, found, agentID, err)
+	}
+	for ev := range events {
+		if ev.Type != store.EventPut {
+			continue
+		}
+		if found, err := b.targeting(ctx, reefID, agentID); err != nil || len(found) > 0 {
+			return b.withTickets(ctx, found, agentID, err)
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// Prune deletes expired reservations and returns how many were removed.
+func (b *Broker) Prune(ctx context.Context) (int, error) {
+	entries, err := b.store.List(ctx, keyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	pruned := 0
+	for _, e := range entries {
+		var r Reservation
+		if err := json.Unmarshal(e.Value, &r); err == nil && now.Before(r.ExpiresAt) {
+			continue
+		}
+		if err := b.store.Delete(ctx, e.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (b *Broker) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = b.Prune(ctx)
+		}
+	}
+}
+
+// pick returns the live relay of colonyID in reefID, other than the two parties,
+// with the fewest live reservations, preferring relays that report less
+// load (see registry.LeastLoaded) among equals.
+func (b *Broker) pick(ctx context.Context, reefID, colonyID, from, to string) (*registry.Record, error) {
+	recs, err := b.registry.List(ctx, reefID, colonyID)
+	if err != nil {
+		return nil, err
+	}
+	live, err := b.live(ctx)
+	if err != nil {
+		return nil, err
+	}
+	load := map[string]int{}
+	for _, r := range live {
+		if r.ReefID == reefID {
+			load[r.Relay]++
+		}
+	}
+	var best *registry.Record
+	for _, rec := range registry.LeastLoaded(recs, time.Now(), 0) {
+		if !rec.HasCapability(Capability) || rec.AgentID == from || rec.AgentID == to {
+			continue
+		}
+		if best == nil || load[rec.AgentID] < load[best.AgentID] {
+			best = rec
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w in colony %s", ErrNoRelay, colonyID)
+	}
+	return best, nil
+}
+
+func (b *Broker) targeting(ctx context.Context, reefID, agentID string) ([]*Reservation, error) {
+	live, err := b.live(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Reservation
+	for _, r := range live {
+		if r.ReefID == reefID && r.Target == agentID {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// live returns the unexpired reservations.
+func (b *Broker) live(ctx context.Context) ([]*Reservation, error) {
+	entries, err := b.store.List(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var out []*Reservation
+	for _, e := range entries {
+		var r Reservation
+		if json.Unmarshal(e.Value, &r) == nil && now.Before(r.ExpiresAt) {
+			out = append(out, &r)
+		}
+	}
+	return out, nil
+}
+
+func (b *Broker) withTickets(ctx context.Context, rs []*Reservation, agentID string, err error) ([]*Reservation, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if _, err := b.withTicket(ctx, r, agentID); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+func (b *Broker) withTicket(ctx context.Context, r *Reservation, agentID string) (*Reservation, error) {
+	s, err := b.signer()
+	if err != nil {
+		return nil, fmt.Errorf("no signing key: %w", err)
+	}
+	if r.Ticket, err = issueTicket(ctx, s, r, agentID); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
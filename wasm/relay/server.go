@@ -0,0 +1,288 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Control messages. A party binds its address to its reservation by
+// sending bindPrefix + ticket; every other datagram from a bound address
+// is forwarded to the peer once the peer is bound too.
+var (
+	bindPrefix = []byte("coral-relay bind ")
+	boundMsg   = []byte("coral-relay bound")
+	quotaMsg   = []byte("coral-relay quota")
+	errPrefix  = []byte("coral-relay error ")
+)
+
+// ErrQuotaExceeded is returned by Bind when the relay reports that the
+// reservation's quota is used up.
+var ErrQuotaExceeded = errors.New("relay quota exceeded")
+
+// sweepInterval is how often the server forgets expired reservations.
+const sweepInterval = time.Minute
+
+// ServerConfig holds the configuration for a Server.
+type ServerConfig struct {
+	// AgentID is the relay's agent ID; tickets for other relays are
+	// refused. Required.
+	AgentID string
+
+	// Keys verifies relay tickets, normally the discovery server's JWKS.
+	// Required.
+	Keys verify.KeySource
+
+	// Rate caps the bytes per second forwarded per reservation when its
+	// ticket does not set a lower rate. Zero only applies ticket rates.
+	Rate int64
+
+	// Capacity is the number of reservations the relay is sized for;
+	// Load reports utilization against it. Zero reports sessions only.
+	Capacity int
+}
+
+// Server is the data plane of a relay: it forwards datagrams between the
+// two bound parties of each reservation until the reservation's quota is
+// used up or its tickets expire.
+type Server struct {
+	agentID  string
+	keys     verify.KeySource
+	rate     int64
+	capacity int
+
+	mu       sync.Mutex
+	sessions map[string]*session // by reservation
+	bindings map[string]*binding // by party address
+}
+
+type session struct {
+	quota   int64
+	used    int64
+	rate    int64
+	tokens  float64
+	last    time.Time
+	expires time.Time
+	addrs   map[string]net.Addr // by agent
+}
+
+type binding struct {
+	session *session
+	peer    string
+}
+
+// NewServer creates a Server from cfg.
+func NewServer(cfg ServerConfig) (*Server, error) {
+	if cfg.AgentID == "" || cfg.Keys == nil {
+		return nil, errors.New("relay: AgentID and Keys are required")
+	}
+	return &Server{
+		agentID:  cfg.AgentID,
+		keys:     cfg.Keys,
+		rate:     cfg.Rate,
+		capacity: cfg.Capacity,
+		sessions: map[string]*session{},
+		bindings: map[string]*binding{},
+	}, nil
+}
+
+// Serve relays datagrams arriving on conn until ctx is done, then closes
+// conn.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 65536)
+	nextSweep := time.Now().Add(sweepInterval)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		now := time.Now()
+		if now.After(nextSweep) {
+			s.sweep(now)
+			nextSweep = now.Add(sweepInterval)
+		}
+
+		packet := buf[:n]
+		if token, ok := bytes.CutPrefix(packet, bindPrefix); ok {
+			if err := s.bind(string(token), from, now); err != nil {
+				_, _ = conn.WriteTo(append(append([]byte{}, errPrefix...), err.Error()...), from)
+				continue
+			}
+			_, _ = conn.WriteTo(boundMsg, from)
+			continue
+		}
+		to, ok, exceeded := s.route(from, n, now)
+		if exceeded {
+			_, _ = conn.WriteTo(quotaMsg, from)
+		}
+		if ok {
+			_, _ = conn.WriteTo(packet, to)
+		}
+	}
+}
+
+// Load reports the relay's live reservations, for its heartbeats.
+func (s *Server) Load() *registry.Load {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	live := 0
+	for _, sess := range s.sessions {
+		if now.Before(sess.expires) {
+			live++
+		}
+	}
+	load := &registry.Load{Sessions: live}
+	if s.capacity > 0 {
+		load.Utilization = min(1, float64(live)/float64(s.capacity))
+	}
+	return load
+}
+
+// bind verifies a ticket and binds from to its reservation.
+func (s *Server) bind(token string, from net.Addr, now time.Time) error {
+	claims, err := ParseTicket(s.keys, token, s.agentID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[claims.Reservation]
+	if !ok {
+		rate := claims.RateBytes
+		if s.rate > 0 && (rate <= 0 || s.rate < rate) {
+			rate = s.rate
+		}
+		sess = &session{
+			quota:   claims.QuotaBytes,
+			rate:    rate,
+			tokens:  float64(rate),
+			last:    now,
+			expires: claims.ExpiresAt.Time,
+			addrs:   map[string]net.Addr{},
+		}
+		s.sessions[claims.Reservation] = sess
+	}
+	if old, ok := sess.addrs[claims.Subject]; ok {
+		delete(s.bindings, old.String())
+	}
+	sess.addrs[claims.Subject] = from
+	s.bindings[from.String()] = &binding{session: sess, peer: claims.Peer}
+	return nil
+}
+
+// route returns where to forward n bytes from from, charging them to its
+// reservation. exceeded reports that the quota is used up.
+func (s *Server) route(from net.Addr, n int, now time.Time) (to net.Addr, ok, exceeded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, found := s.bindings[from.String()]
+	if !found || !now.Before(b.session.expires) {
+		return nil, false, false
+	}
+	sess := b.session
+	to, found = sess.addrs[b.peer]
+	if !found {
+		return nil, false, false
+	}
+	if sess.used+int64(n) > sess.quota {
+		return nil, false, true
+	}
+	if sess.rate > 0 {
+		sess.tokens = min(float64(sess.rate), sess.tokens+now.Sub(sess.last).Seconds()*float64(sess.rate))
+		sess.last = now
+		if sess.tokens < float64(n) {
+			return nil, false, false
+		}
+		sess.tokens -= float64(n)
+	}
+	sess.used += int64(n)
+	return to, true, false
+}
+
+// sweep forgets expired reservations.
+func (s *Server) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if now.Before(sess.expires) {
+			continue
+		}
+		for _, addr := range sess.addrs {
+			delete(s.bindings, addr.String())
+		}
+		delete(s.sessions, id)
+	}
+}
+
+// Bind binds conn to its reservation at the relay at addr with ticket
+// and returns the relay's address, to which the party then sends the
+// datagrams for its peer; datagrams from the peer arrive from it. Do not
+// read from conn until Bind returns.
+func Bind(ctx context.Context, conn net.PacketConn, addr, ticket string) (net.Addr, error) {
+	relay, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	req := append(append([]byte{}, bindPrefix...), ticket...)
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 512)
+	wait := 250 * time.Millisecond
+	for range 6 {
+		if _, err := conn.WriteTo(req, relay); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(wait)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if from.String() != relay.String() {
+				continue
+			}
+			switch msg := buf[:n]; {
+			case bytes.Equal(msg, boundMsg):
+				return relay, nil
+			case bytes.Equal(msg, quotaMsg):
+				return nil, ErrQuotaExceeded
+			case bytes.HasPrefix(msg, errPrefix):
+				return nil, errors.New("relay: " + string(msg[len(errPrefix):]))
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+	return nil, fmt.Errorf("relay %s did not answer", addr)
+}
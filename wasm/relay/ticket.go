@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// Audience is the aud of relay tickets, which keeps them from passing as
+// referral tickets and the other way round.
+const Audience = "coral-relay"
+
+// ErrInvalidTicket is returned for relay tickets that do not verify or
+// are not meant for the relay checking them.
+var ErrInvalidTicket = errors.New("invalid relay ticket")
+
+// Claims are the claims of a relay ticket. The subject is the agent the
+// ticket was issued to; the relay forwards its traffic to Peer within the
+// reservation's limits.
+type Claims struct {
+	Reservation string `json:"rsv"`
+	Relay       string `json:"relay"`
+	Peer        string `json:"peer"`
+	QuotaBytes  int64  `json:"quota_bytes"`
+	RateBytes   int64  `json:"rate_bytes,omitempty"`
+	gojwt.RegisteredClaims
+}
+
+// issueTicket signs the ticket of agentID for r.
+func issueTicket(ctx context.Context, s signer.Signer, r *Reservation, agentID string) (string, error) {
+	peer := r.Target
+	if agentID == r.Target {
+		peer = r.Initiator
+	}
+	return signer.SignToken(ctx, s, &Claims{
+		Reservation: r.ID,
+		Relay:       r.Relay,
+		Peer:        peer,
+		QuotaBytes:  r.QuotaBytes,
+		RateBytes:   r.RateBytes,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        r.ID + "/" + agentID,
+			Issuer:    jwt.DefaultIssuer,
+			Subject:   agentID,
+			Audience:  gojwt.ClaimStrings{Audience},
+			IssuedAt:  gojwt.NewNumericDate(time.Now()),
+			ExpiresAt: gojwt.NewNumericDate(r.ExpiresAt),
+		},
+	})
+}
+
+// ParseTicket verifies a relay ticket against keys, the discovery
+// server's JWKS, and checks that it is for relayID.
+func ParseTicket(keys verify.KeySource, token, relayID string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := gojwt.ParseWithClaims(token, claims, keys.GetKeyFunc(),
+		gojwt.WithAudience(Audience),
+		gojwt.WithIssuer(jwt.DefaultIssuer),
+		gojwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTicket, err)
+	}
+	if claims.Relay != relayID {
+		return nil, fmt.Errorf("%w: issued for relay %q", ErrInvalidTicket, claims.Relay)
+	}
+	if claims.Reservation == "" || claims.Subject == "" || claims.Peer == "" || claims.QuotaBytes <= 0 {
+		return nil, fmt.Errorf("%w: incomplete claims", ErrInvalidTicket)
+	}
+	return claims, nil
+}
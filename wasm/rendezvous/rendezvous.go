@@ -0,0 +1,266 @@
+// Package rendezvous relays WebRTC signaling between agents that found
+// each other through discovery. Agents post SDP offers and answers and
+// ICE candidates to a peer's mailbox and drain their own, so browser
+// agents can set up direct connections without a separate signaling
+// server. Senders are identified by their referral ticket, and the
+// recipient must be a live registration in the sender's reef and colony.
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// DefaultTTL is how long an undelivered signal is kept when Config.TTL is
+// zero. Offers older than this are useless to WebRTC anyway.
+const DefaultTTL = 2 * time.Minute
+
+// MaxDataSize bounds the payload of a signal. SDP for a handful of media
+// and data channels stays well below it.
+const MaxDataSize = 64 << 10
+
+// keyPrefix namespaces mailboxes in the store; signals live under
+// keyPrefix + reef + "/" + recipient + "/" + a time-ordered ID.
+const keyPrefix = "rendezvous/"
+
+// Signal types.
+const (
+	// TypeOffer carries an RTCSessionDescriptionInit of type "offer".
+	TypeOffer = "offer"
+
+	// TypeAnswer carries an RTCSessionDescriptionInit of type "answer".
+	TypeAnswer = "answer"
+
+	// TypeCandidate carries an RTCIceCandidateInit; a null candidate
+	// signals the end of gathering.
+	TypeCandidate = "candidate"
+
+	// TypeBye tells the peer to tear the connection attempt down.
+	TypeBye = "bye"
+)
+
+var (
+	// ErrPeerNotFound is returned when the recipient is not a live agent
+	// of the sender's colony.
+	ErrPeerNotFound = errors.New("peer not found")
+
+	// ErrInvalidSignal is returned for malformed signals.
+	ErrInvalidSignal = errors.New("invalid signal")
+)
+
+// Signal is a signaling message between two agents. Data is the
+// browser's session description or ICE candidate, relayed verbatim.
+type Signal struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	ReefID    string          `json:"reef_id"`
+	ColonyID  string          `json:"colony_id"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// Config holds the configuration for a Broker.
+type Config struct {
+	// Registry resolves recipients. Required.
+	Registry *registry.Registry
+
+	// Store holds mailboxes. Defaults to an in-memory store; use a shared
+	// backend when several instances serve the same colony.
+	Store store.Store
+
+	// TTL bounds how long a signal waits for its recipient. Defaults to
+	// DefaultTTL.
+	TTL time.Duration
+}
+
+// Broker relays signals through per-agent mailboxes.
+type Broker struct {
+	registry *registry.Registry
+	store    store.Store
+	ttl      time.Duration
+}
+
+// New creates a Broker from cfg.
+func New(cfg Config) (*Broker, error) {
+	if cfg.Registry == nil {
+		return nil, errors.New("rendezvous: Registry is required")
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Broker{registry: cfg.Registry, store: st, ttl: ttl}, nil
+}
+
+// Send queues sig for sig.To. sig.From, sig.ReefID and sig.ColonyID
+// identify the sender and must come from its verified ticket; the
+// recipient must be live in the same reef and colony. The stored signal is returned.
+func (b *Broker) Send(ctx context.Context, sig *Signal) (*Signal, error) {
+	switch sig.Type {
+	case TypeOffer, TypeAnswer, TypeCandidate, TypeBye:
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidSignal, sig.Type)
+	}
+	if sig.From == "" || sig.ColonyID == "" || sig.ReefID == "" || strings.Contains(sig.ReefID, "/") {
+		return nil, fmt.Errorf("%w: sender agent, colony and reef are required", ErrInvalidSignal)
+	}
+	if sig.To == "" || strings.Contains(sig.To, "/") || sig.To == sig.From {
+		return nil, fmt.Errorf("%w: invalid recipient %q", ErrInvalidSignal, sig.To)
+	}
+	if len(sig.Data) > MaxDataSize {
+		return nil, fmt.Errorf("%w: data exceeds %d bytes", ErrInvalidSignal, MaxDataSize)
+	}
+	if len(sig.Data) > 0 && !json.Valid(sig.Data) {
+		return nil, fmt.Errorf("%w: data is not valid JSON", ErrInvalidSignal)
+	}
+
+	peer, err := b.registry.Lookup(ctx, sig.ReefID, sig.To)
+	if errors.Is(err, registry.ErrNotFound) || (err == nil && peer.ColonyID != sig.ColonyID) {
+		return nil, fmt.Errorf("%w: %s is not in colony %s", ErrPeerNotFound, sig.To, sig.ColonyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Version 7 IDs sort by creation time, so a mailbox lists in the
+	// order signals were sent.
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	out := *sig
+	out.ID = id.String()
+	out.CreatedAt = now
+	out.ExpiresAt = now.Add(b.ttl)
+	data, err := json.Marshal(&out)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.store.Put(ctx, mailbox(sig.ReefID, sig.To)+out.ID, data); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Receive removes and returns the signals waiting for agentID of reefID,
+// oldest first. When the mailbox is empty it waits up to wait for a
+// signal to arrive.
+func (b *Broker) Receive(ctx context.Context, reefID, agentID string, wait time.Duration) ([]*Signal, error) {
+	if agentID == "" || strings.Contains(agentID, "/") {
+		return nil, fmt.Errorf("%w: invalid agent %q", ErrInvalidSignal, agentID)
+	}
+	if reefID == "" || strings.Contains(reefID, "/") {
+		return nil, fmt.Errorf("%w: invalid reef %q", ErrInvalidSignal, reefID)
+	}
+	box := mailbox(reefID, agentID)
+	sigs, err := b.drain(ctx, box)
+	if err != nil || len(sigs) > 0 || wait <= 0 {
+		return sigs, err
+	}
+
+	// Subscribe before looking again so a signal sent in between is not
+	// missed.
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	events, err := b.store.Watch(waitCtx, box)
+	if err != nil {
+		return nil, err
+	}
+	if sigs, err := b.drain(ctx, box); err != nil || len(sigs) > 0 {
+		return sigs, err
+	}
+	for ev := range events {
+		if ev.Type != store.EventPut {
+			continue
+		}
+		if sigs, err := b.drain(ctx, box); err != nil || len(sigs) > 0 {
+			return sigs, err
+		}
+	}
+	// An empty result means the wait timed out.
+	return nil, ctx.Err()
+}
+
+// drain takes the live signals out of the mailbox box. Signals another
+// instance took first are skipped.
+func (b *Broker) drain(ctx context.Context, box string) ([]*Signal, error) {
+	entries, err := b.store.List(ctx, box)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var sigs []*Signal
+	for _, e := range entries {
+		err := b.store.Delete(ctx, e.Key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return sigs, err
+		}
+		var sig Signal
+		if err := json.Unmarshal(e.Value, &sig); err != nil || !now.Before(sig.ExpiresAt) {
+			continue
+		}
+		sigs = append(sigs, &sig)
+	}
+	return sigs, nil
+}
+
+// Prune deletes expired signals and returns how many were removed.
+func (b *Broker) Prune(ctx context.Context) (int, error) {
+	entries, err := b.store.List(ctx, keyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	pruned := 0
+	for _, e := range entries {
+		var sig Signal
+		if err := json.Unmarshal(e.Value, &sig); err == nil && now.Before(sig.ExpiresAt) {
+			continue
+		}
+		if err := b.store.Delete(ctx, e.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (b *Broker) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = b.Prune(ctx)
+		}
+	}
+}
+
+func mailbox(reefID, agentID string) string {
+	return keyPrefix + reefID + "/" + agentID + "/"
+}
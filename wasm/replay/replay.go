@@ -0,0 +1,206 @@
+// Package replay rejects reuse of one-time referral tickets. A Guard
+// records each ticket's jti in a store.Store until the ticket expires;
+// presenting the same jti again fails with ErrReplayed. One-time tickets
+// must carry both jti and exp, as a jti without an expiry could not be
+// remembered for long enough.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// keyPrefix namespaces seen nonces in the store.
+const keyPrefix = "nonces/"
+
+var (
+	// ErrReplayed is returned when a ticket has already been used.
+	ErrReplayed = errors.New("ticket already used")
+
+	// ErrMissingJTI is returned for one-time tickets without a jti.
+	ErrMissingJTI = errors.New("ticket has no jti")
+
+	// ErrMissingExpiry is returned for one-time tickets without an exp.
+	ErrMissingExpiry = errors.New("ticket has no exp")
+)
+
+// Verifier validates referral tickets. It matches registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// nonce is the stored form of a seen jti.
+type nonce struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Config holds the configuration for a Guard.
+type Config struct {
+	// Store records seen nonces. Defaults to an in-memory store; use a
+	// shared backend when several instances verify tickets.
+	Store store.Store
+
+	// Intents limits one-time use to tickets with these intents. Empty
+	// applies the guard to every ticket.
+	Intents []string
+
+	// Clock decides when recorded tickets have expired. Defaults to
+	// clock.System.
+	Clock clock.Clock
+}
+
+// Guard tracks used ticket IDs.
+type Guard struct {
+	store   store.Store
+	intents map[string]bool
+	clock   clock.Clock
+}
+
+// New creates a Guard from cfg.
+func New(cfg Config) *Guard {
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	g := &Guard{store: st, clock: clock.Or(cfg.Clock)}
+	if len(cfg.Intents) > 0 {
+		g.intents = make(map[string]bool, len(cfg.Intents))
+		for _, intent := range cfg.Intents {
+			g.intents[intent] = true
+		}
+	}
+	return g
+}
+
+// Applies reports whether tickets with intent are one-time.
+func (g *Guard) Applies(intent string) bool {
+	return g.intents == nil || g.intents[intent]
+}
+
+// Use records jti as used until expiresAt and returns ErrReplayed if it
+// was already recorded and has not expired. A zero expiresAt fails with
+// ErrMissingExpiry.
+func (g *Guard) Use(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" || strings.Contains(jti, "/") {
+		return ErrMissingJTI
+	}
+	if expiresAt.IsZero() {
+		return ErrMissingExpiry
+	}
+	key := keyPrefix + jti
+	data, err := json.Marshal(nonce{ExpiresAt: expiresAt.UTC()})
+	if err != nil {
+		return err
+	}
+
+	_, err = g.store.CompareAndSwap(ctx, key, 0, data)
+	if !errors.Is(err, store.ErrConflict) {
+		return err
+	}
+
+	// The jti is known. It may only be reused once the old record has
+	// expired and not been pruned yet.
+	entry, err := g.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return g.Use(ctx, jti, expiresAt)
+	}
+	if err != nil {
+		return err
+	}
+	var seen nonce
+	if err := json.Unmarshal(entry.Value, &seen); err == nil && g.clock.Now().Before(seen.ExpiresAt) {
+		return fmt.Errorf("%w: %s", ErrReplayed, jti)
+	}
+	if _, err := g.store.CompareAndSwap(ctx, key, entry.Revision, data); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return fmt.Errorf("%w: %s", ErrReplayed, jti)
+		}
+		return err
+	}
+	return nil
+}
+
+// Check records claims' jti when the guard applies to their intent. It
+// fails with ErrMissingJTI or ErrMissingExpiry for claims without jti or
+// exp.
+func (g *Guard) Check(ctx context.Context, claims *jwt.ReferralClaims) error {
+	if !g.Applies(claims.Intent) {
+		return nil
+	}
+	if claims.ID == "" {
+		return ErrMissingJTI
+	}
+	if claims.ExpiresAt == nil {
+		return ErrMissingExpiry
+	}
+	return g.Use(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// Prune deletes records of expired tickets and returns how many were
+// removed.
+func (g *Guard) Prune(ctx context.Context) (int, error) {
+	entries, err := g.store.List(ctx, keyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := g.clock.Now()
+	pruned := 0
+	for _, e := range entries {
+		var seen nonce
+		if err := json.Unmarshal(e.Value, &seen); err == nil && now.Before(seen.ExpiresAt) {
+			continue
+		}
+		if err := g.store.Delete(ctx, e.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (g *Guard) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = g.Prune(ctx)
+		}
+	}
+}
+
+// Verifier wraps inner so that one-time tickets verify only once. Place it
+// outermost so tickets rejected for other reasons are not consumed.
+func (g *Guard) Verifier(inner Verifier) Verifier {
+	return &verifier{guard: g, inner: inner}
+}
+
+type verifier struct {
+	guard *Guard
+	inner Verifier
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.guard.Check(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
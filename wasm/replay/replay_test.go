@@ -0,0 +1,74 @@
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replay"
+)
+
+// ticket returns claims with jti and intent expiring at exp, or without
+// exp when it is zero.
+func ticket(jti, intent string, exp time.Time) *jwt.ReferralClaims {
+	c := &jwt.ReferralClaims{ReefID: "reef", ColonyID: "colony", AgentID: "agent", Intent: intent}
+	c.ID = jti
+	if !exp.IsZero() {
+		c.ExpiresAt = gojwt.NewNumericDate(exp)
+	}
+	return c
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	now := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := replay.New(replay.Config{Intents: []string{"enroll"}, Clock: now})
+	exp := now.Now().Add(time.Minute)
+
+	if err := g.Check(ctx, ticket("one", "enroll", exp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(ctx, ticket("one", "enroll", exp)); !errors.Is(err, replay.ErrReplayed) {
+		t.Fatalf("second use: %v, want ErrReplayed", err)
+	}
+	// Other intents are not one-time.
+	for range 2 {
+		if err := g.Check(ctx, ticket("many", "register", exp)); err != nil {
+			t.Fatalf("reusable ticket: %v", err)
+		}
+	}
+
+	// The record outlives nothing but the ticket, on the guard's clock.
+	if n, err := g.Prune(ctx); err != nil || n != 0 {
+		t.Fatalf("pruned %d records (%v) before expiry", n, err)
+	}
+	now.Advance(time.Minute)
+	if n, err := g.Prune(ctx); err != nil || n != 1 {
+		t.Fatalf("pruned %d records (%v) at expiry, want 1", n, err)
+	}
+}
+
+// TestMissingClaims checks that one-time tickets without jti or exp are
+// refused rather than accepted again and again.
+func TestMissingClaims(t *testing.T) {
+	ctx := context.Background()
+	g := replay.New(replay.Config{})
+	exp := time.Now().Add(time.Minute)
+
+	for range 2 {
+		if err := g.Check(ctx, ticket("forever", "enroll", time.Time{})); !errors.Is(err, replay.ErrMissingExpiry) {
+			t.Fatalf("ticket without exp: %v, want ErrMissingExpiry", err)
+		}
+	}
+	if err := g.Check(ctx, ticket("", "enroll", exp)); !errors.Is(err, replay.ErrMissingJTI) {
+		t.Fatalf("ticket without jti: %v, want ErrMissingJTI", err)
+	}
+	if err := g.Use(ctx, "forever", time.Time{}); !errors.Is(err, replay.ErrMissingExpiry) {
+		t.Fatalf("use without expiry: %v, want ErrMissingExpiry", err)
+	}
+}
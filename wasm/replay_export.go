@@ -0,0 +1,76 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/dostore"
+)
+
+// openReplayGuard creates a one-time ticket guard persisted to Durable
+// Object storage. Route every consume for a deployment to the same object
+// so that concurrent uses of one ticket are serialized.
+// Arguments: storage (DurableObjectStorage)
+// Returns: { consume, prune } where each method returns a Promise.
+func openReplayGuard(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: storage")
+	}
+
+	h := &replayHandle{guard: replay.New(replay.Config{Store: dostore.New(args[0])})}
+	return map[string]interface{}{
+		"consume": promisify(h.consume),
+		"prune":   promisify(h.prune),
+	}
+}
+
+// replayHandle binds replay exports to a single Guard.
+type replayHandle struct {
+	guard *replay.Guard
+}
+
+// consume records a ticket's jti until the ticket expires. The ticket is
+// not verified here; call it only after verifySignature succeeded.
+// Arguments: tokenString
+// Returns: { jti, exp }
+func (h *replayHandle) consume(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: tokenString")
+	}
+	_, claims, err := decodeTicket(args[0].String())
+	if err != nil {
+		return errorResult(errTokenMalformed, "failed to parse token: "+err.Error())
+	}
+	if claims.ExpiresAt == nil {
+		return errorResult(errTokenClaims, "ticket has no exp")
+	}
+
+	err = h.guard.Use(context.Background(), claims.ID, claims.ExpiresAt.Time)
+	switch {
+	case errors.Is(err, replay.ErrReplayed):
+		return errorResult(errTokenReplayed, err.Error())
+	case errors.Is(err, replay.ErrMissingJTI):
+		return errorResult(errTokenClaims, err.Error())
+	case err != nil:
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{
+		"jti": claims.ID,
+		"exp": claims.ExpiresAt.Unix(),
+	}
+}
+
+// prune deletes records of expired tickets. Call it from the Durable
+// Object alarm handler.
+// Returns: { pruned }
+func (h *replayHandle) prune(this js.Value, args []js.Value) interface{} {
+	pruned, err := h.guard.Prune(context.Background())
+	if err != nil {
+		return errorResult(errInternal, err.Error())
+	}
+	return map[string]interface{}{"pruned": pruned}
+}
@@ -0,0 +1,323 @@
+// Package replication keeps the registries of an active-active
+// deployment in step. Every discovery server accepts writes locally and
+// serves the writes it stored, in store revision order, at Path; each
+// replica pulls its peers' writes from there and merges them with
+// registry.Apply, where the later hybrid logical clock stamp of an agent
+// wins and tombstones carry deregistrations. Replicas relay the writes
+// they applied, so any connected set of peers converges. A replica
+// shutting down pushes its writes to Path instead (see Handoff).
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Path is the route serving a replica's changes to its peers.
+const Path = "/v1/replication/changes"
+
+// DefaultInterval is how often peers are pulled when the Config does not
+// say.
+const DefaultInterval = 2 * time.Second
+
+// PageSize is the most changes a response carries.
+const PageSize = 1000
+
+// ErrUnauthorized is returned for requests without the replicas' shared
+// secret.
+var ErrUnauthorized = errors.New("invalid replication secret")
+
+// Batch is a page of changes served at Path.
+type Batch struct {
+	// Instance identifies the serving replica's store. A puller seeing a
+	// new instance, after a peer lost its state, starts over.
+	Instance string `json:"instance"`
+
+	// Changes are the writes after the requested cursor.
+	Changes []*registry.Change `json:"changes"`
+
+	// Cursor continues after the last change.
+	Cursor uint64 `json:"cursor"`
+}
+
+// Config holds the configuration for a Replicator.
+type Config struct {
+	// Registry is the local registry. Required.
+	Registry *registry.Registry
+
+	// Peers are the base URLs of the other replicas' discovery servers.
+	Peers []string
+
+	// Secret is shared by the replicas and authenticates their pulls.
+	// Required.
+	Secret []byte
+
+	// Interval is how often each peer is pulled. Defaults to
+	// DefaultInterval.
+	Interval time.Duration
+
+	// HTTPClient reaches the peers. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Replicator pulls changes from peer replicas and serves the local ones.
+// It is safe for concurrent use.
+type Replicator struct {
+	registry *registry.Registry
+	peers    []string
+	secret   []byte
+	interval time.Duration
+	http     *http.Client
+	instance string
+	started  time.Time
+
+	mu      sync.Mutex
+	cursors map[string]cursor
+	synced  map[string]time.Time // when each peer was last pulled up
+}
+
+// cursor is how far a peer's changes have been applied.
+type cursor struct {
+	instance string
+	after    uint64
+}
+
+// New creates a Replicator from cfg.
+func New(cfg Config) (*Replicator, error) {
+	if cfg.Registry == nil || len(cfg.Secret) == 0 {
+		return nil, errors.New("replication: Registry and Secret are required")
+	}
+	for _, peer := range cfg.Peers {
+		if u, err := url.Parse(peer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("replication: invalid peer URL %q", peer)
+		}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	instance := make([]byte, 8)
+	_, _ = rand.Read(instance)
+	return &Replicator{
+		registry: cfg.Registry,
+		peers:    cfg.Peers,
+		secret:   cfg.Secret,
+		interval: cfg.Interval,
+		http:     cfg.HTTPClient,
+		instance: hex.EncodeToString(instance),
+		started:  time.Now(),
+		cursors:  map[string]cursor{},
+		synced:   map[string]time.Time{},
+	}, nil
+}
+
+// Authenticate checks that token is the replicas' shared secret.
+func (r *Replicator) Authenticate(token string) error {
+	got, want := sha256.Sum256([]byte(token)), sha256.Sum256(r.secret)
+	if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Changes returns the page of local changes after cursor, for Path.
+func (r *Replicator) Changes(ctx context.Context, after uint64) (*Batch, error) {
+	changes, next, err := r.registry.Changes(ctx, after, PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{Instance: r.instance, Changes: changes, Cursor: next}, nil
+}
+
+// Receive applies a batch of changes a peer pushed to Path, as one
+// handing off its leases before it shuts down does. It returns the
+// number of changes that were newer than the local state.
+func (r *Replicator) Receive(ctx context.Context, batch *Batch) (int, error) {
+	applied := 0
+	for _, ch := range batch.Changes {
+		ok, err := r.registry.Apply(ctx, ch)
+		if err != nil && !errors.Is(err, registry.ErrInvalidRecord) {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// Handoff pushes every local change to each peer, so that the writes
+// since their last pull, such as renewals while draining, are not lost
+// when this replica shuts down. It returns the first error and carries
+// on with the other peers.
+func (r *Replicator) Handoff(ctx context.Context) error {
+	var first error
+	for after := uint64(0); ; {
+		batch, err := r.Changes(ctx, after)
+		if err != nil {
+			return err
+		}
+		if len(batch.Changes) > 0 {
+			for _, peer := range r.peers {
+				if err := r.push(ctx, peer, batch); err != nil && first == nil {
+					first = err
+				}
+			}
+		}
+		if len(batch.Changes) < PageSize {
+			return first
+		}
+		after = batch.Cursor
+	}
+}
+
+// Sync pulls and applies every peer's pending changes once. It returns
+// the first error and carries on with the other peers.
+func (r *Replicator) Sync(ctx context.Context) error {
+	var first error
+	for _, peer := range r.peers {
+		if err := r.pull(ctx, peer); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Run calls Sync every interval until ctx is done.
+func (r *Replicator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = r.Sync(ctx)
+		}
+	}
+}
+
+// Lag returns, for each peer, how long ago all of its changes were last
+// applied, or, for peers never pulled up, how long ago the Replicator
+// was created. Lag grows by up to the pull interval between syncs even
+// with every peer reachable.
+func (r *Replicator) Lag() map[string]time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	lag := make(map[string]time.Duration, len(r.peers))
+	for _, peer := range r.peers {
+		synced, ok := r.synced[peer]
+		if !ok {
+			synced = r.started
+		}
+		lag[peer] = now.Sub(synced)
+	}
+	return lag
+}
+
+// pull applies peer's changes page by page until it has no more.
+func (r *Replicator) pull(ctx context.Context, peer string) error {
+	for {
+		r.mu.Lock()
+		cur := r.cursors[peer]
+		r.mu.Unlock()
+
+		batch, err := r.fetch(ctx, peer, cur.after)
+		if err != nil {
+			return err
+		}
+		if batch.Instance != cur.instance && cur.after != 0 {
+			// The peer's store is new: its revisions started over.
+			r.setCursor(peer, cursor{instance: batch.Instance})
+			continue
+		}
+		for _, ch := range batch.Changes {
+			if _, err := r.registry.Apply(ctx, ch); err != nil && !errors.Is(err, registry.ErrInvalidRecord) {
+				return fmt.Errorf("replica %s: %w", peer, err)
+			}
+		}
+		r.setCursor(peer, cursor{instance: batch.Instance, after: batch.Cursor})
+		if len(batch.Changes) < PageSize {
+			r.mu.Lock()
+			r.synced[peer] = time.Now()
+			r.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+func (r *Replicator) setCursor(peer string, cur cursor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cursors[peer] = cur
+}
+
+// fetch requests the page of peer's changes after cursor after.
+func (r *Replicator) fetch(ctx context.Context, peer string, after uint64) (*Batch, error) {
+	u := strings.TrimSuffix(peer, "/") + Path + "?after=" + strconv.FormatUint(after, 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(r.secret))
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("replica %s: %w", peer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("replica %s: %s: %s", peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var batch Batch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("replica %s: %w", peer, err)
+	}
+	return &batch, nil
+}
+
+// push posts batch to peer.
+func (r *Replicator) push(ctx context.Context, peer string, batch *Batch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(peer, "/") + Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(r.secret))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("replica %s: %w", peer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("replica %s: %s: %s", peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
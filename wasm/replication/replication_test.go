@@ -0,0 +1,362 @@
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replication"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/testutil"
+)
+
+// noTickets rejects every ticket: the tests act as agents through
+// auth.NewContext instead.
+type noTickets struct{}
+
+func (noTickets) ValidateReferralTicket(string) (*jwt.ReferralClaims, error) {
+	return nil, errors.New("no tickets")
+}
+
+// as returns a context acting as agentID with intent.
+func as(agentID, intent string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{
+		Method:         auth.MethodTicket,
+		ReferralClaims: &jwt.ReferralClaims{ReefID: "reef", ColonyID: "colony", AgentID: agentID, Intent: intent},
+	})
+}
+
+// cluster is a set of replicas pulling from each other over a
+// testutil.Network, sharing a fake clock.
+type cluster struct {
+	t        *testing.T
+	names    []string
+	clock    *clock.Fake
+	network  *testutil.Network
+	stores   map[string]*testutil.Store
+	replicas map[string]*registry.Registry
+	pullers  map[string]*replication.Replicator
+}
+
+// newCluster starts replicas named names, each a host of the network
+// pulling from all the others.
+func newCluster(t *testing.T, seed uint64, names ...string) *cluster {
+	c := &cluster{
+		t:        t,
+		names:    names,
+		clock:    clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		network:  testutil.NewNetwork(seed),
+		stores:   map[string]*testutil.Store{},
+		replicas: map[string]*registry.Registry{},
+		pullers:  map[string]*replication.Replicator{},
+	}
+	for i, name := range names {
+		st := testutil.NewStore(store.NewMemory(), seed+uint64(i)+1)
+		reg, err := registry.New(registry.Config{
+			Store:       st,
+			Verifier:    noTickets{},
+			ReplicaID:   name,
+			GracePeriod: 30 * time.Second,
+			Clock:       c.clock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var peers []string
+		for _, peer := range names {
+			if peer != name {
+				peers = append(peers, "http://"+peer)
+			}
+		}
+		rep, err := replication.New(replication.Config{
+			Registry:   reg,
+			Peers:      peers,
+			Secret:     []byte("secret"),
+			HTTPClient: c.network.Client(name),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.network.Handle(name, server.New(server.Config{Registry: reg, Verifier: noTickets{}, Replication: rep}))
+		c.stores[name], c.replicas[name], c.pullers[name] = st, reg, rep
+	}
+	return c
+}
+
+func (c *cluster) register(replica, agentID string) *registry.Record {
+	c.t.Helper()
+	rec, err := c.replicas[replica].Register(as(agentID, registry.IntentRegister), "", &registry.Record{
+		AgentID:    agentID,
+		ColonyID:   "colony",
+		ReefID:     "reef",
+		Endpoints:  []string{replica + ":9000"},
+		TTLSeconds: 60,
+	})
+	if err != nil {
+		c.t.Fatalf("register %s on %s: %v", agentID, replica, err)
+	}
+	return rec
+}
+
+func (c *cluster) renew(replica, agentID string) {
+	c.t.Helper()
+	if _, err := c.replicas[replica].Renew(as(agentID, registry.IntentRenew), "", agentID); err != nil {
+		c.t.Fatalf("renew %s on %s: %v", agentID, replica, err)
+	}
+}
+
+func (c *cluster) deregister(replica, agentID string) {
+	c.t.Helper()
+	if err := c.replicas[replica].Deregister(as(agentID, registry.IntentRegister), "", agentID); err != nil {
+		c.t.Fatalf("deregister %s on %s: %v", agentID, replica, err)
+	}
+}
+
+// sync pulls each replica's peers once, ignoring unreachable ones.
+func (c *cluster) sync() {
+	for _, name := range c.names {
+		_ = c.pullers[name].Sync(context.Background())
+	}
+}
+
+// converge syncs the replicas until every one has relayed its writes to
+// every other, then fails the test unless they hold the same state.
+func (c *cluster) converge() map[string]string {
+	c.t.Helper()
+	for range c.names {
+		c.sync()
+	}
+	first := c.names[0]
+	want := c.state(first)
+	for _, name := range c.names[1:] {
+		got := c.state(name)
+		agents := slices.Collect(maps.Keys(want))
+		for agent := range got {
+			if _, ok := want[agent]; !ok {
+				agents = append(agents, agent)
+			}
+		}
+		slices.Sort(agents)
+		for _, agent := range agents {
+			if got[agent] != want[agent] {
+				c.t.Errorf("%s is %q on %s, %q on %s", agent, want[agent], first, got[agent], name)
+			}
+		}
+	}
+	if c.t.Failed() {
+		c.t.FailNow()
+	}
+	return want
+}
+
+// state returns the writes replica holds, by agent: the endpoint of a
+// record or "deleted" for a tombstone, and the version.
+func (c *cluster) state(replica string) map[string]string {
+	c.t.Helper()
+	changes, _, err := c.replicas[replica].Changes(context.Background(), 0, 0)
+	if err != nil {
+		c.t.Fatalf("%s: %v", replica, err)
+	}
+	out := map[string]string{}
+	for _, ch := range changes {
+		if ch.Record != nil {
+			out[ch.Record.AgentID] = ch.Record.Endpoints[0] + " " + ch.Record.Version.String()
+		} else if _, ok := out[ch.Tombstone.AgentID]; !ok {
+			out[ch.Tombstone.AgentID] = "deleted " + ch.Tombstone.Version.String()
+		}
+	}
+	return out
+}
+
+// live reports whether replica serves agentID.
+func (c *cluster) live(replica, agentID string) bool {
+	c.t.Helper()
+	_, err := c.replicas[replica].Lookup(context.Background(), "reef", agentID)
+	if err != nil && !errors.Is(err, registry.ErrNotFound) {
+		c.t.Fatalf("lookup %s on %s: %v", agentID, replica, err)
+	}
+	return err == nil
+}
+
+// TestConvergeAfterPartition writes to both sides of a partition and
+// checks that the later write of each agent wins everywhere once it
+// heals.
+func TestConvergeAfterPartition(t *testing.T) {
+	c := newCluster(t, 1, "a", "b", "c")
+	c.register("a", "moved")
+	c.register("a", "left")
+	c.converge()
+
+	c.network.Partition([]string{"a"}, []string{"b", "c"})
+	c.clock.Advance(time.Second)
+	c.deregister("a", "moved")
+	c.renew("b", "left")
+	c.clock.Advance(time.Second)
+	c.register("b", "moved")
+	c.deregister("a", "left")
+	c.register("c", "new-bc")
+	c.register("a", "new-a")
+	c.sync()
+	if c.live("a", "moved") || !c.live("b", "moved") || c.live("a", "new-bc") || c.live("c", "new-a") {
+		t.Fatal("writes crossed the partition")
+	}
+
+	c.network.Heal()
+	c.converge()
+	for _, replica := range c.names {
+		for agent, want := range map[string]bool{"moved": true, "left": false, "new-bc": true, "new-a": true} {
+			if got := c.live(replica, agent); got != want {
+				t.Errorf("%s serves %s: %t, want %t", replica, agent, got, want)
+			}
+		}
+	}
+}
+
+// TestLag checks that peers a replica cannot pull fall behind the others.
+func TestLag(t *testing.T) {
+	c := newCluster(t, 4, "a", "b", "c")
+	c.network.Partition([]string{"a", "b"}, []string{"c"})
+	before := c.pullers["a"].Lag()
+	c.sync()
+
+	lag := c.pullers["a"].Lag()
+	if len(lag) != 2 {
+		t.Fatalf("lag of %d peers, want 2", len(lag))
+	}
+	if lag["http://c"] < before["http://c"] {
+		t.Errorf("lag of unreachable peer c fell from %s to %s", before["http://c"], lag["http://c"])
+	}
+	if lag["http://b"] >= lag["http://c"] {
+		t.Errorf("lag of b (%s) not below that of unreachable c (%s) after a sync", lag["http://b"], lag["http://c"])
+	}
+}
+
+// TestConvergeThroughRelays checks that replicas that cannot reach each
+// other converge through a replica both can.
+func TestConvergeThroughRelays(t *testing.T) {
+	c := newCluster(t, 2, "a", "b", "c")
+	c.network.Cut("a", "c")
+	c.network.Cut("c", "a")
+	c.register("a", "from-a")
+	c.register("c", "from-c")
+	state := c.converge()
+	if len(state) != 2 {
+		t.Fatalf("state %v, want both agents", state)
+	}
+}
+
+// TestLeasesExpireEverywhere checks that replicas do not revive leases
+// their peers' reapers evicted, and that renewals made on one side of a
+// partition save the lease from the reaper on the other.
+func TestLeasesExpireEverywhere(t *testing.T) {
+	c := newCluster(t, 3, "a", "b")
+	c.register("a", "expired")
+	c.register("a", "renewed")
+	c.converge()
+
+	c.network.Partition([]string{"a"}, []string{"b"})
+	c.clock.Advance(50 * time.Second)
+	c.renew("b", "renewed")
+	c.clock.Advance(11 * time.Second)
+	for _, replica := range c.names {
+		if c.live(replica, "expired") {
+			t.Errorf("%s serves an expired lease", replica)
+		}
+	}
+	c.clock.Advance(30 * time.Second)
+	if n, err := c.replicas["a"].Reap(context.Background()); err != nil || n != 2 {
+		t.Fatalf("a reaped %d leases (%v), want 2", n, err)
+	}
+
+	c.network.Heal()
+	c.sync()
+	if c.live("a", "expired") {
+		t.Error("a revived the expired lease from b")
+	}
+	if !c.live("a", "renewed") {
+		t.Error("a lost the renewal made on b")
+	}
+	if n, err := c.replicas["b"].Reap(context.Background()); err != nil || n != 1 {
+		t.Fatalf("b reaped %d leases (%v), want 1", n, err)
+	}
+	c.converge()
+}
+
+// TestConvergeUnderFaults runs agents writing to random replicas while
+// the replicas pull each other over a slow, lossy network from stores
+// with torn listings, then checks that they converge once the faults
+// stop.
+func TestConvergeUnderFaults(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	t.Logf("seed %d", seed)
+	c := newCluster(t, seed, "a", "b", "c")
+	c.network.SetLatency(0, time.Millisecond)
+	c.network.DropRequests(0.1)
+	c.network.DropResponses(0.1)
+	for _, st := range c.stores {
+		st.SetLatency(0, time.Millisecond)
+		st.SetTornLists(true)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var syncers sync.WaitGroup
+	for _, name := range c.names {
+		syncers.Add(1)
+		go func() {
+			defer syncers.Done()
+			for ctx.Err() == nil {
+				_ = c.pullers[name].Sync(ctx)
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for w := range 4 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			rng := rand.New(rand.NewPCG(seed, uint64(w)))
+			for range 100 {
+				replica := c.names[rng.IntN(len(c.names))]
+				agentID := "agent-" + strconv.Itoa(rng.IntN(200))
+				reg := c.replicas[replica]
+				var err error
+				switch rng.IntN(3) {
+				case 0:
+					err = reg.Deregister(as(agentID, registry.IntentRegister), "", agentID)
+				case 1:
+					_, err = reg.Renew(as(agentID, registry.IntentRenew), "", agentID)
+				default:
+					_, err = reg.Register(as(agentID, registry.IntentRegister), "", &registry.Record{
+						AgentID: agentID, ColonyID: "colony", ReefID: "reef", Endpoints: []string{replica + ":9000"},
+					})
+				}
+				if err != nil && !errors.Is(err, registry.ErrNotFound) && !errors.Is(err, store.ErrConflict) {
+					t.Errorf("%s on %s: %v", agentID, replica, err)
+				}
+				if w == 0 {
+					c.clock.Advance(time.Millisecond)
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	cancel()
+	syncers.Wait()
+
+	c.network.DropRequests(0)
+	c.network.DropResponses(0)
+	c.converge()
+}
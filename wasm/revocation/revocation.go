@@ -0,0 +1,178 @@
+// Package revocation tracks revoked referral tickets by their reef and
+// jti claim. A List persists revocations in a store.Store until the
+// revoked ticket would have expired anyway, wraps a Verifier to reject
+// revoked tickets, and produces Snapshots for distribution to offline
+// verifiers such as the Wasm module. A reef's revocations only apply to
+// its own tickets, so that one reef cannot revoke another's.
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// IntentRevoke is the ticket intent required to revoke tickets.
+const IntentRevoke = "revoke"
+
+// keyPrefix namespaces revocations in the store; a revocation lives under
+// keyPrefix + reef + "/" + jti.
+const keyPrefix = "revocations/"
+
+var (
+	// ErrRevoked is returned when a ticket has been revoked.
+	ErrRevoked = errors.New("ticket revoked")
+
+	// ErrInvalidJTI is returned when revoking an empty ticket ID, or
+	// one without a reef.
+	ErrInvalidJTI = errors.New("invalid ticket id")
+)
+
+// Verifier validates referral tickets. It matches registry.Verifier.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// entry is the stored form of a revocation.
+type entry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Config holds the configuration for a List.
+type Config struct {
+	// Store persists revocations. Defaults to an in-memory store.
+	Store store.Store
+}
+
+// List is the authoritative revocation list.
+type List struct {
+	store store.Store
+	hub   *hub
+}
+
+// New creates a List from cfg.
+func New(cfg Config) *List {
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	return &List{store: st, hub: newHub()}
+}
+
+// Revoke marks jti of reefID as revoked until expiresAt, which should be
+// the revoked ticket's exp claim; after that the ticket fails
+// verification on its own and the entry is pruned.
+func (l *List) Revoke(ctx context.Context, reefID, jti string, expiresAt time.Time) error {
+	if jti == "" || strings.Contains(jti, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidJTI, jti)
+	}
+	if reefID == "" || strings.Contains(reefID, "/") {
+		return fmt.Errorf("%w: reef %q", ErrInvalidJTI, reefID)
+	}
+	data, err := json.Marshal(entry{ExpiresAt: expiresAt.UTC()})
+	if err != nil {
+		return err
+	}
+	if _, err := l.store.Put(ctx, revocationKey(reefID, jti), data); err != nil {
+		return err
+	}
+	l.hub.publish(Revocation{ReefID: reefID, JTI: jti, ExpiresAt: expiresAt.UTC()})
+	return nil
+}
+
+// IsRevoked reports whether jti of reefID is currently revoked.
+func (l *List) IsRevoked(ctx context.Context, reefID, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	e, err := l.store.Get(ctx, revocationKey(reefID, jti))
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var rev entry
+	if err := json.Unmarshal(e.Value, &rev); err != nil {
+		return false, fmt.Errorf("failed to decode revocation: %w", err)
+	}
+	return time.Now().Before(rev.ExpiresAt), nil
+}
+
+// Snapshot returns the current revocations, pruning expired entries.
+func (l *List) Snapshot(ctx context.Context) (*Snapshot, error) {
+	now := time.Now()
+	revs, err := l.entries(ctx, keyPrefix, now)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(revs, now), nil
+}
+
+// Entries returns the current revocations of reefID with their
+// expiries, pruning expired entries.
+func (l *List) Entries(ctx context.Context, reefID string) ([]Revocation, error) {
+	return l.entries(ctx, keyPrefix+reefID+"/", time.Now())
+}
+
+func (l *List) entries(ctx context.Context, prefix string, now time.Time) ([]Revocation, error) {
+	entries, err := l.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	revs := make([]Revocation, 0, len(entries))
+	for _, e := range entries {
+		var rev entry
+		if err := json.Unmarshal(e.Value, &rev); err != nil {
+			return nil, fmt.Errorf("failed to decode revocation: %w", err)
+		}
+		if !now.Before(rev.ExpiresAt) {
+			// Best effort; a failed prune is retried on the next snapshot.
+			_ = l.store.Delete(ctx, e.Key)
+			continue
+		}
+		reefID, jti, ok := strings.Cut(strings.TrimPrefix(e.Key, keyPrefix), "/")
+		if !ok {
+			continue
+		}
+		revs = append(revs, Revocation{ReefID: reefID, JTI: jti, ExpiresAt: rev.ExpiresAt})
+	}
+	return revs, nil
+}
+
+// Verifier wraps inner so that revoked tickets fail validation.
+func (l *List) Verifier(inner Verifier) Verifier {
+	return &verifier{list: l, inner: inner}
+}
+
+type verifier struct {
+	list  *List
+	inner Verifier
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	claims, err := v.inner.ValidateReferralTicket(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := v.list.IsRevoked(context.Background(), claims.ReefID, claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check revocation: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: %s", ErrRevoked, claims.ID)
+	}
+	return claims, nil
+}
+
+func revocationKey(reefID, jti string) string {
+	return keyPrefix + reefID + "/" + jti
+}
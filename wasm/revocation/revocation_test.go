@@ -0,0 +1,65 @@
+package revocation_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+)
+
+// tickets maps each token string to the claims it verifies as.
+type tickets map[string]*jwt.ReferralClaims
+
+func (t tickets) ValidateReferralTicket(token string) (*jwt.ReferralClaims, error) {
+	return t[token], nil
+}
+
+// TestReefScope revokes a jti in one reef and checks that the ticket with
+// the same jti in another reef stays valid, online and in snapshots.
+func TestReefScope(t *testing.T) {
+	ctx := context.Background()
+	l := revocation.New(revocation.Config{})
+	if err := l.Revoke(ctx, "a", "jti", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	tick := func(reef string) *jwt.ReferralClaims {
+		c := &jwt.ReferralClaims{ReefID: reef, ColonyID: "c", AgentID: "x"}
+		c.ID = "jti"
+		return c
+	}
+	v := l.Verifier(tickets{"in-a": tick("a"), "in-b": tick("b")})
+	if _, err := v.ValidateReferralTicket("in-a"); !errors.Is(err, revocation.ErrRevoked) {
+		t.Fatalf("revoked ticket: %v, want ErrRevoked", err)
+	}
+	if _, err := v.ValidateReferralTicket("in-b"); err != nil {
+		t.Fatalf("ticket of another reef: %v", err)
+	}
+
+	snap, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap, err = revocation.ParseSnapshot(blob); err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Contains("a", "jti") || snap.Contains("b", "jti") {
+		t.Fatalf("snapshot contains a: %v, b: %v; want true, false", snap.Contains("a", "jti"), snap.Contains("b", "jti"))
+	}
+
+	revs, err := l.Entries(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 0 {
+		t.Fatalf("reef b lists %v", revs)
+	}
+}
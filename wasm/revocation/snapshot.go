@@ -0,0 +1,107 @@
+package revocation
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// snapshotMagic prefixes the binary encoding of a Snapshot. Version 1
+// hashed bare jtis, before revocations were scoped to reefs.
+var snapshotMagic = [4]byte{'C', 'R', 'L', '2'}
+
+// snapshotHeader is magic, generated-at (Unix seconds) and count.
+const snapshotHeader = 4 + 8 + 4
+
+// ErrMalformedSnapshot is returned when a snapshot blob cannot be decoded.
+var ErrMalformedSnapshot = errors.New("malformed revocation snapshot")
+
+// Snapshot is a compact, immutable set of revoked ticket IDs suitable for
+// distribution to verifiers. Each revocation is stored as the first 8
+// bytes of the SHA-256 digest of its reef and jti in a sorted array, so
+// lookups are a binary search and false positives need a 64-bit
+// collision.
+type Snapshot struct {
+	GeneratedAt time.Time
+	hashes      []uint64
+}
+
+// NewSnapshot builds a Snapshot from revs.
+func NewSnapshot(revs []Revocation, generatedAt time.Time) *Snapshot {
+	hashes := make([]uint64, 0, len(revs))
+	for _, rev := range revs {
+		hashes = append(hashes, hashJTI(rev.ReefID, rev.JTI))
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	// Drop duplicates.
+	out := hashes[:0]
+	for i, h := range hashes {
+		if i == 0 || h != hashes[i-1] {
+			out = append(out, h)
+		}
+	}
+	return &Snapshot{GeneratedAt: generatedAt, hashes: out}
+}
+
+// Contains reports whether jti of reefID is revoked. A nil Snapshot
+// contains nothing.
+func (s *Snapshot) Contains(reefID, jti string) bool {
+	if s == nil || jti == "" {
+		return false
+	}
+	h := hashJTI(reefID, jti)
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	return i < len(s.hashes) && s.hashes[i] == h
+}
+
+// Len returns the number of revoked IDs.
+func (s *Snapshot) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.hashes)
+}
+
+// MarshalBinary encodes the snapshot as
+// "CRL2" | generated_at u64 | count u32 | count × hash u64, big-endian.
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, snapshotHeader+8*len(s.hashes))
+	copy(buf, snapshotMagic[:])
+	binary.BigEndian.PutUint64(buf[4:], uint64(s.GeneratedAt.Unix()))
+	binary.BigEndian.PutUint32(buf[12:], uint32(len(s.hashes)))
+	for i, h := range s.hashes {
+		binary.BigEndian.PutUint64(buf[snapshotHeader+8*i:], h)
+	}
+	return buf, nil
+}
+
+// ParseSnapshot decodes a blob produced by MarshalBinary.
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	if len(data) < snapshotHeader || [4]byte(data[:4]) != snapshotMagic {
+		return nil, ErrMalformedSnapshot
+	}
+	count := int(binary.BigEndian.Uint32(data[12:]))
+	if len(data) != snapshotHeader+8*count {
+		return nil, ErrMalformedSnapshot
+	}
+
+	hashes := make([]uint64, count)
+	for i := range hashes {
+		hashes[i] = binary.BigEndian.Uint64(data[snapshotHeader+8*i:])
+		if i > 0 && hashes[i] <= hashes[i-1] {
+			return nil, ErrMalformedSnapshot
+		}
+	}
+	return &Snapshot{
+		GeneratedAt: time.Unix(int64(binary.BigEndian.Uint64(data[4:])), 0),
+		hashes:      hashes,
+	}, nil
+}
+
+func hashJTI(reefID, jti string) uint64 {
+	sum := sha256.Sum256([]byte(reefID + "/" + jti))
+	return binary.BigEndian.Uint64(sum[:8])
+}
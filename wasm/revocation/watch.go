@@ -0,0 +1,71 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// watchBuffer is the per-subscriber buffer. Subscribers that fall further
+// behind are disconnected and should refetch the Snapshot.
+const watchBuffer = 64
+
+// Revocation is a revoked ticket, as streamed by Watch.
+type Revocation struct {
+	ReefID    string    `json:"reef_id"`
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Watch streams revocations made through this List until ctx is done.
+// Revocations made by other instances sharing the store are not seen;
+// poll Snapshot to catch those. The channel is closed when the
+// subscription ends, including when the subscriber falls too far behind.
+func (l *List) Watch(ctx context.Context) <-chan Revocation {
+	return l.hub.subscribe(ctx)
+}
+
+// hub fans revocations out to subscribers.
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan Revocation]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[chan Revocation]struct{})}
+}
+
+func (h *hub) subscribe(ctx context.Context) <-chan Revocation {
+	ch := make(chan Revocation, watchBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.remove(ch)
+	}()
+	return ch
+}
+
+func (h *hub) publish(rev Revocation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- rev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *hub) remove(ch chan Revocation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"sync"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+)
+
+// revoked is the module-level revocation snapshot consulted by every
+// verification. It starts empty.
+var revoked struct {
+	mu       sync.RWMutex
+	snapshot *revocation.Snapshot
+}
+
+// isRevoked reports whether jti of reefID is in the loaded revocation
+// snapshot.
+func isRevoked(reefID, jti string) bool {
+	revoked.mu.RLock()
+	defer revoked.mu.RUnlock()
+	return revoked.snapshot.Contains(reefID, jti)
+}
+
+// loadRevocations replaces the revocation snapshot with the blob served
+// by GET /v1/revocations.
+// Arguments: blob (Uint8Array)
+// Returns: { count: number, generatedAt: number } or { error: { code, message, retryable } }
+func loadRevocations(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: blob")
+	}
+
+	blob, err := bytesFromJS(args[0])
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	snap, err := revocation.ParseSnapshot(blob)
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	revoked.mu.Lock()
+	revoked.snapshot = snap
+	revoked.mu.Unlock()
+
+	return map[string]interface{}{
+		"count":       snap.Len(),
+		"generatedAt": snap.GeneratedAt.Unix(),
+	}
+}
@@ -0,0 +1,260 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// initialisms are the name parts generated field names spell in capitals.
+var initialisms = []string{"api", "cpu", "dns", "gpu", "http", "https", "id", "ip", "json", "os", "rtt", "tcp", "tls", "ttl", "udp", "uri", "url", "uuid"}
+
+// Generate returns Go source for package pkg declaring name, a type for
+// the documents matching the schema, and the types of their nested
+// objects, named after name and the property holding them. It gives
+// typed access to agent metadata (see client.Metadata). Objects with
+// properties become structs whose optional fields are pointers or, for
+// slices and maps, omitted when empty; other objects become maps, and
+// values of no single type any.
+func (s *Schema) Generate(pkg, name string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("invalid package name %q", pkg)
+	}
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return nil, fmt.Errorf("invalid type name %q: want an exported identifier", name)
+	}
+	g := &generator{names: map[string]bool{name: true}}
+	doc := fmt.Sprintf("%s is the metadata of an agent.", name)
+	if s.root.title != "" {
+		doc = fmt.Sprintf("%s is the metadata of an agent: %s.", name, strings.TrimSuffix(s.root.title, "."))
+	}
+	if err := g.declare(s.root, name, doc); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.WriteString("// Code generated from a colony metadata schema. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n", pkg)
+	for _, decl := range g.decls {
+		out.WriteString("\n")
+		out.WriteString(decl)
+	}
+	return out.Bytes(), nil
+}
+
+// generator collects the type declarations of a schema.
+type generator struct {
+	decls []string
+	names map[string]bool
+}
+
+// declare adds the declaration of name, the type of n.
+func (g *generator) declare(n *node, name, doc string) error {
+	var b strings.Builder
+	comment(&b, "", doc, n.description)
+	if kind, _ := n.kind(); kind != "object" || len(n.properties) == 0 {
+		typ, err := g.goType(n, name+"Value", false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "type %s %s\n", name, strings.TrimPrefix(typ, "*"))
+		g.decls = append(g.decls, b.String())
+		return nil
+	}
+	// Reserve the declaration's place before those of its fields' types.
+	i := len(g.decls)
+	g.decls = append(g.decls, "")
+	fmt.Fprintf(&b, "type %s struct {\n", name)
+	var group bytes.Buffer
+	tw := tabwriter.NewWriter(&group, 0, 8, 1, ' ', 0)
+	flush := func() {
+		tw.Flush()
+		for _, line := range strings.SplitAfter(group.String(), "\n") {
+			if line != "" {
+				b.WriteString("\t" + line)
+			}
+		}
+		group.Reset()
+	}
+	fields := map[string]bool{}
+	documented := false
+	for j, prop := range slices.Sorted(maps.Keys(n.properties)) {
+		sub := n.properties[prop]
+		if strings.ContainsAny(prop, "\"\\,`") || prop == "" {
+			return fmt.Errorf("property %q of %s cannot name a struct field", prop, name)
+		}
+		field := unique(exportName(prop), fields)
+		fields[field] = true
+		typ, err := g.goType(sub, unique(name+field, g.names), !slices.Contains(n.required, prop))
+		if err != nil {
+			return err
+		}
+		tag := prop
+		if !slices.Contains(n.required, prop) {
+			tag += ",omitempty"
+		}
+		if j > 0 && (sub.description != "" || documented) {
+			flush()
+			b.WriteString("\n")
+		}
+		if documented = sub.description != ""; documented {
+			comment(&b, "\t", sub.description)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t`json:%q`\n", field, typ, tag)
+	}
+	flush()
+	b.WriteString("}\n")
+	g.decls[i] = b.String()
+	return nil
+}
+
+// goType returns the Go type of n, declaring it as name if it is an
+// object with properties. Optional scalars and objects are pointers.
+func (g *generator) goType(n *node, name string, optional bool) (string, error) {
+	kind, nullable := n.kind()
+	ptr := ""
+	if optional || nullable {
+		ptr = "*"
+	}
+	switch kind {
+	case "string":
+		return ptr + "string", nil
+	case "integer":
+		return ptr + "int64", nil
+	case "number":
+		return ptr + "float64", nil
+	case "boolean":
+		return ptr + "bool", nil
+	case "array":
+		if n.items == nil {
+			return "[]any", nil
+		}
+		item, err := g.goType(n.items, name+"Item", false)
+		return "[]" + item, err
+	case "object":
+		if len(n.properties) > 0 {
+			g.names[name] = true
+			doc := fmt.Sprintf("%s is a property of the metadata of an agent.", name)
+			if n.title != "" {
+				doc = fmt.Sprintf("%s is %s.", name, strings.TrimSuffix(n.title, "."))
+			}
+			return ptr + name, g.declare(n, name, doc)
+		}
+		if k, _ := n.additional.kind(); n.additional != nil && k != "" {
+			value, err := g.goType(n.additional, name+"Value", false)
+			return "map[string]" + value, err
+		}
+		return "map[string]any", nil
+	}
+	return "any", nil
+}
+
+// kind returns the single type of the values n allows, but null, and
+// whether it also allows null. It returns "" when n allows values of
+// several types, or of any.
+func (n *node) kind() (kind string, nullable bool) {
+	if n == nil || n.never {
+		return "", false
+	}
+	types := n.types
+	if types == nil {
+		switch {
+		case n.properties != nil || n.additional != nil:
+			return "object", false
+		case n.items != nil:
+			return "array", false
+		case n.enum != nil:
+			for _, v := range n.enum {
+				types = append(types, kindOf(v))
+			}
+		case n.constant != nil:
+			types = []string{kindOf(*n.constant)}
+		}
+	}
+	var kinds []string
+	for _, t := range types {
+		if t == "null" {
+			nullable = true
+		} else if !slices.Contains(kinds, t) {
+			kinds = append(kinds, t)
+		}
+	}
+	if len(kinds) == 2 && slices.Contains(kinds, "integer") && slices.Contains(kinds, "number") {
+		return "number", nullable
+	}
+	if len(kinds) != 1 {
+		return "", false
+	}
+	return kinds[0], nullable
+}
+
+// kindOf returns the type of v, a decoded JSON value.
+func kindOf(v any) string {
+	for _, t := range []string{"null", "boolean", "integer", "number", "string", "array", "object"} {
+		if is(v, t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// exportName returns the exported Go name of a property, joining its
+// words: "build_id" is BuildID.
+func exportName(prop string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(prop, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if slices.Contains(initialisms, strings.ToLower(word)) {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// unique returns name, numbered when taken already.
+func unique(name string, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		if n := name + strconv.Itoa(i); !taken[n] {
+			return n
+		}
+	}
+}
+
+// comment writes paragraphs as a comment indented by indent, wrapped at
+// 72 columns.
+func comment(b *strings.Builder, indent string, paragraphs ...string) {
+	for i, p := range paragraphs {
+		if p == "" {
+			continue
+		}
+		if i > 0 {
+			b.WriteString(indent + "//\n")
+		}
+		line := ""
+		for _, word := range strings.Fields(p) {
+			if line != "" && len(line)+1+len(word) > 72 {
+				b.WriteString(indent + "// " + line + "\n")
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		b.WriteString(indent + "// " + line + "\n")
+	}
+}
@@ -0,0 +1,353 @@
+// Package schema checks the metadata agents register against the JSON
+// Schema of their colony, so that consumers can rely on its shape, and
+// generates Go types from schemas for typed access to it.
+//
+// Schemas use the subset of JSON Schema 2020-12 that describes the shape
+// of a document: type, properties, required, additionalProperties, items,
+// enum, const, the numeric bounds, minLength, maxLength, pattern,
+// minItems, maxItems, uniqueItems, minProperties and maxProperties, plus
+// annotations such as title, description and format, which are not
+// enforced. Patterns are Go regular expressions and match anywhere in the
+// string unless anchored. Schemas using other keywords, such as $ref or
+// oneOf, are refused rather than partially enforced. Example:
+//
+//	{
+//	  "type": "object",
+//	  "properties": {
+//	    "build": {"type": "string", "pattern": "^[0-9a-f]{7,40}$"},
+//	    "region": {"enum": ["eu", "us"]},
+//	    "gpus": {"type": "integer", "minimum": 0}
+//	  },
+//	  "required": ["build"],
+//	  "additionalProperties": false
+//	}
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MaxSize bounds the encoded size of a schema.
+const MaxSize = 64 << 10
+
+// maxDepth bounds the nesting of subschemas.
+const maxDepth = 32
+
+var (
+	// ErrInvalid is returned for schemas that are malformed or use
+	// keywords outside the supported subset.
+	ErrInvalid = errors.New("invalid metadata schema")
+
+	// ErrMismatch is returned for documents that do not match a schema.
+	ErrMismatch = errors.New("metadata does not match schema")
+)
+
+// types are the values of the type keyword.
+var types = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// annotations are the keywords that describe values without constraining
+// them.
+var annotations = []string{"$schema", "$id", "$comment", "title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly", "format"}
+
+// Schema is a compiled schema.
+type Schema struct {
+	raw  json.RawMessage
+	root *node
+}
+
+// node is a compiled subschema. Nil fields do not constrain.
+type node struct {
+	never       bool // the false schema
+	types       []string
+	properties  map[string]*node
+	required    []string
+	additional  *node
+	items       *node
+	enum        []any
+	constant    *any
+	minimum     *float64
+	maximum     *float64
+	exclMinimum *float64
+	exclMaximum *float64
+	minLength   *int
+	maxLength   *int
+	pattern     *regexp.Regexp
+	minItems    *int
+	maxItems    *int
+	unique      bool
+	minProps    *int
+	maxProps    *int
+	title       string
+	description string
+}
+
+// Compile parses data, a schema, returning ErrInvalid when it is
+// malformed or uses keywords outside the supported subset.
+func Compile(data []byte) (*Schema, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalid, MaxSize)
+	}
+	root, err := compile(data, "#", 0)
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	if err := json.Compact(&raw, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return &Schema{raw: raw.Bytes(), root: root}, nil
+}
+
+// MarshalJSON returns the schema as compiled, compacted.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+// Title returns the title of the schema, if it has one.
+func (s *Schema) Title() string {
+	return s.root.title
+}
+
+func compile(data []byte, path string, depth int) (*node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: %s: nested deeper than %d", ErrInvalid, path, maxDepth)
+	}
+	var always bool
+	if err := json.Unmarshal(data, &always); err == nil {
+		return &node{never: !always}, nil
+	}
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil || keywords == nil {
+		return nil, fmt.Errorf("%w: %s: want an object or a boolean", ErrInvalid, path)
+	}
+	n := &node{}
+	for _, k := range slices.Sorted(maps.Keys(keywords)) {
+		v, at := keywords[k], path+"/"+k
+		var err error
+		switch k {
+		case "type":
+			err = n.compileType(v)
+		case "properties":
+			var props map[string]json.RawMessage
+			if err = json.Unmarshal(v, &props); err == nil {
+				n.properties = make(map[string]*node, len(props))
+				for _, name := range slices.Sorted(maps.Keys(props)) {
+					if n.properties[name], err = compile(props[name], at+"/"+name, depth+1); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case "required":
+			err = json.Unmarshal(v, &n.required)
+		case "additionalProperties":
+			n.additional, err = compile(v, at, depth+1)
+		case "items":
+			n.items, err = compile(v, at, depth+1)
+		case "enum":
+			if err = json.Unmarshal(v, &n.enum); err == nil && len(n.enum) == 0 {
+				err = errors.New("want at least one value")
+			}
+		case "const":
+			var c any
+			if err = json.Unmarshal(v, &c); err == nil {
+				n.constant = &c
+			}
+		case "minimum":
+			err = json.Unmarshal(v, &n.minimum)
+		case "maximum":
+			err = json.Unmarshal(v, &n.maximum)
+		case "exclusiveMinimum":
+			err = json.Unmarshal(v, &n.exclMinimum)
+		case "exclusiveMaximum":
+			err = json.Unmarshal(v, &n.exclMaximum)
+		case "minLength":
+			n.minLength, err = count(v)
+		case "maxLength":
+			n.maxLength, err = count(v)
+		case "minItems":
+			n.minItems, err = count(v)
+		case "maxItems":
+			n.maxItems, err = count(v)
+		case "minProperties":
+			n.minProps, err = count(v)
+		case "maxProperties":
+			n.maxProps, err = count(v)
+		case "uniqueItems":
+			err = json.Unmarshal(v, &n.unique)
+		case "pattern":
+			var expr string
+			if err = json.Unmarshal(v, &expr); err == nil {
+				n.pattern, err = regexp.Compile(expr)
+			}
+		case "title":
+			err = json.Unmarshal(v, &n.title)
+		case "description":
+			err = json.Unmarshal(v, &n.description)
+		default:
+			if !slices.Contains(annotations, k) {
+				return nil, fmt.Errorf("%w: %s: unsupported keyword", ErrInvalid, at)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, at, err)
+		}
+	}
+	return n, nil
+}
+
+func (n *node) compileType(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		n.types = []string{one}
+	} else if err := json.Unmarshal(data, &n.types); err != nil || len(n.types) == 0 {
+		return errors.New("want a type name or a list of them")
+	}
+	for _, t := range n.types {
+		if !slices.Contains(types, t) {
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	return nil
+}
+
+// count parses a non-negative integer keyword value.
+func count(data []byte) (*int, error) {
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil || n < 0 {
+		return nil, errors.New("want a non-negative integer")
+	}
+	return &n, nil
+}
+
+// Validate checks that doc, a JSON document, matches the schema,
+// returning ErrMismatch naming the first value that does not.
+func (s *Schema) Validate(doc []byte) error {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMismatch, err)
+	}
+	return s.root.validate(v, "$")
+}
+
+func (n *node) validate(v any, path string) error {
+	mismatch := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s %s", ErrMismatch, path, fmt.Sprintf(format, args...))
+	}
+	if n.never {
+		return mismatch("is not allowed")
+	}
+	if n.types != nil && !slices.ContainsFunc(n.types, func(t string) bool { return is(v, t) }) {
+		return mismatch("must be of type %s", joinTypes(n.types))
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return mismatch("must be one of %s", encode(n.enum))
+	}
+	if n.constant != nil && !reflect.DeepEqual(*n.constant, v) {
+		return mismatch("must be %s", encode(*n.constant))
+	}
+	switch v := v.(type) {
+	case float64:
+		switch {
+		case n.minimum != nil && v < *n.minimum:
+			return mismatch("must be at least %v", *n.minimum)
+		case n.maximum != nil && v > *n.maximum:
+			return mismatch("must be at most %v", *n.maximum)
+		case n.exclMinimum != nil && v <= *n.exclMinimum:
+			return mismatch("must be above %v", *n.exclMinimum)
+		case n.exclMaximum != nil && v >= *n.exclMaximum:
+			return mismatch("must be below %v", *n.exclMaximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		switch {
+		case n.minLength != nil && length < *n.minLength:
+			return mismatch("must be at least %d characters long", *n.minLength)
+		case n.maxLength != nil && length > *n.maxLength:
+			return mismatch("must be at most %d characters long", *n.maxLength)
+		case n.pattern != nil && !n.pattern.MatchString(v):
+			return mismatch("must match %q", n.pattern)
+		}
+	case []any:
+		switch {
+		case n.minItems != nil && len(v) < *n.minItems:
+			return mismatch("must hold at least %d items", *n.minItems)
+		case n.maxItems != nil && len(v) > *n.maxItems:
+			return mismatch("must hold at most %d items", *n.maxItems)
+		}
+		for i, item := range v {
+			if n.unique && slices.ContainsFunc(v[:i], func(prev any) bool { return reflect.DeepEqual(prev, item) }) {
+				return mismatch("must hold unique items")
+			}
+			if n.items != nil {
+				if err := n.items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		switch {
+		case n.minProps != nil && len(v) < *n.minProps:
+			return mismatch("must hold at least %d properties", *n.minProps)
+		case n.maxProps != nil && len(v) > *n.maxProps:
+			return mismatch("must hold at most %d properties", *n.maxProps)
+		}
+		for _, name := range n.required {
+			if _, ok := v[name]; !ok {
+				return mismatch("must have property %q", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			sub, ok := n.properties[name]
+			if !ok {
+				sub = n.additional
+			}
+			if sub != nil {
+				if err := sub.validate(v[name], path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// is reports whether v, a decoded JSON value, is of type t.
+func is(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return encode(types)
+}
+
+func encode(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,52 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/keys"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/keyseal"
+)
+
+// sealPrivateKey encrypts an Ed25519 private key under a passphrase.
+// Arguments: privateKeyB64, passphrase
+// Returns: { sealed: string } or { error: { code, message, retryable } }
+func sealPrivateKey(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: privateKeyB64, passphrase")
+	}
+
+	privateKey, err := keys.DecodePrivateKey(args[0].String())
+	if err != nil {
+		return errorResult(errKeyDecode, "failed to decode private key: "+err.Error())
+	}
+	sealed, err := keyseal.Seal(privateKey, []byte(args[1].String()))
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+
+	return map[string]interface{}{
+		"sealed": sealed,
+	}
+}
+
+// unsealPrivateKey decrypts a key sealed by sealPrivateKey or
+// `coralctl seal`.
+// Arguments: sealed, passphrase
+// Returns: { privateKey: string } or { error: { code, message, retryable } }
+func unsealPrivateKey(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: sealed, passphrase")
+	}
+
+	privateKey, err := keyseal.Unseal(args[0].String(), []byte(args[1].String()))
+	if err != nil {
+		return errorResult(errKeyUnseal, err.Error())
+	}
+
+	return map[string]interface{}{
+		"privateKey": keys.EncodePrivateKey(privateKey),
+	}
+}
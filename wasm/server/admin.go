@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+)
+
+// adminRevocationsResponse is the body answering GET
+// /v1/admin/revocations.
+type adminRevocationsResponse struct {
+	Revocations []revocation.Revocation `json:"revocations"`
+}
+
+// rotateResponse is the body answering POST /v1/admin/keys/rotate.
+type rotateResponse struct {
+	KeyID string `json:"kid"`
+}
+
+// admin authenticates an admin API request: the caller must carry the
+// rbac.IntentAdmin intent and a role granting perm. Refusals are audited
+// as events of typ.
+func (s *Server) admin(w http.ResponseWriter, r *http.Request, perm rbac.Permission, typ string) (*auth.Principal, bool) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return nil, false
+	}
+	if p.Intent != rbac.IntentAdmin {
+		s.record(r, typ, p.ReferralClaims, r.PathValue("id"), "ticket intent must be "+rbac.IntentAdmin)
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+rbac.IntentAdmin)
+		return nil, false
+	}
+	if err := s.rbac.AuthorizeRoles(p.Roles, perm); err != nil {
+		s.record(r, typ, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+// handleAdminAgents lists the agents of the ticket's reef, or of the
+// colony query parameter, including leases expired within the grace
+// period.
+func (s *Server) handleAdminAgents(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermListAgents, audit.AdminListAgents)
+	if !ok {
+		return
+	}
+	colony := r.URL.Query().Get("colony")
+	recs, err := s.registry.Records(r.Context(), p.ReefID, colony)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminListAgents, p.ReferralClaims, colony, "")
+	writeJSON(w, http.StatusOK, &registry.Page{Records: recs})
+}
+
+// handleAdminEvict removes an agent of the ticket's reef.
+func (s *Server) handleAdminEvict(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermEvictAgents, audit.AdminEvict)
+	if !ok {
+		return
+	}
+	if _, err := s.registry.Evict(r.Context(), p.ReefID, r.PathValue("id")); err != nil {
+		s.record(r, audit.AdminEvict, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminEvict, p.ReferralClaims, r.PathValue("id"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminExpire ends the lease of an agent of the ticket's reef.
+func (s *Server) handleAdminExpire(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermExpireLeases, audit.AdminExpireLease)
+	if !ok {
+		return
+	}
+	rec, err := s.registry.ExpireLease(r.Context(), p.ReefID, r.PathValue("id"))
+	if err != nil {
+		s.record(r, audit.AdminExpireLease, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminExpireLease, p.ReferralClaims, r.PathValue("id"), "")
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// handleAdminRotateKeys replaces the signing key at once and answers
+// with the new key's ID. The key signs for every reef, so only admins of
+// the deployment's own reef may rotate it.
+func (s *Server) handleAdminRotateKeys(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermRotateKeys, audit.AdminRotateKeys)
+	if !ok {
+		return
+	}
+	if p.ReefID != s.keysReef {
+		reason := "only admins of reef " + s.keysReef + " may rotate the signing keys"
+		s.record(r, audit.AdminRotateKeys, p.ReferralClaims, "", reason)
+		writeError(w, http.StatusForbidden, "permission_denied", reason)
+		return
+	}
+	if err := s.keys.Rotate(); err != nil {
+		s.record(r, audit.AdminRotateKeys, p.ReferralClaims, "", err.Error())
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	current, err := s.keys.Current()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	s.record(r, audit.AdminRotateKeys, p.ReferralClaims, current.ID, "")
+	writeJSON(w, http.StatusOK, rotateResponse{KeyID: current.ID})
+}
+
+// handleAdminRevocations lists the revoked ticket IDs of the ticket's
+// reef and when each revocation lapses.
+func (s *Server) handleAdminRevocations(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadRevocations, audit.AdminRevocations)
+	if !ok {
+		return
+	}
+	revs, err := s.revocations.Entries(r.Context(), p.ReefID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	s.record(r, audit.AdminRevocations, p.ReferralClaims, "", "")
+	writeJSON(w, http.StatusOK, adminRevocationsResponse{Revocations: revs})
+}
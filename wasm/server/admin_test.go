@@ -0,0 +1,178 @@
+package server_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+)
+
+// TestAdminRoles checks that the agent routes of the admin API take an
+// admin ticket with a role granting them, and act on its reef only.
+func TestAdminRoles(t *testing.T) {
+	reg, err := registry.New(registry.Config{Verifier: tickets{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: tickets{}, Registry: reg})
+	for _, reef := range []string{"reef", "other"} {
+		rec := registry.Record{ReefID: reef, ColonyID: "colony", AgentID: "agent", Endpoints: []string{"10.0.0.1:9000"}}
+		if code := call(t, s, "POST", "/v1/register", ticket(t, reef, "agent", "register"), &rec, nil); code != http.StatusOK {
+			t.Fatalf("register in %s: status %d", reef, code)
+		}
+	}
+
+	for name, token := range map[string]string{
+		"no role":                 admin(t, "reef"),
+		"unknown role":            admin(t, "reef", "root"),
+		"auditor":                 admin(t, "reef", rbac.RoleAuditor),
+		"operator without intent": ticket(t, "reef", "operator", "register", rbac.RoleOperator),
+	} {
+		if code := call(t, s, "DELETE", "/v1/admin/agents/agent", token, nil, nil); code != http.StatusForbidden {
+			t.Errorf("eviction by %s: status %d, want 403", name, code)
+		}
+	}
+	var page registry.Page
+	if code := call(t, s, "GET", "/v1/admin/agents", admin(t, "reef", rbac.RoleAuditor), nil, &page); code != http.StatusOK {
+		t.Fatalf("listing by auditor: status %d", code)
+	}
+	if len(page.Records) != 1 || page.Records[0].ReefID != "reef" {
+		t.Fatalf("auditor of reef lists %d agents, want its reef's one", len(page.Records))
+	}
+
+	operator := admin(t, "reef", rbac.RoleOperator)
+	if code := call(t, s, "POST", "/v1/admin/agents/agent/expire", operator, nil, nil); code != http.StatusOK {
+		t.Fatalf("lease expiry by operator: status %d", code)
+	}
+	if code := call(t, s, "DELETE", "/v1/admin/agents/agent", operator, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("eviction by operator: status %d", code)
+	}
+	call(t, s, "GET", "/v1/admin/agents", admin(t, "other", rbac.RoleAuditor), nil, &page)
+	if len(page.Records) != 1 {
+		t.Fatal("eviction reached the agent of another reef")
+	}
+}
+
+// rootKey verifies root tickets signed with its private key.
+type rootKey ed25519.PublicKey
+
+func (k rootKey) ValidateReferralTicket(token string) (*jwt.ReferralClaims, error) {
+	var claims jwt.ReferralClaims
+	if _, err := gojwt.ParseWithClaims(token, &claims, func(*gojwt.Token) (any, error) { return ed25519.PublicKey(k), nil }); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// TestDelegatedRoles checks that a delegated admin ticket is granted the
+// roles of its root at most.
+func TestDelegatedRoles(t *testing.T) {
+	rootPub, rootPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderPub, holderPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(agentID string, roles ...string) *delegation.Claims {
+		return &delegation.Claims{
+			ReferralClaims: jwt.ReferralClaims{
+				ReefID: "reef", ColonyID: "colony", AgentID: agentID, Intent: rbac.IntentAdmin,
+				RegisteredClaims: gojwt.RegisteredClaims{ID: agentID, ExpiresAt: gojwt.NewNumericDate(time.Now().Add(time.Hour))},
+			},
+			Roles: roles,
+		}
+	}
+	c := claims("operator", rbac.RoleOperator)
+	c.DelegationKey = base64.StdEncoding.EncodeToString(holderPub)
+	root, err := delegation.Sign(rootPriv, "root", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := delegation.New(delegation.Config{Root: rootKey(rootPub)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: v, Keys: set, KeysReef: "reef"})
+
+	// The holder signs a child claiming admin itself, as Delegate refuses
+	// to.
+	c = claims("delegate", rbac.RoleAdmin)
+	c.Parent = root
+	escalated, err := delegation.Sign(holderPriv, delegation.DelegatedKeyID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(t, s, "POST", "/v1/admin/keys/rotate", escalated, nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("rotation by a delegate claiming admin: status %d, want 401", code)
+	}
+
+	delegate, err := delegation.Delegate(root, holderPriv, delegation.Child{AgentID: "delegate", Intent: rbac.IntentAdmin, TTL: time.Minute, Roles: []string{rbac.RoleOperator}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(t, s, "POST", "/v1/admin/keys/rotate", delegate, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("rotation by an operator delegate: status %d, want 403", code)
+	}
+	if code := call(t, s, "POST", "/v1/admin/keys/rotate", root, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("rotation by an operator: status %d, want 403", code)
+	}
+}
+
+// TestRotateKeys checks that only admins of the deployment's own reef
+// rotate the keys signing the tickets of every reef.
+func TestRotateKeys(t *testing.T) {
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := set.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: tickets{}, Keys: set, KeysReef: "ops"})
+
+	for name, token := range map[string]string{
+		"admin of a tenant reef": admin(t, "tenant", rbac.RoleAdmin),
+		"operator of ops":        admin(t, "ops", rbac.RoleOperator),
+		"agent ticket of ops":    ticket(t, "ops", "agent", "register", rbac.RoleAdmin),
+	} {
+		if code := call(t, s, "POST", "/v1/admin/keys/rotate", token, nil, nil); code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, code)
+		}
+	}
+	if current, _ := set.Current(); current.ID != before.ID {
+		t.Fatal("a refused rotation replaced the key")
+	}
+
+	var out struct {
+		KeyID string `json:"kid"`
+	}
+	if code := call(t, s, "POST", "/v1/admin/keys/rotate", admin(t, "ops", rbac.RoleAdmin), nil, &out); code != http.StatusOK {
+		t.Fatalf("admin of ops: status %d", code)
+	}
+	if current, _ := set.Current(); out.KeyID == before.ID || out.KeyID != current.ID {
+		t.Fatalf("rotation answered kid %q; key was %q and is %q", out.KeyID, before.ID, current.ID)
+	}
+
+	// Without the deployment's reef no one may rotate.
+	s = server.New(server.Config{Verifier: tickets{}, Keys: set})
+	if code := call(t, s, "POST", "/v1/admin/keys/rotate", admin(t, "ops", rbac.RoleAdmin), nil, nil); code != http.StatusNotFound {
+		t.Fatalf("rotation without KeysReef: status %d, want 404", code)
+	}
+}
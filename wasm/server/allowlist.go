@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+)
+
+// publishAllowlistRequest is the body of PUT
+// /v1/colonies/{id}/allowlist.
+type publishAllowlistRequest struct {
+	Token string `json:"token"`
+}
+
+// handleAllowlist serves the endpoint allowlist of a colony of the
+// ticket's reef, with the token so agents can verify it themselves.
+func (s *Server) handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	published, err := s.registry.Allowlist(r.Context(), p.ReefID, r.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, published)
+}
+
+// handlePublishAllowlist makes a signed allowlist the endpoint allowlist
+// of a colony of the ticket's reef. The list's signature, not the ticket,
+// authorizes it: any ticket of the reef may deliver it.
+func (s *Server) handlePublishAllowlist(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req publishAllowlistRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	colony := r.PathValue("id")
+	published, err := s.registry.PublishAllowlist(r.Context(), p.ReefID, colony, req.Token)
+	if err != nil {
+		s.record(r, audit.AllowlistPublish, p.ReferralClaims, colony, err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AllowlistPublish, p.ReferralClaims, colony, "")
+	writeJSON(w, http.StatusOK, published)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+)
+
+// record appends an event of typ by the holder of claims on subject to
+// the audit log, stamped with the client address of r. It is a failure
+// when reason is not empty.
+func (s *Server) record(r *http.Request, typ string, claims *jwt.ReferralClaims, subject, reason string) {
+	if s.audit == nil {
+		return
+	}
+	ev := audit.FromClaims(typ, audit.Success, claims)
+	ev.Subject = subject
+	if reason != "" {
+		ev.Outcome, ev.Reason = audit.Failure, reason
+	}
+	if a := s.remoteAddr(r); a.IsValid() {
+		ev.Source = a.String()
+	}
+	_ = s.audit.Record(r.Context(), ev)
+}
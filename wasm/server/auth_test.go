@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+)
+
+// TestAuthenticationChain checks that a request's ticket is tried before
+// its API key, and decides when present even if it is rejected.
+func TestAuthenticationChain(t *testing.T) {
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.ParseAPIKeys(fmt.Appendf(nil, `{"keys": [{"id": "ci", "hash": %q, "reef_id": "ops",
+	  "colony_id": "ci", "agent_id": "ci", "intent": "admin", "roles": ["admin"]}]}`, hash), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: tickets{}, APIKeys: keys, Keys: set, KeysReef: "ops"})
+	rotate := func(s http.Handler, ticket, apiKey string) int {
+		req := httptest.NewRequest("POST", "/v1/admin/keys/rotate", nil)
+		if ticket != "" {
+			req.Header.Set("Authorization", "Bearer "+ticket)
+		}
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := rotate(s, "", key); code != http.StatusOK {
+		t.Fatalf("API key: status %d", code)
+	}
+	if code := rotate(s, "", key+"x"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown API key: status %d, want 401", code)
+	}
+	if code := rotate(s, "not a ticket", key); code != http.StatusUnauthorized {
+		t.Fatalf("rejected ticket with a valid API key: status %d, want 401", code)
+	}
+	if code := rotate(s, admin(t, "ops", rbac.RoleAuditor), key); code != http.StatusForbidden {
+		t.Fatalf("auditor ticket with an admin API key: status %d, want 403", code)
+	}
+	if code := rotate(s, "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("no credentials: status %d, want 401", code)
+	}
+
+	// The intent policy applies to principals of every method.
+	p, err := policy.Parse([]byte(`{"rules": [{"effect": "allow", "intents": ["*"]}, {"effect": "deny", "colony": "ci", "intents": ["*"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = server.New(server.Config{Verifier: tickets{}, APIKeys: keys, Policy: p, Keys: set, KeysReef: "ops"})
+	if code := rotate(s, "", key); code != http.StatusUnauthorized {
+		t.Fatalf("API key of a denied colony: status %d, want 401", code)
+	}
+	if code := rotate(s, admin(t, "ops", rbac.RoleAdmin), ""); code != http.StatusOK {
+		t.Fatalf("ticket of an allowed colony: status %d", code)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// handleChanges serves the changes to the records of the ticket's reef
+// since the since parameter, a registry.Timestamp as formatted by its
+// String method or its wall time alone: the records written and the
+// tombstones of the agents removed since (see registry.Delta), filtered
+// like watches. Without
+// since, every record is returned. A since older than the tombstones
+// kept is answered with 410 resync_required.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	filter, ok := watchFilter(w, r, p)
+	if !ok {
+		return
+	}
+	var since registry.Timestamp
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = registry.ParseTimestamp(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+	}
+	d, err := s.registry.Delta(r.Context(), filter, since)
+	if errors.Is(err, registry.ErrCursorExpired) {
+		writeError(w, http.StatusGone, "resync_required", err.Error())
+		return
+	}
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
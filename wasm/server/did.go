@@ -0,0 +1,18 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleAgentDID serves an agent's did:web document. Like the JWKS it is
+// public, so partners can resolve agent DIDs without a referral ticket.
+func (s *Server) handleAgentDID(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.registry.Lookup(r.Context(), s.did.Reef(), r.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/did+json")
+	_ = json.NewEncoder(w).Encode(s.did.Document(rec))
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+)
+
+// maxCampaignWait caps the long poll of a campaign below common client
+// and proxy timeouts.
+const maxCampaignWait = 25 * time.Second
+
+// campaignRequest is the body of POST /v1/elections/{name}/campaign.
+type campaignRequest struct {
+	Session    string `json:"session,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// termRequest is the body of the renew and resign routes of an election.
+type termRequest struct {
+	Session string `json:"session,omitempty"`
+	Fence   uint64 `json:"fence"`
+}
+
+// candidate returns the candidate of the ticket's agent for the election
+// of the request.
+func candidate(r *http.Request, p *auth.Principal, session string) election.Candidate {
+	return election.Candidate{
+		ReefID:   p.ReefID,
+		ColonyID: p.ColonyID,
+		AgentID:  p.AgentID,
+		Name:     r.PathValue("name"),
+		Session:  session,
+	}
+}
+
+// handleLeader serves the current term of an election of the ticket's
+// colony.
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	l, err := s.elections.Leader(r.Context(), p.ReefID, p.ColonyID, r.PathValue("name"))
+	if err != nil {
+		writeElectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+// handleCampaign makes the ticket's agent the leader of an election of
+// its colony when nobody leads it, and answers with the election's
+// state either way. The optional wait parameter (a Go duration, at most
+// maxCampaignWait) long polls while another agent leads.
+func (s *Server) handleCampaign(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxCampaignWait)
+	if !ok {
+		return
+	}
+	var req campaignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	l, err := s.elections.Campaign(r.Context(), candidate(r, p, req.Session), ttl, wait)
+	if err != nil {
+		writeElectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+// handleRenewTerm extends the term of the ticket's agent.
+func (s *Server) handleRenewTerm(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req termRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	l, err := s.elections.Renew(r.Context(), candidate(r, p, req.Session), req.Fence)
+	if err != nil {
+		writeElectionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+// handleResign ends the term of the ticket's agent.
+func (s *Server) handleResign(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req termRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if err := s.elections.Resign(r.Context(), candidate(r, p, req.Session), req.Fence); err != nil {
+		writeElectionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeElectionError maps election errors onto HTTP status codes.
+func writeElectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, election.ErrNoLeader):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, election.ErrNotLeader):
+		writeError(w, http.StatusConflict, "not_leader", err.Error())
+	case errors.Is(err, election.ErrInvalid):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/enrollment"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+)
+
+// adminEnrollmentsResponse is the body answering GET
+// /v1/admin/enrollments.
+type adminEnrollmentsResponse struct {
+	Enrollments []*enrollment.Enrollment `json:"enrollments"`
+}
+
+// denyRequest is the optional body of POST
+// /v1/admin/enrollments/{id}/deny.
+type denyRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleEnroll queues the CSR in the body for the agent named by the
+// ticket, which must carry the enrollment.IntentEnroll intent.
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.Intent != enrollment.IntentEnroll {
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+enrollment.IntentEnroll)
+		return
+	}
+	var csr enrollment.CSR
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&csr); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	en, err := s.enrollment.Submit(r.Context(), p.ReferralClaims, &csr)
+	if err != nil {
+		s.record(r, audit.EnrollRequest, p.ReferralClaims, p.AgentID, err.Error())
+		writeEnrollmentError(w, err)
+		return
+	}
+	s.record(r, audit.EnrollRequest, p.ReferralClaims, en.ID, "")
+	writeJSON(w, http.StatusAccepted, en)
+}
+
+// handleEnrollment returns an enrollment. The ID is the credential: the
+// identity it may carry is bound to the agent's key.
+func (s *Server) handleEnrollment(w http.ResponseWriter, r *http.Request) {
+	en, err := s.enrollment.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeEnrollmentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, en)
+}
+
+// handleReissueIdentity answers the holder of an identity ticket with a
+// fresh one. The ticket is bound, so the request also carries a proof of
+// possession of the enrolled key.
+func (s *Server) handleReissueIdentity(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	en, err := s.enrollment.Reissue(r.Context(), r.PathValue("id"), p.Ticket)
+	if err != nil {
+		writeEnrollmentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, en)
+}
+
+// handleAdminEnrollments lists the enrollments of the ticket's reef,
+// optionally only those with the status query parameter.
+func (s *Server) handleAdminEnrollments(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermListEnrollments, audit.AdminEnrollments)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	ens, err := s.enrollment.List(r.Context(), p.ReefID, status)
+	if err != nil {
+		writeEnrollmentError(w, err)
+		return
+	}
+	s.record(r, audit.AdminEnrollments, p.ReferralClaims, status, "")
+	writeJSON(w, http.StatusOK, adminEnrollmentsResponse{Enrollments: ens})
+}
+
+// handleAdminApprove approves a pending enrollment of the ticket's reef.
+func (s *Server) handleAdminApprove(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermDecideEnrollments, audit.AdminApprove)
+	if !ok {
+		return
+	}
+	en, err := s.enrollment.Approve(r.Context(), p.ReefID, r.PathValue("id"), p.AgentID)
+	if err != nil {
+		s.record(r, audit.AdminApprove, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeEnrollmentError(w, err)
+		return
+	}
+	s.record(r, audit.AdminApprove, p.ReferralClaims, en.ID, "")
+	writeJSON(w, http.StatusOK, en)
+}
+
+// handleAdminDeny denies a pending enrollment of the ticket's reef.
+func (s *Server) handleAdminDeny(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermDecideEnrollments, audit.AdminDeny)
+	if !ok {
+		return
+	}
+	var req denyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	en, err := s.enrollment.Deny(r.Context(), p.ReefID, r.PathValue("id"), p.AgentID, req.Reason)
+	if err != nil {
+		s.record(r, audit.AdminDeny, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeEnrollmentError(w, err)
+		return
+	}
+	s.record(r, audit.AdminDeny, p.ReferralClaims, en.ID, "")
+	writeJSON(w, http.StatusOK, en)
+}
+
+// writeEnrollmentError maps enrollment errors onto HTTP status codes.
+func writeEnrollmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, enrollment.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, enrollment.ErrDecided):
+		writeError(w, http.StatusPreconditionFailed, "failed_precondition", err.Error())
+	case errors.Is(err, enrollment.ErrNotEnrolled):
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+	case errors.Is(err, enrollment.ErrInvalidCSR):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
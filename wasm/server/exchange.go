@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+)
+
+// handleTokenExchange swaps an OIDC ID token for a referral ticket. The
+// request is an RFC 8693 form post and errors use the OAuth error
+// response shape rather than writeError's, for OAuth client libraries.
+func (s *Server) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, &oidc.Error{Code: oidc.ErrInvalidRequest, Description: err.Error()})
+		return
+	}
+
+	token, err := s.exchange.Exchange(r.Context(), oidc.Request{
+		GrantType:          r.PostForm.Get("grant_type"),
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		Resource:           r.PostForm.Get("resource"),
+		Scope:              r.PostForm.Get("scope"),
+	})
+	resource := r.PostForm.Get("resource")
+	var oerr *oidc.Error
+	if errors.As(err, &oerr) {
+		s.record(r, audit.TicketIssued, nil, resource, oerr.Error())
+		writeJSON(w, http.StatusBadRequest, oerr)
+		return
+	}
+	if err != nil {
+		s.record(r, audit.TicketIssued, nil, resource, err.Error())
+		writeJSON(w, http.StatusInternalServerError, &oidc.Error{Code: "server_error", Description: err.Error()})
+		return
+	}
+	// The ticket was just signed here, so its claims need no verifying.
+	var claims jwt.ReferralClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(token.AccessToken, &claims); err == nil {
+		s.record(r, audit.TicketIssued, &claims, claims.ID, "")
+	}
+	writeJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+)
+
+// TestTokenExchange posts RFC 8693 exchanges of ID tokens for tickets and
+// checks that tokens of another issuer or audience get OAuth errors.
+func TestTokenExchange(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kid": "idp", "kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(pub)},
+		}})
+	}))
+	defer idp.Close()
+	idToken := func(iss, aud string) string {
+		tok := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, gojwt.MapClaims{
+			"iss": iss, "aud": aud, "sub": "alice", "groups": "sre",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		tok.Header["kid"] = "idp"
+		signed, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	set, err := jwks.New(jwks.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := oidc.New(oidc.Config{
+		Issuer: "https://idp.example.com", ClientID: "coral", JWKSURL: idp.URL,
+		Grants: []oidc.Grant{{Group: "sre", Reef: "prod", Intents: []string{"read-*"}}},
+		Signer: set.Signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: set, Exchange: e})
+	exchange := func(subjectToken string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":         {oidc.GrantTypeTokenExchange},
+			"subject_token":      {subjectToken},
+			"subject_token_type": {oidc.TokenTypeIDToken},
+			"resource":           {"urn:coral:reef:prod:colony:api"},
+			"scope":              {"read-catalog"},
+		}
+		req := httptest.NewRequest("POST", "/v1/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for name, token := range map[string]string{
+		"other issuer":   idToken("https://evil.example.com", "coral"),
+		"other audience": idToken("https://idp.example.com", "other-client"),
+	} {
+		rec := exchange(token)
+		var oerr oidc.Error
+		if err := json.Unmarshal(rec.Body.Bytes(), &oerr); rec.Code != http.StatusBadRequest || err != nil || oerr.Code != oidc.ErrInvalidGrant {
+			t.Errorf("%s: status %d, error %q, want 400 %s", name, rec.Code, oerr.Code, oidc.ErrInvalidGrant)
+		}
+	}
+
+	rec := exchange(idToken("https://idp.example.com", "coral"))
+	var token oidc.Token
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("exchange: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("issued ticket may be cached")
+	}
+	claims, err := set.ValidateReferralTicket(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.AgentID != "alice" || claims.ReefID != "prod" || claims.Intent != "read-catalog" {
+		t.Fatalf("ticket for %s/%s with intent %q", claims.ReefID, claims.AgentID, claims.Intent)
+	}
+}
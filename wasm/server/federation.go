@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// federationPrefix prefixes the routes peers call with federation
+// tokens rather than referral tickets, which skip the ticket checks of
+// ServeHTTP.
+const federationPrefix = "/v1/federation/"
+
+// isFederationRoute reports whether r is for a route peers call.
+func isFederationRoute(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, federationPrefix)
+}
+
+// handleFederatedLookup returns a record of the local reef to a
+// federated reef's server.
+func (s *Server) handleFederatedLookup(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.federation.Authenticate(bearerToken(r)); err != nil {
+		writeFederationError(w, err)
+		return
+	}
+	rec, err := s.registry.Lookup(r.Context(), s.federation.Reef(), r.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// handleFederationKeys serves the server's key set signed with its
+// current key, for peers following its rotation.
+func (s *Server) handleFederationKeys(w http.ResponseWriter, r *http.Request) {
+	token, err := s.federation.KeysToken(r.Context())
+	if err != nil {
+		writeFederationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	_, _ = w.Write([]byte(token))
+}
+
+// writeFederationError maps federation errors onto HTTP status codes.
+func writeFederationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, federation.ErrUnknownReef), errors.Is(err, federation.ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+	case errors.Is(err, federation.ErrNoSigner):
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+	case errors.Is(err, registry.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	default:
+		writeError(w, http.StatusBadGateway, "unavailable", err.Error())
+	}
+}
@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+)
+
+// TestFederatedLookup looks up an agent of reef b through the server of
+// reef a, and checks that b's server answers a's anchored key only.
+func TestFederatedLookup(t *testing.T) {
+	sets := make([]*jwks.Set, 4)
+	for i := range sets {
+		set, err := jwks.New(jwks.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sets[i] = set
+	}
+	rootA, rootB, keysA, keysB := sets[0], sets[1], sets[2], sets[3]
+	anchor := func(root *jwks.Set, local, reef, url string, keys *jwks.Set) string {
+		doc, err := json.Marshal(keys.Document())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := root.Signer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := federation.SignAnchor(context.Background(), s, local, federation.Peer{Reef: reef, URL: url, JWKS: doc}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	reg, err := registry.New(registry.Config{Verifier: tickets{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fedB, err := federation.New(federation.Config{Reef: "b", Roots: rootB, Anchors: []string{anchor(rootB, "b", "a", "https://a.example.com", keysA)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := httptest.NewServer(server.New(server.Config{Verifier: tickets{}, Registry: reg, Federation: fedB}))
+	defer b.Close()
+	rec := registry.Record{ReefID: "b", ColonyID: "colony", AgentID: "agent", Endpoints: []string{"10.0.0.1:9000"}}
+	if code := call(t, b.Config.Handler, "POST", "/v1/register", ticket(t, "b", "agent", "register"), &rec, nil); code != http.StatusOK {
+		t.Fatalf("register: status %d", code)
+	}
+
+	lookup := func(keys *jwks.Set) *server.Server {
+		fed, err := federation.New(federation.Config{Reef: "a", Roots: rootA, Anchors: []string{anchor(rootA, "a", "b", b.URL, keysB)}, Signer: keys.Signer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server.New(server.Config{Verifier: tickets{}, Federation: fed})
+	}
+	a := lookup(keysA)
+	var got registry.Record
+	if code := call(t, a, "GET", "/v1/agents/agent?reef=b", ticket(t, "a", "caller", "lookup"), nil, &got); code != http.StatusOK {
+		t.Fatalf("federated lookup: status %d", code)
+	}
+	if got.ReefID != "b" || got.AgentID != "agent" {
+		t.Fatalf("lookup answered %s/%s", got.ReefID, got.AgentID)
+	}
+	if code := call(t, a, "GET", "/v1/agents/missing?reef=b", ticket(t, "a", "caller", "lookup"), nil, nil); code != http.StatusNotFound {
+		t.Fatalf("lookup of an unknown agent: status %d, want 404", code)
+	}
+	if code := call(t, a, "GET", "/v1/agents/agent?reef=c", ticket(t, "a", "caller", "lookup"), nil, nil); code != http.StatusNotFound {
+		t.Fatalf("lookup in a reef without anchor: status %d, want 404", code)
+	}
+	if code := call(t, a, "GET", "/v1/agents/agent?reef=b", ticket(t, "tenant", "caller", "lookup"), nil, nil); code != http.StatusForbidden {
+		t.Fatalf("lookup by a tenant reef: status %d, want 403", code)
+	}
+
+	// Reef b does not answer a server signing with keys a's anchor
+	// does not name, nor requests without a federation token.
+	if code := call(t, lookup(rootA), "GET", "/v1/agents/agent?reef=b", ticket(t, "a", "caller", "lookup"), nil, nil); code != http.StatusBadGateway {
+		t.Fatalf("lookup signed outside the anchor: status %d, want 502", code)
+	}
+	if code := call(t, b.Config.Handler, "GET", federation.AgentsPath+"agent", ticket(t, "a", "caller", "lookup"), nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("federated lookup with a referral ticket: status %d, want 401", code)
+	}
+}
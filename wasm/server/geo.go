@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// locationHint returns the coarse location Cloudflare attached to r: the
+// CF-IPCountry header, the visitor location headers of the matching
+// managed transform and the colo suffix of CF-Ray. Like X-Forwarded-For
+// the headers are only trusted behind a proxy; it returns nil otherwise
+// or when there are none.
+func (s *Server) locationHint(r *http.Request) *registry.Location {
+	if !s.trustProxy {
+		return nil
+	}
+	var l registry.Location
+	// XX marks unknown countries and T1 Tor exits.
+	if c := r.Header.Get("CF-IPCountry"); c != "XX" && c != "T1" {
+		l.Country = c
+	}
+	l.Continent = r.Header.Get("CF-IPContinent")
+	if ray := r.Header.Get("CF-Ray"); ray != "" {
+		if i := strings.LastIndexByte(ray, '-'); i >= 0 {
+			l.Colo = ray[i+1:]
+		}
+	}
+	lat, errLat := strconv.ParseFloat(r.Header.Get("CF-IPLatitude"), 64)
+	lon, errLon := strconv.ParseFloat(r.Header.Get("CF-IPLongitude"), 64)
+	if errLat == nil && errLon == nil {
+		l.Latitude, l.Longitude = lat, lon
+	}
+	if l == (registry.Location{}) {
+		return nil
+	}
+	return &l
+}
+
+// origin returns the record peers are ranked against for the ticket's
+// agent: its own registration when it has one, with blanks in its
+// location filled from the request's hints.
+func (s *Server) origin(ctx context.Context, r *http.Request, claims *jwt.ReferralClaims) *registry.Record {
+	origin := &registry.Record{AgentID: claims.AgentID}
+	if rec, err := s.registry.Lookup(ctx, claims.ReefID, claims.AgentID); err == nil {
+		origin = rec
+	}
+	origin.Location = origin.Location.Merge(s.locationHint(r))
+	return origin
+}
@@ -0,0 +1,486 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	registryv1 "github.com/coral-mesh/coral-discovery-workers/wasm/gen/coral/registry/v1"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/readcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+)
+
+// GRPCServer implements registryv1.RegistryServiceServer on top of the
+// same registry backend as the HTTP Server. Attach it to a grpc.Server
+// with registryv1.RegisterRegistryServiceServer.
+type GRPCServer struct {
+	registryv1.UnimplementedRegistryServiceServer
+
+	registry   *registry.Registry
+	verifier   registry.Verifier
+	pop        *pop.Checker
+	spiffe     *spiffe.Adapter
+	federation *federation.Federation
+	limiter    *ratelimit.Limiter
+	reads      *readcache.Cache
+}
+
+// NewGRPC creates a GRPCServer from cfg.
+func NewGRPC(cfg Config) *GRPCServer {
+	return &GRPCServer{
+		registry:   cfg.Registry,
+		verifier:   cfg.Verifier,
+		pop:        cfg.PoP,
+		spiffe:     cfg.SPIFFE,
+		federation: cfg.Federation,
+		limiter:    cfg.Limiter,
+		reads:      cfg.ReadCache,
+	}
+}
+
+// Register implements registryv1.RegistryServiceServer.
+func (s *GRPCServer) Register(ctx context.Context, req *registryv1.RegisterRequest) (*registryv1.RegisterResponse, error) {
+	if err := s.checkProof(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkSVID(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetRecord() == nil {
+		return nil, status.Error(codes.InvalidArgument, "record is required")
+	}
+
+	rec := recordFromProto(req.GetRecord())
+	rec.ObservedEndpoints = registry.ObservedEndpoints(rec.Endpoints, peerAddr(ctx))
+	stored, err := s.registry.Register(ctx, metadataToken(ctx), rec)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &registryv1.RegisterResponse{Record: recordToProto(stored)}, nil
+}
+
+// Deregister implements registryv1.RegistryServiceServer.
+func (s *GRPCServer) Deregister(ctx context.Context, req *registryv1.DeregisterRequest) (*registryv1.DeregisterResponse, error) {
+	if err := s.checkProof(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkSVID(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.registry.Deregister(ctx, metadataToken(ctx), req.GetAgentId()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &registryv1.DeregisterResponse{}, nil
+}
+
+// Renew implements registryv1.RegistryServiceServer.
+func (s *GRPCServer) Renew(ctx context.Context, req *registryv1.RenewRequest) (*registryv1.RenewResponse, error) {
+	if err := s.checkProof(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkSVID(ctx); err != nil {
+		return nil, err
+	}
+	rec, err := s.registry.HeartbeatSeq(ctx, metadataToken(ctx), req.GetAgentId(), req.GetSeq(), loadFromProto(req.GetLoad()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &registryv1.RenewResponse{Record: recordToProto(rec)}, nil
+}
+
+// Lookup implements registryv1.RegistryServiceServer.
+func (s *GRPCServer) Lookup(ctx context.Context, req *registryv1.LookupRequest) (*registryv1.LookupResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := metadataConsistency(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var recs []*registry.Record
+	var next string
+	switch {
+	case req.GetAgentId() != "" && (req.GetColonyId() != "" || req.GetTopic() != "" || req.GetService() != ""),
+		req.GetTopic() != "" && req.GetService() != "":
+		return nil, status.Error(codes.InvalidArgument, "only one of agent_id, colony_id, topic or service may be set")
+	case req.GetService() != "":
+		if recs, err = s.registry.ByService(ctx, claims.ReefID, registry.ServiceQuery{
+			Service:  req.GetService(),
+			ColonyID: req.GetColonyId(),
+			Version:  req.GetServiceVersion(),
+			Stable:   req.GetStable(),
+			Canary:   int(req.GetCanary()),
+			Key:      claims.AgentID,
+		}); err != nil {
+			return nil, grpcError(err)
+		}
+	case req.GetTopic() != "":
+		role, err := registry.ParseTopicRole(req.GetTopicRole())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if recs, err = s.registry.ByTopic(ctx, claims.ReefID, req.GetColonyId(), req.GetTopic(), role); err != nil {
+			return nil, grpcError(err)
+		}
+	case req.GetReefId() != "" && req.GetReefId() != claims.ReefID && (s.federation == nil || claims.ReefID != s.federation.Reef() || req.GetAgentId() == ""):
+		return nil, status.Errorf(codes.PermissionDenied, "ticket of reef %s cannot query reef %s", claims.ReefID, req.GetReefId())
+	case req.GetAgentId() != "" && req.GetReefId() != "" && req.GetReefId() != claims.ReefID:
+		rec, err := s.federation.Lookup(ctx, req.GetReefId(), req.GetAgentId())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		recs = []*registry.Record{rec}
+	case req.GetAgentId() != "":
+		rec, _, err := lookupAgent(ctx, s.reads, s.registry, level, claims.ReefID, req.GetAgentId())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		recs = []*registry.Record{rec}
+	case req.GetColonyId() != "":
+		sel, err := registry.ParseSelector(req.GetSelector())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		pick, err := s.selection(ctx, req, claims)
+		if err != nil {
+			return nil, err
+		}
+		if pick != nil {
+			if req.GetPageToken() != "" {
+				return nil, status.Error(codes.InvalidArgument, "page_token does not apply to nearest, least_loaded or weighted")
+			}
+			list, _, err := queryColony(ctx, s.reads, s.registry, level, claims.ReefID, req.GetColonyId(), req.GetSelector(), sel)
+			if err != nil {
+				return nil, grpcError(err)
+			}
+			recs = pick(list)
+			break
+		}
+		list, _, err := queryColony(ctx, s.reads, s.registry, level, claims.ReefID, req.GetColonyId(), req.GetSelector(), sel)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		page, err := registry.Paginate(req.GetColonyId(), list, req.GetPageToken(), int(min(req.GetPageSize(), registry.MaxPageSize)))
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		recs, next = page.Records, page.NextCursor
+	default:
+		return nil, status.Error(codes.InvalidArgument, "agent_id, colony_id, topic or service is required")
+	}
+
+	resp := &registryv1.LookupResponse{Records: make([]*registryv1.AgentRecord, 0, len(recs)), NextPageToken: next}
+	for _, rec := range recs {
+		resp.Records = append(resp.Records, recordToProto(rec))
+	}
+	return resp, nil
+}
+
+// selection returns the strategy a colony lookup asks agents to be
+// selected with, or nil for a plain listing, like Server.selection.
+func (s *GRPCServer) selection(ctx context.Context, req *registryv1.LookupRequest, claims *jwt.ReferralClaims) (func([]*registry.Record) []*registry.Record, error) {
+	var strategy string
+	var n uint32
+	for name, v := range map[string]uint32{
+		selectNearest:     req.GetNearest(),
+		selectLeastLoaded: req.GetLeastLoaded(),
+		selectWeighted:    req.GetWeighted(),
+	} {
+		if v == 0 {
+			continue
+		}
+		if v > registry.MaxPageSize {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be at most %d", name, registry.MaxPageSize)
+		}
+		if strategy != "" {
+			return nil, status.Error(codes.InvalidArgument, "only one of nearest, least_loaded or weighted may be set")
+		}
+		strategy, n = name, v
+	}
+	return selector(strategy, int(n), func() *registry.Record {
+		origin := &registry.Record{AgentID: claims.AgentID}
+		if rec, err := s.registry.Lookup(ctx, claims.ReefID, claims.AgentID); err == nil {
+			origin = rec
+		}
+		return origin
+	}), nil
+}
+
+// Watch implements registryv1.RegistryServiceServer.
+func (s *GRPCServer) Watch(req *registryv1.WatchRequest, stream registryv1.RegistryService_WatchServer) error {
+	ctx := stream.Context()
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	if req.GetReefId() != "" && req.GetReefId() != claims.ReefID {
+		return status.Errorf(codes.PermissionDenied, "ticket of reef %s cannot watch reef %s", claims.ReefID, req.GetReefId())
+	}
+
+	sel, err := registry.ParseSelector(req.GetSelector())
+	if err != nil {
+		return grpcError(err)
+	}
+	role, err := registry.ParseTopicRole(req.GetTopicRole())
+	if err != nil {
+		return grpcError(err)
+	}
+	// The stream counts against the watch quota of the caller's colony.
+	caller := auth.NewContext(ctx, &auth.Principal{Method: auth.MethodTicket, ReferralClaims: claims})
+	events, err := s.registry.WatchFrom(caller, registry.Filter{
+		ColonyID:   req.GetColonyId(),
+		ReefID:     claims.ReefID,
+		Capability: req.GetCapability(),
+		Selector:   sel,
+		Topic:      req.GetTopic(),
+		TopicRole:  role,
+	}, req.GetCursor())
+	if errors.Is(err, registry.ErrCursorExpired) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watch subscriber fell behind; resume from the last cursor")
+			}
+			if ev.Type == registry.EventDrain {
+				return status.Error(codes.Unavailable, "server is draining; watch another instance")
+			}
+			if err := stream.Send(eventToProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authenticate validates the ticket carried in the request metadata.
+func (s *GRPCServer) authenticate(ctx context.Context) (*jwt.ReferralClaims, error) {
+	token := metadataToken(ctx)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "referral ticket is required")
+	}
+	if err := s.checkProof(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkSVID(ctx); err != nil {
+		return nil, err
+	}
+	ip := ""
+	if a := peerAddr(ctx); a.IsValid() {
+		ip = a.String()
+	}
+	if err := s.limiter.Allow(ratelimit.Verify, ratelimit.Key{IP: ip}); err != nil {
+		return nil, grpcError(err)
+	}
+	claims, err := s.verifier.ValidateReferralTicket(token)
+	if err != nil {
+		s.limiter.Fail(ip)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	// Every authenticated call is a lookup or a watch; writes are
+	// charged by the registry.
+	if err := s.limiter.Allow(ratelimit.Lookup, ratelimit.Key{AgentID: claims.AgentID, ColonyID: claims.ColonyID, ReefID: claims.ReefID, IP: ip}); err != nil {
+		return nil, grpcError(err)
+	}
+	return claims, nil
+}
+
+// metadataToken extracts the bearer token from the "authorization"
+// metadata key.
+func metadataToken(ctx context.Context) string {
+	const prefix = "bearer "
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if len(v) >= len(prefix) && strings.EqualFold(v[:len(prefix)], prefix) {
+			return strings.TrimSpace(v[len(prefix):])
+		}
+	}
+	return ""
+}
+
+// grpcError maps registry errors onto gRPC status codes.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, registry.ErrNotFound), errors.Is(err, federation.ErrUnknownReef):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, federation.ErrNoSigner), errors.Is(err, registry.ErrDraining):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, registry.ErrLeaseExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, registry.ErrStaleRecord):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ratelimit.ErrLimited), errors.Is(err, registry.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, rbac.ErrForbidden), errors.Is(err, allowlist.ErrNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, registry.ErrUnauthorized):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, registry.ErrInvalidRecord), errors.Is(err, registry.ErrInvalidSelector), errors.Is(err, registry.ErrInvalidCursor), errors.Is(err, registry.ErrInvalidTopic), errors.Is(err, registry.ErrInvalidService), errors.Is(err, schema.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func recordToProto(rec *registry.Record) *registryv1.AgentRecord {
+	return &registryv1.AgentRecord{
+		AgentId:           rec.AgentID,
+		ColonyId:          rec.ColonyID,
+		ReefId:            rec.ReefID,
+		Endpoints:         rec.Endpoints,
+		Capabilities:      rec.Capabilities,
+		TtlSeconds:        int32(rec.TTLSeconds),
+		CreatedAt:         timestamppb.New(rec.CreatedAt),
+		UpdatedAt:         timestamppb.New(rec.UpdatedAt),
+		ExpiresAt:         timestamppb.New(rec.ExpiresAt),
+		ObservedEndpoints: rec.ObservedEndpoints,
+		Location:          locationToProto(rec.Location),
+		RttMs:             rttToProto(rec.RTT),
+		Load:              loadToProto(rec.Load),
+		Publishes:         rec.Publishes,
+		Subscribes:        rec.Subscribes,
+		Envelope:          envelopeToProto(rec.Envelope),
+		Seq:               rec.Seq,
+		Metadata:          rec.Metadata,
+	}
+}
+
+func recordFromProto(pb *registryv1.AgentRecord) *registry.Record {
+	return &registry.Record{
+		AgentID:      pb.GetAgentId(),
+		ColonyID:     pb.GetColonyId(),
+		ReefID:       pb.GetReefId(),
+		Endpoints:    pb.GetEndpoints(),
+		Capabilities: pb.GetCapabilities(),
+		TTLSeconds:   int(pb.GetTtlSeconds()),
+		Location:     locationFromProto(pb.GetLocation()),
+		RTT:          rttFromProto(pb.GetRttMs()),
+		Load:         loadFromProto(pb.GetLoad()),
+		Publishes:    pb.GetPublishes(),
+		Subscribes:   pb.GetSubscribes(),
+		Envelope:     envelopeFromProto(pb.GetEnvelope()),
+		Seq:          pb.GetSeq(),
+		Metadata:     pb.GetMetadata(),
+	}
+}
+
+func envelopeToProto(e *registry.Envelope) *registryv1.RecordEnvelope {
+	if e == nil {
+		return nil
+	}
+	return &registryv1.RecordEnvelope{Payload: e.Payload, PublicKey: e.PublicKey, Signature: e.Signature}
+}
+
+func envelopeFromProto(pb *registryv1.RecordEnvelope) *registry.Envelope {
+	if pb == nil {
+		return nil
+	}
+	return &registry.Envelope{Payload: pb.GetPayload(), PublicKey: pb.GetPublicKey(), Signature: pb.GetSignature()}
+}
+
+func loadToProto(l *registry.Load) *registryv1.Load {
+	if l == nil {
+		return nil
+	}
+	return &registryv1.Load{Utilization: l.Utilization, Sessions: uint32(l.Sessions)}
+}
+
+func loadFromProto(pb *registryv1.Load) *registry.Load {
+	if pb == nil {
+		return nil
+	}
+	return &registry.Load{Utilization: pb.GetUtilization(), Sessions: int(pb.GetSessions())}
+}
+
+func locationToProto(l *registry.Location) *registryv1.Location {
+	if l == nil {
+		return nil
+	}
+	return &registryv1.Location{
+		Country:   l.Country,
+		Continent: l.Continent,
+		Colo:      l.Colo,
+		Latitude:  l.Latitude,
+		Longitude: l.Longitude,
+	}
+}
+
+func locationFromProto(pb *registryv1.Location) *registry.Location {
+	if pb == nil {
+		return nil
+	}
+	return &registry.Location{
+		Country:   pb.GetCountry(),
+		Continent: pb.GetContinent(),
+		Colo:      pb.GetColo(),
+		Latitude:  pb.GetLatitude(),
+		Longitude: pb.GetLongitude(),
+	}
+}
+
+func rttToProto(rtt map[string]int) map[string]uint32 {
+	if len(rtt) == 0 {
+		return nil
+	}
+	out := make(map[string]uint32, len(rtt))
+	for id, ms := range rtt {
+		out[id] = uint32(ms)
+	}
+	return out
+}
+
+func rttFromProto(rtt map[string]uint32) map[string]int {
+	if len(rtt) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(rtt))
+	for id, ms := range rtt {
+		out[id] = int(ms)
+	}
+	return out
+}
+
+func eventToProto(ev registry.Event) *registryv1.WatchResponse {
+	resp := &registryv1.WatchResponse{Record: recordToProto(ev.Record), Cursor: ev.Cursor}
+	switch ev.Type {
+	case registry.EventPut:
+		resp.Type = registryv1.EventType_EVENT_TYPE_PUT
+	case registry.EventDelete:
+		resp.Type = registryv1.EventType_EVENT_TYPE_DELETE
+	case registry.EventExpire:
+		resp.Type = registryv1.EventType_EVENT_TYPE_EXPIRE
+	case registry.EventSuspect:
+		resp.Type = registryv1.EventType_EVENT_TYPE_SUSPECT
+	case registry.EventAlive:
+		resp.Type = registryv1.EventType_EVENT_TYPE_ALIVE
+	case registry.EventFail:
+		resp.Type = registryv1.EventType_EVENT_TYPE_FAIL
+	}
+	return resp
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	registryv1 "github.com/coral-mesh/coral-discovery-workers/wasm/gen/coral/registry/v1"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// checkTimeout bounds one evaluation of the readiness checks.
+const checkTimeout = 5 * time.Second
+
+// healthPoll is how often gRPC health watches evaluate readiness.
+const healthPoll = 5 * time.Second
+
+// Check is a readiness check: Check returns an error while the server
+// should not be routed requests because of the dependency Name.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Health reports the health of a Server to load balancers and
+// orchestrators, over HTTP:
+//
+//	GET /healthz (liveness: the process serves)
+//	GET /readyz (readiness: every check passes)
+//
+// and as the grpc.health.v1 Health service, where the overall status and
+// that of coral.registry.v1.RegistryService are readiness. The instance
+// is not ready while its registry drains or cannot reach its store,
+// while a replication peer has not been pulled up within
+// Config.MaxReplicationLag, while Config.Keys is overdue for rotation,
+// or while one of Config.Checks fails. Both routes are public and answer
+// with a report of the checks, with 503 when one fails. Serve them
+// outside the Server, so that probes are not rate limited or forwarded
+// to other shards.
+type Health struct {
+	healthpb.UnimplementedHealthServer
+
+	checks []Check
+}
+
+// healthReport is the body of the health routes.
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// NewHealth creates the Health of the Server created from cfg.
+func NewHealth(cfg Config) *Health {
+	h := &Health{}
+	if reg := cfg.Registry; reg != nil {
+		h.checks = append(h.checks, Check{Name: "drain", Check: func(context.Context) error {
+			if reg.Draining() {
+				return registry.ErrDraining
+			}
+			return nil
+		}}, Check{Name: "store", Check: reg.Ping})
+	}
+	if cfg.Replication != nil && cfg.MaxReplicationLag > 0 {
+		h.checks = append(h.checks, Check{Name: "replication", Check: func(context.Context) error {
+			lag := cfg.Replication.Lag()
+			for _, peer := range slices.Sorted(maps.Keys(lag)) {
+				if lag[peer] > cfg.MaxReplicationLag {
+					return fmt.Errorf("peer %s last pulled up %s ago, over %s", peer, lag[peer].Round(time.Second), cfg.MaxReplicationLag)
+				}
+			}
+			return nil
+		}})
+	}
+	if cfg.Keys != nil {
+		h.checks = append(h.checks, Check{Name: "keys", Check: func(context.Context) error {
+			return cfg.Keys.Fresh()
+		}})
+	}
+	h.checks = append(h.checks, cfg.Checks...)
+	return h
+}
+
+// ServeHTTP implements http.Handler for the health routes.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		writeJSON(w, http.StatusOK, &healthReport{Status: "ok"})
+	case "/readyz":
+		report, ready := h.evaluate(r.Context())
+		w.Header().Set("Cache-Control", "no-store")
+		if !ready {
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// evaluate runs every check, reporting whether all of them pass.
+func (h *Health) evaluate(ctx context.Context) (*healthReport, bool) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	report := &healthReport{Status: "ok", Checks: make(map[string]string, len(h.checks))}
+	for _, c := range h.checks {
+		if err := c.Check(ctx); err != nil {
+			report.Status = "unavailable"
+			report.Checks[c.Name] = err.Error()
+			continue
+		}
+		report.Checks[c.Name] = "ok"
+	}
+	return report, report.Status == "ok"
+}
+
+// servingStatus returns the grpc.health.v1 status of service.
+func (h *Health) servingStatus(ctx context.Context, service string) healthpb.HealthCheckResponse_ServingStatus {
+	if service != "" && service != registryv1.RegistryService_ServiceDesc.ServiceName {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	if _, ready := h.evaluate(ctx); !ready {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// Check implements healthpb.HealthServer.
+func (h *Health) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st := h.servingStatus(ctx, req.GetService())
+	if st == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch implements healthpb.HealthServer, sending the status of the
+// requested service whenever it changes, as evaluated every healthPoll.
+func (h *Health) Watch(req *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	ticker := time.NewTicker(healthPoll)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st := h.servingStatus(stream.Context(), req.GetService()); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
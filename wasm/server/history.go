@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// handleHistory lists the archived registry changes of the ticket's
+// reef, oldest first.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadHistory, audit.AdminHistory)
+	if !ok {
+		return
+	}
+	since, ok := timeParam(w, r, "since")
+	if !ok {
+		return
+	}
+	until, ok := timeParam(w, r, "until")
+	if !ok {
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	page, err := s.history.History(r.Context(), history.Query{
+		ReefID:   p.ReefID,
+		ColonyID: q.Get("colony"),
+		Since:    since,
+		Until:    until,
+		Cursor:   q.Get("cursor"),
+		Limit:    limit,
+	})
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminHistory, p.ReferralClaims, q.Get("colony"), "")
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleTopology lists the agents of the ticket's reef that were
+// registered at the time given by the at parameter.
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadHistory, audit.AdminTopology)
+	if !ok {
+		return
+	}
+	at, ok := timeParam(w, r, "at")
+	if !ok {
+		return
+	}
+	if at.IsZero() {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "at is required")
+		return
+	}
+	colony := r.URL.Query().Get("colony")
+	recs, err := s.history.Topology(r.Context(), p.ReefID, colony, at)
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminTopology, p.ReferralClaims, colony, "")
+	writeJSON(w, http.StatusOK, &registry.Page{Records: recs})
+}
+
+// timeParam parses the optional RFC 3339 time parameter name, the zero
+// time when absent. It writes the error response when it is invalid.
+func timeParam(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid "+name+": "+v)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// writeHistoryError maps history errors onto HTTP status codes.
+func writeHistoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, history.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	case errors.Is(err, history.ErrCompacted):
+		writeError(w, http.StatusGone, "out_of_range", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+)
+
+// maxAcquireWait caps the long poll of a lock acquisition below common
+// client and proxy timeouts.
+const maxAcquireWait = 25 * time.Second
+
+// acquireRequest is the body of POST /v1/locks/{name}/acquire.
+type acquireRequest struct {
+	Session    string `json:"session,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// leaseRequest is the body of the renew and release routes of a lock.
+type leaseRequest struct {
+	Session string `json:"session,omitempty"`
+	Fence   uint64 `json:"fence"`
+}
+
+// holder returns the ticket's agent as holder of the lock of the request.
+func holder(r *http.Request, p *auth.Principal, session string) lock.Holder {
+	return lock.Holder{
+		ReefID:   p.ReefID,
+		ColonyID: p.ColonyID,
+		AgentID:  p.AgentID,
+		Name:     r.PathValue("name"),
+		Session:  session,
+	}
+}
+
+// handleLock serves the live leases of a lock or semaphore of the
+// ticket's colony.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	sem, err := s.locks.Semaphore(r.Context(), p.ReefID, p.ColonyID, r.PathValue("name"))
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sem)
+}
+
+// handleAcquire leases the ticket's agent a slot of a lock or semaphore
+// of its colony. The optional wait parameter (a Go duration, at most
+// maxAcquireWait) long polls while every slot is held; the request fails
+// with 409 lock_unavailable when none frees up in time.
+func (s *Server) handleAcquire(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxAcquireWait)
+	if !ok {
+		return
+	}
+	var req acquireRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	lease, err := s.locks.Acquire(r.Context(), holder(r, p, req.Session), req.Limit, ttl, wait)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+// handleRenewLease extends a lease of the ticket's agent.
+func (s *Server) handleRenewLease(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req leaseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	lease, err := s.locks.Renew(r.Context(), holder(r, p, req.Session), req.Fence)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+// handleRelease gives up a lease of the ticket's agent.
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req leaseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if err := s.locks.Release(r.Context(), holder(r, p, req.Session), req.Fence); err != nil {
+		writeLockError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeLockError maps lock errors onto HTTP status codes.
+func writeLockError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lock.ErrUnavailable):
+		writeError(w, http.StatusConflict, "lock_unavailable", err.Error())
+	case errors.Is(err, lock.ErrNotHeld):
+		writeError(w, http.StatusConflict, "not_held", err.Error())
+	case errors.Is(err, lock.ErrInvalid):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
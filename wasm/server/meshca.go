@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// certificateRequest is the body of POST /v1/mesh/certificates.
+type certificateRequest struct {
+	// CSR is the DER PKCS#10 request, base64-encoded by encoding/json.
+	CSR []byte `json:"csr"`
+}
+
+// orderRequest is the body of POST /v1/mesh/orders.
+type orderRequest struct {
+	// Certificate is the agent's current PEM certificate.
+	Certificate string `json:"certificate"`
+}
+
+// challengeResponse is the body of POST /v1/mesh/orders/{id}/challenge.
+type challengeResponse struct {
+	// Signature is the certified key's signature of the order's key
+	// authorization, base64-encoded by encoding/json.
+	Signature []byte `json:"signature"`
+}
+
+// meshRootsResponse is the body answering GET /v1/mesh/ca.
+type meshRootsResponse struct {
+	// Roots is the PEM bundle of every CA root.
+	Roots string `json:"roots"`
+}
+
+// handleIssueCertificate signs a mesh certificate for the ticket's agent,
+// which must be registered. Tickets carry the registry.IntentRegister
+// intent, like the registration they vouch for.
+func (s *Server) handleIssueCertificate(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.Intent != registry.IntentRegister {
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+registry.IntentRegister)
+		return
+	}
+	// Certificates renew themselves through orders; issuing from one, or
+	// from another method standing in for a ticket, would certify any key.
+	if p.Ticket == "" {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "referral ticket is required")
+		return
+	}
+	var req certificateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	cert, err := s.meshCA.Issue(r.Context(), p.ReferralClaims, p.Ticket, req.CSR)
+	if err != nil {
+		s.record(r, audit.CertIssued, p.ReferralClaims, p.AgentID, err.Error())
+		writeMeshCAError(w, err)
+		return
+	}
+	s.record(r, audit.CertIssued, p.ReferralClaims, cert.SPIFFEID, "")
+	writeJSON(w, http.StatusOK, cert)
+}
+
+// handleMeshRoots serves the CA roots. Like the JWKS they are public, so
+// peers can verify mesh certificates without a referral ticket.
+func (s *Server) handleMeshRoots(w http.ResponseWriter, r *http.Request) {
+	roots, err := s.meshCA.RootsPEM()
+	if err != nil {
+		writeMeshCAError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meshRootsResponse{Roots: roots})
+}
+
+// handleNewOrder opens a renewal order for the certificate in the body.
+// The certificate and, later, the challenge response are the credential,
+// so no ticket is needed.
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	var req orderRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	o, err := s.meshCA.NewOrder(r.Context(), []byte(req.Certificate))
+	if err != nil {
+		writeMeshCAError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, o)
+}
+
+// handleOrder returns a renewal order.
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	o, err := s.meshCA.Order(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeMeshCAError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleOrderChallenge answers the challenge of a pending order.
+func (s *Server) handleOrderChallenge(w http.ResponseWriter, r *http.Request) {
+	var req challengeResponse
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	o, err := s.meshCA.Respond(r.Context(), r.PathValue("id"), req.Signature)
+	if err != nil {
+		writeMeshCAError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleFinalizeOrder issues the renewed certificate of a ready order.
+func (s *Server) handleFinalizeOrder(w http.ResponseWriter, r *http.Request) {
+	var req certificateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	o, err := s.meshCA.Finalize(r.Context(), r.PathValue("id"), req.CSR)
+	if err != nil {
+		s.record(r, audit.CertRenewed, nil, r.PathValue("id"), err.Error())
+		writeMeshCAError(w, err)
+		return
+	}
+	s.record(r, audit.CertRenewed, nil, o.Certificate.SPIFFEID, "")
+	writeJSON(w, http.StatusOK, o)
+}
+
+// writeMeshCAError maps mesh CA errors onto HTTP status codes.
+func writeMeshCAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, meshca.ErrInvalidCSR):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	case errors.Is(err, meshca.ErrNotRegistered), errors.Is(err, meshca.ErrOrderState):
+		writeError(w, http.StatusPreconditionFailed, "failed_precondition", err.Error())
+	case errors.Is(err, meshca.ErrInvalidCertificate), errors.Is(err, meshca.ErrChallengeFailed):
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+	case errors.Is(err, meshca.ErrOrderNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+)
+
+// peerKey is the request context key of the client certificate's
+// principal.
+type peerKey struct{}
+
+// checkPeer authenticates the mesh certificate a client presents over
+// TLS and records its principal in the returned request's context,
+// writing a 401 on failure. Requests without one are left to their other
+// credentials.
+func (s *Server) checkPeer(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.peer == nil {
+		return r, true
+	}
+	p, err := s.peer.Authenticate(r)
+	if errors.Is(err, auth.ErrNoCredentials) {
+		return r, true
+	}
+	if err != nil {
+		s.metrics.reject(err, "certificate")
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), peerKey{}, p)), true
+}
+
+// peerPrincipal authenticates requests by the client certificate
+// checkPeer verified, so that the chain does not verify it again.
+func peerPrincipal(r *http.Request) (*auth.Principal, error) {
+	if p, ok := r.Context().Value(peerKey{}).(*auth.Principal); ok {
+		return p, nil
+	}
+	return nil, auth.ErrNoCredentials
+}
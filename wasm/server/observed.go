@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/peer"
+)
+
+// remoteAddr returns the address r came from. Behind a trusted proxy it
+// is the last X-Forwarded-For hop, the one the proxy appended itself.
+func (s *Server) remoteAddr(r *http.Request) netip.Addr {
+	if s.trustProxy {
+		if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+			list := strings.Split(hops[len(hops)-1], ",")
+			if a, err := netip.ParseAddr(strings.TrimSpace(list[len(list)-1])); err == nil {
+				return a
+			}
+		}
+	}
+	return hostAddr(r.RemoteAddr)
+}
+
+// peerAddr returns the address a gRPC call came from.
+func peerAddr(ctx context.Context) netip.Addr {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}
+	}
+	return hostAddr(p.Addr.String())
+}
+
+func hostAddr(hostport string) netip.Addr {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	a, _ := netip.ParseAddr(host)
+	return a
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+)
+
+// handleNonce issues a proof-of-possession nonce.
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	nonce := s.pop.Nonce()
+	w.Header().Set(pop.NonceHeader, nonce)
+	writeJSON(w, http.StatusOK, map[string]string{"nonce": nonce})
+}
+
+// checkProof verifies the proof accompanying a ticketed request, writing
+// a 401 with a fresh nonce on failure. The ticket itself is verified by
+// the handler.
+func (s *Server) checkProof(w http.ResponseWriter, r *http.Request) bool {
+	token := bearerToken(r)
+	if s.pop == nil || token == "" {
+		return true
+	}
+	if err := s.pop.Check(token, r.Header.Get(pop.ProofHeader)); err != nil {
+		s.metrics.reject(err, "proof")
+		w.Header().Set(pop.NonceHeader, s.pop.Nonce())
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return false
+	}
+	return true
+}
+
+// checkProof verifies the proof in the coral-pop metadata key. On failure
+// a fresh nonce is sent in the coral-pop-nonce response header.
+func (s *GRPCServer) checkProof(ctx context.Context) error {
+	token := metadataToken(ctx)
+	if s.pop == nil || token == "" {
+		return nil
+	}
+
+	var proof string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(pop.ProofHeader)); len(v) > 0 {
+			proof = v[0]
+		}
+	}
+	if err := s.pop.Check(token, proof); err != nil {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(pop.NonceHeader), s.pop.Nonce()))
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicConnKey is the request context key of the QUIC connection a request
+// arrived on.
+type quicConnKey struct{}
+
+// NewQUIC returns an HTTP/3 server for h on the UDP address addr, normally
+// a Server, possibly behind a mux adding the JWKS route. Resumed sessions
+// may carry 0-RTT early data so that agents renew their lease without a
+// handshake round trip after a network change. Early data can be replayed
+// by an attacker, so only safe methods and lease renewals, whose replay
+// merely extends a lease the ticket already allows, are served before the
+// handshake completes; anything else gets 425 Too Early.
+func NewQUIC(addr string, h http.Handler, tlsConf *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:       addr,
+		Handler:    earlyDataGuard(h),
+		TLSConfig:  tlsConf,
+		QUICConfig: &quic.Config{Allow0RTT: true},
+		ConnContext: func(ctx context.Context, c quic.Connection) context.Context {
+			return context.WithValue(ctx, quicConnKey{}, c)
+		},
+	}
+}
+
+// earlyDataGuard rejects requests received as early data unless they are
+// safe to replay.
+func earlyDataGuard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isEarlyData(r) && !replaySafe(r) {
+			writeError(w, http.StatusTooEarly, "too_early", "request must not be sent as 0-RTT early data")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isEarlyData reports whether r arrived before its connection's handshake
+// completed.
+func isEarlyData(r *http.Request) bool {
+	conn, ok := r.Context().Value(quicConnKey{}).(quic.EarlyConnection)
+	if !ok {
+		return false
+	}
+	select {
+	case <-conn.HandshakeComplete():
+		return false
+	default:
+		return true
+	}
+}
+
+func replaySafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		return strings.HasPrefix(r.URL.Path, "/v1/agents/") && strings.HasSuffix(r.URL.Path, "/renew")
+	}
+	return false
+}
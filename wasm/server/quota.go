@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// quotasResponse is the body answering GET /v1/admin/quotas.
+type quotasResponse struct {
+	Default registry.ColonyQuota    `json:"default"`
+	Quotas  []*registry.ColonyQuota `json:"quotas"`
+}
+
+// quotaErrorBody is the body of a 429 quota_exceeded. Quota names the
+// quota exceeded (see registry.QuotaError).
+type quotaErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Quota   string `json:"quota,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// handleAdminQuotas lists the default colony quota and the colonies of
+// the ticket's reef with quotas of their own.
+func (s *Server) handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadQuotas, audit.AdminQuotas)
+	if !ok {
+		return
+	}
+	quotas, err := s.registry.ColonyQuotas(r.Context(), p.ReefID)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminQuotas, p.ReferralClaims, "", "")
+	writeJSON(w, http.StatusOK, quotasResponse{Default: s.registry.DefaultColonyQuota(), Quotas: quotas})
+}
+
+// handleAdminQuota shows a colony's quota and how much of it the colony
+// uses on this instance.
+func (s *Server) handleAdminQuota(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadQuotas, audit.AdminQuotas)
+	if !ok {
+		return
+	}
+	status, err := s.registry.ColonyQuotaStatus(r.Context(), p.ReefID, r.PathValue("colony"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminQuotas, p.ReferralClaims, r.PathValue("colony"), "")
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleAdminSetQuota sets a colony's quota from the body, a
+// registry.ColonyQuota whose reef and colony are taken from the ticket
+// and the path.
+func (s *Server) handleAdminSetQuota(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermWriteQuotas, audit.AdminSetQuota)
+	if !ok {
+		return
+	}
+	var q registry.ColonyQuota
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	q.ReefID, q.ColonyID = p.ReefID, r.PathValue("colony")
+	if err := s.registry.SetColonyQuota(r.Context(), &q); err != nil {
+		s.record(r, audit.AdminSetQuota, p.ReferralClaims, q.ColonyID, err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminSetQuota, p.ReferralClaims, q.ColonyID, "")
+	writeJSON(w, http.StatusOK, &q)
+}
+
+// handleAdminResetQuota returns a colony to the default quota.
+func (s *Server) handleAdminResetQuota(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermWriteQuotas, audit.AdminResetQuota)
+	if !ok {
+		return
+	}
+	colony := r.PathValue("colony")
+	if err := s.registry.ResetColonyQuota(r.Context(), p.ReefID, colony); err != nil {
+		s.record(r, audit.AdminResetQuota, p.ReferralClaims, colony, err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminResetQuota, p.ReferralClaims, colony, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeQuotaExceeded answers 429 quota_exceeded naming the quota, with a
+// Retry-After header for quotas that refill.
+func writeQuotaExceeded(w http.ResponseWriter, err error) {
+	body := quotaErrorBody{Code: "quota_exceeded", Message: err.Error()}
+	var qe *registry.QuotaError
+	if errors.As(err, &qe) {
+		body.Quota, body.Limit = qe.Quota, qe.Limit
+		if qe.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(qe.RetryAfter.Seconds()))))
+		}
+	}
+	writeJSON(w, http.StatusTooManyRequests, body)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+)
+
+// routeClasses are the budgets charged to the source IP of routes, beyond
+// ratelimit.Verify for every request presenting a ticket. Lookups are
+// also charged to the ticket's agent and colony once it is verified, and
+// the registry charges writes itself.
+var routeClasses = map[string]ratelimit.Class{
+	"POST /v1/register":                  ratelimit.Register,
+	"DELETE /v1/agents/{id}":             ratelimit.Register,
+	"POST /v1/agents/{id}/renew":         ratelimit.Register,
+	"GET /v1/agents/{id}":                ratelimit.Lookup,
+	"GET /v1/colonies/{id}/agents":       ratelimit.Lookup,
+	"GET /v1/colonies/{id}/digest":       ratelimit.Lookup,
+	"GET /v1/colonies/{id}/allowlist":    ratelimit.Lookup,
+	"PUT /v1/colonies/{id}/allowlist":    ratelimit.Register,
+	"GET /v1/colonies/{id}/schema":       ratelimit.Lookup,
+	"GET /v1/topics/{topic}/agents":      ratelimit.Lookup,
+	"GET /v1/services/{service}/agents":  ratelimit.Lookup,
+	"GET /v1/watch":                      ratelimit.Lookup,
+	"GET /v1/changes":                    ratelimit.Lookup,
+	"GET /v1/ws":                         ratelimit.Lookup,
+	"POST /v1/enrollments":               ratelimit.Register,
+	"GET /v1/enrollments/{id}":           ratelimit.Lookup,
+	"POST /v1/mesh/certificates":         ratelimit.Register,
+	"GET /v1/elections/{name}":           ratelimit.Lookup,
+	"POST /v1/elections/{name}/campaign": ratelimit.Register,
+	"POST /v1/elections/{name}/renew":    ratelimit.Register,
+	"POST /v1/elections/{name}/resign":   ratelimit.Register,
+	"GET /v1/locks/{name}":               ratelimit.Lookup,
+	"POST /v1/locks/{name}/acquire":      ratelimit.Register,
+	"POST /v1/locks/{name}/renew":        ratelimit.Register,
+	"POST /v1/locks/{name}/release":      ratelimit.Register,
+
+	// Renewals present no ticket, so the source IP is all they are
+	// charged to.
+	"POST /v1/mesh/orders":                ratelimit.Register,
+	"GET /v1/mesh/orders/{id}":            ratelimit.Lookup,
+	"POST /v1/mesh/orders/{id}/challenge": ratelimit.Register,
+	"POST /v1/mesh/orders/{id}/finalize":  ratelimit.Register,
+}
+
+// limit serves r with next unless its source IP is over budget or in the
+// penalty box, and counts a rejected ticket against the IP.
+func (s *Server) limit(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if s.limiter == nil {
+		next(w, r)
+		return
+	}
+	key := ratelimit.Key{IP: s.clientIP(r)}
+	token := bearerToken(r)
+	_, route := s.mux.Handler(r)
+	if class, ok := routeClasses[route]; ok {
+		if err := s.limiter.Allow(class, key); err != nil {
+			writeLimited(w, err)
+			return
+		}
+	}
+	if token != "" {
+		if err := s.limiter.Allow(ratelimit.Verify, key); err != nil {
+			writeLimited(w, err)
+			return
+		}
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next(sw, r)
+	if token != "" && sw.status == http.StatusUnauthorized {
+		s.limiter.Fail(key.IP)
+	}
+}
+
+// clientIP returns the request's source address as a limiter key, or
+// empty when unknown.
+func (s *Server) clientIP(r *http.Request) string {
+	if a := s.remoteAddr(r); a.IsValid() {
+		return a.String()
+	}
+	return ""
+}
+
+// writeLimited answers 429 with a Retry-After header for an error from
+// the limiter.
+func writeLimited(w http.ResponseWriter, err error) {
+	var limited *ratelimit.Error
+	if errors.As(err, &limited) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	}
+	writeError(w, http.StatusTooManyRequests, "resource_exhausted", err.Error())
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/readcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// consistencyHeader chooses how fresh the results of a lookup or colony
+// listing must be: "strong", "shared" or "bounded" (see package
+// readcache). It is also read from gRPC metadata, lowercased.
+const consistencyHeader = "Coral-Consistency"
+
+// consistencyParam parses the consistency header. It writes the error
+// response when the header is invalid.
+func consistencyParam(w http.ResponseWriter, r *http.Request) (readcache.Consistency, bool) {
+	level, err := readcache.ParseConsistency(r.Header.Get(consistencyHeader))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+		return readcache.Default, false
+	}
+	return level, true
+}
+
+// metadataConsistency parses the consistency of a gRPC request.
+func metadataConsistency(ctx context.Context) (readcache.Consistency, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(consistencyHeader)); len(v) > 0 {
+			return readcache.ParseConsistency(v[0])
+		}
+	}
+	return readcache.Default, nil
+}
+
+// setAge reports the age of results served from the read cache.
+func setAge(w http.ResponseWriter, age time.Duration) {
+	if age > 0 {
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+}
+
+// lookupAgent looks agentID of reefID up in reg through the read cache.
+// Cache keys name the reef, so reefs never share results.
+func lookupAgent(ctx context.Context, reads *readcache.Cache, reg *registry.Registry, level readcache.Consistency, reefID, agentID string) (*registry.Record, time.Duration, error) {
+	return readcache.Read(ctx, reads, level, "agent\x00"+reefID+"\x00"+agentID, func(ctx context.Context) (*registry.Record, error) {
+		return reg.Lookup(ctx, reefID, agentID)
+	})
+}
+
+// queryColony lists the records of colonyID in reefID matching sel,
+// parsed from selector, in reg through the read cache.
+func queryColony(ctx context.Context, reads *readcache.Cache, reg *registry.Registry, level readcache.Consistency, reefID, colonyID, selector string, sel *registry.Selector) ([]*registry.Record, time.Duration, error) {
+	return readcache.Read(ctx, reads, level, "colony\x00"+reefID+"\x00"+colonyID+"\x00"+selector, func(ctx context.Context) ([]*registry.Record, error) {
+		return reg.Query(ctx, reefID, colonyID, sel)
+	})
+}
+
+// lookup is lookupAgent against the server's registry.
+func (s *Server) lookup(ctx context.Context, level readcache.Consistency, reefID, agentID string) (*registry.Record, time.Duration, error) {
+	return lookupAgent(ctx, s.reads, s.registry, level, reefID, agentID)
+}
+
+// query is queryColony against the server's registry.
+func (s *Server) query(ctx context.Context, level readcache.Consistency, reefID, colonyID, selector string, sel *registry.Selector) ([]*registry.Record, time.Duration, error) {
+	return queryColony(ctx, s.reads, s.registry, level, reefID, colonyID, selector, sel)
+}
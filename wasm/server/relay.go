@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/relay"
+)
+
+// reserveRequest is the body of POST /v1/relay/reservations.
+type reserveRequest struct {
+	Peer       string `json:"peer"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+}
+
+// handleReserveRelay reserves a relay between the ticket's agent and a
+// peer in the ticket's colony, returning the agent's relay ticket.
+func (s *Server) handleReserveRelay(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req reserveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	res, err := s.relay.Reserve(r.Context(), p.ReefID, p.ColonyID, p.AgentID, req.Peer, req.QuotaBytes)
+	if err != nil {
+		writeRelayError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// handleRelayReservations lists the reservations peers made with the
+// ticket's agent, each with the agent's relay ticket, long polling up to
+// the wait parameter when there are none.
+func (s *Server) handleRelayReservations(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxPollWait)
+	if !ok {
+		return
+	}
+
+	found, err := s.relay.Reservations(r.Context(), p.ReefID, p.AgentID, wait)
+	if err != nil {
+		writeRelayError(w, err)
+		return
+	}
+	if found == nil {
+		found = []*relay.Reservation{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"reservations": found,
+	})
+}
+
+// writeRelayError maps relay errors onto HTTP status codes.
+func writeRelayError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, relay.ErrPeerNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, relay.ErrNoRelay):
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+	case errors.Is(err, relay.ErrInvalidQuota):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rendezvous"
+)
+
+// maxSignalWait caps the long poll of GET /v1/rendezvous/signals below
+// common client and proxy timeouts.
+const maxSignalWait = 25 * time.Second
+
+// signalRequest is the body of POST /v1/rendezvous/signals.
+type signalRequest struct {
+	To   string          `json:"to"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// handleSendSignal queues a signal from the ticket's agent to a peer in
+// the ticket's colony.
+func (s *Server) handleSendSignal(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req signalRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*rendezvous.MaxDataSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	sig, err := s.rendezvous.Send(r.Context(), &rendezvous.Signal{
+		Type:     req.Type,
+		From:     p.AgentID,
+		To:       req.To,
+		ReefID:   p.ReefID,
+		ColonyID: p.ColonyID,
+		Data:     req.Data,
+	})
+	if err != nil {
+		writeRendezvousError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sig)
+}
+
+// handleReceiveSignals drains the mailbox of the ticket's agent. The
+// optional wait parameter (a Go duration, at most maxSignalWait) long
+// polls an empty mailbox.
+func (s *Server) handleReceiveSignals(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxSignalWait)
+	if !ok {
+		return
+	}
+
+	sigs, err := s.rendezvous.Receive(r.Context(), p.ReefID, p.AgentID, wait)
+	if err != nil {
+		writeRendezvousError(w, err)
+		return
+	}
+	if sigs == nil {
+		sigs = []*rendezvous.Signal{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"signals": sigs,
+	})
+}
+
+// writeRendezvousError maps rendezvous errors onto HTTP status codes.
+func writeRendezvousError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rendezvous.ErrPeerNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, rendezvous.ErrInvalidSignal):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
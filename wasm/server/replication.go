@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/crdt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replication"
+)
+
+// maxDeltaSize bounds the CRDT deltas edges push.
+const maxDeltaSize = 32 << 20
+
+// isReplicationRoute reports whether r is for a route replicas call,
+// which carry the replicas' secret rather than a referral ticket.
+func isReplicationRoute(r *http.Request) bool {
+	return r.URL.Path == replication.Path || r.URL.Path == crdt.SyncPath
+}
+
+// handleReplicationChanges serves the registry's changes after ?after=
+// to a peer replica.
+func (s *Server) handleReplicationChanges(w http.ResponseWriter, r *http.Request) {
+	if err := s.replication.Authenticate(bearerToken(r)); err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
+	}
+	var after uint64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "after must be a store revision")
+			return
+		}
+	}
+	batch, err := s.replication.Changes(r.Context(), after)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, batch)
+}
+
+// maxHandoffSize bounds the batches of changes peers push.
+const maxHandoffSize = 32 << 20
+
+// handleReplicationPush applies the changes a peer replica pushed while
+// handing off its leases.
+func (s *Server) handleReplicationPush(w http.ResponseWriter, r *http.Request) {
+	if err := s.replication.Authenticate(bearerToken(r)); err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
+	}
+	var batch replication.Batch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHandoffSize)).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid batch: "+err.Error())
+		return
+	}
+	applied, err := s.replication.Receive(r.Context(), &batch)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"applied": applied})
+}
+
+// handleCRDTSync merges the delta an edge replica pushed and answers
+// with the delta it lacks.
+func (s *Server) handleCRDTSync(w http.ResponseWriter, r *http.Request) {
+	if err := s.crdt.Authenticate(bearerToken(r)); err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
+	}
+	var delta crdt.Map
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeltaSize)).Decode(&delta); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid delta: "+err.Error())
+		return
+	}
+	answer, err := s.crdt.Handle(r.Context(), &delta)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, answer)
+}
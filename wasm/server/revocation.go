@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+)
+
+// revokeRequest is the body of POST /v1/revocations.
+type revokeRequest struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleRevoke revokes a ticket of the caller's reef. The caller's
+// ticket must carry the revocation.IntentRevoke intent and a role
+// granting rbac.PermRevokeTickets.
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.Intent != revocation.IntentRevoke {
+		s.record(r, audit.TicketRevoked, p.ReferralClaims, "", "ticket intent must be "+revocation.IntentRevoke)
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+revocation.IntentRevoke)
+		return
+	}
+	if err := s.rbac.AuthorizeRoles(p.Roles, rbac.PermRevokeTickets); err != nil {
+		s.record(r, audit.TicketRevoked, p.ReferralClaims, "", err.Error())
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+		return
+	}
+
+	var req revokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if req.ExpiresAt.IsZero() {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "expires_at is required")
+		return
+	}
+
+	if err := s.revocations.Revoke(r.Context(), p.ReefID, req.JTI, req.ExpiresAt); err != nil {
+		s.record(r, audit.TicketRevoked, p.ReferralClaims, req.JTI, err.Error())
+		if errors.Is(err, revocation.ErrInvalidJTI) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	s.record(r, audit.TicketRevoked, p.ReferralClaims, req.JTI, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevocations serves the current revocation snapshot in its binary
+// encoding. Like the JWKS it is public: entries are truncated hashes.
+func (s *Server) handleRevocations(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.revocations.Snapshot(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	blob, err := snap.MarshalBinary()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(blob)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+)
+
+// handleColonySchema serves the metadata schema of a colony of the
+// ticket's reef, for consumers to generate types from.
+func (s *Server) handleColonySchema(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cs, err := s.registry.ColonySchema(r.Context(), p.ReefID, r.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// handleAdminSetSchema makes the body, a JSON Schema, the metadata schema
+// of a colony of the ticket's reef.
+func (s *Server) handleAdminSetSchema(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermWriteSchemas, audit.AdminSetSchema)
+	if !ok {
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, schema.MaxSize)).Decode(&raw); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	cs := &registry.ColonySchema{ReefID: p.ReefID, ColonyID: r.PathValue("colony"), Schema: raw}
+	if err := s.registry.SetColonySchema(r.Context(), cs); err != nil {
+		s.record(r, audit.AdminSetSchema, p.ReferralClaims, cs.ColonyID, err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminSetSchema, p.ReferralClaims, cs.ColonyID, "")
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// handleAdminDeleteSchema removes the metadata schema of a colony.
+func (s *Server) handleAdminDeleteSchema(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermWriteSchemas, audit.AdminDeleteSchema)
+	if !ok {
+		return
+	}
+	colony := r.PathValue("colony")
+	if err := s.registry.DeleteColonySchema(r.Context(), p.ReefID, colony); err != nil {
+		s.record(r, audit.AdminDeleteSchema, p.ReferralClaims, colony, err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminDeleteSchema, p.ReferralClaims, colony, "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Selection strategies of a colony listing, each a query parameter
+// giving the number of agents to return.
+const (
+	selectNearest     = "nearest"
+	selectLeastLoaded = "least_loaded"
+	selectWeighted    = "weighted"
+)
+
+// selection returns the strategy the request asks the colony's agents to
+// be selected with, or nil for a plain listing. At most one of the
+// nearest, least_loaded and weighted parameters may be set. It writes
+// the error response when they are invalid.
+func (s *Server) selection(w http.ResponseWriter, r *http.Request, claims *jwt.ReferralClaims) (func([]*registry.Record) []*registry.Record, bool) {
+	var strategy string
+	n := 0
+	for _, name := range []string{selectNearest, selectLeastLoaded, selectWeighted} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		if strategy != "" {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "only one of nearest, least_loaded or weighted may be set")
+			return nil, false
+		}
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 || n > registry.MaxPageSize {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "invalid "+name+": "+v)
+			return nil, false
+		}
+		strategy = name
+	}
+	return selector(strategy, n, func() *registry.Record {
+		return s.origin(r.Context(), r, claims)
+	}), true
+}
+
+// selector returns the function applying strategy to a listing, nil when
+// strategy is empty. origin is only called for selectNearest.
+func selector(strategy string, n int, origin func() *registry.Record) func([]*registry.Record) []*registry.Record {
+	switch strategy {
+	case selectNearest:
+		return func(recs []*registry.Record) []*registry.Record {
+			return registry.Nearest(recs, origin(), n)
+		}
+	case selectLeastLoaded:
+		return func(recs []*registry.Record) []*registry.Record {
+			return registry.LeastLoaded(recs, time.Now(), n)
+		}
+	case selectWeighted:
+		return func(recs []*registry.Record) []*registry.Record {
+			return registry.Weighted(recs, time.Now(), n)
+		}
+	}
+	return nil
+}
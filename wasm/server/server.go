@@ -0,0 +1,894 @@
+// Package server exposes the discovery registry over HTTP/JSON so that
+// discovery can run as a standalone Go binary outside the Worker.
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/crdt"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/did"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/election"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/enrollment"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/federation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/jwks"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/lock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/meshca"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/oidc"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/policy"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/pop"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/ratelimit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/readcache"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/relay"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rendezvous"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/replication"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/schema"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/shard"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/webhook"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// Config holds the configuration for a Server.
+type Config struct {
+	// Registry serves registrations and lookups.
+	Registry *registry.Registry
+
+	// Verifier validates referral tickets, the first method of the
+	// authentication chain.
+	Verifier registry.Verifier
+
+	// APIKeys authenticates requests carrying a key in the Coral-API-Key
+	// header, after tickets, JWT-SVIDs and mesh certificates, when set.
+	APIKeys *auth.APIKeys
+
+	// Policy, when set, must allow every authenticated caller, whichever
+	// method of the chain authenticated it. Tickets are usually checked
+	// by a policy.Verifier already; this covers the other methods.
+	Policy *policy.Policy
+
+	// Revocations enables the /v1/revocations routes when set.
+	Revocations *revocation.List
+
+	// PoP enables proof-of-possession checks and GET /v1/pop/nonce when
+	// set. Bound tickets are then rejected without a valid proof.
+	PoP *pop.Checker
+
+	// Enrollment enables the /v1/enrollments routes queueing new agents
+	// for approval when set. Wrap the verifier with it so that only
+	// enrolled agents register.
+	Enrollment *enrollment.Enroller
+
+	// MeshCA enables POST /v1/mesh/certificates, issuing mTLS
+	// certificates to registered agents, and GET /v1/mesh/ca when set.
+	MeshCA *meshca.CA
+
+	// PeerRoots returns the mesh CA roots that client certificates are
+	// verified against when set; serve TLS with ClientAuth
+	// tls.RequestClientCert so that clients may present one. A verified
+	// certificate authenticates its agent where a ticket with the
+	// registry.IntentRegister intent would, and requests presenting both
+	// must name the same agent. Invalid certificates are rejected.
+	PeerRoots func() (*x509.CertPool, error)
+
+	// Threshold enables the /v1/threshold routes coordinating k-of-n
+	// signing of root tickets when set.
+	Threshold *threshold.Coordinator
+
+	// DID serves agents' did:web documents at /v1/agents/{id}/did.json,
+	// without a ticket, when set.
+	DID *did.Resolver
+
+	// SPIFFE enforces SPIFFE bindings when set: tickets bound to a SPIFFE
+	// ID are rejected without a matching JWT-SVID in the Coral-SVID
+	// header. Requests carrying only a JWT-SVID there are authenticated
+	// by it. Accepting SVIDs as tickets is configured on the verifier.
+	SPIFFE *spiffe.Adapter
+
+	// Exchange enables POST /v1/token, swapping OIDC ID tokens for
+	// referral tickets, when set.
+	Exchange *oidc.Exchanger
+
+	// Rendezvous enables the /v1/rendezvous/signals routes relaying
+	// WebRTC signaling between agents when set.
+	Rendezvous *rendezvous.Broker
+
+	// Elections enables the /v1/elections routes electing leaders among
+	// the agents of a colony when set.
+	Elections *election.Elections
+
+	// Locks enables the /v1/locks routes leasing locks and semaphores to
+	// the agents of a colony when set.
+	Locks *lock.Locks
+
+	// Traversal enables the /v1/traversal/attempts routes coordinating
+	// hole punching between agents when set.
+	Traversal *traversal.Coordinator
+
+	// Relay enables the /v1/relay/reservations routes brokering relays
+	// for agents that cannot punch through their NATs when set.
+	Relay *relay.Broker
+
+	// Federation forwards lookups of agents in federated reefs and
+	// answers those of its peers. Nil disables the federation routes.
+	Federation *federation.Federation
+
+	// Replication serves the registry's changes to the other replicas
+	// of an active-active deployment. Nil disables the route.
+	Replication *replication.Replicator
+
+	// CRDT merges the deltas of edge replicas, such as Workers accepting
+	// registrations locally. Nil disables the route.
+	CRDT *crdt.Replica
+
+	// Shards forwards the requests of colonies other instances own to
+	// them and exchanges the shard map with them when set.
+	Shards *shard.Router
+
+	// Metrics counts requests and times them by route when set. Serve
+	// the Set itself at /metrics.
+	Metrics *metrics.Set
+
+	// Tracer records a span for every request, continuing the trace of
+	// callers sending a traceparent header, when set.
+	Tracer *tracing.Tracer
+
+	// Audit records revocations, token exchanges and administrative
+	// actions when set. Give the same Log to the registry and wrap the
+	// verifier with it to record registrations and rejected tickets.
+	Audit *audit.Log
+
+	// RBAC decides which roles may use the /v1/admin routes. Defaults to
+	// rbac.DefaultPolicy; give the registry the same policy.
+	RBAC *rbac.Policy
+
+	// Keys enables POST /v1/admin/keys/rotate when set, together with
+	// KeysReef.
+	Keys *jwks.Set
+
+	// KeysReef is the deployment's own reef. Keys sign the tickets of
+	// every reef the deployment serves, so only admins of KeysReef may
+	// rotate them.
+	KeysReef string
+
+	// History enables the /v1/history routes querying archived registry
+	// changes.
+	History *history.Archive
+
+	// Webhooks enables the /v1/admin/webhooks routes managing the
+	// dead-letter queue of its webhook deliveries when set. Run the
+	// Dispatcher separately.
+	Webhooks *webhook.Dispatcher
+
+	// Limiter throttles requests by source IP, agent and colony, and
+	// boxes addresses presenting rejected tickets, when set. Give the
+	// registry the same Limiter to throttle writes by agent.
+	Limiter *ratelimit.Limiter
+
+	// ReadCache, when set, coalesces concurrent identical agent lookups
+	// and colony listings into one registry read and keeps their results
+	// for its TTL. Callers pick the consistency of a read with the
+	// Coral-Consistency header (or gRPC metadata); cached results carry
+	// an Age header.
+	ReadCache *readcache.Cache
+
+	// MaxReplicationLag, when set, takes the instance out of readiness
+	// (see Health) while a peer of Replication has not been pulled up
+	// for longer.
+	MaxReplicationLag time.Duration
+
+	// Checks are readiness checks of dependencies beyond those Health
+	// derives from the other fields.
+	Checks []Check
+
+	// TrustProxy takes the client address from the last X-Forwarded-For
+	// hop instead of the connection, and agent locations from
+	// Cloudflare's geolocation headers. Enable it only behind a proxy
+	// that sets them.
+	TrustProxy bool
+}
+
+// Server is an http.Handler serving the discovery REST API:
+//
+//	POST   /v1/register
+//	GET    /v1/agents/{id}[?reef=R] (other reefs when Config.Federation is set)
+//	DELETE /v1/agents/{id}
+//	POST   /v1/agents/{id}/renew
+//	GET    /v1/colonies/{id}/agents[?selector=gpu=true][&limit=500&cursor=C | &nearest=5|least_loaded=5|weighted=5]
+//	GET    /v1/colonies/{id}/digest
+//	GET    /v1/colonies/{id}/allowlist
+//	PUT    /v1/colonies/{id}/allowlist
+//	GET    /v1/colonies/{id}/schema
+//	GET    /v1/topics/{topic}/agents[?role=publisher|subscriber][&colony=C]
+//	GET    /v1/services/{service}/agents[?version=>=2.3,<3][&stable=true][&canary=10][&colony=C]
+//	GET    /v1/agents/{id}/did.json (when Config.DID is set; public)
+//	GET    /v1/watch (Server-Sent Events)
+//	GET    /v1/changes[?since=T][&colony_id=C]
+//	GET    /v1/ws (WebSocket: registry changes and revocations)
+//	GET    /v1/snapshot (registry backup; binary)
+//	PUT    /v1/snapshot (restore a backup)
+//	POST   /v1/revocations (when Config.Revocations is set)
+//	GET    /v1/revocations
+//	GET    /v1/pop/nonce (when Config.PoP is set)
+//	POST   /v1/enrollments (when Config.Enrollment is set)
+//	GET    /v1/enrollments/{id} (public)
+//	POST   /v1/enrollments/{id}/identity
+//	POST   /v1/mesh/certificates (when Config.MeshCA is set)
+//	GET    /v1/mesh/ca (public)
+//	POST   /v1/mesh/orders (certificate renewal; public)
+//	GET    /v1/mesh/orders/{id}
+//	POST   /v1/mesh/orders/{id}/challenge
+//	POST   /v1/mesh/orders/{id}/finalize
+//	POST   /v1/threshold/sessions (when Config.Threshold is set)
+//	GET    /v1/threshold/sessions/{id}
+//	POST   /v1/threshold/sessions/{id}/commitments
+//	POST   /v1/threshold/sessions/{id}/shares
+//	POST   /v1/token (when Config.Exchange is set; RFC 8693 form)
+//	POST   /v1/rendezvous/signals (when Config.Rendezvous is set)
+//	GET    /v1/rendezvous/signals[?wait=25s]
+//	GET    /v1/elections/{name} (when Config.Elections is set; name path-escaped)
+//	POST   /v1/elections/{name}/campaign[?wait=25s]
+//	POST   /v1/elections/{name}/renew
+//	POST   /v1/elections/{name}/resign
+//	GET    /v1/locks/{name} (when Config.Locks is set; name path-escaped)
+//	POST   /v1/locks/{name}/acquire[?wait=25s]
+//	POST   /v1/locks/{name}/renew
+//	POST   /v1/locks/{name}/release
+//	POST   /v1/traversal/attempts (when Config.Traversal is set)
+//	GET    /v1/traversal/attempts[?wait=25s]
+//	GET    /v1/traversal/attempts/{id}[?wait=25s]
+//	POST   /v1/traversal/attempts/{id}/accept
+//	POST   /v1/relay/reservations (when Config.Relay is set)
+//	GET    /v1/relay/reservations[?wait=25s]
+//	GET    /v1/federation/agents/{id} (when Config.Federation is set)
+//	GET    /v1/federation/keys (public)
+//	GET    /v1/replication/changes[?after=R] (when Config.Replication is set)
+//	POST   /v1/replication/changes
+//	POST   /v1/crdt/sync (when Config.CRDT is set)
+//	GET    /v1/shards (when Config.Shards is set)
+//	POST   /v1/shards
+//	GET    /v1/admin/agents[?colony=C]
+//	DELETE /v1/admin/agents/{id}
+//	POST   /v1/admin/agents/{id}/expire
+//	GET    /v1/admin/quotas
+//	GET    /v1/admin/quotas/{colony}
+//	PUT    /v1/admin/quotas/{colony}
+//	DELETE /v1/admin/quotas/{colony}
+//	PUT    /v1/admin/schemas/{colony}
+//	DELETE /v1/admin/schemas/{colony}
+//	POST   /v1/admin/keys/rotate (when Config.Keys and Config.KeysReef are set)
+//	GET    /v1/admin/revocations (when Config.Revocations is set)
+//	GET    /v1/admin/enrollments[?status=pending] (when Config.Enrollment is set)
+//	POST   /v1/admin/enrollments/{id}/approve
+//	POST   /v1/admin/enrollments/{id}/deny
+//	GET    /v1/history[?since=T][&until=T][&colony=C][&limit=500&cursor=C] (when Config.History is set)
+//	GET    /v1/history/topology?at=T[&colony=C]
+//	GET    /v1/admin/webhooks/dead-letters (when Config.Webhooks is set)
+//	POST   /v1/admin/webhooks/dead-letters/{id}/redeliver
+//	DELETE /v1/admin/webhooks/dead-letters/{id}
+//
+// Every request except DID documents, enrollment status, mesh CA roots
+// and renewals, token exchanges, the federation routes and replication
+// must carry "Authorization: Bearer <referral ticket>"; peers call the
+// federation routes with federation tokens and replicas pull changes and
+// sync deltas with their shared secret. Registrations may present a
+// JWT-SVID instead when the verifier accepts them. Enrollments are
+// submitted with tickets carrying the enrollment.IntentEnroll intent,
+// and identities reissued with the identity ticket itself. Mesh
+// certificates are renewed with the current certificate and a signature
+// by its key, and may stand in for tickets over mTLS (see
+// Config.PeerRoots), as may API keys (see Config.APIKeys). The admin
+// and history routes take tickets with the rbac.IntentAdmin intent and a
+// role granting the route's permission; they act on the ticket's reef.
+// Requests over a Config.Limiter budget are answered with 429 and a
+// Retry-After header.
+type Server struct {
+	registry      *registry.Registry
+	authenticator auth.Authenticator
+	revocations   *revocation.List
+	pop           *pop.Checker
+	enrollment    *enrollment.Enroller
+	meshCA        *meshca.CA
+	peer          auth.Authenticator // nil without Config.PeerRoots
+	threshold     *threshold.Coordinator
+	did           *did.Resolver
+	spiffe        *spiffe.Adapter
+	exchange      *oidc.Exchanger
+	rendezvous    *rendezvous.Broker
+	elections     *election.Elections
+	locks         *lock.Locks
+	traversal     *traversal.Coordinator
+	relay         *relay.Broker
+	federation    *federation.Federation
+	replication   *replication.Replicator
+	crdt          *crdt.Replica
+	shards        *shard.Router
+	trustProxy    bool
+	metrics       instruments
+	tracer        *tracing.Tracer
+	audit         *audit.Log
+	rbac          *rbac.Policy
+	keys          *jwks.Set
+	keysReef      string
+	webhooks      *webhook.Dispatcher
+	history       *history.Archive
+	limiter       *ratelimit.Limiter
+	reads         *readcache.Cache
+	mux           *http.ServeMux
+}
+
+// New creates a Server from cfg.
+func New(cfg Config) *Server {
+	s := &Server{
+		registry:    cfg.Registry,
+		revocations: cfg.Revocations,
+		pop:         cfg.PoP,
+		enrollment:  cfg.Enrollment,
+		meshCA:      cfg.MeshCA,
+		threshold:   cfg.Threshold,
+		did:         cfg.DID,
+		spiffe:      cfg.SPIFFE,
+		exchange:    cfg.Exchange,
+		rendezvous:  cfg.Rendezvous,
+		elections:   cfg.Elections,
+		locks:       cfg.Locks,
+		traversal:   cfg.Traversal,
+		relay:       cfg.Relay,
+		federation:  cfg.Federation,
+		replication: cfg.Replication,
+		crdt:        cfg.CRDT,
+		shards:      cfg.Shards,
+		trustProxy:  cfg.TrustProxy,
+		metrics:     newInstruments(cfg.Metrics),
+		tracer:      cfg.Tracer,
+		audit:       cfg.Audit,
+		rbac:        cfg.RBAC,
+		keys:        cfg.Keys,
+		keysReef:    cfg.KeysReef,
+		webhooks:    cfg.Webhooks,
+		history:     cfg.History,
+		limiter:     cfg.Limiter,
+		reads:       cfg.ReadCache,
+		mux:         http.NewServeMux(),
+	}
+
+	chain := auth.Chain{auth.Tickets(cfg.Verifier, bearerToken)}
+	if cfg.SPIFFE != nil {
+		chain = append(chain, cfg.SPIFFE.Authenticator())
+	}
+	if cfg.PeerRoots != nil {
+		s.peer = meshca.Authenticator(cfg.PeerRoots, nil)
+		chain = append(chain, auth.AuthenticatorFunc(peerPrincipal))
+	}
+	if cfg.APIKeys != nil {
+		chain = append(chain, cfg.APIKeys)
+	}
+	s.authenticator = chain
+	if cfg.Policy != nil {
+		s.authenticator = cfg.Policy.Authenticator(chain)
+	}
+
+	s.mux.HandleFunc("POST /v1/register", s.handleRegister)
+	s.mux.HandleFunc("GET /v1/agents/{id}", s.handleLookupAgent)
+	s.mux.HandleFunc("DELETE /v1/agents/{id}", s.handleDeregister)
+	s.mux.HandleFunc("POST /v1/agents/{id}/renew", s.handleRenew)
+	s.mux.HandleFunc("GET /v1/colonies/{id}/agents", s.handleListColony)
+	s.mux.HandleFunc("GET /v1/colonies/{id}/digest", s.handleColonyDigest)
+	s.mux.HandleFunc("GET /v1/colonies/{id}/allowlist", s.handleAllowlist)
+	s.mux.HandleFunc("PUT /v1/colonies/{id}/allowlist", s.handlePublishAllowlist)
+	s.mux.HandleFunc("GET /v1/colonies/{id}/schema", s.handleColonySchema)
+	s.mux.HandleFunc("GET /v1/topics/{topic}/agents", s.handleTopicAgents)
+	s.mux.HandleFunc("GET /v1/services/{service}/agents", s.handleServiceAgents)
+	s.mux.HandleFunc("GET /v1/watch", s.handleWatch)
+	s.mux.HandleFunc("GET /v1/changes", s.handleChanges)
+	s.mux.HandleFunc("GET /v1/ws", s.handleWS)
+	s.mux.HandleFunc("GET /v1/snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("PUT /v1/snapshot", s.handleRestore)
+	s.mux.HandleFunc("GET /v1/admin/agents", s.handleAdminAgents)
+	s.mux.HandleFunc("DELETE /v1/admin/agents/{id}", s.handleAdminEvict)
+	s.mux.HandleFunc("POST /v1/admin/agents/{id}/expire", s.handleAdminExpire)
+	s.mux.HandleFunc("GET /v1/admin/quotas", s.handleAdminQuotas)
+	s.mux.HandleFunc("GET /v1/admin/quotas/{colony}", s.handleAdminQuota)
+	s.mux.HandleFunc("PUT /v1/admin/quotas/{colony}", s.handleAdminSetQuota)
+	s.mux.HandleFunc("DELETE /v1/admin/quotas/{colony}", s.handleAdminResetQuota)
+	s.mux.HandleFunc("PUT /v1/admin/schemas/{colony}", s.handleAdminSetSchema)
+	s.mux.HandleFunc("DELETE /v1/admin/schemas/{colony}", s.handleAdminDeleteSchema)
+	if s.keys != nil && s.keysReef != "" {
+		s.mux.HandleFunc("POST /v1/admin/keys/rotate", s.handleAdminRotateKeys)
+	}
+	if s.webhooks != nil {
+		s.mux.HandleFunc("GET /v1/admin/webhooks/dead-letters", s.handleAdminDeadLetters)
+		s.mux.HandleFunc("POST /v1/admin/webhooks/dead-letters/{id}/redeliver", s.handleAdminRedeliver)
+		s.mux.HandleFunc("DELETE /v1/admin/webhooks/dead-letters/{id}", s.handleAdminDiscard)
+	}
+	if s.history != nil {
+		s.mux.HandleFunc("GET /v1/history", s.handleHistory)
+		s.mux.HandleFunc("GET /v1/history/topology", s.handleTopology)
+	}
+	if s.revocations != nil {
+		s.mux.HandleFunc("POST /v1/revocations", s.handleRevoke)
+		s.mux.HandleFunc("GET /v1/revocations", s.handleRevocations)
+		s.mux.HandleFunc("GET /v1/admin/revocations", s.handleAdminRevocations)
+	}
+	if s.pop != nil {
+		s.mux.HandleFunc("GET /v1/pop/nonce", s.handleNonce)
+	}
+	if s.enrollment != nil {
+		s.mux.HandleFunc("POST /v1/enrollments", s.handleEnroll)
+		s.mux.HandleFunc("GET /v1/enrollments/{id}", s.handleEnrollment)
+		s.mux.HandleFunc("POST /v1/enrollments/{id}/identity", s.handleReissueIdentity)
+		s.mux.HandleFunc("GET /v1/admin/enrollments", s.handleAdminEnrollments)
+		s.mux.HandleFunc("POST /v1/admin/enrollments/{id}/approve", s.handleAdminApprove)
+		s.mux.HandleFunc("POST /v1/admin/enrollments/{id}/deny", s.handleAdminDeny)
+	}
+	if s.meshCA != nil {
+		s.mux.HandleFunc("POST /v1/mesh/certificates", s.handleIssueCertificate)
+		s.mux.HandleFunc("GET /v1/mesh/ca", s.handleMeshRoots)
+		s.mux.HandleFunc("POST /v1/mesh/orders", s.handleNewOrder)
+		s.mux.HandleFunc("GET /v1/mesh/orders/{id}", s.handleOrder)
+		s.mux.HandleFunc("POST /v1/mesh/orders/{id}/challenge", s.handleOrderChallenge)
+		s.mux.HandleFunc("POST /v1/mesh/orders/{id}/finalize", s.handleFinalizeOrder)
+	}
+	if s.did != nil {
+		s.mux.HandleFunc("GET /v1/agents/{id}/did.json", s.handleAgentDID)
+	}
+	if s.exchange != nil {
+		s.mux.HandleFunc("POST /v1/token", s.handleTokenExchange)
+	}
+	if s.rendezvous != nil {
+		s.mux.HandleFunc("POST /v1/rendezvous/signals", s.handleSendSignal)
+		s.mux.HandleFunc("GET /v1/rendezvous/signals", s.handleReceiveSignals)
+	}
+	if s.elections != nil {
+		s.mux.HandleFunc("GET /v1/elections/{name}", s.handleLeader)
+		s.mux.HandleFunc("POST /v1/elections/{name}/campaign", s.handleCampaign)
+		s.mux.HandleFunc("POST /v1/elections/{name}/renew", s.handleRenewTerm)
+		s.mux.HandleFunc("POST /v1/elections/{name}/resign", s.handleResign)
+	}
+	if s.locks != nil {
+		s.mux.HandleFunc("GET /v1/locks/{name}", s.handleLock)
+		s.mux.HandleFunc("POST /v1/locks/{name}/acquire", s.handleAcquire)
+		s.mux.HandleFunc("POST /v1/locks/{name}/renew", s.handleRenewLease)
+		s.mux.HandleFunc("POST /v1/locks/{name}/release", s.handleRelease)
+	}
+	if s.traversal != nil {
+		s.mux.HandleFunc("POST /v1/traversal/attempts", s.handleInitiateTraversal)
+		s.mux.HandleFunc("GET /v1/traversal/attempts", s.handlePendingTraversals)
+		s.mux.HandleFunc("GET /v1/traversal/attempts/{id}", s.handleAwaitTraversal)
+		s.mux.HandleFunc("POST /v1/traversal/attempts/{id}/accept", s.handleAcceptTraversal)
+	}
+	if s.relay != nil {
+		s.mux.HandleFunc("POST /v1/relay/reservations", s.handleReserveRelay)
+		s.mux.HandleFunc("GET /v1/relay/reservations", s.handleRelayReservations)
+	}
+	if s.federation != nil {
+		s.mux.HandleFunc("GET "+federation.AgentsPath+"{id}", s.handleFederatedLookup)
+		s.mux.HandleFunc("GET "+federation.KeysPath, s.handleFederationKeys)
+	}
+	if s.replication != nil {
+		s.mux.HandleFunc("GET "+replication.Path, s.handleReplicationChanges)
+		s.mux.HandleFunc("POST "+replication.Path, s.handleReplicationPush)
+	}
+	if s.crdt != nil {
+		s.mux.HandleFunc("POST "+crdt.SyncPath, s.handleCRDTSync)
+	}
+	if s.shards != nil {
+		s.mux.HandleFunc("GET "+shard.Path, s.handleShardMap)
+		s.mux.HandleFunc("POST "+shard.Path, s.handleShardMap)
+	}
+	if s.threshold != nil {
+		s.mux.HandleFunc("POST /v1/threshold/sessions", s.handleThresholdStart)
+		s.mux.HandleFunc("GET /v1/threshold/sessions/{id}", s.handleThresholdSession)
+		s.mux.HandleFunc("POST /v1/threshold/sessions/{id}/commitments", s.handleThresholdCommit)
+		s.mux.HandleFunc("POST /v1/threshold/sessions/{id}/shares", s.handleThresholdShare)
+	}
+
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.instrument(w, r, s.serve)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.shard(w, r, func(w http.ResponseWriter, r *http.Request) {
+		s.limit(w, r, s.route)
+	})
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.checkPeer(w, r)
+	if !ok {
+		return
+	}
+	if !isFederationRoute(r) && !isReplicationRoute(r) && !isShardRoute(r) && (!s.checkProof(w, r) || !s.checkSVID(w, r)) {
+		return
+	}
+	if !negotiate(w, r) {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var rec registry.Record
+	if !decodeBody(w, r, &rec) {
+		return
+	}
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	rec.ObservedEndpoints = registry.ObservedEndpoints(rec.Endpoints, s.remoteAddr(r))
+	rec.Location = rec.Location.Merge(s.locationHint(r))
+
+	stored, err := s.registry.Register(auth.NewContext(r.Context(), p), p.Ticket, &rec)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stored)
+}
+
+func (s *Server) handleLookupAgent(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if reef := r.URL.Query().Get("reef"); reef != "" && reef != p.ReefID {
+		// Only the federation's own reef reaches the reefs it federates
+		// with; reefs served as tenants only ever read their own.
+		if s.federation == nil || p.ReefID != s.federation.Reef() {
+			writeError(w, http.StatusForbidden, "permission_denied", "ticket of reef "+p.ReefID+" cannot query reef "+reef)
+			return
+		}
+		rec, err := s.federation.Lookup(r.Context(), reef, r.PathValue("id"))
+		if errors.Is(err, federation.ErrUnknownReef) {
+			writeError(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		if err != nil {
+			writeFederationError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+		return
+	}
+	level, ok := consistencyParam(w, r)
+	if !ok {
+		return
+	}
+	rec, age, err := s.lookup(r.Context(), level, p.ReefID, r.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	setAge(w, age)
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if err := s.registry.Deregister(auth.NewContext(r.Context(), p), p.Ticket, r.PathValue("id")); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renewRequest is the optional body of POST /v1/agents/{id}/renew.
+type renewRequest struct {
+	Load *registry.Load `json:"load,omitempty"`
+
+	// Seq, when set, renews only a record still at it (see
+	// registry.Record.Seq).
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	var req renewRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	rec, err := s.registry.HeartbeatSeq(auth.NewContext(r.Context(), p), p.Ticket, r.PathValue("id"), req.Seq, req.Load)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (s *Server) handleListColony(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sel, err := registry.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	pick, ok := s.selection(w, r, p.ReferralClaims)
+	if !ok {
+		return
+	}
+	level, ok := consistencyParam(w, r)
+	if !ok {
+		return
+	}
+	colony := r.PathValue("id")
+	if pick != nil {
+		if r.URL.Query().Get("cursor") != "" {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "cursor does not apply to nearest, least_loaded or weighted")
+			return
+		}
+		recs, age, err := s.query(r.Context(), level, p.ReefID, colony, r.URL.Query().Get("selector"), sel)
+		if err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		setAge(w, age)
+		writeJSON(w, http.StatusOK, &registry.Page{Records: pick(recs)})
+		return
+	}
+
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	recs, age, err := s.query(r.Context(), level, p.ReefID, colony, r.URL.Query().Get("selector"), sel)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	page, err := registry.Paginate(colony, recs, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	setAge(w, age)
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleColonyDigest summarizes a colony's membership, so that callers
+// holding a listing can skip downloading it again when it is current.
+func (s *Server) handleColonyDigest(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	level, ok := consistencyParam(w, r)
+	if !ok {
+		return
+	}
+	colony := r.PathValue("id")
+	recs, age, err := s.query(r.Context(), level, p.ReefID, colony, "", nil)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	setAge(w, age)
+	writeJSON(w, http.StatusOK, registry.NewDigest(colony, recs))
+}
+
+// limitParam parses the optional limit parameter, a page size. It writes
+// the error response when the parameter is invalid.
+func limitParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid limit: "+v)
+		return 0, false
+	}
+	return n, true
+}
+
+// handleTopicAgents lists the agents of the caller's reef that publish
+// or subscribe to a topic.
+func (s *Server) handleTopicAgents(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	role, err := registry.ParseTopicRole(q.Get("role"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	recs, err := s.registry.ByTopic(r.Context(), p.ReefID, q.Get("colony"), r.PathValue("topic"), role)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &registry.Page{Records: recs})
+}
+
+// handleServiceAgents lists the agents of the caller's reef running a
+// service at a compatible version, steering the canary share of callers
+// to the newest one during a rollout.
+func (s *Server) handleServiceAgents(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	query := registry.ServiceQuery{
+		Service:  r.PathValue("service"),
+		ColonyID: q.Get("colony"),
+		Version:  q.Get("version"),
+		Key:      p.AgentID,
+	}
+	if v := q.Get("stable"); v != "" {
+		stable, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "invalid stable: "+v)
+			return
+		}
+		query.Stable = stable
+	}
+	if v := q.Get("canary"); v != "" {
+		canary, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "invalid canary: "+v)
+			return
+		}
+		query.Canary = canary
+	}
+	recs, err := s.registry.ByService(r.Context(), p.ReefID, query)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &registry.Page{Records: recs})
+}
+
+// watchFilter builds the filter of p's watch stream from the colony_id,
+// reef_id, capability, selector, topic and topic_role query parameters,
+// writing a 400 when the selector or role does not parse. Streams only
+// carry p's reef: a reef_id naming another is refused with a 403.
+func watchFilter(w http.ResponseWriter, r *http.Request, p *auth.Principal) (registry.Filter, bool) {
+	q := r.URL.Query()
+	if reef := q.Get("reef_id"); reef != "" && reef != p.ReefID {
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket of reef "+p.ReefID+" cannot watch reef "+reef)
+		return registry.Filter{}, false
+	}
+	sel, err := registry.ParseSelector(q.Get("selector"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return registry.Filter{}, false
+	}
+	role, err := registry.ParseTopicRole(q.Get("topic_role"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return registry.Filter{}, false
+	}
+	return registry.Filter{
+		ColonyID:   q.Get("colony_id"),
+		ReefID:     p.ReefID,
+		Capability: q.Get("capability"),
+		Selector:   sel,
+		Topic:      q.Get("topic"),
+		TopicRole:  role,
+	}, true
+}
+
+// authenticate authenticates the caller through the authentication
+// chain, writing a 401 on failure. Callers who also presented a client
+// certificate must name its agent.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	_, span := s.tracer.Start(r.Context(), tracing.Internal, "authenticate")
+	p, err := s.authenticator.Authenticate(r)
+	span.SetError(err)
+	span.End()
+	if errors.Is(err, auth.ErrNoCredentials) {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "referral ticket is required")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return nil, false
+	}
+	if peer, _ := peerPrincipal(r); peer != nil && !peer.Same(p) {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "request does not name the agent of the client certificate")
+		return nil, false
+	}
+	if routeClasses[r.Pattern] == ratelimit.Lookup {
+		if err := s.limiter.Allow(ratelimit.Lookup, ratelimit.Key{AgentID: p.AgentID, ColonyID: p.ColonyID, ReefID: p.ReefID}); err != nil {
+			writeLimited(w, err)
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header,
+// or from the subprotocols of a WebSocket upgrade without one.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if auth == "" && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return protocolTicket(r)
+	}
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// writeRegistryError maps registry errors onto HTTP status codes.
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, registry.ErrLeaseExpired):
+		writeError(w, http.StatusPreconditionFailed, "failed_precondition", err.Error())
+	case errors.Is(err, registry.ErrStaleRecord):
+		writeError(w, http.StatusConflict, "stale_record", err.Error())
+	case errors.Is(err, ratelimit.ErrLimited):
+		writeLimited(w, err)
+	case errors.Is(err, registry.ErrQuotaExceeded):
+		writeQuotaExceeded(w, err)
+	case errors.Is(err, registry.ErrDraining):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+	case errors.Is(err, rbac.ErrForbidden):
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+	case errors.Is(err, allowlist.ErrNotAllowed):
+		writeError(w, http.StatusForbidden, "endpoint_not_allowed", err.Error())
+	case errors.Is(err, registry.ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+	case errors.Is(err, schema.ErrMismatch):
+		writeError(w, http.StatusBadRequest, "invalid_metadata", err.Error())
+	case errors.Is(err, registry.ErrInvalidRecord), errors.Is(err, registry.ErrInvalidSelector), errors.Is(err, registry.ErrInvalidCursor), errors.Is(err, registry.ErrInvalidTopic), errors.Is(err, registry.ErrInvalidService), errors.Is(err, allowlist.ErrInvalid), errors.Is(err, schema.ErrInvalid):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+// writeError writes a Connect-style { code, message } error body.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{
+		"code":    code,
+		"message": message,
+	})
+}
+
+// writeJSON writes v in the schema version negotiated for w.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(wire.Encode(v, responseVersion(w)))
+}
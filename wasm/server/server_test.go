@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+)
+
+// ticketKey signs the tickets of the tests with HS256.
+var ticketKey = []byte("test ticket key")
+
+// tickets verifies the tickets minted by ticket.
+type tickets struct{}
+
+func (tickets) ValidateReferralTicket(token string) (*jwt.ReferralClaims, error) {
+	var claims rbac.Claims
+	_, err := gojwt.ParseWithClaims(token, &claims, func(*gojwt.Token) (any, error) { return ticketKey, nil },
+		gojwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		return nil, err
+	}
+	return &claims.ReferralClaims, nil
+}
+
+// ticket mints a ticket for agentID of colony "colony" in reefID.
+func ticket(t *testing.T, reefID, agentID, intent string, roles ...string) string {
+	t.Helper()
+	claims := rbac.Claims{
+		ReferralClaims: jwt.ReferralClaims{ReefID: reefID, ColonyID: "colony", AgentID: agentID, Intent: intent},
+		Roles:          roles,
+	}
+	token, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, claims).SignedString(ticketKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// admin mints an admin ticket of reefID with roles.
+func admin(t *testing.T, reefID string, roles ...string) string {
+	t.Helper()
+	return ticket(t, reefID, "operator", rbac.IntentAdmin, roles...)
+}
+
+// call serves a request with body, JSON-encoded unless nil, under token,
+// and decodes the response into out unless nil.
+func call(t *testing.T, h http.Handler, method, path, token string, body, out any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
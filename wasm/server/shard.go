@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/shard"
+)
+
+// maxShardMapSize bounds the maps other shards post.
+const maxShardMapSize = 1 << 20
+
+// isShardRoute reports whether r is for the route shards exchange maps
+// at, which carries the shards' secret rather than a referral ticket.
+func isShardRoute(r *http.Request) bool {
+	return r.URL.Path == shard.Path
+}
+
+// shard serves r with next when this instance owns the colony r is for,
+// and forwards it to the owning shard otherwise. Requests another shard
+// forwarded are served here, as made by the client the shard vouches
+// for, and never forwarded again.
+func (s *Server) shard(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if s.shards == nil {
+		next(w, r)
+		return
+	}
+	if client := r.Header.Get(shard.ClientHeader); client != "" {
+		if err := s.shards.Authenticate(r.Header.Get(shard.SecretHeader)); err != nil {
+			writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+			return
+		}
+		r = r.Clone(r.Context())
+		r.RemoteAddr = client
+		r.Header.Del(shard.ClientHeader)
+		r.Header.Del(shard.SecretHeader)
+		r.Header.Del("X-Forwarded-For")
+		next(w, r)
+		return
+	}
+	colony := s.shardColony(r)
+	if colony == "" {
+		next(w, r)
+		return
+	}
+	owner, local := s.shards.Owner(colony)
+	if local {
+		next(w, r)
+		return
+	}
+	s.forward(w, r, owner)
+}
+
+// shardColony returns the colony r is routed by, or "" for requests any
+// shard serves: the {id} of colony routes, the colony_id filter of
+// watches and changes or the colony parameter of lookups, or else the
+// colony of the caller's ticket. The ticket is read without verifying
+// it, which the owning shard does.
+func (s *Server) shardColony(r *http.Request) string {
+	_, route := s.mux.Handler(r)
+	switch route {
+	case "GET /v1/colonies/{id}/agents", "GET /v1/colonies/{id}/digest":
+		id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/colonies/"), "/")
+		return id
+	case "GET /v1/watch", "GET /v1/ws", "GET /v1/changes":
+		if colony := r.URL.Query().Get("colony_id"); colony != "" {
+			return colony
+		}
+	case "GET /v1/agents/{id}":
+		if colony := r.URL.Query().Get("colony"); colony != "" {
+			return colony
+		}
+	case "POST /v1/register", "DELETE /v1/agents/{id}", "POST /v1/agents/{id}/renew",
+		"GET /v1/elections/{name}", "POST /v1/elections/{name}/campaign", "POST /v1/elections/{name}/renew", "POST /v1/elections/{name}/resign",
+		"GET /v1/locks/{name}", "POST /v1/locks/{name}/acquire", "POST /v1/locks/{name}/renew", "POST /v1/locks/{name}/release":
+	default:
+		return ""
+	}
+	var claims jwt.ReferralClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(bearerToken(r), &claims); err != nil {
+		return ""
+	}
+	return claims.ColonyID
+}
+
+// forward proxies r to owner. The Host header is kept, as proofs of
+// possession name the URL the client called, and streams are flushed as
+// they are written.
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, owner shard.Shard) {
+	target, err := url.Parse(owner.URL)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "unavailable", "shard "+owner.ID+": "+err.Error())
+		return
+	}
+	client := "unknown"
+	if addr := s.remoteAddr(r); addr.IsValid() {
+		client = addr.String()
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+			s.shards.SetForwarded(pr.Out, client)
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			writeError(w, http.StatusBadGateway, "unavailable", "shard "+owner.ID+": "+err.Error())
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// handleShardMap answers another shard with the current map, after
+// adopting the map it posted when that is newer.
+func (s *Server) handleShardMap(w http.ResponseWriter, r *http.Request) {
+	if err := s.shards.Authenticate(bearerToken(r)); err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
+	}
+	if r.Method == http.MethodPost {
+		var m shard.Map
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxShardMapSize)).Decode(&m); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "invalid shard map: "+err.Error())
+			return
+		}
+		if _, err := s.shards.Update(m); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.shards.Map())
+}
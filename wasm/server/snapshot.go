@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// maxSnapshotSize bounds the registry snapshots operators restore.
+const maxSnapshotSize = 256 << 20
+
+// restoreResponse is the body answering PUT /v1/snapshot.
+type restoreResponse struct {
+	Restored int       `json:"restored"`
+	TakenAt  time.Time `json:"taken_at"`
+}
+
+// handleSnapshot serves a snapshot of the caller's reef in its binary
+// encoding. The caller's ticket must carry the registry.IntentBackup
+// intent and a role granting rbac.PermBackupRegistry.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.Intent != registry.IntentBackup {
+		s.record(r, audit.AdminBackup, p.ReferralClaims, "", "ticket intent must be "+registry.IntentBackup)
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+registry.IntentBackup)
+		return
+	}
+	if err := s.rbac.AuthorizeRoles(p.Roles, rbac.PermBackupRegistry); err != nil {
+		s.record(r, audit.AdminBackup, p.ReferralClaims, "", err.Error())
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+		return
+	}
+
+	// Buffer the snapshot so a failed store read is still reported as
+	// an error rather than a truncated body.
+	var buf bytes.Buffer
+	if err := s.registry.Snapshot(r.Context(), p.ReefID, &buf); err != nil {
+		s.record(r, audit.AdminBackup, p.ReferralClaims, "", err.Error())
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminBackup, p.ReferralClaims, "", "")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// handleRestore replaces the state of the caller's reef with the
+// snapshot in the request body, which must hold only that reef. The
+// caller's ticket must carry the registry.IntentRestore intent and a role
+// granting rbac.PermRestoreRegistry.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.Intent != registry.IntentRestore {
+		s.record(r, audit.AdminRestore, p.ReferralClaims, "", "ticket intent must be "+registry.IntentRestore)
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+registry.IntentRestore)
+		return
+	}
+	if err := s.rbac.AuthorizeRoles(p.Roles, rbac.PermRestoreRegistry); err != nil {
+		s.record(r, audit.AdminRestore, p.ReferralClaims, "", err.Error())
+		writeError(w, http.StatusForbidden, "permission_denied", err.Error())
+		return
+	}
+
+	takenAt, n, err := s.registry.Restore(r.Context(), p.ReefID, http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err != nil {
+		s.record(r, audit.AdminRestore, p.ReferralClaims, "", err.Error())
+		if errors.Is(err, registry.ErrMalformedSnapshot) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+		writeRegistryError(w, err)
+		return
+	}
+	s.record(r, audit.AdminRestore, p.ReferralClaims, "snapshot taken "+takenAt.Format(time.RFC3339), "")
+	writeJSON(w, http.StatusOK, restoreResponse{Restored: n, TakenAt: takenAt})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/spiffe"
+)
+
+// checkSVID enforces the SPIFFE binding of a ticketed request, writing a
+// 401 on failure. The ticket itself is verified by the handler.
+func (s *Server) checkSVID(w http.ResponseWriter, r *http.Request) bool {
+	token := bearerToken(r)
+	if s.spiffe == nil || token == "" {
+		return true
+	}
+	if err := s.spiffe.CheckBinding(token, r.Header.Get(spiffe.Header)); err != nil {
+		s.metrics.reject(err, "svid")
+		writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return false
+	}
+	return true
+}
+
+// checkSVID enforces the SPIFFE binding using the coral-svid metadata key.
+func (s *GRPCServer) checkSVID(ctx context.Context) error {
+	token := metadataToken(ctx)
+	if s.spiffe == nil || token == "" {
+		return nil
+	}
+
+	var svid string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(spiffe.Header)); len(v) > 0 {
+			svid = v[0]
+		}
+	}
+	if err := s.spiffe.CheckBinding(token, svid); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment line so
+// intermediaries do not time the connection out.
+const sseKeepAlive = 30 * time.Second
+
+// handleWatch streams registry changes as Server-Sent Events. Each event
+// is named after its type ("put" or "delete"), carries the record as
+// JSON in the negotiated schema version and has the registry cursor as
+// its ID. A "drain" event ends the stream when the server shuts down; the
+// client should resume with another instance. Filters are taken from the colony_id, reef_id, capability,
+// selector, topic and topic_role query parameters. A reconnecting client passes the last ID it saw as the
+// Last-Event-ID header or cursor parameter to receive only the changes it
+// missed, or 410 resync_required when they are no longer kept.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal", "streaming is not supported")
+		return
+	}
+
+	filter, ok := watchFilter(w, r, p)
+	if !ok {
+		return
+	}
+	events, ok := s.watch(w, r, p, filter)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-events:
+			if !ok {
+				// Subscriber fell behind; tell the client to resync.
+				fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			if ev.Type == registry.EventDrain {
+				fmt.Fprint(w, "event: drain\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			data, err := wire.Marshal(ev.Record, responseVersion(w))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.Cursor, ev.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// watch subscribes p to the changes matching filter, after the cursor of
+// the Last-Event-ID header or cursor parameter when given. It writes the
+// error response when the cursor's changes are no longer kept or p's
+// colony holds its quota of watch streams.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request, p *auth.Principal, filter registry.Filter) (<-chan registry.Event, bool) {
+	cursor := r.URL.Query().Get("cursor")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		cursor = id
+	}
+	events, err := s.registry.WatchFrom(auth.NewContext(r.Context(), p), filter, cursor)
+	if errors.Is(err, registry.ErrCursorExpired) {
+		writeError(w, http.StatusGone, "resync_required", err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeRegistryError(w, err)
+		return nil, false
+	}
+	return events, true
+}
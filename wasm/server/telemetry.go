@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/metrics"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+)
+
+// instruments are the server's metrics; nil without Config.Metrics.
+type instruments struct {
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+	rejected *metrics.Counter
+}
+
+func newInstruments(set *metrics.Set) instruments {
+	return instruments{
+		requests: set.Counter("coral_http_requests_total",
+			"HTTP requests served, by route and status code.", "route", "code"),
+		latency: set.Histogram("coral_http_request_duration_seconds",
+			"Latency of HTTP requests, by route. Streamed responses are left out.", nil, "route"),
+		rejected: set.TicketFailures(),
+	}
+}
+
+// reject counts a ticket the server rejected before verifying it, such as
+// one without a valid proof of possession.
+func (m instruments) reject(err error, fallback string) {
+	reason := metrics.FailureReason(err)
+	if reason == "other" {
+		reason = fallback
+	}
+	m.rejected.Inc(reason)
+}
+
+// instrument serves r with next in a server span continuing the caller's
+// trace, counting it under its route pattern.
+func (s *Server) instrument(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if s.metrics.requests == nil && s.tracer == nil {
+		next(w, r)
+		return
+	}
+	_, route := s.mux.Handler(r)
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, span := s.tracer.Start(tracing.Extract(r.Context(), r.Header), tracing.Server, route,
+		tracing.String("http.request.method", r.Method),
+		tracing.String("http.route", route),
+		tracing.String("url.path", r.URL.Path),
+	)
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next(sw, r.WithContext(ctx))
+
+	span.SetAttributes(tracing.Int("http.response.status_code", sw.status))
+	if sw.status >= 500 {
+		span.SetError(errors.New(http.StatusText(sw.status)))
+	}
+	span.End()
+	s.metrics.requests.Inc(route, strconv.Itoa(sw.status))
+	if !sw.streamed {
+		s.metrics.latency.Since(start, route)
+	}
+}
+
+// statusWriter records the status of a response and whether it was
+// streamed, passing flushes and hijacks through.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	wrote    bool
+	streamed bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	w.streamed = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("server: response does not support hijacking")
+	}
+	w.streamed, w.status = true, http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// GRPCOptions returns the grpc.Server options recording a server span
+// continuing the caller's trace for every call, when t is not nil.
+func GRPCOptions(t *tracing.Tracer) []grpc.ServerOption {
+	if t == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, span := startRPC(ctx, t, info.FullMethod)
+			resp, err := handler(ctx, req)
+			endRPC(span, err)
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, span := startRPC(ss.Context(), t, info.FullMethod)
+			err := handler(srv, &tracedStream{ServerStream: ss, ctx: ctx})
+			endRPC(span, err)
+			return err
+		}),
+	}
+}
+
+// startRPC starts the server span of a call to method, the child of the
+// span in the call's traceparent metadata.
+func startRPC(ctx context.Context, t *tracing.Tracer, method string) (context.Context, *tracing.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(tracing.TraceparentHeader); len(v) > 0 {
+			if sc, err := tracing.ParseTraceparent(v[0]); err == nil {
+				ctx = tracing.ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+	return t.Start(ctx, tracing.Server, method,
+		tracing.String("rpc.system", "grpc"),
+		tracing.String("rpc.method", method),
+	)
+}
+
+// endRPC ends the span of a call that returned err. As with HTTP 5xx
+// responses, only server faults mark the span failed.
+func endRPC(span *tracing.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(tracing.Int("rpc.grpc.status_code", int(code)))
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded, codes.Unimplemented:
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// tracedStream carries the server span in the stream's context.
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context { return s.ctx }
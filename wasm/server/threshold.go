@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// shareRequest is the body of POST /v1/threshold/sessions/{id}/shares.
+type shareRequest struct {
+	Identifier uint16 `json:"identifier"`
+	Share      string `json:"share"`
+}
+
+// authorizeOperator authenticates a threshold operator and returns the
+// identifier of its share. The ticket must carry the
+// threshold.IntentOperate intent and name the agent holding the share.
+// Operators act on the sessions of their ticket's reef.
+func (s *Server) authorizeOperator(w http.ResponseWriter, r *http.Request) (*auth.Principal, uint16, bool) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+	if p.Intent != threshold.IntentOperate {
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket intent must be "+threshold.IntentOperate)
+		return nil, 0, false
+	}
+	id, ok := s.threshold.Operator(p.AgentID)
+	if !ok {
+		writeError(w, http.StatusForbidden, "permission_denied", "agent "+p.AgentID+" holds no share of the threshold key")
+		return nil, 0, false
+	}
+	return p, id, true
+}
+
+// checkIdentifier reports whether a request body naming identifier, when
+// it names one, comes from the operator holding share id.
+func checkIdentifier(w http.ResponseWriter, identifier, id uint16) bool {
+	if identifier != 0 && identifier != id {
+		writeError(w, http.StatusForbidden, "permission_denied", fmt.Sprintf("ticket holds share %d, not %d", id, identifier))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleThresholdStart(w http.ResponseWriter, r *http.Request) {
+	p, _, ok := s.authorizeOperator(w, r)
+	if !ok {
+		return
+	}
+	var req threshold.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if req.ReefID != p.ReefID {
+		writeError(w, http.StatusForbidden, "permission_denied", "ticket of reef "+p.ReefID+" cannot mint tickets of reef "+req.ReefID)
+		return
+	}
+
+	session, err := s.threshold.Start(r.Context(), &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+func (s *Server) handleThresholdSession(w http.ResponseWriter, r *http.Request) {
+	p, _, ok := s.authorizeOperator(w, r)
+	if !ok {
+		return
+	}
+	session, err := s.threshold.Session(r.Context(), p.ReefID, r.PathValue("id"))
+	if err != nil {
+		writeThresholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleThresholdCommit(w http.ResponseWriter, r *http.Request) {
+	p, id, ok := s.authorizeOperator(w, r)
+	if !ok {
+		return
+	}
+	var com threshold.Commitment
+	if err := json.NewDecoder(r.Body).Decode(&com); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if !checkIdentifier(w, com.Identifier, id) {
+		return
+	}
+	com.Identifier = id
+
+	session, err := s.threshold.Commit(r.Context(), p.ReefID, r.PathValue("id"), com)
+	if err != nil {
+		writeThresholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleThresholdShare(w http.ResponseWriter, r *http.Request) {
+	p, id, ok := s.authorizeOperator(w, r)
+	if !ok {
+		return
+	}
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+	if !checkIdentifier(w, req.Identifier, id) {
+		return
+	}
+
+	session, err := s.threshold.SubmitShare(r.Context(), p.ReefID, r.PathValue("id"), id, req.Share)
+	if err != nil {
+		writeThresholdError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// writeThresholdError maps threshold errors onto HTTP status codes.
+func writeThresholdError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, threshold.ErrSessionNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, threshold.ErrSessionState):
+		writeError(w, http.StatusPreconditionFailed, "failed_precondition", err.Error())
+	case errors.Is(err, threshold.ErrInvalidCommitments), errors.Is(err, threshold.ErrInvalidShare), errors.Is(err, threshold.ErrInvalidKey):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
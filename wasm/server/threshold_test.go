@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// TestThresholdOperators runs a 2-of-3 signing session and checks that
+// operators act only under their own share and on their reef's sessions.
+func TestThresholdOperators(t *testing.T) {
+	shares, pub, err := threshold.Deal("root", nil, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Operators = map[uint16]string{}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		pub.Operators[shares[i].Identifier] = name
+	}
+	coord, err := threshold.New(threshold.Config{Keys: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(server.Config{Verifier: tickets{}, Threshold: coord})
+	op := func(reef, agent string) string { return ticket(t, reef, agent, threshold.IntentOperate) }
+	alice, bob, carol := op("reef", "alice"), op("reef", "bob"), op("reef", "carol")
+
+	req := threshold.Request{ReefID: "reef", ColonyID: "colony", AgentID: "root", Intent: "register", TTLSeconds: 3600}
+	other := req
+	other.ReefID = "other"
+	if code := call(t, s, "POST", "/v1/threshold/sessions", alice, &other, nil); code != http.StatusForbidden {
+		t.Fatalf("session for another reef: status %d, want 403", code)
+	}
+	if code := call(t, s, "POST", "/v1/threshold/sessions", op("reef", "mallory"), &req, nil); code != http.StatusForbidden {
+		t.Fatalf("session opened by an agent without a share: status %d, want 403", code)
+	}
+	var session threshold.Session
+	if code := call(t, s, "POST", "/v1/threshold/sessions", alice, &req, &session); code != http.StatusCreated {
+		t.Fatalf("start: status %d", code)
+	}
+	path := "/v1/threshold/sessions/" + session.ID
+	if code := call(t, s, "GET", path, op("other", "alice"), nil, nil); code != http.StatusNotFound {
+		t.Fatalf("session read from another reef: status %d, want 404", code)
+	}
+
+	// Commitments are taken under their operator's ticket only.
+	nonces := make([]*threshold.Nonces, 2)
+	for i, token := range []string{alice, bob} {
+		n, com, err := threshold.Commit(shares[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonces[i] = n
+		forged := *com
+		forged.Identifier = shares[2].Identifier
+		if code := call(t, s, "POST", path+"/commitments", token, &forged, nil); code != http.StatusForbidden {
+			t.Fatalf("commitment for another operator's share: status %d, want 403", code)
+		}
+		if code := call(t, s, "POST", path+"/commitments", op("other", pub.Operators[com.Identifier]), com, nil); code != http.StatusNotFound {
+			t.Fatalf("commitment from another reef: status %d, want 404", code)
+		}
+		if code := call(t, s, "POST", path+"/commitments", token, com, &session); code != http.StatusOK {
+			t.Fatalf("commitment %d: status %d", i, code)
+		}
+	}
+	if session.Status != threshold.StatusSigning {
+		t.Fatalf("session is %s after 2 commitments", session.Status)
+	}
+
+	message := []byte(session.Message)
+	for i, token := range []string{alice, bob} {
+		z, err := threshold.Sign(shares[i], nonces[i], message, session.Commitments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		share := map[string]any{"identifier": shares[i].Identifier, "share": z}
+		// Carol did not commit, and may not sign even with a valid share.
+		if code := call(t, s, "POST", path+"/shares", carol, map[string]any{"share": z}, nil); code != http.StatusPreconditionFailed {
+			t.Fatalf("share from an operator who did not commit: status %d, want 412", code)
+		}
+		if code := call(t, s, "POST", path+"/shares", token, share, &session); code != http.StatusOK {
+			t.Fatalf("share %d: status %d", i, code)
+		}
+	}
+	if session.Status != threshold.StatusComplete {
+		t.Fatalf("session is %s after 2 shares", session.Status)
+	}
+	groupKey, err := pub.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := strings.LastIndexByte(session.Ticket, '.')
+	sig, err := base64.RawURLEncoding.DecodeString(session.Ticket[dot+1:])
+	if err != nil || !ed25519.Verify(groupKey, []byte(session.Ticket[:dot]), sig) {
+		t.Fatal("ticket does not verify under the group key")
+	}
+}
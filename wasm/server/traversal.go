@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/traversal"
+)
+
+// maxPollWait caps the long polls of the traversal and relay routes, like
+// maxSignalWait.
+const maxPollWait = 25 * time.Second
+
+// initiateRequest is the body of POST /v1/traversal/attempts.
+type initiateRequest struct {
+	Peer       string   `json:"peer"`
+	Candidates []string `json:"candidates"`
+}
+
+// acceptRequest is the body of POST /v1/traversal/attempts/{id}/accept.
+type acceptRequest struct {
+	Candidates []string `json:"candidates"`
+}
+
+// handleInitiateTraversal opens a hole punching attempt from the ticket's
+// agent to a peer in the ticket's colony.
+func (s *Server) handleInitiateTraversal(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req initiateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	a, err := s.traversal.Initiate(r.Context(), p.ReefID, p.ColonyID, p.AgentID, req.Peer, req.Candidates, s.remoteAddr(r))
+	if err != nil {
+		writeTraversalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// handlePendingTraversals lists the attempts waiting for the ticket's
+// agent to accept, long polling up to the wait parameter when there are
+// none.
+func (s *Server) handlePendingTraversals(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxPollWait)
+	if !ok {
+		return
+	}
+
+	attempts, err := s.traversal.Pending(r.Context(), p.ReefID, p.AgentID, wait)
+	if err != nil {
+		writeTraversalError(w, err)
+		return
+	}
+	if attempts == nil {
+		attempts = []*traversal.Attempt{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"attempts": attempts,
+	})
+}
+
+// handleAwaitTraversal returns an attempt to one of its parties, long
+// polling up to the wait parameter until it is ready.
+func (s *Server) handleAwaitTraversal(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := waitParam(w, r, maxPollWait)
+	if !ok {
+		return
+	}
+
+	a, err := s.traversal.Await(r.Context(), p.ReefID, r.PathValue("id"), p.AgentID, wait)
+	if err != nil {
+		writeTraversalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// handleAcceptTraversal accepts an attempt as its target and returns it
+// with the start time.
+func (s *Server) handleAcceptTraversal(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req acceptRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return
+	}
+
+	a, err := s.traversal.Accept(r.Context(), p.ReefID, r.PathValue("id"), p.AgentID, req.Candidates, s.remoteAddr(r))
+	if err != nil {
+		writeTraversalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// waitParam parses the optional wait parameter, a Go duration, capped at
+// limit. It writes the error response when the parameter is invalid.
+func waitParam(w http.ResponseWriter, r *http.Request, limit time.Duration) (time.Duration, bool) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return 0, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid wait: "+v)
+		return 0, false
+	}
+	return min(d, limit), true
+}
+
+// writeTraversalError maps traversal errors onto HTTP status codes.
+func writeTraversalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, traversal.ErrAttemptNotFound), errors.Is(err, traversal.ErrPeerNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, traversal.ErrAttemptState):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, traversal.ErrInvalidCandidate):
+		writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
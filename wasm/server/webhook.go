@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/audit"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/webhook"
+)
+
+// deadLettersResponse is the body answering GET
+// /v1/admin/webhooks/dead-letters.
+type deadLettersResponse struct {
+	DeadLetters []*webhook.DeadLetter `json:"dead_letters"`
+}
+
+// handleAdminDeadLetters lists the webhook events of the ticket's reef
+// that could not be delivered.
+func (s *Server) handleAdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermReadWebhooks, audit.AdminDeadLetters)
+	if !ok {
+		return
+	}
+	letters, err := s.webhooks.DeadLetters(r.Context(), p.ReefID)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	s.record(r, audit.AdminDeadLetters, p.ReferralClaims, "", "")
+	writeJSON(w, http.StatusOK, deadLettersResponse{DeadLetters: letters})
+}
+
+// handleAdminRedeliver makes one more delivery of a dead letter of the
+// ticket's reef. It answers 502 with the updated dead letter's error when
+// the endpoint fails again.
+func (s *Server) handleAdminRedeliver(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermRedeliverWebhooks, audit.AdminRedeliver)
+	if !ok {
+		return
+	}
+	dl, err := s.webhooks.Redeliver(r.Context(), p.ReefID, r.PathValue("id"))
+	if err != nil {
+		s.record(r, audit.AdminRedeliver, p.ReferralClaims, r.PathValue("id"), err.Error())
+		if dl != nil {
+			writeError(w, http.StatusBadGateway, "unavailable", err.Error())
+			return
+		}
+		writeWebhookError(w, err)
+		return
+	}
+	s.record(r, audit.AdminRedeliver, p.ReferralClaims, dl.ID, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminDiscard drops a dead letter of the ticket's reef without
+// delivering it.
+func (s *Server) handleAdminDiscard(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.admin(w, r, rbac.PermRedeliverWebhooks, audit.AdminDiscard)
+	if !ok {
+		return
+	}
+	if err := s.webhooks.Discard(r.Context(), p.ReefID, r.PathValue("id")); err != nil {
+		s.record(r, audit.AdminDiscard, p.ReferralClaims, r.PathValue("id"), err.Error())
+		writeWebhookError(w, err)
+		return
+	}
+	s.record(r, audit.AdminDiscard, p.ReferralClaims, r.PathValue("id"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeWebhookError maps webhook errors onto HTTP status codes.
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrDeadLetterNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, webhook.ErrInvalidEndpoint):
+		writeError(w, http.StatusPreconditionFailed, "failed_precondition", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/rbac"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/server"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/webhook"
+)
+
+type deadLetters struct {
+	DeadLetters []*webhook.DeadLetter `json:"dead_letters"`
+}
+
+// TestDeadLetterReefs dead-letters an event of each of two reefs and
+// checks that the admins of one reef neither see nor touch the other's.
+func TestDeadLetterReefs(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	d, err := webhook.New(webhook.Config{Endpoints: []webhook.Endpoint{{ID: "inventory", URL: down.URL}}, MaxQueue: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The dispatcher is not running: the first event fills the queue and
+	// the others are dead-lettered.
+	for _, reef := range []string{"a", "a", "b"} {
+		d.Publish(&webhook.Event{Type: webhook.AgentRegistered, Agent: &registry.Record{ReefID: reef, ColonyID: "colony", AgentID: "agent"}})
+	}
+	s := server.New(server.Config{Verifier: tickets{}, Webhooks: d})
+	adminA, adminB := admin(t, "a", rbac.RoleOperator), admin(t, "b", rbac.RoleOperator)
+
+	letters := map[string]*webhook.DeadLetter{}
+	for reef, token := range map[string]string{"a": adminA, "b": adminB} {
+		var out deadLetters
+		if code := call(t, s, "GET", "/v1/admin/webhooks/dead-letters", token, nil, &out); code != http.StatusOK {
+			t.Fatalf("listing of reef %s: status %d", reef, code)
+		}
+		if len(out.DeadLetters) != 1 || out.DeadLetters[0].ReefID != reef || out.DeadLetters[0].Event.Agent.ReefID != reef {
+			t.Fatalf("reef %s lists %d dead letters, want its own one", reef, len(out.DeadLetters))
+		}
+		letters[reef] = out.DeadLetters[0]
+	}
+
+	path := "/v1/admin/webhooks/dead-letters/" + letters["b"].ID
+	if code := call(t, s, "POST", path+"/redeliver", adminA, nil, nil); code != http.StatusNotFound {
+		t.Fatalf("redelivery of reef b's dead letter by reef a: status %d, want 404", code)
+	}
+	if code := call(t, s, "DELETE", path, adminA, nil, nil); code != http.StatusNotFound {
+		t.Fatalf("discard of reef b's dead letter by reef a: status %d, want 404", code)
+	}
+	if code := call(t, s, "POST", path+"/redeliver", adminB, nil, nil); code != http.StatusBadGateway {
+		t.Fatalf("redelivery to a failing endpoint: status %d, want 502", code)
+	}
+	if code := call(t, s, "DELETE", path, adminB, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("discard by reef b: status %d, want 204", code)
+	}
+
+	var out deadLetters
+	call(t, s, "GET", "/v1/admin/webhooks/dead-letters", adminA, nil, &out)
+	if len(out.DeadLetters) != 1 || out.DeadLetters[0].ID != letters["a"].ID {
+		t.Fatal("reef a's dead letter went with reef b's")
+	}
+	if code := call(t, s, "GET", "/v1/admin/webhooks/dead-letters", admin(t, "a"), nil, nil); code != http.StatusForbidden {
+		t.Fatalf("listing without a role: status %d, want 403", code)
+	}
+}
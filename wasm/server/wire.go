@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// negotiate picks the schema version of the response from the
+// Accept-Version header and names it in the Content-Version header,
+// where writeJSON finds it. It writes the error response when none of
+// the accepted versions is supported.
+func negotiate(w http.ResponseWriter, r *http.Request) bool {
+	version, err := wire.Negotiate(r.Header.Get(wire.AcceptHeader))
+	if err != nil {
+		writeError(w, http.StatusNotAcceptable, "not_acceptable", err.Error())
+		return false
+	}
+	w.Header().Set(wire.ContentHeader, strconv.Itoa(version))
+	return true
+}
+
+// responseVersion returns the schema version negotiated for w; version 1
+// when none was.
+func responseVersion(w http.ResponseWriter) int {
+	if v, err := strconv.Atoi(w.Header().Get(wire.ContentHeader)); err == nil {
+		return v
+	}
+	return wire.V1
+}
+
+// decodeBody decodes the request body into v, in the schema version its
+// Content-Version header names. It writes the error response when the
+// body cannot be decoded.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	version, err := wire.Parse(r.Header.Get(wire.ContentHeader))
+	if err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, "invalid_argument", err.Error())
+		return false
+	}
+	var data json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return false
+	}
+	if err := wire.Unmarshal(data, version, v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
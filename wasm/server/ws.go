@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/wire"
+)
+
+// WebSocket subprotocols of /v1/ws. Browsers cannot set headers on
+// WebSocket requests, so they offer the ticket as a second subprotocol,
+// "bearer.<ticket>", next to WSProtocol, and choose the schema version of
+// records (see package wire) by offering WSProtocolV2 for version 2.
+const (
+	WSProtocol     = "coral.watch.v1"
+	WSProtocolV2   = "coral.watch.v2"
+	wsBearerPrefix = "bearer."
+)
+
+// wsKeepAlive is how often an idle socket gets a keepalive message.
+const wsKeepAlive = 30 * time.Second
+
+// wsMessage is a JSON text frame sent on /v1/ws. Type is a registry
+// event type ("put", "delete", "expire", "suspect", "alive", "fail") with
+// Record and Cursor, "revoke" with JTI and ExpiresAt, "resync" when the
+// subscriber fell behind and should reconnect with its last cursor,
+// "drain" before the socket is closed for a server shutting down, when
+// the client should reconnect to another instance, "expired" before the
+// socket is closed for an expired ticket, or "keepalive".
+type wsMessage struct {
+	Type      string     `json:"type"`
+	Record    any        `json:"record,omitempty"`
+	Cursor    string     `json:"cursor,omitempty"`
+	JTI       string     `json:"jti,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleWS streams registry changes over a WebSocket, along with
+// revocations when Config.Revocations is set. The ticket is checked before the
+// upgrade; the socket is closed when the ticket expires or is revoked.
+// Filters are taken from the same query parameters as /v1/watch, and a
+// reconnecting client resumes with the cursor parameter as there.
+// Origins are not checked: the ticket travels in the subprotocol, not a
+// cookie, so other sites cannot ride an agent's credentials.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	filter, ok := watchFilter(w, r, p)
+	if !ok {
+		return
+	}
+	events, ok := s.watch(w, r, p, filter)
+	if !ok {
+		return
+	}
+	var expires time.Time
+	if p.ExpiresAt != nil {
+		expires = p.ExpiresAt.Time
+	}
+
+	websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			offered := cfg.Protocol
+			cfg.Protocol = nil
+			for _, p := range offered {
+				if p == WSProtocolV2 || p == WSProtocol && cfg.Protocol == nil {
+					cfg.Protocol = []string{p}
+				}
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			version := wire.V1
+			if slices.Contains(ws.Config().Protocol, WSProtocolV2) {
+				version = wire.V2
+			}
+			s.streamWS(ws, events, version, p.ReefID, p.ID, expires)
+		},
+	}.ServeHTTP(w, r)
+}
+
+// streamWS writes events to ws, with records in the schema version, and
+// the revocations of reefID until the peer goes away, the ticket jti
+// expires at expires or is revoked, or the subscriber falls behind.
+func (s *Server) streamWS(ws *websocket.Conn, events <-chan registry.Event, version int, reefID, jti string, expires time.Time) {
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	// Agents have nothing to say; reading only notices disconnects.
+	go func() {
+		defer cancel()
+		var discard [512]byte
+		for {
+			if _, err := ws.Read(discard[:]); err != nil {
+				return
+			}
+		}
+	}()
+
+	var revocations <-chan revocation.Revocation
+	if s.revocations != nil {
+		revocations = s.revocations.Watch(ctx)
+	}
+	var expiry <-chan time.Time
+	if !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expiry = timer.C
+	}
+	keepAlive := time.NewTicker(wsKeepAlive)
+	defer keepAlive.Stop()
+
+	send := func(m wsMessage) bool {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+		ws.SetWriteDeadline(time.Now().Add(wsKeepAlive))
+		_, err = ws.Write(data)
+		return err == nil
+	}
+	for {
+		var m wsMessage
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiry:
+			send(wsMessage{Type: "expired"})
+			return
+		case <-keepAlive.C:
+			m = wsMessage{Type: "keepalive"}
+		case ev, ok := <-events:
+			if !ok {
+				send(wsMessage{Type: "resync"})
+				return
+			}
+			if ev.Type == registry.EventDrain {
+				send(wsMessage{Type: "drain"})
+				return
+			}
+			m = wsMessage{Type: ev.Type.String(), Record: wire.Encode(ev.Record, version), Cursor: ev.Cursor}
+		case rev, ok := <-revocations:
+			if !ok {
+				send(wsMessage{Type: "resync"})
+				return
+			}
+			if rev.ReefID != reefID {
+				continue
+			}
+			m = wsMessage{Type: "revoke", JTI: rev.JTI, ExpiresAt: &rev.ExpiresAt}
+			if rev.JTI == jti {
+				send(m)
+				return
+			}
+		}
+		if !send(m) {
+			return
+		}
+	}
+}
+
+// protocolTicket returns the ticket offered as a "bearer." WebSocket
+// subprotocol.
+func protocolTicket(r *http.Request) string {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			if t, ok := strings.CutPrefix(strings.TrimSpace(p), wsBearerPrefix); ok {
+				return t
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,318 @@
+// Package shard splits the registry between discovery instances by
+// colony, so that a deployment can hold more agents than one instance.
+// Each colony is owned by the shard its ID hashes to on a consistent hash
+// ring over the shards of a Map, so that adding or removing a shard only
+// moves the colonies it gains or loses. Instances forward requests for
+// colonies they do not own to the owner, and exchange maps with the
+// other shards at Path, where the map with the higher version wins, so
+// that a change made on one instance reaches the rest.
+package shard
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is the route shards exchange their maps at.
+const Path = "/v1/shards"
+
+// Headers of forwarded requests. The forwarding shard vouches for the
+// client's address with the shards' secret.
+const (
+	ClientHeader = "Coral-Shard-Client"
+	SecretHeader = "Coral-Shard-Secret"
+)
+
+// Defaults used when the Config leaves a field zero.
+const (
+	DefaultVirtualNodes = 128
+	DefaultInterval     = 30 * time.Second
+)
+
+// ErrUnauthorized is returned for exchanges and forwarded requests
+// without the shards' secret.
+var ErrUnauthorized = errors.New("invalid shard secret")
+
+// ErrInvalidMap is returned for maps without shards, with duplicate IDs
+// or with invalid URLs.
+var ErrInvalidMap = errors.New("invalid shard map")
+
+// Shard is a discovery instance owning part of the colonies.
+type Shard struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Map assigns colonies to shards. Maps with a higher version replace
+// those with a lower one, so operators bump it with every change.
+type Map struct {
+	Version uint64  `json:"version"`
+	Shards  []Shard `json:"shards"`
+}
+
+// ParseMap parses and validates a JSON map document.
+func ParseMap(data []byte) (Map, error) {
+	var m Map
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Map{}, fmt.Errorf("%w: %v", ErrInvalidMap, err)
+	}
+	return m, m.validate()
+}
+
+func (m Map) validate() error {
+	if len(m.Shards) == 0 {
+		return fmt.Errorf("%w: no shards", ErrInvalidMap)
+	}
+	seen := map[string]bool{}
+	for _, s := range m.Shards {
+		if s.ID == "" || seen[s.ID] {
+			return fmt.Errorf("%w: empty or duplicate shard ID %q", ErrInvalidMap, s.ID)
+		}
+		seen[s.ID] = true
+		if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%w: shard %s has invalid URL %q", ErrInvalidMap, s.ID, s.URL)
+		}
+	}
+	return nil
+}
+
+// ring is a consistent hash ring: points sorted, owners[i] the index of
+// the shard at points[i].
+type ring struct {
+	points []uint64
+	owners []int
+}
+
+func newRing(m Map, vnodes int) ring {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	points := make([]point, 0, len(m.Shards)*vnodes)
+	for i, s := range m.Shards {
+		for v := 0; v < vnodes; v++ {
+			points = append(points, point{hash(s.ID + "#" + strconv.Itoa(v)), i})
+		}
+	}
+	slices.SortFunc(points, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(m.Shards[a.owner].ID, m.Shards[b.owner].ID))
+	})
+	r := ring{points: make([]uint64, len(points)), owners: make([]int, len(points))}
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.owner
+	}
+	return r
+}
+
+// owner returns the index of the shard owning key.
+func (r ring) owner(key string) int {
+	h := hash(key)
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Config holds the configuration for a Router.
+type Config struct {
+	// Self is the ID of this instance in the map. Required.
+	Self string
+
+	// Map is the initial map. Required.
+	Map Map
+
+	// Secret is shared by the shards. It authenticates map exchanges and
+	// the client addresses of forwarded requests. Required.
+	Secret []byte
+
+	// VirtualNodes is the number of points each shard has on the ring.
+	// Defaults to DefaultVirtualNodes.
+	VirtualNodes int
+
+	// Interval is how often maps are exchanged. Defaults to
+	// DefaultInterval.
+	Interval time.Duration
+
+	// HTTPClient reaches the other shards. Defaults to a client with a
+	// 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Router tells which shard owns a colony and keeps the map in step with
+// the other shards. It is safe for concurrent use.
+type Router struct {
+	self     string
+	secret   []byte
+	vnodes   int
+	interval time.Duration
+	http     *http.Client
+
+	mu   sync.RWMutex
+	m    Map
+	ring ring
+}
+
+// New creates a Router from cfg.
+func New(cfg Config) (*Router, error) {
+	if cfg.Self == "" || len(cfg.Secret) == 0 {
+		return nil, errors.New("shard: Self and Secret are required")
+	}
+	if err := cfg.Map.validate(); err != nil {
+		return nil, fmt.Errorf("shard: %w", err)
+	}
+	if cfg.VirtualNodes <= 0 {
+		cfg.VirtualNodes = DefaultVirtualNodes
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Router{
+		self:     cfg.Self,
+		secret:   cfg.Secret,
+		vnodes:   cfg.VirtualNodes,
+		interval: cfg.Interval,
+		http:     cfg.HTTPClient,
+		m:        cfg.Map,
+		ring:     newRing(cfg.Map, cfg.VirtualNodes),
+	}, nil
+}
+
+// Self returns the ID of this instance.
+func (r *Router) Self() string {
+	return r.self
+}
+
+// Map returns the current map.
+func (r *Router) Map() Map {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.m
+}
+
+// Owner returns the shard owning colonyID, and whether it is this
+// instance.
+func (r *Router) Owner(colonyID string) (Shard, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := r.m.Shards[r.ring.owner(colonyID)]
+	return s, s.ID == r.self
+}
+
+// Update replaces the map with m when m has a higher version. It reports
+// whether it did.
+func (r *Router) Update(m Map) (bool, error) {
+	if err := m.validate(); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.Version <= r.m.Version {
+		return false, nil
+	}
+	r.m, r.ring = m, newRing(m, r.vnodes)
+	return true, nil
+}
+
+// Authenticate checks that secret is the shards' shared secret.
+func (r *Router) Authenticate(secret string) error {
+	got, want := sha256.Sum256([]byte(secret)), sha256.Sum256(r.secret)
+	if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// SetForwarded marks req, forwarded to another shard, as made by the
+// client at addr.
+func (r *Router) SetForwarded(req *http.Request, addr string) {
+	req.Header.Set(ClientHeader, addr)
+	req.Header.Set(SecretHeader, string(r.secret))
+}
+
+// Exchange sends the map to every other shard and adopts the map each
+// answers with when it is newer. It returns the first error and carries
+// on with the other shards.
+func (r *Router) Exchange(ctx context.Context) error {
+	m := r.Map()
+	var first error
+	for _, s := range m.Shards {
+		if s.ID == r.self {
+			continue
+		}
+		theirs, err := r.exchange(ctx, s, m)
+		if err == nil {
+			_, err = r.Update(theirs)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("shard %s: %w", s.ID, err)
+		}
+	}
+	return first
+}
+
+// Run calls Exchange every interval until ctx is done.
+func (r *Router) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = r.Exchange(ctx)
+		}
+	}
+}
+
+// exchange posts m to s and returns the map s answers with.
+func (r *Router) exchange(ctx context.Context, s Shard, m Map) (Map, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return Map{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.URL, "/")+Path, bytes.NewReader(body))
+	if err != nil {
+		return Map{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(r.secret))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return Map{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return Map{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var theirs Map
+	if err := json.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+		return Map{}, err
+	}
+	return theirs, nil
+}
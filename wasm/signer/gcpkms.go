@@ -0,0 +1,138 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// gcpKMSEndpoint is the Cloud KMS REST API root.
+const gcpKMSEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+// GCPKMSConfig configures a Google Cloud KMS signer.
+type GCPKMSConfig struct {
+	// KeyVersion is the full resource name of an EC_SIGN_ED25519 key
+	// version: projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V.
+	KeyVersion string
+
+	// KeyID is the kid to publish. Defaults to KeyVersion.
+	KeyID string
+
+	// Token returns an OAuth2 access token with the cloudkms scope, e.g.
+	// from golang.org/x/oauth2/google. Required.
+	Token func(ctx context.Context) (string, error)
+
+	// HTTPClient is used for API calls. Defaults to a client with a 30s
+	// timeout.
+	HTTPClient *http.Client
+
+	// Endpoint overrides the API root, for tests and private endpoints.
+	Endpoint string
+}
+
+// NewGCPKMS returns a Signer backed by a Cloud KMS key version. The public
+// key is fetched once at construction.
+func NewGCPKMS(ctx context.Context, cfg GCPKMSConfig) (Signer, error) {
+	if cfg.KeyVersion == "" {
+		return nil, errors.New("key version is required")
+	}
+	if cfg.Token == nil {
+		return nil, errors.New("token source is required")
+	}
+	if cfg.KeyID == "" {
+		cfg.KeyID = cfg.KeyVersion
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = gcpKMSEndpoint
+	}
+
+	k := &gcpKMS{cfg: cfg}
+	var resp struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call(ctx, http.MethodGet, "/publicKey", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch public key: %w", err)
+	}
+	block, _ := pem.Decode([]byte(resp.PEM))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key version uses %s, not Ed25519", resp.Algorithm)
+	}
+
+	return Func(cfg.KeyID, pub, k.sign), nil
+}
+
+type gcpKMS struct {
+	cfg GCPKMSConfig
+}
+
+// sign calls asymmetricSign. Ed25519 keys sign the raw data.
+func (k *gcpKMS) sign(ctx context.Context, message []byte) ([]byte, error) {
+	req := map[string]string{"data": base64.StdEncoding.EncodeToString(message)}
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	if err := k.call(ctx, http.MethodPost, ":asymmetricSign", req, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
+
+// call invokes a method on the key version resource.
+func (k *gcpKMS) call(ctx context.Context, method, suffix string, body, out interface{}) error {
+	token, err := k.cfg.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain access token: %w", err)
+	}
+
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.cfg.Endpoint+k.cfg.KeyVersion+suffix, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := k.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloud KMS returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
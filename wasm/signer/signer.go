@@ -0,0 +1,120 @@
+// Package signer mints referral tickets through a Signer, so a ticket can
+// be signed by a key held in an HSM or a cloud KMS instead of one loaded
+// into memory. Local keys, crypto.Signer implementations (PKCS#11 modules
+// such as crypto11 expose these) and Google Cloud KMS are supported; any
+// other service can be plugged in with Func.
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ErrBadSignature is returned when a signer produces a signature that does
+// not verify under its public key, usually a misconfigured key reference.
+var ErrBadSignature = errors.New("signer returned an invalid signature")
+
+// Signer signs with an Ed25519 key identified by KeyID.
+type Signer interface {
+	// KeyID is the kid published in the JWKS for this key.
+	KeyID() string
+
+	// Public returns the public half of the key.
+	Public() ed25519.PublicKey
+
+	// Sign returns the Ed25519 signature of message (PureEdDSA, no
+	// prehashing).
+	Sign(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// Local returns a Signer for an in-memory private key.
+func Local(kid string, key ed25519.PrivateKey) Signer {
+	return Func(kid, key.Public().(ed25519.PublicKey), func(_ context.Context, message []byte) ([]byte, error) {
+		return ed25519.Sign(key, message), nil
+	})
+}
+
+// FromCryptoSigner adapts a crypto.Signer holding an Ed25519 key, such as
+// a PKCS#11 object. The context is not passed to s.
+func FromCryptoSigner(kid string, s crypto.Signer) (Signer, error) {
+	pub, ok := s.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signer key is %T, not Ed25519", s.Public())
+	}
+	return Func(kid, pub, func(_ context.Context, message []byte) ([]byte, error) {
+		return s.Sign(rand.Reader, message, crypto.Hash(0))
+	}), nil
+}
+
+// Func returns a Signer that calls sign.
+func Func(kid string, pub ed25519.PublicKey, sign func(ctx context.Context, message []byte) ([]byte, error)) Signer {
+	return &funcSigner{kid: kid, pub: pub, sign: sign}
+}
+
+type funcSigner struct {
+	kid  string
+	pub  ed25519.PublicKey
+	sign func(ctx context.Context, message []byte) ([]byte, error)
+}
+
+func (s *funcSigner) KeyID() string             { return s.kid }
+func (s *funcSigner) Public() ed25519.PublicKey { return s.pub }
+
+func (s *funcSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	return s.sign(ctx, message)
+}
+
+// SignToken signs claims as an EdDSA JWT with s. Remote signatures are
+// checked against s.Public before the token is returned.
+func SignToken(ctx context.Context, s Signer, claims gojwt.Claims) (string, error) {
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = s.KeyID()
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+	sig, err := s.Sign(ctx, []byte(signingString))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	if !ed25519.Verify(s.Public(), []byte(signingString), sig) {
+		return "", ErrBadSignature
+	}
+	return signingString + "." + token.EncodeSegment(sig), nil
+}
+
+// CreateReferralTicket is jwt.CreateReferralTicketStatic for a Signer: it
+// mints a ticket with the default issuer and audience and returns it with
+// its expiry in Unix seconds.
+func CreateReferralTicket(ctx context.Context, s Signer, reefID, colonyID, agentID, intent string, ttl time.Duration) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token, err := SignToken(ctx, s, &jwt.ReferralClaims{
+		ReefID:   reefID,
+		ColonyID: colonyID,
+		AgentID:  agentID,
+		Intent:   intent,
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			NotBefore: gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return token, expiresAt.Unix(), nil
+}
@@ -0,0 +1,55 @@
+package spiffe
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jwk"
+)
+
+// Bundle holds the JWT-SVID signing keys of a trust domain.
+type Bundle struct {
+	TrustDomain string
+	keys        map[string]crypto.PublicKey
+}
+
+// ParseBundle parses a SPIFFE bundle, or any JWKS, for trustDomain. Keys
+// marked for other uses (x509-svid) are skipped.
+func ParseBundle(trustDomain string, data []byte) (*Bundle, error) {
+	set, err := jwk.ParseSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bundle for %s: %w", trustDomain, err)
+	}
+
+	b := &Bundle{TrustDomain: trustDomain, keys: make(map[string]crypto.PublicKey)}
+	for _, k := range set {
+		if k.Use != "" && k.Use != "jwt-svid" {
+			continue
+		}
+		if k.KID == "" {
+			return nil, fmt.Errorf("bundle for %s has a JWT key without kid", trustDomain)
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("bundle for %s: key %s: %w", trustDomain, k.KID, err)
+		}
+		b.keys[k.KID] = pub
+	}
+	return b, nil
+}
+
+// LoadBundle reads a bundle file for trustDomain.
+func LoadBundle(trustDomain, path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBundle(trustDomain, data)
+}
+
+// Key returns the key with kid.
+func (b *Bundle) Key(kid string) (crypto.PublicKey, bool) {
+	k, ok := b.keys[kid]
+	return k, ok
+}
@@ -0,0 +1,46 @@
+package spiffe
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidID is returned for strings that are not SPIFFE IDs.
+var ErrInvalidID = errors.New("invalid SPIFFE ID")
+
+// ID is a SPIFFE ID, spiffe://<trust domain><path>.
+type ID struct {
+	TrustDomain string
+	Path        string
+}
+
+// ParseID parses a SPIFFE ID.
+func ParseID(s string) (ID, error) {
+	rest, ok := strings.CutPrefix(s, "spiffe://")
+	if !ok {
+		return ID{}, fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	td, path, _ := strings.Cut(rest, "/")
+	if td == "" || strings.ToLower(td) != td || strings.ContainsAny(td, ":@") {
+		return ID{}, fmt.Errorf("%w: bad trust domain in %q", ErrInvalidID, s)
+	}
+	if path != "" {
+		path = "/" + path
+		for _, seg := range strings.Split(path[1:], "/") {
+			if seg == "" || seg == "." || seg == ".." {
+				return ID{}, fmt.Errorf("%w: bad path in %q", ErrInvalidID, s)
+			}
+		}
+		if _, err := url.Parse(s); err != nil || strings.ContainsAny(path, "?#%") {
+			return ID{}, fmt.Errorf("%w: bad path in %q", ErrInvalidID, s)
+		}
+	}
+	return ID{TrustDomain: td, Path: path}, nil
+}
+
+// String returns the URI form of id.
+func (id ID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
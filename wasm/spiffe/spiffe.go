@@ -0,0 +1,249 @@
+// Package spiffe lets workloads with a SPIFFE identity use discovery. An
+// Adapter accepts JWT-SVIDs in place of referral tickets on the
+// registration endpoint, mapping the SPIFFE ID to a reef, colony and agent
+// through a path template, and checks tickets bound to a SPIFFE ID: such a
+// ticket carries a spiffe_id claim and must be presented together with a
+// JWT-SVID for that ID in the Coral-SVID header.
+package spiffe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/auth"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Header carries the JWT-SVID accompanying a SPIFFE-bound ticket. gRPC
+// uses the lower-cased name as a metadata key.
+const Header = "Coral-SVID"
+
+// DefaultTemplate maps SPIFFE ID paths to agents when Config.Template is
+// empty.
+const DefaultTemplate = "/reef/{reef}/colony/{colony}/agent/{agent}"
+
+var (
+	// ErrUnmapped is returned for SPIFFE IDs that do not match the
+	// template.
+	ErrUnmapped = errors.New("SPIFFE ID does not map to an agent")
+
+	// ErrSVIDRequired is returned when a SPIFFE-bound ticket is presented
+	// without a JWT-SVID.
+	ErrSVIDRequired = errors.New("JWT-SVID required")
+
+	// ErrBindingMismatch is returned when the presented SVID is for a
+	// different SPIFFE ID than the ticket is bound to.
+	ErrBindingMismatch = errors.New("JWT-SVID does not match ticket binding")
+)
+
+// Claims are referral claims bound to a SPIFFE ID.
+type Claims struct {
+	jwt.ReferralClaims
+
+	SPIFFEID string `json:"spiffe_id,omitempty"`
+}
+
+// Bind sets claims' SPIFFE ID.
+func Bind(claims *Claims, id ID) {
+	claims.SPIFFEID = id.String()
+}
+
+// Bound returns the SPIFFE ID ticket is bound to, or "" when it is not
+// bound. The ticket signature is not checked.
+func Bound(ticket string) (string, error) {
+	var claims Claims
+	if _, _, err := gojwt.NewParser().ParseUnverified(ticket, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse ticket: %w", err)
+	}
+	return claims.SPIFFEID, nil
+}
+
+// Verifier validates referral tickets. *jwt.Validator and the wrappers in
+// this module satisfy it.
+type Verifier interface {
+	ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error)
+}
+
+// Config configures an Adapter.
+type Config struct {
+	// Bundles are the trusted trust domains. Required.
+	Bundles []*Bundle
+
+	// Audiences are the accepted SVID audiences; DefaultAudience when
+	// empty.
+	Audiences []string
+
+	// Template maps SPIFFE ID paths to agents. Its segments are literals
+	// or the placeholders {reef}, {colony} and {agent}, all of which must
+	// appear. DefaultTemplate when empty.
+	Template string
+
+	// Leeway is the allowed clock skew.
+	Leeway time.Duration
+}
+
+// Adapter validates JWT-SVIDs and SPIFFE bindings.
+type Adapter struct {
+	bundles   map[string]*Bundle
+	audiences []string
+	template  []string
+	leeway    time.Duration
+}
+
+// New creates an Adapter from cfg.
+func New(cfg Config) (*Adapter, error) {
+	if len(cfg.Bundles) == 0 {
+		return nil, errors.New("at least one trust bundle is required")
+	}
+	a := &Adapter{
+		bundles:   make(map[string]*Bundle, len(cfg.Bundles)),
+		audiences: cfg.Audiences,
+		leeway:    cfg.Leeway,
+	}
+	for _, b := range cfg.Bundles {
+		a.bundles[b.TrustDomain] = b
+	}
+	if len(a.audiences) == 0 {
+		a.audiences = []string{DefaultAudience}
+	}
+
+	tmpl := cfg.Template
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	a.template = strings.Split(strings.TrimPrefix(tmpl, "/"), "/")
+	for _, seg := range a.template {
+		if strings.ContainsAny(seg, "{}") && seg != "{reef}" && seg != "{colony}" && seg != "{agent}" {
+			return nil, fmt.Errorf("template %q has unknown placeholder %s", tmpl, seg)
+		}
+	}
+	for _, p := range []string{"{reef}", "{colony}", "{agent}"} {
+		n := 0
+		for _, seg := range a.template {
+			if seg == p {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("template %q must contain %s exactly once", tmpl, p)
+		}
+	}
+	return a, nil
+}
+
+// ValidateSVID verifies a JWT-SVID against the configured bundles and
+// audiences.
+func (a *Adapter) ValidateSVID(token string) (*SVID, error) {
+	return validate(token, a.bundles, a.audiences, a.leeway)
+}
+
+// Claims maps svid to registration claims. The ticket ID is the SVID's jti
+// or, for SVIDs without one, derived from the token.
+func (a *Adapter) Claims(svid *SVID, token string) (*jwt.ReferralClaims, error) {
+	segs := strings.Split(strings.TrimPrefix(svid.ID.Path, "/"), "/")
+	if len(segs) != len(a.template) {
+		return nil, fmt.Errorf("%w: %s", ErrUnmapped, svid.ID)
+	}
+	vars := make(map[string]string, 3)
+	for i, seg := range a.template {
+		if strings.HasPrefix(seg, "{") {
+			vars[seg] = segs[i]
+		} else if seg != segs[i] {
+			return nil, fmt.Errorf("%w: %s", ErrUnmapped, svid.ID)
+		}
+	}
+
+	jti := svid.JTI
+	if jti == "" {
+		sum := sha256.Sum256([]byte(token))
+		jti = "svid-" + hex.EncodeToString(sum[:16])
+	}
+	return &jwt.ReferralClaims{
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    "spiffe://" + svid.ID.TrustDomain,
+			Subject:   svid.ID.String(),
+			Audience:  svid.Audience,
+			ExpiresAt: gojwt.NewNumericDate(svid.ExpiresAt),
+		},
+		ReefID:   vars["{reef}"],
+		ColonyID: vars["{colony}"],
+		AgentID:  vars["{agent}"],
+		Intent:   registry.IntentRegister,
+	}, nil
+}
+
+// CheckBinding verifies that svid is a valid JWT-SVID for the SPIFFE ID
+// ticket is bound to. Unbound tickets pass without an SVID. The ticket
+// itself is verified by the handler.
+func (a *Adapter) CheckBinding(ticket, svid string) error {
+	bound, err := Bound(ticket)
+	if err != nil || bound == "" {
+		// Malformed tickets are rejected when verified.
+		return nil
+	}
+	if svid == "" {
+		return fmt.Errorf("%w: ticket is bound to %s", ErrSVIDRequired, bound)
+	}
+	s, err := a.ValidateSVID(svid)
+	if err != nil {
+		return err
+	}
+	if s.ID.String() != bound {
+		return fmt.Errorf("%w: SVID is for %s, ticket is bound to %s", ErrBindingMismatch, s.ID, bound)
+	}
+	return nil
+}
+
+// Authenticator authenticates requests by the JWT-SVID in their Header
+// alone. Place it after the ticket authenticator: with a ticket, the
+// SVID only proves the ticket's SPIFFE binding (see CheckBinding).
+func (a *Adapter) Authenticator() auth.Authenticator {
+	return auth.AuthenticatorFunc(func(r *http.Request) (*auth.Principal, error) {
+		token := r.Header.Get(Header)
+		if token == "" {
+			return nil, auth.ErrNoCredentials
+		}
+		svid, err := a.ValidateSVID(token)
+		if err != nil {
+			return nil, err
+		}
+		claims, err := a.Claims(svid, token)
+		if err != nil {
+			return nil, err
+		}
+		return &auth.Principal{Method: auth.MethodSVID, ReferralClaims: claims}, nil
+	})
+}
+
+// Verifier wraps inner so that JWT-SVIDs from a trusted trust domain are
+// accepted as registration tickets. Other tokens are passed to inner.
+// Place it inside policy and replay wrappers so SVID registrations are
+// subject to them.
+func (a *Adapter) Verifier(inner Verifier) Verifier {
+	return &verifier{adapter: a, inner: inner}
+}
+
+type verifier struct {
+	adapter *Adapter
+	inner   Verifier
+}
+
+func (v *verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	if !IsSVID(tokenString) {
+		return v.inner.ValidateReferralTicket(tokenString)
+	}
+	svid, err := v.adapter.ValidateSVID(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return v.adapter.Claims(svid, tokenString)
+}
@@ -0,0 +1,102 @@
+package spiffe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultAudience is the audience JWT-SVIDs must carry when
+// Config.Audiences is empty.
+const DefaultAudience = "coral-discovery"
+
+var (
+	// ErrInvalidSVID is returned for JWT-SVIDs that are malformed, badly
+	// signed, expired or issued for another audience.
+	ErrInvalidSVID = errors.New("invalid JWT-SVID")
+
+	// ErrUnknownTrustDomain is returned for SVIDs from a trust domain
+	// without a configured bundle.
+	ErrUnknownTrustDomain = errors.New("unknown trust domain")
+)
+
+// svidMethods are the JWT-SVID signing algorithms.
+var svidMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// SVID is a validated JWT-SVID.
+type SVID struct {
+	ID        ID
+	Audience  []string
+	ExpiresAt time.Time
+
+	// JTI is the token's jti claim, which JWT-SVIDs need not carry.
+	JTI string
+}
+
+// IsSVID reports whether token looks like a JWT-SVID, that is a JWT whose
+// subject is a SPIFFE ID. It does not verify the token.
+func IsSVID(token string) bool {
+	var claims gojwt.RegisteredClaims
+	if _, _, err := gojwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return false
+	}
+	return strings.HasPrefix(claims.Subject, "spiffe://")
+}
+
+// validate checks token against the bundle of its subject's trust domain
+// and the accepted audiences.
+func validate(token string, bundles map[string]*Bundle, audiences []string, leeway time.Duration) (*SVID, error) {
+	var claims gojwt.RegisteredClaims
+	var id ID
+	keyFunc := func(t *gojwt.Token) (interface{}, error) {
+		var err error
+		if id, err = ParseID(claims.Subject); err != nil {
+			return nil, err
+		}
+		b, ok := bundles[id.TrustDomain]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTrustDomain, id.TrustDomain)
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := b.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("no key %q in the bundle for %s", kid, id.TrustDomain)
+		}
+		return key, nil
+	}
+
+	_, err := gojwt.ParseWithClaims(token, &claims, keyFunc,
+		gojwt.WithValidMethods(svidMethods),
+		gojwt.WithExpirationRequired(),
+		gojwt.WithLeeway(leeway),
+	)
+	if errors.Is(err, ErrUnknownTrustDomain) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSVID, err)
+	}
+	if !audienceMatches(claims.Audience, audiences) {
+		return nil, fmt.Errorf("%w: audience %v not accepted", ErrInvalidSVID, []string(claims.Audience))
+	}
+	return &SVID{
+		ID:        id,
+		Audience:  claims.Audience,
+		ExpiresAt: claims.ExpiresAt.Time,
+		JTI:       claims.ID,
+	}, nil
+}
+
+func audienceMatches(got gojwt.ClaimStrings, accepted []string) bool {
+	for _, a := range got {
+		for _, b := range accepted {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
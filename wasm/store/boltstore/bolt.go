@@ -0,0 +1,169 @@
+// Package boltstore implements store.Store on a local BoltDB file, for
+// single-node deployments that need registry state to survive restarts.
+package boltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+var bucketName = []byte("entries")
+
+// Store is a store.Store backed by BoltDB. Values are stored as an 8-byte
+// big-endian revision followed by the value bytes.
+type Store struct {
+	db    *bolt.DB
+	watch *store.Broadcaster
+
+	// mu is held from the start of a write until its event is published,
+	// so that watchers see writes in revision order.
+	mu sync.Mutex
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens or creates the BoltDB file at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return &Store{db: db, watch: store.NewBroadcaster()}, nil
+}
+
+// Get implements store.Store.
+func (s *Store) Get(_ context.Context, key string) (*store.Entry, error) {
+	var entry *store.Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketName).Get([]byte(key))
+		if raw == nil {
+			return store.ErrNotFound
+		}
+		entry = decode(key, raw)
+		return nil
+	})
+	return entry, err
+}
+
+// Put implements store.Store.
+func (s *Store) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	entry, err := s.update(store.EventPut, func(b *bolt.Bucket) (*store.Entry, error) {
+		return put(b, key, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entry.Revision, nil
+}
+
+// Delete implements store.Store.
+func (s *Store) Delete(_ context.Context, key string) error {
+	_, err := s.update(store.EventDelete, func(b *bolt.Bucket) (*store.Entry, error) {
+		raw := b.Get([]byte(key))
+		if raw == nil {
+			return nil, store.ErrNotFound
+		}
+		return decode(key, raw), b.Delete([]byte(key))
+	})
+	return err
+}
+
+// List implements store.Store.
+func (s *Store) List(_ context.Context, prefix string) ([]*store.Entry, error) {
+	var out []*store.Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			out = append(out, decode(string(k), v))
+		}
+		return nil
+	})
+	return out, err
+}
+
+// CompareAndSwap implements store.Store.
+func (s *Store) CompareAndSwap(_ context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	entry, err := s.update(store.EventPut, func(b *bolt.Bucket) (*store.Entry, error) {
+		var current uint64
+		if raw := b.Get([]byte(key)); raw != nil {
+			current = binary.BigEndian.Uint64(raw[:8])
+		}
+		if current != revision {
+			return nil, store.ErrConflict
+		}
+		return put(b, key, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entry.Revision, nil
+}
+
+// Watch implements store.Store. Only writes made through this Store are
+// observed; BoltDB files are not shared between processes.
+func (s *Store) Watch(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	return s.watch.Subscribe(ctx, prefix), nil
+}
+
+// Close implements store.Store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// update runs fn in a write transaction and, once it commits, publishes
+// an event of type typ for the entry fn returns.
+func (s *Store) update(typ store.EventType, fn func(*bolt.Bucket) (*store.Entry, error)) (*store.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entry *store.Entry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = fn(tx.Bucket(bucketName))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.watch.Publish(store.Event{Type: typ, Entry: *entry})
+	return entry, nil
+}
+
+func put(b *bolt.Bucket, key string, value []byte) (*store.Entry, error) {
+	rev, err := b.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(raw, rev)
+	copy(raw[8:], value)
+	if err := b.Put([]byte(key), raw); err != nil {
+		return nil, err
+	}
+	return &store.Entry{Key: key, Value: append([]byte(nil), value...), Revision: rev}, nil
+}
+
+// decode copies a raw bolt value, which is only valid inside its
+// transaction, into an Entry.
+func decode(key string, raw []byte) *store.Entry {
+	return &store.Entry{
+		Key:      key,
+		Value:    append([]byte(nil), raw[8:]...),
+		Revision: binary.BigEndian.Uint64(raw[:8]),
+	}
+}
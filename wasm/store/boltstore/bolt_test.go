@@ -0,0 +1,19 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		s, err := Open(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
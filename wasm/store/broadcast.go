@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// watchBuffer is the per-subscriber event buffer. Subscribers that fall
+// further behind are disconnected rather than blocking writers.
+const watchBuffer = 64
+
+// Broadcaster fans store events out to prefix subscribers. Backends
+// without native change feeds use it to implement Watch. The set of
+// subscribers is copied on write, so that concurrent publishers only
+// contend on the subscribers they deliver to.
+type Broadcaster struct {
+	mu   sync.Mutex // serializes changes to subs
+	subs atomic.Pointer[[]*subscriber]
+}
+
+type subscriber struct {
+	prefix string
+
+	mu     sync.Mutex
+	closed bool
+	ch     chan Event
+}
+
+// NewBroadcaster creates a Broadcaster with no subscribers.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{}
+}
+
+// Subscribe returns a channel of events for keys starting with prefix.
+// The channel is closed when ctx is done or the subscriber falls behind.
+func (b *Broadcaster) Subscribe(ctx context.Context, prefix string) <-chan Event {
+	s := &subscriber{prefix: prefix, ch: make(chan Event, watchBuffer)}
+
+	b.mu.Lock()
+	var subs []*subscriber
+	if current := b.subs.Load(); current != nil {
+		subs = slices.Clone(*current)
+	}
+	subs = append(subs, s)
+	b.subs.Store(&subs)
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.remove(s)
+	}()
+
+	return s.ch
+}
+
+// Publish delivers ev to matching subscribers without blocking.
+func (b *Broadcaster) Publish(ev Event) {
+	subs := b.subs.Load()
+	if subs == nil {
+		return
+	}
+	for _, s := range *subs {
+		if strings.HasPrefix(ev.Entry.Key, s.prefix) && !s.send(ev) {
+			b.remove(s)
+		}
+	}
+}
+
+// send delivers ev unless the subscriber fell behind, in which case its
+// channel is closed.
+func (s *subscriber) send(ev Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.ch <- ev:
+		return true
+	default:
+		s.closeLocked()
+		return false
+	}
+}
+
+func (s *subscriber) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+func (b *Broadcaster) remove(s *subscriber) {
+	b.mu.Lock()
+	if current := b.subs.Load(); current != nil && slices.Contains(*current, s) {
+		subs := slices.DeleteFunc(slices.Clone(*current), func(t *subscriber) bool { return t == s })
+		b.subs.Store(&subs)
+	}
+	b.mu.Unlock()
+
+	s.mu.Lock()
+	s.closeLocked()
+	s.mu.Unlock()
+}
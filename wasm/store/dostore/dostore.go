@@ -0,0 +1,178 @@
+//go:build js
+
+// Package dostore implements store.Store on Cloudflare Durable Object
+// storage, reached through the DurableObjectStorage JS binding, so the
+// Worker build keeps registry state across isolate evictions.
+package dostore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsutil"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Entries are stored as { value: Uint8Array, revision: number } under
+// entryPrefix + key; the revision counter lives under revisionKey.
+const (
+	entryPrefix = "e:"
+	revisionKey = "m:revision"
+)
+
+// Store is a store.Store backed by DurableObjectStorage. A Durable Object
+// is the sole writer to its storage, so a process-level mutex is enough to
+// make read-modify-write operations atomic.
+type Store struct {
+	mu      sync.Mutex
+	storage js.Value
+	watch   *store.Broadcaster
+}
+
+var _ store.Store = (*Store)(nil)
+
+// New wraps a DurableObjectStorage object (ctx.storage in the Durable
+// Object constructor).
+func New(storage js.Value) *Store {
+	return &Store{storage: storage, watch: store.NewBroadcaster()}
+}
+
+// Get implements store.Store.
+func (s *Store) Get(_ context.Context, key string) (*store.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+// Put implements store.Store.
+func (s *Store) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(key, value)
+}
+
+// Delete implements store.Store.
+func (s *Store) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if _, err := jsutil.Await(s.storage.Call("delete", entryPrefix+key)); err != nil {
+		return err
+	}
+	s.watch.Publish(store.Event{Type: store.EventDelete, Entry: *entry})
+	return nil
+}
+
+// List implements store.Store.
+func (s *Store) List(_ context.Context, prefix string) ([]*store.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	opts := js.Global().Get("Object").New()
+	opts.Set("prefix", entryPrefix+prefix)
+	result, err := jsutil.Await(s.storage.Call("list", opts))
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := js.Global().Get("Array").Call("from", result.Call("entries"))
+	out := make([]*store.Entry, 0, pairs.Length())
+	for i := 0; i < pairs.Length(); i++ {
+		pair := pairs.Index(i)
+		key := strings.TrimPrefix(pair.Index(0).String(), entryPrefix)
+		entry, err := decode(key, pair.Index(1))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// CompareAndSwap implements store.Store.
+func (s *Store) CompareAndSwap(_ context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current uint64
+	entry, err := s.get(key)
+	switch {
+	case err == nil:
+		current = entry.Revision
+	case err != store.ErrNotFound:
+		return 0, err
+	}
+	if current != revision {
+		return 0, store.ErrConflict
+	}
+	return s.put(key, value)
+}
+
+// Watch implements store.Store. Writes made by this Durable Object
+// instance are observed.
+func (s *Store) Watch(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	return s.watch.Subscribe(ctx, prefix), nil
+}
+
+// Close implements store.Store.
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) get(key string) (*store.Entry, error) {
+	v, err := jsutil.Await(s.storage.Call("get", entryPrefix+key))
+	if err != nil {
+		return nil, err
+	}
+	if v.IsUndefined() || v.IsNull() {
+		return nil, store.ErrNotFound
+	}
+	return decode(key, v)
+}
+
+func (s *Store) put(key string, value []byte) (uint64, error) {
+	v, err := jsutil.Await(s.storage.Call("get", revisionKey))
+	if err != nil {
+		return 0, err
+	}
+	var rev uint64 = 1
+	if v.Type() == js.TypeNumber {
+		rev = uint64(v.Float()) + 1
+	}
+
+	obj := js.Global().Get("Object").New()
+	obj.Set("value", jsutil.BytesToJS(value))
+	obj.Set("revision", float64(rev))
+
+	// A multi-key put is atomic in Durable Object storage.
+	batch := js.Global().Get("Object").New()
+	batch.Set(entryPrefix+key, obj)
+	batch.Set(revisionKey, float64(rev))
+	if _, err := jsutil.Await(s.storage.Call("put", batch)); err != nil {
+		return 0, err
+	}
+
+	entry := &store.Entry{Key: key, Value: append([]byte(nil), value...), Revision: rev}
+	s.watch.Publish(store.Event{Type: store.EventPut, Entry: *entry})
+	return rev, nil
+}
+
+func decode(key string, v js.Value) (*store.Entry, error) {
+	value, err := jsutil.BytesFromJS(v.Get("value"))
+	if err != nil {
+		return nil, err
+	}
+	return &store.Entry{
+		Key:      key,
+		Value:    value,
+		Revision: uint64(v.Get("revision").Float()),
+	}, nil
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"hash/maphash"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryShards is the number of independently locked partitions of a
+// Memory store.
+const memoryShards = 64
+
+// Memory is a Store backed by in-process maps. Keys are spread over
+// shards with their own locks, so that writes to different keys, such as
+// a large colony re-registering, do not hold up reads of other keys.
+// Entries are never modified once stored, and each shard keeps a sorted
+// copy-on-write snapshot of its entries for List, rebuilt on the first
+// List after a write, so that listings neither hold locks while copying
+// nor sort the whole store. Events are published in revision order per
+// key.
+type Memory struct {
+	seed     maphash.Seed
+	shards   [memoryShards]memoryShard
+	revision atomic.Uint64
+	watch    *Broadcaster
+}
+
+type memoryShard struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	sorted  atomic.Pointer[[]*Entry] // nil after writes
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	m := &Memory{seed: maphash.MakeSeed(), watch: NewBroadcaster()}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]*Entry)
+	}
+	return m
+}
+
+func (m *Memory) shard(key string) *memoryShard {
+	return &m.shards[maphash.String(m.seed, key)%memoryShards]
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, key string) (*Entry, error) {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	e, ok := sh.entries[key]
+	sh.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e.clone(), nil
+}
+
+// Put implements Store.
+func (m *Memory) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return m.putLocked(sh, key, value), nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.entries[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(sh.entries, key)
+	sh.sorted.Store(nil)
+	m.watch.Publish(Event{Type: EventDelete, Entry: *e.clone()})
+	return nil
+}
+
+// List implements Store.
+func (m *Memory) List(_ context.Context, prefix string) ([]*Entry, error) {
+	out := make([]*Entry, 0)
+	for i := range m.shards {
+		sorted := m.shards[i].snapshot()
+		j := sort.Search(len(sorted), func(j int) bool { return sorted[j].Key >= prefix })
+		for ; j < len(sorted) && strings.HasPrefix(sorted[j].Key, prefix); j++ {
+			out = append(out, sorted[j].clone())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// CompareAndSwap implements Store.
+func (m *Memory) CompareAndSwap(_ context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	var current uint64
+	if e, ok := sh.entries[key]; ok {
+		current = e.Revision
+	}
+	if current != revision {
+		return 0, ErrConflict
+	}
+	return m.putLocked(sh, key, value), nil
+}
+
+// Watch implements Store.
+func (m *Memory) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return m.watch.Subscribe(ctx, prefix), nil
+}
+
+// Close implements Store.
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) putLocked(sh *memoryShard, key string, value []byte) uint64 {
+	e := &Entry{Key: key, Value: append([]byte(nil), value...), Revision: m.revision.Add(1)}
+	sh.entries[key] = e
+	sh.sorted.Store(nil)
+	m.watch.Publish(Event{Type: EventPut, Entry: *e.clone()})
+	return e.Revision
+}
+
+// snapshot returns the shard's entries sorted by key. The slice and its
+// entries must not be modified.
+func (sh *memoryShard) snapshot() []*Entry {
+	if sorted := sh.sorted.Load(); sorted != nil {
+		return *sorted
+	}
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if sorted := sh.sorted.Load(); sorted != nil {
+		return *sorted
+	}
+	sorted := make([]*Entry, 0, len(sh.entries))
+	for _, e := range sh.entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	sh.sorted.Store(&sorted)
+	return sorted
+}
+
+func (e *Entry) clone() *Entry {
+	c := *e
+	c.Value = append([]byte(nil), e.Value...)
+	return &c
+}
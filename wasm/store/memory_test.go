@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/storetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		return store.NewMemory()
+	})
+}
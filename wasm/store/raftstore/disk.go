@@ -0,0 +1,142 @@
+package raftstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	logBucket  = []byte("log")
+	metaBucket = []byte("meta")
+	termKey    = []byte("term")
+	voteKey    = []byte("vote")
+)
+
+// disk persists the term, vote and log in a BoltDB file. Log entries are
+// stored under their 8-byte big-endian index as an 8-byte term followed
+// by the command.
+type disk struct {
+	db *bolt.DB
+}
+
+func openDisk(path string) (*disk, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft log: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(logBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+	return &disk{db: db}, nil
+}
+
+// state returns the persisted term and vote.
+func (d *disk) state() (term uint64, vote string, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if raw := b.Get(termKey); len(raw) == 8 {
+			term = binary.BigEndian.Uint64(raw)
+		}
+		vote = string(b.Get(voteKey))
+		return nil
+	})
+	return term, vote, err
+}
+
+// setState persists term and vote; both must be durable before a vote
+// is granted or a term acted in.
+func (d *disk) setState(term uint64, vote string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		var raw [8]byte
+		binary.BigEndian.PutUint64(raw[:], term)
+		if err := b.Put(termKey, raw[:]); err != nil {
+			return err
+		}
+		return b.Put(voteKey, []byte(vote))
+	})
+}
+
+// entries returns the log entries after index, in order.
+func (d *disk) entries(after uint64) ([]entry, error) {
+	var out []entry
+	err := d.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(logBucket).Cursor()
+		for k, v := c.Seek(indexKey(after + 1)); k != nil; k, v = c.Next() {
+			out = append(out, entry{
+				Index: binary.BigEndian.Uint64(k),
+				Term:  binary.BigEndian.Uint64(v[:8]),
+				Data:  append([]byte(nil), v[8:]...),
+			})
+		}
+		return nil
+	})
+	return out, err
+}
+
+// write replaces the entries from index from on with entries.
+func (d *disk) write(from uint64, entries []entry) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		if err := deleteFrom(b, from); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			raw := make([]byte, 8+len(e.Data))
+			binary.BigEndian.PutUint64(raw, e.Term)
+			copy(raw[8:], e.Data)
+			if err := b.Put(indexKey(e.Index), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// compact deletes the entries up to index, which a snapshot covers, or
+// every entry when keep is false.
+func (d *disk) compact(index uint64, keep bool) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		if !keep {
+			return deleteFrom(b, 0)
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= index; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *disk) close() error {
+	return d.db.Close()
+}
+
+func deleteFrom(b *bolt.Bucket, index uint64) error {
+	c := b.Cursor()
+	for k, _ := c.Seek(indexKey(index)); k != nil; k, _ = c.Next() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexKey(index uint64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], index)
+	return k[:]
+}
@@ -0,0 +1,420 @@
+package raftstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/testutil"
+)
+
+// group is a Raft group whose members talk over a testutil.Network.
+type group struct {
+	t       *testing.T
+	ids     []string
+	network *testutil.Network
+	members map[string]*Store
+}
+
+// newGroup starts members named ids, each a host of the network.
+func newGroup(t *testing.T, ids ...string) *group {
+	t.Helper()
+	g := &group{t: t, ids: ids, network: testutil.NewNetwork(1), members: map[string]*Store{}}
+	for _, id := range ids {
+		peers := map[string]string{}
+		for _, peer := range ids {
+			if peer != id {
+				peers[peer] = "http://" + peer
+			}
+		}
+		s, err := Open(Config{
+			ID:                id,
+			Peers:             peers,
+			Dir:               t.TempDir(),
+			Secret:            []byte("secret"),
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			HTTPClient:        g.network.Client(id),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		g.network.Handle(id, s.Handler())
+		g.members[id] = s
+	}
+	return g
+}
+
+// others returns the IDs of the members other than ids.
+func (g *group) others(ids ...string) []string {
+	var out []string
+	for _, id := range g.ids {
+		if !slices.Contains(ids, id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// leader waits until the members ids, or all members when none are
+// given, follow one of them in the latest term among them, and returns
+// it.
+func (g *group) leader(ids ...string) *Store {
+	g.t.Helper()
+	if len(ids) == 0 {
+		ids = g.ids
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if s := g.agreed(ids); s != nil {
+			return s
+		}
+	}
+	g.t.Fatalf("members %v elected no leader", ids)
+	return nil
+}
+
+func (g *group) agreed(ids []string) *Store {
+	var lead *Store
+	var term uint64
+	for _, id := range ids {
+		s := g.members[id]
+		s.mu.Lock()
+		if s.role == leader && s.term >= term {
+			lead, term = s, s.term
+		}
+		s.mu.Unlock()
+	}
+	if lead == nil {
+		return nil
+	}
+	for _, id := range ids {
+		s := g.members[id]
+		s.mu.Lock()
+		ok := s.term == term && s.leader == lead.id
+		s.mu.Unlock()
+		if !ok {
+			return nil
+		}
+	}
+	return lead
+}
+
+// term returns the current term of s.
+func term(s *Store) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term
+}
+
+// get returns the value of key read through s, failing the test on
+// error.
+func get(t *testing.T, s *Store, key string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("get %s through %s: %v", key, s.id, err)
+	}
+	return string(e.Value)
+}
+
+func put(t *testing.T, s *Store, key, value string) uint64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	revision, err := s.Put(ctx, key, []byte(value))
+	if err != nil {
+		t.Fatalf("put %s through %s: %v", key, s.id, err)
+	}
+	return revision
+}
+
+// eventually retries check until it returns nil, failing the test with
+// its last error after five seconds.
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err = check(); err == nil {
+			return
+		}
+	}
+	t.Fatal(err)
+}
+
+// TestFollowers checks that every member serves the whole Store,
+// forwarding writes and reads to the leader.
+func TestFollowers(t *testing.T) {
+	g := newGroup(t, "n1", "n2", "n3")
+	ctx := context.Background()
+	lead := g.leader()
+	follower := g.members[g.others(lead.id)[0]]
+
+	revision := put(t, follower, "k", "v1")
+	for _, s := range g.members {
+		if v := get(t, s, "k"); v != "v1" {
+			t.Fatalf("%s reads %q, want v1", s.id, v)
+		}
+	}
+	if _, err := follower.CompareAndSwap(ctx, "k", revision+1, []byte("v2")); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("swap at a wrong revision through a follower: %v, want ErrConflict", err)
+	}
+	if _, err := follower.CompareAndSwap(ctx, "k", revision, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Delete(ctx, "k"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("second delete: %v, want ErrNotFound", err)
+	}
+}
+
+// TestLeaderChange cuts the leader off and checks that the others elect
+// a new one in a later term, that writes go on through it and that the
+// old leader follows it once the partition heals.
+func TestLeaderChange(t *testing.T) {
+	g := newGroup(t, "n1", "n2", "n3")
+	old := g.leader()
+	put(t, old, "k", "v1")
+	oldTerm := term(old)
+
+	rest := g.others(old.id)
+	g.network.Partition([]string{old.id}, rest)
+	next := g.leader(rest...)
+	if next == old || term(next) <= oldTerm {
+		t.Fatalf("new leader %s in term %d, after %s in term %d", next.id, term(next), old.id, oldTerm)
+	}
+	put(t, g.members[rest[0]], "k", "v2")
+	if v := get(t, g.members[rest[1]], "k"); v != "v2" {
+		t.Fatalf("read %q after the new leader committed v2", v)
+	}
+
+	g.network.Heal()
+	if lead := g.leader(); lead != next && term(lead) <= term(next) {
+		t.Fatalf("leader %s after healing, want %s or a later term", lead.id, next.id)
+	}
+	if v := get(t, old, "k"); v != "v2" {
+		t.Fatalf("old leader reads %q after healing, want v2", v)
+	}
+}
+
+// TestMinorityFailure checks that a group of five commits with two
+// members cut off, and that the two cannot write or read.
+func TestMinorityFailure(t *testing.T) {
+	g := newGroup(t, "n1", "n2", "n3", "n4", "n5")
+	lead := g.leader()
+	minority := g.others(lead.id)[:2]
+	g.network.Partition(minority, g.others(minority...))
+
+	for i := range 5 {
+		put(t, lead, "k", fmt.Sprint(i))
+	}
+	for _, id := range g.others(minority...) {
+		if v := get(t, g.members[id], "k"); v != "4" {
+			t.Fatalf("%s reads %q, want 4", id, v)
+		}
+	}
+
+	for _, id := range minority {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		if _, err := g.members[id].Put(ctx, "k", []byte("minority")); err == nil {
+			t.Fatalf("%s wrote without a majority", id)
+		}
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+		if e, err := g.members[id].Get(ctx, "k"); err == nil {
+			t.Fatalf("%s read %q without a majority", id, e.Value)
+		}
+		cancel()
+	}
+
+	g.network.Heal()
+	for _, s := range g.members {
+		if v := get(t, s, "k"); v != "4" {
+			t.Fatalf("%s reads %q after healing, want 4", s.id, v)
+		}
+	}
+}
+
+// TestLogConflict has a leader cut off with one follower log an entry it
+// cannot commit, and checks that the entry is replaced by those of the
+// next leader once the partition heals.
+func TestLogConflict(t *testing.T) {
+	g := newGroup(t, "n1", "n2", "n3", "n4", "n5")
+	old := g.leader()
+	put(t, old, "k", "v1")
+	minority := []string{old.id, g.others(old.id)[0]}
+	g.network.Partition(minority, g.others(minority...))
+
+	stale := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := old.Put(ctx, "k", []byte("stale"))
+		stale <- err
+	}()
+	// Wait until the old leader and its follower logged the stale entry.
+	var index, staleTerm uint64
+	eventually(t, func() error {
+		old.mu.Lock()
+		index, staleTerm = old.lastIndexLocked(), old.term
+		commit := old.commit
+		old.mu.Unlock()
+		f := g.members[minority[1]]
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if index <= commit || f.lastIndexLocked() < index {
+			return fmt.Errorf("follower logged up to %d, leader %d of which %d committed", f.lastIndexLocked(), index, commit)
+		}
+		return nil
+	})
+
+	majority := g.others(minority...)
+	g.leader(majority...)
+	for i := range 3 {
+		put(t, g.members[majority[0]], "k", fmt.Sprint("v", i+2))
+	}
+
+	g.network.Heal()
+	if err := <-stale; !errors.Is(err, ErrLeadershipLost) {
+		t.Fatalf("write of the deposed leader: %v, want ErrLeadershipLost", err)
+	}
+	g.leader()
+	for _, s := range g.members {
+		if v := get(t, s, "k"); v != "v4" {
+			t.Fatalf("%s reads %q, want v4", s.id, v)
+		}
+	}
+	// Every member holds the same log, without the stale entry.
+	want := g.members[majority[0]]
+	eventually(t, func() error {
+		want.mu.Lock()
+		last := want.lastIndexLocked()
+		terms := make([]uint64, last+1)
+		for i := range terms {
+			terms[i] = want.termAtLocked(uint64(i))
+		}
+		want.mu.Unlock()
+		for _, s := range g.members {
+			s.mu.Lock()
+			got := make([]uint64, s.lastIndexLocked()+1)
+			for i := range got {
+				got[i] = s.termAtLocked(uint64(i))
+			}
+			s.mu.Unlock()
+			if !slices.Equal(got, terms) {
+				return fmt.Errorf("%s logged terms %v, %s %v", s.id, got, want.id, terms)
+			}
+		}
+		if terms[index] == staleTerm {
+			return fmt.Errorf("entry %d is still of the stale term %d", index, staleTerm)
+		}
+		return nil
+	})
+}
+
+// TestAcceptConflict appends to a member by hand and checks that it
+// truncates its log at the first entry of another term, on disk as well,
+// and points the leader before the conflicting term.
+func TestAcceptConflict(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		ID:              "n1",
+		Peers:           map[string]string{"n2": "http://n2"},
+		Dir:             dir,
+		Secret:          []byte("secret"),
+		ElectionTimeout: time.Hour,
+		HTTPClient:      testutil.NewNetwork(1).Client("n1"),
+	}
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accept := func(req appendRequest) appendResponse {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.acceptLocked(req)
+	}
+	terms := func() []uint64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var out []uint64
+		for _, e := range s.log {
+			out = append(out, e.Term)
+		}
+		return out
+	}
+
+	if resp := accept(appendRequest{Term: 2, Leader: "n2", Entries: []entry{{Index: 1, Term: 1}, {Index: 2, Term: 2}, {Index: 3, Term: 2}, {Index: 4, Term: 2}}}); !resp.Success {
+		t.Fatalf("append to an empty log: %+v", resp)
+	}
+	// A leader of term 3 holds another entry 3: the member answers with
+	// the index before its entries of term 2.
+	resp := accept(appendRequest{Term: 3, Leader: "n2", PrevIndex: 3, PrevTerm: 3})
+	if resp.Success || resp.LastIndex != 1 {
+		t.Fatalf("append after a conflicting entry: %+v, want failure at index 1", resp)
+	}
+	resp = accept(appendRequest{Term: 3, Leader: "n2", PrevIndex: 2, PrevTerm: 2, Entries: []entry{{Index: 3, Term: 3}}})
+	if !resp.Success || resp.LastIndex != 3 {
+		t.Fatalf("append replacing entry 3: %+v", resp)
+	}
+	if got := terms(); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Fatalf("logged terms %v, want [1 2 3]", got)
+	}
+	// Entries the member holds already do not truncate it.
+	if resp := accept(appendRequest{Term: 3, Leader: "n2", PrevIndex: 1, PrevTerm: 1, Entries: []entry{{Index: 2, Term: 2}}}); !resp.Success {
+		t.Fatalf("append of a logged entry: %+v", resp)
+	}
+	if got := terms(); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Fatalf("logged terms %v after a repeated append, want [1 2 3]", got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := terms(); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Fatalf("logged terms %v after reopening, want [1 2 3]", got)
+	}
+}
+
+// TestReadIndex checks that a read through any member observes every
+// write acknowledged before it began, and that a deposed leader does
+// not serve reads.
+func TestReadIndex(t *testing.T) {
+	g := newGroup(t, "n1", "n2", "n3")
+	g.leader()
+	for i := range 30 {
+		value := fmt.Sprint(i)
+		put(t, g.members[g.ids[i%3]], "k", value)
+		if v := get(t, g.members[g.ids[(i+1)%3]], "k"); v != value {
+			t.Fatalf("read %q after writing %q", v, value)
+		}
+	}
+
+	old := g.leader()
+	rest := g.others(old.id)
+	g.network.Partition([]string{old.id}, rest)
+	g.leader(rest...)
+	put(t, g.members[rest[0]], "k", "new")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if e, err := old.Get(ctx, "k"); err == nil {
+		t.Fatalf("deposed leader read %q", e.Value)
+	}
+	g.network.Heal()
+	if v := get(t, old, "k"); v != "new" {
+		t.Fatalf("deposed leader reads %q after healing, want new", v)
+	}
+}
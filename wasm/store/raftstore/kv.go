@@ -0,0 +1,252 @@
+package raftstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Command operations.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opCAS    = "cas"
+)
+
+// command is the data of a log entry. Every write takes the index of its
+// entry as its revision, so that the members agree on revisions.
+type command struct {
+	Op       string `json:"op"`
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// execLocked applies e to the keys.
+func (s *Store) execLocked(e entry) (uint64, error) {
+	if e.Data == nil {
+		return 0, nil
+	}
+	var cmd command
+	if err := json.Unmarshal(e.Data, &cmd); err != nil {
+		return 0, fmt.Errorf("raftstore: invalid command at index %d: %w", e.Index, err)
+	}
+	current, ok := s.kv[cmd.Key]
+	switch cmd.Op {
+	case opDelete:
+		if !ok {
+			return 0, store.ErrNotFound
+		}
+		delete(s.kv, cmd.Key)
+		s.watch.Publish(store.Event{Type: store.EventDelete, Entry: *clone(current)})
+		return 0, nil
+	case opCAS:
+		var revision uint64
+		if ok {
+			revision = current.Revision
+		}
+		if revision != cmd.Revision {
+			return 0, store.ErrConflict
+		}
+	}
+	next := &store.Entry{Key: cmd.Key, Value: cmd.Value, Revision: e.Index}
+	s.kv[cmd.Key] = next
+	s.watch.Publish(store.Event{Type: store.EventPut, Entry: *clone(next)})
+	return e.Index, nil
+}
+
+// restoreLocked replaces the keys with kv, publishing the differences.
+func (s *Store) restoreLocked(kv map[string]*store.Entry) {
+	for key, e := range s.kv {
+		if _, ok := kv[key]; !ok {
+			s.watch.Publish(store.Event{Type: store.EventDelete, Entry: *clone(e)})
+		}
+	}
+	for key, e := range kv {
+		if old, ok := s.kv[key]; !ok || old.Revision != e.Revision || !bytes.Equal(old.Value, e.Value) {
+			s.watch.Publish(store.Event{Type: store.EventPut, Entry: *clone(e)})
+		}
+	}
+	s.kv = kv
+}
+
+// propose commits cmd and returns the revision it was applied at.
+func (s *Store) propose(ctx context.Context, cmd command) (uint64, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	var revision uint64
+	err = s.lead(ctx, func() error {
+		revision, err = s.proposeLocal(ctx, data)
+		return err
+	}, func(url string) error {
+		var resp proposeResponse
+		err := s.call(ctx, url, proposePath, proposeRequest{Command: data}, &resp)
+		var dial *net.OpError
+		if err != nil && ctx.Err() == nil && ((errors.As(err, &dial) && dial.Op == "dial") || (isTransport(err) && cmd.Op == opPut)) {
+			// The leader never got the command, or it may have but
+			// applying a put twice is harmless: try whoever leads next.
+			return fmt.Errorf("%w: %v", errNotLeader, err)
+		}
+		revision = resp.Revision
+		return err
+	})
+	return revision, err
+}
+
+// proposeLocal appends data to the log of this member, which must lead,
+// and waits until it is applied.
+func (s *Store) proposeLocal(ctx context.Context, data []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.role != leader {
+		return 0, errNotLeader
+	}
+	index := s.lastIndexLocked() + 1
+	r := &result{term: s.term}
+	s.results[index] = r
+	if _, err := s.appendLocked(data); err != nil {
+		delete(s.results, index)
+		return 0, err
+	}
+	s.replicateLocked()
+	if err := s.wait(ctx, func() bool { return r.done }); err != nil {
+		delete(s.results, index)
+		return 0, err
+	}
+	return r.revision, r.err
+}
+
+// sync waits until this member applied every write committed before it
+// was called, so that the keys it holds can be read.
+func (s *Store) sync(ctx context.Context) error {
+	var index uint64
+	err := s.lead(ctx, func() error {
+		var err error
+		index, err = s.readIndexLocal(ctx)
+		return err
+	}, func(url string) error {
+		var resp readResponse
+		if err := s.call(ctx, url, readPath, struct{}{}, &resp); err != nil {
+			// Ask whoever leads next; reads are safe to retry.
+			return fmt.Errorf("%w: %v", errNotLeader, err)
+		}
+		index = resp.Index
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wait(ctx, func() bool { return s.applied >= index })
+}
+
+// readIndexLocal returns the commit index of this member, which must
+// lead, once a majority confirmed that it still does.
+func (s *Store) readIndexLocal(ctx context.Context) (uint64, error) {
+	s.mu.Lock()
+	// The commit index is only known once an entry of the term committed.
+	err := s.wait(ctx, func() bool { return s.role != leader || s.termAtLocked(s.commit) == s.term })
+	index, leading := s.commit, s.role == leader
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if !leading {
+		return 0, errNotLeader
+	}
+	if len(s.peers) == 0 {
+		return index, nil
+	}
+
+	acks := make(chan bool, len(s.peers))
+	for id := range s.peers {
+		go func() {
+			ok, _ := s.sendAppend(id)
+			acks <- ok
+		}()
+	}
+	confirmed := 1
+	for range s.peers {
+		select {
+		case ok := <-acks:
+			if ok {
+				confirmed++
+			}
+			if confirmed >= s.quorum() {
+				return index, nil
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return 0, errNotLeader
+}
+
+// Get implements store.Store.
+func (s *Store) Get(ctx context.Context, key string) (*store.Entry, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.kv[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return clone(e), nil
+}
+
+// Put implements store.Store.
+func (s *Store) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	return s.propose(ctx, command{Op: opPut, Key: key, Value: value})
+}
+
+// Delete implements store.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.propose(ctx, command{Op: opDelete, Key: key})
+	return err
+}
+
+// List implements store.Store.
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.Entry, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*store.Entry, 0)
+	for k, e := range s.kv {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, clone(e))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// CompareAndSwap implements store.Store.
+func (s *Store) CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	return s.propose(ctx, command{Op: opCAS, Key: key, Value: value, Revision: revision})
+}
+
+// Watch implements store.Store. Every member observes every committed
+// write as it applies it.
+func (s *Store) Watch(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	return s.watch.Subscribe(ctx, prefix), nil
+}
+
+func clone(e *store.Entry) *store.Entry {
+	c := *e
+	c.Value = append([]byte(nil), e.Value...)
+	return &c
+}
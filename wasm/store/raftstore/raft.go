@@ -0,0 +1,649 @@
+// Package raftstore implements store.Store on a Raft group of corald
+// instances, for deployments whose threat model rules out eventual
+// consistency, notably for revocations. Writes are acknowledged once a
+// majority of the group logged them, and reads are served once the
+// instance reading applied every write committed before the read began
+// (a ReadIndex read), so both are linearizable. Followers forward writes
+// and read indexes to the leader, so every instance serves the whole
+// Store.
+//
+// The group is fixed by Config.Peers. Members talk over HTTP, with the
+// Handler of each served on its own listener, authenticated by a shared
+// secret. Terms, votes and the log are kept in a BoltDB file in
+// Config.Dir, and compacted into a snapshot of the keys framed like
+// registry snapshots once Config.SnapshotEvery entries were applied.
+// Members too far behind are sent the snapshot.
+package raftstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Defaults used when the Config leaves a field zero.
+const (
+	DefaultElectionTimeout   = time.Second
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultSnapshotEvery     = 8192
+)
+
+// maxBatch bounds the entries sent in one append.
+const maxBatch = 512
+
+var (
+	// ErrNoLeader is returned when no leader was elected within four
+	// election timeouts, for example while a majority is down.
+	ErrNoLeader = errors.New("no raft leader")
+
+	// ErrLeadershipLost is returned for writes whose leader stepped down
+	// before committing them. They may or may not have been committed.
+	ErrLeadershipLost = errors.New("raft leadership lost")
+
+	// ErrUnauthorized is returned for RPCs without the group's secret.
+	ErrUnauthorized = errors.New("invalid raft secret")
+
+	// ErrClosed is returned once the Store is closed.
+	ErrClosed = errors.New("raft store closed")
+
+	// errNotLeader is answered by members asked to lead while not
+	// leading; callers retry with the leader.
+	errNotLeader = errors.New("not the raft leader")
+)
+
+// Config holds the configuration for a Store.
+type Config struct {
+	// ID names this member. Required.
+	ID string
+
+	// Peers maps the IDs of the other members to the base URLs their
+	// Handler is served at. Empty for a group of one.
+	Peers map[string]string
+
+	// Dir holds the log and snapshot. Required.
+	Dir string
+
+	// Secret is shared by the members and authenticates their RPCs.
+	// Required.
+	Secret []byte
+
+	// ElectionTimeout is how long a follower waits for the leader before
+	// standing for election, randomized up to twice as long. Defaults to
+	// DefaultElectionTimeout.
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is how often the leader appends to idle
+	// followers. Defaults to DefaultHeartbeatInterval.
+	HeartbeatInterval time.Duration
+
+	// SnapshotEvery is the number of applied entries after which the log
+	// is compacted into a snapshot. Defaults to DefaultSnapshotEvery.
+	SnapshotEvery uint64
+
+	// HTTPClient reaches the other members. Defaults to a client with a
+	// 10s timeout.
+	HTTPClient *http.Client
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// entry is a log entry. Data is a command, or nil for the entry a new
+// leader appends to commit the entries of earlier terms.
+type entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// result is the outcome of a write proposed by this member, awaited by
+// the proposer.
+type result struct {
+	term     uint64
+	done     bool
+	revision uint64
+	err      error
+}
+
+// Store is a store.Store replicated with Raft. It is safe for concurrent
+// use.
+type Store struct {
+	id            string
+	peers         map[string]string
+	secret        []byte
+	election      time.Duration
+	heartbeat     time.Duration
+	snapshotEvery uint64
+	http          *http.Client
+	dir           string
+	disk          *disk
+	watch         *store.Broadcaster
+	mux           *http.ServeMux
+	done          chan struct{}
+	wg            sync.WaitGroup
+
+	mu        sync.Mutex
+	changed   *sync.Cond // on role, leader, commit and apply changes
+	closed    bool
+	term      uint64
+	vote      string
+	role      role
+	leader    string
+	log       []entry // the entries after snapIndex
+	snapIndex uint64
+	snapTerm  uint64
+	commit    uint64
+	applied   uint64
+	deadline  time.Time // of the election timeout
+	next      map[string]uint64
+	match     map[string]uint64
+	busy      map[string]bool // peers with appends in flight
+	results   map[uint64]*result
+	kv        map[string]*store.Entry
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens the member's log in cfg.Dir, creating it when missing, and
+// starts taking part in the group. Serve Handler to the other members.
+func Open(cfg Config) (*Store, error) {
+	if cfg.ID == "" || cfg.Dir == "" || len(cfg.Secret) == 0 {
+		return nil, errors.New("raftstore: ID, Dir and Secret are required")
+	}
+	if _, ok := cfg.Peers[cfg.ID]; ok {
+		return nil, errors.New("raftstore: Peers must not include ID")
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.SnapshotEvery == 0 {
+		cfg.SnapshotEvery = DefaultSnapshotEvery
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	d, err := openDisk(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		id:            cfg.ID,
+		peers:         cfg.Peers,
+		secret:        cfg.Secret,
+		election:      cfg.ElectionTimeout,
+		heartbeat:     cfg.HeartbeatInterval,
+		snapshotEvery: cfg.SnapshotEvery,
+		http:          cfg.HTTPClient,
+		dir:           cfg.Dir,
+		disk:          d,
+		watch:         store.NewBroadcaster(),
+		done:          make(chan struct{}),
+		next:          make(map[string]uint64),
+		match:         make(map[string]uint64),
+		busy:          make(map[string]bool),
+		results:       make(map[uint64]*result),
+		kv:            make(map[string]*store.Entry),
+	}
+	s.changed = sync.NewCond(&s.mu)
+	if err := s.load(); err != nil {
+		d.close()
+		return nil, err
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST "+appendPath, s.handleAppend)
+	s.mux.HandleFunc("POST "+votePath, s.handleVote)
+	s.mux.HandleFunc("POST "+snapshotPath, s.handleSnapshot)
+	s.mux.HandleFunc("POST "+proposePath, s.handlePropose)
+	s.mux.HandleFunc("POST "+readPath, s.handleRead)
+	s.resetDeadlineLocked()
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// load restores the persisted term, vote, snapshot and log.
+func (s *Store) load() error {
+	var err error
+	if s.term, s.vote, err = s.disk.state(); err != nil {
+		return err
+	}
+	data, err := readSnapshot(s.dir)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if s.snapIndex, s.snapTerm, s.kv, err = decodeSnapshot(data); err != nil {
+			return err
+		}
+		s.commit, s.applied = s.snapIndex, s.snapIndex
+	}
+	entries, err := s.disk.entries(s.snapIndex)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if e.Index != s.snapIndex+uint64(i)+1 {
+			return fmt.Errorf("raftstore: log has a gap at index %d", s.snapIndex+uint64(i)+1)
+		}
+	}
+	s.log = entries
+	return nil
+}
+
+// Close stops taking part in the group and closes the log. Watches are
+// not closed; cancel their contexts.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.changed.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return s.disk.close()
+}
+
+// Leader returns the ID of the current leader, or "" while there is
+// none known.
+func (s *Store) Leader() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// run sends heartbeats while leading and stands for election when the
+// election timeout passes without hearing from a leader.
+func (s *Store) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		switch {
+		case s.role == leader:
+			s.replicateLocked()
+		case time.Now().After(s.deadline):
+			s.campaignLocked()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Store) quorum() int {
+	return (len(s.peers)+1)/2 + 1
+}
+
+func (s *Store) resetDeadlineLocked() {
+	s.deadline = time.Now().Add(s.election + rand.N(s.election))
+}
+
+func (s *Store) lastIndexLocked() uint64 {
+	return s.snapIndex + uint64(len(s.log))
+}
+
+// termAtLocked returns the term of the entry at index, or 0 when it is
+// not in the log.
+func (s *Store) termAtLocked(index uint64) uint64 {
+	switch {
+	case index == s.snapIndex:
+		return s.snapTerm
+	case index < s.snapIndex || index > s.lastIndexLocked():
+		return 0
+	}
+	return s.log[index-s.snapIndex-1].Term
+}
+
+// setStateLocked persists and adopts term and vote.
+func (s *Store) setStateLocked(term uint64, vote string) error {
+	if err := s.disk.setState(term, vote); err != nil {
+		return err
+	}
+	s.term, s.vote = term, vote
+	return nil
+}
+
+// stepDownLocked follows in term, which is at least the current one.
+// Writes awaiting commit by this member as leader fail with
+// ErrLeadershipLost.
+func (s *Store) stepDownLocked(term uint64) {
+	if term > s.term {
+		if err := s.setStateLocked(term, ""); err != nil {
+			log.Printf("raftstore: failed to persist term: %v", err)
+		}
+		s.leader = ""
+	}
+	if s.role == leader {
+		s.leader = ""
+		for index, r := range s.results {
+			r.done, r.err = true, ErrLeadershipLost
+			delete(s.results, index)
+		}
+	}
+	if s.role != follower {
+		s.role = follower
+		s.resetDeadlineLocked()
+	}
+	s.changed.Broadcast()
+}
+
+// campaignLocked stands for election in the next term.
+func (s *Store) campaignLocked() {
+	if err := s.setStateLocked(s.term+1, s.id); err != nil {
+		log.Printf("raftstore: failed to persist vote: %v", err)
+		s.resetDeadlineLocked()
+		return
+	}
+	s.role, s.leader = candidate, ""
+	s.resetDeadlineLocked()
+	s.changed.Broadcast()
+	if len(s.peers) == 0 {
+		s.leadLocked()
+		return
+	}
+
+	req := voteRequest{Term: s.term, Candidate: s.id, LastIndex: s.lastIndexLocked(), LastTerm: s.termAtLocked(s.lastIndexLocked())}
+	votes := 1
+	for _, url := range s.peers {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), s.election)
+			defer cancel()
+			var resp voteResponse
+			if err := s.call(ctx, url, votePath, req, &resp); err != nil {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if resp.Term > s.term {
+				s.stepDownLocked(resp.Term)
+				return
+			}
+			if !resp.Granted || s.role != candidate || s.term != req.Term {
+				return
+			}
+			if votes++; votes == s.quorum() {
+				s.leadLocked()
+			}
+		}()
+	}
+}
+
+// leadLocked takes the lead, appending an empty entry so that the
+// entries of earlier terms commit with it.
+func (s *Store) leadLocked() {
+	s.role, s.leader = leader, s.id
+	for id := range s.peers {
+		s.next[id], s.match[id] = s.lastIndexLocked()+1, 0
+	}
+	if _, err := s.appendLocked(nil); err != nil {
+		log.Printf("raftstore: failed to append: %v", err)
+		s.stepDownLocked(s.term)
+		return
+	}
+	s.changed.Broadcast()
+	s.replicateLocked()
+}
+
+// appendLocked appends data to the leader's log and returns its index.
+func (s *Store) appendLocked(data []byte) (uint64, error) {
+	e := entry{Index: s.lastIndexLocked() + 1, Term: s.term, Data: data}
+	if err := s.disk.write(e.Index, []entry{e}); err != nil {
+		return 0, err
+	}
+	s.log = append(s.log, e)
+	s.advanceLocked()
+	return e.Index, nil
+}
+
+// replicateLocked starts appending to each follower without an append
+// in flight.
+func (s *Store) replicateLocked() {
+	for id := range s.peers {
+		if !s.busy[id] {
+			s.busy[id] = true
+			go s.replicate(id)
+		}
+	}
+}
+
+// replicate appends to the follower id until it has the whole log or
+// stops answering.
+func (s *Store) replicate(id string) {
+	for {
+		_, err := s.sendAppend(id)
+		s.mu.Lock()
+		if err != nil || s.role != leader || s.match[id] >= s.lastIndexLocked() {
+			s.busy[id] = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sendAppend sends the follower id the entries from its next index, or
+// the snapshot when they were compacted. It reports whether the
+// follower acknowledged this member's lead.
+func (s *Store) sendAppend(id string) (bool, error) {
+	s.mu.Lock()
+	if s.role != leader {
+		s.mu.Unlock()
+		return false, nil
+	}
+	if s.next[id] <= s.snapIndex {
+		s.mu.Unlock()
+		return s.sendSnapshot(id)
+	}
+	prev := s.next[id] - 1
+	batch := s.log[prev-s.snapIndex:]
+	batch = append([]entry(nil), batch[:min(len(batch), maxBatch)]...)
+	req := appendRequest{
+		Term:      s.term,
+		Leader:    s.id,
+		PrevIndex: prev,
+		PrevTerm:  s.termAtLocked(prev),
+		Entries:   batch,
+		Commit:    s.commit,
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.election)
+	defer cancel()
+	var resp appendResponse
+	if err := s.call(ctx, s.peers[id], appendPath, req, &resp); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp.Term > s.term {
+		s.stepDownLocked(resp.Term)
+		return false, nil
+	}
+	if s.role != leader || s.term != req.Term {
+		return false, nil
+	}
+	if resp.Success {
+		s.match[id] = max(s.match[id], req.PrevIndex+uint64(len(req.Entries)))
+		s.next[id] = s.match[id] + 1
+		s.advanceLocked()
+	} else {
+		s.next[id] = max(1, min(req.PrevIndex, resp.LastIndex+1))
+	}
+	return true, nil
+}
+
+// sendSnapshot sends the follower id the snapshot.
+func (s *Store) sendSnapshot(id string) (bool, error) {
+	data, err := readSnapshot(s.dir)
+	if err != nil || data == nil {
+		return false, fmt.Errorf("raftstore: no snapshot to send: %v", err)
+	}
+	index, _, _, err := decodeSnapshot(data)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	req := snapshotRequest{Term: s.term, Leader: s.id, Data: data}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*s.election)
+	defer cancel()
+	var resp snapshotResponse
+	if err := s.call(ctx, s.peers[id], snapshotPath, req, &resp); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp.Term > s.term {
+		s.stepDownLocked(resp.Term)
+		return false, nil
+	}
+	if s.role != leader || s.term != req.Term {
+		return false, nil
+	}
+	s.match[id] = max(s.match[id], index)
+	s.next[id] = s.match[id] + 1
+	return true, nil
+}
+
+// advanceLocked commits the last entry of the current term a majority
+// logged, with those before it.
+func (s *Store) advanceLocked() {
+	for n := s.lastIndexLocked(); n > s.commit && s.termAtLocked(n) == s.term; n-- {
+		count := 1
+		for id := range s.peers {
+			if s.match[id] >= n {
+				count++
+			}
+		}
+		if count >= s.quorum() {
+			s.commit = n
+			s.applyLocked()
+			return
+		}
+	}
+}
+
+// applyLocked applies the committed entries not applied yet, then
+// compacts the log when due.
+func (s *Store) applyLocked() {
+	for s.applied < s.commit {
+		e := s.log[s.applied-s.snapIndex]
+		revision, err := s.execLocked(e)
+		s.applied = e.Index
+		if r, ok := s.results[e.Index]; ok {
+			if r.term != e.Term {
+				err = ErrLeadershipLost
+			}
+			r.done, r.revision, r.err = true, revision, err
+			delete(s.results, e.Index)
+		}
+	}
+	s.changed.Broadcast()
+
+	if s.applied-s.snapIndex >= s.snapshotEvery {
+		if err := s.snapshotLocked(); err != nil {
+			log.Printf("raftstore: snapshot failed: %v", err)
+		}
+	}
+}
+
+// snapshotLocked compacts the applied entries into a snapshot.
+func (s *Store) snapshotLocked() error {
+	index, term := s.applied, s.termAtLocked(s.applied)
+	var buf bytes.Buffer
+	if err := encodeSnapshot(&buf, index, term, s.kv); err != nil {
+		return err
+	}
+	if err := writeSnapshot(s.dir, buf.Bytes()); err != nil {
+		return err
+	}
+	s.log = append([]entry(nil), s.log[index-s.snapIndex:]...)
+	s.snapIndex, s.snapTerm = index, term
+	return s.disk.compact(index, true)
+}
+
+// wait blocks until ok holds, ctx is done or the Store is closed. s.mu
+// is held.
+func (s *Store) wait(ctx context.Context, ok func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.changed.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+	for !ok() {
+		if s.closed {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.changed.Wait()
+	}
+	return nil
+}
+
+// lead runs local on the leader, or remote with the leader's URL on
+// followers. Both return errNotLeader when the member asked is not
+// leading, upon which lead retries while a leader is elected.
+func (s *Store) lead(ctx context.Context, local func() error, remote func(url string) error) error {
+	deadline := time.Now().Add(4 * s.election)
+	for {
+		s.mu.Lock()
+		leading, url := s.role == leader, s.peers[s.leader]
+		s.mu.Unlock()
+		err := errNotLeader
+		switch {
+		case leading:
+			err = local()
+		case url != "":
+			err = remote(url)
+		}
+		if !errors.Is(err, errNotLeader) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrNoLeader
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return ErrClosed
+		case <-time.After(s.heartbeat):
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package raftstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		s, err := Open(Config{
+			ID:                "n1",
+			Dir:               t.TempDir(),
+			Secret:            []byte("secret"),
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
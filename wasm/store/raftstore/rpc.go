@@ -0,0 +1,333 @@
+package raftstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Routes of the Handler.
+const (
+	appendPath   = "/raft/append"
+	votePath     = "/raft/vote"
+	snapshotPath = "/raft/snapshot"
+	proposePath  = "/raft/propose"
+	readPath     = "/raft/read"
+)
+
+// maxRPCSize bounds RPC bodies; snapshots are sent whole.
+const maxRPCSize = 1 << 30
+
+type appendRequest struct {
+	Term      uint64  `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex uint64  `json:"prev_index"`
+	PrevTerm  uint64  `json:"prev_term"`
+	Entries   []entry `json:"entries"`
+	Commit    uint64  `json:"commit"`
+}
+
+// appendResponse carries, on failure, the index the leader should
+// resume appending after.
+type appendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+type voteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+}
+
+type voteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type snapshotRequest struct {
+	Term   uint64 `json:"term"`
+	Leader string `json:"leader"`
+	Data   []byte `json:"data"`
+}
+
+type snapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+type proposeRequest struct {
+	Command []byte `json:"command"`
+}
+
+type proposeResponse struct {
+	Revision uint64 `json:"revision"`
+}
+
+type readResponse struct {
+	Index uint64 `json:"index"`
+}
+
+// Handler returns the handler the other members call. Serve it on a
+// listener they reach at the URL given in their Config.Peers.
+func (s *Store) Handler() http.Handler {
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	got, want := sha256.Sum256([]byte(token)), sha256.Sum256(s.secret)
+	if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRPCSize)
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Store) handleAppend(w http.ResponseWriter, r *http.Request) {
+	var req appendRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	resp := s.acceptLocked(req)
+	s.mu.Unlock()
+	writeJSON(w, resp)
+}
+
+// acceptLocked appends the entries of req after checking that the log
+// holds the entry before them.
+func (s *Store) acceptLocked(req appendRequest) appendResponse {
+	if req.Term < s.term {
+		return appendResponse{Term: s.term}
+	}
+	s.followLocked(req.Term, req.Leader)
+	last := s.lastIndexLocked()
+	if req.PrevIndex > last {
+		return appendResponse{Term: s.term, LastIndex: last}
+	}
+	if req.PrevIndex > s.snapIndex && s.termAtLocked(req.PrevIndex) != req.PrevTerm {
+		// Skip back over the conflicting term in one step.
+		conflict, i := s.termAtLocked(req.PrevIndex), req.PrevIndex
+		for i > s.snapIndex+1 && s.termAtLocked(i-1) == conflict {
+			i--
+		}
+		return appendResponse{Term: s.term, LastIndex: i - 1}
+	}
+
+	for k, e := range req.Entries {
+		if e.Index <= s.snapIndex || (e.Index <= s.lastIndexLocked() && s.termAtLocked(e.Index) == e.Term) {
+			continue
+		}
+		fresh := req.Entries[k:]
+		if err := s.disk.write(e.Index, fresh); err != nil {
+			log.Printf("raftstore: failed to append: %v", err)
+			return appendResponse{Term: s.term, LastIndex: s.commit}
+		}
+		s.log = append(s.log[:e.Index-s.snapIndex-1], fresh...)
+		break
+	}
+	end := req.PrevIndex + uint64(len(req.Entries))
+	if commit := min(req.Commit, end); commit > s.commit {
+		s.commit = commit
+		s.applyLocked()
+	}
+	return appendResponse{Term: s.term, Success: true, LastIndex: end}
+}
+
+// followLocked follows leader in term, which is at least the current
+// one.
+func (s *Store) followLocked(term uint64, leader string) {
+	if term > s.term || s.role != follower {
+		s.stepDownLocked(term)
+	}
+	if s.leader != leader {
+		s.leader = leader
+		s.changed.Broadcast()
+	}
+	s.resetDeadlineLocked()
+}
+
+func (s *Store) handleVote(w http.ResponseWriter, r *http.Request) {
+	var req voteRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Term > s.term {
+		s.stepDownLocked(req.Term)
+	}
+	resp := voteResponse{Term: s.term}
+	last := s.lastIndexLocked()
+	upToDate := req.LastTerm > s.termAtLocked(last) || (req.LastTerm == s.termAtLocked(last) && req.LastIndex >= last)
+	if req.Term == s.term && (s.vote == "" || s.vote == req.Candidate) && upToDate {
+		if err := s.setStateLocked(s.term, req.Candidate); err != nil {
+			log.Printf("raftstore: failed to persist vote: %v", err)
+		} else {
+			resp.Granted = true
+			s.resetDeadlineLocked()
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Store) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req snapshotRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	index, term, kv, err := decodeSnapshot(req.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Term < s.term {
+		writeJSON(w, snapshotResponse{Term: s.term})
+		return
+	}
+	s.followLocked(req.Term, req.Leader)
+	if index > s.snapIndex {
+		if err := s.installLocked(index, term, kv, req.Data); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSON(w, snapshotResponse{Term: s.term})
+}
+
+// installLocked replaces the log up to index with the snapshot data of
+// kv, keeping the entries after index when the log agrees on its term.
+func (s *Store) installLocked(index, term uint64, kv map[string]*store.Entry, data []byte) error {
+	if err := writeSnapshot(s.dir, data); err != nil {
+		return err
+	}
+	keep := s.termAtLocked(index) == term
+	if keep {
+		s.log = append([]entry(nil), s.log[index-s.snapIndex:]...)
+	} else {
+		s.log = nil
+	}
+	s.snapIndex, s.snapTerm = index, term
+	if index > s.applied {
+		s.restoreLocked(kv)
+		s.commit, s.applied = max(s.commit, index), index
+		s.changed.Broadcast()
+	}
+	return s.disk.compact(index, keep)
+}
+
+func (s *Store) handlePropose(w http.ResponseWriter, r *http.Request) {
+	var req proposeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	revision, err := s.proposeLocal(r.Context(), req.Command)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, proposeResponse{Revision: revision})
+}
+
+func (s *Store) handleRead(w http.ResponseWriter, r *http.Request) {
+	index, err := s.readIndexLocal(r.Context())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, readResponse{Index: index})
+}
+
+// call posts req to the member at base and decodes its answer into
+// resp. Errors answered by the member are returned as the sentinel they
+// were sent for.
+func (s *Store) call(ctx context.Context, base, path string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(base, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Authorization", "Bearer "+string(s.secret))
+	hr.Header.Set("Content-Type", "application/json")
+	res, err := s.http.Do(hr)
+	if err != nil {
+		return &transportError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		for _, sentinel := range []error{store.ErrNotFound, store.ErrConflict, errNotLeader, ErrLeadershipLost, ErrUnauthorized} {
+			if res.StatusCode == errorStatus(sentinel) && strings.Contains(string(msg), sentinel.Error()) {
+				return sentinel
+			}
+		}
+		return fmt.Errorf("raftstore: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// transportError is returned by call when the member could not be
+// reached or did not answer.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+func isTransport(err error) bool {
+	var t *transportError
+	return errors.As(err, &t)
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, errNotLeader):
+		return http.StatusMisdirectedRequest
+	case errors.Is(err, ErrLeadershipLost):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,163 @@
+package raftstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// snapshotMagic prefixes snapshots; its last byte is the format version.
+var snapshotMagic = [4]byte{'C', 'R', 'R', '1'}
+
+// Snapshot frame types.
+const (
+	frameEnd   byte = 0
+	frameEntry byte = 1
+)
+
+// maxFrameSize bounds a single entry in a snapshot.
+const maxFrameSize = 1 << 20
+
+// snapshotFile is the name of the snapshot in Config.Dir.
+const snapshotFile = "snapshot"
+
+// errMalformedSnapshot is returned for snapshots that are truncated,
+// corrupt or of an unknown version.
+var errMalformedSnapshot = errors.New("malformed raft snapshot")
+
+// snapshotEntry is the payload of an entry frame.
+type snapshotEntry struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value"`
+	Revision uint64 `json:"revision"`
+}
+
+// encodeSnapshot writes the state machine as of the log entry at index,
+// of term, to w in the framing of registry snapshots:
+//
+//	"CRR1" | index u64 | term u64
+//	frames: 1 u8 | length u32 | JSON {"key","value","revision"}
+//	end:    0 u8 | count u32 | CRC-32 (IEEE) u32 of everything before
+//
+// big-endian.
+func encodeSnapshot(w io.Writer, index, term uint64, kv map[string]*store.Entry) error {
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+
+	var header [20]byte
+	copy(header[:], snapshotMagic[:])
+	binary.BigEndian.PutUint64(header[4:], index)
+	binary.BigEndian.PutUint64(header[12:], term)
+	if _, err := out.Write(header[:]); err != nil {
+		return err
+	}
+	for _, e := range kv {
+		payload, err := json.Marshal(snapshotEntry{Key: e.Key, Value: e.Value, Revision: e.Revision})
+		if err != nil {
+			return err
+		}
+		var frame [5]byte
+		frame[0] = frameEntry
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+		if _, err := out.Write(frame[:]); err != nil {
+			return err
+		}
+		if _, err := out.Write(payload); err != nil {
+			return err
+		}
+	}
+
+	var end [9]byte
+	binary.BigEndian.PutUint32(end[1:], uint32(len(kv)))
+	if _, err := out.Write(end[:5]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(end[5:], crc.Sum32())
+	if _, err := bw.Write(end[5:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// decodeSnapshot verifies and decodes a snapshot.
+func decodeSnapshot(data []byte) (index, term uint64, kv map[string]*store.Entry, err error) {
+	if len(data) < 20+9 || [4]byte(data[:4]) != snapshotMagic {
+		return 0, 0, nil, fmt.Errorf("%w: bad header", errMalformedSnapshot)
+	}
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(trailer) {
+		return 0, 0, nil, fmt.Errorf("%w: checksum mismatch", errMalformedSnapshot)
+	}
+	index = binary.BigEndian.Uint64(data[4:])
+	term = binary.BigEndian.Uint64(data[12:])
+
+	kv = make(map[string]*store.Entry)
+	rd := bytes.NewReader(body[20:])
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(rd, head[:]); err != nil {
+			return 0, 0, nil, fmt.Errorf("%w: truncated", errMalformedSnapshot)
+		}
+		size := binary.BigEndian.Uint32(head[1:])
+		if head[0] == frameEnd {
+			if int(size) != len(kv) || rd.Len() != 0 {
+				return 0, 0, nil, fmt.Errorf("%w: %d entries, trailer says %d", errMalformedSnapshot, len(kv), size)
+			}
+			return index, term, kv, nil
+		}
+		if head[0] != frameEntry || size > maxFrameSize {
+			return 0, 0, nil, fmt.Errorf("%w: bad frame", errMalformedSnapshot)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			return 0, 0, nil, fmt.Errorf("%w: truncated", errMalformedSnapshot)
+		}
+		var e snapshotEntry
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return 0, 0, nil, fmt.Errorf("%w: invalid entry", errMalformedSnapshot)
+		}
+		kv[e.Key] = &store.Entry{Key: e.Key, Value: e.Value, Revision: e.Revision}
+	}
+}
+
+// writeSnapshot replaces the snapshot in dir with data once it is
+// complete and synced, as the log it covers is deleted next.
+func writeSnapshot(dir string, data []byte) error {
+	path := filepath.Join(dir, snapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readSnapshot returns the snapshot in dir, or nil when there is none.
+func readSnapshot(dir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
@@ -0,0 +1,254 @@
+// Package redisstore implements store.Store on Redis so that several
+// discovery instances can share registry state.
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Each entry is a hash {v: value, r: revision} at <prefix>k:<key>. Keys are
+// indexed in a sorted set for prefix listing, revisions come from a
+// shared counter, and every write publishes a change notification.
+const (
+	revisionKey = "rev"
+	indexKey    = "index"
+	channelKey  = "events"
+	entryPrefix = "k:"
+)
+
+// putScript writes an entry when the stored revision matches ARGV[3], or
+// unconditionally when ARGV[3] is "-1". It returns the new revision or -1
+// on conflict.
+var putScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'r') or '0')
+if ARGV[3] ~= '-1' and current ~= tonumber(ARGV[3]) then
+  return -1
+end
+local rev = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[1], 'v', ARGV[1], 'r', rev)
+redis.call('ZADD', KEYS[3], 0, ARGV[2])
+redis.call('PUBLISH', ARGV[4], 'p' .. rev .. '\n' .. ARGV[2] .. '\n' .. ARGV[1])
+return rev
+`)
+
+// deleteScript removes an entry, returning 0 if it did not exist.
+var deleteScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'v', 'r')
+if not fields[2] then
+  return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('PUBLISH', ARGV[2], 'd' .. fields[2] .. '\n' .. ARGV[1] .. '\n' .. fields[1])
+return 1
+`)
+
+// Config holds the configuration for a Redis store.
+type Config struct {
+	// Addr is the Redis address (host:port).
+	Addr string
+
+	// Password authenticates to Redis, if set.
+	Password string
+
+	// DB selects the Redis logical database.
+	DB int
+
+	// Prefix namespaces every key this store writes. Defaults to "coral:".
+	Prefix string
+}
+
+// Store is a store.Store backed by Redis.
+type Store struct {
+	client *redis.Client
+	prefix string
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open connects to Redis and verifies the connection.
+func Open(ctx context.Context, cfg Config) (*Store, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "coral:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return &Store{client: client, prefix: cfg.Prefix}, nil
+}
+
+// Get implements store.Store.
+func (s *Store) Get(ctx context.Context, key string) (*store.Entry, error) {
+	fields, err := s.client.HMGet(ctx, s.entryKey(key), "v", "r").Result()
+	if err != nil {
+		return nil, err
+	}
+	return decode(key, fields)
+}
+
+// Put implements store.Store.
+func (s *Store) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	return s.put(ctx, key, -1, value)
+}
+
+// Delete implements store.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	n, err := deleteScript.Run(ctx, s.client,
+		[]string{s.entryKey(key), s.prefix + indexKey},
+		key, s.prefix+channelKey,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// List implements store.Store.
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.Entry, error) {
+	keys, err := s.client.ZRangeByLex(ctx, s.prefix+indexKey, &redis.ZRangeBy{
+		Min: "[" + prefix,
+		Max: "[" + prefix + "\xff",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.HMGet(ctx, s.entryKey(k), "v", "r")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	out := make([]*store.Entry, 0, len(keys))
+	for i, k := range keys {
+		e, err := decode(k, cmds[i].Val())
+		if errors.Is(err, store.ErrNotFound) {
+			continue // Deleted between the index read and the fetch.
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// CompareAndSwap implements store.Store.
+func (s *Store) CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	return s.put(ctx, key, int64(revision), value)
+}
+
+// Watch implements store.Store using Redis pub/sub, so changes made by
+// every instance sharing the database are observed.
+func (s *Store) Watch(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	sub := s.client.Subscribe(ctx, s.prefix+channelKey)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	out := make(chan store.Event)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				ev, ok := parseEvent(msg.Payload)
+				if !ok || !strings.HasPrefix(ev.Entry.Key, prefix) {
+					continue
+				}
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Close implements store.Store.
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+func (s *Store) put(ctx context.Context, key string, revision int64, value []byte) (uint64, error) {
+	rev, err := putScript.Run(ctx, s.client,
+		[]string{s.entryKey(key), s.prefix + revisionKey, s.prefix + indexKey},
+		value, key, revision, s.prefix+channelKey,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if rev < 0 {
+		return 0, store.ErrConflict
+	}
+	return uint64(rev), nil
+}
+
+func (s *Store) entryKey(key string) string {
+	return s.prefix + entryPrefix + key
+}
+
+func decode(key string, fields []interface{}) (*store.Entry, error) {
+	if len(fields) != 2 || fields[1] == nil {
+		return nil, store.ErrNotFound
+	}
+	value, _ := fields[0].(string)
+	revStr, _ := fields[1].(string)
+	rev, err := strconv.ParseUint(revStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revision for %s: %w", key, err)
+	}
+	return &store.Entry{Key: key, Value: []byte(value), Revision: rev}, nil
+}
+
+// parseEvent decodes "<type><revision>\n<key>\n<value>" notifications.
+func parseEvent(payload string) (store.Event, bool) {
+	parts := strings.SplitN(payload, "\n", 3)
+	if len(parts) != 3 || len(parts[0]) < 2 {
+		return store.Event{}, false
+	}
+	rev, err := strconv.ParseUint(parts[0][1:], 10, 64)
+	if err != nil {
+		return store.Event{}, false
+	}
+	ev := store.Event{Entry: store.Entry{Key: parts[1], Value: []byte(parts[2]), Revision: rev}}
+	switch parts[0][0] {
+	case 'p':
+		ev.Type = store.EventPut
+	case 'd':
+		ev.Type = store.EventDelete
+	default:
+		return store.Event{}, false
+	}
+	return ev, true
+}
@@ -0,0 +1,31 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store/storetest"
+)
+
+// TestConformance runs against the Redis server at CORAL_TEST_REDIS_ADDR,
+// each subtest under its own key prefix.
+func TestConformance(t *testing.T) {
+	addr := os.Getenv("CORAL_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("CORAL_TEST_REDIS_ADDR is not set")
+	}
+	storetest.Conformance(t, func(t *testing.T) store.Store {
+		s, err := Open(context.Background(), Config{
+			Addr:   addr,
+			Prefix: fmt.Sprintf("coral-test:%d:", time.Now().UnixNano()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
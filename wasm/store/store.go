@@ -0,0 +1,72 @@
+// Package store defines the key/value storage interface used by the
+// registry and other discovery subsystems, plus an in-memory
+// implementation. Persistent backends live in subpackages.
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrConflict is returned by CompareAndSwap when the stored revision
+	// does not match the expected one.
+	ErrConflict = errors.New("revision conflict")
+)
+
+// Entry is a stored value and the revision at which it was written.
+// Revisions increase monotonically per store.
+type Entry struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// EventType is the kind of change carried by an Event.
+type EventType int
+
+const (
+	// EventPut is emitted when a key is created or updated.
+	EventPut EventType = iota + 1
+
+	// EventDelete is emitted when a key is removed.
+	EventDelete
+)
+
+// Event is a single change observed by Watch. For deletes, Entry.Value
+// holds the last stored value when the backend knows it.
+type Event struct {
+	Type  EventType
+	Entry Entry
+}
+
+// Store is a revisioned key/value store.
+type Store interface {
+	// Get returns the entry for key or ErrNotFound.
+	Get(ctx context.Context, key string) (*Entry, error)
+
+	// Put writes value under key unconditionally and returns the new
+	// revision.
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+
+	// Delete removes key. Deleting a missing key returns ErrNotFound.
+	Delete(ctx context.Context, key string) error
+
+	// List returns every entry whose key starts with prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]*Entry, error)
+
+	// CompareAndSwap writes value under key only if the stored revision
+	// equals revision. A revision of 0 requires that key does not exist.
+	// It returns the new revision or ErrConflict.
+	CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error)
+
+	// Watch streams changes to keys starting with prefix until ctx is
+	// done, after which the channel is closed.
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+
+	// Close releases resources held by the store.
+	Close() error
+}
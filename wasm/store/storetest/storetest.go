@@ -0,0 +1,569 @@
+// Package storetest checks that a store.Store implementation behaves as
+// the registry and the other subsystems built on it expect. Backends,
+// including third-party ones, run Conformance from a test:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Conformance(t, func(t *testing.T) store.Store {
+//			return mystore.New(...)
+//		})
+//	}
+//
+// Besides examples of each operation, the suite checks random operation
+// sequences against a model, that concurrent CompareAndSwap calls on one
+// key are linearizable, and that Watch delivers the writes to each key in
+// revision order and closes when its context is done. The Store contract
+// has no expiry: leases and other TTLs live in the values, written and
+// swept by the stores' users, so the suite checks that entries stay until
+// they are deleted.
+package storetest
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Factory returns an empty store for one subtest. Conformance closes it
+// when the subtest ends.
+type Factory func(t *testing.T) store.Store
+
+// Timeout bounds each wait for a Watch event or a closed channel.
+var Timeout = 10 * time.Second
+
+// Conformance runs the suite against stores returned by factory, a fresh
+// one for each subtest.
+func Conformance(t *testing.T, factory Factory) {
+	for _, test := range []struct {
+		name string
+		fn   func(*testing.T, store.Store)
+	}{
+		{"GetPutDelete", testGetPutDelete},
+		{"Revisions", testRevisions},
+		{"List", testList},
+		{"CompareAndSwap", testCompareAndSwap},
+		{"Persistence", testPersistence},
+		{"Model", testModel},
+		{"LinearizableCompareAndSwap", testLinearizableCAS},
+		{"WatchOrder", testWatchOrder},
+		{"WatchPrefix", testWatchPrefix},
+		{"WatchClose", testWatchClose},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			st := factory(t)
+			t.Cleanup(func() {
+				if err := st.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			})
+			test.fn(t, st)
+		})
+	}
+}
+
+func testGetPutDelete(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	if _, err := st.Get(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get of a missing key: %v, want ErrNotFound", err)
+	}
+	if err := st.Delete(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Delete of a missing key: %v, want ErrNotFound", err)
+	}
+
+	value := []byte("one")
+	rev, err := st.Put(ctx, "a", value)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if rev == 0 {
+		t.Fatal("Put returned revision 0")
+	}
+	value[0] = 'X'
+	e := mustGet(t, st, "a")
+	if e.Key != "a" || string(e.Value) != "one" || e.Revision != rev {
+		t.Fatalf("Get returned %s, want a=one@%d", format(e), rev)
+	}
+	e.Value[0] = 'X'
+	if e := mustGet(t, st, "a"); string(e.Value) != "one" {
+		t.Fatalf("Get returned %q after the caller changed the value it was given", e.Value)
+	}
+
+	if _, err := st.Put(ctx, "empty", nil); err != nil {
+		t.Fatalf("Put of an empty value: %v", err)
+	}
+	if e := mustGet(t, st, "empty"); len(e.Value) != 0 {
+		t.Fatalf("Get of an empty value returned %q", e.Value)
+	}
+
+	if err := st.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := st.Get(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get after Delete: %v, want ErrNotFound", err)
+	}
+	if err := st.Delete(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("second Delete: %v, want ErrNotFound", err)
+	}
+}
+
+func testRevisions(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	var last uint64
+	for i, key := range []string{"a", "b", "a", "c", "b"} {
+		rev, err := st.Put(ctx, key, []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if rev <= last {
+			t.Fatalf("Put of %q returned revision %d after %d", key, rev, last)
+		}
+		last = rev
+	}
+	if err := st.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	rev, err := st.Put(ctx, "a", nil)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if rev <= last {
+		t.Fatalf("Put after Delete returned revision %d after %d", rev, last)
+	}
+
+	// Concurrent writes get distinct revisions.
+	const writers, writes = 8, 16
+	revs := make([][]uint64, writers)
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				rev, err := st.Put(ctx, fmt.Sprintf("w%d/%d", w, i%4), nil)
+				if err != nil {
+					t.Errorf("Put: %v", err)
+					return
+				}
+				revs[w] = append(revs[w], rev)
+			}
+		}()
+	}
+	wg.Wait()
+	all := slices.Concat(revs...)
+	slices.Sort(all)
+	if len(slices.Compact(all)) != writers*writes {
+		t.Fatal("concurrent Puts returned the same revision")
+	}
+}
+
+func testList(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	keys := []string{"b/2", "a", "b/1", "b", "c/1", "b/10", "ba"}
+	for _, key := range keys {
+		if _, err := st.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"a", "b", "b/1", "b/10", "b/2", "ba", "c/1"}},
+		{"b/", []string{"b/1", "b/10", "b/2"}},
+		{"b", []string{"b", "b/1", "b/10", "b/2", "ba"}},
+		{"b/1", []string{"b/1", "b/10"}},
+		{"d", nil},
+	} {
+		entries, err := st.List(ctx, tc.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tc.prefix, err)
+		}
+		var got []string
+		for _, e := range entries {
+			if string(e.Value) != e.Key {
+				t.Fatalf("List(%q) returned %s", tc.prefix, format(e))
+			}
+			got = append(got, e.Key)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("List(%q) = %q, want %q", tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func testCompareAndSwap(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	if _, err := st.CompareAndSwap(ctx, "a", 1, nil); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("CompareAndSwap of a missing key at revision 1: %v, want ErrConflict", err)
+	}
+	rev, err := st.CompareAndSwap(ctx, "a", 0, []byte("one"))
+	if err != nil {
+		t.Fatalf("CompareAndSwap creating a key: %v", err)
+	}
+	if _, err := st.CompareAndSwap(ctx, "a", 0, []byte("two")); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("CompareAndSwap at revision 0 of an existing key: %v, want ErrConflict", err)
+	}
+	if _, err := st.CompareAndSwap(ctx, "a", rev+1, []byte("two")); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("CompareAndSwap at a later revision: %v, want ErrConflict", err)
+	}
+	next, err := st.CompareAndSwap(ctx, "a", rev, []byte("two"))
+	if err != nil {
+		t.Fatalf("CompareAndSwap at the stored revision: %v", err)
+	}
+	if next <= rev {
+		t.Fatalf("CompareAndSwap returned revision %d after %d", next, rev)
+	}
+	if _, err := st.CompareAndSwap(ctx, "a", rev, []byte("three")); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("CompareAndSwap at a stale revision: %v, want ErrConflict", err)
+	}
+	if e := mustGet(t, st, "a"); string(e.Value) != "two" || e.Revision != next {
+		t.Fatalf("Get returned %s, want a=two@%d", format(e), next)
+	}
+
+	if err := st.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := st.CompareAndSwap(ctx, "a", next, nil); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("CompareAndSwap of a deleted key at its last revision: %v, want ErrConflict", err)
+	}
+	if _, err := st.CompareAndSwap(ctx, "a", 0, nil); err != nil {
+		t.Fatalf("CompareAndSwap recreating a deleted key: %v", err)
+	}
+}
+
+func testPersistence(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	rev, err := st.Put(ctx, "a", []byte("one"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if e := mustGet(t, st, "a"); string(e.Value) != "one" || e.Revision != rev {
+		t.Fatalf("Get returned %s, want a=one@%d", format(e), rev)
+	}
+}
+
+// testModel applies random operations to st and to a map, comparing
+// their results.
+func testModel(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	seed := uint64(time.Now().UnixNano())
+	rng := rand.New(rand.NewPCG(seed, seed))
+	fail := func(format string, args ...any) {
+		t.Helper()
+		t.Fatalf("seed %d: "+format, append([]any{seed}, args...)...)
+	}
+
+	keys := []string{"a", "a/1", "a/2", "ab", "b", "b/1"}
+	model := make(map[string]store.Entry)
+	var last uint64
+	for i := range 500 {
+		key := keys[rng.IntN(len(keys))]
+		value := []byte(strconv.Itoa(i))
+		current, exists := model[key]
+		switch op := rng.IntN(6); op {
+		case 0, 1:
+			rev, err := st.Put(ctx, key, value)
+			if err != nil || rev <= last {
+				fail("Put(%q) = %d, %v after revision %d", key, rev, err, last)
+			}
+			model[key], last = store.Entry{Key: key, Value: value, Revision: rev}, rev
+		case 2:
+			expect := current.Revision
+			if rng.IntN(3) == 0 {
+				expect = uint64(rng.IntN(int(last) + 2))
+			}
+			rev, err := st.CompareAndSwap(ctx, key, expect, value)
+			if expect != current.Revision {
+				if !errors.Is(err, store.ErrConflict) {
+					fail("CompareAndSwap(%q, %d) at revision %d: %v, want ErrConflict", key, expect, current.Revision, err)
+				}
+				break
+			}
+			if err != nil || rev <= last {
+				fail("CompareAndSwap(%q, %d) = %d, %v after revision %d", key, expect, rev, err, last)
+			}
+			model[key], last = store.Entry{Key: key, Value: value, Revision: rev}, rev
+		case 3:
+			err := st.Delete(ctx, key)
+			if !exists && !errors.Is(err, store.ErrNotFound) || exists && err != nil {
+				fail("Delete(%q) with the key present %t: %v", key, exists, err)
+			}
+			delete(model, key)
+		case 4:
+			e, err := st.Get(ctx, key)
+			if !exists {
+				if !errors.Is(err, store.ErrNotFound) {
+					fail("Get(%q) of a missing key: %v, want ErrNotFound", key, err)
+				}
+				break
+			}
+			if err != nil || !equal(e, &current) {
+				fail("Get(%q) = %s, %v, want %s", key, format(e), err, format(&current))
+			}
+		case 5:
+			prefix := key[:rng.IntN(len(key)+1)]
+			entries, err := st.List(ctx, prefix)
+			if err != nil {
+				fail("List(%q): %v", prefix, err)
+			}
+			var want []store.Entry
+			for _, k := range keys {
+				if e, ok := model[k]; ok && len(k) >= len(prefix) && k[:len(prefix)] == prefix {
+					want = append(want, e)
+				}
+			}
+			if len(entries) != len(want) {
+				fail("List(%q) returned %d entries, want %d", prefix, len(entries), len(want))
+			}
+			for j := range want {
+				if !equal(entries[j], &want[j]) {
+					fail("List(%q)[%d] = %s, want %s", prefix, j, format(entries[j]), format(&want[j]))
+				}
+			}
+		}
+	}
+}
+
+// testLinearizableCAS increments a counter from concurrent
+// read-modify-write loops. The swaps that succeed must form one chain,
+// each made at the revision the one before it wrote, and no increment may
+// be lost.
+func testLinearizableCAS(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	const workers, increments = 8, 25
+
+	type swap struct{ from, to uint64 }
+	swaps := make([][]swap, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				for {
+					var n int
+					var rev uint64
+					e, err := st.Get(ctx, "counter")
+					switch {
+					case err == nil:
+						if n, err = strconv.Atoi(string(e.Value)); err != nil {
+							t.Errorf("counter holds %q", e.Value)
+							return
+						}
+						rev = e.Revision
+					case !errors.Is(err, store.ErrNotFound):
+						t.Errorf("Get: %v", err)
+						return
+					}
+					next, err := st.CompareAndSwap(ctx, "counter", rev, []byte(strconv.Itoa(n+1)))
+					if errors.Is(err, store.ErrConflict) {
+						continue
+					}
+					if err != nil {
+						t.Errorf("CompareAndSwap: %v", err)
+						return
+					}
+					swaps[w] = append(swaps[w], swap{rev, next})
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	all := slices.Concat(swaps...)
+	slices.SortFunc(all, func(a, b swap) int { return cmp.Compare(a.to, b.to) })
+	var prev uint64
+	for i, s := range all {
+		if s.from != prev {
+			t.Fatalf("swap %d was made at revision %d, but the one before it wrote %d", i, s.from, prev)
+		}
+		prev = s.to
+	}
+	if e := mustGet(t, st, "counter"); string(e.Value) != strconv.Itoa(workers*increments) || e.Revision != prev {
+		t.Fatalf("counter is %s, want %d@%d", format(e), workers*increments, prev)
+	}
+}
+
+// testWatchOrder writes to a few keys from concurrent writers and checks
+// that every write is observed once, in revision order per key.
+func testWatchOrder(t *testing.T, st store.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := st.Watch(ctx, "w/")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	const writers, writes = 4, 12
+	var mu sync.Mutex
+	var puts, deletes int
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				key := fmt.Sprintf("w/%d", (w+i)%3)
+				if i%4 == 3 {
+					err := st.Delete(ctx, key)
+					if err == nil {
+						mu.Lock()
+						deletes++
+						mu.Unlock()
+					} else if !errors.Is(err, store.ErrNotFound) {
+						t.Errorf("Delete: %v", err)
+					}
+					continue
+				}
+				if _, err := st.Put(ctx, key, []byte(strconv.Itoa(w))); err != nil {
+					t.Errorf("Put: %v", err)
+					continue
+				}
+				mu.Lock()
+				puts++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	last := make(map[string]store.Event)
+	for range puts + deletes {
+		ev := next(t, events)
+		prev, seen := last[ev.Entry.Key]
+		switch ev.Type {
+		case store.EventPut:
+			if seen && ev.Entry.Revision <= prev.Entry.Revision {
+				t.Fatalf("put of %q at revision %d observed after revision %d", ev.Entry.Key, ev.Entry.Revision, prev.Entry.Revision)
+			}
+		case store.EventDelete:
+			if !seen || prev.Type != store.EventPut || ev.Entry.Revision < prev.Entry.Revision {
+				t.Fatalf("delete of %q observed after %+v", ev.Entry.Key, prev)
+			}
+		default:
+			t.Fatalf("event of type %d", ev.Type)
+		}
+		last[ev.Entry.Key] = ev
+	}
+	for key, ev := range last {
+		e, err := st.Get(ctx, key)
+		switch {
+		case ev.Type == store.EventDelete && !errors.Is(err, store.ErrNotFound):
+			t.Fatalf("last event of %q was a delete, but Get returned %s, %v", key, format(e), err)
+		case ev.Type == store.EventPut && (err != nil || e.Revision != ev.Entry.Revision):
+			t.Fatalf("last event of %q was a put at revision %d, but Get returned %s, %v", key, ev.Entry.Revision, format(e), err)
+		}
+	}
+}
+
+// testWatchPrefix checks that watches only see their prefix and that
+// events carry the values written.
+func testWatchPrefix(t *testing.T, st store.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := st.Watch(ctx, "p/")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	rev, err := st.Put(ctx, "other", []byte("x"))
+	if err == nil {
+		_, err = st.Put(ctx, "p", []byte("x"))
+	}
+	if err == nil {
+		rev, err = st.Put(ctx, "p/a", []byte("one"))
+	}
+	if err == nil {
+		err = st.Delete(ctx, "p")
+	}
+	if err == nil {
+		err = st.Delete(ctx, "p/a")
+	}
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	ev := next(t, events)
+	if ev.Type != store.EventPut || ev.Entry.Key != "p/a" || string(ev.Entry.Value) != "one" || ev.Entry.Revision != rev {
+		t.Fatalf("first event is %d %s, want a put of p/a=one@%d", ev.Type, format(&ev.Entry), rev)
+	}
+	ev = next(t, events)
+	if ev.Type != store.EventDelete || ev.Entry.Key != "p/a" {
+		t.Fatalf("second event is %d %s, want a delete of p/a", ev.Type, format(&ev.Entry))
+	}
+	if len(ev.Entry.Value) > 0 && string(ev.Entry.Value) != "one" {
+		t.Fatalf("delete event carries %q, want the last value or none", ev.Entry.Value)
+	}
+}
+
+// testWatchClose checks that a watch's channel is closed once its context
+// is done.
+func testWatchClose(t *testing.T, st store.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := st.Watch(ctx, "")
+	if err != nil {
+		cancel()
+		t.Fatalf("Watch: %v", err)
+	}
+	cancel()
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			t.Fatal("watch channel not closed after its context was canceled")
+		}
+	}
+}
+
+func mustGet(t *testing.T, st store.Store, key string) *store.Entry {
+	t.Helper()
+	e, err := st.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	return e
+}
+
+func next(t *testing.T, events <-chan store.Event) store.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(Timeout):
+		t.Fatal("no watch event")
+	}
+	return store.Event{}
+}
+
+func equal(e, want *store.Entry) bool {
+	return e.Key == want.Key && bytes.Equal(e.Value, want.Value) && e.Revision == want.Revision
+}
+
+func format(e *store.Entry) string {
+	if e == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%s=%q@%d", e.Key, e.Value, e.Revision)
+}
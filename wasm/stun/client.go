@@ -0,0 +1,101 @@
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Retransmission of requests (RFC 5389 section 7.2.1): the interval
+// starts at initialRTO and doubles, for up to maxRequests requests.
+const (
+	initialRTO  = 500 * time.Millisecond
+	maxRequests = 7
+)
+
+// ErrNoResponse is returned when the server did not answer any request.
+var ErrNoResponse = errors.New("no STUN response")
+
+// Discover returns the server-reflexive address of conn: the address the
+// STUN server at addr (host:port) sees conn's packets come from. Use the
+// socket the address is for, since NATs map each socket separately, and
+// do not read from it concurrently; packets other than the response are
+// discarded.
+func Discover(ctx context.Context, conn net.PacketConn, addr string) (netip.AddrPort, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	req, err := newTransaction()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	packet := req.marshal()
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, maxPacket)
+	rto := initialRTO
+	for range maxRequests {
+		if _, err := conn.WriteTo(packet, server); err != nil {
+			return netip.AddrPort{}, err
+		}
+		deadline := time.Now().Add(rto)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return netip.AddrPort{}, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return netip.AddrPort{}, err
+			}
+			resp, err := parse(buf[:n])
+			if err != nil || resp.txID != req.txID {
+				continue
+			}
+			return reflexive(resp)
+		}
+		if err := ctx.Err(); err != nil {
+			return netip.AddrPort{}, err
+		}
+		rto *= 2
+	}
+	return netip.AddrPort{}, fmt.Errorf("%w from %s", ErrNoResponse, addr)
+}
+
+// reflexive extracts the mapped address from a response, preferring the
+// XOR-MAPPED-ADDRESS that NATs rewriting payloads cannot mangle.
+func reflexive(resp *message) (netip.AddrPort, error) {
+	switch resp.typ {
+	case bindingSuccess:
+	case bindingError:
+		if v, ok := resp.get(attrErrorCode); ok && len(v) >= 4 {
+			return netip.AddrPort{}, fmt.Errorf("STUN error %d: %s", int(v[2])*100+int(v[3]), v[4:])
+		}
+		return netip.AddrPort{}, ErrInvalidMessage
+	default:
+		return netip.AddrPort{}, ErrInvalidMessage
+	}
+	if v, ok := resp.get(attrXORMappedAddress); ok {
+		return parseXORAddress(v, resp.txID)
+	}
+	v, ok := resp.get(attrMappedAddress)
+	if !ok || len(v) < 8 {
+		return netip.AddrPort{}, fmt.Errorf("%w: no mapped address", ErrInvalidMessage)
+	}
+	ip, ok := netip.AddrFromSlice(v[4:])
+	if !ok {
+		return netip.AddrPort{}, ErrInvalidMessage
+	}
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(v[2:4])), nil
+}
@@ -0,0 +1,72 @@
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// maxPacket is the largest STUN message read.
+const maxPacket = 1500
+
+// Serve answers Binding requests arriving on conn until ctx is done,
+// then closes conn. Other packets are dropped. Responses are barely
+// larger than requests, so the responder is a poor amplifier.
+func Serve(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxPacket)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if resp := respond(buf[:n], udp.AddrPort()); resp != nil {
+			_, _ = conn.WriteTo(resp, from)
+		}
+	}
+}
+
+// respond returns the response to the request in b received from src, or
+// nil when b is not a Binding request.
+func respond(b []byte, src netip.AddrPort) []byte {
+	req, err := parse(b)
+	if err != nil || req.typ != bindingRequest {
+		return nil
+	}
+
+	// Comprehension-required attributes the responder does not know must
+	// be refused (RFC 5389 section 7.3.1).
+	var unknown []byte
+	for _, a := range req.attrs {
+		if a.typ < 0x8000 {
+			unknown = binary.BigEndian.AppendUint16(unknown, a.typ)
+		}
+	}
+	resp := &message{typ: bindingSuccess, txID: req.txID}
+	if unknown != nil {
+		resp.typ = bindingError
+		resp.attrs = []attribute{
+			{typ: attrErrorCode, value: append([]byte{0, 0, 4, 20}, "Unknown Attribute"...)},
+			{typ: attrUnknownAttributes, value: unknown},
+		}
+	} else {
+		resp.attrs = []attribute{{typ: attrXORMappedAddress, value: xorAddress(src, req.txID)}}
+	}
+	resp.attrs = append(resp.attrs, attribute{typ: attrSoftware, value: []byte(software)})
+	return resp.marshal()
+}
@@ -0,0 +1,192 @@
+// Package stun implements the Binding method of STUN (RFC 5389): a
+// responder telling clients the address their packets arrive from, and
+// a client learning its server-reflexive address through it. Agents
+// behind NAT only know their private addresses; registering the
+// reflexive address along with them gives peers something they can
+// reach, or at least punch to (see the traversal package).
+//
+// Only what address discovery needs is implemented: no authentication,
+// no ALTERNATE-SERVER and no RFC 3489 compatibility.
+package stun
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net/netip"
+)
+
+// DefaultPort is the STUN port registered with IANA.
+const DefaultPort = 3478
+
+// magicCookie is the fixed value distinguishing RFC 5389 messages.
+const magicCookie = 0x2112A442
+
+// headerSize is the size of the message header.
+const headerSize = 20
+
+// Message types.
+const (
+	bindingRequest = 0x0001
+	bindingSuccess = 0x0101
+	bindingError   = 0x0111
+)
+
+// Attribute types.
+const (
+	attrMappedAddress     = 0x0001
+	attrErrorCode         = 0x0009
+	attrUnknownAttributes = 0x000A
+	attrXORMappedAddress  = 0x0020
+	attrSoftware          = 0x8022
+	attrFingerprint       = 0x8028
+)
+
+// fingerprintXOR is XORed into the CRC-32 of FINGERPRINT attributes.
+const fingerprintXOR = 0x5354554e
+
+// software is sent in the SOFTWARE attribute of responses.
+const software = "coral-discovery"
+
+// ErrInvalidMessage is returned for packets that are not well-formed
+// STUN messages.
+var ErrInvalidMessage = errors.New("invalid STUN message")
+
+type attribute struct {
+	typ   uint16
+	value []byte
+}
+
+// message is a decoded STUN message.
+type message struct {
+	typ   uint16
+	txID  [12]byte
+	attrs []attribute
+}
+
+// newTransaction returns a Binding request with a random transaction ID.
+func newTransaction() (*message, error) {
+	m := &message{typ: bindingRequest}
+	if _, err := rand.Read(m.txID[:]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parse decodes b, checking the header and, when present, the
+// fingerprint.
+func parse(b []byte) (*message, error) {
+	if len(b) < headerSize || b[0]&0xC0 != 0 {
+		return nil, ErrInvalidMessage
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length%4 != 0 || headerSize+length != len(b) || binary.BigEndian.Uint32(b[4:8]) != magicCookie {
+		return nil, ErrInvalidMessage
+	}
+	m := &message{typ: binary.BigEndian.Uint16(b[0:2])}
+	copy(m.txID[:], b[8:20])
+
+	for off := headerSize; off < len(b); {
+		if off+4 > len(b) {
+			return nil, ErrInvalidMessage
+		}
+		typ := binary.BigEndian.Uint16(b[off:])
+		n := int(binary.BigEndian.Uint16(b[off+2:]))
+		end := off + 4 + n
+		if end > len(b) {
+			return nil, ErrInvalidMessage
+		}
+		if typ == attrFingerprint {
+			if n != 4 || end != len(b) || binary.BigEndian.Uint32(b[off+4:]) != fingerprint(b[:off]) {
+				return nil, ErrInvalidMessage
+			}
+			break
+		}
+		m.attrs = append(m.attrs, attribute{typ: typ, value: b[off+4 : end]})
+		off = end + (4-n%4)%4
+	}
+	return m, nil
+}
+
+// marshal encodes m, ending it with a FINGERPRINT attribute.
+func (m *message) marshal() []byte {
+	b := make([]byte, headerSize, 128)
+	binary.BigEndian.PutUint16(b[0:2], m.typ)
+	binary.BigEndian.PutUint32(b[4:8], magicCookie)
+	copy(b[8:20], m.txID[:])
+	for _, a := range m.attrs {
+		b = binary.BigEndian.AppendUint16(b, a.typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.value)))
+		b = append(b, a.value...)
+		b = append(b, make([]byte, (4-len(a.value)%4)%4)...)
+	}
+	// The length covers the fingerprint when it is computed.
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-headerSize+8))
+	b = binary.BigEndian.AppendUint16(b, attrFingerprint)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	return binary.BigEndian.AppendUint32(b, fingerprint(b[:len(b)-4]))
+}
+
+func (m *message) get(typ uint16) ([]byte, bool) {
+	for _, a := range m.attrs {
+		if a.typ == typ {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+// fingerprint computes the FINGERPRINT value of the message prefix b.
+func fingerprint(b []byte) uint32 {
+	return crc32.ChecksumIEEE(b) ^ fingerprintXOR
+}
+
+// xorAddress encodes addr as an XOR-MAPPED-ADDRESS for transaction txID.
+func xorAddress(addr netip.AddrPort, txID [12]byte) []byte {
+	ip := addr.Addr().Unmap()
+	family := byte(0x01)
+	if ip.Is6() {
+		family = 0x02
+	}
+	raw := ip.AsSlice()
+	out := make([]byte, 4+len(raw))
+	out[1] = family
+	binary.BigEndian.PutUint16(out[2:], addr.Port()^(magicCookie>>16))
+	mask := xorMask(txID)
+	for i, v := range raw {
+		out[4+i] = v ^ mask[i]
+	}
+	return out
+}
+
+// parseXORAddress decodes an XOR-MAPPED-ADDRESS for transaction txID.
+func parseXORAddress(v []byte, txID [12]byte) (netip.AddrPort, error) {
+	if len(v) < 4 {
+		return netip.AddrPort{}, ErrInvalidMessage
+	}
+	n := 4
+	if v[1] == 0x02 {
+		n = 16
+	} else if v[1] != 0x01 {
+		return netip.AddrPort{}, ErrInvalidMessage
+	}
+	if len(v) != 4+n {
+		return netip.AddrPort{}, ErrInvalidMessage
+	}
+	mask := xorMask(txID)
+	raw := make([]byte, n)
+	for i := range raw {
+		raw[i] = v[4+i] ^ mask[i]
+	}
+	ip, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(v[2:])^(magicCookie>>16)), nil
+}
+
+// xorMask is the magic cookie followed by the transaction ID.
+func xorMask(txID [12]byte) [16]byte {
+	var mask [16]byte
+	binary.BigEndian.PutUint32(mask[:4], magicCookie)
+	copy(mask[4:], txID[:])
+	return mask
+}
@@ -0,0 +1,202 @@
+// Package tenant serves several reefs from one discovery deployment, each
+// with its own ticket signing keys and agent quota. A Tenants verifies a
+// ticket only with the keys of the reef the ticket names, so a reef's
+// issuer cannot mint tickets for another reef, and rejects tickets of
+// reefs it does not list. The registry keeps each reef's records apart
+// (see registry.Migrate); Tenants also supplies its per-reef quotas.
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/allowlist"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// ErrUnknownReef is returned for tickets of reefs the tenants do not
+// list.
+var ErrUnknownReef = errors.New("unknown reef")
+
+// ErrInvalidConfig is returned for tenant documents with empty, repeated
+// or malformed reef IDs, or negative quotas.
+var ErrInvalidConfig = errors.New("invalid tenant config")
+
+// Reef is one tenant of the deployment.
+type Reef struct {
+	ID string `json:"id"`
+
+	// JWKS is the path of the JWKS document holding the keys that sign
+	// the reef's tickets, relative to the tenants document. Reefs without
+	// one are verified with the deployment's own keys.
+	JWKS string `json:"jwks,omitempty"`
+
+	// MaxAgents bounds the agents the reef may register. Zero means no
+	// limit.
+	MaxAgents int `json:"max_agents,omitempty"`
+}
+
+// Config is the JSON tenants document.
+type Config struct {
+	Reefs []Reef `json:"reefs"`
+}
+
+// ParseConfig parses and validates a tenants document of the form
+//
+//	{"reefs": [{"id": "acme", "jwks": "acme.jwks.json", "max_agents": 500},
+//	  {"id": "internal"}]}
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	seen := map[string]bool{}
+	for _, r := range c.Reefs {
+		if r.ID == "" || strings.Contains(r.ID, "/") || seen[r.ID] {
+			return fmt.Errorf("%w: empty, repeated or malformed reef ID %q", ErrInvalidConfig, r.ID)
+		}
+		seen[r.ID] = true
+		if r.MaxAgents < 0 {
+			return fmt.Errorf("%w: reef %s has a negative max_agents", ErrInvalidConfig, r.ID)
+		}
+	}
+	return nil
+}
+
+// tenant is a listed reef as loaded.
+type tenant struct {
+	keys      verify.KeySource // nil for the deployment's keys
+	maxAgents int
+}
+
+// Tenants resolves ticket keys and quotas by reef. It satisfies
+// verify.KeySource and registry.Quotas, and is safe for concurrent use.
+type Tenants struct {
+	fallback verify.KeySource
+
+	mu    sync.RWMutex
+	reefs map[string]tenant
+}
+
+// Load reads the tenants document at path and the JWKS documents it
+// names. Reefs without their own keys are verified with fallback.
+func Load(path string, fallback verify.KeySource) (*Tenants, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tenants{fallback: fallback, reefs: make(map[string]tenant, len(cfg.Reefs))}
+	for _, r := range cfg.Reefs {
+		entry := tenant{maxAgents: r.MaxAgents}
+		if r.JWKS != "" {
+			jwksPath := r.JWKS
+			if !filepath.IsAbs(jwksPath) {
+				jwksPath = filepath.Join(filepath.Dir(path), jwksPath)
+			}
+			doc, err := os.ReadFile(jwksPath)
+			if err != nil {
+				return nil, fmt.Errorf("reef %s: %w", r.ID, err)
+			}
+			keys, err := verify.ParseKeys(doc)
+			if err != nil {
+				return nil, fmt.Errorf("reef %s: %w", r.ID, err)
+			}
+			entry.keys = keys
+		} else if fallback == nil {
+			return nil, fmt.Errorf("%w: reef %s needs jwks without deployment keys", ErrInvalidConfig, r.ID)
+		}
+		t.reefs[r.ID] = entry
+	}
+	return t, nil
+}
+
+// Replace serves the reefs of other, such as a reread document, in place
+// of t's.
+func (t *Tenants) Replace(other *Tenants) {
+	other.mu.RLock()
+	reefs := other.reefs
+	other.mu.RUnlock()
+	t.mu.Lock()
+	t.reefs = reefs
+	t.mu.Unlock()
+}
+
+// Reload rereads the tenants document at path into t, keeping t's
+// deployment keys. On failure t keeps its reefs.
+func (t *Tenants) Reload(path string) error {
+	other, err := Load(path, t.fallback)
+	if err != nil {
+		return err
+	}
+	t.Replace(other)
+	return nil
+}
+
+// Reefs returns the IDs of the listed reefs.
+func (t *Tenants) Reefs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ids := make([]string, 0, len(t.reefs))
+	for id := range t.reefs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetKeyFunc implements verify.KeySource. It resolves the token's key
+// among the keys of the reef its reef_id claim names, for tickets and
+// endpoint allowlists alike.
+func (t *Tenants) GetKeyFunc() gojwt.Keyfunc {
+	return func(token *gojwt.Token) (interface{}, error) {
+		reef := reefOf(token)
+		t.mu.RLock()
+		entry, ok := t.reefs[reef]
+		t.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownReef, reef)
+		}
+		keys := entry.keys
+		if keys == nil {
+			keys = t.fallback
+		}
+		return keys.GetKeyFunc()(token)
+	}
+}
+
+// reefOf returns the reef_id claim of token, which gojwt decodes before
+// resolving its key.
+func reefOf(token *gojwt.Token) string {
+	switch claims := token.Claims.(type) {
+	case *jwt.ReferralClaims:
+		return claims.ReefID
+	case *allowlist.Claims:
+		return claims.ReefID
+	case gojwt.MapClaims:
+		reef, _ := claims["reef_id"].(string)
+		return reef
+	}
+	return ""
+}
+
+// MaxAgents implements registry.Quotas.
+func (t *Tenants) MaxAgents(reefID string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.reefs[reefID].maxAgents
+}
@@ -0,0 +1,173 @@
+package testutil
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Network connects the HTTP handlers of simulated hosts in process and
+// injects faults between them. Clients from Client reach the handler
+// registered for the host of a request's URL, so servers under test run
+// unchanged without listening on sockets. It is safe for concurrent use.
+type Network struct {
+	mu            sync.Mutex
+	rand          *rand.Rand
+	hosts         map[string]http.Handler
+	cut           map[[2]string]bool
+	latency       time.Duration
+	jitter        time.Duration
+	dropRequests  float64
+	dropResponses float64
+}
+
+// NewNetwork creates a Network without hosts, drawing random faults from
+// seed.
+func NewNetwork(seed uint64) *Network {
+	return &Network{
+		rand:  rand.New(rand.NewPCG(seed, seed)),
+		hosts: map[string]http.Handler{},
+		cut:   map[[2]string]bool{},
+	}
+}
+
+// Handle serves requests for host, a URL host such as "a" or "a:8080",
+// with h.
+func (n *Network) Handle(host string, h http.Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.hosts[host] = h
+}
+
+// Client returns an HTTP client sending requests from host from.
+func (n *Network) Client(from string) *http.Client {
+	return &http.Client{Transport: n.Transport(from)}
+}
+
+// Transport returns a RoundTripper sending requests from host from.
+func (n *Network) Transport(from string) http.RoundTripper {
+	return &transport{network: n, from: from}
+}
+
+// Partition splits the hosts into groups that cannot reach each other,
+// replacing earlier cuts. Hosts in no group reach every host.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	clear(n.cut)
+	for i, g := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range g {
+				for _, b := range other {
+					n.cut[[2]string{a, b}] = true
+					n.cut[[2]string{b, a}] = true
+				}
+			}
+		}
+	}
+}
+
+// Cut stops requests from host from reaching host to. Cut both ways for
+// a symmetric partition.
+func (n *Network) Cut(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cut[[2]string{from, to}] = true
+}
+
+// Heal restores every link cut by Partition or Cut.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	clear(n.cut)
+}
+
+// SetLatency delays every request by latency plus a random duration up
+// to jitter, and every response by as much again.
+func (n *Network) SetLatency(latency, jitter time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency, n.jitter = latency, jitter
+}
+
+// DropRequests loses each request before it reaches its host with
+// probability p.
+func (n *Network) DropRequests(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropRequests = p
+}
+
+// DropResponses loses each response with probability p, after its host
+// handled the request, so that the client cannot tell whether it took
+// effect.
+func (n *Network) DropResponses(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropResponses = p
+}
+
+// hop waits out the latency of a request or response from from to to,
+// and reports whether it got through.
+func (n *Network) hop(req *http.Request, from, to string, drop func(*Network) float64) error {
+	n.mu.Lock()
+	delay := n.latency
+	if n.jitter > 0 {
+		delay += time.Duration(n.rand.Int64N(int64(n.jitter)))
+	}
+	n.mu.Unlock()
+	if err := sleep(req.Context(), delay); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cut[[2]string{from, to}] {
+		return fmt.Errorf("%s cannot reach %s: %w", from, to, ErrPartitioned)
+	}
+	if p := drop(n); p > 0 && n.rand.Float64() < p {
+		return fmt.Errorf("%s %s from %s: connection reset", req.Method, req.URL, from)
+	}
+	return nil
+}
+
+type transport struct {
+	network *Network
+	from    string
+}
+
+// RoundTrip serves req with the handler of its host.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	host := req.URL.Host
+	t.network.mu.Lock()
+	h, ok := t.network.hosts[host]
+	t.network.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no such host", req.Method, req.URL)
+	}
+
+	if err := t.network.hop(req, t.from, host, func(n *Network) float64 { return n.dropRequests }); err != nil {
+		return nil, err
+	}
+	in := req.Clone(req.Context())
+	in.Host = host
+	in.RemoteAddr = t.from + ":0"
+	in.RequestURI = req.URL.RequestURI()
+	if in.Body == nil {
+		in.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, in)
+	if err := t.network.hop(req, host, t.from, func(n *Network) float64 { return n.dropResponses }); err != nil {
+		return nil, err
+	}
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
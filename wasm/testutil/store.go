@@ -0,0 +1,230 @@
+// Package testutil injects the faults of production deployments into
+// tests: slow and unreachable stores, listings torn by concurrent writes,
+// lost watch events and slow, partitioned or lossy networks between
+// replicas. Random faults are drawn from a seeded source, so a test that
+// logs its seed can replay a failing run.
+//
+// Time-dependent behaviour, such as lease expiry, is tested with a
+// clock.Fake instead of sleeping, and replication by calling Sync on
+// each replica rather than running it in the background, so that a test
+// decides exactly which changes have been exchanged.
+package testutil
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// ErrPartitioned is returned by calls that cannot reach their store or
+// host.
+var ErrPartitioned = errors.New("testutil: partitioned")
+
+// Store wraps a store.Store and injects faults into its calls. It is
+// safe for concurrent use.
+type Store struct {
+	store.Store
+
+	mu          sync.Mutex
+	rand        *rand.Rand
+	latency     time.Duration
+	jitter      time.Duration
+	partitioned bool
+	tornLists   bool
+	dropWatch   float64
+	dropped     int
+}
+
+// NewStore wraps st, drawing random faults from seed. It injects none
+// until told to.
+func NewStore(st store.Store, seed uint64) *Store {
+	return &Store{Store: st, rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// SetLatency delays every call by latency plus a random duration up to
+// jitter.
+func (s *Store) SetLatency(latency, jitter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency, s.jitter = latency, jitter
+}
+
+// Partition fails every call with ErrPartitioned, as when the store is
+// unreachable, and drops the events of open watches until Heal.
+func (s *Store) Partition() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitioned = true
+}
+
+// Heal ends a Partition.
+func (s *Store) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitioned = false
+}
+
+// SetTornLists makes List read each entry separately, with the store's
+// latency in between, as backends without snapshot reads do. A listing
+// then misses entries created while it runs but includes later writes to
+// entries it reads after them.
+func (s *Store) SetTornLists(torn bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tornLists = torn
+}
+
+// DropWatchEvents drops each event of open and later watches with
+// probability p, as lossy change feeds such as Redis pub/sub do.
+func (s *Store) DropWatchEvents(p float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropWatch = p
+}
+
+// Dropped returns the number of watch events dropped so far.
+func (s *Store) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Get implements store.Store.
+func (s *Store) Get(ctx context.Context, key string) (*store.Entry, error) {
+	if err := s.fault(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, key)
+}
+
+// Put implements store.Store.
+func (s *Store) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := s.fault(ctx); err != nil {
+		return 0, err
+	}
+	return s.Store.Put(ctx, key, value)
+}
+
+// Delete implements store.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := s.fault(ctx); err != nil {
+		return err
+	}
+	return s.Store.Delete(ctx, key)
+}
+
+// List implements store.Store.
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.Entry, error) {
+	if err := s.fault(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := s.Store.List(ctx, prefix)
+	s.mu.Lock()
+	torn := s.tornLists
+	s.mu.Unlock()
+	if err != nil || !torn {
+		return entries, err
+	}
+
+	out := entries[:0]
+	for _, e := range entries {
+		if err := s.fault(ctx); err != nil {
+			return nil, err
+		}
+		e, err := s.Store.Get(ctx, e.Key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// CompareAndSwap implements store.Store.
+func (s *Store) CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	if err := s.fault(ctx); err != nil {
+		return 0, err
+	}
+	return s.Store.CompareAndSwap(ctx, key, revision, value)
+}
+
+// Watch implements store.Store.
+func (s *Store) Watch(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	if err := s.fault(ctx); err != nil {
+		return nil, err
+	}
+	in, err := s.Store.Watch(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan store.Event)
+	go func() {
+		defer close(out)
+		for ev := range in {
+			if s.drop() {
+				continue
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// fault waits out the latency of a call and fails it while partitioned.
+func (s *Store) fault(ctx context.Context) error {
+	s.mu.Lock()
+	delay := s.latency
+	if s.jitter > 0 {
+		delay += time.Duration(s.rand.Int64N(int64(s.jitter)))
+	}
+	s.mu.Unlock()
+	if err := sleep(ctx, delay); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.partitioned {
+		return ErrPartitioned
+	}
+	return nil
+}
+
+// drop reports whether to drop a watch event, and counts it if so.
+func (s *Store) drop() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.partitioned || (s.dropWatch > 0 && s.rand.Float64() < s.dropWatch) {
+		s.dropped++
+		return true
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
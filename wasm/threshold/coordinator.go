@@ -0,0 +1,357 @@
+package threshold
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/delegation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// IntentOperate is the ticket intent operators use to drive signing
+// sessions.
+const IntentOperate = "threshold"
+
+// DefaultSessionTTL is how long a session may take when
+// Config.SessionTTL is zero.
+const DefaultSessionTTL = 10 * time.Minute
+
+// keyPrefix namespaces sessions in the store; a session lives under
+// keyPrefix + reef + "/" + ID.
+const keyPrefix = "threshold/"
+
+// Session states.
+const (
+	// StatusCommitting collects round-one commitments.
+	StatusCommitting = "committing"
+
+	// StatusSigning collects signature shares over the frozen list.
+	StatusSigning = "signing"
+
+	// StatusComplete carries the signed ticket.
+	StatusComplete = "complete"
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown or expired sessions.
+	ErrSessionNotFound = errors.New("signing session not found")
+
+	// ErrSessionState is returned when a commitment or share arrives in
+	// the wrong state, twice from the same operator, or as a share from
+	// an operator that did not commit.
+	ErrSessionState = errors.New("signing session is not accepting this step")
+)
+
+// Request describes the root ticket a session mints.
+type Request struct {
+	ReefID   string `json:"reef_id"`
+	ColonyID string `json:"colony_id"`
+	AgentID  string `json:"agent_id"`
+	Intent   string `json:"intent"`
+
+	// TTLSeconds is the ticket lifetime, counted from session start.
+	TTLSeconds int `json:"ttl_seconds"`
+
+	// DelegationKey optionally makes the ticket delegable (see
+	// delegation.Claims).
+	DelegationKey string `json:"delegation_key,omitempty"`
+}
+
+// Session is a signing session. Message is the JWT signing string of the
+// ticket; operators sign it once Commitments is complete.
+type Session struct {
+	ID          string            `json:"id"`
+	ReefID      string            `json:"reef_id"`
+	Status      string            `json:"status"`
+	KeyID       string            `json:"key_id"`
+	Threshold   int               `json:"threshold"`
+	Message     string            `json:"message"`
+	Commitments []Commitment      `json:"commitments"`
+	Shares      map[uint16]string `json:"shares,omitempty"`
+	Ticket      string            `json:"ticket,omitempty"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+// Config holds the configuration for a Coordinator.
+type Config struct {
+	// Keys is the public side of the threshold key. Required, with an
+	// operator named for every share.
+	Keys *PublicKeys
+
+	// Store holds sessions. Defaults to an in-memory store; use a shared
+	// backend when several instances coordinate.
+	Store store.Store
+
+	// SessionTTL bounds how long operators have to finish a session.
+	// Defaults to DefaultSessionTTL.
+	SessionTTL time.Duration
+}
+
+// Coordinator runs signing sessions. It only ever sees public data:
+// commitments and signature shares, which it verifies before aggregating.
+type Coordinator struct {
+	keys      *PublicKeys
+	operators map[string]uint16
+	store     store.Store
+	ttl       time.Duration
+}
+
+// New creates a Coordinator from cfg.
+func New(cfg Config) (*Coordinator, error) {
+	if cfg.Keys == nil {
+		return nil, errors.New("threshold: Keys is required")
+	}
+	if _, err := cfg.Keys.PublicKey(); err != nil {
+		return nil, err
+	}
+	if cfg.Keys.Threshold < 2 || cfg.Keys.Threshold > len(cfg.Keys.VerifyingShares) {
+		return nil, fmt.Errorf("%w: threshold %d of %d operators", ErrInvalidKey, cfg.Keys.Threshold, len(cfg.Keys.VerifyingShares))
+	}
+	operators := make(map[string]uint16, len(cfg.Keys.VerifyingShares))
+	for id := range cfg.Keys.VerifyingShares {
+		agent := cfg.Keys.Operators[id]
+		if agent == "" {
+			return nil, fmt.Errorf("%w: no operator named for share %d", ErrInvalidKey, id)
+		}
+		if _, ok := operators[agent]; ok {
+			return nil, fmt.Errorf("%w: operator %s holds two shares", ErrInvalidKey, agent)
+		}
+		operators[agent] = id
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	ttl := cfg.SessionTTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &Coordinator{keys: cfg.Keys, operators: operators, store: st, ttl: ttl}, nil
+}
+
+// Operator returns the identifier of the share agentID holds.
+func (c *Coordinator) Operator(agentID string) (uint16, bool) {
+	id, ok := c.operators[agentID]
+	return id, ok
+}
+
+// Start opens a session of req.ReefID for the ticket described by req.
+func (c *Coordinator) Start(ctx context.Context, req *Request) (*Session, error) {
+	if req.ReefID == "" || req.ColonyID == "" || req.AgentID == "" || req.Intent == "" || req.TTLSeconds <= 0 {
+		return nil, errors.New("reef_id, colony_id, agent_id, intent and ttl_seconds are required")
+	}
+	if strings.Contains(req.ReefID, "/") {
+		return nil, errors.New("reef_id must not contain '/'")
+	}
+
+	now := time.Now()
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, &delegation.Claims{
+		ReferralClaims: jwt.ReferralClaims{
+			ReefID:   req.ReefID,
+			ColonyID: req.ColonyID,
+			AgentID:  req.AgentID,
+			Intent:   req.Intent,
+			RegisteredClaims: gojwt.RegisteredClaims{
+				ID:        uuid.New().String(),
+				Issuer:    jwt.DefaultIssuer,
+				Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+				IssuedAt:  gojwt.NewNumericDate(now),
+				NotBefore: gojwt.NewNumericDate(now),
+				ExpiresAt: gojwt.NewNumericDate(now.Add(time.Duration(req.TTLSeconds) * time.Second)),
+			},
+		},
+		DelegationKey: req.DelegationKey,
+	})
+	token.Header["kid"] = c.keys.KeyID
+	message, err := token.SigningString()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	s := &Session{
+		ID:        uuid.New().String(),
+		ReefID:    req.ReefID,
+		Status:    StatusCommitting,
+		KeyID:     c.keys.KeyID,
+		Threshold: c.keys.Threshold,
+		Message:   message,
+		ExpiresAt: now.Add(c.ttl).UTC(),
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.store.CompareAndSwap(ctx, sessionKey(s.ReefID, s.ID), 0, data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Session returns the session of reefID with id.
+func (c *Coordinator) Session(ctx context.Context, reefID, id string) (*Session, error) {
+	s, _, err := c.load(ctx, reefID, id)
+	return s, err
+}
+
+// Commit adds an operator's commitment to session id of reefID. The list
+// is frozen, and signing starts, once it holds Threshold commitments.
+func (c *Coordinator) Commit(ctx context.Context, reefID, id string, com Commitment) (*Session, error) {
+	if _, ok := c.keys.VerifyingShares[com.Identifier]; !ok {
+		return nil, fmt.Errorf("%w: unknown operator %d", ErrInvalidCommitments, com.Identifier)
+	}
+	if _, err := decodePointFrom(com.Hiding); err != nil {
+		return nil, fmt.Errorf("%w: operator %d: %v", ErrInvalidCommitments, com.Identifier, err)
+	}
+	if _, err := decodePointFrom(com.Binding); err != nil {
+		return nil, fmt.Errorf("%w: operator %d: %v", ErrInvalidCommitments, com.Identifier, err)
+	}
+
+	return c.update(ctx, reefID, id, func(s *Session) error {
+		if s.Status != StatusCommitting {
+			return fmt.Errorf("%w: session is %s", ErrSessionState, s.Status)
+		}
+		for _, existing := range s.Commitments {
+			if existing.Identifier == com.Identifier {
+				return fmt.Errorf("%w: operator %d already committed", ErrSessionState, com.Identifier)
+			}
+		}
+		s.Commitments = append(s.Commitments, com)
+		if len(s.Commitments) == s.Threshold {
+			s.Status = StatusSigning
+		}
+		return nil
+	})
+}
+
+// SubmitShare adds an operator's signature share to session id of reefID
+// after verifying it. Only operators whose commitment was frozen sign.
+// The last share completes the session with the signed ticket.
+func (c *Coordinator) SubmitShare(ctx context.Context, reefID, id string, identifier uint16, share string) (*Session, error) {
+	return c.update(ctx, reefID, id, func(s *Session) error {
+		if s.Status != StatusSigning {
+			return fmt.Errorf("%w: session is %s", ErrSessionState, s.Status)
+		}
+		if !slices.ContainsFunc(s.Commitments, func(com Commitment) bool { return com.Identifier == identifier }) {
+			return fmt.Errorf("%w: operator %d did not commit", ErrSessionState, identifier)
+		}
+		if _, ok := s.Shares[identifier]; ok {
+			return fmt.Errorf("%w: operator %d already signed", ErrSessionState, identifier)
+		}
+		message := []byte(s.Message)
+		if err := c.keys.VerifyShare(identifier, share, message, s.Commitments); err != nil {
+			return err
+		}
+		if s.Shares == nil {
+			s.Shares = make(map[uint16]string, s.Threshold)
+		}
+		s.Shares[identifier] = share
+		if len(s.Shares) < len(s.Commitments) {
+			return nil
+		}
+
+		sig, err := c.keys.Aggregate(message, s.Commitments, s.Shares)
+		if err != nil {
+			return err
+		}
+		s.Ticket = s.Message + "." + base64.RawURLEncoding.EncodeToString(sig)
+		s.Status = StatusComplete
+		return nil
+	})
+}
+
+// Prune deletes expired sessions and returns how many were removed.
+func (c *Coordinator) Prune(ctx context.Context) (int, error) {
+	entries, err := c.store.List(ctx, keyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	pruned := 0
+	for _, e := range entries {
+		var s Session
+		if err := json.Unmarshal(e.Value, &s); err == nil && now.Before(s.ExpiresAt) {
+			continue
+		}
+		if err := c.store.Delete(ctx, e.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (c *Coordinator) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = c.Prune(ctx)
+		}
+	}
+}
+
+// load reads a live session of reefID and its revision.
+func (c *Coordinator) load(ctx context.Context, reefID, id string) (*Session, uint64, error) {
+	entry, err := c.store.Get(ctx, sessionKey(reefID, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var s Session
+	if err := json.Unmarshal(entry.Value, &s); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	if !time.Now().Before(s.ExpiresAt) {
+		return nil, 0, fmt.Errorf("%w: %s expired", ErrSessionNotFound, id)
+	}
+	return &s, entry.Revision, nil
+}
+
+// update applies fn to session id of reefID, retrying on concurrent
+// writes.
+func (c *Coordinator) update(ctx context.Context, reefID, id string, fn func(*Session) error) (*Session, error) {
+	for {
+		s, rev, err := c.load(ctx, reefID, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(s); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		_, err = c.store.CompareAndSwap(ctx, sessionKey(reefID, id), rev, data)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// sessionKey returns the store key of session id of reefID.
+func sessionKey(reefID, id string) string {
+	return keyPrefix + reefID + "/" + id
+}
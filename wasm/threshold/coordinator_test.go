@@ -0,0 +1,40 @@
+package threshold_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// TestOperators checks that a coordinator needs a distinct agent named
+// for every share, and maps each back to its share.
+func TestOperators(t *testing.T) {
+	_, pub, err := threshold.Deal("root", nil, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, operators := range map[string]map[uint16]string{
+		"none":     nil,
+		"missing":  {1: "alice", 2: "bob"},
+		"repeated": {1: "alice", 2: "bob", 3: "alice"},
+		"empty ID": {1: "alice", 2: "bob", 3: ""},
+	} {
+		pub.Operators = operators
+		if _, err := threshold.New(threshold.Config{Keys: pub}); !errors.Is(err, threshold.ErrInvalidKey) {
+			t.Errorf("%s: %v, want ErrInvalidKey", name, err)
+		}
+	}
+
+	pub.Operators = map[uint16]string{1: "alice", 2: "bob", 3: "carol"}
+	c, err := threshold.New(threshold.Config{Keys: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := c.Operator("bob"); !ok || id != 2 {
+		t.Fatalf("bob holds share %d (%v), want 2", id, ok)
+	}
+	if _, ok := c.Operator("mallory"); ok {
+		t.Fatal("an agent without a share was named an operator")
+	}
+}
@@ -0,0 +1,193 @@
+package threshold
+
+import (
+	"crypto/sha512"
+	"errors"
+	"math/big"
+)
+
+// This file implements the edwards25519 group needed by FROST using
+// math/big. The arithmetic is variable-time; it only ever handles one
+// operator's share at a time and is not meant for high-volume signing.
+
+var (
+	// fieldP is the field prime 2^255 - 19.
+	fieldP = mustInt("57896044618658097711785492504343953926634992332820282019728792003956564819949")
+
+	// orderL is the prime order of the base point.
+	orderL = mustInt("7237005577332262213973186563042994240857116359379907606001950938285454250989")
+
+	// curveD is the curve constant -121665/121666.
+	curveD = mustInt("37095705934669439343138083508754565189542113879843219016388785533085940283555")
+
+	// sqrtM1 is a square root of -1 mod p.
+	sqrtM1 = mustInt("19681161376707505956807079304988542015446066515923890162744021073123829784752")
+
+	basePoint = &point{
+		x: mustInt("15112221349535400772501151409588531511454012693041857206046113283949847762202"),
+		y: mustInt("46316835694926478169428394003475163141307993866256225615783033603165251855960"),
+		z: big.NewInt(1),
+		t: mustInt("46827403850823179245072216630277197565144205554125654976674165829533817101731"),
+	}
+
+	errInvalidPoint  = errors.New("invalid group element")
+	errInvalidScalar = errors.New("invalid scalar")
+)
+
+func mustInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("threshold: bad constant " + s)
+	}
+	return n
+}
+
+// point is an edwards25519 point in extended coordinates (X:Y:Z:T) with
+// x = X/Z, y = Y/Z and xy = T/Z.
+type point struct {
+	x, y, z, t *big.Int
+}
+
+func identity() *point {
+	return &point{x: big.NewInt(0), y: big.NewInt(1), z: big.NewInt(1), t: big.NewInt(0)}
+}
+
+func feMul(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Mul(a, b), fieldP) }
+func feAdd(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Add(a, b), fieldP) }
+func feSub(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Sub(a, b), fieldP) }
+
+// add returns p + q using the complete unified formula for a = -1
+// (add-2008-hwcd-3), which also covers doubling.
+func (p *point) add(q *point) *point {
+	a := feMul(feSub(p.y, p.x), feSub(q.y, q.x))
+	b := feMul(feAdd(p.y, p.x), feAdd(q.y, q.x))
+	c := feMul(feMul(p.t, q.t), feMul(big.NewInt(2), curveD))
+	d := feMul(feMul(p.z, q.z), big.NewInt(2))
+	e, f, g, h := feSub(b, a), feSub(d, c), feAdd(d, c), feAdd(b, a)
+	return &point{x: feMul(e, f), y: feMul(g, h), z: feMul(f, g), t: feMul(e, h)}
+}
+
+// mul returns k·p for a non-negative k.
+func (p *point) mul(k *big.Int) *point {
+	r := identity()
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = r.add(r)
+		if k.Bit(i) == 1 {
+			r = r.add(p)
+		}
+	}
+	return r
+}
+
+func (p *point) equal(q *point) bool {
+	// x1/z1 == x2/z2 and y1/z1 == y2/z2, cross-multiplied.
+	return feMul(p.x, q.z).Cmp(feMul(q.x, p.z)) == 0 && feMul(p.y, q.z).Cmp(feMul(q.y, p.z)) == 0
+}
+
+func (p *point) isIdentity() bool {
+	return p.equal(identity())
+}
+
+// bytes encodes p per RFC 8032 section 5.1.2.
+func (p *point) bytes() []byte {
+	zInv := new(big.Int).ModInverse(p.z, fieldP)
+	x, y := feMul(p.x, zInv), feMul(p.y, zInv)
+	out := leBytes(y)
+	out[31] |= byte(x.Bit(0)) << 7
+	return out
+}
+
+// decodePoint decodes an RFC 8032 point encoding and checks that it is a
+// non-identity element of the prime-order subgroup, as RFC 9591 requires.
+func decodePoint(b []byte) (*point, error) {
+	if len(b) != 32 {
+		return nil, errInvalidPoint
+	}
+	enc := append([]byte(nil), b...)
+	sign := enc[31] >> 7
+	enc[31] &= 0x7f
+	y := fromLE(enc)
+	if y.Cmp(fieldP) >= 0 {
+		return nil, errInvalidPoint
+	}
+
+	// x^2 = (y^2 - 1) / (d y^2 + 1)
+	y2 := feMul(y, y)
+	u := feSub(y2, big.NewInt(1))
+	v := feAdd(feMul(curveD, y2), big.NewInt(1))
+	v3 := feMul(feMul(v, v), v)
+	v7 := feMul(feMul(v3, v3), v)
+	exp := new(big.Int).Rsh(new(big.Int).Sub(fieldP, big.NewInt(5)), 3)
+	x := feMul(feMul(u, v3), new(big.Int).Exp(feMul(u, v7), exp, fieldP))
+
+	vx2 := feMul(v, feMul(x, x))
+	switch {
+	case vx2.Cmp(u) == 0:
+	case vx2.Cmp(feSub(big.NewInt(0), u)) == 0:
+		x = feMul(x, sqrtM1)
+	default:
+		return nil, errInvalidPoint
+	}
+	if x.Sign() == 0 && sign == 1 {
+		return nil, errInvalidPoint
+	}
+	if byte(x.Bit(0)) != sign {
+		x = feSub(big.NewInt(0), x)
+	}
+
+	p := &point{x: x, y: y, z: big.NewInt(1), t: feMul(x, y)}
+	if p.isIdentity() || !p.mul(orderL).isIdentity() {
+		return nil, errInvalidPoint
+	}
+	return p, nil
+}
+
+// scalarBytes encodes a scalar as 32 little-endian bytes.
+func scalarBytes(s *big.Int) []byte {
+	return leBytes(s)
+}
+
+// decodeScalar decodes a canonical little-endian scalar.
+func decodeScalar(b []byte) (*big.Int, error) {
+	if len(b) != 32 {
+		return nil, errInvalidScalar
+	}
+	s := fromLE(b)
+	if s.Cmp(orderL) >= 0 {
+		return nil, errInvalidScalar
+	}
+	return s, nil
+}
+
+// hashToScalar is SHA-512 over parts, read little-endian and reduced
+// mod L.
+func hashToScalar(parts ...[]byte) *big.Int {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return new(big.Int).Mod(fromLE(h.Sum(nil)), orderL)
+}
+
+func scMul(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Mul(a, b), orderL) }
+func scAdd(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Add(a, b), orderL) }
+func scSub(a, b *big.Int) *big.Int { return new(big.Int).Mod(new(big.Int).Sub(a, b), orderL) }
+func scInv(a *big.Int) *big.Int    { return new(big.Int).ModInverse(a, orderL) }
+
+// leBytes encodes n as 32 little-endian bytes.
+func leBytes(n *big.Int) []byte {
+	be := n.FillBytes(make([]byte, 32))
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		be[i], be[j] = be[j], be[i]
+	}
+	return be
+}
+
+// fromLE decodes little-endian bytes.
+func fromLE(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
@@ -0,0 +1,467 @@
+// Package threshold implements FROST threshold Ed25519 signing
+// (RFC 9591, FROST(Ed25519, SHA-512)) so that a root referral ticket can
+// only be minted when k of n colony operators cooperate. Signatures are
+// plain Ed25519 signatures under the group public key, so tickets verify
+// with the existing JWKS and validators.
+//
+// A trusted dealer splits the key once (Deal). To sign, each participating
+// operator publishes a commitment (Commit), then a signature share over
+// the message and the full commitment list (Sign). Any party, typically
+// the Coordinator, checks the shares and aggregates them (Aggregate).
+package threshold
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// contextString is the RFC 9591 ciphersuite context string.
+const contextString = "FROST-ED25519-SHA512-v1"
+
+var (
+	// ErrInvalidKey is returned for malformed shares, group keys or
+	// threshold parameters.
+	ErrInvalidKey = errors.New("invalid threshold key")
+
+	// ErrInvalidCommitments is returned for commitment lists that are too
+	// short, contain duplicates or unknown identifiers, or omit the
+	// signer.
+	ErrInvalidCommitments = errors.New("invalid commitment list")
+
+	// ErrInvalidShare is returned when a signature share does not verify
+	// under its operator's verifying share.
+	ErrInvalidShare = errors.New("invalid signature share")
+)
+
+// KeyShare is one operator's share of a threshold key. Secret must never
+// leave the operator.
+type KeyShare struct {
+	Identifier uint16 `json:"identifier"`
+	Secret     string `json:"secret"`
+	KeyID      string `json:"key_id"`
+	GroupKey   string `json:"group_key"`
+	Threshold  int    `json:"threshold"`
+}
+
+// PublicKeys is the public side of a threshold key: the group key, which
+// is published in the JWKS under KeyID like any Ed25519 key, and each
+// operator's verifying share.
+type PublicKeys struct {
+	KeyID           string            `json:"key_id"`
+	GroupKey        string            `json:"group_key"`
+	Threshold       int               `json:"threshold"`
+	VerifyingShares map[uint16]string `json:"verifying_shares"`
+
+	// Operators names the agent holding each share, by identifier. A
+	// Coordinator takes an operator's commitments and signature shares
+	// only under that agent's tickets.
+	Operators map[uint16]string `json:"operators,omitempty"`
+}
+
+// Nonces are the secret nonces behind a Commitment. They must be used for
+// exactly one Sign call and then discarded.
+type Nonces struct {
+	Hiding  string `json:"hiding"`
+	Binding string `json:"binding"`
+}
+
+// Commitment is an operator's public round-one commitment.
+type Commitment struct {
+	Identifier uint16 `json:"identifier"`
+	Hiding     string `json:"hiding"`
+	Binding    string `json:"binding"`
+}
+
+// Deal splits key into n shares, any threshold of which can sign under
+// key's public key. A nil key deals a fresh random key that never exists
+// in one place after Deal returns.
+func Deal(kid string, key ed25519.PrivateKey, threshold, n int) ([]*KeyShare, *PublicKeys, error) {
+	if threshold < 2 || threshold > n || n > 0xffff {
+		return nil, nil, fmt.Errorf("%w: need 2 <= threshold <= n, got %d of %d", ErrInvalidKey, threshold, n)
+	}
+
+	var secret *big.Int
+	if key == nil {
+		s, err := randomScalar()
+		if err != nil {
+			return nil, nil, err
+		}
+		secret = s
+	} else {
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, nil, fmt.Errorf("%w: bad Ed25519 private key", ErrInvalidKey)
+		}
+		secret = expandedScalar(key.Seed())
+	}
+
+	// f(x) = secret + a1 x + ... + a(t-1) x^(t-1)
+	coeffs := []*big.Int{secret}
+	for i := 1; i < threshold; i++ {
+		c, err := randomScalar()
+		if err != nil {
+			return nil, nil, err
+		}
+		coeffs = append(coeffs, c)
+	}
+
+	groupKey := encode(basePoint.mul(secret).bytes())
+	pub := &PublicKeys{
+		KeyID:           kid,
+		GroupKey:        groupKey,
+		Threshold:       threshold,
+		VerifyingShares: make(map[uint16]string, n),
+	}
+	shares := make([]*KeyShare, n)
+	for i := 1; i <= n; i++ {
+		x := big.NewInt(int64(i))
+		y := new(big.Int)
+		for j := len(coeffs) - 1; j >= 0; j-- {
+			y = scAdd(scMul(y, x), coeffs[j])
+		}
+		id := uint16(i)
+		shares[i-1] = &KeyShare{
+			Identifier: id,
+			Secret:     encode(scalarBytes(y)),
+			KeyID:      kid,
+			GroupKey:   groupKey,
+			Threshold:  threshold,
+		}
+		pub.VerifyingShares[id] = encode(basePoint.mul(y).bytes())
+	}
+	return shares, pub, nil
+}
+
+// PublicKey returns the group key.
+func (pk *PublicKeys) PublicKey() (ed25519.PublicKey, error) {
+	b, err := decodePointString(pk.GroupKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: group key: %v", ErrInvalidKey, err)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Commit generates fresh nonces for share and the commitment to publish.
+func Commit(share *KeyShare) (*Nonces, *Commitment, error) {
+	secret, err := decodeScalarString(share.Secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: share secret: %v", ErrInvalidKey, err)
+	}
+	hiding, err := generateNonce(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	binding, err := generateNonce(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Nonces{
+		Hiding:  encode(scalarBytes(hiding)),
+		Binding: encode(scalarBytes(binding)),
+	}, &Commitment{
+		Identifier: share.Identifier,
+		Hiding:     encode(basePoint.mul(hiding).bytes()),
+		Binding:    encode(basePoint.mul(binding).bytes()),
+	}, nil
+}
+
+// Sign returns share's signature share over message. commitments must hold
+// exactly the participating operators' commitments, including the one
+// made with nonces.
+func Sign(share *KeyShare, nonces *Nonces, message []byte, commitments []Commitment) (string, error) {
+	secret, err := decodeScalarString(share.Secret)
+	if err != nil {
+		return "", fmt.Errorf("%w: share secret: %v", ErrInvalidKey, err)
+	}
+	groupKey, err := decodePointString(share.GroupKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: group key: %v", ErrInvalidKey, err)
+	}
+	hiding, err := decodeScalarString(nonces.Hiding)
+	if err != nil {
+		return "", fmt.Errorf("%w: nonce: %v", ErrInvalidKey, err)
+	}
+	binding, err := decodeScalarString(nonces.Binding)
+	if err != nil {
+		return "", fmt.Errorf("%w: nonce: %v", ErrInvalidKey, err)
+	}
+
+	list, err := parseCommitments(commitments, share.Threshold)
+	if err != nil {
+		return "", err
+	}
+	own, ok := list.find(share.Identifier)
+	if !ok {
+		return "", fmt.Errorf("%w: operator %d is not in the list", ErrInvalidCommitments, share.Identifier)
+	}
+	if !own.hiding.equal(basePoint.mul(hiding)) || !own.binding.equal(basePoint.mul(binding)) {
+		return "", fmt.Errorf("%w: operator %d's commitment does not match its nonces", ErrInvalidCommitments, share.Identifier)
+	}
+
+	rho := list.bindingFactors(groupKey, message)
+	r := list.groupCommitment(rho)
+	c := challenge(r, groupKey, message)
+	lambda := list.lagrange(share.Identifier)
+
+	z := scAdd(scAdd(hiding, scMul(binding, rho[share.Identifier])), scMul(scMul(lambda, secret), c))
+	return encode(scalarBytes(z)), nil
+}
+
+// VerifyShare checks operator id's signature share.
+func (pk *PublicKeys) VerifyShare(id uint16, sigShare string, message []byte, commitments []Commitment) error {
+	groupKey, err := decodePointString(pk.GroupKey)
+	if err != nil {
+		return fmt.Errorf("%w: group key: %v", ErrInvalidKey, err)
+	}
+	list, err := pk.parseCommitments(commitments)
+	if err != nil {
+		return err
+	}
+	rho := list.bindingFactors(groupKey, message)
+	return pk.verifyShare(list, rho, list.groupCommitment(rho), groupKey, id, sigShare, message)
+}
+
+// Aggregate verifies every share and combines them into an Ed25519
+// signature over message. shares is keyed by identifier and must cover
+// every commitment. A failing share is reported with its identifier.
+func (pk *PublicKeys) Aggregate(message []byte, commitments []Commitment, shares map[uint16]string) ([]byte, error) {
+	groupKey, err := decodePointString(pk.GroupKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: group key: %v", ErrInvalidKey, err)
+	}
+	list, err := pk.parseCommitments(commitments)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) != len(list) {
+		return nil, fmt.Errorf("%w: %d shares for %d commitments", ErrInvalidShare, len(shares), len(list))
+	}
+
+	rho := list.bindingFactors(groupKey, message)
+	r := list.groupCommitment(rho)
+	z := new(big.Int)
+	for _, com := range list {
+		share, ok := shares[com.id]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing share from operator %d", ErrInvalidShare, com.id)
+		}
+		if err := pk.verifyShare(list, rho, r, groupKey, com.id, share, message); err != nil {
+			return nil, err
+		}
+		zi, _ := decodeScalarString(share)
+		z = scAdd(z, zi)
+	}
+
+	sig := append(r.bytes(), scalarBytes(z)...)
+	if !ed25519.Verify(ed25519.PublicKey(groupKey), message, sig) {
+		return nil, fmt.Errorf("%w: aggregate signature does not verify", ErrInvalidShare)
+	}
+	return sig, nil
+}
+
+// verifyShare checks z_i·B == D_i + rho_i·E_i + (c·lambda_i)·Y_i.
+func (pk *PublicKeys) verifyShare(list commitmentList, rho map[uint16]*big.Int, r *point, groupKey []byte, id uint16, sigShare string, message []byte) error {
+	com, ok := list.find(id)
+	if !ok {
+		return fmt.Errorf("%w: operator %d is not in the list", ErrInvalidCommitments, id)
+	}
+	z, err := decodeScalarString(sigShare)
+	if err != nil {
+		return fmt.Errorf("%w: operator %d: %v", ErrInvalidShare, id, err)
+	}
+	y, err := decodePointFrom(pk.VerifyingShares[id])
+	if err != nil {
+		return fmt.Errorf("%w: verifying share %d: %v", ErrInvalidKey, id, err)
+	}
+
+	c := challenge(r, groupKey, message)
+	want := com.hiding.add(com.binding.mul(rho[id])).add(y.mul(scMul(c, list.lagrange(id))))
+	if !basePoint.mul(z).equal(want) {
+		return fmt.Errorf("%w: operator %d", ErrInvalidShare, id)
+	}
+	return nil
+}
+
+// commitment is a decoded Commitment.
+type commitment struct {
+	id      uint16
+	hiding  *point
+	binding *point
+}
+
+// commitmentList is a validated commitment list sorted by identifier.
+type commitmentList []commitment
+
+// parseCommitments decodes commitments for this key, checking that every
+// identifier has a verifying share.
+func (pk *PublicKeys) parseCommitments(commitments []Commitment) (commitmentList, error) {
+	list, err := parseCommitments(commitments, pk.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, com := range list {
+		if _, ok := pk.VerifyingShares[com.id]; !ok {
+			return nil, fmt.Errorf("%w: unknown operator %d", ErrInvalidCommitments, com.id)
+		}
+	}
+	return list, nil
+}
+
+// parseCommitments decodes and sorts commitments, requiring at least
+// threshold distinct non-zero identifiers.
+func parseCommitments(commitments []Commitment, threshold int) (commitmentList, error) {
+	if threshold < 2 || len(commitments) < threshold {
+		return nil, fmt.Errorf("%w: %d commitments for threshold %d", ErrInvalidCommitments, len(commitments), threshold)
+	}
+	list := make(commitmentList, 0, len(commitments))
+	seen := make(map[uint16]bool, len(commitments))
+	for _, c := range commitments {
+		if c.Identifier == 0 || seen[c.Identifier] {
+			return nil, fmt.Errorf("%w: bad or duplicate identifier %d", ErrInvalidCommitments, c.Identifier)
+		}
+		seen[c.Identifier] = true
+		hiding, err := decodePointFrom(c.Hiding)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operator %d: %v", ErrInvalidCommitments, c.Identifier, err)
+		}
+		binding, err := decodePointFrom(c.Binding)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operator %d: %v", ErrInvalidCommitments, c.Identifier, err)
+		}
+		list = append(list, commitment{id: c.Identifier, hiding: hiding, binding: binding})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list, nil
+}
+
+func (l commitmentList) find(id uint16) (commitment, bool) {
+	for _, c := range l {
+		if c.id == id {
+			return c, true
+		}
+	}
+	return commitment{}, false
+}
+
+// bindingFactors computes each participant's binding factor (RFC 9591
+// section 4.4).
+func (l commitmentList) bindingFactors(groupKey, message []byte) map[uint16]*big.Int {
+	var encoded []byte
+	for _, c := range l {
+		encoded = append(encoded, scalarBytes(big.NewInt(int64(c.id)))...)
+		encoded = append(encoded, c.hiding.bytes()...)
+		encoded = append(encoded, c.binding.bytes()...)
+	}
+	prefix := append(append(append([]byte(nil), groupKey...), h4(message)...), h5(encoded)...)
+
+	rho := make(map[uint16]*big.Int, len(l))
+	for _, c := range l {
+		input := append(append([]byte(nil), prefix...), scalarBytes(big.NewInt(int64(c.id)))...)
+		rho[c.id] = hashToScalar([]byte(contextString+"rho"), input)
+	}
+	return rho
+}
+
+// groupCommitment is R = sum(D_i + rho_i·E_i).
+func (l commitmentList) groupCommitment(rho map[uint16]*big.Int) *point {
+	r := identity()
+	for _, c := range l {
+		r = r.add(c.hiding).add(c.binding.mul(rho[c.id]))
+	}
+	return r
+}
+
+// lagrange is participant id's Lagrange coefficient at zero over the
+// identifiers in l.
+func (l commitmentList) lagrange(id uint16) *big.Int {
+	num, den := big.NewInt(1), big.NewInt(1)
+	xi := big.NewInt(int64(id))
+	for _, c := range l {
+		if c.id == id {
+			continue
+		}
+		xj := big.NewInt(int64(c.id))
+		num = scMul(num, xj)
+		den = scMul(den, scSub(xj, xi))
+	}
+	return scMul(num, scInv(den))
+}
+
+// challenge is the Ed25519 challenge H(R || A || M) mod L, which makes the
+// aggregate a standard Ed25519 signature.
+func challenge(r *point, groupKey, message []byte) *big.Int {
+	return hashToScalar(r.bytes(), groupKey, message)
+}
+
+func h4(m []byte) []byte {
+	h := sha512.Sum512(append([]byte(contextString+"msg"), m...))
+	return h[:]
+}
+
+func h5(m []byte) []byte {
+	h := sha512.Sum512(append([]byte(contextString+"com"), m...))
+	return h[:]
+}
+
+// generateNonce implements RFC 9591 nonce_generate: random bytes hashed
+// together with the secret so a weak RNG alone does not leak the share.
+func generateNonce(secret *big.Int) (*big.Int, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return hashToScalar([]byte(contextString+"nonce"), random, scalarBytes(secret)), nil
+}
+
+func randomScalar() (*big.Int, error) {
+	b := make([]byte, 64)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate scalar: %w", err)
+	}
+	return new(big.Int).Mod(fromLE(b), orderL), nil
+}
+
+// expandedScalar is the Ed25519 secret scalar of seed (RFC 8032 section
+// 5.1.5), reduced mod L. seed's public key is this scalar times B.
+func expandedScalar(seed []byte) *big.Int {
+	h := sha512.Sum512(seed)
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return new(big.Int).Mod(fromLE(h[:32]), orderL)
+}
+
+func encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func decodeScalarString(s string) (*big.Int, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return decodeScalar(b)
+}
+
+// decodePointString checks that s encodes a valid group element and
+// returns its canonical encoding.
+func decodePointString(s string) ([]byte, error) {
+	p, err := decodePointFrom(s)
+	if err != nil {
+		return nil, err
+	}
+	return p.bytes(), nil
+}
+
+func decodePointFrom(s string) (*point, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return decodePoint(b)
+}
@@ -0,0 +1,156 @@
+package threshold_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// sign runs both FROST rounds for signers and returns the commitments and
+// the signature shares by identifier.
+func sign(t *testing.T, signers []*threshold.KeyShare, message []byte) ([]threshold.Commitment, map[uint16]string) {
+	t.Helper()
+	nonces := make([]*threshold.Nonces, len(signers))
+	var commitments []threshold.Commitment
+	for i, share := range signers {
+		n, com, err := threshold.Commit(share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonces[i] = n
+		commitments = append(commitments, *com)
+	}
+	shares := make(map[uint16]string, len(signers))
+	for i, share := range signers {
+		z, err := threshold.Sign(share, nonces[i], message, commitments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares[share.Identifier] = z
+	}
+	return commitments, shares
+}
+
+// TestRoundTrip deals an existing key 2 of 3 and checks that every pair
+// of operators produces a signature ed25519.Verify accepts under it.
+func TestRoundTrip(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	shares, pub, err := threshold.Deal("root", key, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupKey, err := pub.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !groupKey.Equal(key.Public()) {
+		t.Fatalf("group key %x, want the dealt key's %x", groupKey, key.Public())
+	}
+
+	message := []byte("header.claims")
+	for _, pair := range [][2]int{{0, 1}, {0, 2}, {1, 2}, {2, 0}} {
+		signers := []*threshold.KeyShare{shares[pair[0]], shares[pair[1]]}
+		commitments, sigShares := sign(t, signers, message)
+		for id, z := range sigShares {
+			if err := pub.VerifyShare(id, z, message, commitments); err != nil {
+				t.Fatalf("share of operator %d: %v", id, err)
+			}
+		}
+		sig, err := pub.Aggregate(message, commitments, sigShares)
+		if err != nil {
+			t.Fatalf("operators %v: %v", pair, err)
+		}
+		if !ed25519.Verify(groupKey, message, sig) {
+			t.Fatalf("operators %v: signature does not verify", pair)
+		}
+		if ed25519.Verify(groupKey, []byte("other"), sig) {
+			t.Fatalf("operators %v: signature verifies another message", pair)
+		}
+	}
+}
+
+// TestFreshKey deals a random key 3 of 5 and signs with all five.
+func TestFreshKey(t *testing.T) {
+	shares, pub, err := threshold.Deal("root", nil, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupKey, err := pub.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("ticket")
+	commitments, sigShares := sign(t, shares, message)
+	sig, err := pub.Aggregate(message, commitments, sigShares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(groupKey, message, sig) {
+		t.Fatal("signature does not verify")
+	}
+}
+
+func TestBadShare(t *testing.T) {
+	shares, pub, err := threshold.Deal("root", nil, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("ticket")
+	commitments, sigShares := sign(t, shares[:2], message)
+
+	// A share over another message, or another operator's share, fails
+	// with the operator named.
+	_, other := sign(t, shares[:2], []byte("forged"))
+	for name, z := range map[string]string{"other message": other[1], "swapped": sigShares[2]} {
+		bad := map[uint16]string{1: z, 2: sigShares[2]}
+		if err := pub.VerifyShare(1, z, message, commitments); !errors.Is(err, threshold.ErrInvalidShare) {
+			t.Errorf("%s: VerifyShare: %v, want ErrInvalidShare", name, err)
+		}
+		if _, err := pub.Aggregate(message, commitments, bad); !errors.Is(err, threshold.ErrInvalidShare) {
+			t.Errorf("%s: Aggregate: %v, want ErrInvalidShare", name, err)
+		}
+	}
+	if _, err := pub.Aggregate(message, commitments, map[uint16]string{1: sigShares[1]}); !errors.Is(err, threshold.ErrInvalidShare) {
+		t.Errorf("missing share: %v, want ErrInvalidShare", err)
+	}
+
+	// Nonces do not match another operator's commitment.
+	nonces, _, err := threshold.Commit(shares[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := threshold.Sign(shares[0], nonces, message, commitments); !errors.Is(err, threshold.ErrInvalidCommitments) {
+		t.Errorf("sign with unrelated nonces: %v, want ErrInvalidCommitments", err)
+	}
+}
+
+func TestBelowThreshold(t *testing.T) {
+	shares, pub, err := threshold.Deal("root", nil, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonces, com, err := threshold.Commit(shares[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, com2, err := threshold.Commit(shares[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments := []threshold.Commitment{*com, *com2}
+	if _, err := threshold.Sign(shares[0], nonces, []byte("ticket"), commitments); !errors.Is(err, threshold.ErrInvalidCommitments) {
+		t.Fatalf("sign with 2 of 3: %v, want ErrInvalidCommitments", err)
+	}
+	if _, err := pub.Aggregate([]byte("ticket"), commitments, map[uint16]string{1: "", 2: ""}); !errors.Is(err, threshold.ErrInvalidCommitments) {
+		t.Fatalf("aggregate 2 of 3: %v, want ErrInvalidCommitments", err)
+	}
+
+	for _, tn := range [][2]int{{1, 3}, {4, 3}, {0, 0}} {
+		if _, _, err := threshold.Deal("root", nil, tn[0], tn[1]); !errors.Is(err, threshold.ErrInvalidKey) {
+			t.Errorf("deal %d of %d: %v, want ErrInvalidKey", tn[0], tn[1], err)
+		}
+	}
+}
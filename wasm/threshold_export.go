@@ -0,0 +1,111 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/threshold"
+)
+
+// thresholdCommit runs an operator's first FROST round. The nonces are
+// secret and must be passed to exactly one thresholdSign call; the
+// commitment is published to the signing session.
+// Arguments: keyShareJSON
+// Returns: { nonces: string, commitment: string } or { error: { code, message, retryable } }
+func thresholdCommit(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: keyShareJSON")
+	}
+
+	var share threshold.KeyShare
+	if err := json.Unmarshal([]byte(args[0].String()), &share); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse key share: "+err.Error())
+	}
+	nonces, com, err := threshold.Commit(&share)
+	if err != nil {
+		return errorResult(errThreshold, err.Error())
+	}
+	noncesJSON, err := json.Marshal(nonces)
+	if err != nil {
+		return errorResult(errInternal, "failed to marshal nonces: "+err.Error())
+	}
+	comJSON, err := json.Marshal(com)
+	if err != nil {
+		return errorResult(errInternal, "failed to marshal commitment: "+err.Error())
+	}
+
+	return map[string]interface{}{
+		"nonces":     string(noncesJSON),
+		"commitment": string(comJSON),
+	}
+}
+
+// thresholdSign computes an operator's signature share over a session's
+// message once its commitment list is complete.
+// Arguments: keyShareJSON, noncesJSON, message, commitmentsJSON
+// Returns: { share: string } or { error: { code, message, retryable } }
+func thresholdSign(this js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return errorResult(errInvalidArgument, "expected 4 arguments: keyShareJSON, noncesJSON, message, commitmentsJSON")
+	}
+
+	var share threshold.KeyShare
+	if err := json.Unmarshal([]byte(args[0].String()), &share); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse key share: "+err.Error())
+	}
+	var nonces threshold.Nonces
+	if err := json.Unmarshal([]byte(args[1].String()), &nonces); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse nonces: "+err.Error())
+	}
+	var commitments []threshold.Commitment
+	if err := json.Unmarshal([]byte(args[3].String()), &commitments); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse commitments: "+err.Error())
+	}
+
+	sigShare, err := threshold.Sign(&share, &nonces, []byte(args[2].String()), commitments)
+	if err != nil {
+		return errorResult(errThreshold, err.Error())
+	}
+
+	return map[string]interface{}{
+		"share": sigShare,
+	}
+}
+
+// thresholdAggregate verifies signature shares and combines them into an
+// Ed25519 signature under the group key. For a session message (a JWT
+// signing string), jwt is the signed ticket.
+// Arguments: publicKeysJSON, message, commitmentsJSON, sharesJSON ({ identifier: share })
+// Returns: { signature: string, jwt: string } or { error: { code, message, retryable } }
+func thresholdAggregate(this js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return errorResult(errInvalidArgument, "expected 4 arguments: publicKeysJSON, message, commitmentsJSON, sharesJSON")
+	}
+
+	var pub threshold.PublicKeys
+	if err := json.Unmarshal([]byte(args[0].String()), &pub); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse public keys: "+err.Error())
+	}
+	message := args[1].String()
+	var commitments []threshold.Commitment
+	if err := json.Unmarshal([]byte(args[2].String()), &commitments); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse commitments: "+err.Error())
+	}
+	var shares map[uint16]string
+	if err := json.Unmarshal([]byte(args[3].String()), &shares); err != nil {
+		return errorResult(errInvalidArgument, "failed to parse shares: "+err.Error())
+	}
+
+	sig, err := pub.Aggregate([]byte(message), commitments, shares)
+	if err != nil {
+		return errorResult(errThreshold, err.Error())
+	}
+
+	return map[string]interface{}{
+		"signature": base64.StdEncoding.EncodeToString(sig),
+		"jwt":       message + "." + base64.RawURLEncoding.EncodeToString(sig),
+	}
+}
@@ -0,0 +1,104 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+)
+
+// ticketHeader is the subset of the JOSE header needed for routing.
+type ticketHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parseReferralTicket decodes a referral ticket without verifying it.
+// The result must only be used for routing (e.g. selecting a JWKS), never
+// for authorization decisions.
+// Arguments: tokenString
+// Returns: { reefID, colonyID, agentID, intent, exp, kid } or { error: { code, message, retryable } }
+func parseReferralTicket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errInvalidArgument, "expected 1 argument: tokenString")
+	}
+
+	header, claims, err := decodeTicket(args[0].String())
+	if err != nil {
+		return errorResult(errTokenMalformed, "failed to parse token: "+err.Error())
+	}
+
+	var exp int64
+	if claims.ExpiresAt != nil {
+		exp = claims.ExpiresAt.Unix()
+	}
+
+	return map[string]interface{}{
+		"reefID":   claims.ReefID,
+		"colonyID": claims.ColonyID,
+		"agentID":  claims.AgentID,
+		"intent":   claims.Intent,
+		"exp":      exp,
+		"kid":      header.Kid,
+	}
+}
+
+// decodeTicket splits a compact JWT and decodes its header and claims.
+// The signature segment is not checked.
+func decodeTicket(tokenString string) (*ticketHeader, *jwt.ReferralClaims, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("token must have three segments")
+	}
+
+	header, err := decodeHeader(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var claims jwt.ReferralClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, nil, errors.New("invalid claims: " + err.Error())
+	}
+
+	return header, &claims, nil
+}
+
+// decodeHeader decodes the base64url JOSE header segment of a JWT.
+func decodeHeader(segment string) (*ticketHeader, error) {
+	var header ticketHeader
+	if err := decodeSegment(segment, &header); err != nil {
+		return nil, errors.New("invalid header: " + err.Error())
+	}
+	return &header, nil
+}
+
+// tokenKeyID returns the kid from a compact JWT header.
+func tokenKeyID(tokenString string) (string, error) {
+	end := strings.IndexByte(tokenString, '.')
+	if end < 0 {
+		return "", errors.New("token must have three segments")
+	}
+	header, err := decodeHeader(tokenString[:end])
+	if err != nil {
+		return "", err
+	}
+	if header.Kid == "" {
+		return "", errors.New("missing kid in token header")
+	}
+	return header.Kid, nil
+}
+
+// decodeSegment decodes a base64url JWT segment into v.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Transport wraps base, or http.DefaultTransport when nil, so that each
+// request runs in a client span and carries it in its traceparent
+// header.
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t == nil {
+		return base
+	}
+	return &transport{tracer: t, base: base}
+}
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), Client, "HTTP "+req.Method,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.path", req.URL.Path),
+	)
+	defer span.End()
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetError(errors.New(resp.Status))
+	}
+	return resp, nil
+}
+
+// Client returns a copy of hc, or of a zero http.Client when nil, whose
+// requests are traced by Transport.
+func (t *Tracer) Client(hc *http.Client) *http.Client {
+	var c http.Client
+	if hc != nil {
+		c = *hc
+	}
+	c.Transport = t.Transport(c.Transport)
+	return &c
+}
@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OTLPPath is the path of the OTLP/HTTP traces endpoint of a collector.
+const OTLPPath = "/v1/traces"
+
+// scopeName is the instrumentation scope of every span.
+const scopeName = "github.com/coral-mesh/coral-discovery-workers/wasm"
+
+// OTLP status codes.
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+// MarshalOTLP encodes spans of service as an OTLP/JSON
+// ExportTraceServiceRequest.
+func MarshalOTLP(service string, spans []*SpanData) ([]byte, error) {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:    s.Context.TraceID.String(),
+			SpanID:     s.Context.SpanID.String(),
+			Name:       s.Name,
+			Kind:       int(s.Kind),
+			Start:      strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: otlpAttributes(s.Attributes),
+			Status:     otlpStatus{Code: statusOK},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: statusError, Message: s.Error}
+		}
+		out = append(out, span)
+	}
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]Attribute{String("service.name", service)}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": scopeName},
+				"spans": out,
+			}},
+		}},
+	})
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.Value.(type) {
+		case int64:
+			// OTLP/JSON carries 64-bit integers as strings.
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpAttribute{Key: a.Key, Value: value})
+	}
+	return out
+}
+
+// OTLPConfig holds the configuration for an OTLP exporter.
+type OTLPConfig struct {
+	// Endpoint is the collector's traces URL, typically ending in
+	// OTLPPath. Required.
+	Endpoint string
+
+	// Headers are added to every export, e.g. for collector
+	// authentication.
+	Headers map[string]string
+
+	// HTTPClient reaches the collector. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// OTLP exports spans to an OpenTelemetry collector over OTLP/HTTP with
+// JSON encoding.
+type OTLP struct {
+	endpoint string
+	headers  map[string]string
+	http     *http.Client
+}
+
+// NewOTLP creates an OTLP exporter from cfg.
+func NewOTLP(cfg OTLPConfig) (*OTLP, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("tracing: Endpoint is required")
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OTLP{endpoint: cfg.Endpoint, headers: cfg.Headers, http: cfg.HTTPClient}, nil
+}
+
+// Export implements Exporter.
+func (e *OTLP) Export(ctx context.Context, service string, spans []*SpanData) error {
+	body, err := MarshalOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("collector: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("collector: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,259 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often Run exports finished spans when the Config
+// does not say.
+const DefaultInterval = 5 * time.Second
+
+// DefaultMaxQueue is how many finished spans are kept for export when the
+// Config does not say. Spans finished while the queue is full are
+// dropped.
+const DefaultMaxQueue = 2048
+
+// Kind is the role of a span in a request, as in OpenTelemetry.
+type Kind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	Internal Kind = 1
+	Server   Kind = 2
+	Client   Kind = 3
+)
+
+// Attribute is a key/value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value any // string, int64, float64 or bool
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// SpanData is a finished span handed to an Exporter.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID // zero for root spans
+	Start, End time.Time
+	Attributes []Attribute
+
+	// Error is the status message of failed spans; empty when the span
+	// succeeded.
+	Error string
+}
+
+// Exporter sends the finished spans of service to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []*SpanData) error
+}
+
+// Config holds the configuration for a Tracer.
+type Config struct {
+	// Service is reported as the service.name of every span. Required.
+	Service string
+
+	// Exporter receives the finished spans. Required.
+	Exporter Exporter
+
+	// Ratio is the fraction of traces started here that are recorded,
+	// in (0, 1]. Defaults to 1. Traces started by a caller follow the
+	// caller's sampling decision.
+	Ratio float64
+
+	// Interval is how often Run exports. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// MaxQueue bounds the finished spans awaiting export. Defaults to
+	// DefaultMaxQueue.
+	MaxQueue int
+}
+
+// Tracer starts spans and batches them for export. It is safe for
+// concurrent use.
+type Tracer struct {
+	service   string
+	exporter  Exporter
+	threshold uint64 // traces with a lower ID suffix are sampled
+	interval  time.Duration
+	maxQueue  int
+
+	mu    sync.Mutex
+	queue []*SpanData
+}
+
+// New creates a Tracer from cfg.
+func New(cfg Config) (*Tracer, error) {
+	if cfg.Service == "" || cfg.Exporter == nil {
+		return nil, errors.New("tracing: Service and Exporter are required")
+	}
+	if cfg.Ratio == 0 {
+		cfg.Ratio = 1
+	}
+	if cfg.Ratio < 0 || cfg.Ratio > 1 {
+		return nil, errors.New("tracing: Ratio must be in (0, 1]")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = DefaultMaxQueue
+	}
+	threshold := uint64(math.MaxUint64)
+	if cfg.Ratio < 1 {
+		threshold = uint64(cfg.Ratio * math.MaxUint64)
+	}
+	return &Tracer{
+		service:   cfg.Service,
+		exporter:  cfg.Exporter,
+		threshold: threshold,
+		interval:  cfg.Interval,
+		maxQueue:  cfg.MaxQueue,
+	}, nil
+}
+
+// Start starts a span that is a child of the span or remote parent ctx
+// carries, and returns a copy of ctx carrying it. End the span when the
+// operation is done. Spans of unsampled traces are propagated but not
+// recorded.
+func (t *Tracer) Start(ctx context.Context, kind Kind, name string, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Flags: parent.Flags}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Flags = 0
+		if binary.BigEndian.Uint64(sc.TraceID[8:]) <= t.threshold {
+			sc.Flags = flagSampled
+		}
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Context:    sc,
+			Parent:     parent.SpanID,
+			Start:      time.Now(),
+			Attributes: attrs,
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Flush exports the finished spans queued so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.Export(ctx, t.service, spans)
+}
+
+// Run calls Flush every interval until ctx is done, then once more.
+// Failed exports are dropped; tracing is best effort.
+func (t *Tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), t.interval)
+			_ = t.Flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			_ = t.Flush(ctx)
+		}
+	}
+}
+
+// enqueue queues a finished span for export.
+func (t *Tracer) enqueue(span *SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= t.maxQueue {
+		return
+	}
+	t.queue = append(t.queue, span)
+}
+
+// Span is an operation within a trace.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's propagated identity.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttributes adds attrs to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetError marks the span failed with err, when err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and queues it for export if its trace is
+// sampled. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if data.Context.Sampled() {
+		s.tracer.enqueue(&data)
+	}
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Store wraps st so that operations made within a traced request run in
+// client spans. Background work, such as the reaper, is not traced.
+func (t *Tracer) Store(st store.Store) store.Store {
+	if t == nil {
+		return st
+	}
+	return &tracedStore{Store: st, tracer: t}
+}
+
+type tracedStore struct {
+	store.Store
+	tracer *Tracer
+}
+
+// start starts the span of operation op on key, unless ctx is untraced.
+func (t *tracedStore) start(ctx context.Context, op, key string) (context.Context, *Span) {
+	if !SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return t.tracer.Start(ctx, Client, "store "+op, String("db.operation.name", op), String("store.key", key))
+}
+
+// end ends span, marking it failed for errors other than missing keys and
+// compare-and-swap conflicts.
+func end(span *Span, err error) {
+	if err != nil && !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrConflict) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (t *tracedStore) Get(ctx context.Context, key string) (*store.Entry, error) {
+	ctx, span := t.start(ctx, "get", key)
+	entry, err := t.Store.Get(ctx, key)
+	end(span, err)
+	return entry, err
+}
+
+func (t *tracedStore) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	ctx, span := t.start(ctx, "put", key)
+	rev, err := t.Store.Put(ctx, key, value)
+	end(span, err)
+	return rev, err
+}
+
+func (t *tracedStore) Delete(ctx context.Context, key string) error {
+	ctx, span := t.start(ctx, "delete", key)
+	err := t.Store.Delete(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (t *tracedStore) List(ctx context.Context, prefix string) ([]*store.Entry, error) {
+	ctx, span := t.start(ctx, "list", prefix)
+	entries, err := t.Store.List(ctx, prefix)
+	end(span, err)
+	return entries, err
+}
+
+func (t *tracedStore) CompareAndSwap(ctx context.Context, key string, revision uint64, value []byte) (uint64, error) {
+	ctx, span := t.start(ctx, "cas", key)
+	rev, err := t.Store.CompareAndSwap(ctx, key, revision, value)
+	end(span, err)
+	return rev, err
+}
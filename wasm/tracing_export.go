@@ -0,0 +1,91 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsutil"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/tracing"
+)
+
+// tracer records the module's spans; nil until configureTracing.
+var tracer atomic.Pointer[tracing.Tracer]
+
+// configureTracing starts recording spans of fetchJWKS and of registries
+// opened afterwards, exported as OTLP/JSON to endpoint with the global
+// fetch when flushTraces is called. A null endpoint stops tracing.
+// Arguments: endpoint, serviceName, [headersJSON], [ratio]
+// Returns: { enabled: boolean }
+func configureTracing(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errInvalidArgument, "expected 2 arguments: endpoint, serviceName")
+	}
+	if args[0].Type() != js.TypeString {
+		tracer.Store(nil)
+		return map[string]interface{}{"enabled": false}
+	}
+
+	exporter := &fetchExporter{endpoint: args[0].String()}
+	if len(args) > 2 && args[2].Type() == js.TypeString {
+		if err := json.Unmarshal([]byte(args[2].String()), &exporter.headers); err != nil {
+			return errorResult(errInvalidArgument, "failed to parse headers: "+err.Error())
+		}
+	}
+	ratio := 0.0
+	if len(args) > 3 && args[3].Type() == js.TypeNumber {
+		ratio = args[3].Float()
+	}
+	t, err := tracing.New(tracing.Config{Service: args[1].String(), Exporter: exporter, Ratio: ratio})
+	if err != nil {
+		return errorResult(errInvalidArgument, err.Error())
+	}
+	tracer.Store(t)
+	return map[string]interface{}{"enabled": true}
+}
+
+// flushTraces exports the spans finished so far. Workers should pass the
+// Promise to ctx.waitUntil so the export outlives the response.
+// Arguments: none
+// Returns: nothing
+func flushTraces(this js.Value, args []js.Value) interface{} {
+	if err := tracer.Load().Flush(context.Background()); err != nil {
+		return errorResult(errFetch, "failed to export spans: "+err.Error())
+	}
+	return nil
+}
+
+// fetchExporter posts spans to an OTLP/HTTP collector with the global
+// fetch, which Workers allow where net/http cannot dial.
+type fetchExporter struct {
+	endpoint string
+	headers  map[string]string
+}
+
+func (e *fetchExporter) Export(_ context.Context, service string, spans []*tracing.SpanData) error {
+	body, err := tracing.MarshalOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	headers := map[string]interface{}{"Content-Type": "application/json"}
+	for k, v := range e.headers {
+		headers[k] = v
+	}
+	resp, err := jsutil.Await(js.Global().Call("fetch", e.endpoint, map[string]interface{}{
+		"method":  "POST",
+		"headers": headers,
+		"body":    string(body),
+	}))
+	if err != nil {
+		return err
+	}
+	if !resp.Get("ok").Bool() {
+		return errors.New("collector: unexpected status " + strconv.Itoa(resp.Get("status").Int()))
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+// Package traversal coordinates UDP hole punching between agents behind
+// NATs, in the manner of libp2p's DCUtR. The initiator opens an attempt
+// with its candidate addresses, the target accepts it with its own, and
+// the coordinator schedules a common start time so that both punch
+// through their NATs simultaneously. The server adds the public address
+// each request came from to the candidates (see
+// registry.ObservedEndpoints), since agents behind carrier-grade NAT only
+// know their private addresses. Punch runs the agent side.
+package traversal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// DefaultLead is how far ahead of acceptance an attempt starts when
+// Config.Lead is zero: long enough for the initiator to hear about it.
+const DefaultLead = 500 * time.Millisecond
+
+// DefaultTTL is how long an attempt lives when Config.TTL is zero.
+const DefaultTTL = 30 * time.Second
+
+// maxCandidates bounds the candidates of each party.
+const maxCandidates = 16
+
+// keyPrefix namespaces attempts in the store.
+const keyPrefix = "traversal/"
+
+// Attempt states.
+const (
+	// StatusPending waits for the target to accept.
+	StatusPending = "pending"
+
+	// StatusReady carries both parties' candidates and the start time.
+	StatusReady = "ready"
+)
+
+var (
+	// ErrAttemptNotFound is returned for unknown or expired attempts, and
+	// to agents that are not a party to the attempt.
+	ErrAttemptNotFound = errors.New("traversal attempt not found")
+
+	// ErrPeerNotFound is returned when the target is not a live agent of
+	// the initiator's colony.
+	ErrPeerNotFound = errors.New("peer not found")
+
+	// ErrAttemptState is returned when accepting an attempt twice.
+	ErrAttemptState = errors.New("traversal attempt is not pending")
+
+	// ErrInvalidCandidate is returned for candidates that are not
+	// host:port pairs with an IP address.
+	ErrInvalidCandidate = errors.New("invalid candidate")
+)
+
+// Party is one side of an attempt. Candidates are the UDP addresses the
+// party punches from: its local addresses first, then those observed by
+// the server.
+type Party struct {
+	AgentID    string   `json:"agent_id"`
+	Candidates []string `json:"candidates"`
+}
+
+// Attempt is a hole punching attempt between two agents of a colony.
+// StartInMS is the time until StartAt when the attempt was returned,
+// which spares parties from trusting their clocks; it is not stored.
+type Attempt struct {
+	ID        string    `json:"id"`
+	ReefID    string    `json:"reef_id"`
+	ColonyID  string    `json:"colony_id"`
+	Status    string    `json:"status"`
+	Initiator Party     `json:"initiator"`
+	Target    Party     `json:"target"`
+	StartAt   time.Time `json:"start_at,omitempty"`
+	StartInMS int64     `json:"start_in_ms,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Peer returns the party of a that is not agentID.
+func (a *Attempt) Peer(agentID string) Party {
+	if a.Initiator.AgentID == agentID {
+		return a.Target
+	}
+	return a.Initiator
+}
+
+// Config holds the configuration for a Coordinator.
+type Config struct {
+	// Registry resolves parties. Required.
+	Registry *registry.Registry
+
+	// Store holds attempts. Defaults to an in-memory store; use a shared
+	// backend when several instances serve the same colony.
+	Store store.Store
+
+	// Lead is the delay between acceptance and the start of punching.
+	// Defaults to DefaultLead.
+	Lead time.Duration
+
+	// TTL bounds the life of an attempt. Defaults to DefaultTTL.
+	TTL time.Duration
+}
+
+// Coordinator synchronizes hole punching attempts.
+type Coordinator struct {
+	registry *registry.Registry
+	store    store.Store
+	lead     time.Duration
+	ttl      time.Duration
+}
+
+// New creates a Coordinator from cfg.
+func New(cfg Config) (*Coordinator, error) {
+	if cfg.Registry == nil {
+		return nil, errors.New("traversal: Registry is required")
+	}
+	st := cfg.Store
+	if st == nil {
+		st = store.NewMemory()
+	}
+	lead := cfg.Lead
+	if lead <= 0 {
+		lead = DefaultLead
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Coordinator{registry: cfg.Registry, store: st, lead: lead, ttl: ttl}, nil
+}
+
+// Initiate opens an attempt from agent from to agent to of colonyID in
+// reefID. remote is the address the request came from.
+func (c *Coordinator) Initiate(ctx context.Context, reefID, colonyID, from, to string, candidates []string, remote netip.Addr) (*Attempt, error) {
+	if to == "" || to == from {
+		return nil, fmt.Errorf("%w: invalid peer %q", ErrPeerNotFound, to)
+	}
+	for _, agentID := range []string{from, to} {
+		rec, err := c.registry.Lookup(ctx, reefID, agentID)
+		if errors.Is(err, registry.ErrNotFound) || (err == nil && rec.ColonyID != colonyID) {
+			return nil, fmt.Errorf("%w: %s is not in colony %s", ErrPeerNotFound, agentID, colonyID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	cands, err := withObserved(candidates, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	a := &Attempt{
+		ID:        uuid.New().String(),
+		ReefID:    reefID,
+		ColonyID:  colonyID,
+		Status:    StatusPending,
+		Initiator: Party{AgentID: from, Candidates: cands},
+		Target:    Party{AgentID: to},
+		ExpiresAt: now.Add(c.ttl),
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.store.CompareAndSwap(ctx, keyPrefix+a.ID, 0, data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Accept answers attempt id as its target agentID of reefID and
+// schedules the start.
+func (c *Coordinator) Accept(ctx context.Context, reefID, id, agentID string, candidates []string, remote netip.Addr) (*Attempt, error) {
+	cands, err := withObserved(candidates, remote)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		a, rev, err := c.load(ctx, reefID, id, agentID)
+		if err != nil {
+			return nil, err
+		}
+		if a.Target.AgentID != agentID {
+			return nil, fmt.Errorf("%w: %s", ErrAttemptNotFound, id)
+		}
+		if a.Status != StatusPending {
+			return nil, fmt.Errorf("%w: %s is %s", ErrAttemptState, id, a.Status)
+		}
+		a.Status = StatusReady
+		a.Target.Candidates = cands
+		a.StartAt = time.Now().UTC().Add(c.lead)
+		data, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		_, err = c.store.CompareAndSwap(ctx, keyPrefix+id, rev, data)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return a.stamp(), nil
+	}
+}
+
+// Pending returns the attempts waiting for agentID of reefID to accept.
+// When there are none it waits up to wait for one to arrive.
+func (c *Coordinator) Pending(ctx context.Context, reefID, agentID string, wait time.Duration) ([]*Attempt, error) {
+	var out []*Attempt
+	err := c.poll(ctx, keyPrefix, wait, func() (bool, error) {
+		entries, err := c.store.List(ctx, keyPrefix)
+		if err != nil {
+			return false, err
+		}
+		out = nil
+		now := time.Now()
+		for _, e := range entries {
+			var a Attempt
+			if json.Unmarshal(e.Value, &a) != nil || !now.Before(a.ExpiresAt) {
+				continue
+			}
+			if a.Status == StatusPending && a.ReefID == reefID && a.Target.AgentID == agentID {
+				out = append(out, &a)
+			}
+		}
+		return len(out) > 0, nil
+	})
+	return out, err
+}
+
+// Await returns attempt id to party agentID of reefID once it is ready,
+// or as it stands after waiting up to wait.
+func (c *Coordinator) Await(ctx context.Context, reefID, id, agentID string, wait time.Duration) (*Attempt, error) {
+	var out *Attempt
+	err := c.poll(ctx, keyPrefix+id, wait, func() (bool, error) {
+		a, _, err := c.load(ctx, reefID, id, agentID)
+		if err != nil {
+			return false, err
+		}
+		out = a
+		return a.Status == StatusReady, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.stamp(), nil
+}
+
+// Prune deletes expired attempts and returns how many were removed.
+func (c *Coordinator) Prune(ctx context.Context) (int, error) {
+	entries, err := c.store.List(ctx, keyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	pruned := 0
+	for _, e := range entries {
+		var a Attempt
+		if err := json.Unmarshal(e.Value, &a); err == nil && now.Before(a.ExpiresAt) {
+			continue
+		}
+		if err := c.store.Delete(ctx, e.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (c *Coordinator) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = c.Prune(ctx)
+		}
+	}
+}
+
+// poll calls check until it reports done, re-checking whenever keys under
+// prefix change, for up to wait.
+func (c *Coordinator) poll(ctx context.Context, prefix string, wait time.Duration, check func() (bool, error)) error {
+	if done, err := check(); err != nil || done || wait <= 0 {
+		return err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	events, err := c.store.Watch(waitCtx, prefix)
+	if err != nil {
+		return err
+	}
+	// Look again now that changes are being watched.
+	if done, err := check(); err != nil || done {
+		return err
+	}
+	for ev := range events {
+		if ev.Type != store.EventPut {
+			continue
+		}
+		if done, err := check(); err != nil || done {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// load reads a live attempt that agentID of reefID is a party to.
+func (c *Coordinator) load(ctx context.Context, reefID, id, agentID string) (*Attempt, uint64, error) {
+	entry, err := c.store.Get(ctx, keyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrAttemptNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var a Attempt
+	if err := json.Unmarshal(entry.Value, &a); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode attempt %s: %w", id, err)
+	}
+	if !time.Now().Before(a.ExpiresAt) || a.ReefID != reefID || (a.Initiator.AgentID != agentID && a.Target.AgentID != agentID) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrAttemptNotFound, id)
+	}
+	return &a, entry.Revision, nil
+}
+
+// stamp sets StartInMS from the current time.
+func (a *Attempt) stamp() *Attempt {
+	if !a.StartAt.IsZero() {
+		a.StartInMS = max(0, time.Until(a.StartAt).Milliseconds())
+	}
+	return a
+}
+
+// withObserved validates candidates and appends the ones inferred from
+// remote.
+func withObserved(candidates []string, remote netip.Addr) ([]string, error) {
+	if len(candidates) == 0 || len(candidates) > maxCandidates {
+		return nil, fmt.Errorf("%w: between 1 and %d candidates are required", ErrInvalidCandidate, maxCandidates)
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(candidates))
+	for _, cand := range candidates {
+		ap, err := netip.ParseAddrPort(cand)
+		if err != nil || ap.Port() == 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCandidate, cand)
+		}
+		s := net.JoinHostPort(ap.Addr().Unmap().String(), fmt.Sprint(ap.Port()))
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	for _, obs := range registry.ObservedEndpoints(out, remote) {
+		if !seen[obs] {
+			seen[obs] = true
+			out = append(out, obs)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package traversal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DefaultPunchWindow is how long Punch keeps probing after the start
+// time when ctx has no earlier deadline.
+const DefaultPunchWindow = 5 * time.Second
+
+// probeInterval spaces the probes sent to each candidate. NATs drop the
+// first probes of either side until the other side's mapping exists, so
+// both keep sending for the whole window.
+const probeInterval = 25 * time.Millisecond
+
+// probe prefixes; each packet is prefix + attempt ID.
+var (
+	synPrefix = []byte("coral-punch syn ")
+	ackPrefix = []byte("coral-punch ack ")
+)
+
+// ErrPunchFailed is returned when no candidate of the peer answered
+// within the punch window.
+var ErrPunchFailed = errors.New("hole punching failed")
+
+// Punch runs the agent side of a ready attempt on conn, which must be
+// the socket whose addresses were given as candidates. It waits for the
+// start time, probes every candidate of the peer of self, and returns the
+// first peer address that answers. a should be freshly returned by the
+// server, since the start time is taken from a.StartInMS.
+//
+// The probes carry the attempt ID but are not authenticated; the caller
+// should authenticate the peer over the returned path, e.g. with the
+// handshake of the protocol it runs next.
+func Punch(ctx context.Context, conn net.PacketConn, a *Attempt, self string) (net.Addr, error) {
+	start := time.Now().Add(time.Duration(a.StartInMS) * time.Millisecond)
+	var targets []net.Addr
+	for _, cand := range a.Peer(self).Candidates {
+		addr, err := net.ResolveUDPAddr("udp", cand)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, addr)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("traversal: peer has no candidates")
+	}
+
+	deadline := start.Add(DefaultPunchWindow)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(start)):
+	}
+
+	syn := append(append([]byte{}, synPrefix...), a.ID...)
+	ack := append(append([]byte{}, ackPrefix...), a.ID...)
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 512)
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, addr := range targets {
+			_, _ = conn.WriteTo(syn, addr)
+		}
+
+		next := time.Now().Add(probeInterval)
+		for {
+			if err := conn.SetReadDeadline(next); err != nil {
+				return nil, err
+			}
+			n, from, err := conn.ReadFrom(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case bytes.Equal(buf[:n], ack):
+				return from, nil
+			case bytes.Equal(buf[:n], syn):
+				// The peer may stop listening once it hears an ack, so
+				// send a few against loss.
+				for range 3 {
+					_, _ = conn.WriteTo(ack, from)
+				}
+				return from, nil
+			}
+		}
+	}
+	return nil, ErrPunchFailed
+}
+
+// LocalCandidates returns the addresses conn is reachable at on this
+// host: its local address, or every interface address when it is bound
+// to the unspecified address. Loopback addresses come last.
+func LocalCandidates(conn net.PacketConn) ([]string, error) {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("traversal: not a UDP socket")
+	}
+	port := strconv.Itoa(local.Port)
+	if !local.IP.IsUnspecified() {
+		return []string{net.JoinHostPort(local.IP.String(), port)}, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var out, loopback []string
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		// A socket bound to 0.0.0.0 is IPv4 only; one bound to :: takes
+		// both families.
+		if !ok || ipnet.IP.IsLinkLocalUnicast() || (local.IP.To4() != nil && ipnet.IP.To4() == nil) {
+			continue
+		}
+		cand := net.JoinHostPort(ipnet.IP.String(), port)
+		if ipnet.IP.IsLoopback() {
+			loopback = append(loopback, cand)
+		} else {
+			out = append(out, cand)
+		}
+	}
+	return append(out, loopback...), nil
+}
@@ -0,0 +1,83 @@
+package vc
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strings"
+)
+
+// IRIs the credential and proof terms expand to under Contexts.
+const (
+	rdfType          = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	xsdDateTime      = "http://www.w3.org/2001/XMLSchema#dateTime"
+	credNS           = "https://www.w3.org/2018/credentials#"
+	secNS            = "https://w3id.org/security#"
+	dcCreated        = "http://purl.org/dc/terms/created"
+	coralNS          = "https://coral-mesh.github.io/ns/v1#"
+	blankProofNode   = "_:c14n0"
+	assertionPurpose = "assertionMethod"
+)
+
+// quads accumulates the N-Quads of a default-graph dataset.
+type quads []string
+
+func (q *quads) iri(s, p, o string) {
+	*q = append(*q, node(s)+" <"+p+"> <"+o+"> .\n")
+}
+
+func (q *quads) dateTime(s, p, v string) {
+	*q = append(*q, node(s)+" <"+p+"> \""+escapeLiteral(v)+"\"^^<"+xsdDateTime+"> .\n")
+}
+
+// hash sorts the quads, giving the URDNA2015 canonical form of a dataset
+// whose only blank node is already labeled _:c14n0, and hashes it.
+func (q quads) hash() []byte {
+	sort.Strings(q)
+	h := sha256.Sum256([]byte(strings.Join(q, "")))
+	return h[:]
+}
+
+func node(s string) string {
+	if strings.HasPrefix(s, "_:") {
+		return s
+	}
+	return "<" + s + ">"
+}
+
+func escapeLiteral(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// documentQuads is the RDF form of c without its proof.
+func documentQuads(c *Credential) quads {
+	var q quads
+	for _, t := range c.Type {
+		q.iri(c.ID, rdfType, typeIRI(t))
+	}
+	q.iri(c.ID, credNS+"issuer", c.Issuer)
+	q.dateTime(c.ID, credNS+"issuanceDate", c.IssuanceDate)
+	if c.ExpirationDate != "" {
+		q.dateTime(c.ID, credNS+"expirationDate", c.ExpirationDate)
+	}
+	q.iri(c.ID, credNS+"credentialSubject", c.CredentialSubject.ID)
+	q.iri(c.CredentialSubject.ID, coralNS+"memberOf", c.CredentialSubject.MemberOf)
+	return q
+}
+
+// proofQuads is the RDF form of the proof options: p without its
+// proofValue, under the credential's context.
+func proofQuads(p *Proof) quads {
+	var q quads
+	q.iri(blankProofNode, rdfType, secNS+p.Type)
+	q.dateTime(blankProofNode, dcCreated, p.Created)
+	q.iri(blankProofNode, secNS+"proofPurpose", secNS+p.ProofPurpose)
+	q.iri(blankProofNode, secNS+"verificationMethod", p.VerificationMethod)
+	return q
+}
+
+func typeIRI(t string) string {
+	if t == TypeVerifiableCredential {
+		return credNS + t
+	}
+	return coralNS + t
+}
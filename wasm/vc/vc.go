@@ -0,0 +1,289 @@
+// Package vc issues and verifies W3C Verifiable Credentials attesting
+// colony membership ("agent X is a member of colony Y"), for relying
+// parties that accept VCs but not referral tickets. Credentials carry an
+// Ed25519Signature2020 proof by a discovery key, issued through a
+// signer.Signer so KMS-held keys work too.
+//
+// Proofs are computed over the URDNA2015 canonical N-Quads of the
+// credential. Credentials have a fixed shape, all of whose nodes are
+// named except the proof, so the canonical form is built directly from
+// the fields rather than through a general JSON-LD processor. Verify
+// therefore only accepts credentials of that shape.
+package vc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/did"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/signer"
+)
+
+// Credential and proof types.
+const (
+	// TypeVerifiableCredential is the base type of every credential.
+	TypeVerifiableCredential = "VerifiableCredential"
+
+	// TypeMembership is the type of colony membership credentials.
+	TypeMembership = "ColonyMembershipCredential"
+
+	// ProofType is the proof suite.
+	ProofType = "Ed25519Signature2020"
+)
+
+// Contexts is the @context of membership credentials: the VC and
+// Ed25519Signature2020 contexts plus inline definitions of the coral
+// terms.
+var Contexts = json.RawMessage(`["https://www.w3.org/2018/credentials/v1","https://w3id.org/security/suites/ed25519-2020/v1",{"ColonyMembershipCredential":"` + coralNS + `ColonyMembershipCredential","memberOf":{"@id":"` + coralNS + `memberOf","@type":"@id"}}]`)
+
+var (
+	// ErrInvalidCredential is returned for credentials not in the shape
+	// Issue produces.
+	ErrInvalidCredential = errors.New("invalid credential")
+
+	// ErrInvalidProof is returned when the proof does not verify.
+	ErrInvalidProof = errors.New("invalid credential proof")
+
+	// ErrExpired is returned for credentials past their expirationDate or
+	// before their issuanceDate.
+	ErrExpired = errors.New("credential expired or not yet valid")
+)
+
+// Credential is a colony membership credential.
+type Credential struct {
+	Context           json.RawMessage `json:"@context"`
+	ID                string          `json:"id"`
+	Type              []string        `json:"type"`
+	Issuer            string          `json:"issuer"`
+	IssuanceDate      string          `json:"issuanceDate"`
+	ExpirationDate    string          `json:"expirationDate,omitempty"`
+	CredentialSubject Subject         `json:"credentialSubject"`
+	Proof             *Proof          `json:"proof,omitempty"`
+}
+
+// Subject is the member agent and the colony it belongs to.
+type Subject struct {
+	// ID is the agent's DID, e.g. from did.Resolver.AgentDID.
+	ID string `json:"id"`
+
+	// MemberOf is the colony IRI from ColonyIRI.
+	MemberOf string `json:"memberOf"`
+}
+
+// Proof is an Ed25519Signature2020 proof.
+type Proof struct {
+	Type               string `json:"type"`
+	Created            string `json:"created"`
+	VerificationMethod string `json:"verificationMethod"`
+	ProofPurpose       string `json:"proofPurpose"`
+	ProofValue         string `json:"proofValue"`
+}
+
+// Membership describes the credential to issue.
+type Membership struct {
+	// SubjectDID is the agent's DID.
+	SubjectDID string
+	ReefID     string
+	ColonyID   string
+
+	// TTL bounds the credential's lifetime; zero issues a credential
+	// without expirationDate.
+	TTL time.Duration
+}
+
+// ColonyIRI names a colony in credentials.
+func ColonyIRI(reefID, colonyID string) string {
+	return "urn:coral:reef:" + url.PathEscape(reefID) + ":colony:" + url.PathEscape(colonyID)
+}
+
+// ParseColonyIRI returns the reef and colony named by ColonyIRI.
+func ParseColonyIRI(iri string) (reefID, colonyID string, err error) {
+	rest, ok := strings.CutPrefix(iri, "urn:coral:reef:")
+	if ok {
+		reef, colony, found := strings.Cut(rest, ":colony:")
+		if found {
+			if reefID, err = url.PathUnescape(reef); err == nil {
+				if colonyID, err = url.PathUnescape(colony); err == nil && reefID != "" && colonyID != "" {
+					return reefID, colonyID, nil
+				}
+			}
+		}
+	}
+	return "", "", fmt.Errorf("%w: %q is not a colony IRI", ErrInvalidCredential, iri)
+}
+
+// Issue returns a membership credential signed by s. The issuer is the
+// did:key of s's public key, so relying parties can verify it offline.
+func Issue(ctx context.Context, s signer.Signer, m Membership) (*Credential, error) {
+	if m.SubjectDID == "" || m.ReefID == "" || m.ColonyID == "" {
+		return nil, fmt.Errorf("%w: subject, reef and colony are required", ErrInvalidCredential)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	issuer := did.KeyDID(s.Public())
+	c := &Credential{
+		Context:      Contexts,
+		ID:           "urn:uuid:" + uuid.New().String(),
+		Type:         []string{TypeVerifiableCredential, TypeMembership},
+		Issuer:       issuer,
+		IssuanceDate: now.Format(time.RFC3339),
+		CredentialSubject: Subject{
+			ID:       m.SubjectDID,
+			MemberOf: ColonyIRI(m.ReefID, m.ColonyID),
+		},
+	}
+	if m.TTL > 0 {
+		c.ExpirationDate = now.Add(m.TTL).Format(time.RFC3339)
+	}
+	proof := &Proof{
+		Type:               ProofType,
+		Created:            c.IssuanceDate,
+		VerificationMethod: issuer + "#" + did.Multibase(s.Public()),
+		ProofPurpose:       assertionPurpose,
+	}
+
+	sig, err := s.Sign(ctx, verifyData(c, proof))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign credential: %w", err)
+	}
+	if !ed25519.Verify(s.Public(), verifyData(c, proof), sig) {
+		return nil, signer.ErrBadSignature
+	}
+	proof.ProofValue = did.EncodeMultibase(sig)
+	c.Proof = proof
+	return c, nil
+}
+
+// Resolver resolves issuer DIDs other than did:key. *did.Resolver
+// satisfies it.
+type Resolver interface {
+	Resolve(ctx context.Context, did string) (*did.Document, error)
+}
+
+// Parse decodes a credential, rejecting members Issue does not produce.
+func Parse(data []byte) (*Credential, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var c Credential
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+	return &c, nil
+}
+
+// Verify checks c's proof and validity period and returns the issuer's
+// public key. Callers must still check that the issuer is trusted, for
+// example that the key is in the discovery JWKS. did:key issuers are
+// resolved offline; other issuers need resolver, which may be nil.
+func Verify(ctx context.Context, c *Credential, resolver Resolver) (ed25519.PublicKey, error) {
+	if err := c.checkShape(); err != nil {
+		return nil, err
+	}
+
+	var doc *did.Document
+	if pub, err := did.ParseKeyDID(c.Issuer); err == nil {
+		doc = did.KeyDocument(pub)
+	} else if resolver != nil {
+		if doc, err = resolver.Resolve(ctx, c.Issuer); err != nil {
+			return nil, fmt.Errorf("failed to resolve issuer: %w", err)
+		}
+	} else {
+		return nil, fmt.Errorf("%w: cannot resolve issuer %s", ErrInvalidProof, c.Issuer)
+	}
+	if !contains(doc.AssertionMethod, c.Proof.VerificationMethod) {
+		return nil, fmt.Errorf("%w: %s is not an assertion method of %s", ErrInvalidProof, c.Proof.VerificationMethod, c.Issuer)
+	}
+	pub, err := doc.PublicKey(c.Proof.VerificationMethod)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	sig, err := did.DecodeMultibase(c.Proof.ProofValue)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: malformed proofValue", ErrInvalidProof)
+	}
+	if !ed25519.Verify(pub, verifyData(c, c.Proof), sig) {
+		return nil, ErrInvalidProof
+	}
+
+	now := time.Now()
+	issued, _ := time.Parse(time.RFC3339, c.IssuanceDate)
+	if now.Before(issued) {
+		return nil, fmt.Errorf("%w: issued %s", ErrExpired, c.IssuanceDate)
+	}
+	if c.ExpirationDate != "" {
+		expires, _ := time.Parse(time.RFC3339, c.ExpirationDate)
+		if !now.Before(expires) {
+			return nil, fmt.Errorf("%w: expired %s", ErrExpired, c.ExpirationDate)
+		}
+	}
+	return pub, nil
+}
+
+// checkShape rejects credentials whose canonical form verifyData cannot
+// build.
+func (c *Credential) checkShape() error {
+	var got, want bytes.Buffer
+	if err := json.Compact(&got, c.Context); err != nil || json.Compact(&want, Contexts) != nil || got.String() != want.String() {
+		return fmt.Errorf("%w: unsupported @context", ErrInvalidCredential)
+	}
+	if len(c.Type) != 2 || c.Type[0] != TypeVerifiableCredential || c.Type[1] != TypeMembership {
+		return fmt.Errorf("%w: type must be [%s %s]", ErrInvalidCredential, TypeVerifiableCredential, TypeMembership)
+	}
+	for _, iri := range []string{c.ID, c.Issuer, c.CredentialSubject.ID, c.CredentialSubject.MemberOf} {
+		if !isIRI(iri) {
+			return fmt.Errorf("%w: %q is not an absolute IRI", ErrInvalidCredential, iri)
+		}
+	}
+	for _, date := range []string{c.IssuanceDate, c.ExpirationDate} {
+		if _, err := time.Parse(time.RFC3339, date); date != "" && err != nil {
+			return fmt.Errorf("%w: bad date %q", ErrInvalidCredential, date)
+		}
+	}
+	if c.IssuanceDate == "" {
+		return fmt.Errorf("%w: issuanceDate is required", ErrInvalidCredential)
+	}
+
+	p := c.Proof
+	if p == nil || p.Type != ProofType || p.ProofPurpose != assertionPurpose {
+		return fmt.Errorf("%w: proof must be %s for %s", ErrInvalidCredential, ProofType, assertionPurpose)
+	}
+	if !strings.HasPrefix(p.VerificationMethod, c.Issuer+"#") {
+		return fmt.Errorf("%w: verification method %s does not belong to the issuer", ErrInvalidCredential, p.VerificationMethod)
+	}
+	if _, err := time.Parse(time.RFC3339, p.Created); err != nil {
+		return fmt.Errorf("%w: bad proof date %q", ErrInvalidCredential, p.Created)
+	}
+	return nil
+}
+
+// verifyData is the Ed25519Signature2020 signing input: the hash of the
+// canonical proof options followed by the hash of the canonical
+// credential.
+func verifyData(c *Credential, p *Proof) []byte {
+	return append(proofQuads(p).hash(), documentQuads(c).hash()...)
+}
+
+// isIRI reports whether s is an absolute IRI that can be written in
+// N-Quads unescaped.
+func isIRI(s string) bool {
+	scheme, rest, ok := strings.Cut(s, ":")
+	return ok && scheme != "" && rest != "" && !strings.ContainsAny(s, "<>\"{}|^`\\ \t\n\r")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package verify
+
+import (
+	"crypto/ed25519"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/edbatch"
+)
+
+// Ed25519Verifier, when set, checks the EdDSA signatures of tickets whose
+// keys come from ParseKeys in place of edbatch, reporting which sigs[i]
+// are valid signatures of msgs[i] by pubs[i] as edbatch.Valid would. The
+// Wasm module points it at SubtleCrypto. Set it before verifying tickets.
+var Ed25519Verifier func(pubs []ed25519.PublicKey, msgs, sigs [][]byte) []bool
+
+// Ticket is a token for ParseClaimsBatch, with the arguments ParseClaims
+// would take for it.
+type Ticket struct {
+	Keys   KeySource
+	Token  string
+	Opts   *Options
+	Claims *jwt.ReferralClaims
+}
+
+// ParseClaimsBatch runs ParseClaims on each ticket and returns the error
+// of each. The EdDSA signatures of tickets whose keys come from ParseKeys
+// are checked together, with edbatch.Valid or Ed25519Verifier.
+func ParseClaimsBatch(tickets []Ticket) []error {
+	errs := make([]error, len(tickets))
+	var (
+		batched    []int
+		pubs       []ed25519.PublicKey
+		msgs, sigs [][]byte
+		bufs       []*[]byte
+	)
+	defer func() {
+		for _, buf := range bufs {
+			buffers.Put(buf)
+		}
+	}()
+
+	for i, t := range tickets {
+		keys, ok := t.Keys.(resolver)
+		if !ok {
+			errs[i] = ParseClaims(t.Keys, t.Token, t.Opts, t.Claims)
+			continue
+		}
+		buf := buffers.Get().(*[]byte)
+		bufs = append(bufs, buf)
+		p, err := prepare(keys, t.Token, t.Opts, t.Claims, buf)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if pub, ok := p.pub.(ed25519.PublicKey); ok && p.method == EdDSA && len(pub) == ed25519.PublicKeySize {
+			batched = append(batched, i)
+			pubs = append(pubs, pub)
+			msgs = append(msgs, p.signed)
+			sigs = append(sigs, p.sig)
+			continue
+		}
+		if err := verifySignature(p.method, p.pub, p.signed, p.sig); err != nil {
+			errs[i] = tokenError(gojwt.ErrTokenSignatureInvalid, "", err)
+			continue
+		}
+		errs[i] = validateTimes(t.Claims, t.Opts)
+	}
+
+	valid := edbatch.Valid
+	if Ed25519Verifier != nil {
+		valid = Ed25519Verifier
+	}
+	for j, ok := range valid(pubs, msgs, sigs) {
+		t := tickets[batched[j]]
+		if !ok {
+			errs[batched[j]] = tokenError(gojwt.ErrTokenSignatureInvalid, "", gojwt.ErrEd25519Verification)
+			continue
+		}
+		errs[batched[j]] = validateTimes(t.Claims, t.Opts)
+	}
+	return errs
+}
@@ -0,0 +1,324 @@
+package verify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/edbatch"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsonscan"
+)
+
+// resolver is implemented by key sources that resolve keys without a
+// gojwt.Token. ParseClaims verifies tickets against them directly.
+type resolver interface {
+	resolve(kid []byte, alg string) (crypto.PublicKey, error)
+}
+
+// buffers holds the scratch space tickets are decoded into.
+var buffers = sync.Pool{New: func() any { return new([]byte) }}
+
+var segments = base64.RawURLEncoding
+
+// parseFast is ParseClaims for keys resolved by a resolver. The token is
+// copied and decoded into a pooled buffer and its header and claims are
+// scanned in place, so that only the claim values copied into claims
+// allocate. Failures are reported as gojwt reports them.
+func parseFast(keys resolver, token string, opts *Options, claims *jwt.ReferralClaims) error {
+	buf := buffers.Get().(*[]byte)
+	defer buffers.Put(buf)
+	p, err := prepare(keys, token, opts, claims, buf)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(p.method, p.pub, p.signed, p.sig); err != nil {
+		return tokenError(gojwt.ErrTokenSignatureInvalid, "", err)
+	}
+	return validateTimes(claims, opts)
+}
+
+// prepared is a token whose claims are decoded and whose key is
+// resolved, but whose signature and lifetime are yet to be checked.
+type prepared struct {
+	method      string
+	pub         crypto.PublicKey
+	signed, sig []byte
+}
+
+// prepare decodes token into buf and claims and resolves its key. The
+// signed bytes and signature in the result point into buf.
+func prepare(keys resolver, token string, opts *Options, claims *jwt.ReferralClaims, buf *[]byte) (prepared, error) {
+	first := strings.IndexByte(token, '.')
+	second := strings.LastIndexByte(token, '.')
+	if first < 0 || second == first || strings.IndexByte(token[first+1:second], '.') >= 0 {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "token contains an invalid number of segments")
+	}
+
+	size := len(token) + segments.DecodedLen(first) + segments.DecodedLen(second-first-1) + segments.DecodedLen(len(token)-second-1)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	raw := (*buf)[:size]
+	copy(raw, token)
+	signed, rest := raw[:second], raw[len(token):]
+
+	header, rest, err := decodeSegment(rest, raw[:first])
+	if err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "could not base64 decode header", err)
+	}
+	alg, kid, err := scanHeader(header)
+	if err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "could not JSON decode header", err)
+	}
+	payload, rest, err := decodeSegment(rest, raw[first+1:second])
+	if err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "could not base64 decode claim", err)
+	}
+	if err := scanClaims(payload, claims); err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "could not JSON decode claim", err)
+	}
+
+	method, err := algorithm(alg, opts)
+	if err != nil {
+		return prepared{}, err
+	}
+	sig, _, err := decodeSegment(rest, raw[second+1:len(token)])
+	if err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenMalformed, "could not base64 decode signature", err)
+	}
+	if kid == nil {
+		return prepared{}, tokenError(gojwt.ErrTokenUnverifiable, "error while executing keyfunc", errors.New("missing kid in token header"))
+	}
+	pub, err := keys.resolve(kid, method)
+	if err != nil {
+		return prepared{}, tokenError(gojwt.ErrTokenUnverifiable, "error while executing keyfunc", err)
+	}
+	return prepared{method: method, pub: pub, signed: signed, sig: sig}, nil
+}
+
+// decodeSegment decodes the base64url segment src into the start of dst,
+// returning the decoded bytes and the remainder of dst.
+func decodeSegment(dst, src []byte) (decoded, rest []byte, err error) {
+	n, err := segments.Decode(dst, src)
+	return dst[:n], dst[n:], err
+}
+
+// algorithm returns the allowlisted algorithm named by the alg header.
+func algorithm(alg []byte, opts *Options) (string, error) {
+	if alg == nil {
+		return "", tokenError(gojwt.ErrTokenUnverifiable, "signing method (alg) is unspecified")
+	}
+	allowed := DefaultAlgorithms
+	if opts != nil {
+		allowed = opts.algorithms()
+	}
+	for _, a := range allowed {
+		if string(alg) == a {
+			return a, nil
+		}
+	}
+	if gojwt.GetSigningMethod(string(alg)) == nil {
+		return "", tokenError(gojwt.ErrTokenUnverifiable, "signing method (alg) is unavailable")
+	}
+	return "", tokenError(gojwt.ErrTokenSignatureInvalid, fmt.Sprintf("signing method %s is invalid", alg))
+}
+
+// verifySignature checks sig over signed with pub.
+func verifySignature(alg string, pub crypto.PublicKey, signed, sig []byte) error {
+	switch alg {
+	case EdDSA:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return gojwt.ErrInvalidKeyType
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return gojwt.ErrInvalidKey
+		}
+		if Ed25519Verifier != nil {
+			if !Ed25519Verifier([]ed25519.PublicKey{key}, [][]byte{signed}, [][]byte{sig})[0] {
+				return gojwt.ErrEd25519Verification
+			}
+		} else if !edbatch.Verify(key, signed, sig) {
+			return gojwt.ErrEd25519Verification
+		}
+		return nil
+	case ES256:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return gojwt.ErrInvalidKeyType
+		}
+		if len(sig) != 64 {
+			return gojwt.ErrECDSAVerification
+		}
+		hash := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return gojwt.ErrECDSAVerification
+		}
+		return nil
+	case RS256:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return gojwt.ErrInvalidKeyType
+		}
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	}
+	return gojwt.ErrSignatureInvalid
+}
+
+// validateTimes checks exp, nbf and iat as the gojwt validator configured
+// by ParserOptions does, or by default when opts is nil.
+func validateTimes(claims *jwt.ReferralClaims, opts *Options) error {
+	now, leeway, strict := time.Now(), time.Duration(0), opts != nil
+	if strict {
+		now, leeway = clock.Or(opts.Clock).Now(), max(opts.Leeway, opts.NotBeforeLeeway)
+	}
+
+	var errs []error
+	switch {
+	case claims.ExpiresAt == nil:
+		if strict {
+			errs = append(errs, tokenError(gojwt.ErrTokenRequiredClaimMissing, "exp claim is required"))
+		}
+	case !now.Before(claims.ExpiresAt.Add(leeway)):
+		errs = append(errs, gojwt.ErrTokenExpired)
+	}
+	if claims.NotBefore != nil && now.Before(claims.NotBefore.Add(-leeway)) {
+		errs = append(errs, gojwt.ErrTokenNotValidYet)
+	}
+	if strict && claims.IssuedAt != nil && now.Before(claims.IssuedAt.Add(-leeway)) {
+		errs = append(errs, gojwt.ErrTokenUsedBeforeIssued)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return tokenError(gojwt.ErrTokenInvalidClaims, "", errors.Join(errs...))
+}
+
+// tokenError wraps sentinel, a message and further errors the way gojwt
+// does.
+func tokenError(sentinel error, msg string, more ...error) error {
+	format, args := "%w", []any{sentinel}
+	if msg != "" {
+		format, args = "%w: %s", append(args, msg)
+	}
+	for _, err := range more {
+		format += ": %w"
+		args = append(args, err)
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// scanHeader returns the alg and kid header values, or nil for either
+// when it is absent or not a string. A null header decodes, as gojwt's
+// does, with neither.
+func scanHeader(data []byte) (alg, kid []byte, err error) {
+	s := jsonscan.New(data)
+	if s.Peek() == 'n' {
+		s.Literal("null")
+		return nil, nil, s.End()
+	}
+	s.Expect('{')
+	for i := 0; s.More(i, '}'); i++ {
+		switch name := s.Name(); string(name) {
+		case "alg":
+			alg = s.StringOrSkip()
+		case "kid":
+			kid = s.StringOrSkip()
+		default:
+			s.Skip()
+		}
+	}
+	return alg, kid, s.End()
+}
+
+// scanClaims decodes the referral claims in data into claims as
+// encoding/json would, matching names case-insensitively and skipping
+// unknown members. Like encoding/json, it leaves claims unchanged for
+// null.
+func scanClaims(data []byte, claims *jwt.ReferralClaims) error {
+	s := jsonscan.New(data)
+	if s.Peek() == 'n' {
+		s.Literal("null")
+		return s.End()
+	}
+	s.Expect('{')
+	for i := 0; s.More(i, '}'); i++ {
+		name := s.Name()
+		switch {
+		case fold(name, "reef_id"):
+			s.StringInto(&claims.ReefID)
+		case fold(name, "colony_id"):
+			s.StringInto(&claims.ColonyID)
+		case fold(name, "agent_id"):
+			s.StringInto(&claims.AgentID)
+		case fold(name, "intent"):
+			s.StringInto(&claims.Intent)
+		case fold(name, "iss"):
+			s.StringInto(&claims.Issuer)
+		case fold(name, "sub"):
+			s.StringInto(&claims.Subject)
+		case fold(name, "jti"):
+			s.StringInto(&claims.ID)
+		case fold(name, "aud"):
+			audience(&s, &claims.Audience)
+		case fold(name, "exp"):
+			claims.ExpiresAt = date(&s)
+		case fold(name, "nbf"):
+			claims.NotBefore = date(&s)
+		case fold(name, "iat"):
+			claims.IssuedAt = date(&s)
+		default:
+			s.Skip()
+		}
+	}
+	return s.End()
+}
+
+// fold reports whether the member name matches field, which is lower
+// case ASCII, ignoring case as encoding/json does. Names holding other
+// runes go through bytes.EqualFold, since Unicode folding maps a few of
+// them onto ASCII letters, such as ſ onto s.
+func fold(name []byte, field string) bool {
+	for _, c := range name {
+		if c >= utf8.RuneSelf {
+			return bytes.EqualFold(name, []byte(field))
+		}
+	}
+	if len(name) != len(field) {
+		return false
+	}
+	for i := range name {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != field[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// numericDate converts seconds since the epoch as gojwt does.
+func numericDate(f float64) *gojwt.NumericDate {
+	round, frac := math.Modf(f)
+	return gojwt.NewNumericDate(time.Unix(int64(round), int64(frac*1e9)))
+}
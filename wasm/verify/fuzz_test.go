@@ -0,0 +1,116 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// sentinels are the gojwt errors ParseClaims reports failures with.
+var sentinels = []error{
+	gojwt.ErrTokenMalformed,
+	gojwt.ErrTokenUnverifiable,
+	gojwt.ErrTokenSignatureInvalid,
+	gojwt.ErrTokenInvalidClaims,
+	gojwt.ErrTokenExpired,
+	gojwt.ErrTokenNotValidYet,
+}
+
+// FuzzParseKeys checks that malformed JWKS documents are rejected without
+// panicking, and that the keys of accepted ones can be used.
+func FuzzParseKeys(f *testing.F) {
+	for _, seed := range []string{
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","use":"sig","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","kid":"k2","alg":"ES256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}]}`,
+		`{"keys":[{"kty":"RSA","kid":"k3","n":"AQAB","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-521","x":"AA","y":"AA"}]}`,
+		`{"keys":[{"kty":"OKP","crv":"X25519","x":"AA"}]}`,
+		`{"keys":[null,{}]}`,
+		`{"keys":null}`,
+		`{"keys":{}}`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		keys, err := ParseKeys(data)
+		if err != nil {
+			if keys != nil {
+				t.Fatal("keys returned with an error")
+			}
+			return
+		}
+		for _, kid := range keys.KeyIDs() {
+			for _, alg := range []string{EdDSA, ES256, RS256} {
+				if pub, err := keys.resolve([]byte(kid), alg); err == nil {
+					_ = verifySignature(alg, pub, []byte("signed"), make([]byte, 64))
+				}
+			}
+		}
+	})
+}
+
+// FuzzParseClaims signs a fuzzed header and payload and checks that the
+// fast path decodes and rejects them as gojwt does.
+func FuzzParseClaims(f *testing.F) {
+	keys, priv := testKeys(f)
+	for _, seed := range [][2]string{
+		{`{"alg":"EdDSA","kid":"k1","typ":"JWT"}`, `{"reef_id":"reef-1","colony_id":"colony-1","agent_id":"agent-1","intent":"register","iss":"coral-discovery","aud":["coral-colony"],"exp":4102444800,"iat":1700000000,"jti":"ticket-1"}`},
+		{`{"alg":"EdDSA","kid":"k1"}`, `{"AUD":"a","Exp":"4102444800","nbf":1.5,"ſub":"é\ud800"}`},
+		{`{"alg":"EdDSA","kid":"k1"}`, `{"exp":1,"nbf":4102444800,"aud":null,"iss":null}`},
+		{`{"alg":"EdDSA","kid":"k1"}`, `{"aud":["a",1],"exp":1e400}`},
+		{`{"alg":"EdDSA","kid":"k2"}`, `{}`},
+		{`{"alg":"ES256","kid":"k1"}`, `{}`},
+		{`{"alg":"none"}`, `{}`},
+		{`{"alg":5,"kid":["k1"]}`, `{}`},
+		{`null`, `null`},
+	} {
+		f.Add([]byte(seed[0]), []byte(seed[1]))
+	}
+	f.Fuzz(func(t *testing.T, header, payload []byte) {
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		token := signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, []byte(signed)))
+		compareParsers(t, keys, token)
+	})
+}
+
+// FuzzParseToken checks that the fast path rejects malformed compact
+// tokens as gojwt does.
+func FuzzParseToken(f *testing.F) {
+	keys, token := benchmarkTicket(f)
+	f.Add(token)
+	f.Add(token[:len(token)-1])
+	f.Add(token + "=")
+	f.Add("..")
+	f.Add("a.b.c.d")
+	f.Add("eyJhbGciOiJFZERTQSJ9.e30.")
+	f.Fuzz(func(t *testing.T, token string) {
+		compareParsers(t, keys, token)
+	})
+}
+
+// compareParsers parses token with the fast path and with gojwt and fails
+// t if they disagree.
+func compareParsers(t *testing.T, keys *Keys, token string) {
+	var fast, slow jwt.ReferralClaims
+	fastErr := ParseClaims(keys, token, nil, &fast)
+	slowErr := ParseClaims(keyFunc{keys}, token, nil, &slow)
+	if (fastErr == nil) != (slowErr == nil) {
+		t.Fatalf("fast path: %v, gojwt: %v", fastErr, slowErr)
+	}
+	for _, sentinel := range sentinels {
+		if errors.Is(fastErr, sentinel) != errors.Is(slowErr, sentinel) {
+			t.Fatalf("fast path: %v, gojwt: %v", fastErr, slowErr)
+		}
+	}
+	if fastErr == nil && !reflect.DeepEqual(fast, slow) {
+		t.Fatalf("fast path decoded %+v, gojwt %+v", fast, slow)
+	}
+}
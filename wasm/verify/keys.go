@@ -0,0 +1,159 @@
+package verify
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"sync"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jwk"
+)
+
+// Signature algorithms a ticket may be signed with.
+const (
+	EdDSA = "EdDSA"
+	ES256 = "ES256"
+	RS256 = "RS256"
+)
+
+// DefaultAlgorithms is the allowlist applied when Options.Algorithms is
+// empty: discovery itself only signs with Ed25519.
+var DefaultAlgorithms = []string{EdDSA}
+
+// key is a verification key and the JWK alg it is restricted to, if any.
+type key struct {
+	pub crypto.PublicKey
+	alg string
+}
+
+// Keys is a static set of Ed25519, P-256 and RSA verification keys parsed
+// from a JWKS document. Unlike *jwt.Validator it verifies ES256 and RS256
+// tickets as well as EdDSA; which of those a verifier accepts is set by
+// Options.Algorithms. It is safe for concurrent use.
+type Keys struct {
+	mu   sync.RWMutex
+	keys map[string]key
+}
+
+// ParseKeys parses a JWKS document. Keys of other types or curves, and
+// keys not meant for signatures, are skipped so one odd key does not
+// break the whole set; malformed keys of a supported type are an error.
+func ParseKeys(jwksJSON []byte) (*Keys, error) {
+	set, err := jwk.ParseSet(jwksJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS JSON: %w", err)
+	}
+	keys := make(map[string]key, len(set))
+	for _, k := range set {
+		if k.Use != "" && k.Use != "sig" || !supported(k) {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.KID, err)
+		}
+		keys[k.KID] = key{pub: pub, alg: k.Alg}
+	}
+	return &Keys{keys: keys}, nil
+}
+
+// supported reports whether k is an Ed25519, P-256 or RSA key.
+func supported(k jwk.Key) bool {
+	switch k.KTY {
+	case "OKP":
+		return k.CRV == "Ed25519"
+	case "EC":
+		return k.CRV == "P-256"
+	case "RSA":
+		return true
+	}
+	return false
+}
+
+// GetKeyFunc implements KeySource. The key named by the token's kid must
+// be of the type its alg header calls for and, when the JWK carries an
+// alg member, match it.
+func (k *Keys) GetKeyFunc() gojwt.Keyfunc {
+	return func(token *gojwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid in token header")
+		}
+		return k.resolve([]byte(kid), token.Method.Alg())
+	}
+}
+
+// resolve returns the key named kid if it can verify alg. It implements
+// resolver, so that Parse verifies tickets against k without gojwt.
+func (k *Keys) resolve(kid []byte, alg string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[string(kid)]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in JWKS", kid)
+	}
+
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.alg, alg)
+	}
+	switch pub := key.pub.(type) {
+	case ed25519.PublicKey:
+		ok = alg == EdDSA
+	case *ecdsa.PublicKey:
+		ok = alg == ES256 && pub.Curve == elliptic.P256()
+	case *rsa.PublicKey:
+		ok = alg == RS256
+	default:
+		ok = false
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %q cannot verify %s", kid, alg)
+	}
+	return key.pub, nil
+}
+
+// KeyIDs returns the kids of the parsed keys.
+func (k *Keys) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		ids = append(ids, kid)
+	}
+	return ids
+}
+
+// Replace verifies with the keys of other, such as a reread JWKS
+// document, in place of k's.
+func (k *Keys) Replace(other *Keys) {
+	other.mu.RLock()
+	keys := other.keys
+	other.mu.RUnlock()
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+// Ed25519Key resolves kid to an Ed25519 key through keys, for formats
+// other than JWT signed with the same keys, by presenting the key source
+// with the equivalent EdDSA header.
+func Ed25519Key(keys KeySource, kid string) (ed25519.PublicKey, error) {
+	token := &gojwt.Token{
+		Header: map[string]interface{}{"alg": EdDSA, "kid": kid},
+		Method: gojwt.SigningMethodEdDSA,
+	}
+	key, err := keys.GetKeyFunc()(token)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %q is not an Ed25519 key", kid)
+	}
+	return pub, nil
+}
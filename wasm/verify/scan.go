@@ -0,0 +1,63 @@
+package verify
+
+import (
+	"fmt"
+	"strconv"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/internal/jsonscan"
+)
+
+// audience reads a string or an array of strings into dst, leaving it
+// unchanged for null.
+func audience(s *jsonscan.Scanner, dst *gojwt.ClaimStrings) {
+	switch s.Peek() {
+	case 'n':
+		s.Literal("null")
+	case '[':
+		s.Expect('[')
+		var aud gojwt.ClaimStrings
+		for i := 0; s.More(i, ']'); i++ {
+			if s.Peek() != '"' {
+				s.Fail(gojwt.ErrInvalidType)
+				return
+			}
+			aud = append(aud, string(s.Str()))
+		}
+		if s.Err() == nil {
+			*dst = aud
+		}
+	default:
+		if v := s.Str(); s.Err() == nil {
+			*dst = gojwt.ClaimStrings{string(v)}
+		}
+	}
+}
+
+// date reads a NumericDate given as a number or a string holding one,
+// returning nil for null.
+func date(s *jsonscan.Scanner) *gojwt.NumericDate {
+	var raw []byte
+	switch s.Peek() {
+	case 'n':
+		s.Literal("null")
+		return nil
+	case '"':
+		raw = s.Str()
+		if s.Err() == nil && (len(raw) == 0 || jsonscan.ScanNumber(raw) != len(raw)) {
+			s.Fail(fmt.Errorf("invalid number literal %q", raw))
+		}
+	default:
+		raw = s.Number()
+	}
+	if s.Err() != nil {
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		s.Fail(err)
+		return nil
+	}
+	return numericDate(f)
+}
@@ -0,0 +1,213 @@
+// Package verify checks referral tickets against explicit expectations:
+// issuer, audience, signature algorithm, clock skew tolerance and maximum
+// lifetime, on a pluggable clock. It extends the signature-only
+// jwt.VerifySignatureStatic so a ticket minted for one environment cannot
+// verify in another that shares a key.
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+)
+
+var (
+	// ErrIssuer is returned when the iss claim is not expected.
+	ErrIssuer = errors.New("unexpected issuer")
+
+	// ErrAudience is returned when no aud value is expected.
+	ErrAudience = errors.New("unexpected audience")
+
+	// ErrLifetime is returned when a ticket lacks exp or iat, or lives
+	// longer than Options.MaxTTL.
+	ErrLifetime = errors.New("ticket lifetime exceeds limit")
+)
+
+// Options are the expectations a ticket must meet beyond its signature.
+type Options struct {
+	// Issuers lists accepted iss values. Empty accepts
+	// jwt.DefaultIssuer and jwt.LegacyIssuer.
+	Issuers []string
+
+	// Audiences lists accepted aud values; a ticket must carry at least
+	// one. Empty accepts jwt.DefaultAudience and jwt.LegacyAudience.
+	Audiences []string
+
+	// Algorithms lists accepted alg header values, any of EdDSA, ES256
+	// and RS256. Empty accepts DefaultAlgorithms.
+	Algorithms []string
+
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+
+	// NotBeforeLeeway, when longer than Leeway, is the skew tolerated on
+	// nbf and iat alone, so tickets from issuers whose clocks run ahead,
+	// such as drifting embedded devices, are not "not yet valid" while
+	// expiry stays strict.
+	NotBeforeLeeway time.Duration
+
+	// Clock is the time tickets are checked against. Defaults to
+	// clock.System.
+	Clock clock.Clock
+
+	// MaxTTL rejects tickets whose exp is more than MaxTTL after iat.
+	// Zero disables the check.
+	MaxTTL time.Duration
+}
+
+// KeySource resolves verification keys. *jwt.Validator and *Keys satisfy
+// it.
+type KeySource interface {
+	GetKeyFunc() gojwt.Keyfunc
+}
+
+// Parse verifies tokenString's signature and time claims with keys and
+// checks the remaining expectations in opts.
+func Parse(keys KeySource, tokenString string, opts Options) (*jwt.ReferralClaims, error) {
+	claims := &jwt.ReferralClaims{}
+	if err := ParseClaims(keys, tokenString, &opts, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	if err := opts.Check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseClaims verifies tokenString's signature and time claims with keys
+// as ParserOptions would, decoding its claims into claims, and leaves the
+// expectations of Check to the caller. With a nil opts only
+// DefaultAlgorithms are accepted, and exp and nbf are only checked when
+// present, as gojwt does by default.
+//
+// Tickets verified with *Keys are parsed without gojwt, allocating only
+// the claim values themselves; errors wrap the same gojwt sentinels.
+func ParseClaims(keys KeySource, tokenString string, opts *Options, claims *jwt.ReferralClaims) error {
+	if r, ok := keys.(resolver); ok {
+		return parseFast(r, tokenString, opts, claims)
+	}
+	parserOpts := []gojwt.ParserOption{gojwt.WithValidMethods(DefaultAlgorithms)}
+	if opts != nil {
+		parserOpts = opts.ParserOptions()
+	}
+	_, err := gojwt.ParseWithClaims(tokenString, claims, keys.GetKeyFunc(), parserOpts...)
+	return err
+}
+
+// ParserOptions returns the parser options checking a ticket's
+// algorithm against o's allowlist and its time claims with o's clock and
+// skew tolerances. Parsing with them admits the NotBeforeLeeway on exp as
+// well; Check enforces Leeway on it.
+func (o Options) ParserOptions() []gojwt.ParserOption {
+	return []gojwt.ParserOption{
+		gojwt.WithValidMethods(o.algorithms()),
+		gojwt.WithLeeway(max(o.Leeway, o.NotBeforeLeeway)),
+		gojwt.WithTimeFunc(clock.Or(o.Clock).Now),
+		gojwt.WithIssuedAt(),
+		gojwt.WithExpirationRequired(),
+	}
+}
+
+// AllowsAlgorithm reports whether alg is in o's algorithm allowlist.
+func (o Options) AllowsAlgorithm(alg string) bool {
+	return contains(o.algorithms(), alg)
+}
+
+func (o Options) algorithms() []string {
+	if len(o.Algorithms) == 0 {
+		return DefaultAlgorithms
+	}
+	return o.Algorithms
+}
+
+// Check applies the issuer, audience and lifetime expectations, and the
+// stricter expiry leeway, to already-verified claims.
+func (o Options) Check(claims *jwt.ReferralClaims) error {
+	if o.NotBeforeLeeway > o.Leeway && claims.ExpiresAt != nil &&
+		!clock.Or(o.Clock).Now().Before(claims.ExpiresAt.Add(o.Leeway)) {
+		return fmt.Errorf("%w: %w", gojwt.ErrTokenInvalidClaims, gojwt.ErrTokenExpired)
+	}
+
+	issuers := o.Issuers
+	if len(issuers) == 0 {
+		issuers = []string{jwt.DefaultIssuer, jwt.LegacyIssuer}
+	}
+	if !contains(issuers, claims.Issuer) {
+		return fmt.Errorf("%w: %q", ErrIssuer, claims.Issuer)
+	}
+
+	audiences := o.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{jwt.DefaultAudience, jwt.LegacyAudience}
+	}
+	matched := false
+	for _, aud := range claims.Audience {
+		if contains(audiences, aud) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("%w: %v", ErrAudience, claims.Audience)
+	}
+
+	if o.MaxTTL > 0 {
+		if claims.IssuedAt == nil || claims.ExpiresAt == nil {
+			return fmt.Errorf("%w: exp and iat are required", ErrLifetime)
+		}
+		if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl > o.MaxTTL {
+			return fmt.Errorf("%w: %s > %s", ErrLifetime, ttl, o.MaxTTL)
+		}
+	}
+	return nil
+}
+
+// Static verifies tokenString against a JWKS document with opts. It is the
+// option-aware counterpart of jwt.VerifySignatureStatic, and also accepts
+// P-256 and RSA keys (see ParseKeys): a bad signature, or an algorithm
+// outside opts.Algorithms, yields false with a nil error, any other
+// failure an error.
+func Static(tokenString, jwksJSON string, opts Options) (bool, error) {
+	keys, err := ParseKeys([]byte(jwksJSON))
+	if err != nil {
+		return false, err
+	}
+	if _, err := Parse(keys, tokenString, opts); err != nil {
+		if errors.Is(err, gojwt.ErrTokenSignatureInvalid) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Verifier validates referral tickets with fixed Options. It satisfies
+// registry.Verifier.
+type Verifier struct {
+	keys KeySource
+	opts Options
+}
+
+// New creates a Verifier resolving keys from keys.
+func New(keys KeySource, opts Options) *Verifier {
+	return &Verifier{keys: keys, opts: opts}
+}
+
+// ValidateReferralTicket implements registry.Verifier.
+func (v *Verifier) ValidateReferralTicket(tokenString string) (*jwt.ReferralClaims, error) {
+	return Parse(v.keys, tokenString, v.opts)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-crypto/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// keyFunc hides the resolver of Keys, so that tickets are parsed by gojwt.
+type keyFunc struct {
+	keys *Keys
+}
+
+func (k keyFunc) GetKeyFunc() gojwt.Keyfunc {
+	return k.keys.GetKeyFunc()
+}
+
+// testKeys returns a key set holding an Ed25519 key "k1" and its private
+// key.
+func testKeys(tb testing.TB) (*Keys, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keys, err := ParseKeys(fmt.Appendf(nil, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","use":"sig","x":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(pub)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return keys, priv
+}
+
+func benchmarkTicket(tb testing.TB) (*Keys, string) {
+	keys, priv := testKeys(tb)
+	now := time.Now()
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, jwt.ReferralClaims{
+		ReefID:   "reef-1",
+		ColonyID: "colony-1",
+		AgentID:  "agent-1",
+		Intent:   "register",
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    jwt.DefaultIssuer,
+			Audience:  gojwt.ClaimStrings{jwt.DefaultAudience},
+			ExpiresAt: gojwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ID:        "ticket-1",
+		},
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return keys, signed
+}
+
+func benchmarkParse(b *testing.B, keys KeySource, token string) {
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Parse(keys, token, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	keys, token := benchmarkTicket(b)
+	benchmarkParse(b, keys, token)
+}
+
+func BenchmarkParseKeyFunc(b *testing.B) {
+	keys, token := benchmarkTicket(b)
+	benchmarkParse(b, keyFunc{keys}, token)
+}
+
+func BenchmarkParseClaims(b *testing.B) {
+	keys, token := benchmarkTicket(b)
+	b.ReportAllocs()
+	var claims jwt.ReferralClaims
+	for b.Loop() {
+		claims = jwt.ReferralClaims{}
+		if err := ParseClaims(keys, token, nil, &claims); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseClaimsBatch(b *testing.B) {
+	keys, token := benchmarkTicket(b)
+	tickets := make([]Ticket, 64)
+	claims := make([]jwt.ReferralClaims, len(tickets))
+	b.ReportAllocs()
+	for b.Loop() {
+		for i := range tickets {
+			claims[i] = jwt.ReferralClaims{}
+			tickets[i] = Ticket{Keys: keys, Token: token, Claims: &claims[i]}
+		}
+		for _, err := range ParseClaimsBatch(tickets) {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+//go:build (tinygo.wasm || js) && !lite
+
+package main
+
+import (
+	"errors"
+	"syscall/js"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/verify"
+)
+
+// verifyOptionsFromJS reads { issuer, audience, algorithms,
+// clockSkewSeconds, notBeforeSkewSeconds, maxTTLSeconds } into
+// verify.Options. issuer, audience and algorithms may each be a string or
+// an array of strings. undefined or null yields nil, meaning the
+// token is checked for signature and time validity only.
+func verifyOptionsFromJS(v js.Value) (*verify.Options, error) {
+	if v.IsUndefined() || v.IsNull() {
+		return nil, nil
+	}
+	if v.Type() != js.TypeObject {
+		return nil, errors.New("options must be an object")
+	}
+
+	var opts verify.Options
+	var err error
+	if opts.Issuers, err = stringsFromJS(v.Get("issuer")); err != nil {
+		return nil, errors.New("options.issuer: " + err.Error())
+	}
+	if opts.Audiences, err = stringsFromJS(v.Get("audience")); err != nil {
+		return nil, errors.New("options.audience: " + err.Error())
+	}
+	if opts.Algorithms, err = stringsFromJS(v.Get("algorithms")); err != nil {
+		return nil, errors.New("options.algorithms: " + err.Error())
+	}
+	if skew := v.Get("clockSkewSeconds"); skew.Type() == js.TypeNumber {
+		opts.Leeway = time.Duration(skew.Float() * float64(time.Second))
+	}
+	if skew := v.Get("notBeforeSkewSeconds"); skew.Type() == js.TypeNumber {
+		opts.NotBeforeLeeway = time.Duration(skew.Float() * float64(time.Second))
+	}
+	if ttl := v.Get("maxTTLSeconds"); ttl.Type() == js.TypeNumber {
+		opts.MaxTTL = time.Duration(ttl.Float() * float64(time.Second))
+	}
+	return &opts, nil
+}
+
+// stringsFromJS accepts undefined, a string or an array of strings.
+func stringsFromJS(v js.Value) ([]string, error) {
+	switch v.Type() {
+	case js.TypeUndefined, js.TypeNull:
+		return nil, nil
+	case js.TypeString:
+		return []string{v.String()}, nil
+	case js.TypeObject:
+		if !js.Global().Get("Array").Call("isArray", v).Bool() {
+			return nil, errors.New("expected a string or an array of strings")
+		}
+		out := make([]string, v.Length())
+		for i := range out {
+			item := v.Index(i)
+			if item.Type() != js.TypeString {
+				return nil, errors.New("expected an array of strings")
+			}
+			out[i] = item.String()
+		}
+		return out, nil
+	default:
+		return nil, errors.New("expected a string or an array of strings")
+	}
+}
@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// deadPrefix is the store key prefix of dead letters; a dead letter lives
+// under deadPrefix + reef + "/" + ID.
+const deadPrefix = "webhooks/dead/"
+
+// ErrDeadLetterNotFound is returned for unknown or pruned dead letters.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is an event that could not be delivered to an endpoint.
+type DeadLetter struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	Event    *Event `json:"event"`
+
+	// ReefID is the reef of the event's agent or revoked ticket.
+	ReefID string `json:"reef_id"`
+
+	// Attempts is how many deliveries were made; 0 when the event never
+	// left the queue.
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// DeadLetters returns the dead letters of reefID, oldest first.
+func (d *Dispatcher) DeadLetters(ctx context.Context, reefID string) ([]*DeadLetter, error) {
+	entries, err := d.store.List(ctx, deadPrefix+reefID+"/")
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		var dl DeadLetter
+		if err := json.Unmarshal(entry.Value, &dl); err != nil {
+			return nil, fmt.Errorf("failed to decode dead letter %s: %w", entry.Key, err)
+		}
+		letters = append(letters, &dl)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+// Redeliver makes one more delivery of dead letter id of reefID, removing
+// it from the queue on success and recording the failure otherwise.
+func (d *Dispatcher) Redeliver(ctx context.Context, reefID, id string) (*DeadLetter, error) {
+	dl, rev, err := d.loadDeadLetter(ctx, reefID, id)
+	if err != nil {
+		return nil, err
+	}
+	e := d.endpoint(dl.Endpoint)
+	if e == nil {
+		return nil, fmt.Errorf("%w: endpoint %s is no longer configured", ErrInvalidEndpoint, dl.Endpoint)
+	}
+	if err := d.post(ctx, e, dl.Event); err != nil {
+		dl.Attempts++
+		dl.LastError, dl.FailedAt = err.Error(), d.clock.Now().UTC()
+		if err := d.saveDeadLetter(ctx, dl, rev); err != nil {
+			return nil, err
+		}
+		return dl, err
+	}
+	if err := d.store.Delete(ctx, deadLetterKey(reefID, id)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// Discard removes dead letter id of reefID without delivering it.
+func (d *Dispatcher) Discard(ctx context.Context, reefID, id string) error {
+	err := d.store.Delete(ctx, deadLetterKey(reefID, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	return err
+}
+
+// Prune deletes the dead letters of every reef older than the
+// dead-letter TTL and returns how many were removed.
+func (d *Dispatcher) Prune(ctx context.Context) (int, error) {
+	entries, err := d.store.List(ctx, deadPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := d.clock.Now().Add(-d.deadTTL)
+	pruned := 0
+	for _, entry := range entries {
+		var dl DeadLetter
+		if err := json.Unmarshal(entry.Value, &dl); err == nil && dl.FailedAt.After(cutoff) {
+			continue
+		}
+		if err := d.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RunPruner calls Prune every interval until ctx is done.
+func (d *Dispatcher) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = d.Prune(ctx)
+		}
+	}
+}
+
+// deadLetter queues ev, which failed attempts deliveries to e with err.
+func (d *Dispatcher) deadLetter(ctx context.Context, e *Endpoint, ev *Event, attempts int, err error) error {
+	dl := &DeadLetter{
+		ID:        uuid.New().String(),
+		Endpoint:  e.ID,
+		Event:     ev,
+		ReefID:    ev.reefID(),
+		Attempts:  attempts,
+		LastError: err.Error(),
+		FailedAt:  d.clock.Now().UTC(),
+	}
+	return d.saveDeadLetter(ctx, dl, 0)
+}
+
+// endpoint returns the endpoint with id, or nil.
+func (d *Dispatcher) endpoint(id string) *Endpoint {
+	for i := range d.endpoints {
+		if d.endpoints[i].ID == id {
+			return &d.endpoints[i]
+		}
+	}
+	return nil
+}
+
+// loadDeadLetter reads a dead letter of reefID and its revision.
+func (d *Dispatcher) loadDeadLetter(ctx context.Context, reefID, id string) (*DeadLetter, uint64, error) {
+	entry, err := d.store.Get(ctx, deadLetterKey(reefID, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var dl DeadLetter
+	if err := json.Unmarshal(entry.Value, &dl); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode dead letter %s: %w", id, err)
+	}
+	return &dl, entry.Revision, nil
+}
+
+// saveDeadLetter writes dl if its stored revision is still revision.
+func (d *Dispatcher) saveDeadLetter(ctx context.Context, dl *DeadLetter, revision uint64) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	_, err = d.store.CompareAndSwap(ctx, deadLetterKey(dl.ReefID, dl.ID), revision, data)
+	return err
+}
+
+// deadLetterKey returns the store key of dead letter id of reefID.
+func deadLetterKey(reefID, id string) string {
+	return deadPrefix + reefID + "/" + id
+}
@@ -0,0 +1,500 @@
+// Package webhook notifies downstream systems, such as inventories, of
+// mesh membership changes. A Dispatcher watches the registry and the
+// revocation list and POSTs each lifecycle event, signed, to the
+// endpoints subscribed to it. Failed deliveries are retried with
+// exponential backoff; events that exhaust their attempts are kept in a
+// dead-letter queue for operators to inspect and redeliver.
+//
+// Example endpoint document:
+//
+//	{
+//	  "endpoints": [
+//	    {"id": "inventory", "url": "https://inventory.example/hooks/coral",
+//	     "events": ["agent.registered", "agent.deregistered"], "reef_id": "prod",
+//	     "secret": "shared-hmac-secret"}
+//	  ]
+//	}
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/clock"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/revocation"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/store"
+)
+
+// Event types.
+const (
+	// AgentRegistered is sent when an agent registers for the first
+	// time. Re-registrations and lease renewals are not sent.
+	AgentRegistered = "agent.registered"
+
+	// AgentExpired is sent when an agent's lease ends without renewal,
+	// or is ended by an operator.
+	AgentExpired = "agent.expired"
+
+	// AgentDeregistered is sent when an agent is removed.
+	AgentDeregistered = "agent.deregistered"
+
+	// TicketRevoked is sent when a referral ticket is revoked.
+	TicketRevoked = "ticket.revoked"
+)
+
+// eventTypes are all event types.
+var eventTypes = []string{AgentRegistered, AgentExpired, AgentDeregistered, TicketRevoked}
+
+// Delivery headers. The signatures cover SignedContent of the timestamp
+// and body, so that receivers can reject replays of old deliveries.
+const (
+	// IDHeader carries the event ID, stable across retries.
+	IDHeader = "Coral-Webhook-ID"
+
+	// TimestampHeader carries the delivery time in Unix seconds.
+	TimestampHeader = "Coral-Webhook-Timestamp"
+
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// signed content under the endpoint's secret, when it has one.
+	SignatureHeader = "Coral-Webhook-Signature"
+
+	// Ed25519Header carries the base64 Ed25519 signature of the signed
+	// content by Config.SigningKey, when set.
+	Ed25519Header = "Coral-Webhook-Signature-Ed25519"
+)
+
+// Dispatcher defaults.
+const (
+	DefaultMaxAttempts   = 8
+	DefaultMinBackoff    = time.Second
+	DefaultMaxBackoff    = 5 * time.Minute
+	DefaultMaxQueue      = 1000
+	DefaultDeadLetterTTL = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidEndpoint is returned for endpoint documents with missing
+	// or unknown fields.
+	ErrInvalidEndpoint = errors.New("invalid webhook endpoint")
+
+	// ErrQueueFull is recorded on events dead-lettered because their
+	// endpoint's queue was full.
+	ErrQueueFull = errors.New("webhook queue is full")
+)
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Agent is the agent's record, for the agent events.
+	Agent *registry.Record `json:"agent,omitempty"`
+
+	// Revocation is the revoked ticket, for TicketRevoked.
+	Revocation *revocation.Revocation `json:"revocation,omitempty"`
+}
+
+// Endpoint is a webhook receiver and the events it subscribes to.
+type Endpoint struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Events are the event types sent; empty sends all of them.
+	Events []string `json:"events,omitempty"`
+
+	// ReefID and ColonyID, when set, restrict agent events to agents of
+	// that reef and colony. ReefID also restricts revocations to that
+	// reef's tickets.
+	ReefID   string `json:"reef_id,omitempty"`
+	ColonyID string `json:"colony_id,omitempty"`
+
+	// Secret, when set, signs deliveries in SignatureHeader.
+	Secret string `json:"secret,omitempty"`
+}
+
+// reefID returns the reef of ev's agent or revoked ticket.
+func (ev *Event) reefID() string {
+	switch {
+	case ev.Agent != nil:
+		return ev.Agent.ReefID
+	case ev.Revocation != nil:
+		return ev.Revocation.ReefID
+	}
+	return ""
+}
+
+// wants reports whether e subscribes to ev.
+func (e *Endpoint) wants(ev *Event) bool {
+	if len(e.Events) > 0 && !slices.Contains(e.Events, ev.Type) {
+		return false
+	}
+	if ev.Agent != nil {
+		if (e.ReefID != "" && ev.Agent.ReefID != e.ReefID) || (e.ColonyID != "" && ev.Agent.ColonyID != e.ColonyID) {
+			return false
+		}
+	}
+	if ev.Revocation != nil && e.ReefID != "" && ev.Revocation.ReefID != e.ReefID {
+		return false
+	}
+	return true
+}
+
+// endpointsDocument is the JSON form of a list of endpoints.
+type endpointsDocument struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// ParseEndpoints decodes and validates an endpoint document.
+func ParseEndpoints(data []byte) ([]Endpoint, error) {
+	var doc endpointsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("webhook: failed to parse endpoints: %w", err)
+	}
+	ids := make(map[string]bool, len(doc.Endpoints))
+	for i, e := range doc.Endpoints {
+		if e.ID == "" || ids[e.ID] {
+			return nil, fmt.Errorf("%w: endpoint %d: id is empty or repeated", ErrInvalidEndpoint, i)
+		}
+		ids[e.ID] = true
+		if e.URL == "" {
+			return nil, fmt.Errorf("%w: endpoint %s: url is required", ErrInvalidEndpoint, e.ID)
+		}
+		for _, typ := range e.Events {
+			if !slices.Contains(eventTypes, typ) {
+				return nil, fmt.Errorf("%w: endpoint %s: unknown event %q", ErrInvalidEndpoint, e.ID, typ)
+			}
+		}
+	}
+	return doc.Endpoints, nil
+}
+
+// Config holds the configuration for a Dispatcher.
+type Config struct {
+	// Endpoints receive the events they subscribe to. Required.
+	Endpoints []Endpoint
+
+	// Registry is watched for agent events when set.
+	Registry *registry.Registry
+
+	// Revocations is watched for TicketRevoked when set. Only
+	// revocations made through this instance are seen.
+	Revocations *revocation.List
+
+	// SigningKey, when set, signs every delivery in Ed25519Header.
+	SigningKey ed25519.PrivateKey
+
+	// Store keeps the dead-letter queue. Defaults to an in-memory store.
+	Store store.Store
+
+	// MaxAttempts bounds the deliveries of an event before it is
+	// dead-lettered. Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// attempts. Default to DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxQueue bounds the events awaiting delivery to each endpoint;
+	// events beyond it are dead-lettered. Defaults to DefaultMaxQueue.
+	MaxQueue int
+
+	// DeadLetterTTL is how long Prune keeps dead letters. Defaults to
+	// DefaultDeadLetterTTL.
+	DeadLetterTTL time.Duration
+
+	// HTTPClient reaches the endpoints. Defaults to a client with a 10s
+	// timeout.
+	HTTPClient *http.Client
+
+	// Clock stamps events and deliveries. Defaults to clock.System.
+	Clock clock.Clock
+}
+
+// Dispatcher delivers registry lifecycle events to webhook endpoints.
+// Each endpoint has its own queue, delivered in order by its own
+// worker, so that a slow receiver does not hold up the others.
+type Dispatcher struct {
+	endpoints   []Endpoint
+	registry    *registry.Registry
+	revocations *revocation.List
+	signingKey  ed25519.PrivateKey
+	store       store.Store
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	deadTTL     time.Duration
+	http        *http.Client
+	clock       clock.Clock
+	queues      map[string]chan *Event
+
+	mu      sync.Mutex
+	running bool
+}
+
+// New creates a Dispatcher from cfg.
+func New(cfg Config) (*Dispatcher, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("webhook: endpoints are required")
+	}
+	if cfg.Store == nil {
+		cfg.Store = store.NewMemory()
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = DefaultMaxQueue
+	}
+	if cfg.DeadLetterTTL <= 0 {
+		cfg.DeadLetterTTL = DefaultDeadLetterTTL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	d := &Dispatcher{
+		endpoints:   cfg.Endpoints,
+		registry:    cfg.Registry,
+		revocations: cfg.Revocations,
+		signingKey:  cfg.SigningKey,
+		store:       cfg.Store,
+		maxAttempts: cfg.MaxAttempts,
+		minBackoff:  cfg.MinBackoff,
+		maxBackoff:  cfg.MaxBackoff,
+		deadTTL:     cfg.DeadLetterTTL,
+		http:        cfg.HTTPClient,
+		clock:       clock.Or(cfg.Clock),
+		queues:      make(map[string]chan *Event, len(cfg.Endpoints)),
+	}
+	for _, e := range cfg.Endpoints {
+		d.queues[e.ID] = make(chan *Event, cfg.MaxQueue)
+	}
+	return d, nil
+}
+
+// Run watches the registry and revocation list and delivers their events
+// until ctx is done. Events still queued then are dead-lettered.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.mu.Lock()
+	if d.running {
+		d.mu.Unlock()
+		return
+	}
+	d.running = true
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range d.endpoints {
+		e := &d.endpoints[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx, e)
+		}()
+	}
+	if d.registry != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.watchRegistry(ctx)
+		}()
+	}
+	if d.revocations != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.watchRevocations(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Publish queues ev for the endpoints subscribed to it, filling in its
+// ID and time when empty.
+func (d *Dispatcher) Publish(ev *Event) {
+	if ev.ID == "" {
+		ev.ID = uuid.New().String()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = d.clock.Now().UTC()
+	}
+	for i := range d.endpoints {
+		e := &d.endpoints[i]
+		if !e.wants(ev) {
+			continue
+		}
+		select {
+		case d.queues[e.ID] <- ev:
+		default:
+			_ = d.deadLetter(context.Background(), e, ev, 0, ErrQueueFull)
+		}
+	}
+}
+
+// watchRegistry publishes agent events.
+func (d *Dispatcher) watchRegistry(ctx context.Context) {
+	d.registry.Follow(ctx, registry.Filter{}, func(ev registry.Event) {
+		if typ := agentEvent(ev); typ != "" {
+			d.Publish(&Event{Type: typ, Agent: ev.Record})
+		}
+	})
+}
+
+// agentEvent returns the event type of a registry event, or "" for
+// those not sent.
+func agentEvent(ev registry.Event) string {
+	switch ev.Type {
+	case registry.EventPut:
+		// Only first registrations leave the creation time unchanged.
+		if ev.Record.CreatedAt.Equal(ev.Record.UpdatedAt) {
+			return AgentRegistered
+		}
+	case registry.EventExpire:
+		return AgentExpired
+	case registry.EventDelete:
+		return AgentDeregistered
+	}
+	return ""
+}
+
+// watchRevocations publishes TicketRevoked events.
+func (d *Dispatcher) watchRevocations(ctx context.Context) {
+	for ctx.Err() == nil {
+		for rev := range d.revocations.Watch(ctx) {
+			d.Publish(&Event{Type: TicketRevoked, Revocation: &rev})
+		}
+	}
+}
+
+// work delivers the queue of e until ctx is done.
+func (d *Dispatcher) work(ctx context.Context, e *Endpoint) {
+	queue := d.queues[e.ID]
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain(e)
+			return
+		case ev := <-queue:
+			d.deliver(ctx, e, ev)
+		}
+	}
+}
+
+// deliver sends ev to e, retrying with backoff, and dead-letters it when
+// its attempts are exhausted or ctx ends first.
+func (d *Dispatcher) deliver(ctx context.Context, e *Endpoint, ev *Event) {
+	backoff := d.minBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = d.post(ctx, e, ev); err == nil {
+			return
+		}
+		if attempt == d.maxAttempts {
+			_ = d.deadLetter(context.Background(), e, ev, attempt, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			_ = d.deadLetter(context.Background(), e, ev, attempt, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, d.maxBackoff)
+	}
+}
+
+// drain dead-letters the events left in e's queue at shutdown.
+func (d *Dispatcher) drain(e *Endpoint) {
+	for {
+		select {
+		case ev := <-d.queues[e.ID]:
+			_ = d.deadLetter(context.Background(), e, ev, 0, context.Canceled)
+		default:
+			return
+		}
+	}
+}
+
+// post makes one delivery of ev to e.
+func (d *Dispatcher) post(ctx context.Context, e *Endpoint, ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := d.clock.Now().Unix()
+	content := SignedContent(ts, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, ev.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	if e.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(sign(e.Secret, content)))
+	}
+	if d.signingKey != nil {
+		req.Header.Set(Ed25519Header, base64.StdEncoding.EncodeToString(ed25519.Sign(d.signingKey, content)))
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// SignedContent returns the bytes a delivery's signatures cover: the
+// TimestampHeader value, a period, and the body.
+func SignedContent(timestamp int64, body []byte) []byte {
+	return append([]byte(strconv.FormatInt(timestamp, 10)+"."), body...)
+}
+
+// VerifyHMAC reports whether signature, a SignatureHeader value, signs
+// the delivery of body at timestamp under secret.
+func VerifyHMAC(secret string, timestamp int64, body []byte, signature string) bool {
+	want := "sha256=" + hex.EncodeToString(sign(secret, SignedContent(timestamp, body)))
+	return hmac.Equal([]byte(signature), []byte(want))
+}
+
+// VerifyEd25519 reports whether signature, an Ed25519Header value, signs
+// the delivery of body at timestamp by pub.
+func VerifyEd25519(pub ed25519.PublicKey, timestamp int64, body []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, SignedContent(timestamp, body), sig)
+}
+
+func sign(secret string, content []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(content)
+	return mac.Sum(nil)
+}
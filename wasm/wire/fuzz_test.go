@@ -0,0 +1,68 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// FuzzRecord decodes fuzzed record JSON in each version and checks that
+// records passing Validate survive the lease arithmetic of the registry
+// and encode back to the same record in every version.
+func FuzzRecord(f *testing.F) {
+	for _, seed := range []struct {
+		version int
+		data    string
+	}{
+		{V1, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["10.0.0.1:9000"],"capabilities":["service=api/1.2.3"],"ttl_seconds":60,"created_at":"2025-01-01T00:00:00Z","updated_at":"2025-01-01T00:00:00+01:00","expires_at":"2025-01-01T00:01:00Z","location":{"country":"de","continent":"eu","colo":"fra","latitude":50.1,"longitude":8.7},"rtt_ms":{"b":12},"load":{"utilization":0.5,"sessions":3},"publishes":["metrics"],"subscribes":["alerts"]}`},
+		{V2, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["10.0.0.1:9000"],"lease":{"ttl_seconds":60,"created_at":"2025-01-01T00:00:00Z"},"topics":{"publishes":["metrics"]},"version":{"wall":1,"logical":2,"node":"n"}}`},
+		{V2, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["e"],"lease":null,"topics":null,"location":null,"load":null}`},
+		{V1, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["e"],"ttl_seconds":9223372036854775807}`},
+		{V1, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["e"],"capabilities":["service=api/x.y"]}`},
+		{V1, `{"agent_id":"a","colony_id":"c","reef_id":"r","endpoints":["e"],"load":{"utilization":1e309}}`},
+		{V2, `{"endpoints":null}`},
+		{V1, `null`},
+	} {
+		f.Add(seed.version, []byte(seed.data))
+	}
+	f.Fuzz(func(t *testing.T, version int, data []byte) {
+		var rec registry.Record
+		if err := Unmarshal(data, version, &rec); err != nil || rec.Validate() != nil {
+			return
+		}
+
+		now := time.Now()
+		if ttl := time.Duration(rec.TTLSeconds) * time.Second; int64(ttl/time.Second) != int64(rec.TTLSeconds) {
+			t.Fatalf("ttl_seconds %d overflows a time.Duration", rec.TTLSeconds)
+		}
+		clone := rec.Clone()
+		if clone.Fingerprint() != rec.Fingerprint() {
+			t.Fatal("clone has another fingerprint")
+		}
+		registry.Health(&rec, now)
+		registry.Nearest([]*registry.Record{clone}, &rec, 1)
+
+		for v := Oldest; v <= Current; v++ {
+			want, err := Marshal(&rec, v)
+			if err != nil {
+				t.Fatalf("version %d: %v", v, err)
+			}
+			var decoded registry.Record
+			if err := Unmarshal(want, v, &decoded); err != nil {
+				t.Fatalf("version %d: %v", v, err)
+			}
+			if err := decoded.Validate(); err != nil {
+				t.Fatalf("version %d: decoded record is invalid: %v", v, err)
+			}
+			got, err := Marshal(&decoded, v)
+			if err != nil {
+				t.Fatalf("version %d: %v", v, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("version %d: encoded %s, then %s", v, want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,303 @@
+// Package wire versions the JSON encoding of registry payloads so that
+// the shape of agent records can evolve without breaking deployed agents.
+//
+// Version 1 is the flat encoding of registry.Record that predates
+// versioning. Version 2, the current one, groups the lease fields under
+// "lease" and the pub/sub topics under "topics". Callers list the
+// versions they decode, by preference, in the Accept-Version header, and
+// bodies name theirs in Content-Version; bodies without one are version
+// 1. Each side decodes the current version and the one before it, so
+// servers and agents can be upgraded one release at a time.
+package wire
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/history"
+	"github.com/coral-mesh/coral-discovery-workers/wasm/registry"
+)
+
+// Headers negotiating the schema version.
+const (
+	AcceptHeader  = "Accept-Version"
+	ContentHeader = "Content-Version"
+)
+
+// Schema versions. Oldest is the oldest version still decoded.
+const (
+	V1      = 1
+	V2      = 2
+	Current = V2
+	Oldest  = V1
+)
+
+// Accept is the Accept-Version value of callers decoding every supported
+// version, preferring the current one.
+const Accept = "2, 1"
+
+// ErrUnsupported is returned for schema versions outside Oldest to
+// Current.
+var ErrUnsupported = errors.New("unsupported schema version")
+
+// Supported reports whether version is decoded.
+func Supported(version int) bool {
+	return version >= Oldest && version <= Current
+}
+
+// Negotiate returns the version to encode a response in for accept, an
+// Accept-Version header: the first version it lists that is supported.
+// Callers sending none predate versioning and get version 1.
+func Negotiate(accept string) (int, error) {
+	if strings.TrimSpace(accept) == "" {
+		return V1, nil
+	}
+	for _, s := range strings.Split(accept, ",") {
+		if v, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && Supported(v) {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: accepted %q, supported %q", ErrUnsupported, accept, Accept)
+}
+
+// Parse parses a Content-Version header; an empty one is version 1.
+func Parse(s string) (int, error) {
+	if s == "" {
+		return V1, nil
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || !Supported(v) {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupported, s)
+	}
+	return v, nil
+}
+
+// Encode returns v as it is to be marshaled in version. Records, pages
+// of records, deltas and history pages change shape between versions;
+// other values are returned as they are.
+func Encode(v any, version int) any {
+	if version != V2 {
+		return v
+	}
+	switch v := v.(type) {
+	case *registry.Record:
+		return toV2(v)
+	case []*registry.Record:
+		return listToV2(v)
+	case *registry.Page:
+		if v == nil {
+			return v
+		}
+		return &pageV2{Records: listToV2(v.Records), NextCursor: v.NextCursor}
+	case *registry.Delta:
+		if v == nil {
+			return v
+		}
+		return &deltaV2{Records: listToV2(v.Records), Tombstones: v.Tombstones, Through: v.Through}
+	case *history.Page:
+		if v == nil {
+			return v
+		}
+		p := &historyPageV2{Entries: make([]*entryV2, len(v.Entries)), NextCursor: v.NextCursor}
+		for i, e := range v.Entries {
+			p.Entries[i] = &entryV2{ID: e.ID, Time: e.Time, Type: e.Type, Record: toV2(e.Record)}
+		}
+		return p
+	}
+	return v
+}
+
+// Marshal returns the JSON encoding of v in version.
+func Marshal(v any, version int) ([]byte, error) {
+	return json.Marshal(Encode(v, version))
+}
+
+// Unmarshal decodes data, encoded in version, into v. Like Encode, it
+// tells the versions apart for records, pages of records, deltas and
+// history pages.
+func Unmarshal(data []byte, version int, v any) error {
+	if !Supported(version) {
+		return fmt.Errorf("%w: %d", ErrUnsupported, version)
+	}
+	if version != V2 {
+		return json.Unmarshal(data, v)
+	}
+	switch v := v.(type) {
+	case *registry.Record:
+		var r recordV2
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		*v = *r.record()
+	case *[]*registry.Record:
+		var recs []*recordV2
+		if err := json.Unmarshal(data, &recs); err != nil {
+			return err
+		}
+		*v = listFromV2(recs)
+	case *registry.Page:
+		var p pageV2
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*v = registry.Page{Records: listFromV2(p.Records), NextCursor: p.NextCursor}
+	case *registry.Delta:
+		var d deltaV2
+		if err := json.Unmarshal(data, &d); err != nil {
+			return err
+		}
+		*v = registry.Delta{Records: listFromV2(d.Records), Tombstones: d.Tombstones, Through: d.Through}
+	case *history.Page:
+		var p historyPageV2
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*v = history.Page{Entries: make([]*history.Entry, len(p.Entries)), NextCursor: p.NextCursor}
+		for i, e := range p.Entries {
+			v.Entries[i] = &history.Entry{ID: e.ID, Time: e.Time, Type: e.Type, Record: e.Record.record()}
+		}
+	default:
+		return json.Unmarshal(data, v)
+	}
+	return nil
+}
+
+// recordV2 is the version 2 encoding of registry.Record.
+type recordV2 struct {
+	AgentID           string             `json:"agent_id"`
+	ColonyID          string             `json:"colony_id"`
+	ReefID            string             `json:"reef_id"`
+	Endpoints         []string           `json:"endpoints"`
+	ObservedEndpoints []string           `json:"observed_endpoints,omitempty"`
+	Capabilities      []string           `json:"capabilities,omitempty"`
+	Lease             leaseV2            `json:"lease"`
+	Topics            *topicsV2          `json:"topics,omitempty"`
+	Location          *registry.Location `json:"location,omitempty"`
+	RTT               map[string]int     `json:"rtt_ms,omitempty"`
+	Load              *registry.Load     `json:"load,omitempty"`
+	Metadata          json.RawMessage    `json:"metadata,omitempty"`
+	Envelope          *registry.Envelope `json:"envelope,omitempty"`
+	Seq               uint64             `json:"seq,omitempty"`
+	Version           registry.Timestamp `json:"version,omitzero"`
+}
+
+type leaseV2 struct {
+	TTLSeconds int       `json:"ttl_seconds"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type topicsV2 struct {
+	Publishes  []string `json:"publishes,omitempty"`
+	Subscribes []string `json:"subscribes,omitempty"`
+}
+
+type pageV2 struct {
+	Records    []*recordV2 `json:"agents"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type deltaV2 struct {
+	Records    []*recordV2           `json:"records"`
+	Tombstones []*registry.Tombstone `json:"tombstones"`
+	Through    registry.Timestamp    `json:"through"`
+}
+
+type entryV2 struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Record *recordV2 `json:"record"`
+}
+
+type historyPageV2 struct {
+	Entries    []*entryV2 `json:"entries"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+func toV2(rec *registry.Record) *recordV2 {
+	if rec == nil {
+		return nil
+	}
+	r := &recordV2{
+		AgentID:           rec.AgentID,
+		ColonyID:          rec.ColonyID,
+		ReefID:            rec.ReefID,
+		Endpoints:         rec.Endpoints,
+		ObservedEndpoints: rec.ObservedEndpoints,
+		Capabilities:      rec.Capabilities,
+		Lease: leaseV2{
+			TTLSeconds: rec.TTLSeconds,
+			CreatedAt:  rec.CreatedAt,
+			UpdatedAt:  rec.UpdatedAt,
+			ExpiresAt:  rec.ExpiresAt,
+		},
+		Location: rec.Location,
+		RTT:      rec.RTT,
+		Load:     rec.Load,
+		Metadata: rec.Metadata,
+		Envelope: rec.Envelope,
+		Seq:      rec.Seq,
+		Version:  rec.Version,
+	}
+	if len(rec.Publishes) > 0 || len(rec.Subscribes) > 0 {
+		r.Topics = &topicsV2{Publishes: rec.Publishes, Subscribes: rec.Subscribes}
+	}
+	return r
+}
+
+func (r *recordV2) record() *registry.Record {
+	if r == nil {
+		return nil
+	}
+	rec := &registry.Record{
+		AgentID:           r.AgentID,
+		ColonyID:          r.ColonyID,
+		ReefID:            r.ReefID,
+		Endpoints:         r.Endpoints,
+		Capabilities:      r.Capabilities,
+		TTLSeconds:        r.Lease.TTLSeconds,
+		CreatedAt:         r.Lease.CreatedAt,
+		UpdatedAt:         r.Lease.UpdatedAt,
+		ExpiresAt:         r.Lease.ExpiresAt,
+		ObservedEndpoints: r.ObservedEndpoints,
+		Location:          r.Location,
+		RTT:               r.RTT,
+		Load:              r.Load,
+		Metadata:          r.Metadata,
+		Envelope:          r.Envelope,
+		Seq:               r.Seq,
+		Version:           r.Version,
+	}
+	if r.Topics != nil {
+		rec.Publishes, rec.Subscribes = r.Topics.Publishes, r.Topics.Subscribes
+	}
+	return rec
+}
+
+func listToV2(recs []*registry.Record) []*recordV2 {
+	if recs == nil {
+		return nil
+	}
+	out := make([]*recordV2, len(recs))
+	for i, rec := range recs {
+		out[i] = toV2(rec)
+	}
+	return out
+}
+
+func listFromV2(recs []*recordV2) []*registry.Record {
+	if recs == nil {
+		return nil
+	}
+	out := make([]*registry.Record, len(recs))
+	for i, r := range recs {
+		out[i] = r.record()
+	}
+	return out
+}
@@ -0,0 +1,158 @@
+// Package x25519 provides X25519 key agreement for agents found through
+// the registry. Agents identify with Ed25519 keys; those keys convert to
+// X25519 so two agents can derive a shared channel secret from each
+// other's published key without exchanging anything else.
+package x25519
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// DefaultInfo is the HKDF info used by SharedSecret when info is empty.
+const DefaultInfo = "coral-channel-v1"
+
+// ErrInvalidKey is returned for keys of the wrong size or that are not
+// valid curve points.
+var ErrInvalidKey = errors.New("invalid key")
+
+// p is the field prime 2^255 - 19.
+var p = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// GenerateKey generates a new X25519 private key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// PrivateKeyFromEd25519 converts an Ed25519 private key to the X25519 key
+// with the same secret scalar (RFC 8032 section 5.1.5).
+func PrivateKeyFromEd25519(key ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: Ed25519 private key must be %d bytes", ErrInvalidKey, ed25519.PrivateKeySize)
+	}
+	h := sha512.Sum512(key.Seed())
+	// X25519 clamps the scalar itself, so the raw hash prefix is enough.
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// PublicKeyFromEd25519 converts an Ed25519 public key to its X25519
+// (Montgomery) form: u = (1 + y) / (1 - y) mod p.
+func PublicKeyFromEd25519(key ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: Ed25519 public key must be %d bytes", ErrInvalidKey, ed25519.PublicKeySize)
+	}
+
+	// The encoding is y in little-endian with the sign of x in the top bit.
+	le := bytes.Clone(key)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, fmt.Errorf("%w: non-canonical Ed25519 public key", ErrInvalidKey)
+	}
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("%w: Ed25519 identity point", ErrInvalidKey)
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, p))
+	u.Mod(u, p)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+// SharedSecret performs X25519 with priv and peer and expands the result
+// with HKDF-SHA256 into size bytes. Both public keys, in sorted order,
+// form the salt, so either side of a channel derives the same secret.
+// An empty info uses DefaultInfo.
+func SharedSecret(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, info string, size int) ([]byte, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	a, b := priv.PublicKey().Bytes(), peer.Bytes()
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	if info == "" {
+		info = DefaultInfo
+	}
+	return hkdf.Key(sha256.New, shared, append(bytes.Clone(a), b...), info, size)
+}
+
+// SharedSecretEd25519 derives a shared secret directly from an agent's
+// Ed25519 private key and a peer's Ed25519 public key.
+func SharedSecretEd25519(priv ed25519.PrivateKey, peer ed25519.PublicKey, info string, size int) ([]byte, error) {
+	xpriv, err := PrivateKeyFromEd25519(priv)
+	if err != nil {
+		return nil, err
+	}
+	xpeer, err := PublicKeyFromEd25519(peer)
+	if err != nil {
+		return nil, err
+	}
+	return SharedSecret(xpriv, xpeer, info, size)
+}
+
+// EncodePrivateKey encodes an X25519 private key as standard base64.
+func EncodePrivateKey(key *ecdh.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// DecodePrivateKey decodes a base64 X25519 private key.
+func DecodePrivateKey(encoded string) (*ecdh.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
+
+// EncodePublicKey encodes an X25519 public key as standard base64.
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// DecodePublicKey decodes a base64 X25519 public key.
+func DecodePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
+
+// reverse returns b with its bytes in reverse order.
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
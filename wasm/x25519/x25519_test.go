@@ -0,0 +1,129 @@
+package x25519_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/coral-mesh/coral-discovery-workers/wasm/x25519"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// TestFromEd25519 checks that the public key converted from Ed25519 is
+// the one of the converted private key, so peers need only each other's
+// published Ed25519 keys.
+func TestFromEd25519(t *testing.T) {
+	for range 16 {
+		pub, priv := newKey(t)
+		xpriv, err := x25519.PrivateKeyFromEd25519(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpub, err := x25519.PublicKeyFromEd25519(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xpub.Equal(xpriv.PublicKey()) {
+			t.Fatalf("converted public key %x, want %x", xpub.Bytes(), xpriv.PublicKey().Bytes())
+		}
+	}
+}
+
+func TestSharedSecret(t *testing.T) {
+	alicePub, alice := newKey(t)
+	bobPub, bob := newKey(t)
+	ab, err := x25519.SharedSecretEd25519(alice, bobPub, "", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ba, err := x25519.SharedSecretEd25519(bob, alicePub, "", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ab, ba) || len(ab) != 32 {
+		t.Fatalf("secrets differ: %x, %x", ab, ba)
+	}
+
+	other, err := x25519.SharedSecretEd25519(alice, bobPub, "other", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ab, other) {
+		t.Fatal("secret does not depend on info")
+	}
+	carolPub, _ := newKey(t)
+	ac, err := x25519.SharedSecretEd25519(alice, carolPub, "", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ab, ac) {
+		t.Fatal("secret does not depend on the peer")
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	identity := make(ed25519.PublicKey, ed25519.PublicKeySize)
+	identity[0] = 1
+	nonCanonical := bytes.Repeat([]byte{0xff}, ed25519.PublicKeySize)
+	nonCanonical[31] = 0x7f
+	for name, key := range map[string]ed25519.PublicKey{
+		"short":         make(ed25519.PublicKey, 31),
+		"identity":      identity,
+		"non-canonical": nonCanonical,
+	} {
+		if _, err := x25519.PublicKeyFromEd25519(key); !errors.Is(err, x25519.ErrInvalidKey) {
+			t.Errorf("%s: %v, want ErrInvalidKey", name, err)
+		}
+	}
+	if _, err := x25519.PrivateKeyFromEd25519(make(ed25519.PrivateKey, 32)); !errors.Is(err, x25519.ErrInvalidKey) {
+		t.Errorf("seed as private key: %v, want ErrInvalidKey", err)
+	}
+
+	// X25519 with a low-order peer yields the all-zero secret, which is
+	// refused.
+	priv, err := x25519.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero, err := x25519.DecodePublicKey("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x25519.SharedSecret(priv, zero, "", 32); !errors.Is(err, x25519.ErrInvalidKey) {
+		t.Errorf("low-order peer: %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	priv, err := x25519.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := x25519.DecodePrivateKey(x25519.EncodePrivateKey(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(priv) {
+		t.Fatal("private key changed by encoding")
+	}
+	pub, err := x25519.DecodePublicKey(x25519.EncodePublicKey(priv.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(priv.PublicKey()) {
+		t.Fatal("public key changed by encoding")
+	}
+	if _, err := x25519.DecodePublicKey("AAAA"); !errors.Is(err, x25519.ErrInvalidKey) {
+		t.Fatalf("3-byte key: %v, want ErrInvalidKey", err)
+	}
+}